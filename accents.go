@@ -0,0 +1,41 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// IgnoreAccents returns true if the diacritics of the queries and the
+// lines are ignored. See filter.WithIgnoreAccents
+func (p *Peco) IgnoreAccents() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.ignoreAccents
+}
+
+func (p *Peco) SetIgnoreAccents(b bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.ignoreAccents = b
+}
+
+// doToggleIgnoreAccents switches between ignoring the diacritics and
+// matching them as they are, and runs the current query again
+func doToggleIgnoreAccents(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleIgnoreAccents")
+		defer g.End()
+	}
+
+	b := !state.IgnoreAccents()
+	state.SetIgnoreAccents(b)
+	if b {
+		state.Hub().SendStatusMsgAndClear("Ignore accents", time.Second)
+	} else {
+		state.Hub().SendStatusMsgAndClear("Match accents", time.Second)
+	}
+	state.ExecQuery()
+}
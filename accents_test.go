@@ -0,0 +1,57 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToggleIgnoreAccents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--query", "cafe"}
+	p.Stdin = bytes.NewBufferString("café\ncafe\ntea\n")
+	p.config.IncrementalFilter = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached while waiting for %v, got %v", expected, bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	if !waitLines([]string{"cafe"}) {
+		return
+	}
+
+	// The results of the query that was run with the accents must not
+	// be reused
+	doToggleIgnoreAccents(ctx, p, termbox.Event{})
+	if !assert.True(t, p.IgnoreAccents(), "accents should be ignored") {
+		return
+	}
+	if !waitLines([]string{"café", "cafe"}) {
+		return
+	}
+
+	doToggleIgnoreAccents(ctx, p, termbox.Event{})
+	if !assert.False(t, p.IgnoreAccents(), "accents should be matched") {
+		return
+	}
+	waitLines([]string{"cafe"})
+}
@@ -2,35 +2,60 @@ package peco
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"context"
 
 	"github.com/google/btree"
 	"github.com/lestrrat/go-pdebug"
 	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/clipboard"
 	"github.com/peco/peco/internal/keyseq"
 	"github.com/peco/peco/internal/util"
 	"github.com/peco/peco/line"
 	"github.com/pkg/errors"
 )
 
+// writeClipboard is used by the CopyToClipboard and CopyQueryToClipboard
+// actions to write to the system clipboard. Tests may replace it
+var writeClipboard = clipboard.Write
+
+// actionFactories maps the names of actions that take arguments to the
+// functions that create them from the arguments. See CustomActionConfig
+var actionFactories = map[string]func(json.RawMessage) (Action, error){
+	"peco.ExecuteCommand":       newExecuteCommand,
+	"peco.ExecuteWithSelection": newExecuteWithSelection,
+	"peco.SetQuery":             newSetQuery,
+}
+
 // This is the global map of canonical action name to actions
 var nameToActions map[string]Action
 
 // This is the default keybinding used by NewKeymap()
 var defaultKeyBinding map[string]Action
 
+// defaultKeyNames maps the keys in defaultKeyBinding to the names of
+// the actions that they are bound to
+var defaultKeyNames map[string]string
+
+// deprecatedActions holds the names of the actions that are only kept
+// for compatibility, which peco.ShowActionPalette does not list
+var deprecatedActions = map[string]bool{}
+
 // Execute fulfills the Action interface for AfterFunc
 func (a ActionFunc) Execute(ctx context.Context, state *Peco, e termbox.Event) {
 	a(ctx, state, e)
 }
 
-func (a ActionFunc) registerKeySequence(k keyseq.KeyList) {
+func (a ActionFunc) registerKeySequence(name string, k keyseq.KeyList) {
 	defaultKeyBinding[k.String()] = a
+	defaultKeyNames[k.String()] = name
 }
 
 // Register fulfills the Action interface for AfterFunc. Registers `a`
@@ -39,7 +64,7 @@ func (a ActionFunc) registerKeySequence(k keyseq.KeyList) {
 func (a ActionFunc) Register(name string, defaultKeys ...termbox.Key) {
 	nameToActions["peco."+name] = a
 	for _, k := range defaultKeys {
-		a.registerKeySequence(keyseq.KeyList{keyseq.NewKeyFromKey(k)})
+		a.registerKeySequence("peco."+name, keyseq.KeyList{keyseq.NewKeyFromKey(k)})
 	}
 }
 
@@ -47,10 +72,11 @@ func (a ActionFunc) Register(name string, defaultKeys ...termbox.Key) {
 // Registers the action to be mapped against a key sequence
 func (a ActionFunc) RegisterKeySequence(name string, k keyseq.KeyList) {
 	nameToActions["peco."+name] = a
-	a.registerKeySequence(k)
+	a.registerKeySequence("peco."+name, k)
 }
 
 func wrapDeprecated(fn func(context.Context, *Peco, termbox.Event), oldName, newName string) ActionFunc {
+	deprecatedActions["peco."+oldName] = true
 	return ActionFunc(func(ctx context.Context, state *Peco, e termbox.Event) {
 		state.Hub().SendStatusMsg(fmt.Sprintf("%s is deprecated. Use %s", oldName, newName))
 		fn(ctx, state, e)
@@ -61,13 +87,29 @@ func init() {
 	// Build the global maps
 	nameToActions = map[string]Action{}
 	defaultKeyBinding = map[string]Action{}
+	defaultKeyNames = map[string]string{}
 
 	ActionFunc(doInvertSelection).Register("InvertSelection")
+	ActionFunc(doAddMatchesToSelection).Register("AddMatchesToSelection")
+	ActionFunc(doSetMark).Register("SetMark")
+	ActionFunc(doSelectToMark).Register("SelectToMark")
+	ActionFunc(doNextSelection).Register("NextSelection")
+	ActionFunc(doPrevSelection).Register("PrevSelection")
+	ActionFunc(doNextGroup).Register("NextGroup")
+	ActionFunc(doPrevGroup).Register("PrevGroup")
 	ActionFunc(doBeginningOfLine).Register("BeginningOfLine", termbox.KeyCtrlA)
 	ActionFunc(doBackwardChar).Register("BackwardChar", termbox.KeyCtrlB)
-	ActionFunc(doBackwardWord).Register("BackwardWord")
+	ActionFunc(doBackwardWord).RegisterKeySequence(
+		"BackwardWord",
+		keyseq.KeyList{keyseq.Key{Modifier: keyseq.ModAlt, Key: 0, Ch: 'b'}},
+	)
 	ActionFunc(doCancel).Register("Cancel", termbox.KeyCtrlC, termbox.KeyEsc)
+	ActionFunc(doCopyToClipboard).Register("CopyToClipboard")
+	ActionFunc(doCopyQueryToClipboard).Register("CopyQueryToClipboard")
 	ActionFunc(doDeleteAll).Register("DeleteAll")
+	ActionFunc(doClearQuery).Register("ClearQuery")
+	ActionFunc(doCompleteCommonPrefix).Register("CompleteCommonPrefix")
+	ActionFunc(doRestoreQuery).Register("RestoreQuery")
 	ActionFunc(doDeleteBackwardChar).Register(
 		"DeleteBackwardChar",
 		termbox.KeyBackspace,
@@ -79,14 +121,23 @@ func init() {
 	)
 	ActionFunc(doDeleteForwardChar).Register("DeleteForwardChar", termbox.KeyCtrlD)
 	ActionFunc(doDeleteForwardWord).Register("DeleteForwardWord")
+	ActionFunc(doTransposeChars).Register("TransposeChars")
 	ActionFunc(doEndOfFile).Register("EndOfFile")
 	ActionFunc(doEndOfLine).Register("EndOfLine", termbox.KeyCtrlE)
 	ActionFunc(doFinish).Register("Finish", termbox.KeyEnter)
+	ActionFunc(doAcceptAll).Register("AcceptAll")
+	ActionFunc(doEmitAndContinue).Register("EmitAndContinue")
 	ActionFunc(doForwardChar).Register("ForwardChar", termbox.KeyCtrlF)
-	ActionFunc(doForwardWord).Register("ForwardWord")
+	ActionFunc(doForwardWord).RegisterKeySequence(
+		"ForwardWord",
+		keyseq.KeyList{keyseq.Key{Modifier: keyseq.ModAlt, Key: 0, Ch: 'f'}},
+	)
 	ActionFunc(doKillEndOfLine).Register("KillEndOfLine", termbox.KeyCtrlK)
+	ActionFunc(doPreviousQueryFromHistory).Register("PreviousQueryFromHistory")
+	ActionFunc(doNextQueryFromHistory).Register("NextQueryFromHistory")
 	ActionFunc(doKillBeginningOfLine).Register("KillBeginningOfLine", termbox.KeyCtrlU)
 	ActionFunc(doRotateFilter).Register("RotateFilter", termbox.KeyCtrlR)
+	ActionFunc(doRotateFilterReverse).Register("RotateFilterReverse")
 	wrapDeprecated(doRotateFilter, "RotateMatcher", "RotateFilter").Register("RotateMatcher")
 	ActionFunc(doBackToInitialFilter).Register("BackToInitialFilter")
 
@@ -104,17 +155,31 @@ func init() {
 
 	ActionFunc(doScrollLeft).Register("ScrollLeft")
 	ActionFunc(doScrollRight).Register("ScrollRight")
+	ActionFunc(doScrollFirstColumn).Register("ScrollFirstColumn")
+	ActionFunc(doScrollViewUp).Register("ScrollViewUp")
+	ActionFunc(doScrollViewDown).Register("ScrollViewDown")
+	ActionFunc(doToLineTop).Register("ToLineTop")
+	ActionFunc(doToLineBottom).Register("ToLineBottom")
+	ActionFunc(doToMiddle).Register("ToMiddle")
 
 	ActionFunc(doToggleSelection).Register("ToggleSelection")
 	ActionFunc(doToggleSelectionAndSelectNext).Register(
 		"ToggleSelectionAndSelectNext",
 		termbox.KeyCtrlSpace,
 	)
+	// These move the cursor in the direction they say, regardless of
+	// the layout, but stop at the ends of the list instead of wrapping
+	// around like peco.SelectDown and peco.SelectUp
+	makeCombinedAction(ActionFunc(doToggleSelection), ActionFunc(doMoveDownWithoutWrapping)).Register("ToggleSelectionAndDown")
+	makeCombinedAction(ActionFunc(doToggleSelection), ActionFunc(doMoveUpWithoutWrapping)).Register("ToggleSelectionAndUp")
 	ActionFunc(doSelectNone).Register(
 		"SelectNone",
 		termbox.KeyCtrlG,
 	)
+	ActionFunc(doClearSelection).Register("ClearSelection")
 	ActionFunc(doSelectAll).Register("SelectAll")
+	ActionFunc(doPinCurrent).Register("PinCurrent")
+	ActionFunc(doUnpinCurrent).Register("UnpinCurrent")
 	ActionFunc(doSelectVisible).Register("SelectVisible")
 	wrapDeprecated(doToggleRangeMode, "ToggleSelectMode", "ToggleRangeMode").Register("ToggleSelectMode")
 	wrapDeprecated(doCancelRangeMode, "CancelSelectMode", "CancelRangeMode").Register("CancelSelectMode")
@@ -122,7 +187,26 @@ func init() {
 	ActionFunc(doCancelRangeMode).Register("CancelRangeMode")
 	ActionFunc(doToggleQuery).Register("ToggleQuery", termbox.KeyCtrlT)
 	ActionFunc(doRefreshScreen).Register("RefreshScreen", termbox.KeyCtrlL)
+	ActionFunc(doNothing).Register("Noop")
 	ActionFunc(doToggleSingleKeyJump).Register("ToggleSingleKeyJump")
+	ActionFunc(doToggleMouse).Register("ToggleMouse")
+	ActionFunc(doToggleSort).Register("ToggleSort")
+	ActionFunc(doToggleWholeWord).Register("ToggleWholeWord")
+	ActionFunc(doToggleFixedString).Register("ToggleFixedString")
+	ActionFunc(doToggleIgnoreAccents).Register("ToggleIgnoreAccents")
+	ActionFunc(doToggleFuzzyAnchor).Register("ToggleFuzzyAnchor")
+	ActionFunc(doToggleSelectionMode).Register("ToggleSelectionMode")
+	ActionFunc(doScrollPreviewUp).Register("ScrollPreviewUp")
+	ActionFunc(doScrollPreviewDown).Register("ScrollPreviewDown")
+	ActionFunc(doTogglePreview).Register("TogglePreview")
+	ActionFunc(doToggleLineWrap).Register("ToggleLineWrap")
+	ActionFunc(doOpenInEditor).Register("OpenInEditor")
+	ActionFunc(doRefineByLine).Register("RefineByLine")
+	ActionFunc(doPopRefinement).Register("PopRefinement")
+	ActionFunc(doSelectField).Register("SelectField")
+	ActionFunc(doRepeatLastAction).Register("RepeatLastAction")
+	ActionFunc(doShowActionPalette).Register("ShowActionPalette")
+	ActionFunc(doQuickSelect).Register("QuickSelect")
 
 	ActionFunc(doKonamiCommand).RegisterKeySequence(
 		"KonamiCommand",
@@ -157,6 +241,16 @@ func doAcceptChar(ctx context.Context, state *Peco, e termbox.Event) {
 		return
 	}
 
+	if state.FieldSelectMode() {
+		doSelectFieldKey(ctx, state, e)
+		return
+	}
+
+	if labels, _ := state.QuickSelectLabels(); labels != nil {
+		doQuickSelectKey(ctx, state, e)
+		return
+	}
+
 	if state.SingleKeyJumpMode() {
 		doSingleKeyJump(ctx, state, e)
 		return
@@ -182,6 +276,23 @@ func doRotateFilter(ctx context.Context, state *Peco, e termbox.Event) {
 
 	filters := state.Filters()
 	filters.Rotate()
+	resetQueryOnFilterChange(state)
+
+	if state.ExecQuery() {
+		return
+	}
+	state.Hub().SendDrawPrompt()
+}
+
+func doRotateFilterReverse(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doRotateFilterReverse")
+		defer g.End()
+	}
+
+	filters := state.Filters()
+	filters.RotateReverse()
+	resetQueryOnFilterChange(state)
 
 	if state.ExecQuery() {
 		return
@@ -197,6 +308,7 @@ func doBackToInitialFilter(ctx context.Context, state *Peco, e termbox.Event) {
 
 	filters := state.Filters()
 	filters.Reset()
+	resetQueryOnFilterChange(state)
 
 	if state.ExecQuery() {
 		return
@@ -214,13 +326,29 @@ func doToggleSelection(ctx context.Context, state *Peco, _ termbox.Event) {
 	if err != nil {
 		return
 	}
+	if state.skipsGroupHeaders() && state.isGroupHeader(l) {
+		return
+	}
 
 	selection := state.Selection()
 	if selection.Has(l) {
 		selection.Remove(l)
 		return
 	}
-	selection.Add(l)
+	if !selection.Add(l) {
+		notifySelectionFull(state)
+	}
+}
+
+// notifySelectionFull tells the user that lines were not selected
+// because MaxSelection lines are selected already, or because peco is
+// in single selection mode
+func notifySelectionFull(state *Peco) {
+	if state.SingleSelection() {
+		state.ShowMessage("Lines cannot be selected in single selection mode", 2*time.Second, MessageWarning)
+		return
+	}
+	state.ShowMessage(fmt.Sprintf("Cannot select more than %d lines", state.Selection().Limit()), 2*time.Second, MessageWarning)
 }
 
 func doToggleRangeMode(ctx context.Context, state *Peco, _ termbox.Event) {
@@ -230,13 +358,17 @@ func doToggleRangeMode(ctx context.Context, state *Peco, _ termbox.Event) {
 	}
 
 	r := state.SelectionRangeStart()
+	if !r.Valid() && state.SingleSelection() {
+		notifySelectionFull(state)
+		return
+	}
 	if r.Valid() {
 		r.Reset()
 	} else {
 		cl := state.Location().LineNumber()
 		r.SetValue(cl)
-		if l, err := state.CurrentLineBuffer().LineAt(cl); err == nil {
-			state.selection.Add(l)
+		if l, err := state.CurrentLineBuffer().LineAt(cl); err == nil && !state.selection.Add(l) {
+			notifySelectionFull(state)
 		}
 	}
 }
@@ -250,17 +382,47 @@ func doSelectNone(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
 }
 
+// doClearSelection deselects all the lines like peco.SelectNone,
+// including the pinned lines, and tells how many were deselected. The
+// cursor and the query are left as they are
+func doClearSelection(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doClearSelection")
+		defer g.End()
+	}
+
+	// Range mode would select the lines between the start of the range
+	// and the cursor again as soon as the cursor moves
+	state.SelectionRangeStart().Reset()
+
+	selection := state.Selection()
+	n := selection.Len()
+	if n == 0 {
+		state.Hub().SendStatusMsgAndClear("No lines are selected", time.Second)
+		return
+	}
+	selection.Reset()
+	state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Cleared %d selected line(s)", n), time.Second)
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
 func doSelectAll(ctx context.Context, state *Peco, _ termbox.Event) {
 	selection := state.Selection()
 	b := state.CurrentLineBuffer()
+	full := false
 	for x := 0; x < b.Size(); x++ {
 		if l, err := b.LineAt(x); err == nil {
 			l.SetDirty(true)
-			selection.Add(l)
+			if !selection.Add(l) {
+				full = true
+			}
 		} else {
 			selection.Remove(l)
 		}
 	}
+	if full {
+		notifySelectionFull(state)
+	}
 	state.Hub().SendDraw(nil)
 }
 
@@ -275,17 +437,137 @@ func doSelectVisible(ctx context.Context, state *Peco, _ termbox.Event) {
 	loc := state.Location()
 	pc := loc.PageCrop()
 	lb := pc.Crop(b)
+	full := false
 	for x := 0; x < lb.Size(); x++ {
 		l, err := lb.LineAt(x)
 		if err != nil {
 			continue
 		}
 		l.SetDirty(true)
-		selection.Add(l)
+		if !selection.Add(l) {
+			full = true
+		}
+	}
+	if full {
+		notifySelectionFull(state)
 	}
 	state.Hub().SendDraw(nil)
 }
 
+func doSetMark(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doSetMark")
+		defer g.End()
+	}
+
+	l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber())
+	if err != nil {
+		return
+	}
+	state.SetMark(l)
+	state.Hub().SendStatusMsgAndClear("Mark set", time.Second)
+}
+
+// doSelectToMark selects the lines between the line marked by
+// peco.SetMark and the current line, in the order they are displayed
+func doSelectToMark(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doSelectToMark")
+		defer g.End()
+	}
+
+	mark := state.Mark()
+	if mark == nil {
+		state.Hub().SendStatusMsgAndClear("No mark set", 2*time.Second)
+		return
+	}
+
+	b := state.CurrentLineBuffer()
+	start := -1
+	for x := 0; x < b.Size(); x++ {
+		if l, err := b.LineAt(x); err == nil && l.ID() == mark.ID() {
+			start = x
+			break
+		}
+	}
+	if start < 0 {
+		state.Hub().SendStatusMsgAndClear("Marked line is not in the current results", 2*time.Second)
+		return
+	}
+
+	end := state.Location().LineNumber()
+	if end < start {
+		start, end = end, start
+	}
+
+	selection := state.Selection()
+	full := false
+	for x := start; x <= end; x++ {
+		if l, err := b.LineAt(x); err == nil {
+			l.SetDirty(true)
+			if !selection.Add(l) {
+				full = true
+			}
+		}
+	}
+	if full {
+		notifySelectionFull(state)
+	}
+	state.Hub().SendDraw(nil)
+}
+
+// doNextSelection moves the cursor to the next selected line, in the
+// order they are displayed. See jumpToSelection
+func doNextSelection(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doNextSelection")
+		defer g.End()
+	}
+	jumpToSelection(state, 1)
+}
+
+// doPrevSelection moves the cursor to the previous selected line, in
+// the order they are displayed. See jumpToSelection
+func doPrevSelection(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doPrevSelection")
+		defer g.End()
+	}
+	jumpToSelection(state, -1)
+}
+
+// jumpToSelection moves the cursor to the closest selected line in the
+// current line buffer, looking in the given direction from the current
+// line and wrapping around at either end. The cursor does not move if
+// none of the lines in the current line buffer are selected
+func jumpToSelection(state *Peco, dir int) {
+	b := state.CurrentLineBuffer()
+	selection := state.Selection()
+	if selection.Len() == 0 {
+		state.Hub().SendStatusMsgAndClear("No lines selected", time.Second)
+		return
+	}
+
+	size := b.Size()
+	loc := state.Location()
+	current := loc.LineNumber()
+	for i := 1; i <= size; i++ {
+		n := ((current+dir*i)%size + size) % size
+		l, err := b.LineAt(n)
+		if err != nil || !selection.Has(l) {
+			continue
+		}
+		if n == current {
+			// The current line is the only one selected
+			return
+		}
+		loc.SetLineNumber(n)
+		state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+		return
+	}
+	state.Hub().SendStatusMsgAndClear("No selected lines in the current results", time.Second)
+}
+
 type errCollectResults struct{}
 
 func (err errCollectResults) Error() string {
@@ -294,31 +576,345 @@ func (err errCollectResults) Error() string {
 func (err errCollectResults) CollectResults() bool {
 	return true
 }
+
+// selectionOrCurrentLine returns a copy of the selection. If nothing
+// is selected, or peco is in single selection mode, the returned
+// selection contains the current line
+func selectionOrCurrentLine(state *Peco) *Selection {
+	sel := NewSelection()
+	if !state.SingleSelection() {
+		state.Selection().Copy(sel)
+	}
+	if sel.Len() == 0 {
+		if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
+			sel.Add(l)
+		}
+	}
+	return sel
+}
+
+func doCopyToClipboard(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doCopyToClipboard")
+		defer g.End()
+	}
+
+	sel := selectionOrCurrentLine(state)
+	if sel.Len() == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	sel.Ascend(func(it btree.Item) bool {
+		if buf.Len() > 0 {
+			buf.WriteString(state.resultDelimiter())
+		}
+		buf.WriteString(it.(line.Line).Output())
+		return true
+	})
+
+	if err := writeClipboard(buf.String()); err != nil {
+		state.ShowMessage("Failed to copy to clipboard: "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+	state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Copied %d line(s) to clipboard", sel.Len()), time.Second)
+}
+
+// doCopyQueryToClipboard copies the query as it is typed, rather than
+// the lines that it matches, to the system clipboard
+func doCopyQueryToClipboard(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doCopyQueryToClipboard")
+		defer g.End()
+	}
+
+	q := state.Query().String()
+	if q == "" {
+		return
+	}
+
+	if err := writeClipboard(q); err != nil {
+		state.ShowMessage("Failed to copy to clipboard: "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+	state.Hub().SendStatusMsgAndClear("Copied the query to clipboard", time.Second)
+}
+
+// newExecuteCommand creates an action that pipes the selected lines, or
+// the current line, to a shell command
+func newExecuteCommand(buf json.RawMessage) (Action, error) {
+	var args ExecuteCommandArgs
+	if err := json.Unmarshal(buf, &args); err != nil {
+		return nil, errors.Wrap(err, "failed to decode arguments")
+	}
+
+	if args.Cmd == "" {
+		return nil, errors.New("Cmd must be specified")
+	}
+
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if !state.startExecuting() {
+			state.Hub().SendStatusMsgAndClear("Busy: "+args.Cmd+" is still running", time.Second)
+			return
+		}
+
+		// The lines are those selected when the key was pressed, even
+		// if the selection changes while the command runs
+		sel := selectionOrCurrentLine(state)
+		go func() {
+			defer state.doneExecuting()
+			doExecuteCommand(ctx, state, args, sel)
+		}()
+	}), nil
+}
+
+func doExecuteCommand(ctx context.Context, state *Peco, args ExecuteCommandArgs, sel *Selection) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doExecuteCommand %s (%d lines)", args.Cmd, sel.Len())
+		defer g.End()
+	}
+
+	var stdin, stdout, stderr bytes.Buffer
+	sel.Ascend(func(it btree.Item) bool {
+		stdin.WriteString(it.(line.Line).Buffer())
+		stdin.WriteByte(state.outputDelimiter())
+		return true
+	})
+
+	state.Hub().SendStatusMsg("Executing " + args.Cmd)
+	cmd := util.Shell(args.Cmd)
+	cmd.Stdin = &stdin
+	cmd.Stderr = &stderr
+	if args.Replace {
+		cmd.Stdout = &stdout
+	}
+
+	if err := cmd.Run(); err != nil {
+		state.ShowMessage("Failed to execute "+args.Cmd+": "+commandFailure(err, &stderr), 5*time.Second, MessageError)
+		return
+	}
+	state.Hub().SendStatusMsg("")
+
+	if !args.Replace {
+		return
+	}
+
+	// The output of the command becomes the new input, so anything
+	// that refers to the old lines is no longer valid
+	src := NewSource(args.Cmd, &stdout, state.idgen, state.bufferSize, state.enableSep)
+	go src.Setup(ctx, state)
+	select {
+	case <-ctx.Done():
+		return
+	case <-src.SetupDone():
+	}
+
+	// The query is run against the new lines once they replace the
+	// old ones
+	if state.swapSource(ctx, src, nil) && state.queriesSource() {
+		state.ExecQuery()
+	}
+}
+
+// queriesSource returns true if the lines displayed for the source
+// come from running the query, rather than from the source as it is
+func (p *Peco) queriesSource() bool {
+	return p.Query().Len() > 0 || len(p.MultiQuery()) > 0 || p.processesSource()
+}
+
+// swapSource has the view replace the input with src, and waits for it
+// to be done, see replaceSource. cancel stops reading the input into
+// src. The action palette is closed if it is shown, as it would
+// otherwise bring back the old input once closed. Returns false if ctx
+// is canceled first
+func (p *Peco) swapSource(ctx context.Context, src *Source, cancel func()) bool {
+	if p.ActionPaletteShown() {
+		closeActionPalette(p)
+	}
+
+	// The view replaces the source, as it moves the cursor to the
+	// first line
+	applied := make(chan struct{})
+	p.Hub().SendPaging(replaceSourceRequest{src: src, cancel: cancel, applied: applied})
+	select {
+	case <-ctx.Done():
+		return false
+	case <-applied:
+		return true
+	}
+}
+
+// replaceSource replaces the input with the source of r. The old input
+// is no longer read, and what refers to its lines is no longer valid:
+// the selection and the refinements are dropped, and the new source is
+// displayed as it is until the query is run against it. The old source
+// is released once nothing uses its lines anymore
+func (p *Peco) replaceSource(r replaceSourceRequest) {
+	p.mutex.Lock()
+	old := p.source
+	prev := p.cancelSource
+	p.source = r.src
+	p.cancelSource = r.cancel
+	p.refinements = nil
+	p.mutex.Unlock()
+
+	if prev != nil {
+		prev()
+	}
+	if old != nil && old != r.src {
+		p.retireSource(old)
+	}
+	p.Selection().Reset()
+	p.ResetCurrentLineBuffer()
+	close(r.applied)
+}
+
+// commandFailure describes why a command failed, using the first line
+// of its error output if there is one
+func commandFailure(err error, stderr *bytes.Buffer) string {
+	msg := strings.TrimSpace(stderr.String())
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = msg[:i]
+	}
+	if msg == "" {
+		msg = err.Error()
+	}
+	return msg
+}
+
+// newExecuteWithSelection creates an action that runs a shell command
+// with the selected lines, or the current line, in the background. peco
+// keeps running, and only one such command may run at a time
+func newExecuteWithSelection(buf json.RawMessage) (Action, error) {
+	var args ExecuteWithSelectionArgs
+	if err := json.Unmarshal(buf, &args); err != nil {
+		return nil, errors.Wrap(err, "failed to decode arguments")
+	}
+
+	if args.Cmd == "" {
+		return nil, errors.New("Cmd must be specified")
+	}
+
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if !state.startExecuting() {
+			state.Hub().SendStatusMsgAndClear("Busy: "+args.Cmd+" is still running", time.Second)
+			return
+		}
+
+		// The lines are those selected when the key was pressed, even
+		// if the selection changes while the command runs
+		sel := selectionOrCurrentLine(state)
+		go func() {
+			defer state.doneExecuting()
+			doExecuteWithSelection(ctx, state, args, sel)
+		}()
+	}), nil
+}
+
+func doExecuteWithSelection(ctx context.Context, state *Peco, args ExecuteWithSelectionArgs, sel *Selection) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doExecuteWithSelection %s (%d lines)", args.Cmd, sel.Len())
+		defer g.End()
+	}
+
+	if sel.Len() == 0 {
+		state.Hub().SendStatusMsgAndClear("No lines to execute "+args.Cmd+" with", time.Second)
+		return
+	}
+
+	cmdline := args.Cmd
+	var stdin, stderr bytes.Buffer
+	sel.Ascend(func(it btree.Item) bool {
+		l := it.(line.Line)
+		if args.Args {
+			cmdline += " " + util.ShellQuote(l.Buffer())
+		} else {
+			stdin.WriteString(l.Buffer())
+			stdin.WriteByte(state.outputDelimiter())
+		}
+		return true
+	})
+
+	state.Hub().SendStatusMsg("Executing " + args.Cmd)
+	cmd := util.Shell(cmdline)
+	cmd.Stdin = &stdin
+	cmd.Stderr = &stderr
+	cmd.Env = state.commandEnv()
+	if err := cmd.Start(); err != nil {
+		state.ShowMessage("Failed to execute "+args.Cmd+": "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return
+	case err := <-done:
+		if err != nil {
+			state.ShowMessage("Failed to execute "+args.Cmd+": "+commandFailure(err, &stderr), 5*time.Second, MessageError)
+			return
+		}
+	}
+	state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Executed %s with %d line(s)", args.Cmd, sel.Len()), time.Second)
+}
+
 func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("doFinish")
 		defer g.End()
 	}
+	if state.ActionPaletteShown() {
+		executeActionPalette(ctx, state)
+		return
+	}
+	finish(state, false)
+}
+
+// doAcceptAll accepts all the lines that matched the query, without
+// having to select them first
+func doAcceptAll(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doAcceptAll")
+		defer g.End()
+	}
+	if state.ActionPaletteShown() {
+		executeActionPalette(ctx, state)
+		return
+	}
+	finish(state, true)
+}
+
+// finish exits peco with the results, or passes them to the command
+// given with --exec. If all is true, the results are all the current
+// lines instead of the selection
+func finish(state *Peco, all bool) {
+	if h := state.history; h != nil {
+		if err := h.Append(state.Query().String()); err != nil && pdebug.Enabled {
+			pdebug.Printf("failed to save query to history: %s", err)
+		}
+	}
+
+	if state.noMatch(all) && state.handleNoMatch() {
+		return
+	}
 
 	ccarg := state.execOnFinish
 	if len(ccarg) == 0 {
+		state.acceptAll = all
+		state.setExitHook(state.config.OnFinishCommand)
 		state.Exit(errCollectResults{})
 		return
 	}
 
-	sel := NewSelection()
-	state.Selection().Copy(sel)
-	if sel.Len() == 0 {
-		if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
-			sel.Add(l)
-		}
-	}
+	res := state.results(all)
 
 	var stdin bytes.Buffer
-	sel.Ascend(func(it btree.Item) bool {
-		line := it.(line.Line)
-		stdin.WriteString(line.Buffer())
-		stdin.WriteRune('\n')
+	res.each(func(l line.Line) bool {
+		stdin.WriteString(l.Buffer())
+		stdin.WriteByte(state.outputDelimiter())
 		return true
 	})
 
@@ -328,27 +924,11 @@ func doFinish(ctx context.Context, state *Peco, _ termbox.Event) {
 	cmd.Stdin = &stdin
 	cmd.Stdout = state.Stdout
 	cmd.Stderr = state.Stderr
-	// Setup some enviroment variables. Start with a copy of the current
-	// environment...
-	env := os.Environ()
-
-	// Add some PECO specific ones...
-	// PECO_QUERY: current query value
-	// PECO_FILENAME: input file name, if any. "-" for stdin
-	// PECO_LINE_COUNT: number of lines in the original input
+	// Setup some enviroment variables. On top of those set by commandEnv,
 	// PECO_MATCHED_LINE_COUNT: number of lines matched (number of lines being
 	//     sent to stdin of the command being executed)
-
-	if s, ok := state.Source().(*Source); ok {
-		env = append(env,
-			`PECO_FILENAME=`+s.Name(),
-			`PECO_LINE_COUNT=`+strconv.Itoa(s.Size()),
-		)
-	}
-
-	env = append(env,
-		`PECO_QUERY=`+state.Query().String(),
-		`PECO_MATCHED_LINE_COUNT=`+strconv.Itoa(sel.Len()),
+	env := append(state.commandEnv(),
+		`PECO_MATCHED_LINE_COUNT=`+strconv.Itoa(res.Len()),
 	)
 	cmd.Env = env
 
@@ -376,11 +956,32 @@ func doCancel(ctx context.Context, state *Peco, e termbox.Event) {
 		return
 	}
 
-	// peco.Cancel -> end program, exit with failure
+	if state.FieldSelectMode() {
+		doSelectFieldKey(ctx, state, e)
+		return
+	}
+
+	if labels, _ := state.QuickSelectLabels(); labels != nil {
+		endQuickSelect(state)
+		return
+	}
+
+	if state.ActionPaletteShown() {
+		closeActionPalette(state)
+		return
+	}
+
+	exitCanceled(state)
+}
+
+// exitCanceled ends the program as canceled by the user, which is a
+// failure unless --on-cancel success was given
+func exitCanceled(state *Peco) {
 	err := makeIgnorable(errors.New("user canceled"))
-	if state.onCancel == errorKey {
-		err = setExitStatus(err, 1)
+	if state.onCancel != successKey {
+		err = setExitStatus(err, ExitStatusNoSelection)
 	}
+	state.setExitHook(state.config.OnCancelCommand)
 	state.Exit(err)
 }
 
@@ -400,6 +1001,27 @@ func doSelectUp(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ToLineAbove)
 }
 
+// moveWithoutWrapping moves the cursor to the line in the direction of
+// req, unless the cursor is on the line at that end of the list, from
+// where it would wrap around to the other end
+func moveWithoutWrapping(state *Peco, req PagingRequestType) {
+	// The lines are indexed from the bottom in the bottom-up layout
+	forward := (req == ToLineBelow) == (state.LayoutType() != LayoutTypeBottomUp)
+	n := state.Location().LineNumber()
+	if (forward && n >= state.CurrentLineBuffer().Size()-1) || (!forward && n <= 0) {
+		return
+	}
+	state.Hub().SendPaging(req)
+}
+
+func doMoveDownWithoutWrapping(ctx context.Context, state *Peco, e termbox.Event) {
+	moveWithoutWrapping(state, ToLineBelow)
+}
+
+func doMoveUpWithoutWrapping(ctx context.Context, state *Peco, e termbox.Event) {
+	moveWithoutWrapping(state, ToLineAbove)
+}
+
 func doScrollPageUp(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ToScrollPageUp)
 }
@@ -408,6 +1030,26 @@ func doScrollPageDown(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ToScrollPageDown)
 }
 
+func doScrollViewUp(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ToScrollViewUp)
+}
+
+func doScrollViewDown(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ToScrollViewDown)
+}
+
+func doToLineTop(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ToFirstLine)
+}
+
+func doToLineBottom(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ToLastLine)
+}
+
+func doToMiddle(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ToMiddleLine)
+}
+
 func doScrollLeft(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ToScrollLeft)
 }
@@ -416,6 +1058,10 @@ func doScrollRight(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendPaging(ToScrollRight)
 }
 
+func doScrollFirstColumn(ctx context.Context, state *Peco, e termbox.Event) {
+	state.Hub().SendPaging(ToScrollFirstColumn)
+}
+
 func doToggleSelectionAndSelectNext(ctx context.Context, state *Peco, e termbox.Event) {
 	toplevel, _ := ctx.Value(isTopLevelActionCall).(bool)
 	state.Hub().Batch(func() {
@@ -430,143 +1076,144 @@ func doToggleSelectionAndSelectNext(ctx context.Context, state *Peco, e termbox.
 	}, toplevel)
 }
 
+// doInvertSelection toggles the selection of the lines in the current
+// line buffer. Lines that are not displayed because of the query keep
+// their selection state
 func doInvertSelection(ctx context.Context, state *Peco, _ termbox.Event) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("doInvertSelection")
 		defer g.End()
 	}
 
+	// Range mode would select the lines between the start of the range
+	// and the cursor again as soon as the cursor moves
+	state.SelectionRangeStart().Reset()
+
 	selection := state.Selection()
 	b := state.CurrentLineBuffer()
 
+	full := false
 	for x := 0; x < b.Size(); x++ {
-		if l, err := b.LineAt(x); err == nil {
-			l.SetDirty(true)
-			if selection.Has(l) {
-				selection.Remove(l)
-			} else {
-				selection.Add(l)
-			}
-		} else {
+		l, err := b.LineAt(x)
+		if err != nil {
+			continue
+		}
+		l.SetDirty(true)
+		if selection.Has(l) {
 			selection.Remove(l)
+		} else if !selection.Add(l) {
+			full = true
 		}
 	}
+	if full {
+		notifySelectionFull(state)
+	}
 
 	state.Hub().SendDraw(nil)
 }
 
-func doDeleteBackwardWord(ctx context.Context, state *Peco, _ termbox.Event) {
+// doAddMatchesToSelection adds the lines in the current results to the
+// selection, so that the selection can be built up across several
+// queries. Lines that are already selected stay selected
+func doAddMatchesToSelection(ctx context.Context, state *Peco, _ termbox.Event) {
 	if pdebug.Enabled {
-		g := pdebug.Marker("doDeleteBackwardWord")
+		g := pdebug.Marker("doAddMatchesToSelection")
 		defer g.End()
 	}
 
-	c := state.Caret()
-	if c.Pos() == 0 {
-		return
+	selection := state.Selection()
+	b := state.CurrentLineBuffer()
+
+	added := 0
+	full := false
+	for x := 0; x < b.Size(); x++ {
+		l, err := b.LineAt(x)
+		if err != nil || selection.Has(l) {
+			continue
+		}
+		if !selection.Add(l) {
+			full = true
+			break
+		}
+		l.SetDirty(true)
+		added++
 	}
 
-	q := state.Query()
-	pos := q.Len()
-	if l := q.Len(); l <= c.Pos() {
-		pos = l
+	if full {
+		notifySelectionFull(state)
+	} else {
+		state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Added %d line(s) to the selection", added), time.Second)
 	}
+	state.Hub().SendDraw(nil)
+}
 
-	sepFunc := unicode.IsSpace
-	if unicode.IsSpace(q.RuneAt(pos - 1)) {
-		sepFunc = func(r rune) bool { return !unicode.IsSpace(r) }
+func doDeleteBackwardWord(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doDeleteBackwardWord")
+		defer g.End()
 	}
 
-	found := false
-	start := pos
-	for pos = start - 1; pos >= 0; pos-- {
-		if sepFunc(q.RuneAt(pos)) {
-			q.DeleteRange(pos+1, start)
-			c.SetPos(pos + 1)
-			found = true
-			break
-		}
+	c := state.Caret()
+	q := state.Query()
+	end := c.Pos()
+	if l := q.Len(); end > l {
+		end = l
+	}
+	if end <= 0 {
+		return
 	}
 
-	if !found {
-		q.DeleteRange(0, start)
-		c.SetPos(0)
+	// Delete the run of runes of the same class that ends at the caret
+	class := state.runeClass(q.RuneAt(end - 1))
+	start := end - 1
+	for start > 0 && state.runeClass(q.RuneAt(start-1)) == class {
+		start--
 	}
+
+	q.DeleteRange(start, end)
+	c.SetPos(start)
 	if state.ExecQuery() {
 		return
 	}
 	state.Hub().SendDrawPrompt()
 }
 
+// doForwardWord moves the caret to the end of the word under or after
+// it. Only the prompt is redrawn, as the query does not change
 func doForwardWord(ctx context.Context, state *Peco, _ termbox.Event) {
-	if state.Caret().Pos() >= state.Query().Len() {
-		return
-	}
-	defer state.Hub().SendDrawPrompt()
-
-	foundSpace := false
-	q := state.Query()
 	c := state.Caret()
-	for pos := c.Pos(); pos < q.Len(); pos++ {
-		r := q.RuneAt(pos)
-		if foundSpace {
-			if !unicode.IsSpace(r) {
-				c.SetPos(pos)
-				return
-			}
-		} else {
-			if unicode.IsSpace(r) {
-				foundSpace = true
-			}
-		}
+	q := state.Query()
+	pos := minOf(c.Pos(), q.Len())
+	for pos < q.Len() && state.runeClass(q.RuneAt(pos)) != runeClassWord {
+		pos++
 	}
-
-	// not found. just move to the end of the buffer
-	c.SetPos(q.Len())
+	for pos < q.Len() && state.runeClass(q.RuneAt(pos)) == runeClassWord {
+		pos++
+	}
+	if pos == c.Pos() {
+		return
+	}
+	c.SetPos(pos)
+	state.Hub().SendDrawPrompt()
 }
 
+// doBackwardWord moves the caret to the beginning of the word under or
+// before it. Only the prompt is redrawn, as the query does not change
 func doBackwardWord(ctx context.Context, state *Peco, _ termbox.Event) {
 	c := state.Caret()
 	q := state.Query()
-	if c.Pos() == 0 {
-		return
+	pos := minOf(c.Pos(), q.Len())
+	for pos > 0 && state.runeClass(q.RuneAt(pos-1)) != runeClassWord {
+		pos--
 	}
-	defer state.Hub().SendDrawPrompt()
-
-	if c.Pos() >= q.Len() {
-		c.Move(-1)
-	}
-
-	// if we start from a whitespace-ish position, we should
-	// rewind to the end of the previous word, and then do the
-	// search all over again
-SEARCH_PREV_WORD:
-	if unicode.IsSpace(q.RuneAt(c.Pos())) {
-		for pos := c.Pos(); pos > 0; pos-- {
-			if !unicode.IsSpace(q.RuneAt(pos)) {
-				c.SetPos(pos)
-				break
-			}
-		}
-	}
-
-	// if we start from the first character of a word, we
-	// should attempt to move back and search for the previous word
-	if c.Pos() > 0 && unicode.IsSpace(q.RuneAt(c.Pos()-1)) {
-		c.Move(-1)
-		goto SEARCH_PREV_WORD
+	for pos > 0 && state.runeClass(q.RuneAt(pos-1)) == runeClassWord {
+		pos--
 	}
-
-	// Now look for a space
-	for pos := c.Pos(); pos > 0; pos-- {
-		if unicode.IsSpace(q.RuneAt(pos)) {
-			c.SetPos(int(pos + 1))
-			return
-		}
+	if pos == c.Pos() {
+		return
 	}
-
-	// not found. just move to the beginning of the buffer
-	c.SetPos(0)
+	c.SetPos(pos)
+	state.Hub().SendDrawPrompt()
 }
 
 func doForwardChar(ctx context.Context, state *Peco, _ termbox.Event) {
@@ -596,33 +1243,41 @@ func doDeleteForwardWord(ctx context.Context, state *Peco, _ termbox.Event) {
 		return
 	}
 
-	// If we are on a word (non-Space, delete till the end of the word.
-	// If we are on a space, delete till the end of space.
-	sepFunc := unicode.IsSpace
-	if unicode.IsSpace(q.RuneAt(start)) {
-		sepFunc = func(r rune) bool { return !unicode.IsSpace(r) }
-	}
-
-	for pos := start; pos < q.Len(); pos++ {
-		if pos == q.Len()-1 {
-			q.DeleteRange(start, q.Len())
-			c.SetPos(start)
-			break
-		}
-
-		if sepFunc(q.RuneAt(pos)) {
-			q.DeleteRange(start, pos)
-			c.SetPos(start)
-			break
-		}
+	// Delete the run of runes of the same class that starts at the caret
+	class := state.runeClass(q.RuneAt(start))
+	end := start + 1
+	for end < q.Len() && state.runeClass(q.RuneAt(end)) == class {
+		end++
 	}
 
+	q.DeleteRange(start, end)
+	c.SetPos(start)
 	if state.ExecQuery() {
 		return
 	}
 	state.Hub().SendDrawPrompt()
 }
 
+// Classes of runes used to find word boundaries. A word is a run of
+// letters, numbers and WordChars, and the runs of spaces and other
+// runes between words are deleted separately from the words
+const (
+	runeClassWord = iota
+	runeClassSpace
+	runeClassOther
+)
+
+func (p *Peco) runeClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsNumber(r) || strings.ContainsRune(p.wordChars, r):
+		return runeClassWord
+	case unicode.IsSpace(r):
+		return runeClassSpace
+	default:
+		return runeClassOther
+	}
+}
+
 func doBeginningOfLine(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Caret().SetPos(0)
 	state.Hub().SendDrawPrompt()
@@ -664,11 +1319,87 @@ func doKillEndOfLine(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Hub().SendDrawPrompt()
 }
 
+func doPreviousQueryFromHistory(ctx context.Context, state *Peco, _ termbox.Event) {
+	h := state.history
+	if h == nil {
+		return
+	}
+
+	if q, ok := h.Previous(state.Query().String()); ok {
+		setQueryFromHistory(state, q)
+	}
+}
+
+func doNextQueryFromHistory(ctx context.Context, state *Peco, _ termbox.Event) {
+	h := state.history
+	if h == nil {
+		return
+	}
+
+	if q, ok := h.Next(); ok {
+		setQueryFromHistory(state, q)
+	}
+}
+
+func setQueryFromHistory(state *Peco, q string) {
+	state.Query().Set(q)
+	state.Caret().SetPos(utf8.RuneCountInString(q))
+	if state.ExecQuery() {
+		return
+	}
+	state.Hub().SendDrawPrompt()
+}
+
 func doDeleteAll(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Query().Reset()
 	state.ExecQuery()
 }
 
+// newSetQuery creates an action that replaces the query with the one
+// given in the arguments
+func newSetQuery(buf json.RawMessage) (Action, error) {
+	var args SetQueryArgs
+	if err := json.Unmarshal(buf, &args); err != nil {
+		return nil, errors.Wrap(err, "failed to decode arguments")
+	}
+
+	return ActionFunc(func(ctx context.Context, state *Peco, _ termbox.Event) {
+		if pdebug.Enabled {
+			g := pdebug.Marker("doSetQuery %s", args.Query)
+			defer g.End()
+		}
+		setQuery(state, args.Query)
+	}), nil
+}
+
+// doClearQuery removes the query, including the queries given on the
+// command line, so that all the lines are displayed again
+func doClearQuery(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doClearQuery")
+		defer g.End()
+	}
+	setQuery(state, "")
+}
+
+// setQuery replaces the query with q, and runs it. The cursor is moved
+// to the top of the results, as the line it was on may no longer be
+// there
+func setQuery(state *Peco, q string) {
+	state.SetMultiQuery(nil)
+	state.Query().Set(q)
+	state.Caret().SetPos(state.Query().Len())
+
+	loc := state.Location()
+	loc.SetLineNumber(0)
+	loc.SetOffset(0)
+
+	if state.ExecQuery() {
+		return
+	}
+	state.Hub().SendDrawPrompt()
+}
+
 func doDeleteForwardChar(ctx context.Context, state *Peco, _ termbox.Event) {
 	q := state.Query()
 	c := state.Caret()
@@ -725,6 +1456,36 @@ func doDeleteBackwardChar(ctx context.Context, state *Peco, e termbox.Event) {
 	state.Hub().SendDrawPrompt()
 }
 
+// doTransposeChars swaps the character before the caret with the one
+// under it, and moves the caret forward, like C-t in readline. At the
+// end of the query, the last two characters are swapped instead
+func doTransposeChars(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doTransposeChars")
+		defer g.End()
+	}
+
+	q := state.Query()
+	c := state.Caret()
+	runes := []rune(q.String())
+	pos := c.Pos()
+	if len(runes) < 2 || pos <= 0 {
+		return
+	}
+
+	if pos >= len(runes) {
+		pos = len(runes) - 1
+	}
+	runes[pos-1], runes[pos] = runes[pos], runes[pos-1]
+	q.Set(string(runes))
+	c.SetPos(pos + 1)
+
+	if state.ExecQuery() {
+		return
+	}
+	state.Hub().SendDrawPrompt()
+}
+
 func doRefreshScreen(ctx context.Context, state *Peco, _ termbox.Event) {
 	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
 }
@@ -760,6 +1521,61 @@ func doToggleSingleKeyJump(ctx context.Context, state *Peco, e termbox.Event) {
 	state.ToggleSingleKeyJumpMode()
 }
 
+func doToggleMouse(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleMouse")
+		defer g.End()
+	}
+
+	enabled := !state.MouseEnabled()
+	state.SetMouseEnabled(enabled)
+	if enabled {
+		state.Hub().SendStatusMsgAndClear("Mouse enabled", 2*time.Second)
+	} else {
+		state.Hub().SendStatusMsgAndClear("Mouse disabled", 2*time.Second)
+	}
+}
+
+func doScrollPreviewUp(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doScrollPreviewUp")
+		defer g.End()
+	}
+
+	if p := state.preview; p != nil {
+		p.Scroll(-p.PageSize())
+		state.Hub().SendDraw(nil)
+	}
+}
+
+func doScrollPreviewDown(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doScrollPreviewDown")
+		defer g.End()
+	}
+
+	if p := state.preview; p != nil {
+		p.Scroll(p.PageSize())
+		state.Hub().SendDraw(nil)
+	}
+}
+
+// doTogglePreview hides the preview pane, giving its space back to the
+// list, or shows it again
+func doTogglePreview(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doTogglePreview")
+		defer g.End()
+	}
+
+	p := state.preview
+	if p == nil {
+		return
+	}
+	p.SetHidden(!p.Hidden())
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
 func doSingleKeyJump(ctx context.Context, state *Peco, e termbox.Event) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("doSingleKeyJump %c", e.Ch)
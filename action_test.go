@@ -1,6 +1,14 @@
 package peco
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 	"unicode/utf8"
@@ -9,6 +17,9 @@ import (
 
 	"github.com/nsf/termbox-go"
 	"github.com/peco/peco/filter"
+	"github.com/peco/peco/internal/clipboard"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,6 +67,58 @@ func TestActionNames(t *testing.T) {
 	}
 }
 
+func TestUnbindDefaultKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := newPeco()
+	state.hub = batchHub{}
+
+	km := NewKeymap(map[string]string{
+		"C-a": "-",
+		"C-e": "peco.Noop",
+		"x":   "peco.Noop",
+		// Replaces peco.Finish, which is bound to the same key as "Enter"
+		"C-m": "peco.BeginningOfLine",
+	}, nil, nil)
+	if !assert.NoError(t, km.ApplyKeybinding(), "ApplyKeybinding should succeed") {
+		return
+	}
+
+	tests := []struct {
+		ev    termbox.Event
+		query string
+		caret int
+	}{
+		{termbox.Event{Key: termbox.KeyCtrlA}, "ab", 1},
+		{termbox.Event{Key: termbox.KeyCtrlE}, "ab", 1},
+		{termbox.Event{Ch: 'x'}, "ab", 1},
+		{termbox.Event{Ch: 'y'}, "ayb", 2},
+		{termbox.Event{Key: termbox.KeyEnter}, "ab", 0},
+	}
+	for _, test := range tests {
+		state.Query().Set("ab")
+		state.Caret().SetPos(1)
+		if !assert.NoError(t, km.ExecuteAction(ctx, state, test.ev), "ExecuteAction should succeed") {
+			return
+		}
+		if !expectQueryString(t, state.Query(), test.query) {
+			return
+		}
+		if !expectCaretPos(t, state.Caret(), test.caret) {
+			return
+		}
+	}
+
+	actions := map[string]ActionInfo{}
+	for _, a := range km.Actions() {
+		actions[a.Name] = a
+	}
+	assert.Equal(t, []string{"C-m"}, actions["peco.BeginningOfLine"].Keys, "unbound key should not be listed")
+	assert.NotContains(t, actions["peco.Finish"].Keys, "Enter", "replaced key should not be listed")
+	assert.Equal(t, []string{"C-e", "x"}, actions["peco.Noop"].Keys, "keys bound to peco.Noop should be listed")
+}
+
 func expectCaretPos(t *testing.T, c *Caret, expect int) bool {
 	return assert.Equal(t, expect, c.Pos(), "Expected caret position %d, got %d", expect, c.Pos())
 }
@@ -100,6 +163,98 @@ func TestDoDeleteForwardChar(t *testing.T) {
 	expectCaretPos(t, c, 0)
 }
 
+func TestDoTransposeChars(t *testing.T) {
+	state := newPeco()
+	q := state.Query()
+	c := state.Caret()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go state.Run(ctx)
+	defer cancel()
+
+	<-state.Ready()
+
+	tests := []struct {
+		query    string
+		pos      int
+		expected string
+		caret    int
+	}{
+		{"abcd", 2, "acbd", 3},
+		{"abcd", 4, "abdc", 4},
+		{"abcd", 0, "abcd", 0},
+		{"a", 1, "a", 1},
+		{"", 0, "", 0},
+		{"日本語", 1, "本日語", 2},
+		{"日本語", 3, "日語本", 3},
+		{"aé", 2, "éa", 2},
+	}
+	for _, test := range tests {
+		q.Set(test.query)
+		c.SetPos(test.pos)
+		doTransposeChars(ctx, state, termbox.Event{})
+
+		if !expectQueryString(t, q, test.expected) {
+			return
+		}
+		if !expectCaretPos(t, c, test.caret) {
+			return
+		}
+	}
+}
+
+func TestDoForwardBackwardWord(t *testing.T) {
+	state := newPeco()
+	q := state.Query()
+	c := state.Caret()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go state.Run(ctx)
+	defer cancel()
+
+	<-state.Ready()
+
+	tests := []struct {
+		query     string
+		wordChars string
+		pos       int
+		forward   int
+		backward  int
+	}{
+		{"foo bar baz", "", 0, 3, 0},
+		{"foo bar baz", "", 3, 7, 0},
+		{"foo bar baz", "", 5, 7, 4},
+		{"foo bar baz", "", 11, 11, 8},
+		{"foo  --bar", "", 3, 10, 0},
+		{"foo_bar.go", "", 0, 3, 0},
+		{"foo_bar.go", "_", 0, 7, 0},
+		{"foo_bar.go", "_", 10, 10, 8},
+		{"日本語 テスト", "", 0, 3, 0},
+		{"日本語 テスト", "", 6, 7, 4},
+		{"", "", 0, 0, 0},
+		{"foo", "", 10, 3, 0},
+	}
+	for _, test := range tests {
+		state.wordChars = test.wordChars
+		q.Set(test.query)
+
+		c.SetPos(test.pos)
+		doForwardWord(ctx, state, termbox.Event{})
+		if !assert.Equal(t, test.forward, c.Pos(), "forward from %d in %q", test.pos, test.query) {
+			return
+		}
+
+		c.SetPos(test.pos)
+		doBackwardWord(ctx, state, termbox.Event{})
+		if !assert.Equal(t, test.backward, c.Pos(), "backward from %d in %q", test.pos, test.query) {
+			return
+		}
+		if !expectQueryString(t, q, test.query) {
+			return
+		}
+	}
+}
+
 func TestDoDeleteForwardWord(t *testing.T) {
 	state := newPeco()
 	q := state.Query()
@@ -147,11 +302,23 @@ func TestDoDeleteForwardWord(t *testing.T) {
 		return
 	}
 
-	// should delete "World"
+	// should delete "World", but not the punctuation after it
 	c.SetPos(1)
 	doDeleteForwardWord(ctx, state, termbox.Event{})
 
-	if !expectQueryString(t, q, " ") {
+	if !expectQueryString(t, q, " !") {
+		return
+	}
+
+	// words are runs of Unicode letters and numbers
+	q.Set("größe2x-ß")
+	c.SetPos(0)
+	doDeleteForwardWord(ctx, state, termbox.Event{})
+
+	if !expectQueryString(t, q, "-ß") {
+		return
+	}
+	if !expectCaretPos(t, c, 0) {
 		return
 	}
 }
@@ -231,6 +398,42 @@ func TestDoDeleteBackwardWord(t *testing.T) {
 	if !expectCaretPos(t, c, 4) {
 		return
 	}
+	// Case 3. "foo bar<caret> baz" -> "foo  baz"
+	q.Set("foo bar baz")
+	c.SetPos(7)
+	doDeleteBackwardWord(ctx, state, termbox.Event{})
+
+	if !expectQueryString(t, q, "foo  baz") {
+		return
+	}
+
+	if !expectCaretPos(t, c, 4) {
+		return
+	}
+
+	// Case 4. "path/to/日本語<caret>" -> "path/to/"
+	q.Set("path/to/日本語")
+	c.SetPos(q.Len())
+	doDeleteBackwardWord(ctx, state, termbox.Event{})
+
+	if !expectQueryString(t, q, "path/to/") {
+		return
+	}
+
+	if !expectCaretPos(t, c, 8) {
+		return
+	}
+
+	// Case 5. "path/to/<caret>" -> "path/to"
+	doDeleteBackwardWord(ctx, state, termbox.Event{})
+
+	if !expectQueryString(t, q, "path/to") {
+		return
+	}
+
+	if !expectCaretPos(t, c, 7) {
+		return
+	}
 }
 
 func writeQueryToPrompt(t *testing.T, screen Screen, message string) {
@@ -314,6 +517,32 @@ func TestRotateFilter(t *testing.T) {
 	// TODO toggle ExecQuery()
 }
 
+func TestRotateFilterReverse(t *testing.T) {
+	state := newPeco()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go state.Run(ctx)
+	defer cancel()
+
+	<-state.Ready()
+
+	size := state.filters.Size()
+	if size <= 1 {
+		t.Skip("Can't proceed testing, only have 1 filter registered")
+		return
+	}
+
+	doRotateFilterReverse(ctx, state, termbox.Event{})
+	if !assert.Equal(t, size-1, state.Filters().Index(), "should have rotated to the last filter") {
+		return
+	}
+
+	doRotateFilter(ctx, state, termbox.Event{})
+	if !assert.Equal(t, 0, state.Filters().Index(), "should have rotated back to the first filter") {
+		return
+	}
+}
+
 func TestBeginningOfLineAndEndOfLine(t *testing.T) {
 	state := newPeco()
 
@@ -371,3 +600,559 @@ func TestBackToInitialFilter(t *testing.T) {
 		return
 	}
 }
+
+func TestDoCopyToClipboard(t *testing.T) {
+	state := newPeco()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go state.Run(ctx)
+	defer cancel()
+
+	<-state.Ready()
+	<-state.source.SetupDone()
+
+	var copied []string
+	writeClipboard = func(s string) error {
+		copied = append(copied, s)
+		return nil
+	}
+	defer func() { writeClipboard = clipboard.Write }()
+
+	// Without a selection, the current line is copied
+	doCopyToClipboard(ctx, state, termbox.Event{})
+	if !assert.Len(t, copied, 1, "clipboard should be written once") {
+		return
+	}
+	if !assert.Equal(t, "package peco", copied[0], "current line should be copied") {
+		return
+	}
+
+	for _, n := range []int{0, 2} {
+		l, err := state.Source().(*Source).LineAt(n)
+		if !assert.NoError(t, err, "LineAt(%d) should succeed", n) {
+			return
+		}
+		state.Selection().Add(l)
+	}
+
+	doCopyToClipboard(ctx, state, termbox.Event{})
+	if !assert.Equal(t, "package peco\nimport (", copied[1], "selected lines should be joined with newlines") {
+		return
+	}
+
+	state.print0 = true
+	doCopyToClipboard(ctx, state, termbox.Event{})
+	if !assert.Equal(t, "package peco\x00import (", copied[2], "selected lines should be joined with NUL") {
+		return
+	}
+}
+
+func TestDoCopyQueryToClipboard(t *testing.T) {
+	state := newPeco()
+	state.hub = nullHub{}
+
+	var copied []string
+	writeClipboard = func(s string) error {
+		copied = append(copied, s)
+		return nil
+	}
+	defer func() { writeClipboard = clipboard.Write }()
+
+	ctx := context.Background()
+	doCopyQueryToClipboard(ctx, state, termbox.Event{})
+	if !assert.Len(t, copied, 0, "empty query should not be copied") {
+		return
+	}
+
+	state.Query().Set(`1:foo !bar 'baz\`)
+	doCopyQueryToClipboard(ctx, state, termbox.Event{})
+	if !assert.Equal(t, []string{`1:foo !bar 'baz\`}, copied, "query should be copied as is") {
+		return
+	}
+
+	writeClipboard = func(string) error { return clipboard.ErrNotAvailable }
+	doCopyQueryToClipboard(ctx, state, termbox.Event{})
+	assert.Equal(t, `1:foo !bar 'baz\`, state.Query().String(), "query should be kept if the clipboard is not available")
+}
+
+func TestExecuteCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+		return
+	}
+
+	if _, err := newExecuteCommand(json.RawMessage(`{}`)); !assert.Error(t, err, "Cmd should be required") {
+		return
+	}
+
+	state := newPeco()
+	state.config.CustomAction = map[string]CustomActionConfig{
+		"test.Upcase": {
+			Action: "peco.ExecuteCommand",
+			Args:   json.RawMessage(`{"cmd": "tr a-z A-Z", "replace": true}`),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go state.Run(ctx)
+	defer cancel()
+
+	<-state.Ready()
+	<-state.source.SetupDone()
+
+	state.config.Keymap["C-q"] = "test.Upcase"
+	if !assert.NoError(t, state.populateKeymap(), "populateKeymap expected to succeed") {
+		return
+	}
+
+	prev := state.Source()
+	state.screen.SendEvent(termbox.Event{Key: termbox.KeyCtrlQ})
+
+	timeout := time.After(5 * time.Second)
+	for state.Source() == prev {
+		select {
+		case <-timeout:
+			assert.Fail(t, "timed out waiting for the source to be replaced")
+			return
+		default:
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	src := state.Source().(*Source)
+	if !assert.Equal(t, 1, src.Size(), "output of the command should be the new source") {
+		return
+	}
+	l, err := src.LineAt(0)
+	if !assert.NoError(t, err, "LineAt(0) should succeed") {
+		return
+	}
+	assert.Equal(t, "PACKAGE PECO", l.DisplayString(), "current line should have been passed to the command")
+}
+
+func TestDoToggleMouse(t *testing.T) {
+	state := newPeco()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go state.Run(ctx)
+	defer cancel()
+
+	<-state.Ready()
+
+	screen := state.Screen().(*dummyScreen)
+	screen.interceptor.reset()
+
+	if !assert.False(t, state.MouseEnabled(), "mouse should be disabled by default") {
+		return
+	}
+
+	doToggleMouse(ctx, state, termbox.Event{})
+	if !assert.True(t, state.MouseEnabled(), "mouse should be enabled") {
+		return
+	}
+
+	doToggleMouse(ctx, state, termbox.Event{})
+	if !assert.False(t, state.MouseEnabled(), "mouse should be disabled") {
+		return
+	}
+
+	screen.interceptor.m.Lock()
+	events := screen.interceptor.events["SetMouse"]
+	screen.interceptor.m.Unlock()
+	if !assert.Len(t, events, 2, "screen should have been told twice") {
+		return
+	}
+	assert.Equal(t, interceptorArgs{true}, events[0], "mouse should be enabled first")
+	assert.Equal(t, interceptorArgs{false}, events[1], "mouse should be disabled next")
+}
+
+func TestDoInvertSelection(t *testing.T) {
+	state := newPeco()
+	state.hub = nullHub{}
+
+	var lines []line.Line
+	buf := NewMemoryBuffer()
+	for i := 0; i < 5; i++ {
+		l := line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false)
+		lines = append(lines, l)
+		// Only the even lines match the query
+		if i%2 == 0 {
+			buf.lines = append(buf.lines, line.NewMatched(l, nil))
+		}
+	}
+	state.currentLineBuffer = buf
+
+	state.Selection().Add(lines[0])
+	state.Selection().Add(lines[1])
+	state.SelectionRangeStart().SetValue(0)
+
+	doInvertSelection(context.Background(), state, termbox.Event{})
+
+	expected := []bool{false, true, true, false, true}
+	for i, l := range lines {
+		if !assert.Equal(t, expected[i], state.Selection().Has(l), "selection state of line %d", i) {
+			return
+		}
+	}
+	if !assert.False(t, state.SelectionRangeStart().Valid(), "range mode should be cancelled") {
+		return
+	}
+}
+
+// statusHub records the status messages that are sent
+type statusHub struct {
+	nullHub
+	msgs []string
+}
+
+func (h *statusHub) SendStatusMsgAndClear(msg string, _ time.Duration) {
+	h.msgs = append(h.msgs, msg)
+}
+
+func TestDoAddMatchesToSelection(t *testing.T) {
+	state := newPeco()
+	h := &statusHub{}
+	state.hub = h
+
+	var lines []line.Line
+	for i := 0; i < 5; i++ {
+		lines = append(lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+	}
+	setMatches := func(ids ...int) {
+		buf := NewMemoryBuffer()
+		for _, i := range ids {
+			buf.lines = append(buf.lines, line.NewMatched(lines[i], nil))
+		}
+		state.currentLineBuffer = buf
+	}
+
+	state.Selection().Add(lines[0])
+	setMatches(0, 2)
+	doAddMatchesToSelection(context.Background(), state, termbox.Event{})
+	setMatches(2, 3)
+	doAddMatchesToSelection(context.Background(), state, termbox.Event{})
+
+	expected := []bool{true, false, true, true, false}
+	for i, l := range lines {
+		if !assert.Equal(t, expected[i], state.Selection().Has(l), "selection state of line %d", i) {
+			return
+		}
+	}
+	assert.Equal(t, []string{"Added 1 line(s) to the selection", "Added 1 line(s) to the selection"}, h.msgs, "already selected lines should not be counted")
+}
+
+func TestDoClearSelection(t *testing.T) {
+	state := newPeco()
+	h := &statusHub{}
+	state.hub = h
+
+	for i := 0; i < 3; i++ {
+		state.Selection().Add(line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+	}
+	state.Selection().Pin(line.NewRaw(3, "line 3", false))
+	doClearSelection(context.Background(), state, termbox.Event{})
+	doClearSelection(context.Background(), state, termbox.Event{})
+
+	assert.Equal(t, 0, state.Selection().Len(), "selection should be empty")
+	assert.Equal(t, 0, state.Selection().PinnedLen(), "pinned lines should be deselected")
+	assert.Equal(t, []string{"Cleared 4 selected line(s)", "No lines are selected"}, h.msgs, "deselected lines should be counted")
+}
+
+func TestClearSelectionFinish(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	out := &bytes.Buffer{}
+	p.Stdout = out
+	resultCh := make(chan error)
+	go func() { resultCh <- p.Run(ctx) }()
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	for p.CurrentLineBuffer().Size() < 3 {
+		select {
+		case <-ctx.Done():
+			t.Errorf("lines were not displayed")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	doToggleSelection(ctx, p, termbox.Event{})
+	p.Location().SetLineNumber(2)
+	doToggleSelection(ctx, p, termbox.Event{})
+	p.Location().SetLineNumber(1)
+	doClearSelection(ctx, p, termbox.Event{})
+	if !assert.Equal(t, 0, p.Selection().Len(), "selection should be empty") {
+		return
+	}
+	if !assert.Equal(t, 1, p.Location().LineNumber(), "cursor should not move") {
+		return
+	}
+
+	doFinish(ctx, p, termbox.Event{})
+	if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "peco should exit with the results") {
+		return
+	}
+	p.PrintResults()
+	assert.Equal(t, "bar\n", out.String(), "only the current line should be output")
+}
+
+// pagingHub records the paging requests that are sent
+type pagingHub struct {
+	batchHub
+	reqs []interface{}
+}
+
+func (h *pagingHub) SendPaging(v interface{}) {
+	h.reqs = append(h.reqs, v)
+}
+
+func TestToggleSelectionAndMove(t *testing.T) {
+	state := newPeco()
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 3; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+	}
+	state.currentLineBuffer = buf
+
+	tests := []struct {
+		action   string
+		layout   string
+		line     int
+		expected []interface{}
+	}{
+		{"peco.ToggleSelectionAndDown", LayoutTypeTopDown, 0, []interface{}{ToLineBelow}},
+		{"peco.ToggleSelectionAndDown", LayoutTypeTopDown, 2, nil},
+		{"peco.ToggleSelectionAndUp", LayoutTypeTopDown, 0, nil},
+		{"peco.ToggleSelectionAndUp", LayoutTypeTopDown, 2, []interface{}{ToLineAbove}},
+		// The first line is at the bottom of the bottom-up layout
+		{"peco.ToggleSelectionAndDown", LayoutTypeBottomUp, 0, nil},
+		{"peco.ToggleSelectionAndUp", LayoutTypeBottomUp, 2, nil},
+		{"peco.ToggleSelectionAndUp", LayoutTypeBottomUp, 0, []interface{}{ToLineAbove}},
+	}
+	for _, test := range tests {
+		h := &pagingHub{}
+		state.hub = h
+		state.layoutType = test.layout
+		state.Selection().Reset()
+		state.Location().SetLineNumber(test.line)
+
+		nameToActions[test.action].Execute(context.Background(), state, termbox.Event{})
+		if !assert.Equal(t, 1, state.Selection().Len(), "%s should select line %d", test.action, test.line) {
+			return
+		}
+		if !assert.Equal(t, test.expected, h.reqs, "%s from line %d in %s should move the cursor unless it is at the end", test.action, test.line, test.layout) {
+			return
+		}
+	}
+}
+
+func TestDoSelectToMark(t *testing.T) {
+	state := newPeco()
+	state.hub = nullHub{}
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 5; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+	}
+	state.currentLineBuffer = buf
+
+	// Without a mark, nothing happens
+	doSelectToMark(context.Background(), state, termbox.Event{})
+	if !assert.Equal(t, 0, state.Selection().Len(), "nothing should be selected without a mark") {
+		return
+	}
+
+	state.Location().SetLineNumber(3)
+	doSetMark(context.Background(), state, termbox.Event{})
+	state.Location().SetLineNumber(1)
+	doSelectToMark(context.Background(), state, termbox.Event{})
+
+	expected := []bool{false, true, true, true, false}
+	for i, l := range buf.lines {
+		if !assert.Equal(t, expected[i], state.Selection().Has(l), "selection state of line %d", i) {
+			return
+		}
+	}
+
+	// The marked line is no longer in the results
+	state.Selection().Reset()
+	state.currentLineBuffer = &MemoryBuffer{lines: buf.lines[:2]}
+	doSelectToMark(context.Background(), state, termbox.Event{})
+	if !assert.Equal(t, 0, state.Selection().Len(), "nothing should be selected if the mark is not found") {
+		return
+	}
+}
+
+func TestExecuteWithSelection(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+		return
+	}
+
+	if _, err := newExecuteWithSelection(json.RawMessage(`{}`)); !assert.Error(t, err, "Cmd should be required") {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "peco-execute")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	state := newPeco()
+	state.hub = nullHub{}
+	state.source = NewSource("-", nil, newIDGen(), 0, false)
+	buf := NewMemoryBuffer()
+	for i, s := range []string{"foo", "bar baz", "qux"} {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), s, false))
+	}
+	state.currentLineBuffer = buf
+	state.Selection().Add(buf.lines[0])
+	state.Selection().Add(buf.lines[1])
+
+	run := func(args string, expected string) bool {
+		out := filepath.Join(dir, "out")
+		os.Remove(out)
+
+		action, err := newExecuteWithSelection(json.RawMessage(strings.Replace(args, "OUT", out, -1)))
+		if !assert.NoError(t, err, "action should be created") {
+			return false
+		}
+		action.Execute(context.Background(), state, termbox.Event{})
+
+		timeout := time.After(5 * time.Second)
+		for {
+			if buf, err := ioutil.ReadFile(out); err == nil && string(buf) == expected {
+				break
+			}
+			select {
+			case <-timeout:
+				assert.Fail(t, "timed out waiting for the command")
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		for !state.startExecuting() {
+			select {
+			case <-timeout:
+				assert.Fail(t, "timed out waiting for the command to be done")
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		state.doneExecuting()
+		return true
+	}
+
+	if !run(`{"cmd": "cat > OUT"}`, "foo\nbar baz\n") {
+		return
+	}
+	if !run(`{"cmd": "printf '%s\\n' > OUT", "args": true}`, "foo\nbar baz\n") {
+		return
+	}
+
+	// Only one command runs at a time
+	state.startExecuting()
+	action, err := newExecuteWithSelection(json.RawMessage(`{"cmd": "touch ` + filepath.Join(dir, "busy") + `"}`))
+	if !assert.NoError(t, err, "action should be created") {
+		return
+	}
+	action.Execute(context.Background(), state, termbox.Event{})
+	time.Sleep(100 * time.Millisecond)
+	_, err = os.Stat(filepath.Join(dir, "busy"))
+	assert.True(t, os.IsNotExist(err), "command should not run while another one is running")
+}
+
+func TestDoNextSelection(t *testing.T) {
+	state := newPeco()
+	state.hub = nullHub{}
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 5; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), fmt.Sprintf("line %d", i), false))
+	}
+	state.currentLineBuffer = buf
+
+	// Without a selection, the cursor stays where it is
+	state.Location().SetLineNumber(2)
+	doNextSelection(context.Background(), state, termbox.Event{})
+	if !assert.Equal(t, 2, state.Location().LineNumber(), "cursor should not move without a selection") {
+		return
+	}
+
+	state.Selection().Add(buf.lines[0])
+	state.Selection().Add(buf.lines[3])
+
+	for i, expected := range []int{3, 0, 3} {
+		doNextSelection(context.Background(), state, termbox.Event{})
+		if !assert.Equal(t, expected, state.Location().LineNumber(), "NextSelection #%d", i) {
+			return
+		}
+	}
+	for i, expected := range []int{0, 3, 0} {
+		doPrevSelection(context.Background(), state, termbox.Event{})
+		if !assert.Equal(t, expected, state.Location().LineNumber(), "PrevSelection #%d", i) {
+			return
+		}
+	}
+}
+
+func TestSetQueryAndClearQuery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state := newPeco()
+	state.Argv = nil
+	state.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	go state.Run(ctx)
+
+	<-state.Ready()
+	<-state.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(state.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached while waiting for %v, got %v", expected, bufferLines(state.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	state.Location().SetLineNumber(2)
+	action, err := newSetQuery(json.RawMessage(`{"query": "ba"}`))
+	if !assert.NoError(t, err, "action should be created") {
+		return
+	}
+	action.Execute(ctx, state, termbox.Event{})
+	if !waitLines([]string{"bar", "baz"}) {
+		return
+	}
+	if !assert.Equal(t, "ba", state.Query().String(), "query should be replaced") {
+		return
+	}
+	if !assert.Equal(t, 2, state.Caret().Pos(), "caret should be at the end of the query") {
+		return
+	}
+	if !assert.Equal(t, 0, state.Location().LineNumber(), "cursor should be at the top") {
+		return
+	}
+
+	state.Location().SetLineNumber(1)
+	doClearQuery(ctx, state, termbox.Event{})
+	if !waitLines([]string{"foo", "bar", "baz"}) {
+		return
+	}
+	if !assert.Equal(t, "", state.Query().String(), "query should be cleared") {
+		return
+	}
+	assert.Equal(t, 0, state.Location().LineNumber(), "cursor should be at the top")
+}
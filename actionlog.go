@@ -0,0 +1,227 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/keyseq"
+	"github.com/pkg/errors"
+)
+
+// DefaultReplayWidth and DefaultReplayHeight are the size of the screen
+// that --replay runs on, if the log does not say otherwise
+const (
+	DefaultReplayWidth  = 80
+	DefaultReplayHeight = 24
+)
+
+// eventKey returns the key that the keymap looks up for ev
+func eventKey(ev termbox.Event) keyseq.Key {
+	modifier := keyseq.ModNone
+	if (ev.Mod & termbox.ModAlt) != 0 {
+		modifier = keyseq.ModAlt
+	}
+	return keyseq.Key{
+		Modifier: modifier,
+		Key:      ev.Key,
+		Ch:       ev.Ch,
+	}
+}
+
+// boundActionName returns the name of the action that key is bound to
+// by itself, or an empty string if it is not bound, or only as part of
+// a key sequence. The conditional bindings are not taken into account
+func (km Keymap) boundActionName(key string) string {
+	for s, name := range km.Config {
+		if canonicalKeyName(s) != key {
+			continue
+		}
+		if name == "-" {
+			return ""
+		}
+		return name
+	}
+	return defaultKeyNames[key]
+}
+
+// syncActions returns true if each action has to wait for what it
+// started to complete, because the actions are logged or replayed
+func (p *Peco) syncActions() bool {
+	return p.actionLogFile != "" || p.replayFile != ""
+}
+
+// actionState summarizes the state of peco, as logged after each action
+func (p *Peco) actionState() actionLogState {
+	return actionLogState{
+		Query:    p.Query().String(),
+		Caret:    p.Caret().Pos(),
+		Cursor:   p.Location().LineNumber(),
+		Selected: p.Selection().Len(),
+	}
+}
+
+// dispatchAction executes the action bound to ev, which is what Input
+// does with the keys typed. If expire is true, ev is the last key of a
+// key sequence that was not completed in time instead, see
+// Keymap.ExpireSequence.
+//
+// When the actions are logged or replayed, each action waits for the
+// queries and the cursor movements that it started to complete, so
+// that the state logged after it is the one it led to
+func (p *Peco) dispatchAction(ctx context.Context, ev termbox.Event, expire bool) {
+	km := p.Keymap()
+	execute := func() {
+		if expire {
+			km.ExpireSequence(ctx, p, ev)
+			return
+		}
+		km.ExecuteAction(ctx, p, ev)
+	}
+
+	if !p.syncActions() {
+		execute()
+		return
+	}
+
+	p.actionMutex.Lock()
+	defer p.actionMutex.Unlock()
+	if p.actionsStopped {
+		return
+	}
+
+	key := eventKey(ev).String()
+	var name string
+	if !expire {
+		name = km.boundActionName(key)
+	}
+	p.Hub().Batch(execute, false)
+
+	if p.actionLog == nil {
+		return
+	}
+	w, h := p.screen.Size()
+	buf, err := json.Marshal(actionLogEntry{
+		Time:   time.Now(),
+		Key:    key,
+		Action: name,
+		Expire: expire,
+		Event:  actionLogEvent{Key: ev.Key, Ch: ev.Ch, Mod: ev.Mod},
+		Size:   [2]int{w, h},
+		State:  p.actionState(),
+	})
+	if err == nil {
+		_, err = p.actionLog.Write(append(buf, '\n'))
+	}
+	if err != nil && pdebug.Enabled {
+		pdebug.Printf("failed to log action: %s", err)
+	}
+}
+
+// stopActions waits for the action being logged or replayed to
+// complete once peco exits, and keeps any other from being executed.
+// If replayDone is not nil, the replay is waited for as well. The
+// messages sent meanwhile are discarded, as nothing receives them
+// anymore
+func (p *Peco) stopActions(replayDone <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go drainHub(ctx, p.Hub())
+
+	if replayDone != nil {
+		<-replayDone
+	}
+	p.actionMutex.Lock()
+	defer p.actionMutex.Unlock()
+	p.actionsStopped = true
+}
+
+// readActionLog reads the actions logged in filename by ActionLog
+func readActionLog(filename string) ([]actionLogEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open action log")
+	}
+	defer f.Close()
+
+	var entries []actionLogEntry
+	dec := json.NewDecoder(f)
+	for {
+		var e actionLogEntry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, errors.Wrapf(err, "failed to parse action %d in %s", len(entries)+1, filename)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// diff describes how s differs from the state that was expected
+func (s actionLogState) diff(expected actionLogState) []string {
+	var diffs []string
+	if s.Query != expected.Query {
+		diffs = append(diffs, fmt.Sprintf("query is %q instead of %q", s.Query, expected.Query))
+	}
+	if s.Caret != expected.Caret {
+		diffs = append(diffs, fmt.Sprintf("caret is at %d instead of %d", s.Caret, expected.Caret))
+	}
+	if s.Cursor != expected.Cursor {
+		diffs = append(diffs, fmt.Sprintf("cursor is on line %d instead of %d", s.Cursor, expected.Cursor))
+	}
+	if s.Selected != expected.Selected {
+		diffs = append(diffs, fmt.Sprintf("%d lines are selected instead of %d", s.Selected, expected.Selected))
+	}
+	return diffs
+}
+
+// replayActions dispatches the actions in entries one after the other,
+// once the input has been read and the initial query has been run. The
+// state that each action leads to is compared with the one logged, and
+// the differences are reported to Stderr. If none of the actions made
+// peco exit, it exits once they have all been replayed
+func (p *Peco) replayActions(ctx context.Context, entries []actionLogEntry) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.replayActions (%d actions)", len(entries))
+		defer g.End()
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-p.source.SetupDone():
+	}
+	p.Hub().Batch(func() { p.ExecQuery() }, false)
+
+	screen, _ := p.screen.(*headlessScreen)
+	for i, e := range entries {
+		if ctx.Err() != nil {
+			fmt.Fprintf(p.Stderr, "replay: peco exited before action %d (%s)\n", i+1, e.Key)
+			p.replayMismatches++
+			return
+		}
+
+		if w, h := p.screen.Size(); screen != nil && (w != e.Size[0] || h != e.Size[1]) {
+			screen.SetSize(e.Size[0], e.Size[1])
+			p.Hub().Batch(p.resize, false)
+		}
+
+		ev := termbox.Event{Type: termbox.EventKey, Key: e.Event.Key, Ch: e.Event.Ch, Mod: e.Event.Mod}
+		p.dispatchAction(ctx, ev, e.Expire)
+		if diffs := p.actionState().diff(e.State); len(diffs) > 0 {
+			fmt.Fprintf(p.Stderr, "replay: after action %d (%s): %s\n", i+1, e.Key, strings.Join(diffs, ", "))
+			p.replayMismatches++
+		}
+	}
+
+	if ctx.Err() == nil {
+		p.Exit(setExitStatus(makeIgnorable(errors.New("replay ended")), ExitStatusNoSelection))
+	}
+}
@@ -0,0 +1,136 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundActionName(t *testing.T) {
+	km := NewKeymap(map[string]string{
+		"C-j": "peco.Finish",
+		"C-n": "-",
+	}, nil, nil)
+
+	testValues := []struct {
+		ev       termbox.Event
+		expected string
+	}{
+		{termbox.Event{Key: termbox.KeyCtrlJ}, "peco.Finish"},
+		{termbox.Event{Key: termbox.KeyCtrlN}, ""},
+		{termbox.Event{Key: termbox.KeyCtrlP}, "peco.SelectUp"},
+		{termbox.Event{Ch: 'a'}, ""},
+	}
+	for _, v := range testValues {
+		key := eventKey(v.ev).String()
+		assert.Equal(t, v.expected, km.boundActionName(key), "action bound to %s", key)
+	}
+}
+
+func TestActionLogReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-actionlog")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "actions.log")
+
+	// Log a session that narrows down the lines, and moves to the second
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	out := &bytes.Buffer{}
+	p.Stdout = out
+	p.config.ActionLog = filename
+	resultCh := make(chan error)
+	go func() { resultCh <- p.Run(ctx) }()
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	for _, ev := range []termbox.Event{
+		{Ch: 'b'},
+		{Ch: 'a'},
+		{Key: termbox.KeyCtrlN},
+		{Key: termbox.KeyEnter},
+	} {
+		p.dispatchAction(ctx, ev, false)
+	}
+	if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "peco should exit with the results") {
+		return
+	}
+	p.PrintResults()
+	assert.Equal(t, "baz\n", out.String(), "second line matched should be selected")
+
+	entries, err := readActionLog(filename)
+	if !assert.NoError(t, err, "readActionLog should succeed") || !assert.Len(t, entries, 4, "each action should be logged") {
+		return
+	}
+	assert.Equal(t, "peco.SelectDown", entries[2].Action, "action should be named")
+	assert.Equal(t, actionLogState{Query: "ba", Caret: 2, Cursor: 1}, entries[2].State, "state after the action should be logged")
+	assert.Equal(t, [2]int{80, 10}, entries[2].Size, "size of the screen should be logged")
+	assert.Equal(t, "peco.Finish", entries[3].Action, "action should be named")
+
+	replay := func(entries []actionLogEntry) (string, string, error) {
+		f, err := os.Create(filename)
+		if err != nil {
+			return "", "", err
+		}
+		enc := json.NewEncoder(f)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		f.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = []string{"peco", "--replay", filename}
+		p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+		out := &bytes.Buffer{}
+		p.Stdout = out
+		errOut := &bytes.Buffer{}
+		p.Stderr = errOut
+		err = p.Run(ctx)
+		if util.IsCollectResultsError(err) {
+			p.PrintResults()
+			err = nil
+		}
+		return out.String(), errOut.String(), err
+	}
+
+	t.Run("Same state", func(t *testing.T) {
+		out, errOut, err := replay(entries)
+		if !assert.NoError(t, err, "replay should succeed") {
+			return
+		}
+		assert.Equal(t, "baz\n", out, "replay should select the same line")
+		assert.Empty(t, errOut, "nothing should be reported")
+	})
+
+	t.Run("Other state", func(t *testing.T) {
+		entries[2].State.Cursor = 0
+		_, errOut, err := replay(entries)
+		assert.Error(t, err, "replay should fail")
+		assert.Contains(t, errOut, "after action 3 (C-n): cursor is on line 1 instead of 0", "difference should be reported")
+	})
+
+	t.Run("Unfinished", func(t *testing.T) {
+		_, _, err := replay(entries[:2])
+		if st, ok := util.GetExitStatus(err); assert.True(t, ok && util.IsIgnorableError(err), "peco should exit once replayed") {
+			assert.Equal(t, ExitStatusNoSelection, st, "nothing should be selected")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/mattn/go-runewidth"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+)
+
+// NewAnnotator creates a new Annotator from the configuration. Returns
+// nil if no command is configured
+func NewAnnotator(cfg AnnotatorConfig) *Annotator {
+	if cfg.Command == "" {
+		return nil
+	}
+
+	return &Annotator{
+		command: cfg.Command,
+		badges:  make(map[uint64]string),
+	}
+}
+
+// Badge returns the badge given to l, or the empty string if it does
+// not have one
+func (a *Annotator) Badge(l line.Line) string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.badges[l.ID()]
+}
+
+// Width returns the width of the widest badge. The column of the badges
+// is not displayed until it is greater than 0
+func (a *Annotator) Width() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.width
+}
+
+func (a *Annotator) store(badges map[uint64]string) {
+	width := 0
+	for _, badge := range badges {
+		if w := runewidth.StringWidth(badge); w > width {
+			width = w
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.badges = badges
+	a.width = width
+}
+
+// Run executes the command once the input has been read, and records
+// the badges that it prints. The lines are given to the command in the
+// order they were read, so that the indices are stable no matter how
+// the lines are filtered or sorted afterwards
+func (a *Annotator) Run(ctx context.Context, state *Peco) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Annotator.Run")
+		defer g.End()
+	}
+
+	src, ok := state.Source().(*Source)
+	if !ok {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-src.SetupDone():
+	}
+
+	delim := "\n"
+	if state.readNull {
+		delim = "\x00"
+	}
+
+	lines, _ := src.retained()
+	var in, out bytes.Buffer
+	for _, l := range lines {
+		in.WriteString(l.Output())
+		in.WriteString(delim)
+	}
+
+	cmd := util.Shell(a.command)
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	cmd.Env = state.commandEnv()
+	if err := cmd.Start(); err != nil {
+		state.ShowMessage("failed to execute annotator: "+err.Error(), 0, MessageError)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return
+	case err := <-done:
+		if err != nil {
+			state.ShowMessage("annotator failed: "+err.Error(), 0, MessageError)
+			return
+		}
+	}
+
+	a.store(parseBadges(&out, lines))
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
+// parseBadges reads the "index\tbadge" pairs printed by the command,
+// and maps them to the IDs of the lines. Pairs that cannot be parsed,
+// or whose index is out of range, are ignored
+func parseBadges(out *bytes.Buffer, lines []line.Line) map[uint64]string {
+	badges := make(map[uint64]string)
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil || i < 0 || i >= len(lines) {
+			continue
+		}
+		badge := util.StripANSISequence(fields[1])
+		badge = strings.Replace(badge, "\t", " ", -1)
+		if badge == "" {
+			continue
+		}
+		badges[lines[i].ID()] = badge
+	}
+	return badges
+}
@@ -0,0 +1,78 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAnnotator(t *testing.T) {
+	assert.Nil(t, NewAnnotator(AnnotatorConfig{}), "annotator should be disabled without a command")
+	assert.NotNil(t, NewAnnotator(AnnotatorConfig{Command: "true"}), "annotator should be enabled with a command")
+}
+
+func TestParseBadges(t *testing.T) {
+	lines := []line.Line{
+		line.NewRaw(0, "foo", false),
+		line.NewRaw(1, "bar", false),
+		line.NewRaw(2, "baz", false),
+	}
+	out := bytes.NewBufferString("0\tM\ngarbage\n2\t\x1b[31m??\x1b[0m\n5\tX\n-1\tY\n1\t\n")
+	expected := map[uint64]string{
+		lines[0].ID(): "M",
+		lines[2].ID(): "??",
+	}
+	assert.Equal(t, expected, parseBadges(out, lines), "badges should match")
+}
+
+func TestAnnotator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	p.config.Annotator.Command = `awk '/^b/ { print NR-1 "\t" toupper($0) }'`
+	go p.Run(ctx)
+
+	<-p.Ready()
+	for p.annotator.Width() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached while waiting for the annotator")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !assert.Equal(t, 3, p.annotator.Width(), "width should be that of the widest badge") {
+		return
+	}
+
+	// Badges follow the lines once they are filtered
+	p.Query().Set("z")
+	p.ExecQuery()
+	for !assert.ObjectsAreEqual([]string{"baz"}, bufferLines(p.CurrentLineBuffer())) {
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached while waiting for the query")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	l, err := p.CurrentLineBuffer().LineAt(0)
+	if !assert.NoError(t, err, "LineAt should succeed") {
+		return
+	}
+	assert.Equal(t, "BAZ", p.annotator.Badge(l), "badge should match")
+
+	l, err = p.source.LineAt(0)
+	if !assert.NoError(t, err, "LineAt should succeed") {
+		return
+	}
+	assert.Equal(t, "", p.annotator.Badge(l), "lines without a badge should not have one")
+}
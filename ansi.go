@@ -0,0 +1,133 @@
+package peco
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+)
+
+// ansiColors maps the color numbers used by SGR sequences, which start
+// from black, to the termbox colors
+var ansiColors = [8]termbox.Attribute{
+	termbox.ColorBlack,
+	termbox.ColorRed,
+	termbox.ColorGreen,
+	termbox.ColorYellow,
+	termbox.ColorBlue,
+	termbox.ColorMagenta,
+	termbox.ColorCyan,
+	termbox.ColorWhite,
+}
+
+// parseANSI strips the ANSI escape sequences from s, like
+// util.StripANSISequence, and returns the parts of the stripped string
+// that SGR sequences give a style to. Sequences other than SGR, and
+// colors that cannot be displayed with 8 colors, are ignored
+func parseANSI(s string) (string, []ansiSpan) {
+	var stripped bytes.Buffer
+	var spans []ansiSpan
+	var cur ansiSpan
+
+	// Closes the span that starts at cur.start, and starts a new one
+	// with the same style
+	flush := func() {
+		cur.end = stripped.Len()
+		switch last := len(spans) - 1; {
+		case cur.end == cur.start || (cur.fg == 0 && cur.bg == 0):
+			// Nothing to style
+		case last >= 0 && spans[last].end == cur.start && spans[last].fg == cur.fg && spans[last].bg == cur.bg:
+			// Sequences that do not change the style do not split spans
+			spans[last].end = cur.end
+		default:
+			spans = append(spans, cur)
+		}
+		cur.start = cur.end
+	}
+
+	prev := 0
+	for _, loc := range util.ANSISequenceIndices(s) {
+		stripped.WriteString(s[prev:loc[0]])
+		prev = loc[1]
+
+		seq := s[loc[0]:loc[1]]
+		if seq[len(seq)-1] != 'm' {
+			continue
+		}
+		flush()
+		cur.fg, cur.bg = applySGR(cur.fg, cur.bg, seq[2:len(seq)-1])
+	}
+	stripped.WriteString(s[prev:])
+	flush()
+
+	return stripped.String(), spans
+}
+
+// applySGR returns the colors that result from the semicolon separated
+// parameters of a SGR sequence on fg and bg
+func applySGR(fg, bg termbox.Attribute, params string) (termbox.Attribute, termbox.Attribute) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		// An empty parameter, as in "\x1b[m", means 0
+		code, _ := strconv.Atoi(codes[i])
+		switch {
+		case code == 0:
+			fg, bg = termbox.ColorDefault, termbox.ColorDefault
+		case code == 1:
+			fg |= termbox.AttrBold
+		case code == 4:
+			fg |= termbox.AttrUnderline
+		case code == 7:
+			fg |= termbox.AttrReverse
+		case code == 22:
+			fg &^= termbox.AttrBold
+		case code == 24:
+			fg &^= termbox.AttrUnderline
+		case code == 27:
+			fg &^= termbox.AttrReverse
+		case code >= 30 && code <= 37:
+			fg = fg&^0x0F | ansiColors[code-30]
+		case code == 39:
+			fg &^= 0x0F
+		case code >= 40 && code <= 47:
+			bg = bg&^0x0F | ansiColors[code-40]
+		case code == 49:
+			bg &^= 0x0F
+		case code >= 90 && code <= 97:
+			fg = fg&^0x0F | ansiColors[code-90]
+		case code >= 100 && code <= 107:
+			bg = bg&^0x0F | ansiColors[code-100]
+		case code == 38 || code == 48:
+			// Extended colors are either "5;n" or "2;r;g;b". Only the
+			// first 16 of the 256 colors can be displayed
+			var color termbox.Attribute
+			if i+2 < len(codes) && codes[i+1] == "5" {
+				if n, err := strconv.Atoi(codes[i+2]); err == nil && n < 16 {
+					color = ansiColors[n%8]
+				}
+				i += 2
+			} else if i+1 < len(codes) && codes[i+1] == "2" {
+				i += 4
+			}
+			if color == 0 {
+				continue
+			}
+			if code == 38 {
+				fg = fg&^0x0F | color
+			} else {
+				bg = bg&^0x0F | color
+			}
+		}
+	}
+	return fg, bg
+}
+
+// applyANSI returns base with the color and attributes of a, if any
+func applyANSI(base, a termbox.Attribute) termbox.Attribute {
+	if a&0x0F != 0 {
+		base = base&^0x0F | a&0x0F
+	}
+	return base | a&^0x0F
+}
@@ -0,0 +1,111 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseANSI(t *testing.T) {
+	tests := []struct {
+		input    string
+		stripped string
+		spans    []ansiSpan
+	}{
+		{"foo", "foo", nil},
+		{"\x1b[01;34mfoo\x1b[0m bar", "foo bar", []ansiSpan{
+			{0, 3, termbox.ColorBlue | termbox.AttrBold, termbox.ColorDefault},
+		}},
+		{"a\x1b[31;42mb\x1b[39mc\x1b[mdd", "abcdd", []ansiSpan{
+			{1, 2, termbox.ColorRed, termbox.ColorGreen},
+			{2, 3, termbox.ColorDefault, termbox.ColorGreen},
+		}},
+		// grep --color clears the rest of the line with "\x1b[K"
+		{"\x1b[01;31m\x1b[Kfoo\x1b[m\x1b[K:bar", "foo:bar", []ansiSpan{
+			{0, 3, termbox.ColorRed | termbox.AttrBold, termbox.ColorDefault},
+		}},
+		{"\x1b[38;5;2mfoo\x1b[38;5;200mbar\x1b[38;2;1;2;3mbaz", "foobarbaz", []ansiSpan{
+			{0, 9, termbox.ColorGreen, termbox.ColorDefault},
+		}},
+		{"\x1b[4mfoo\x1b[24mbar", "foobar", []ansiSpan{
+			{0, 3, termbox.AttrUnderline, termbox.ColorDefault},
+		}},
+	}
+
+	for _, test := range tests {
+		stripped, spans := parseANSI(test.input)
+		if !assert.Equal(t, test.stripped, stripped, "stripped string should match for %q", test.input) {
+			return
+		}
+		if !assert.Equal(t, util.StripANSISequence(test.input), stripped, "stripped string should match StripANSISequence for %q", test.input) {
+			return
+		}
+		if !assert.Equal(t, test.spans, spans, "spans should match for %q", test.input) {
+			return
+		}
+	}
+}
+
+func TestAnsiColors(t *testing.T) {
+	state := newPeco()
+	state.ansiColors = true
+	screen := NewDummyScreen()
+	styles := NewStyleSet()
+	styles.Basic = Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault}
+	styles.Selected = Style{fg: termbox.ColorDefault, bg: termbox.ColorMagenta}
+	styles.Matched = Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault}
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 2; i++ {
+		l := line.NewRaw(uint64(i), "\x1b[31;44mfoo\x1b[0mbar", false)
+		buf.lines = append(buf.lines, line.NewMatched(l, [][]int{{1, 2}}))
+	}
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(2)
+	loc.SetPage(1)
+	loc.SetLineNumber(0)
+
+	screen.interceptor.reset()
+	list := NewListArea(screen, AnchorTop, 0, true, styles)
+	list.Draw(state, nil, 2, &DrawOptions{DisableCache: true})
+
+	type cell struct {
+		ch     rune
+		fg, bg termbox.Attribute
+	}
+	cellAt := func(x, y int) cell {
+		var c cell
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[0].(int) == x && ev[1].(int) == y {
+				c = cell{ev[2].(rune), ev[3].(termbox.Attribute), ev[4].(termbox.Attribute)}
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		x, y     int
+		expected cell
+	}{
+		// The cursor line keeps the background of the selection
+		{0, 0, cell{'f', termbox.ColorRed, termbox.ColorMagenta}},
+		{1, 0, cell{'o', termbox.ColorCyan, termbox.ColorMagenta}},
+		{3, 0, cell{'b', termbox.ColorDefault, termbox.ColorMagenta}},
+		{0, 1, cell{'f', termbox.ColorRed, termbox.ColorBlue}},
+		// Matched characters are drawn with the Matched style
+		{1, 1, cell{'o', termbox.ColorCyan, termbox.ColorDefault}},
+		{2, 1, cell{'o', termbox.ColorRed, termbox.ColorBlue}},
+		{3, 1, cell{'b', termbox.ColorDefault, termbox.ColorDefault}},
+		{6, 1, cell{' ', termbox.ColorDefault, termbox.ColorDefault}},
+	}
+	for _, test := range tests {
+		if !assert.Equal(t, test.expected, cellAt(test.x, test.y), "cell at %d, %d should match", test.x, test.y) {
+			return
+		}
+	}
+}
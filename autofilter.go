@@ -0,0 +1,97 @@
+package peco
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	autoFilterWhenRegexp    = "regexp"
+	autoFilterWhenUppercase = "uppercase"
+)
+
+// regexpMetaChars are the characters that make a query look like a
+// regular expression. The dot is left out, as it is common in file
+// names
+const regexpMetaChars = `\^$*+?()[]{}|`
+
+var autoFilterConditions = map[string]func(string) bool{
+	autoFilterWhenRegexp:    looksLikeRegexp,
+	autoFilterWhenUppercase: hasUpper,
+}
+
+// IsValidAutoFilterCondition checks if v is the name of a built-in test
+// for AutoFilter rules. The empty string does not test anything
+func IsValidAutoFilterCondition(v string) bool {
+	if v == "" {
+		return true
+	}
+	_, ok := autoFilterConditions[v]
+	return ok
+}
+
+// looksLikeRegexp returns true if the query contains characters that
+// have a special meaning in regular expressions, and is a valid one
+func looksLikeRegexp(query string) bool {
+	if !strings.ContainsAny(query, regexpMetaChars) {
+		return false
+	}
+	_, err := regexp.Compile(query)
+	return err == nil
+}
+
+func hasUpper(query string) bool {
+	return strings.IndexFunc(query, unicode.IsUpper) >= 0
+}
+
+// validate reports the problems of the rule, apart from the filter not
+// existing, which depends on the filters that are configured
+func (r AutoFilterRule) validate() error {
+	if r.Filter == "" {
+		return errors.New("no filter")
+	}
+	if r.When == "" && r.Pattern == "" {
+		return errors.New("neither when nor pattern")
+	}
+	if !IsValidAutoFilterCondition(r.When) {
+		return errors.Errorf("unknown condition %s", r.When)
+	}
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return errors.Wrap(err, "invalid pattern")
+		}
+	}
+	return nil
+}
+
+// matches returns true if the rule chooses its filter for query
+func (r AutoFilterRule) matches(query string) bool {
+	if r.When != "" {
+		cond, ok := autoFilterConditions[r.When]
+		if !ok || !cond(query) {
+			return false
+		}
+	}
+	if r.Pattern != "" {
+		rx, err := regexp.Compile(r.Pattern)
+		if err != nil || !rx.MatchString(query) {
+			return false
+		}
+	}
+	return true
+}
+
+// autoFilter returns the name of the filter chosen for query by the
+// first of the rules that matches it. The second return value is false
+// if none of them do
+func autoFilter(rules []AutoFilterRule, query string) (string, bool) {
+	for _, r := range rules {
+		if r.matches(query) {
+			return r.Filter, true
+		}
+	}
+	return "", false
+}
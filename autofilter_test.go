@@ -0,0 +1,78 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoFilter(t *testing.T) {
+	rules := []AutoFilterRule{
+		{When: "regexp", Filter: "Regexp"},
+		{When: "uppercase", Pattern: `^\S+$`, Filter: "CaseSensitive"},
+		{Pattern: `^\s`, Filter: "Fuzzy"},
+	}
+	for query, expected := range map[string]string{
+		"^foo":    "Regexp",
+		"a|b":     "Regexp",
+		"main.go": "",
+		"[a":      "",
+		"Foo":     "CaseSensitive",
+		"Foo bar": "",
+		" foo":    "Fuzzy",
+		"":        "",
+	} {
+		name, ok := autoFilter(rules, query)
+		if !assert.Equal(t, expected != "", ok, "a rule should match %q only if expected", query) {
+			return
+		}
+		if !assert.Equal(t, expected, name, "filter for %q should match", query) {
+			return
+		}
+	}
+
+	assert.NoError(t, rules[0].validate(), "valid rule should pass")
+	assert.Error(t, AutoFilterRule{When: "sometimes", Filter: "Regexp"}.validate(), "unknown condition should fail")
+	assert.Error(t, AutoFilterRule{Pattern: "(", Filter: "Regexp"}.validate(), "invalid pattern should fail")
+	assert.Error(t, AutoFilterRule{Filter: "Regexp"}.validate(), "rule without a test should fail")
+	assert.Error(t, AutoFilterRule{When: "regexp"}.validate(), "rule without a filter should fail")
+}
+
+func TestApplyConfigAutoFilter(t *testing.T) {
+	rules := []AutoFilterRule{{When: "regexp", Filter: "Regexp"}}
+
+	t.Run("matched", func(t *testing.T) {
+		p := newPeco()
+		p.config.AutoFilter = rules
+		p.config.InitialFilter = "CaseSensitive"
+		if !assert.NoError(t, p.ApplyConfig(CLIOptions{OptQuery: []string{"^foo"}}), "ApplyConfig should succeed") {
+			return
+		}
+		if !assert.Equal(t, "Regexp", p.Filters().Current().String(), "filter should be chosen by the rule") {
+			return
+		}
+
+		// The filter can still be changed afterwards
+		p.Filters().Rotate()
+		assert.NotEqual(t, "Regexp", p.Filters().Current().String(), "filter should rotate")
+	})
+
+	t.Run("not matched", func(t *testing.T) {
+		p := newPeco()
+		p.config.AutoFilter = rules
+		p.config.InitialFilter = "CaseSensitive"
+		if !assert.NoError(t, p.ApplyConfig(CLIOptions{OptQuery: []string{"foo"}}), "ApplyConfig should succeed") {
+			return
+		}
+		assert.Equal(t, "CaseSensitive", p.Filters().Current().String(), "InitialFilter should be used")
+	})
+
+	t.Run("command line", func(t *testing.T) {
+		p := newPeco()
+		p.config.AutoFilter = rules
+		if !assert.NoError(t, p.ApplyConfig(CLIOptions{OptQuery: []string{"^foo"}, OptInitialFilter: "SmartCase"}), "ApplyConfig should succeed") {
+			return
+		}
+		assert.Equal(t, "SmartCase", p.Filters().Current().String(), "filter given on the command line should be used")
+	})
+}
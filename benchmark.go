@@ -0,0 +1,176 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/hub"
+	"github.com/pkg/errors"
+)
+
+// DefaultBenchmarkIterations is the number of times --benchmark runs
+// the query, unless --benchmark-iterations says otherwise
+const DefaultBenchmarkIterations = 5
+
+// benchmarkRun is the outcome of running the query once
+type benchmarkRun struct {
+	elapsed time.Duration
+	matched int
+	mallocs uint64
+	bytes   uint64
+}
+
+// runBenchmark reads the file given to --benchmark, and runs the query
+// against its lines several times, through the same pipeline as the
+// queries typed into peco, but without the screen. The timings and the
+// memory allocated by each run are written to Stderr. The filter, the
+// query and the rest of the configuration are taken from the command
+// line and the config file as usual
+func (p *Peco) runBenchmark(ctx context.Context) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.runBenchmark %s", p.benchmarkFile).BindError(&err)
+		defer g.End()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Nothing displays the messages sent by the source and the filter
+	go drainHub(ctx, p.Hub())
+	go p.idgen.Run(ctx)
+	p.matchAllLines()
+
+	query := p.initialQuery
+	queries := p.commandLineQueries()
+	selected := p.Filters().Current()
+	if err := validateQueries(selected, queries); err != nil {
+		return err
+	}
+
+	f, err := os.Open(p.benchmarkFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file for benchmark")
+	}
+	src := NewSource(p.benchmarkFile, f, p.idgen, p.bufferSize, p.enableSep)
+	start := time.Now()
+	go src.Setup(ctx, p)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-src.SetupDone():
+	}
+	p.SetSource(src)
+	fmt.Fprintf(p.Stderr, "read %d lines from %s in %s\n", src.Size(), p.benchmarkFile, time.Since(start))
+	fmt.Fprintf(p.Stderr, "running query '%s' with filter %s %d times\n", strings.Join(queries, "' or '"), selected, p.benchmarkIterations)
+
+	var runs []benchmarkRun
+	for i := 0; i < p.benchmarkIterations; i++ {
+		// A new Filter each time, so that the results of the previous
+		// run are not reused
+		r := benchmarkQuery(ctx, NewFilter(p), query)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.matched = p.CurrentLineBuffer().Size()
+		runs = append(runs, r)
+		fmt.Fprintf(p.Stderr, "run %d: %s, %d lines matched, %.0f lines/sec, %d allocs, %d bytes\n",
+			i+1, r.elapsed, r.matched, linesPerSec(src.Size(), r.elapsed), r.mallocs, r.bytes)
+	}
+	writeBenchmarkSummary(p, runs, src.Size())
+
+	return makeIgnorable(errors.New("user asked to run benchmark"))
+}
+
+// commandLineQueries returns the queries given on the command line.
+// Like in Filter.Work, the empty query matches any of them
+func (p *Peco) commandLineQueries() []string {
+	if queries := p.MultiQuery(); len(queries) > 0 {
+		return queries
+	}
+	return []string{p.initialQuery}
+}
+
+// validateQueries makes sure that the filter can run each of the
+// queries, so that an invalid one is reported instead of just not
+// matching anything
+func validateQueries(selected filter.Filter, queries []string) error {
+	v, ok := selected.(filter.QueryValidator)
+	if !ok {
+		return nil
+	}
+	for _, q := range queries {
+		if !v.IsValidQuery(q) {
+			return errors.Errorf("invalid query '%s' for filter %s", q, selected)
+		}
+	}
+	return nil
+}
+
+// benchmarkQuery runs the query once, and measures it
+func benchmarkQuery(ctx context.Context, f *Filter, query string) benchmarkRun {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	f.Work(ctx, hub.NewPayload(query))
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	return benchmarkRun{
+		elapsed: elapsed,
+		mallocs: after.Mallocs - before.Mallocs,
+		bytes:   after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+func writeBenchmarkSummary(p *Peco, runs []benchmarkRun, lines int) {
+	if len(runs) == 0 {
+		return
+	}
+
+	var total time.Duration
+	var mallocs, bytes uint64
+	fastest := runs[0].elapsed
+	for _, r := range runs {
+		total += r.elapsed
+		mallocs += r.mallocs
+		bytes += r.bytes
+		if r.elapsed < fastest {
+			fastest = r.elapsed
+		}
+	}
+	n := uint64(len(runs))
+	avg := total / time.Duration(n)
+	fmt.Fprintf(p.Stderr, "average: %s (fastest %s), %.0f lines/sec, %d allocs, %d bytes per run\n",
+		avg, fastest, linesPerSec(lines, avg), mallocs/n, bytes/n)
+}
+
+func linesPerSec(lines int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(lines) / d.Seconds()
+}
+
+// drainHub discards the messages sent to h until ctx is canceled
+func drainHub(ctx context.Context, h MessageHub) {
+	for {
+		var p hub.Payload
+		select {
+		case <-ctx.Done():
+			return
+		case p = <-h.DrawCh():
+		case p = <-h.PagingCh():
+		case p = <-h.QueryCh():
+		case p = <-h.StatusMsgCh():
+		}
+		p.Done()
+	}
+}
@@ -0,0 +1,88 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBenchmark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-benchmark")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "input.txt")
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte("foo\nbar\nfoobar\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	run := func(args ...string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		p := newPeco()
+		p.Argv = append([]string{"peco", "--benchmark", filename}, args...)
+		p.Stderr = &stderr
+		p.Stdout = &bytes.Buffer{}
+		err := p.Run(ctx)
+		return stderr.String(), err
+	}
+
+	report, err := run("--query", "foo", "--benchmark-iterations", "2")
+	if !assert.True(t, util.IsIgnorableError(err), "Run should exit without an error") {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(report), "\n")
+	if !assert.Len(t, lines, 5, "report should have a line for each run") {
+		return
+	}
+	assert.True(t, strings.HasPrefix(lines[0], "read 3 lines from "+filename+" in "), "number of lines read should be reported")
+	assert.Equal(t, "running query 'foo' with filter IgnoreCase 2 times", lines[1], "query should be reported")
+	for _, l := range lines[2:4] {
+		assert.Contains(t, l, "2 lines matched", "each run should match the lines")
+	}
+	assert.True(t, strings.HasPrefix(lines[4], "average: "), "summary should be reported")
+
+	_, err = run("--query", "a(", "--initial-filter", "Regexp")
+	assert.False(t, util.IsIgnorableError(err), "invalid query should fail")
+}
+
+func TestBenchmarkAllLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-benchmark")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "input.txt")
+	input := strings.Repeat("line\n", 500)
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte(input), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	p := newPeco()
+	p.Argv = []string{"peco", "--benchmark", filename, "--query", "line", "--benchmark-iterations", "1"}
+	p.Stderr = &stderr
+	p.Stdout = &bytes.Buffer{}
+	p.config.LazyFilter = true
+	p.config.MaxResults = 10
+	if err := p.Run(ctx); !assert.True(t, util.IsIgnorableError(err), "Run should exit without an error") {
+		return
+	}
+	assert.Contains(t, stderr.String(), "500 lines matched", "all of the matching lines should be counted")
+}
@@ -1,24 +1,31 @@
 package peco
 
 import (
+	"container/heap"
+	"sort"
 	"time"
 
 	"context"
 
 	"github.com/lestrrat/go-pdebug"
 	runewidth "github.com/mattn/go-runewidth"
+	"github.com/peco/peco/filter"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
 	"github.com/pkg/errors"
 )
 
 func NewFilteredBuffer(src Buffer, page, perPage int) *FilteredBuffer {
+	return newFilteredBufferAt(src, perPage*(page-1), perPage)
+}
+
+// newFilteredBufferAt is like NewFilteredBuffer, but the range starts
+// at the given line instead of the beginning of a page
+func newFilteredBufferAt(src Buffer, start, perPage int) *FilteredBuffer {
 	fb := FilteredBuffer{
 		src: src,
 	}
 
-	start := perPage * (page - 1)
-
 	// if for whatever reason we wanted a page that goes over the
 	// capacity of the original buffer, we don't need to do any more
 	// calculations. bail out
@@ -69,6 +76,35 @@ func (flb FilteredBuffer) Size() int {
 	return len(flb.selection)
 }
 
+func newReversedBuffer(src Buffer) *reversedBuffer {
+	return &reversedBuffer{src: src}
+}
+
+// LineAt returns the line at index `i`, counting from the last line
+// of the source buffer
+func (rb *reversedBuffer) LineAt(i int) (line.Line, error) {
+	n := rb.src.Size() - 1 - i
+	if i < 0 || n < 0 {
+		return nil, errors.Errorf("specified index %d is out of range", i)
+	}
+	return rb.src.LineAt(n)
+}
+
+// Size returns the number of lines in the buffer
+func (rb *reversedBuffer) Size() int {
+	return rb.src.Size()
+}
+
+func (rb *reversedBuffer) linesInRange(start, end int) []line.Line {
+	size := rb.src.Size()
+	lines := rb.src.linesInRange(size-end, size-start)
+	reversed := make([]line.Line, len(lines))
+	for i, l := range lines {
+		reversed[len(lines)-1-i] = l
+	}
+	return reversed
+}
+
 func NewMemoryBuffer() *MemoryBuffer {
 	mb := &MemoryBuffer{}
 	mb.Reset()
@@ -94,6 +130,43 @@ func (mb *MemoryBuffer) Reset() {
 	}
 	mb.done = make(chan struct{})
 	mb.lines = []line.Line(nil)
+	mb.changed = false
+	mb.partial = false
+	mb.ranked = rankedLines{}
+	mb.rankPending = false
+	mb.yieldCh = make(chan struct{}, 1)
+}
+
+// takeChanged returns true if the lines changed since it was last
+// called, and forgets about the changes. Ranked lines are ordered by
+// their scores first, as the lines are about to be displayed
+func (mb *MemoryBuffer) takeChanged() bool {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.orderRanked()
+	changed := mb.changed
+	mb.changed = false
+	return changed
+}
+
+// Yield makes the lines received so far available, even if they are
+// not all there yet. See pipeline.Yielder
+func (mb *MemoryBuffer) Yield() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.orderRanked()
+	select {
+	case mb.yieldCh <- struct{}{}:
+	default:
+	}
+}
+
+// Partial returns true if the lines were made available by Yield
+// before all of them were received
+func (mb *MemoryBuffer) Partial() bool {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+	return mb.partial
 }
 
 func (mb *MemoryBuffer) Done() <-chan struct{} {
@@ -113,6 +186,18 @@ func (mb *MemoryBuffer) Accept(ctx context.Context, in chan interface{}, _ pipel
 		mb.mutex.Unlock()
 	}()
 
+	// When the lines are sorted, they can't be displayed until all of
+	// them have been received, so they are kept aside till the end,
+	// unless the pipeline asks for what we have so far. The same goes
+	// for StableOrder, as the ranked lines arrive in the order of their
+	// scores
+	pending := rankedLines{capacity: mb.capacity}
+	sorted := isSorted(mb.sortMode) || mb.stableOrder
+
+	mb.mutex.RLock()
+	yield := mb.yieldCh
+	mb.mutex.RUnlock()
+
 	start := time.Now()
 	for {
 		select {
@@ -121,24 +206,182 @@ func (mb *MemoryBuffer) Accept(ctx context.Context, in chan interface{}, _ pipel
 				pdebug.Printf("MemoryBuffer received context done")
 			}
 			return
-		case v := <-in:
+		case <-yield:
+			var lines []line.Line
+			if sorted {
+				lines = pending.sorted()
+				mb.sortLines(lines)
+			}
+			mb.mutex.Lock()
+			if sorted {
+				mb.lines = lines
+				mb.changed = true
+			}
+			mb.partial = true
+			mb.mutex.Unlock()
+		case v, ok := <-in:
+			if !ok {
+				v = pipeline.EndMark{}
+			}
+			// Lines ranked by the filter are kept as line.Scored, so
+			// that they are ordered by their score
+			if l, ok := filter.RankedLine(v); ok {
+				v = l
+			}
 			switch v.(type) {
 			case error:
 				if pipeline.IsEndMark(v.(error)) {
 					if pdebug.Enabled {
-						pdebug.Printf("MemoryBuffer received end mark (read %d lines, %s since starting accept loop)", len(mb.lines), time.Since(start).String())
+						pdebug.Printf("MemoryBuffer received end mark (read %d lines, %s since starting accept loop)", mb.Size()+len(pending.lines), time.Since(start).String())
 					}
+					var lines []line.Line
+					if sorted {
+						lines = pending.sorted()
+						mb.sortLines(lines)
+					}
+					mb.mutex.Lock()
+					if sorted {
+						mb.lines = lines
+						mb.changed = true
+					}
+					mb.orderRanked()
+					mb.partial = false
+					mb.mutex.Unlock()
 					return
 				}
 			case line.Line:
-				mb.mutex.Lock()
-				mb.lines = append(mb.lines, v.(line.Line))
-				mb.mutex.Unlock()
+				if sorted {
+					pending.add(v.(line.Line))
+					continue
+				}
+				mb.appendLine(v.(line.Line))
 			}
 		}
 	}
 }
 
+// appendLine adds l to the lines, as if it was received by Accept.
+// Once a ranked line is received, the lines are collected aside, and
+// only displayed when orderRanked puts them in the order of their
+// scores
+func (mb *MemoryBuffer) appendLine(l line.Line) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.changed = true
+	if _, ok := l.(*line.Scored); !ok && !mb.ranked.ranked {
+		mb.lines = trimLines(append(mb.lines, l), mb.capacity)
+		return
+	}
+	if !mb.ranked.ranked {
+		mb.ranked = rankedLines{capacity: mb.capacity}
+		for _, l := range mb.lines {
+			mb.ranked.add(l)
+		}
+	}
+	mb.ranked.add(l)
+	mb.rankPending = true
+}
+
+// rankLines puts the ranked lines received by appendLine in the order
+// of their scores
+func (mb *MemoryBuffer) rankLines() {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.orderRanked()
+}
+
+// orderRanked is rankLines, for when the mutex is already held
+func (mb *MemoryBuffer) orderRanked() {
+	if !mb.rankPending {
+		return
+	}
+	mb.lines = mb.ranked.sorted()
+	mb.rankPending = false
+}
+
+// trimLines discards the oldest lines, so that no more than capacity
+// lines are kept. capacity <= 0 means no limit
+func trimLines(lines []line.Line, capacity int) []line.Line {
+	if capacity <= 0 || len(lines) <= capacity {
+		return lines
+	}
+	return lines[len(lines)-capacity:]
+}
+
+// rankedBefore returns true if a ranks before b. Lines with a higher
+// score come first, and lines with the same score are kept in the
+// order they were read from the input. Lines without a score rank
+// after all of those with one
+func rankedBefore(a, b line.Line) bool {
+	as, aok := a.(*line.Scored)
+	bs, bok := b.(*line.Scored)
+	if aok != bok {
+		return aok
+	}
+	if aok && as.Score() != bs.Score() {
+		return as.Score() > bs.Score()
+	}
+	return a.ID() < b.ID()
+}
+
+// add adds l to the lines collected
+func (rl *rankedLines) add(l line.Line) {
+	if _, ok := l.(*line.Scored); ok && !rl.ranked {
+		rl.ranked = true
+		if rl.capacity > 0 {
+			heap.Init(rl)
+		}
+	}
+
+	switch {
+	case rl.capacity <= 0:
+		rl.lines = append(rl.lines, l)
+	case !rl.ranked:
+		rl.lines = trimLines(append(rl.lines, l), rl.capacity)
+	case len(rl.lines) < rl.capacity:
+		heap.Push(rl, l)
+	case rankedBefore(l, rl.lines[0]):
+		rl.lines[0] = l
+		heap.Fix(rl, 0)
+	}
+}
+
+// sorted returns a copy of the lines collected, in the order they were
+// received, or in the order of their scores once they are ranked
+func (rl *rankedLines) sorted() []line.Line {
+	lines := make([]line.Line, len(rl.lines))
+	copy(lines, rl.lines)
+	if rl.ranked {
+		sort.SliceStable(lines, func(i, j int) bool {
+			return rankedBefore(lines[i], lines[j])
+		})
+	}
+	return lines
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface, so that the
+// lowest ranked line is at the top of the heap
+func (rl *rankedLines) Len() int { return len(rl.lines) }
+
+func (rl *rankedLines) Less(i, j int) bool {
+	return rankedBefore(rl.lines[j], rl.lines[i])
+}
+
+func (rl *rankedLines) Swap(i, j int) {
+	rl.lines[i], rl.lines[j] = rl.lines[j], rl.lines[i]
+}
+
+func (rl *rankedLines) Push(x interface{}) {
+	rl.lines = append(rl.lines, x.(line.Line))
+}
+
+func (rl *rankedLines) Pop() interface{} {
+	n := len(rl.lines) - 1
+	l := rl.lines[n]
+	rl.lines = rl.lines[:n]
+	return l
+}
+
 func (mb *MemoryBuffer) LineAt(n int) (line.Line, error) {
 	mb.mutex.RLock()
 	defer mb.mutex.RUnlock()
@@ -16,7 +16,7 @@ func main() {
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error:\n%s", err)
-			os.Exit(1)
+			os.Exit(peco.ExitStatusError)
 		}
 	}()
 	os.Exit(_main())
@@ -37,18 +37,21 @@ func _main() int {
 		switch {
 		case util.IsCollectResultsError(err):
 			cli.PrintResults()
-			return 0
+			if st, ok := util.GetExitStatus(err); ok {
+				return st
+			}
+			return peco.ExitStatusSuccess
 		case util.IsIgnorableError(err):
 			if st, ok := util.GetExitStatus(err); ok {
 				return st
 			}
-			return 0
+			return peco.ExitStatusSuccess
 		default:
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-			return 1
+			return peco.ExitStatusError
 		}
 
 	}
 
-	return 0
+	return peco.ExitStatusSuccess
 }
@@ -0,0 +1,78 @@
+package peco
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+)
+
+// doCompleteCommonPrefix extends the query to the longest prefix that
+// the matched lines share, like the completion of a shell. The query
+// must be a prefix of the lines for that, ignoring case, so that the
+// completion continues from what was typed. If there is nothing to add,
+// the status bar says so instead
+func doCompleteCommonPrefix(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doCompleteCommonPrefix")
+		defer g.End()
+	}
+
+	// The lines that are yet to be matched may not share the prefix
+	if state.FilterRunning() {
+		state.Hub().SendStatusMsgAndClear("Wait for the query to complete", time.Second)
+		return
+	}
+
+	buf := state.CurrentLineBuffer()
+	if buf.Size() == 0 {
+		state.Hub().SendStatusMsgAndClear("No lines to complete from", time.Second)
+		return
+	}
+
+	// The flags at the start of the query are kept as they are
+	query := state.Query().String()
+	_, q, _ := state.queryFilter(state.Filters().Current(), query)
+
+	prefix := commonPrefix(buf.linesInRange(0, buf.Size()))
+	if len(prefix) < len(q) || !strings.EqualFold(prefix[:len(q)], q) {
+		state.Hub().SendStatusMsgAndClear("No common prefix to complete", time.Second)
+		return
+	}
+	if len(prefix) == len(q) {
+		state.Hub().SendStatusMsgAndClear("Nothing to complete", time.Second)
+		return
+	}
+	setQuery(state, query+prefix[len(q):])
+}
+
+// commonPrefix returns the longest prefix of the text that the lines
+// are matched against, which all of them share. The prefix never ends
+// in the middle of a character
+func commonPrefix(lines []line.Line) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	prefix := line.MatchString(lines[0])
+	for _, l := range lines[1:] {
+		s := line.MatchString(l)
+		n := 0
+		for n < len(prefix) && n < len(s) && prefix[n] == s[n] {
+			n++
+		}
+		prefix = prefix[:n]
+		if prefix == "" {
+			break
+		}
+	}
+
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
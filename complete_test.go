@@ -0,0 +1,71 @@
+package peco
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommonPrefix(t *testing.T) {
+	newLines := func(texts ...string) []line.Line {
+		var lines []line.Line
+		for i, s := range texts {
+			lines = append(lines, line.NewRaw(uint64(i), s, false))
+		}
+		return lines
+	}
+
+	assert.Equal(t, "", commonPrefix(nil), "no lines have no prefix")
+	assert.Equal(t, "src/foo", commonPrefix(newLines("src/foo")), "a single line is its own prefix")
+	assert.Equal(t, "src/foo/", commonPrefix(newLines("src/foo/a.go", "src/foo/b.go", "src/foo/")), "prefix should match")
+	assert.Equal(t, "", commonPrefix(newLines("src/foo", "lib/foo")), "lines with nothing in common have no prefix")
+	// "é" and "è" share their first byte
+	assert.Equal(t, "caf", commonPrefix(newLines("café", "cafè")), "prefix should not split a character")
+}
+
+func TestDoCompleteCommonPrefix(t *testing.T) {
+	state := newPeco()
+	h := &statusHub{}
+	state.hub = h
+	if !assert.NoError(t, state.populateFilters(), "populateFilters should succeed") {
+		return
+	}
+
+	setMatches := func(texts ...string) {
+		buf := NewMemoryBuffer()
+		for i, s := range texts {
+			buf.lines = append(buf.lines, line.NewRaw(uint64(i), s, false))
+		}
+		state.currentLineBuffer = buf
+	}
+	complete := func(query string) string {
+		state.Query().Set(query)
+		doCompleteCommonPrefix(context.Background(), state, termbox.Event{})
+		return state.Query().String()
+	}
+
+	setMatches("src/peco/a.go", "src/peco/b.go")
+	assert.Equal(t, "src/peco/", complete("src"), "query should be extended to the common prefix")
+	assert.Empty(t, h.msgs, "nothing should be reported")
+
+	// The case of the query is kept
+	assert.Equal(t, "SRC/peco/", complete("SRC"), "query should be extended ignoring case")
+
+	assert.Equal(t, "src/peco/", complete("src/peco/"), "query should not change")
+	assert.Equal(t, []string{"Nothing to complete"}, h.msgs, "lack of completion should be reported")
+
+	h.msgs = nil
+	assert.Equal(t, "peco", complete("peco"), "query should not change")
+	assert.Equal(t, []string{"No common prefix to complete"}, h.msgs, "query that is not a prefix should be reported")
+
+	h.msgs = nil
+	state.config.QueryFlags = true
+	assert.Equal(t, "-s src/peco/", complete("-s src"), "flags should be kept")
+
+	setMatches()
+	assert.Equal(t, "src", complete("src"), "query should not change")
+	assert.Equal(t, []string{"No lines to complete from"}, h.msgs, "lack of lines should be reported")
+}
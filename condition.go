@@ -0,0 +1,122 @@
+package peco
+
+import (
+	"context"
+	"sort"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/keyseq"
+	"github.com/pkg/errors"
+)
+
+// keyConditions maps the names that can be given to When in the
+// ConditionalKeymap to the checks that they stand for
+var keyConditions = map[string]func(*Peco) bool{
+	"selection_empty": func(state *Peco) bool {
+		return state.Selection().Len() == 0
+	},
+	"query_empty": func(state *Peco) bool {
+		return state.Query().Len() == 0
+	},
+	"results_empty": func(state *Peco) bool {
+		return state.CurrentLineBuffer().Size() == 0
+	},
+}
+
+// IsValidKeyCondition checks if a string is the name of a condition
+// that can be used in the ConditionalKeymap
+func IsValidKeyCondition(v string) bool {
+	_, ok := keyConditions[v]
+	return ok
+}
+
+// conditionalBinding is an action of the ConditionalKeymap, along
+// with the condition that it is executed under
+type conditionalBinding struct {
+	name   string
+	when   func(*Peco) bool
+	action Action
+}
+
+// resolveConditionalBinding returns the binding for c
+func (km Keymap) resolveConditionalBinding(c ConditionalKeyConfig) (conditionalBinding, error) {
+	when, ok := keyConditions[c.When]
+	if !ok {
+		return conditionalBinding{}, errors.Errorf("unknown condition %s", c.When)
+	}
+	a, err := km.resolveActionName(c.Action, 0)
+	if err != nil {
+		return conditionalBinding{}, errors.Wrapf(err, "failed to resolve action name %s", c.Action)
+	}
+	return conditionalBinding{name: c.Action, when: when, action: a}, nil
+}
+
+// applyConditionalBindings replaces the actions in kb of the keys that
+// have conditional bindings with actions that choose between them
+// when the key is typed. The first binding whose condition holds is
+// executed. If there is none, the key does what kb says, or types the
+// character if kb has nothing for it
+func (km Keymap) applyConditionalBindings(kb map[string]Action) error {
+	var keys []string
+	bindings := map[string][]conditionalBinding{}
+	for _, c := range km.Conditional {
+		list, err := keyseq.ToKeyList(c.Key)
+		if err != nil {
+			return errors.Wrapf(err, "unknown key %s", c.Key)
+		}
+		b, err := km.resolveConditionalBinding(c)
+		if err != nil {
+			return errors.Wrapf(err, "invalid conditional binding for %s", c.Key)
+		}
+
+		// Keys have several names, such as "Enter" and "C-m"
+		k := list.String()
+		if _, ok := bindings[k]; !ok {
+			keys = append(keys, k)
+		}
+		bindings[k] = append(bindings[k], b)
+	}
+
+	for _, k := range keys {
+		var names []string
+		for s := range kb {
+			if list, err := keyseq.ToKeyList(s); err == nil && list.String() == k {
+				names = append(names, s)
+			}
+		}
+		sort.Strings(names)
+
+		// The Keymap takes precedence over the default key bindings
+		var fallback Action = ActionFunc(doAcceptChar)
+		for _, s := range names {
+			fallback = kb[s]
+			if _, ok := km.Config[s]; ok {
+				break
+			}
+		}
+		for _, s := range names {
+			delete(kb, s)
+		}
+		kb[k] = makeConditionalAction(bindings[k], fallback)
+	}
+	return nil
+}
+
+// makeConditionalAction creates an action that executes the first of
+// bindings whose condition holds, or fallback if none of them hold
+func makeConditionalAction(bindings []conditionalBinding, fallback Action) ActionFunc {
+	return ActionFunc(func(ctx context.Context, state *Peco, e termbox.Event) {
+		for _, b := range bindings {
+			if !b.when(state) {
+				continue
+			}
+			if pdebug.Enabled {
+				pdebug.Printf("Executing %s, as its condition holds", b.name)
+			}
+			b.action.Execute(ctx, state, e)
+			return
+		}
+		fallback.Execute(ctx, state, e)
+	})
+}
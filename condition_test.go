@@ -0,0 +1,80 @@
+package peco
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionalKeymap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := newPeco()
+	state.hub = batchHub{}
+	state.Query().Set("ab")
+	state.Caret().SetPos(2)
+
+	km := NewKeymap(map[string]string{"C-m": "peco.EndOfLine"}, nil, nil)
+	km.Conditional = []ConditionalKeyConfig{
+		{Key: "Enter", When: "selection_empty", Action: "peco.BeginningOfLine"},
+		{Key: "x", When: "query_empty", Action: "peco.BeginningOfLine"},
+	}
+	if !assert.NoError(t, km.ApplyKeybinding(), "ApplyKeybinding should succeed") {
+		return
+	}
+
+	type key struct {
+		ev       termbox.Event
+		expected int
+		query    string
+	}
+	press := func(keys ...key) bool {
+		for i, k := range keys {
+			if !assert.NoError(t, km.ExecuteAction(ctx, state, k.ev), "ExecuteAction should succeed") {
+				return false
+			}
+			if !assert.Equal(t, k.expected, state.Caret().Pos(), "caret position after key %d should match", i) {
+				return false
+			}
+			if !assert.Equal(t, k.query, state.Query().String(), "query after key %d should match", i) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !press(key{termbox.Event{Key: termbox.KeyEnter}, 0, "ab"}) {
+		return
+	}
+
+	// Without the condition, the key does what the Keymap says
+	state.Selection().Add(line.NewRaw(0, "foo", false))
+	if !press(key{termbox.Event{Key: termbox.KeyEnter}, 2, "ab"}) {
+		return
+	}
+
+	// Or types the character, if the Keymap has nothing for it
+	if !press(key{termbox.Event{Ch: 'x'}, 3, "abx"}) {
+		return
+	}
+	state.Query().Reset()
+	state.Caret().SetPos(0)
+	press(key{termbox.Event{Ch: 'x'}, 0, ""})
+}
+
+func TestConditionalKeymapInvalid(t *testing.T) {
+	km := NewKeymap(nil, nil, nil)
+	km.Conditional = []ConditionalKeyConfig{
+		{Key: "C-k", When: "never", Action: "peco.BeginningOfLine"},
+	}
+	assert.Error(t, km.ApplyKeybinding(), "unknown conditions should be rejected")
+
+	km.Conditional = []ConditionalKeyConfig{
+		{Key: "C-k", When: "query_empty", Action: "peco.NoSuchAction"},
+	}
+	assert.Error(t, km.ApplyKeybinding(), "unknown actions should be rejected")
+}
@@ -3,8 +3,12 @@ package peco
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/nsf/termbox-go"
@@ -15,6 +19,10 @@ import (
 
 var homedirFunc = util.Homedir
 
+// projectConfigFilename is the name of the config file that is read
+// from the current directory, if ProjectConfig is enabled
+const projectConfigFilename = ".peco.json"
+
 // NewConfig creates a new Config
 func (c *Config) Init() error {
 	c.Keymap = make(map[string]string)
@@ -26,26 +34,39 @@ func (c *Config) Init() error {
 }
 
 // ReadFilename reads the config from the given file, and
-// does the appropriate processing, if any
+// does the appropriate processing, if any. The values in the file are
+// merged into c: keys that the file does not mention keep their values,
+// and the entries of maps such as Keymap are replaced one by one, so
+// that files can be read on top of each other
 func (c *Config) ReadFilename(filename string) error {
-	f, err := os.Open(filename)
+	buf, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open file %s", filename)
 	}
-	defer f.Close()
 
-	err = json.NewDecoder(f).Decode(c)
+	// Only the CustomMatcher of this file is converted below
+	c.CustomMatcher = nil
+	err = json.Unmarshal(buf, c)
 	if err != nil {
 		return errors.Wrap(err, "failed to decode JSON")
 	}
 
-	if !IsValidLayoutType(LayoutType(c.Layout)) {
-		return errors.Errorf("invalid layout type: %s", c.Layout)
+	if errs := c.problems(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	for _, key := range unknownConfigKeys("", buf, reflect.TypeOf(*c)) {
+		c.warnings = append(c.warnings, fmt.Sprintf("Unknown key '%s' in %s is ignored", key, filename))
 	}
 
+	c.warnings = append(c.warnings, c.expandPaths(filename)...)
+
 	if len(c.CustomMatcher) > 0 {
-		fmt.Fprintf(os.Stderr, "'CustomMatcher' is deprecated. Use CustomFilter instead\n")
+		c.warnings = append(c.warnings, "'CustomMatcher' is deprecated. Use CustomFilter instead")
 
+		if c.CustomFilter == nil {
+			c.CustomFilter = make(map[string]CustomFilterConfig)
+		}
 		for n, cfg := range c.CustomMatcher {
 			if _, ok := c.CustomFilter[n]; ok {
 				return errors.Errorf("failed to create CustomFilter: '%s' already exists. Refusing to overwrite with deprecated CustomMatcher config", n)
@@ -57,11 +78,70 @@ func (c *Config) ReadFilename(filename string) error {
 				BufferThreshold: filter.DefaultCustomFilterBufferThreshold,
 			}
 		}
+		c.CustomMatcher = nil
 	}
 
 	return nil
 }
 
+// Warnings returns the problems found in the files read by
+// ReadFilename that did not keep them from being read, such as unknown
+// keys, and forgets about them
+func (c *Config) Warnings() []string {
+	warnings := c.warnings
+	c.warnings = nil
+	return warnings
+}
+
+// expandPaths expands the environment variables and the leading ~ in
+// the values that are names of files. Variables that are not set
+// expand to an empty string, and are returned as warnings. The values
+// are expanded into copies, which are only written back if the
+// expansion changed them
+func (c *Config) expandPaths(filename string) []string {
+	paths := []struct {
+		key   string
+		value *string
+	}{
+		{"ActionLog", &c.ActionLog},
+		{"FrecencyFile", &c.FrecencyFile},
+		{"SessionFile", &c.SessionFile},
+		{"WriteQueryTo", &c.WriteQueryTo},
+	}
+	var warnings []string
+	for _, p := range paths {
+		v, unset := expandPath(*p.value)
+		for _, name := range unset {
+			warnings = append(warnings, fmt.Sprintf("Variable '%s' in %s of %s is not set", name, p.key, filename))
+		}
+		if v != *p.value {
+			*p.value = v
+		}
+	}
+	return warnings
+}
+
+// expandPath expands $VAR and ${VAR} in s, as well as ~ at the start of
+// it, which stands for the home directory. It also returns the names of
+// the variables that are not set
+func expandPath(s string) (string, []string) {
+	var unset []string
+	s = os.Expand(s, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			unset = append(unset, name)
+		}
+		return v
+	})
+
+	if s == "~" || strings.HasPrefix(s, "~/") || strings.HasPrefix(s, "~"+string(filepath.Separator)) {
+		if home, err := homedirFunc(); err == nil {
+			s = home + s[1:]
+		}
+	}
+	return s, unset
+}
+
 var (
 	stringToFg = map[string]termbox.Attribute{
 		"default": termbox.ColorDefault,
@@ -113,6 +193,209 @@ func (ss *StyleSet) Init() {
 	ss.SavedSelection.bg = termbox.ColorCyan
 	ss.Selected.fg = termbox.ColorDefault | termbox.AttrUnderline
 	ss.Selected.bg = termbox.ColorMagenta
+	ss.FilterName.fg = termbox.ColorDefault
+	ss.FilterName.bg = termbox.ColorDefault
+}
+
+// problems checks the values that can be checked without setting up
+// peco, and returns an error for each invalid value
+func (c *Config) problems() []error {
+	var errs []error
+	if !IsValidLayoutType(LayoutType(c.Layout)) {
+		errs = append(errs, errors.Errorf("invalid layout type: %s", c.Layout))
+	}
+
+	if !IsValidPosition(c.PromptPosition) {
+		errs = append(errs, errors.Errorf("invalid prompt position: %s", c.PromptPosition))
+	}
+
+	if !IsValidPosition(c.StatusPosition) {
+		errs = append(errs, errors.Errorf("invalid status position: %s", c.StatusPosition))
+	}
+
+	if !IsValidScrollMode(c.ScrollMode) {
+		errs = append(errs, errors.Errorf("invalid scroll mode: %s", c.ScrollMode))
+	}
+
+	if !IsValidSortMode(c.Sort) {
+		errs = append(errs, errors.Errorf("invalid sort mode: %s", c.Sort))
+	}
+
+	if c.Sort == SortFrecency && c.FrecencyFile == "" {
+		errs = append(errs, errors.New("Sort frecency requires FrecencyFile"))
+	}
+
+	if !IsValidResetQueryMode(c.ResetQueryOnFilterChange) {
+		errs = append(errs, errors.Errorf("invalid ResetQueryOnFilterChange: %s", c.ResetQueryOnFilterChange))
+	}
+
+	if !IsValidEmptyInputBehavior(c.EmptyInputBehavior) {
+		errs = append(errs, errors.Errorf("invalid EmptyInputBehavior: %s", c.EmptyInputBehavior))
+	}
+
+	if !IsValidNoMatchAccept(c.NoMatchAccept) {
+		errs = append(errs, errors.Errorf("invalid NoMatchAccept: %s", c.NoMatchAccept))
+	}
+
+	if c.HeaderLines < 0 {
+		errs = append(errs, errors.Errorf("invalid HeaderLines: %d", c.HeaderLines))
+	}
+
+	if c.MaxIngestRate < 0 {
+		errs = append(errs, errors.Errorf("invalid MaxIngestRate: %d", c.MaxIngestRate))
+	}
+
+	if !IsValidIngestOverflow(c.IngestOverflow) {
+		errs = append(errs, errors.Errorf("invalid IngestOverflow: %s", c.IngestOverflow))
+	}
+
+	if c.MaxResults < 0 {
+		errs = append(errs, errors.Errorf("invalid MaxResults: %d", c.MaxResults))
+	}
+
+	if err := c.MaxHeight.validate(); err != nil {
+		errs = append(errs, errors.Wrap(err, "invalid MaxHeight"))
+	}
+
+	if err := c.InitialIndex.validate(); err != nil {
+		errs = append(errs, errors.Wrap(err, "invalid InitialIndex"))
+	}
+
+	if c.MaxSelection < 0 {
+		errs = append(errs, errors.Errorf("invalid MaxSelection: %d", c.MaxSelection))
+	}
+
+	if !IsValidPreviewPosition(c.Preview.Position) {
+		errs = append(errs, errors.Errorf("invalid preview position: %s", c.Preview.Position))
+	}
+
+	if !IsValidSpinnerPosition(c.Spinner.Position) {
+		errs = append(errs, errors.Errorf("invalid spinner position: %s", c.Spinner.Position))
+	}
+
+	if !IsValidFuzzyAnchor(c.FuzzyAnchor) {
+		errs = append(errs, errors.Errorf("invalid fuzzy anchor: %s", c.FuzzyAnchor))
+	}
+
+	if !IsValidTruncateSide(c.TruncateSide) {
+		errs = append(errs, errors.Errorf("invalid truncate side: %s", c.TruncateSide))
+	}
+
+	if !IsValidMatchColumnOutput(c.MatchColumn.Output) {
+		errs = append(errs, errors.Errorf("invalid match column output: %s", c.MatchColumn.Output))
+	}
+
+	errs = append(errs, c.macroProblems()...)
+
+	for _, k := range c.ConditionalKeymap {
+		if !IsValidKeyCondition(k.When) {
+			errs = append(errs, errors.Errorf("invalid condition for %s in ConditionalKeymap: %s", k.Key, k.When))
+		}
+	}
+
+	if v := c.GroupPattern; v != "" {
+		if _, err := regexp.Compile(v); err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid GroupPattern"))
+		}
+	}
+
+	if v := c.SplitPattern; v != "" {
+		if _, err := regexp.Compile(v); err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid SplitPattern"))
+		}
+	}
+
+	if c.RecordSeparator != "" {
+		if c.SplitPattern != "" {
+			errs = append(errs, errors.New("RecordSeparator cannot be used with SplitPattern"))
+		}
+		if c.MatchColumn.Delimiter != "" {
+			errs = append(errs, errors.New("RecordSeparator cannot be used with MatchColumn"))
+		}
+	} else if c.RecordSummary != "" {
+		errs = append(errs, errors.New("RecordSummary requires RecordSeparator"))
+	}
+
+	if v := c.LineTransform.Pattern; v != "" {
+		if _, err := regexp.Compile(v); err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid LineTransform pattern"))
+		}
+	}
+
+	if !IsValidLineTransformOutput(c.LineTransform.Output) {
+		errs = append(errs, errors.Errorf("invalid LineTransform output: %s", c.LineTransform.Output))
+	}
+
+	if c.OutputCaptureGroup < 0 {
+		errs = append(errs, errors.Errorf("invalid OutputCaptureGroup: %d", c.OutputCaptureGroup))
+	}
+
+	if c.TabWidth < 0 {
+		errs = append(errs, errors.Errorf("invalid TabWidth: %d", c.TabWidth))
+	}
+
+	if c.ConfirmTimeout < 0 {
+		errs = append(errs, errors.Errorf("invalid ConfirmTimeout: %d", c.ConfirmTimeout))
+	}
+
+	for name, f := range c.CustomFilter {
+		if f.Highlight && f.HighlightFd {
+			errs = append(errs, errors.Errorf("CustomFilter %s cannot set both Highlight and HighlightFd", name))
+		}
+		if f.Timeout < 0 {
+			errs = append(errs, errors.Errorf("invalid Timeout for CustomFilter %s: %d", name, f.Timeout))
+		}
+		if f.ExecThreshold < 0 {
+			errs = append(errs, errors.Errorf("invalid ExecThreshold for CustomFilter %s: %d", name, f.ExecThreshold))
+		}
+		if f.ExecThreshold > 0 && f.InternalFilter == "" {
+			errs = append(errs, errors.Errorf("CustomFilter %s cannot set ExecThreshold without InternalFilter", name))
+		}
+	}
+
+	for name, f := range c.ContentFilter {
+		if f.Cmd == "" && len(f.Args) > 0 {
+			errs = append(errs, errors.Errorf("ContentFilter %s cannot set Args without Cmd", name))
+		}
+		if f.Concurrency < 0 {
+			errs = append(errs, errors.Errorf("invalid Concurrency for ContentFilter %s: %d", name, f.Concurrency))
+		}
+		if f.Timeout < 0 {
+			errs = append(errs, errors.Errorf("invalid Timeout for ContentFilter %s: %d", name, f.Timeout))
+		}
+	}
+
+	if v := c.QuickSelect.Chars; v != "" {
+		if err := validateQuickSelectChars(v); err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid QuickSelect chars"))
+		}
+	}
+
+	for name, f := range c.FuzzyFilter {
+		if f.MinContiguous < 0 {
+			errs = append(errs, errors.Errorf("invalid MinContiguous for FuzzyFilter %s: %d", name, f.MinContiguous))
+		}
+	}
+
+	for i, r := range c.AutoFilter {
+		if err := r.validate(); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid AutoFilter rule %d", i))
+		}
+	}
+	return errs
+}
+
+// highlightMode returns how the command gives the regions of the lines
+// that matched the query
+func (c CustomFilterConfig) highlightMode() filter.HighlightMode {
+	switch {
+	case c.HighlightFd:
+		return filter.HighlightFd
+	case c.Highlight:
+		return filter.HighlightPrefix
+	default:
+		return filter.HighlightNone
+	}
 }
 
 // UnmarshalJSON satisfies json.RawMessage.
@@ -124,6 +407,34 @@ func (s *Style) UnmarshalJSON(buf []byte) error {
 	return stringsToStyle(s, raw)
 }
 
+// MarshalJSON returns the style in the format that UnmarshalJSON
+// accepts, such as ["red", "on_black", "bold"]
+func (s Style) MarshalJSON() ([]byte, error) {
+	raw := []string{}
+	for name, fg := range stringToFg {
+		if fg != termbox.ColorDefault && s.fg&0x0F == fg {
+			raw = append(raw, name)
+		}
+	}
+	for name, bg := range stringToBg {
+		if bg != termbox.ColorDefault && s.bg&0x0F == bg {
+			raw = append(raw, name)
+		}
+	}
+	for name, attr := range stringToFgAttr {
+		if s.fg&attr != 0 {
+			raw = append(raw, name)
+		}
+	}
+	for name, attr := range stringToBgAttr {
+		if s.bg&attr != 0 {
+			raw = append(raw, name)
+		}
+	}
+	sort.Strings(raw)
+	return json.Marshal(raw)
+}
+
 func stringsToStyle(style *Style, raw []string) error {
 	style.fg = termbox.ColorDefault
 	style.bg = termbox.ColorDefault
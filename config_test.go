@@ -164,3 +164,131 @@ func TestLocateRcfile(t *testing.T) {
 	LocateRcfile(locater)
 
 }
+
+func TestReadConfigLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-config-")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if !assert.NoError(t, err, "os.Getwd should succeed") {
+		return
+	}
+	if !assert.NoError(t, os.Chdir(dir), "os.Chdir should succeed") {
+		return
+	}
+	defer os.Chdir(wd)
+
+	user := filepath.Join(dir, "config.json")
+	write := func(filename, txt string) bool {
+		return assert.NoError(t, ioutil.WriteFile(filename, []byte(txt), 0644), "writing %s should succeed", filename)
+	}
+	if !write(projectConfigFilename, `{
+	"Keymap": {"C-j": "peco.SelectDown"},
+	"Style": {"Query": ["red"]},
+	"Prompt": "[project]",
+	"MaxHeight": "40%"
+}`) {
+		return
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if !write(user, `{"Keymap": {"C-j": "peco.Finish", "C-k": "peco.SelectUp"}, "Prompt": "[user]"}`) {
+			return
+		}
+		var cfg Config
+		cfg.Init()
+		if !assert.NoError(t, readConfig(&cfg, user), "readConfig should succeed") {
+			return
+		}
+		assert.Equal(t, "[user]", cfg.Prompt, "project config should not be read")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		if !write(user, `{
+	"ProjectConfig": true,
+	"Keymap": {"C-j": "peco.Finish", "C-k": "peco.SelectUp"},
+	"Style": {"Matched": ["green"]},
+	"Prompt": "[user]",
+	"Layout": "bottom-up"
+}`) {
+			return
+		}
+		var cfg Config
+		cfg.Init()
+		if !assert.NoError(t, readConfig(&cfg, user), "readConfig should succeed") {
+			return
+		}
+
+		assert.Equal(t, map[string]string{"C-j": "peco.SelectDown", "C-k": "peco.SelectUp"}, cfg.Keymap, "keymaps should be merged per key")
+		assert.Equal(t, Style{fg: termbox.ColorRed, bg: termbox.ColorDefault}, cfg.Style.Query, "project style should be used")
+		assert.Equal(t, Style{fg: termbox.ColorGreen, bg: termbox.ColorDefault}, cfg.Style.Matched, "user style should be kept")
+		assert.Equal(t, NewStyleSet().Selected, cfg.Style.Selected, "default style should be kept")
+		assert.Equal(t, "[project]", cfg.Prompt, "project config should take precedence")
+		assert.Equal(t, LayoutTypeBottomUp, cfg.Layout, "user config should be kept")
+
+		// The effective config can be read back as a config file
+		buf, err := json.Marshal(cfg)
+		if !assert.NoError(t, err, "encoding the config should succeed") {
+			return
+		}
+		var decoded Config
+		decoded.Init()
+		if !assert.NoError(t, json.Unmarshal(buf, &decoded), "decoding the config should succeed") {
+			return
+		}
+		assert.Equal(t, cfg, decoded, "decoded config should match")
+	})
+}
+
+func TestExpandPath(t *testing.T) {
+	defer func(f func() (string, error)) { homedirFunc = f }(homedirFunc)
+	homedirFunc = func() (string, error) {
+		return "/home/peco", nil
+	}
+	os.Setenv("PECO_TEST_STATE", "/var/state")
+	defer os.Unsetenv("PECO_TEST_STATE")
+	os.Unsetenv("PECO_TEST_UNSET")
+
+	tests := []struct {
+		path     string
+		expected string
+		unset    []string
+	}{
+		{"/tmp/peco", "/tmp/peco", nil},
+		{"~", "/home/peco", nil},
+		{"~/.peco_history", "/home/peco/.peco_history", nil},
+		{"foo~/bar", "foo~/bar", nil},
+		{"$PECO_TEST_STATE/peco", "/var/state/peco", nil},
+		{"${PECO_TEST_STATE}/peco/session.json", "/var/state/peco/session.json", nil},
+		{"$PECO_TEST_UNSET/peco", "/peco", []string{"PECO_TEST_UNSET"}},
+	}
+	for _, test := range tests {
+		v, unset := expandPath(test.path)
+		assert.Equal(t, test.expected, v, "%s should be expanded", test.path)
+		assert.Equal(t, test.unset, unset, "unset variables in %s should be reported", test.path)
+	}
+
+	f, err := ioutil.TempFile("", "peco-config-")
+	if !assert.NoError(t, err, "creating a temporary file should succeed") {
+		return
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, `{"SessionFile": "~/session.json", "WriteQueryTo": "${PECO_TEST_STATE}/query", "ActionLog": "$PECO_TEST_UNSET/actions"}`)
+	f.Close()
+
+	var cfg Config
+	if !assert.NoError(t, cfg.Init(), "Config.Init should succeed") {
+		return
+	}
+	if !assert.NoError(t, cfg.ReadFilename(f.Name()), "reading the config should succeed") {
+		return
+	}
+	assert.Equal(t, "/home/peco/session.json", cfg.SessionFile, "SessionFile should be expanded")
+	assert.Equal(t, "/var/state/query", cfg.WriteQueryTo, "WriteQueryTo should be expanded")
+	expected := []string{fmt.Sprintf("Variable 'PECO_TEST_UNSET' in ActionLog of %s is not set", f.Name())}
+	assert.Equal(t, expected, cfg.Warnings(), "unset variables should be collected as warnings")
+	assert.Nil(t, cfg.Warnings(), "warnings should only be returned once")
+}
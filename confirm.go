@@ -0,0 +1,106 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// DefaultConfirmTimeout is how long peco waits for the answer to the
+// confirmation of an action when ConfirmTimeout is not specified
+const DefaultConfirmTimeout = 10 * time.Second
+
+// confirmPrompt returns the question asked before the custom action
+// name is executed. Actions that run a command name the command
+func confirmPrompt(name string, c CustomActionConfig) string {
+	var args struct {
+		Cmd string
+	}
+	if json.Unmarshal(c.Args, &args) == nil && args.Cmd != "" {
+		return fmt.Sprintf("Run `%s`? [y/N]", args.Cmd)
+	}
+	return fmt.Sprintf("Run %s? [y/N]", name)
+}
+
+// makeConfirmedAction creates an action that asks prompt in the status
+// bar, and only executes a if the next key typed is "y"
+func makeConfirmedAction(prompt string, a Action) Action {
+	return ActionFunc(func(ctx context.Context, state *Peco, ev termbox.Event) {
+		state.askConfirmation(prompt, a, ev)
+	})
+}
+
+// askConfirmation waits for the answer to prompt, before a is executed
+// with ev. Until then, the keys typed are taken as the answer instead
+// of being handled by the keymap. The question is canceled if it is
+// not answered within ConfirmTimeout
+func (p *Peco) askConfirmation(prompt string, a Action, ev termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.askConfirmation %s", prompt)
+		defer g.End()
+	}
+
+	c := &confirmation{action: a, event: ev}
+
+	p.mutex.Lock()
+	if prev := p.confirm; prev != nil {
+		prev.timer.Stop()
+	}
+	p.confirm = c
+	timeout := p.confirmTimeout
+	if timeout <= 0 {
+		timeout = DefaultConfirmTimeout
+	}
+	c.timer = time.AfterFunc(timeout, func() {
+		if p.endConfirmation(c) {
+			p.Hub().SendStatusMsgAndClear("Canceled", time.Second)
+		}
+	})
+	p.mutex.Unlock()
+
+	p.Hub().SendStatusMsg(prompt)
+}
+
+// pendingConfirmation returns the question that is waiting for an
+// answer, or nil if there is none
+func (p *Peco) pendingConfirmation() *confirmation {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.confirm
+}
+
+// endConfirmation stops waiting for the answer to c. It returns false
+// if c was already answered, or timed out
+func (p *Peco) endConfirmation(c *confirmation) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.confirm != c {
+		return false
+	}
+	p.confirm = nil
+	c.timer.Stop()
+	return true
+}
+
+// answerConfirmation handles the key typed while c is pending. The
+// action is executed if the key is "y", and canceled for any other key
+func answerConfirmation(ctx context.Context, state *Peco, c *confirmation, ev termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("answerConfirmation %c", ev.Ch)
+		defer g.End()
+	}
+
+	if !state.endConfirmation(c) {
+		return
+	}
+	if ev.Mod != 0 || (ev.Ch != 'y' && ev.Ch != 'Y') {
+		state.Hub().SendStatusMsgAndClear("Canceled", time.Second)
+		return
+	}
+	state.Hub().SendStatusMsg("")
+	c.action.Execute(ctx, state, c.event)
+}
@@ -0,0 +1,103 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// promptHub records the status messages that stay until they are
+// replaced, as well as those that are cleared
+type promptHub struct {
+	statusHub
+	prompts []string
+}
+
+func (h *promptHub) SendStatusMsg(msg string) {
+	h.prompts = append(h.prompts, msg)
+}
+
+func TestConfirmPrompt(t *testing.T) {
+	c := CustomActionConfig{
+		Action: "peco.ExecuteCommand",
+		Args:   json.RawMessage(`{"Cmd": "xargs rm"}`),
+	}
+	assert.Equal(t, "Run `xargs rm`? [y/N]", confirmPrompt("rm", c), "command should be named")
+
+	c = CustomActionConfig{
+		Action: "peco.SetQuery",
+		Args:   json.RawMessage(`{"query": "foo"}`),
+	}
+	assert.Equal(t, "Run my.Query? [y/N]", confirmPrompt("my.Query", c), "action should be named")
+}
+
+func TestConfirmedAction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := newPeco()
+	h := &promptHub{}
+	state.hub = h
+	km := NewKeymap(nil, nil, nil)
+
+	var executed []rune
+	a := makeConfirmedAction("Really? [y/N]", ActionFunc(func(_ context.Context, _ *Peco, ev termbox.Event) {
+		executed = append(executed, ev.Ch)
+	}))
+
+	// Any key other than "y" cancels the action
+	a.Execute(ctx, state, termbox.Event{Ch: 'a'})
+	if !assert.NotNil(t, state.pendingConfirmation(), "action should wait for confirmation") {
+		return
+	}
+	km.ExecuteAction(ctx, state, termbox.Event{Key: termbox.KeyEnter})
+	assert.Nil(t, state.pendingConfirmation(), "confirmation should be answered")
+	assert.Empty(t, executed, "action should be canceled")
+
+	// "y" executes the action with the key it was invoked with
+	a.Execute(ctx, state, termbox.Event{Ch: 'b'})
+	km.ExecuteAction(ctx, state, termbox.Event{Ch: 'y'})
+	assert.Equal(t, []rune{'b'}, executed, "action should be executed once confirmed")
+	assert.Equal(t, []string{"Really? [y/N]", "Really? [y/N]", ""}, h.prompts, "question should be asked, then cleared")
+	assert.Equal(t, []string{"Canceled"}, h.msgs, "cancellation should be reported")
+
+	// The question is canceled once the timeout is reached
+	state.confirmTimeout = 10 * time.Millisecond
+	a.Execute(ctx, state, termbox.Event{Ch: 'c'})
+	timeout := time.After(5 * time.Second)
+	for state.pendingConfirmation() != nil {
+		select {
+		case <-timeout:
+			t.Errorf("confirmation should time out")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Equal(t, []rune{'b'}, executed, "action should not be executed after the timeout")
+}
+
+func TestConfirmCustomAction(t *testing.T) {
+	state := newPeco()
+	h := &promptHub{}
+	state.hub = h
+
+	km := NewKeymap(nil, nil, map[string]CustomActionConfig{
+		"my.Query": {
+			Action:  "peco.SetQuery",
+			Args:    json.RawMessage(`{"query": "foo"}`),
+			Confirm: true,
+		},
+	})
+	a, err := km.resolveActionName("my.Query", 0)
+	if !assert.NoError(t, err, "resolveActionName should succeed") {
+		return
+	}
+	a.Execute(context.Background(), state, termbox.Event{})
+	assert.NotNil(t, state.pendingConfirmation(), "action should wait for confirmation")
+	assert.Equal(t, "", state.Query().String(), "query should not be set before confirmation")
+	assert.Equal(t, []string{"Run my.Query? [y/N]"}, h.prompts, "question should be asked")
+}
@@ -0,0 +1,67 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/hub"
+	"github.com/pkg/errors"
+)
+
+// runCount reads the input as usual, runs the query against all of it
+// once without the screen, and prints the number of lines that matched
+// to Stdout. Like with --exit-0, peco exits with ExitStatusNoSelection
+// if nothing matched. The empty query matches all of the lines
+func (p *Peco) runCount(ctx context.Context) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.runCount").BindError(&err)
+		defer g.End()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Nothing displays the messages sent by the source and the filter
+	go drainHub(ctx, p.Hub())
+	go p.idgen.Run(ctx)
+	p.matchAllLines()
+
+	if err := validateQueries(p.Filters().Current(), p.commandLineQueries()); err != nil {
+		return err
+	}
+
+	src, err := p.SetupSource(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup input source")
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-src.SetupDone():
+	}
+	p.SetSource(src)
+	p.printSourceErrors(src)
+
+	NewFilter(p).Work(ctx, hub.NewPayload(p.initialQuery))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	n := p.CurrentLineBuffer().Size()
+	fmt.Fprintf(p.Stdout, "%d\n", n)
+
+	err = makeIgnorable(errors.New("user asked to count the matching lines"))
+	if n == 0 {
+		err = setExitStatus(err, ExitStatusNoSelection)
+	}
+	return err
+}
+
+// matchAllLines makes the queries match all of the input at once, for
+// the runs without the screen that report on all of the lines matched.
+// LazyFilter and MaxResults only limit the lines to display
+func (p *Peco) matchAllLines() {
+	p.lazyFilter = false
+	p.maxResults = 0
+}
@@ -0,0 +1,82 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCount(t *testing.T) {
+	run := func(args ...string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var stdout bytes.Buffer
+		p := newPeco()
+		p.Argv = append([]string{"peco", "--count"}, args...)
+		p.Stdin = strings.NewReader("foo\nbar\nfoobar\n")
+		p.Stdout = &stdout
+		err := p.Run(ctx)
+		return stdout.String(), err
+	}
+
+	testValues := []struct {
+		args     []string
+		expected string
+		status   int
+	}{
+		{[]string{"--query", "foo"}, "2\n", ExitStatusSuccess},
+		{[]string{"--query", "foo", "--query", "bar"}, "3\n", ExitStatusSuccess},
+		{nil, "3\n", ExitStatusSuccess},
+		{[]string{"--query", "baz"}, "0\n", ExitStatusNoSelection},
+	}
+	for _, v := range testValues {
+		out, err := run(v.args...)
+		if !assert.True(t, util.IsIgnorableError(err), "Run should exit without an error for %v", v.args) {
+			continue
+		}
+		assert.Equal(t, v.expected, out, "count for %v should match", v.args)
+		st, ok := util.GetExitStatus(err)
+		if !ok {
+			st = ExitStatusSuccess
+		}
+		assert.Equal(t, v.status, st, "exit status for %v should match", v.args)
+	}
+
+	_, err := run("--query", "a(", "--initial-filter", "Regexp")
+	assert.False(t, util.IsIgnorableError(err), "invalid query should fail")
+}
+
+func TestCountAllLines(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&input, "line %d\n", i)
+	}
+
+	configs := []func(*Config){
+		func(c *Config) { c.LazyFilter = true },
+		func(c *Config) { c.MaxResults = 10 },
+	}
+	for i, configure := range configs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		var stdout bytes.Buffer
+		p := newPeco()
+		p.Argv = []string{"peco", "--count", "--query", "line"}
+		p.Stdin = bytes.NewReader(input.Bytes())
+		p.Stdout = &stdout
+		configure(&p.config)
+		err := p.Run(ctx)
+		cancel()
+		if !assert.True(t, util.IsIgnorableError(err), "Run should exit without an error (config %d)", i) {
+			continue
+		}
+		assert.Equal(t, "500\n", stdout.String(), "all of the matching lines should be counted (config %d)", i)
+	}
+}
@@ -0,0 +1,42 @@
+package peco
+
+import (
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// lineKey is the key used to find duplicate lines. The entire line is
+// compared, so that lines which only differ in what is output after
+// the separator are kept
+func lineKey(v interface{}) string {
+	if l, ok := v.(line.Line); ok {
+		return l.Buffer()
+	}
+	return ""
+}
+
+// uniqueLines returns true if duplicate lines are dropped
+func (p *Peco) uniqueLines() bool {
+	return p.config.Unique || p.config.UniqueAdjacent
+}
+
+// dedupNode returns the pipeline node that drops duplicate lines, as
+// configured by Unique and UniqueAdjacent. Returns nil if duplicate
+// lines are kept
+func (p *Peco) dedupNode() pipeline.Acceptor {
+	switch {
+	case p.config.UniqueAdjacent:
+		return pipeline.DedupAdjacent(lineKey)
+	case p.config.Unique:
+		return pipeline.Dedup(lineKey)
+	default:
+		return nil
+	}
+}
+
+// processesSource returns true if the lines of the source can't be
+// displayed as they are even without a query, because they have to
+// be rewritten, sorted or deduplicated by the filter first
+func (p *Peco) processesSource() bool {
+	return isSorted(p.SortMode()) || p.uniqueLines() || p.lineTransformer != nil
+}
@@ -0,0 +1,57 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnique(t *testing.T) {
+	testValues := []struct {
+		name     string
+		adjacent bool
+		query    string
+		expected []string
+	}{
+		{"Unique", false, "", []string{"foo", "bar", "baz"}},
+		{"Unique with query", false, "ba", []string{"bar", "baz"}},
+		{"UniqueAdjacent", true, "", []string{"foo", "bar", "foo", "baz", "bar"}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = []string{"peco"}
+			if v.query != "" {
+				p.Argv = append(p.Argv, "--query", v.query)
+			}
+			p.Stdin = bytes.NewBufferString("foo\nfoo\nbar\nfoo\nbaz\nbaz\nbar\n")
+			p.config.Unique = !v.adjacent
+			p.config.UniqueAdjacent = v.adjacent
+			go p.Run(ctx)
+
+			<-p.Ready()
+			<-p.source.SetupDone()
+
+			for {
+				if b := p.CurrentLineBuffer(); b.Size() == len(v.expected) {
+					if lines := bufferLines(b); assert.ObjectsAreEqual(v.expected, lines) {
+						return
+					}
+				}
+				select {
+				case <-ctx.Done():
+					assert.Equal(t, v.expected, bufferLines(p.CurrentLineBuffer()), "duplicates should be dropped")
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package peco
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/pkg/errors"
+)
+
+// compileEditorLinePattern compiles the EditorLinePattern. The
+// default pattern is used if s is empty
+func compileEditorLinePattern(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		s = DefaultEditorLinePattern
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile regular expression")
+	}
+
+	for _, name := range re.SubexpNames() {
+		if name == "path" {
+			return re, nil
+		}
+	}
+	return nil, errors.New(`regular expression must have a group named "path"`)
+}
+
+// editorCommand returns the command line that opens the file referred
+// to by s in editor. If re also captures a line number, the editor is
+// asked to jump to that line using the "+N" convention understood by
+// most editors
+func editorCommand(editor string, re *regexp.Regexp, s string) (string, error) {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return "", errors.New("no file name found in the current line")
+	}
+
+	var path, lineno string
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "path":
+			path = m[i]
+		case "line":
+			lineno = m[i]
+		}
+	}
+	if path == "" {
+		return "", errors.New("no file name found in the current line")
+	}
+
+	cmd := editor
+	if lineno != "" {
+		cmd += " +" + lineno
+	}
+	return cmd + " " + util.ShellQuote(path), nil
+}
+
+// doOpenInEditor opens the file referred to by the current line in
+// $EDITOR. peco gives the terminal to the editor, and redraws the
+// screen once the editor exits
+func doOpenInEditor(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doOpenInEditor")
+		defer g.End()
+	}
+
+	l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber())
+	if err != nil {
+		return
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		state.ShowMessage("Failed to open editor: $EDITOR is not set", 5*time.Second, MessageError)
+		return
+	}
+
+	re := state.editorLinePattern
+	if re == nil {
+		re = regexp.MustCompile(DefaultEditorLinePattern)
+	}
+	cmdline, err := editorCommand(editor, re, l.DisplayString())
+	if err != nil {
+		state.ShowMessage("Failed to open editor: "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+
+	// The output of peco may be piped to another command, so the
+	// editor is connected to the terminal directly
+	in, out, err := util.OpenTty()
+	if err != nil {
+		state.ShowMessage("Failed to open editor: "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	cmd := util.Shell(cmdline)
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	state.screen.Suspend()
+	err = cmd.Run()
+	state.screen.Resume()
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+	if err != nil {
+		state.ShowMessage("Failed to open editor: "+err.Error(), 5*time.Second, MessageError)
+	}
+}
@@ -0,0 +1,57 @@
+package peco
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditorCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+		return
+	}
+
+	if _, err := compileEditorLinePattern(`^([^:]+)`); !assert.Error(t, err, "pattern without a path group should be rejected") {
+		return
+	}
+
+	re, err := compileEditorLinePattern("")
+	if !assert.NoError(t, err, "default pattern should compile") {
+		return
+	}
+
+	testValues := []struct {
+		input    string
+		expected string
+	}{
+		{"file.go:42:matched text", "vi +42 'file.go'"},
+		{"file.go:matched text", "vi 'file.go'"},
+		{"file.go", "vi 'file.go'"},
+		{"it's here.go:3:", `vi +3 'it'\''s here.go'`},
+	}
+	for _, v := range testValues {
+		cmd, err := editorCommand("vi", re, v.input)
+		if !assert.NoError(t, err, "editorCommand should succeed for %q", v.input) {
+			return
+		}
+		if !assert.Equal(t, v.expected, cmd, "command should match for %q", v.input) {
+			return
+		}
+	}
+
+	if _, err := editorCommand("vi", re, ":42:"); !assert.Error(t, err, "line without a file name should fail") {
+		return
+	}
+
+	re, err = compileEditorLinePattern(`^\S+ (?P<line>\d+) (?P<path>\S+)`)
+	if !assert.NoError(t, err, "custom pattern should compile") {
+		return
+	}
+	cmd, err := editorCommand("emacs -nw", re, "match 10 main.go")
+	if !assert.NoError(t, err, "editorCommand should succeed") {
+		return
+	}
+	assert.Equal(t, "emacs -nw +10 'main.go'", cmd, "command should match")
+}
@@ -0,0 +1,49 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+)
+
+// doEmitAndContinue writes the selected lines, or the current line if
+// nothing is selected, to the file given with --emit-to, formatted like
+// the output. Then the selection and the query are cleared for the next
+// round, and peco keeps running. Each round is written as soon as it is
+// emitted, so whatever reads the file receives it right away
+func doEmitAndContinue(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doEmitAndContinue")
+		defer g.End()
+	}
+
+	if state.emitOut == nil {
+		state.Hub().SendStatusMsgAndClear("Nothing to emit to: use --emit-to", 2*time.Second)
+		return
+	}
+
+	sel := selectionOrCurrentLine(state)
+	if sel.Len() == 0 {
+		state.Hub().SendStatusMsgAndClear("No lines to emit", time.Second)
+		return
+	}
+
+	selected := !state.SingleSelection() && state.Selection().Len() > 0
+	w := state.newResultWriter(state.emitOut)
+	resultSource{lines: sel}.each(func(l line.Line) bool {
+		w.WriteLine(l, selected)
+		return true
+	})
+	if err := w.Flush(); err != nil {
+		state.ShowMessage("Failed to emit: "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+
+	state.Selection().Reset()
+	setQuery(state, "")
+	state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Emitted %d line(s)", sel.Len()), time.Second)
+}
@@ -0,0 +1,86 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitAndContinue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-emit-")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "emitted")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--emit-to", filename}
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitForLines := func(n int) bool {
+		for {
+			if len(bufferLines(p.CurrentLineBuffer())) == n {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				t.Errorf("unexpected lines %#v", bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	emitted := func(expected string) bool {
+		buf, err := ioutil.ReadFile(filename)
+		if !assert.NoError(t, err, "reading the emitted lines should succeed") {
+			return false
+		}
+		return assert.Equal(t, expected, string(buf), "emitted lines should match")
+	}
+	if !waitForLines(3) {
+		return
+	}
+
+	// The current line is emitted when nothing is selected
+	doEmitAndContinue(ctx, p, termbox.Event{})
+	if !emitted("foo\n") {
+		return
+	}
+
+	p.Query().Set("ba")
+	p.ExecQuery()
+	if !waitForLines(2) {
+		return
+	}
+	for _, i := range []int{0, 1} {
+		l, err := p.CurrentLineBuffer().LineAt(i)
+		if !assert.NoError(t, err, "LineAt should succeed") {
+			return
+		}
+		p.Selection().Add(l)
+	}
+
+	doEmitAndContinue(ctx, p, termbox.Event{})
+	if !emitted("foo\nbar\nbaz\n") {
+		return
+	}
+	assert.Equal(t, 0, p.Selection().Len(), "selection should be cleared")
+	assert.Equal(t, "", p.Query().String(), "query should be cleared")
+	assert.NoError(t, ctx.Err(), "peco should keep running")
+	waitForLines(3)
+}
@@ -0,0 +1,55 @@
+package peco
+
+import (
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// DefaultEmptyInputPlaceholder is the line drawn for an empty input if
+// EmptyInputPlaceholder is not configured
+const DefaultEmptyInputPlaceholder = "(no input)"
+
+// IsValidEmptyInputBehavior checks if a string is a supported value for
+// EmptyInputBehavior. The empty string selects the default behavior
+func IsValidEmptyInputBehavior(v string) bool {
+	switch v {
+	case "", EmptyInputExit, EmptyInputWait, EmptyInputMessage:
+		return true
+	}
+	return false
+}
+
+// handleEmptyInput is called once the entire input has been read
+// without finding a single line. It returns false if peco exits, as
+// EmptyInputBehavior asks for, instead of bringing up the screen
+func (p *Peco) handleEmptyInput() bool {
+	if pdebug.Enabled {
+		pdebug.Printf("Input is empty (EmptyInputBehavior = %s)", p.emptyInputBehavior)
+	}
+
+	switch p.emptyInputBehavior {
+	case "", EmptyInputWait:
+		return true
+	case EmptyInputMessage:
+		placeholder := p.config.EmptyInputPlaceholder
+		if placeholder == "" {
+			placeholder = DefaultEmptyInputPlaceholder
+		}
+		p.mutex.Lock()
+		p.emptyInputPlaceholder = placeholder
+		p.mutex.Unlock()
+		return true
+	}
+
+	p.Exit(setExitStatus(makeIgnorable(errors.New("no input")), ExitStatusNoSelection))
+	return false
+}
+
+// EmptyInputPlaceholder returns the line to draw in place of the input,
+// or the empty string unless the input turned out to be empty and
+// EmptyInputBehavior is EmptyInputMessage
+func (p *Peco) EmptyInputPlaceholder() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.emptyInputPlaceholder
+}
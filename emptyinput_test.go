@@ -0,0 +1,108 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmptyInputBehavior(t *testing.T) {
+	// run returns the error returned by Run, and the error of the
+	// context once Run has returned
+	run := func(behavior, input string, timeout time.Duration) (*Peco, error, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.config.EmptyInputBehavior = behavior
+		p.Stdin = bytes.NewBufferString(input)
+		err := p.Run(ctx)
+		return p, err, ctx.Err()
+	}
+
+	t.Run("exit", func(t *testing.T) {
+		_, err, ctxErr := run(EmptyInputExit, "", 5*time.Second)
+		if !assert.NoError(t, ctxErr, "peco should exit before the timeout") {
+			return
+		}
+		if !assert.True(t, util.IsIgnorableError(err), "error should be ignorable") {
+			return
+		}
+		st, ok := util.GetExitStatus(err)
+		assert.True(t, ok, "error should have an exit status")
+		assert.Equal(t, ExitStatusNoSelection, st, "exit status should match")
+	})
+
+	t.Run("default", func(t *testing.T) {
+		_, _, ctxErr := run("", "", 500*time.Millisecond)
+		assert.Equal(t, context.DeadlineExceeded, ctxErr, "peco should wait by default")
+	})
+
+	t.Run("exit with input", func(t *testing.T) {
+		_, _, ctxErr := run(EmptyInputExit, "foo\n", 500*time.Millisecond)
+		assert.Equal(t, context.DeadlineExceeded, ctxErr, "peco should not exit")
+	})
+
+	t.Run("message", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.config.EmptyInputBehavior = EmptyInputMessage
+		p.config.EmptyInputPlaceholder = "nothing here"
+		p.Stdin = bytes.NewBufferString("")
+		go p.Run(ctx)
+
+		for p.EmptyInputPlaceholder() == "" {
+			select {
+			case <-ctx.Done():
+				t.Errorf("placeholder should be set")
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		assert.Equal(t, "nothing here", p.EmptyInputPlaceholder(), "placeholder should match")
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		p, _, ctxErr := run(EmptyInputWait, "", 500*time.Millisecond)
+		assert.Equal(t, context.DeadlineExceeded, ctxErr, "peco should not exit")
+		assert.Equal(t, "", p.EmptyInputPlaceholder(), "placeholder should not be set")
+	})
+}
+
+func TestDrawEmptyInputPlaceholder(t *testing.T) {
+	state := newPeco()
+	state.emptyInputPlaceholder = DefaultEmptyInputPlaceholder
+	state.currentLineBuffer = NewMemoryBuffer()
+	screen := NewDummyScreen()
+
+	loc := state.Location()
+	loc.SetPerPage(2)
+	loc.SetPage(1)
+
+	list := NewListArea(screen, AnchorTop, 0, true, NewStyleSet())
+	list.Draw(state, nil, 2, &DrawOptions{DisableCache: true})
+
+	rows := map[int][]rune{}
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		y := ev[1].(int)
+		rows[y] = append(rows[y], ev[2].(rune))
+	}
+	assert.Equal(t, DefaultEmptyInputPlaceholder, strings.TrimRight(string(rows[0]), " "), "placeholder should be drawn on the first line")
+	assert.Equal(t, "", strings.TrimRight(string(rows[1]), " "), "second line should be blank")
+}
+
+func TestIsValidEmptyInputBehavior(t *testing.T) {
+	for _, v := range []string{"", EmptyInputExit, EmptyInputWait, EmptyInputMessage} {
+		assert.True(t, IsValidEmptyInputBehavior(v), "%q should be valid", v)
+	}
+	assert.False(t, IsValidEmptyInputBehavior("sometimes"), "unknown behavior should be invalid")
+}
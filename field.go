@@ -0,0 +1,97 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+)
+
+// FieldSelectMode returns true if the next key typed chooses the field
+// to output, as started by peco.SelectField
+func (p *Peco) FieldSelectMode() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.fieldSelectMode
+}
+
+func (p *Peco) SetFieldSelectMode(b bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.fieldSelectMode = b
+}
+
+// OutputField returns the field of the selected lines that is output
+// instead of the entire line (1 based). Returns 0 if the entire line is
+// output
+func (p *Peco) OutputField() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.outputField
+}
+
+func (p *Peco) SetOutputField(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.outputField = n
+}
+
+// fieldDelimiter returns the delimiter used to split lines into fields.
+// Without a FieldDelimiter, lines are split on white spaces
+func (p *Peco) fieldDelimiter() string {
+	if delim := p.config.FieldDelimiter; delim != "" {
+		return delim
+	}
+	return " "
+}
+
+// outputString returns the string that is output for the line, which
+// is the field chosen by peco.SelectField if there is one. Lines that
+// do not have as many fields are output as empty strings
+func (p *Peco) outputString(l line.Line) string {
+	n := p.OutputField()
+	if n == 0 {
+		return l.Output()
+	}
+	v, _ := filter.Field(l.Output(), p.fieldDelimiter(), n)
+	return v
+}
+
+// doSelectField waits for the next key to choose the field that is
+// output in place of the entire line. See doSelectFieldKey
+func doSelectField(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doSelectField")
+		defer g.End()
+	}
+
+	state.SetFieldSelectMode(true)
+	state.Hub().SendStatusMsg("Field to output? (1-9, or 0 for the entire line)")
+}
+
+// doSelectFieldKey handles the key typed after peco.SelectField
+func doSelectFieldKey(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doSelectFieldKey %c", e.Ch)
+		defer g.End()
+	}
+
+	state.SetFieldSelectMode(false)
+	if e.Ch < '0' || e.Ch > '9' {
+		state.Hub().SendStatusMsgAndClear("Field selection canceled", time.Second)
+		return
+	}
+
+	n := int(e.Ch - '0')
+	state.SetOutputField(n)
+	if n == 0 {
+		state.Hub().SendStatusMsgAndClear("Output the entire line", time.Second)
+	} else {
+		state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Output field %d", n), time.Second)
+	}
+	state.Hub().SendDrawPrompt()
+}
@@ -0,0 +1,69 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectField(t *testing.T) {
+	testValues := []struct {
+		name   string
+		delim  string
+		keys   []rune
+		output string
+	}{
+		{"whole line", "", nil, "a b c\nd e\n"},
+		{"second field", "", []rune{'2'}, "b\ne\n"},
+		{"missing field", "", []rune{'3'}, "c\n\n"},
+		{"reset", "", []rune{'2', '0'}, "a b c\nd e\n"},
+		{"canceled", "", []rune{'x'}, "a b c\nd e\n"},
+		{"delimiter", ",", []rune{'1'}, "a b c\nd e\n"},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = nil
+			p.Stdin = bytes.NewBufferString("a b c\nd e\n")
+			p.config.FieldDelimiter = v.delim
+			var out bytes.Buffer
+			p.Stdout = &out
+			go p.Run(ctx)
+
+			<-p.Ready()
+			<-p.source.SetupDone()
+
+			for _, ch := range v.keys {
+				doSelectField(ctx, p, termbox.Event{})
+				if !assert.True(t, p.FieldSelectMode(), "field select mode should be enabled") {
+					return
+				}
+				doAcceptChar(ctx, p, termbox.Event{Ch: ch})
+				if !assert.False(t, p.FieldSelectMode(), "field select mode should be disabled") {
+					return
+				}
+			}
+			if !assert.Equal(t, "", p.Query().String(), "keys should not be added to the query") {
+				return
+			}
+
+			for i := 0; i < 2; i++ {
+				l, err := p.CurrentLineBuffer().LineAt(i)
+				if !assert.NoError(t, err, "LineAt(%d) should succeed", i) {
+					return
+				}
+				p.Selection().Add(l)
+			}
+			p.PrintResults()
+			assert.Equal(t, v.output, out.String(), "output should match")
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package peco
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -12,8 +13,13 @@ import (
 	"github.com/peco/peco/internal/buffer"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
 )
 
+// pipelineDrainTimeout is the amount of time we wait for the pipeline
+// to finish up after a query has been canceled
+const pipelineDrainTimeout = time.Second
+
 func newFilterProcessor(f filter.Filter, q string) *filterProcessor {
 	return &filterProcessor{
 		filter: f,
@@ -22,19 +28,35 @@ func newFilterProcessor(f filter.Filter, q string) *filterProcessor {
 }
 
 func (fp *filterProcessor) Accept(ctx context.Context, in chan interface{}, out pipeline.ChanOutput) {
-	acceptAndFilter(ctx, fp.filter, in, out)
+	acceptAndFilter(ctx, fp.filter, in, out, fp.setTimedOut)
+}
+
+func (fp *filterProcessor) setTimedOut(err error) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	fp.timedOut = err
+}
+
+// TimedOut returns the error of the last batch of lines that the filter
+// gave up on because it took too long, or nil if there is none
+func (fp *filterProcessor) TimedOut() error {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	return fp.timedOut
 }
 
 // This flusher is run in a separate goroutine so that the filter can
-// run separately from accepting incoming messages
-func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, done chan struct{}, out pipeline.ChanOutput) {
+// run separately from accepting incoming messages. Filters that take
+// too long only lose the batch of lines they were working on, which is
+// reported to timedOut
+func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, done chan struct{}, out pipeline.ChanOutput, timedOut func(error)) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("flusher goroutine")
 		defer g.End()
 	}
 
 	defer close(done)
-	defer out.SendEndMark("end of filter")
+	defer out.SendEndMarkCtx(ctx, "end of filter")
 
 	for {
 		select {
@@ -45,16 +67,24 @@ func flusher(ctx context.Context, f filter.Filter, incoming chan []line.Line, do
 				return
 			}
 			pdebug.Printf("flusher: %#v", buf)
-			f.Apply(ctx, buf, out)
+			if err := f.Apply(ctx, buf, out); err != nil {
+				if _, ok := errors.Cause(err).(*filter.TimeoutError); ok && timedOut != nil {
+					timedOut(err)
+				} else {
+					// Errors from the filter are fatal for this query, so
+					// let the pipeline know that it should bail out
+					pipeline.ReportError(ctx, err)
+				}
+			}
 			buffer.ReleaseLineListBuf(buf)
 		}
 	}
 }
 
-func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{}, out pipeline.ChanOutput) {
+func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{}, out pipeline.ChanOutput, timedOut func(error)) {
 	flush := make(chan []line.Line)
 	flushDone := make(chan struct{})
-	go flusher(ctx, f, flush, flushDone, out)
+	go flusher(ctx, f, flush, flushDone, out, timedOut)
 
 	buf := buffer.GetLineListBuf()
 	bufsiz := f.BufSize()
@@ -81,7 +111,15 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 				flush <- buf
 				buf = buffer.GetLineListBuf()
 			}
-		case v := <-in:
+		case v, ok := <-in:
+			if !ok {
+				v = pipeline.EndMark{}
+			}
+			// Lines ranked by the previous filter of a chain keep their
+			// score and matches
+			if l, ok := filter.RankedLine(v); ok {
+				v = l
+			}
 			switch v.(type) {
 			case error:
 				if pipeline.IsEndMark(v.(error)) {
@@ -113,12 +151,140 @@ func acceptAndFilter(ctx context.Context, f filter.Filter, in chan interface{},
 	}
 }
 
+// filtersTimedOut returns the error of the first filter that timed
+// out, if any. See filterProcessor.TimedOut
+func filtersTimedOut(procs []*filterProcessor) error {
+	for _, fp := range procs {
+		if err := fp.TimedOut(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func NewFilter(state *Peco) *Filter {
 	return &Filter{
 		state: state,
 	}
 }
 
+// Start replays the lines matched by the cached query
+func (fc *filterCache) Start(ctx context.Context, out pipeline.ChanOutput) {
+	defer out.SendEndMarkCtx(ctx, "end of cached lines")
+	for _, l := range fc.lines {
+		if err := out.SendCtx(ctx, l); err != nil {
+			return
+		}
+	}
+}
+
+// Reset is a no-op, as the cached lines never change
+func (fc *filterCache) Reset() {}
+
+// sourceFor returns the source that the query should be run against.
+// If incremental filtering is enabled and the query extends the last
+// completed query for the same filter, source and sort mode, the lines
+// matched by that query are used instead of the entire input
+func (f *Filter) sourceFor(src pipeline.Source, selectedFilter filter.Filter, query, sortMode string) pipeline.Source {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fc := f.cache
+	if fc == nil || fc.filter != selectedFilter || fc.source != src || fc.sortMode != sortMode {
+		return src
+	}
+
+	if fc.query == query {
+		return fc
+	}
+
+	if inc, ok := selectedFilter.(filter.Incremental); ok && inc.IsSubsetQuery(fc.query, query) {
+		if pdebug.Enabled {
+			pdebug.Printf("Filter: reusing %d lines matched by '%s'", len(fc.lines), fc.query)
+		}
+		return fc
+	}
+	return src
+}
+
+// selectSizeFilter returns the filter that sw uses for the lines that
+// src holds at this point
+func selectSizeFilter(sw *filter.SizeSwitch, src pipeline.Source) filter.Filter {
+	var size int
+	if s, ok := src.(interface{ Size() int }); ok {
+		size = s.Size()
+	}
+	f := sw.Select(size)
+	if pdebug.Enabled {
+		pdebug.Printf("Filter: %s matches %d lines with %s", sw, size, f)
+	}
+	return f
+}
+
+// updateCache remembers the lines matched by a completed query.
+// Results are only cached once the input has been read completely,
+// as otherwise they would miss lines that are yet to come
+func (f *Filter) updateCache(src pipeline.Source, selectedFilter filter.Filter, query, sortMode string, buf *MemoryBuffer) {
+	if s, ok := src.(*Source); ok {
+		select {
+		case <-s.SetupDone():
+		default:
+			return
+		}
+	}
+
+	if _, ok := selectedFilter.(filter.Incremental); !ok {
+		f.resetCache()
+		return
+	}
+
+	matched := buf.linesInRange(0, buf.Size())
+	lines := make([]line.Line, len(matched))
+	for i, l := range matched {
+		// Strip the match information, so that the lines can be
+		// matched again as if they came from the source
+		switch m := l.(type) {
+		case *line.Matched:
+			l = m.Line
+		case *line.Scored:
+			l = m.Line
+		}
+		lines[i] = l
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cache = &filterCache{
+		filter:   selectedFilter,
+		lines:    lines,
+		query:    query,
+		sortMode: sortMode,
+		source:   src,
+	}
+}
+
+func (f *Filter) resetCache() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.cache = nil
+}
+
+// newFilterProcessors creates the nodes that match the lines against
+// the query. Each filter of a chain gets a node of its own, so that the
+// filters run concurrently on different batches of lines
+func newFilterProcessors(activeFilter filter.Filter, query string) []*filterProcessor {
+	chain, ok := activeFilter.(*filter.Chain)
+	if !ok {
+		return []*filterProcessor{newFilterProcessor(activeFilter, query)}
+	}
+
+	var procs []*filterProcessor
+	for _, f := range chain.Links() {
+		procs = append(procs, newFilterProcessor(f, query))
+	}
+	return procs
+}
+
 // Work is the actual work horse that that does the matching
 // in a goroutine of its own. It wraps Matcher.Match().
 func (f *Filter) Work(ctx context.Context, q hub.Payload) {
@@ -135,31 +301,222 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 	}
 
 	state := f.state
-	if query == "" {
+	state.setLazyRun(nil)
+	queries := state.MultiQuery()
+	sortMode := state.SortMode()
+	session := state.sessionFor(query)
+
+	// Running the same query with a different sort mode matches the
+	// same lines, so there is no reason to drop the selection
+	f.mutex.Lock()
+	resorted := f.lastQuery == query && f.lastSortMode != sortMode
+	f.lastQuery = query
+	f.lastSortMode = sortMode
+	f.mutex.Unlock()
+	keepSelection := state.config.StickySelection || resorted
+
+	// With QueryFlags, the flags at the start of the query select the
+	// filter that the rest of it is matched with
+	selectedFilter, query, flags := state.queryFilter(state.Filters().Current(), query)
+
+	// Without a query, the lines are only run through the pipeline
+	// if they need to be rewritten, sorted or deduplicated
+	noFilter := query == "" && len(queries) == 0
+	if noFilter {
+		// The results cached for the query that was cleared must not
+		// be reused for whatever is typed next
+		f.resetCache()
+	}
+	if noFilter && !isSorted(sortMode) && !state.uniqueLines() && state.lineTransformer == nil {
+		state.startQueryTimer(time.Time{})
+		state.firstFilterDone(nil)
 		state.ResetCurrentLineBuffer()
-		if !state.config.StickySelection {
-			state.Selection().Reset()
+		if !keepSelection {
+			state.Selection().ResetUnpinned()
 		}
 		return
 	}
 
-	// Create a new pipeline
+	// Create a new pipeline. The source is kept from being released
+	// while the query runs, even if it is replaced
+	source, done := state.useSource()
+	defer done()
+	var src pipeline.Source = source
 	p := pipeline.New()
-	p.SetSource(state.Source())
+
+	// Custom filters with an InternalFilter only run the command once
+	// the input is large enough
+	if sw, ok := selectedFilter.(*filter.SizeSwitch); ok {
+		selectedFilter = selectSizeFilter(sw, src)
+	}
+
+	// The empty query with multiple queries given on the command
+	// line means that we are matching against any of those queries.
+	// The results of those can't be reused by the incremental filter
+	var activeFilter filter.Filter = selectedFilter
+	incremental := state.config.IncrementalFilter
+	if query == "" {
+		activeFilter = filter.NewUnion(selectedFilter, queries)
+		incremental = false
+	}
+
+	// Extending a query that only matches entire words does not match
+	// a subset of the lines, so the results can't be reused either
+	wholeWord := state.WholeWord() || flags.word
+	if wholeWord {
+		incremental = false
+	}
+
+	// The results are only cached while the diacritics are matched as
+	// they are, so they can't be reused while they are ignored
+	ignoreAccents := state.IgnoreAccents()
+	if ignoreAccents {
+		incremental = false
+	}
+
+	// Neither are the results of the anchored fuzzy filters, which
+	// would be taken for those matching anywhere once toggled off
+	fuzzyAnchor := state.FuzzyAnchor()
+	if fuzzyAnchor {
+		incremental = false
+	}
+
+	if incremental {
+		p.SetSource(f.sourceFor(src, selectedFilter, query, sortMode))
+	} else {
+		p.SetSource(src)
+	}
+
+	// The lines are rewritten before anything else looks at them, so
+	// that both matching and output see the rewritten text
+	if t := state.lineTransformer; t != nil {
+		p.Add(t)
+	}
+
+	// Duplicates are dropped before the lines are matched, so that
+	// the filter has less work to do
+	if node := state.dedupNode(); node != nil {
+		p.Add(node)
+	}
 
 	// Wraps the actual filter
-	selectedFilter := state.Filters().Current()
-	ctx = selectedFilter.NewContext(ctx, query)
-	p.Add(newFilterProcessor(selectedFilter, query))
+	var procs []*filterProcessor
+	if !noFilter {
+		ctx = activeFilter.NewContext(ctx, query)
+		if delim := state.config.FieldDelimiter; delim != "" {
+			ctx = filter.WithFieldDelimiter(ctx, delim)
+		}
+		if wholeWord {
+			ctx = filter.WithWholeWord(ctx)
+		}
+		if ignoreAccents {
+			ctx = filter.WithIgnoreAccents(ctx)
+		}
+		if fuzzyAnchor {
+			ctx = filter.WithFuzzyAnchor(ctx, state.fuzzyAnchorSeparators)
+		}
+		// The lines rewritten by the transformer are not the ones
+		// that the source checked
+		if s, ok := src.(interface{ ASCII() bool }); ok && s.ASCII() && state.lineTransformer == nil {
+			ctx = filter.WithASCII(ctx)
+		}
+		procs = newFilterProcessors(activeFilter, query)
+		for _, fp := range procs {
+			p.Add(fp)
+		}
+	}
+
+	// Once enough lines are matched, the rest of the input is not
+	// even read. With LazyFilter, that is once the lines around the
+	// scroll position are matched
+	var limit *pipeline.LimitNode
+	lazy := !noFilter && state.lazyFilterApplies(selectedFilter, sortMode)
+	switch {
+	case lazy:
+		limit = pipeline.Limit(state.lazyFilterLimit())
+		p.Add(limit)
+	case !noFilter && state.maxResults > 0:
+		limit = pipeline.Limit(state.maxResults)
+		p.Add(limit)
+	}
 
+	// The results of a query that follows the input must not grow
+	// beyond the lines kept in the source either
 	buf := NewMemoryBuffer()
+	buf.source = source
+	buf.capacity = state.bufferSize
+	buf.sortMode = sortMode
+	buf.frecency = state.frecency
+	buf.stableOrder = state.stableOrder
 	p.SetDestination(buf)
+	p.SetBudget(state.filterBudget)
 	state.SetCurrentLineBuffer(buf)
+	if s := state.spinner; s != nil {
+		s.Start(buf.Done)
+	}
 
+	start := time.Now()
+	state.startQueryTimer(start)
+	state.addFiltersRunning(1)
+	queryDone := make(chan struct{})
 	go func() {
+		// The query must no longer count as running by the time the
+		// screen is drawn, so that the prompt can tell if it matched
+		// nothing
 		defer state.Hub().SendDraw(&DrawOptions{RunningQuery: true})
-		if err := p.Run(ctx); err != nil {
-			state.Hub().SendStatusMsg(err.Error())
+		defer state.addFiltersRunning(-1)
+		// If this query gets canceled, don't let a stuck pipeline
+		// keep us waiting forever
+		err := p.RunWithTimeout(ctx, pipelineDrainTimeout)
+
+		// The selection is reset before firstFilterDone, as the lines
+		// that --select-1 selects must be kept
+		if !keepSelection {
+			state.Selection().ResetUnpinned()
+		}
+		close(queryDone)
+
+		if err != nil {
+			if pe, ok := err.(*pipeline.NodePanicError); ok && pdebug.Enabled {
+				pdebug.Printf("%s\n%s", pe, pe.Stack)
+			}
+			state.firstFilterDone(nil)
+			state.ShowMessage(err.Error(), 0, MessageError)
+			return
+		}
+
+		if ctx.Err() != nil {
+			state.firstFilterDone(nil)
+		} else {
+			state.stopQueryTimer(start)
+			state.firstFilterDone(buf)
+		}
+
+		// The rest of the input is matched as the list is scrolled
+		if lazy && ctx.Err() == nil && limit.Limited() {
+			if s, ok := src.(*Source); ok {
+				state.setLazyRun(&lazyFilterRun{
+					buf: buf,
+					ctx: ctx,
+					nodes: func() []pipeline.Acceptor {
+						var nodes []pipeline.Acceptor
+						if t := state.lineTransformer; t != nil {
+							nodes = append(nodes, t)
+						}
+						for _, fp := range newFilterProcessors(activeFilter, query) {
+							nodes = append(nodes, fp)
+						}
+						return nodes
+					},
+					src: s,
+				})
+			}
+		}
+
+		// Only results of queries that ran to completion can be
+		// safely reused by the next query
+		if incremental && ctx.Err() == nil && (limit == nil || !limit.Limited()) && filtersTimedOut(procs) == nil {
+			f.updateCache(src, selectedFilter, query, sortMode, buf)
 		}
 	}()
 
@@ -168,24 +525,50 @@ func (f *Filter) Work(ctx context.Context, q hub.Payload) {
 			g := pdebug.Marker("Periodic draw request for '%s'", query)
 			defer g.End()
 		}
-		t := time.NewTicker(5 * time.Millisecond)
+		// However fast the lines come in, they are drawn at most once
+		// per interval. The deferred draw below takes care of those
+		// that came in after the last tick
+		t := time.NewTicker(state.redrawInterval)
 		defer t.Stop()
-		defer state.Hub().SendStatusMsg("")
+		defer func() {
+			if err := filtersTimedOut(procs); err != nil {
+				state.ShowMessage(err.Error(), 0, MessageError)
+				return
+			}
+			if limit != nil && limit.Limited() && !lazy {
+				state.Hub().SendStatusMsg(fmt.Sprintf("Showing the first %d matches", state.maxResults))
+				return
+			}
+			state.Hub().SendStatusMsg("")
+		}()
 		defer state.Hub().SendDraw(&DrawOptions{RunningQuery: true})
+		var partial bool
 		for {
 			select {
 			case <-p.Done():
 				return
 			case <-t.C:
-				state.Hub().SendDraw(&DrawOptions{RunningQuery: true})
+				if !partial && buf.Partial() {
+					partial = true
+					state.Hub().SendStatusMsg("Partial results, still running query...")
+				}
+				// The pipeline keeps running for as long as the input
+				// is being read, so only redraw when there are new
+				// results to display
+				if buf.takeChanged() {
+					state.Hub().SendDraw(&DrawOptions{RunningQuery: true})
+				}
 			}
 		}
 	}()
 
-	<-p.Done()
+	<-queryDone
 
-	if !state.config.StickySelection {
-		state.Selection().Reset()
+	// The cursor and the selection of a restored session are only
+	// meaningful once its query has been run
+	if session != nil && ctx.Err() == nil {
+		state.applySession(session)
+		state.Hub().SendDraw(&DrawOptions{DisableCache: true})
 	}
 }
 
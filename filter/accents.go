@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// accentedLetters lists the precomposed letters that decompose into
+// each base letter followed by combining marks, as with Unicode NFD.
+// Only the Latin script is covered: letters such as "ø" or "ł" do not
+// decompose, so they are kept as they are
+var accentedLetters = map[rune]string{
+	'A': "ÀÁÂÃÄÅĀĂĄǍǞǠǺȀȂȦḀẠẢẤẦẨẪẬẮẰẲẴẶ",
+	'a': "àáâãäåāăąǎǟǡǻȁȃȧḁạảấầẩẫậắằẳẵặ",
+	'C': "ÇĆĈĊČḈ",
+	'c': "çćĉċčḉ",
+	'D': "ĎḊḌḎḐḒ",
+	'd': "ďḋḍḏḑḓ",
+	'E': "ÈÉÊËĒĔĖĘĚȄȆȨḔḖḘḚḜẸẺẼẾỀỂỄỆ",
+	'e': "èéêëēĕėęěȅȇȩḕḗḙḛḝẹẻẽếềểễệ",
+	'G': "ĜĞĠĢǦǴḠ",
+	'g': "ĝğġģǧǵḡ",
+	'H': "ĤȞḢḤḦḨḪ",
+	'h': "ĥȟḣḥḧḩḫẖ",
+	'I': "ÌÍÎÏĨĪĬĮİǏȈȊḬḮỈỊ",
+	'i': "ìíîïĩīĭįǐȉȋḭḯỉị",
+	'J': "Ĵ",
+	'j': "ĵǰ",
+	'K': "ĶǨḰḲḴ",
+	'k': "ķǩḱḳḵ",
+	'L': "ĹĻĽḶḸḺḼ",
+	'l': "ĺļľḷḹḻḽ",
+	'M': "ḾṀṂ",
+	'm': "ḿṁṃ",
+	'N': "ÑŃŅŇǸṄṆṈṊ",
+	'n': "ñńņňǹṅṇṉṋ",
+	'O': "ÒÓÔÕÖŌŎŐƠǑǪǬȌȎȪȬȮȰṌṎṐṒỌỎỐỒỔỖỘỚỜỞỠỢ",
+	'o': "òóôõöōŏőơǒǫǭȍȏȫȭȯȱṍṏṑṓọỏốồổỗộớờởỡợ",
+	'P': "ṔṖ",
+	'p': "ṕṗ",
+	'R': "ŔŖŘȐȒṘṚṜṞ",
+	'r': "ŕŗřȑȓṙṛṝṟ",
+	'S': "ŚŜŞŠȘṠṢṤṦṨ",
+	's': "śŝşšșṡṣṥṧṩ",
+	'T': "ŢŤȚṪṬṮṰ",
+	't': "ţťțṫṭṯṱẗ",
+	'U': "ÙÚÛÜŨŪŬŮŰŲƯǓǕǗǙǛȔȖṲṴṶṸṺỤỦỨỪỬỮỰ",
+	'u': "ùúûüũūŭůűųưǔǖǘǚǜȕȗṳṵṷṹṻụủứừửữự",
+	'V': "ṼṾ",
+	'v': "ṽṿ",
+	'W': "ŴẀẂẄẆẈ",
+	'w': "ŵẁẃẅẇẉẘ",
+	'X': "ẊẌ",
+	'x': "ẋẍ",
+	'Y': "ÝŶŸȲẎỲỴỶỸ",
+	'y': "ýÿŷȳẏẙỳỵỷỹ",
+	'Z': "ŹŻŽẐẒẔ",
+	'z': "źżžẑẓẕ",
+}
+
+// accentBases maps each letter of accentedLetters to its base letter
+var accentBases = make(map[rune]rune)
+
+func init() {
+	for base, letters := range accentedLetters {
+		for _, r := range letters {
+			accentBases[r] = base
+		}
+	}
+}
+
+// WithIgnoreAccents makes the built-in filters ignore the diacritics
+// of both the query and the lines: "cafe" matches "café", and so does
+// "café". The indices of the matches still refer to the original lines
+func WithIgnoreAccents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ignoreAccentsKey, true)
+}
+
+func ignoreAccents(ctx context.Context) bool {
+	v, _ := ctx.Value(ignoreAccentsKey).(bool)
+	return v
+}
+
+// foldAccents returns s without its diacritics. Precomposed letters are
+// replaced by their base letter, and combining marks are removed.
+// offsets holds the index in s of the character that each byte of the
+// result comes from, followed by len(s), so that the indices of the
+// result can be mapped back to s with unfoldMatches. If s does not
+// have any diacritics, it is returned as is, with nil offsets
+func foldAccents(s string) (folded string, offsets []int) {
+	i := strings.IndexFunc(s, isAccented)
+	if i == -1 {
+		return s, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(s))
+	buf.WriteString(s[:i])
+	offsets = make([]int, i, len(s)+1)
+	for j := range offsets {
+		offsets[j] = j
+	}
+
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// The mark belongs to the character before it
+		default:
+			if base, ok := accentBases[r]; ok {
+				r = base
+			}
+			k := buf.Len()
+			buf.WriteRune(r)
+			for ; k < buf.Len(); k++ {
+				offsets = append(offsets, i)
+			}
+		}
+		i += n
+	}
+	return buf.String(), append(offsets, len(s))
+}
+
+// isAccented returns true if foldAccents changes r
+func isAccented(r rune) bool {
+	if r < utf8.RuneSelf {
+		return false
+	}
+	if _, ok := accentBases[r]; ok {
+		return true
+	}
+	return unicode.Is(unicode.Mn, r)
+}
+
+// unfoldMatches maps the indices of matches against the string returned
+// by foldAccents back to the original string. A match that ends right
+// before removed combining marks includes them. matches is modified in
+// place
+func unfoldMatches(matches [][]int, offsets []int) [][]int {
+	if offsets == nil {
+		return matches
+	}
+	for _, m := range matches {
+		for i, v := range m {
+			if v >= 0 {
+				m[i] = offsets[v]
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,94 @@
+package filter
+
+import (
+	"context"
+	"strings"
+)
+
+// WithASCII tells the filters that every line they are given only
+// consists of ASCII characters. The terms of IgnoreCase, CaseSensitive
+// and SmartCase that are ASCII as well are then searched for byte by
+// byte, which is much faster than running a regular expression. Terms
+// with other characters still use the regular expressions, as do the
+// lines of a context without this flag
+func WithASCII(ctx context.Context) context.Context {
+	return context.WithValue(ctx, asciiKey, true)
+}
+
+func asciiInput(ctx context.Context) bool {
+	v, _ := ctx.Value(asciiKey).(bool)
+	return v
+}
+
+// indexAllASCII returns the location of every non-overlapping
+// occurrence of sub in s, in the same form as the indices returned by
+// regexp.FindAllStringSubmatchIndex. If fold is true, sub must be in
+// lower case, and the ASCII letters of s are matched regardless of
+// their case. Returns nil if sub does not occur in s
+func indexAllASCII(s, sub string, fold bool) [][]int {
+	var matches [][]int
+	for start := 0; start <= len(s)-len(sub); {
+		var i int
+		if fold {
+			i = indexFoldASCII(s[start:], sub)
+		} else {
+			i = strings.Index(s[start:], sub)
+		}
+		if i < 0 {
+			break
+		}
+		i += start
+		matches = append(matches, []int{i, i + len(sub)})
+		start = i + len(sub)
+	}
+	return matches
+}
+
+// indexFoldASCII returns the index of the first occurrence of the
+// lower case sub in s, ignoring the case of the ASCII letters of s,
+// or -1 if there is none. The candidates are found by looking for
+// either case of the first byte of sub with strings.IndexByte
+func indexFoldASCII(s, sub string) int {
+	c := sub[0]
+	upper := c
+	if 'a' <= c && c <= 'z' {
+		upper = c - 'a' + 'A'
+	}
+
+	for base := 0; base <= len(s)-len(sub); {
+		rest := s[base : len(s)-len(sub)+1]
+		i := strings.IndexByte(rest, c)
+		if upper != c {
+			if j := strings.IndexByte(rest, upper); j >= 0 && (i < 0 || j < i) {
+				i = j
+			}
+		}
+		if i < 0 {
+			return -1
+		}
+		i += base
+		if hasPrefixFoldASCII(s[i+1:], sub[1:]) {
+			return i
+		}
+		base = i + 1
+	}
+	return -1
+}
+
+// hasPrefixFoldASCII returns true if s starts with the lower case
+// prefix, ignoring the case of the ASCII letters of s
+func hasPrefixFoldASCII(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
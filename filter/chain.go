@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// NewChain creates a filter that matches the lines that all of the
+// filters match, applying them from left to right. Each filter only
+// sees the lines matched by the previous one, and the regions matched
+// by all of the filters are highlighted
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+// Links returns the filters to apply one after the other, for example
+// as separate nodes of a pipeline. The filters after the first one
+// merge the regions that they match with the regions matched by the
+// filters before them
+func (c *Chain) Links() []Filter {
+	links := make([]Filter, len(c.filters))
+	for i, f := range c.filters {
+		if i > 0 {
+			f = chainLink{f}
+		}
+		links[i] = f
+	}
+	return links
+}
+
+func (c *Chain) BufSize() int {
+	return c.filters[0].BufSize()
+}
+
+func (c *Chain) NewContext(ctx context.Context, query string) context.Context {
+	for _, f := range c.filters {
+		ctx = f.NewContext(ctx, query)
+	}
+	return ctx
+}
+
+// String returns the names of the filters, separated by ">"
+func (c *Chain) String() string {
+	names := make([]string, len(c.filters))
+	for i, f := range c.filters {
+		names[i] = f.String()
+	}
+	return strings.Join(names, ">")
+}
+
+// IsValidQuery returns false if any of the filters does not accept the
+// query
+func (c *Chain) IsValidQuery(query string) bool {
+	for _, f := range c.filters {
+		if v, ok := f.(QueryValidator); ok && !v.IsValidQuery(query) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSubsetQuery returns true only if every filter can tell that query
+// matches a subset of the lines matched by prev
+func (c *Chain) IsSubsetQuery(prev, query string) bool {
+	for _, f := range c.filters {
+		inc, ok := f.(Incremental)
+		if !ok || !inc.IsSubsetQuery(prev, query) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply runs the lines through each of the filters in turn. It is used
+// when the chain is not split into the nodes of a pipeline, such as
+// when it is wrapped by a Union
+func (c *Chain) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	for _, f := range c.Links() {
+		// The filters send at most one value per line, so they never
+		// block on this channel
+		ch := make(chan interface{}, len(lines))
+		if err := f.Apply(ctx, lines, pipeline.ChanOutput(ch)); err != nil {
+			return err
+		}
+		close(ch)
+
+		lines = lines[:0:0]
+		for v := range ch {
+			if l, ok := RankedLine(v); ok {
+				lines = append(lines, l)
+			}
+		}
+	}
+
+	for _, l := range lines {
+		if err := out.SendCtx(ctx, rankLine(l)); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Apply runs the wrapped filter, and merges the regions matched in the
+// lines that it sends with the regions matched by the previous filters
+func (cl chainLink) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	ch := make(chan interface{}, len(lines))
+	if err := cl.Filter.Apply(ctx, lines, pipeline.ChanOutput(ch)); err != nil {
+		return err
+	}
+	close(ch)
+
+	for v := range ch {
+		if l, ok := RankedLine(v); ok {
+			v = rankLine(mergeChainedLine(l))
+		}
+		if err := out.SendCtx(ctx, v); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// mergeChainedLine flattens a line matched by a filter of a chain,
+// which wraps the line matched by the previous filter, into a single
+// line with the regions matched by both. The score is taken from the
+// last filter that gave one
+func mergeChainedLine(l line.Line) line.Line {
+	type indexer interface {
+		Indices() [][]int
+	}
+
+	var inner line.Line
+	switch m := l.(type) {
+	case *line.Scored:
+		inner = m.Line
+	case *line.Matched:
+		inner = m.Line
+	default:
+		return l
+	}
+	prev, ok := inner.(indexer)
+	if !ok {
+		return l
+	}
+
+	merged := mergeIndices(prev.Indices(), l.(indexer).Indices())
+	base := line.Unwrap(inner)
+	if s, ok := l.(*line.Scored); ok {
+		return line.NewScored(base, merged, s.Score())
+	}
+	if s, ok := inner.(*line.Scored); ok {
+		return line.NewScored(base, merged, s.Score())
+	}
+	return line.NewMatched(base, merged)
+}
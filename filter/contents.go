@@ -0,0 +1,212 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+)
+
+// NewContents creates a new filter that matches the files named by the
+// lines, instead of the lines themselves. cmd is executed with args for
+// each file, with "$QUERY" replaced by the query and "$FILE" by the
+// name of the file, which is added at the end if no argument mentions
+// it. A file matches if the command exits successfully, and the first
+// line that it prints is displayed next to the name of the file. At
+// most concurrency files are searched at the same time, and each of
+// them for at most timeout, unless it is 0
+func NewContents(name, cmd string, args []string, concurrency int, timeout time.Duration) *Contents {
+	if cmd == "" {
+		cmd = "grep"
+		if len(args) == 0 {
+			args = defaultContentsArgs
+		}
+	}
+	if len(args) == 0 {
+		args = []string{"$QUERY"}
+	}
+
+	hasFile := false
+	for _, v := range args {
+		if strings.Contains(v, "$FILE") {
+			hasFile = true
+		}
+	}
+	if !hasFile {
+		args = append(append([]string{}, args...), "$FILE")
+	}
+
+	if concurrency <= 0 {
+		concurrency = DefaultContentsConcurrency
+	}
+
+	return &Contents{
+		args:        args,
+		cmd:         cmd,
+		concurrency: concurrency,
+		name:        name,
+		timeout:     timeout,
+	}
+}
+
+func (cf *Contents) BufSize() int {
+	return 0
+}
+
+func (cf *Contents) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (cf *Contents) String() string {
+	return cf.name
+}
+
+// Apply searches the files named by the lines, and sends the lines of
+// those that match as soon as they are found, so the lines come out in
+// the order the searches end. The files that could not be searched
+// within the timeout are left out, and reported as a TimeoutError once
+// all the files are done
+func (cf *Contents) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Contents.Apply (%d lines)", len(lines)).BindError(&err)
+		defer g.End()
+	}
+
+	query := ctx.Value(queryKey).(string)
+
+	var mutex sync.Mutex
+	var firstErr error
+	var timedOut bool
+	setErr := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cf.concurrency)
+	for _, l := range lines {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case sem <- struct{}{}:
+		}
+
+		// There is no point in going on if the command can't be run
+		mutex.Lock()
+		failed := firstErr != nil
+		mutex.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(l line.Line) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fileCtx := ctx
+			if cf.timeout > 0 {
+				var cancel context.CancelFunc
+				fileCtx, cancel = context.WithTimeout(ctx, cf.timeout)
+				defer cancel()
+			}
+
+			snippet, ok, err := cf.search(fileCtx, query, line.MatchString(l))
+			if err != nil {
+				setErr(err)
+				return
+			}
+			if fileCtx.Err() != nil {
+				if ctx.Err() == nil {
+					mutex.Lock()
+					timedOut = true
+					mutex.Unlock()
+				}
+				return
+			}
+			if !ok {
+				return
+			}
+			out.SendCtx(ctx, line.NewTransformed(l, func(s string) string {
+				return s + ": " + snippet
+			}, true))
+		}(l)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if timedOut && ctx.Err() == nil {
+		return &TimeoutError{Name: cf.name, Timeout: cf.timeout}
+	}
+	return nil
+}
+
+// search runs the command on the file. It returns the first line that
+// the command printed, and whether the file matched. Commands that exit
+// with an error, such as grep on a file that does not contain the
+// query, do not match, but commands that cannot be started are errors
+func (cf *Contents) search(ctx context.Context, query, file string) (string, bool, error) {
+	args := make([]string, len(cf.args))
+	for i, v := range cf.args {
+		v = strings.Replace(v, "$QUERY", query, -1)
+		args[i] = strings.Replace(v, "$FILE", file, -1)
+	}
+
+	cmd := exec.Command(cf.cmd, args...)
+	if pdebug.Enabled {
+		pdebug.Printf("Executing command %s %v", cmd.Path, cmd.Args)
+	}
+	setProcessGroup(cmd)
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", false, errors.Wrap(err, `failed to get stdout pipe`)
+	}
+	if err := cmd.Start(); err != nil {
+		return "", false, errors.Wrap(err, `failed to start command`)
+	}
+
+	// Only the first line is kept, but the rest of the output must be
+	// read for the command to exit
+	type result struct {
+		snippet string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rdr := bufio.NewReader(r)
+		s, _ := rdr.ReadString('\n')
+		io.Copy(ioutil.Discard, rdr)
+		done <- result{snippet: strings.TrimSpace(s), err: cmd.Wait()}
+	}()
+
+	select {
+	case <-ctx.Done():
+		killCommand(cmd)
+		<-done
+		return "", false, nil
+	case res := <-done:
+		if res.err != nil {
+			if _, ok := res.err.(*exec.ExitError); ok {
+				return "", false, nil
+			}
+			return "", false, errors.Wrap(res.err, `failed to run command`)
+		}
+		return res.snippet, true, nil
+	}
+}
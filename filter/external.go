@@ -4,7 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	pdebug "github.com/lestrrat/go-pdebug"
 	"github.com/peco/peco/line"
@@ -12,9 +21,13 @@ import (
 	"github.com/pkg/errors"
 )
 
-// NewExternalCmd creates a new filter that uses an external
-// command to filter the input
-func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen line.IDGenerator, enableSep bool) *ExternalCmd {
+// NewExternalCmd creates a new filter that uses an external command
+// to filter the input. highlight tells how the command gives the regions
+// of the lines that matched the query, if it does. Lines are separated by
+// delim both in the input and in the output of the command. If the command
+// fails to start for reasons that may go away, such as running out of
+// memory or file descriptors, starting it is retried up to retries times
+func NewExternalCmd(name string, cmd string, args []string, threshold, retries int, idgen line.IDGenerator, enableSep bool, highlight HighlightMode, delim byte) *ExternalCmd {
 	if len(args) == 0 {
 		args = []string{"$QUERY"}
 	}
@@ -26,14 +39,201 @@ func NewExternalCmd(name string, cmd string, args []string, threshold int, idgen
 	return &ExternalCmd{
 		args:            args,
 		cmd:             cmd,
+		delim:           delim,
 		enableSep:       enableSep,
+		highlight:       highlight,
 		idgen:           idgen,
 		name:            name,
 		outCh:           pipeline.ChanOutput(make(chan interface{})),
+		retries:         retries,
+		retryDelay:      customFilterRetryDelay,
+		startCmd:        (*exec.Cmd).Start,
 		thresholdBufsiz: threshold,
 	}
 }
 
+// SetTimeout limits how long each invocation of the command may run.
+// Once d elapses, the command is killed, and the lines it printed are
+// kept unless discard is true. Either way, Apply returns a TimeoutError.
+// 0 means no limit
+func (ecf *ExternalCmd) SetTimeout(d time.Duration, discard bool) {
+	ecf.timeout = d
+	ecf.discardPartial = discard
+}
+
+// Error returns a message that tells which filter timed out, and what
+// happened to its results
+func (e *TimeoutError) Error() string {
+	if e.Discarded {
+		return fmt.Sprintf("%s timed out after %s, results were discarded", e.Name, e.Timeout)
+	}
+	return fmt.Sprintf("%s timed out after %s, showing partial results", e.Name, e.Timeout)
+}
+
+// timeoutError returns a TimeoutError if cmdCtx, which was derived from
+// ctx by Apply, is done because the timeout elapsed. When ctx itself is
+// done, the query was canceled, so that is not a timeout
+func (ecf *ExternalCmd) timeoutError(ctx, cmdCtx context.Context) error {
+	if ctx.Err() != nil || cmdCtx.Err() == nil {
+		return nil
+	}
+	return &TimeoutError{Name: ecf.name, Timeout: ecf.timeout, Discarded: ecf.discardPartial}
+}
+
+// isTransientError returns true if starting a command failed for a
+// reason that may go away by itself, such as the system being short on
+// memory or file descriptors. Commands that cannot be found are not
+// going to appear by themselves, so those errors are not transient
+func isTransientError(err error) bool {
+	switch v := err.(type) {
+	case *os.PathError:
+		err = v.Err
+	case *os.SyscallError:
+		err = v.Err
+	}
+
+	switch err {
+	case syscall.EAGAIN, syscall.ENOMEM, syscall.EMFILE, syscall.ENFILE:
+		return true
+	}
+	return false
+}
+
+// start starts the command with the given input, and returns the pipe
+// to read its output from. The command inherits extra as fd 3 and
+// onwards. Transient failures are retried with an exponential backoff,
+// until either the retries run out or ctx is canceled, e.g. because the
+// query changed. In the latter case, both the returned command and the
+// error are nil
+func (ecf *ExternalCmd) start(ctx context.Context, args []string, input []byte, extra []*os.File) (*exec.Cmd, io.Reader, error) {
+	delay := ecf.retryDelay
+	for attempt := 1; ; attempt++ {
+		cmd := exec.Command(ecf.cmd, args...)
+		if pdebug.Enabled {
+			pdebug.Printf("Executing command %s %v (attempt %d)", cmd.Path, cmd.Args, attempt)
+		}
+
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.ExtraFiles = extra
+		setProcessGroup(cmd)
+		r, err := cmd.StdoutPipe()
+		if err == nil {
+			if err = ecf.startCmd(cmd); err == nil {
+				return cmd, r, nil
+			}
+			err = errors.Wrap(err, `failed to start command`)
+		} else {
+			err = errors.Wrap(err, `failed to get stdout pipe`)
+		}
+
+		if attempt > ecf.retries || !isTransientError(errors.Cause(err)) {
+			if attempt > 1 {
+				err = errors.Wrapf(err, "gave up after %d attempts", attempt)
+			}
+			return nil, nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxCustomFilterRetryDelay {
+			delay = maxCustomFilterRetryDelay
+		}
+	}
+}
+
+// parseHighlightedLine splits a line written by a command that
+// highlights its matches into the line and the matched regions. The
+// line is prefixed with a comma separated list of "start-end" byte
+// offsets in the line (start inclusive, end exclusive), followed by
+// a tab. For example, "0-3,8-11\tfoo bar baz" highlights "foo" and
+// "baz". If the prefix is malformed, the entire input is returned as
+// the line, without any matched regions
+func parseHighlightedLine(s string) (string, [][]int) {
+	i := strings.IndexByte(s, '\t')
+	if i < 0 {
+		return s, nil
+	}
+
+	v := s[i+1:]
+	if i == 0 {
+		return v, nil
+	}
+
+	matches, ok := parseRegions(s[:i], v)
+	if !ok {
+		return s, nil
+	}
+	return v, matches
+}
+
+// parseHighlightRecord parses a line written on fd 3 by a command that
+// highlights its matches with HighlightFd. The record gives the index of
+// a line among those printed by the command, starting from 0, and the
+// regions that matched as parseHighlightedLine does. For example,
+// "2:0-3,8-11" highlights "foo" and "baz" in the third line, if it is
+// "foo bar baz". The last return value is false if the record is
+// malformed
+func parseHighlightRecord(s string) (int, string, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return 0, "", false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 0 {
+		return 0, "", false
+	}
+	return n, s[i+1:], true
+}
+
+// parseRegions parses a comma separated list of "start-end" byte offsets
+// in v. Regions that do not fit in v, or that split a character, are
+// ignored. The second return value is false if the list is malformed
+func parseRegions(spec, v string) ([][]int, bool) {
+	var matches [][]int
+	for _, r := range strings.Split(spec, ",") {
+		j := strings.IndexByte(r, '-')
+		if j < 0 {
+			return nil, false
+		}
+		start, err := strconv.Atoi(r[:j])
+		if err != nil {
+			return nil, false
+		}
+		end, err := strconv.Atoi(r[j+1:])
+		if err != nil {
+			return nil, false
+		}
+
+		if start < 0 || end <= start || end > len(v) {
+			continue
+		}
+		if !utf8.RuneStart(v[start]) || (end < len(v) && !utf8.RuneStart(v[end])) {
+			continue
+		}
+		matches = append(matches, []int{start, end})
+	}
+	sort.Sort(byMatchStart(matches))
+	return matches, true
+}
+
+// readHighlightRecords reads the records written on fd 3 by a command
+// that highlights its matches with HighlightFd, keyed by the index of
+// the line. Malformed records are ignored. If a line has several
+// records, the last one wins
+func readHighlightRecords(r io.Reader) map[int]string {
+	records := map[int]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if n, spec, ok := parseHighlightRecord(strings.TrimSuffix(scanner.Text(), "\r")); ok {
+			records[n] = spec
+		}
+	}
+	return records
+}
+
 func (ecf ExternalCmd) BufSize() int {
 	return ecf.thresholdBufsiz
 }
@@ -60,40 +260,80 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 	}
 
 	query := ctx.Value(queryKey).(string)
-	args := append([]string(nil), ecf.args...)
-	for i, v := range args {
-		if v == "$QUERY" {
-			args[i] = query
-		}
-	}
-
-	cmd := exec.Command(ecf.cmd, args...)
-	if pdebug.Enabled {
-		pdebug.Printf("Executing command %s %v", cmd.Path, cmd.Args)
+	args := make([]string, len(ecf.args))
+	for i, v := range ecf.args {
+		args[i] = strings.Replace(v, "$QUERY", query, -1)
 	}
 
 	inbuf := &bytes.Buffer{}
 	for _, l := range buf {
-		inbuf.WriteString(l.DisplayString() + "\n")
+		inbuf.WriteString(l.DisplayString())
+		inbuf.WriteByte(ecf.delim)
 	}
 
-	cmd.Stdin = inbuf
-	r, err := cmd.StdoutPipe()
-	if err != nil {
-		return errors.Wrap(err, `failed to get stdout pipe`)
+	// With HighlightFd, the command writes the regions on a pipe that
+	// it inherits as fd 3
+	var extra []*os.File
+	var records chan map[int]string
+	if ecf.highlight == HighlightFd {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return errors.Wrap(err, "failed to create pipe for highlights")
+		}
+		defer pr.Close()
+		extra = []*os.File{pw}
+
+		records = make(chan map[int]string, 1)
+		go func() { records <- readHighlightRecords(pr) }()
 	}
 
-	err = cmd.Start()
-	if err != nil {
-		return errors.Wrap(err, `failed to start command`)
+	// The timeout starts over with each invocation. When the query
+	// changes, ctx is canceled and the command is killed right away
+	cmdCtx := ctx
+	if ecf.timeout > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeout(ctx, ecf.timeout)
+		defer cancel()
 	}
 
-	go cmd.Wait()
+	cmd, r, err := ecf.start(cmdCtx, args, inbuf.Bytes(), extra)
+	// Once the command has its own copy, closing ours lets the reader
+	// know when the command is done writing
+	for _, f := range extra {
+		f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		// The query was canceled, or the timeout elapsed, while we
+		// were waiting to retry
+		return ecf.timeoutError(ctx, cmdCtx)
+	}
 
+	// complete is only set if the command printed everything, before
+	// cmdCh is closed
+	var complete bool
 	cmdCh := make(chan line.Line)
 	go func(ctx context.Context, cmdCh chan line.Line, rdr *bufio.Reader) {
 		defer func() { recover() }()
 		defer close(cmdCh)
+		// Wait closes the pipe, so it must not be called before we
+		// are done reading from it
+		defer cmd.Wait()
+
+		send := func(l line.Line) bool {
+			select {
+			case cmdCh <- l:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// With HighlightFd, the records may come after the lines they
+		// refer to, so the lines are held until the command is done
+		var held []string
 		for {
 			select {
 			case <-ctx.Done():
@@ -101,40 +341,93 @@ func (ecf *ExternalCmd) Apply(ctx context.Context, buf []line.Line, out pipeline
 			default:
 			}
 
-			b, _, err := rdr.ReadLine()
+			b, err := rdr.ReadBytes(ecf.delim)
+			b = bytes.TrimSuffix(b, []byte{ecf.delim})
+			if ecf.delim == '\n' {
+				b = bytes.TrimSuffix(b, []byte{'\r'})
+			}
 			if len(b) > 0 {
 				// TODO: need to redo the spec for custom matchers
 				// This is the ONLY location where we need to actually
 				// RECREATE a Raw, and thus the only place where
 				// ctx.enableSep is required.
-				select {
-				case cmdCh <- line.NewRaw(ecf.idgen.Next(), string(b), ecf.enableSep):
-				case <-ctx.Done():
+				var l line.Line
+				switch ecf.highlight {
+				case HighlightFd:
+					held = append(held, string(b))
+				case HighlightPrefix:
+					s, matches := parseHighlightedLine(string(b))
+					l = line.NewMatched(line.NewRaw(ecf.idgen.Next(), s, ecf.enableSep), matches)
+				default:
+					l = line.NewRaw(ecf.idgen.Next(), string(b), ecf.enableSep)
+				}
+				if l != nil && !send(l) {
 					return
 				}
 			}
 			if err != nil {
+				break
+			}
+		}
+
+		if records == nil {
+			complete = true
+			return
+		}
+		var specs map[int]string
+		select {
+		case specs = <-records:
+		case <-ctx.Done():
+			return
+		}
+		for i, s := range held {
+			var matches [][]int
+			if spec, ok := specs[i]; ok {
+				matches, _ = parseRegions(spec, s)
+			}
+			if !send(line.NewMatched(line.NewRaw(ecf.idgen.Next(), s, ecf.enableSep), matches)) {
 				return
 			}
 		}
-	}(ctx, cmdCh, bufio.NewReader(r))
+		complete = true
+	}(cmdCtx, cmdCh, bufio.NewReader(r))
 
+	// Once cmdCh is closed, the command has been waited for, and its
+	// process ID may belong to another process already
+	var reaped bool
 	defer func() {
-		if p := cmd.Process; p != nil {
-			p.Kill()
+		if !reaped {
+			killCommand(cmd)
 		}
 	}()
 
+	// If the lines of an invocation that times out are discarded, none
+	// of them can be sent until the command is done
+	var pending []line.Line
 	for {
 		select {
-		case <-ctx.Done():
-			return nil
+		case <-cmdCtx.Done():
+			return ecf.timeoutError(ctx, cmdCtx)
 		case l, ok := <-cmdCh:
 			if l == nil || !ok {
+				reaped = !ok
+				if !complete {
+					return ecf.timeoutError(ctx, cmdCtx)
+				}
+				for _, l := range pending {
+					if err := out.SendCtx(ctx, l); err != nil {
+						return nil
+					}
+				}
 				return nil
 			}
-			out.Send(l)
+			if ecf.discardPartial {
+				pending = append(pending, l)
+				continue
+			}
+			if err := out.SendCtx(cmdCtx, l); err != nil {
+				return ecf.timeoutError(ctx, cmdCtx)
+			}
 		}
 	}
-	return nil
 }
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package filter
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a process group of
+// its own, so that killCommand can get rid of the processes that it
+// starts as well
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killCommand kills the process group of the command. The processes
+// that hold on to its output would otherwise keep us from reaping it
+func killCommand(cmd *exec.Cmd) {
+	p := cmd.Process
+	if p == nil {
+		return
+	}
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err != nil {
+		p.Kill()
+	}
+}
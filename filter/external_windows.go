@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package filter
+
+import "os/exec"
+
+// setProcessGroup does nothing, as killCommand only kills the command
+// itself on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killCommand kills the command
+func killCommand(cmd *exec.Cmd) {
+	if p := cmd.Process; p != nil {
+		p.Kill()
+	}
+}
@@ -1,6 +1,15 @@
 package filter
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 // newContext initializes the context so that it is suitable
 // to be passed to `Run()`
@@ -8,6 +17,264 @@ func newContext(ctx context.Context, query string) context.Context {
 	return context.WithValue(ctx, queryKey, query)
 }
 
+// WithFieldDelimiter enables matching query terms against a single
+// field of each line. Terms prefixed with "N:" only match against the
+// N-th field (1 based) of the line, as split by delim. Negative values
+// of N count from the last field. If delim only consists of white
+// spaces, the line is split on runs of white spaces instead
+func WithFieldDelimiter(ctx context.Context, delim string) context.Context {
+	return context.WithValue(ctx, fieldDelimiterKey, delim)
+}
+
+// fieldDelimiter returns the field delimiter set by WithFieldDelimiter.
+// The second return value is false if matching against fields is not
+// enabled
+func fieldDelimiter(ctx context.Context) (string, bool) {
+	delim, ok := ctx.Value(fieldDelimiterKey).(string)
+	return delim, ok && delim != ""
+}
+
+// WithWholeWord makes the filters that match the query as is, such as
+// IgnoreCase and CaseSensitive, only match entire words: "cat" matches
+// "the cat", but not "category". A word is a run of letters and numbers
+func WithWholeWord(ctx context.Context) context.Context {
+	return context.WithValue(ctx, wholeWordKey, true)
+}
+
+func wholeWord(ctx context.Context) bool {
+	v, _ := ctx.Value(wholeWordKey).(bool)
+	return v
+}
+
+// WithFuzzyAnchor makes the Fuzzy filters anchor the first term of the
+// query: its first character must be at the start of the line, or of
+// the field it is matched against. If separators is not empty, the
+// anchor is right after the last of these characters instead, such as
+// the start of the file name of a path with "/"
+func WithFuzzyAnchor(ctx context.Context, separators string) context.Context {
+	return context.WithValue(ctx, fuzzyAnchorKey, separators)
+}
+
+// fuzzyAnchor returns the separators set by WithFuzzyAnchor. The second
+// return value is false if the terms are not anchored
+func fuzzyAnchor(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(fuzzyAnchorKey).(string)
+	return v, ok
+}
+
+// wholeWordTerm checks if the given query term is surrounded by "\b",
+// which makes it match entire words only, regardless of WithWholeWord.
+// The returned string is the term without them
+func wholeWordTerm(term string) (string, bool) {
+	if len(term) > 4 && strings.HasPrefix(term, `\b`) && strings.HasSuffix(term, `\b`) {
+		return term[2 : len(term)-2], true
+	}
+	return term, false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// isWordMatch returns true if the match of s from start to end neither
+// starts nor ends in the middle of a word
+func isWordMatch(s string, start, end int) bool {
+	if r, _ := utf8.DecodeLastRuneInString(s[:start]); start > 0 && isWordRune(r) {
+		if r, _ := utf8.DecodeRuneInString(s[start:]); isWordRune(r) {
+			return false
+		}
+	}
+	if r, _ := utf8.DecodeRuneInString(s[end:]); end < len(s) && isWordRune(r) {
+		if r, _ := utf8.DecodeLastRuneInString(s[:end]); isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFieldTerm splits the "N:" prefix off the query term, and returns
+// the field number and the rest of the term. If the term does not have
+// such a prefix, the field number is 0
+func parseFieldTerm(term string) (int, string) {
+	i := strings.IndexByte(term, ':')
+	if i <= 0 || i == len(term)-1 {
+		return 0, term
+	}
+
+	n, err := strconv.Atoi(term[:i])
+	if err != nil || n == 0 || term[0] == '+' {
+		return 0, term
+	}
+	return n, term[i+1:]
+}
+
+// fieldSpan returns the byte offsets of the start and the end of the
+// n-th field of s. n is 1 based, and negative values count from the
+// last field. The last return value is false if there is no such field
+func fieldSpan(s, delim string, n int) (int, int, bool) {
+	var spans [][]int
+	if strings.TrimSpace(delim) == "" {
+		start := -1
+		for i, r := range s {
+			switch {
+			case unicode.IsSpace(r):
+				if start >= 0 {
+					spans = append(spans, []int{start, i})
+					start = -1
+				}
+			case start < 0:
+				start = i
+			}
+		}
+		if start >= 0 {
+			spans = append(spans, []int{start, len(s)})
+		}
+	} else {
+		start := 0
+		for {
+			i := strings.Index(s[start:], delim)
+			if i < 0 {
+				spans = append(spans, []int{start, len(s)})
+				break
+			}
+			spans = append(spans, []int{start, start + i})
+			start += i + len(delim)
+		}
+	}
+
+	if n < 0 {
+		n += len(spans) + 1
+	}
+	if n <= 0 || n > len(spans) {
+		return 0, 0, false
+	}
+	return spans[n-1][0], spans[n-1][1], true
+}
+
+// fieldOf returns the n-th field of s, and the byte offset in s where
+// the field starts. If n is 0, s itself is returned. The last return
+// value is false if there is no such field
+func fieldOf(s, delim string, n int) (string, int, bool) {
+	if n == 0 {
+		return s, 0, true
+	}
+
+	start, end, ok := fieldSpan(s, delim, n)
+	if !ok {
+		return "", 0, false
+	}
+	return s[start:end], start, true
+}
+
+// Field returns the n-th field of s, as split by delim in the same way
+// as the fields matched by terms prefixed with "N:". See
+// WithFieldDelimiter. The second return value is false if s has no
+// such field
+func Field(s, delim string, n int) (string, bool) {
+	if n == 0 {
+		return "", false
+	}
+	v, _, ok := fieldOf(s, delim, n)
+	return v, ok
+}
+
+// splitQuery splits the query into terms separated by spaces. Spaces
+// that are preceded by a backslash ("\ ") are treated as part of the
+// term, and the backslash is removed. Empty terms are discarded.
+func splitQuery(query string) []string {
+	var terms []string
+	var buf bytes.Buffer
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\\' && i+1 < len(query) && query[i+1] == ' ':
+			buf.WriteByte(' ')
+			i++
+		case c == ' ':
+			if buf.Len() > 0 {
+				terms = append(terms, buf.String())
+				buf.Reset()
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if buf.Len() > 0 {
+		terms = append(terms, buf.String())
+	}
+	return terms
+}
+
+// negatedTerm checks if the given query term is negated, i.e. it is
+// prefixed with "!". The returned string is the term without the
+// prefix. A leading "\!" is unescaped into a literal "!", and a lone
+// "!" is not considered to be a negation.
+func negatedTerm(term string) (string, bool) {
+	switch {
+	case strings.HasPrefix(term, `\!`):
+		return term[1:], false
+	case len(term) > 1 && term[0] == '!':
+		return term[1:], true
+	default:
+		return term, false
+	}
+}
+
+// LiteralTerm returns a query term that makes f match the lines that
+// contain s as is. The second return value is false if f does not
+// combine query terms separated by spaces, in which case the term can
+// only be used as the entire query
+func LiteralTerm(f Filter, s string) (string, bool) {
+	switch f := f.(type) {
+	case *Union:
+		return LiteralTerm(f.filter, s)
+	case *Regexp:
+		if !f.quotemeta {
+			s = regexp.QuoteMeta(s)
+		}
+		s = strings.Replace(s, " ", `\ `, -1)
+		if strings.HasPrefix(s, "!") {
+			s = `\` + s
+		}
+		return s, true
+	case *Fuzzy, *FuzzyRanked:
+		return "'" + strings.Replace(s, " ", `\ `, -1), true
+	default:
+		return s, false
+	}
+}
+
+// dedupMatches sorts the matched regions, and merges those that
+// overlap. For example, if we matched the same region twice, we don't
+// want that to be drawn twice
+func dedupMatches(matches [][]int) [][]int {
+	sort.Sort(byMatchStart(matches))
+
+	deduped := make([][]int, 0, len(matches))
+	for i, m := range matches {
+		// Always push the first one
+		if i == 0 {
+			deduped = append(deduped, m)
+			continue
+		}
+
+		prev := deduped[len(deduped)-1]
+		switch {
+		case matchContains(prev, m):
+			// If the previous match contains this one, then
+			// don't do anything
+			continue
+		case matchOverlaps(prev, m):
+			// If the previous match overlaps with this one,
+			// merge the results and make it a bigger one
+			deduped[len(deduped)-1] = mergeMatches(prev, m)
+		default:
+			deduped = append(deduped, m)
+		}
+	}
+	return deduped
+}
+
 // sort related stuff
 type byMatchStart [][]int
 
@@ -3,6 +3,13 @@ package filter
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -15,6 +22,13 @@ type indexer interface {
 	Indices() [][]int
 }
 
+// receiveLine receives the next value sent by a filter, and returns the
+// line that it carries
+func receiveLine(ch chan interface{}) line.Line {
+	l, _ := RankedLine(<-ch)
+	return l
+}
+
 // TestFuzzy tests a fuzzy filter against various inputs
 func TestFuzzy(t *testing.T) {
 	octx, ocancel := context.WithCancel(context.Background())
@@ -52,10 +66,11 @@ func TestFuzzy(t *testing.T) {
 			}
 
 			select {
-			case l, ok := <-ch:
+			case v, ok := <-ch:
 				if !assert.True(t, ok, `channel read should succeed`) {
 					return
 				}
+				l, _ := RankedLine(v)
 
 				if !assert.Implements(t, (*line.Line)(nil), l, "result is a line") {
 					return
@@ -70,3 +85,1426 @@ func TestFuzzy(t *testing.T) {
 		})
 	}
 }
+
+func TestSmartCase(t *testing.T) {
+	testValues := []struct {
+		input    string
+		query    string
+		selected bool
+	}{
+		{"this is a test", "test", true},    // all lower case: match case-insensitively
+		{"THIS IS A TEST", "test", true},    // all lower case: match case-insensitively
+		{"this is a Test", "Test", true},    // upper case: match case-sensitively
+		{"this is a test", "Test", false},   // upper case: match case-sensitively
+		{"THIS IS A TEST", "Test", false},   // upper case: match case-sensitively
+		{"this is a TEST", "is TEST", true}, // upper case in any term turns on case-sensitivity
+	}
+
+	filter := NewSmartCase()
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`"%s" against "%s", expect "%t"`, v.input, v.query, v.selected), func(t *testing.T) {
+			ctx := filter.NewContext(context.Background(), v.query)
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if !assert.Equal(t, v.selected, len(ch) == 1, "line should be selected: %t", v.selected) {
+				return
+			}
+		})
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	testValues := []struct {
+		query    string
+		expected []string
+	}{
+		{"foo", []string{"foo"}},
+		{"foo bar", []string{"foo", "bar"}},
+		{"  foo   bar  ", []string{"foo", "bar"}},
+		{`foo\ bar baz`, []string{"foo bar", "baz"}},
+		{`foo\bar`, []string{`foo\bar`}},
+		{"   ", nil},
+	}
+
+	for _, v := range testValues {
+		if !assert.Equal(t, v.expected, splitQuery(v.query), "splitQuery(%q)", v.query) {
+			return
+		}
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	testValues := []struct {
+		input   string
+		query   string
+		indices [][]int // nil if the line should not be selected
+	}{
+		{"foo bar baz", "fo+ ba.", [][]int{{0, 3}, {4, 7}, {8, 11}}},
+		{"foo bar baz", "ba[rz] ^foo", [][]int{{0, 3}, {4, 7}, {8, 11}}},
+		{"foo bar baz", "foo qux", nil},
+		{"foo bar baz", `o\ b`, [][]int{{2, 5}}},
+		{"foo bar baz", "foo (", nil}, // invalid term matches nothing
+	}
+
+	filter := NewRegexp()
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`"%s" against "%s"`, v.input, v.query), func(t *testing.T) {
+			ctx := filter.NewContext(context.Background(), v.query)
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestNegation(t *testing.T) {
+	testValues := []struct {
+		input   string
+		query   string
+		indices [][]int // nil if the line should not be selected
+	}{
+		{"foo bar", "foo !test", [][]int{{0, 3}}},
+		{"foo test", "foo !test", nil},
+		{"foo bar", "!test", [][]int{}},
+		{"foo test", "!TEST", nil},
+		{"foo !bar", `\!bar`, [][]int{{4, 8}}},
+		{"foo bar", `\!bar`, nil},
+		{"foo ! bar", "!", [][]int{{4, 5}}},
+	}
+
+	filter := NewIgnoreCase()
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`"%s" against "%s"`, v.input, v.query), func(t *testing.T) {
+			ctx := filter.NewContext(context.Background(), v.query)
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestLiteralTerm(t *testing.T) {
+	inputs := []string{"foo bar", "!baz", `a\ b`, "(x+y)*", "Foo.Bar"}
+	filters := []Filter{NewIgnoreCase(), NewCaseSensitive(), NewSmartCase(), NewRegexp(), NewFuzzy(), NewFuzzyRanked()}
+
+	for _, f := range filters {
+		for i, input := range inputs {
+			t.Run(fmt.Sprintf(`%s "%s"`, f, input), func(t *testing.T) {
+				term, _ := LiteralTerm(f, input)
+				ctx := f.NewContext(context.Background(), term)
+				ch := make(chan interface{}, 2)
+				lines := []line.Line{
+					line.NewRaw(uint64(i), input, false),
+					line.NewRaw(uint64(i), "unrelated", false),
+				}
+				if !assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+					return
+				}
+				if !assert.Equal(t, 1, len(ch), "only the line itself should be selected") {
+					return
+				}
+				assert.Equal(t, input, receiveLine(ch).DisplayString(), "selected line should match")
+			})
+		}
+	}
+
+	_, ok := LiteralTerm(NewIgnoreCase(), "foo")
+	assert.True(t, ok, "regexp based filters should combine terms")
+	_, ok = LiteralTerm(NewFuzzy(), "foo")
+	assert.True(t, ok, "fuzzy filter should combine terms")
+	_, ok = LiteralTerm(NewExternalCmd("foo", "cat", nil, 0, 0, nil, false, HighlightNone, 0), "foo")
+	assert.False(t, ok, "custom filters should not combine terms")
+}
+
+func TestFixedString(t *testing.T) {
+	testValues := []struct {
+		input   string
+		query   string
+		indices [][]int
+	}{
+		{"a foo bar b", "foo bar", [][]int{{2, 9}}},
+		{"foo baz bar", "foo bar", nil},
+		{"a !x", "!x", [][]int{{2, 4}}},
+		{"(x+y)*2", "(x+y)*", [][]int{{0, 6}}},
+		{`a\ b`, `a\ b`, [][]int{{0, 4}}},
+		{"1:foo", "1:foo", [][]int{{0, 5}}},
+		{"Foo", "foo", nil},
+		{"ab ab", "ab", [][]int{{0, 2}}},
+		{"foo ", "o ", [][]int{{2, 4}}},
+	}
+
+	f := NewFixedString()
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`"%s" against "%s"`, v.query, v.input), func(t *testing.T) {
+			ctx := WithFieldDelimiter(f.NewContext(context.Background(), v.query), ":")
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, f.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), "Apply should succeed") {
+				return
+			}
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not match")
+				return
+			}
+			if !assert.Equal(t, 1, len(ch), "line should match") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestUnicodeFolding(t *testing.T) {
+	testValues := []struct {
+		filter  Filter
+		input   string
+		query   string
+		indices [][]int // nil if the line should not be selected
+	}{
+		{NewIgnoreCase(), "Straße", "strasse", [][]int{{0, 7}}},
+		{NewIgnoreCase(), "STRASSE", "straße", [][]int{{0, 7}}},
+		{NewIgnoreCase(), "STRAẞE", "straße", [][]int{{0, 8}}},
+		{NewIgnoreCase(), "Die Straße", "asse", [][]int{{7, 11}}},
+		{NewIgnoreCase(), "ßs", "sss", [][]int{{0, 3}}},
+		{NewIgnoreCase(), "ß", "s", nil},
+		{NewIgnoreCase(), "İstanbul", "istanbul", [][]int{{0, 9}}},
+		{NewIgnoreCase(), "istanbul", "İSTANBUL", [][]int{{0, 8}}},
+		{NewIgnoreCase(), "DİYARBAKIR", "diyarbakır", [][]int{{0, 11}}},
+		{NewSmartCase(), "Straße", "strasse", [][]int{{0, 7}}},
+		{NewSmartCase(), "Straße", "STRASSE", nil},
+		{NewCaseSensitive(), "straße", "strasse", nil},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s"`, v.filter, v.input, v.query), func(t *testing.T) {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestIsSubsetQuery(t *testing.T) {
+	testValues := []struct {
+		filter   Incremental
+		prev     string
+		query    string
+		expected bool
+	}{
+		{NewIgnoreCase(), "foo", "foob", true},
+		{NewIgnoreCase(), "foo", "foo bar", true},
+		{NewIgnoreCase(), "foo", "fo", false},
+		{NewIgnoreCase(), "foo", "bar", false},
+		{NewIgnoreCase(), "foo !t", "foo !te", false},
+		{NewIgnoreCase(), `foo\`, `foo\ bar`, false},
+		{NewSmartCase(), "foo", "fooB", true},
+		{NewIgnoreCase(), "stras", "strass", false},
+		{NewIgnoreCase(), "strass", "strasse", true},
+		{NewCaseSensitive(), "stras", "strass", true},
+		{NewRegexp(), "foo", "foo*", false},
+		{NewFuzzy(), "fb", "fbz", true},
+		{NewFuzzy(), "fb", "fb z", true},
+		{NewFuzzy(), "'fb", "'fbz", true},
+		{NewFuzzy(), "^fb", "^fbz", true},
+		{NewFuzzy(), "fb", "fb$", false},
+		{NewFuzzy(), "fb$", "fb$z", false},
+		{NewFuzzy(), "'", "'f", false},
+		{NewFuzzy(), `fb\`, `fb\ z`, false},
+		{NewFuzzyRanked(), "fb", "fbz", true},
+		// Typing more characters can move the contiguous run to them
+		{NewFuzzyContiguous("Strict", 3), "fb", "fbz", false},
+		{NewFixedString(), "o b", "foo ba", true},
+		{NewFixedString(), "foo", "fo", false},
+	}
+
+	for _, v := range testValues {
+		assert.Equal(t, v.expected, v.filter.IsSubsetQuery(v.prev, v.query), "%s: IsSubsetQuery(%q, %q)", v.filter, v.prev, v.query)
+	}
+}
+
+func TestFuzzyRanked(t *testing.T) {
+	filter := NewFuzzyRanked()
+	ctx := filter.NewContext(context.Background(), "fb")
+	ch := make(chan interface{}, 3)
+	lines := []line.Line{
+		line.NewRaw(0, "foo_bar", false),
+		line.NewRaw(1, "baz", false),
+		line.NewRaw(2, "fooBar", false),
+	}
+	if !assert.NoError(t, filter.Apply(ctx, lines, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+		return
+	}
+
+	if !assert.Equal(t, 2, len(ch), "only matching lines should be selected") {
+		return
+	}
+
+	for _, expected := range [][][]int{{{0, 1}, {4, 5}}, {{0, 1}, {3, 4}}} {
+		l, ok := receiveLine(ch).(*line.Scored)
+		if !assert.True(t, ok, "line should be scored") {
+			return
+		}
+		assert.True(t, l.Score() > 0, "score should be positive")
+		assert.Equal(t, expected, l.Indices(), "indices should match")
+	}
+}
+
+func TestRankedLine(t *testing.T) {
+	l := line.NewRaw(0, "foo_bar", false)
+
+	// Filters that rank the lines send them wrapped in a Ranked
+	filter := NewFuzzyRanked()
+	ch := make(chan interface{}, 1)
+	if !assert.NoError(t, filter.Apply(filter.NewContext(context.Background(), "fb"), []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+		return
+	}
+	r, ok := (<-ch).(pipeline.Ranked)
+	if !assert.True(t, ok, "FuzzyRanked should send a Ranked") {
+		return
+	}
+	assert.Equal(t, l, r.Value, "the line should be wrapped as it is")
+	assert.Equal(t, [][2]int{{0, 1}, {4, 5}}, r.Spans, "spans should match")
+
+	scored, ok := RankedLine(r)
+	if !assert.True(t, ok, "Ranked should carry a line") {
+		return
+	}
+	assert.Equal(t, r.Score, scored.(*line.Scored).Score(), "score should be kept")
+	assert.Equal(t, [][]int{{0, 1}, {4, 5}}, scored.(*line.Scored).Indices(), "matches should be kept")
+	assert.Equal(t, r, rankLine(scored), "scored lines should be sent as a Ranked")
+
+	// The others send the lines as they are
+	bare, ok := RankedLine(l)
+	assert.True(t, ok, "lines should be accepted as they are")
+	assert.Equal(t, line.Line(l), bare, "lines should be returned as they are")
+	assert.Equal(t, line.Line(l), rankLine(l), "unscored lines should be sent as they are")
+
+	_, ok = RankedLine(pipeline.Ranked{Value: "foo"})
+	assert.False(t, ok, "Ranked values that are not lines should be rejected")
+}
+
+func TestParseFuzzyTerm(t *testing.T) {
+	testValues := []struct {
+		term string
+		kind fuzzyTermKind
+		text string
+	}{
+		{"foo", fuzzyTermFuzzy, "foo"},
+		{"'foo", fuzzyTermExact, "foo"},
+		{"'^foo$", fuzzyTermExact, "^foo$"},
+		{"^foo", fuzzyTermPrefix, "foo"},
+		{"foo$", fuzzyTermSuffix, "foo"},
+		{"^foo$", fuzzyTermWhole, "foo"},
+		{`\'foo`, fuzzyTermFuzzy, "'foo"},
+		{`\^foo`, fuzzyTermFuzzy, "^foo"},
+		{`foo\$`, fuzzyTermFuzzy, "foo$"},
+		{`\^foo$`, fuzzyTermSuffix, "^foo"},
+		{"'", fuzzyTermFuzzy, "'"},
+		{"^", fuzzyTermFuzzy, "^"},
+		{"$", fuzzyTermFuzzy, "$"},
+	}
+
+	for _, v := range testValues {
+		term := parseFuzzyTerm(v.term)
+		assert.Equal(t, v.kind, term.kind, "kind of %q", v.term)
+		assert.Equal(t, v.text, term.text, "text of %q", v.term)
+	}
+}
+
+func TestFuzzyTermMatch(t *testing.T) {
+	testValues := []struct {
+		term    string
+		input   string
+		indices [][]int // nil if the term should not match
+	}{
+		{"fb", "foo_bar", [][]int{{0, 1}, {4, 5}}},
+		{"FB", "foo_bar", nil},
+		{"'o_b", "foo_bar", [][]int{{2, 5}}},
+		{"'ob", "foo_bar", nil},
+		{"'O_B", "foo_bar", nil},
+		{"'o", "foo", [][]int{{1, 2}, {2, 3}}},
+		{"^fo", "foo_bar", [][]int{{0, 2}}},
+		{"^oo", "foo_bar", nil},
+		{"ar$", "foo_bar", [][]int{{5, 7}}},
+		{"ba$", "foo_bar", nil},
+		{"^foo_bar$", "FOO_BAR", [][]int{{0, 7}}},
+		{"^foo$", "foo_bar", nil},
+		{"'straße", "STRASSE", [][]int{{0, 7}}},
+	}
+
+	for _, v := range testValues {
+		term := parseFuzzyQuery(v.term, false)[0]
+		assert.Equal(t, v.indices, term.match(v.input, ""), "%q against %q", v.term, v.input)
+	}
+}
+
+func TestFuzzyMixedTerms(t *testing.T) {
+	testValues := []struct {
+		filter  Filter
+		input   string
+		query   string
+		indices [][]int // nil if the line should not be selected
+	}{
+		{NewFuzzy(), "src/main.go", "^src sm 'main go$", [][]int{{0, 3}, {4, 8}, {9, 11}}},
+		{NewFuzzy(), "src/main.go", "^src 'mian", nil},
+		{NewFuzzy(), "src/main.go", "main src$", nil},
+		// Terms that overlap are merged
+		{NewFuzzy(), "foobar", "'oob ^foo", [][]int{{0, 4}}},
+		{NewFuzzy(), "a b", `a\ b`, [][]int{{0, 1}, {1, 2}, {2, 3}}},
+		{NewFuzzyRanked(), "src/main.go", "^src mgo", [][]int{{0, 3}, {4, 5}, {9, 11}}},
+		{NewFuzzyRanked(), "src/main.go", "^main", nil},
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "smgo", nil},
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "maigo", [][]int{{4, 7}, {9, 10}, {10, 11}}},
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "rcmai", [][]int{{1, 2}, {2, 3}, {4, 7}}},
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "MAI", nil},
+		{NewFuzzyContiguous("Strict", 3), "SRC/MAIN.GO", "maigo", [][]int{{4, 7}, {9, 10}, {10, 11}}},
+		// Terms shorter than MinContiguous must be contained as is
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "ma", [][]int{{4, 6}}},
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "mn", nil},
+		{NewFuzzyContiguous("Strict", 3), "src/main.go", "^src mn", nil},
+		{NewFuzzyContiguous("Loose", 1), "src/main.go", "mn", [][]int{{4, 5}, {7, 8}}},
+		{NewFuzzyRankedContiguous("StrictRanked", 3), "src/main.go", "maigo", [][]int{{4, 7}, {9, 10}, {10, 11}}},
+		{NewFuzzyRankedContiguous("StrictRanked", 3), "src/main.go", "mgo", nil},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s"`, v.filter, v.input, v.query), func(t *testing.T) {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestParseFieldTerm(t *testing.T) {
+	testValues := []struct {
+		term  string
+		field int
+		rest  string
+	}{
+		{"2:foo", 2, "foo"},
+		{"-1:foo", -1, "foo"},
+		{"12:30", 12, "30"},
+		{"0:foo", 0, "0:foo"},
+		{"+1:foo", 0, "+1:foo"},
+		{"a:foo", 0, "a:foo"},
+		{":foo", 0, ":foo"},
+		{"2:", 0, "2:"},
+		{"foo", 0, "foo"},
+	}
+
+	for _, v := range testValues {
+		field, rest := parseFieldTerm(v.term)
+		assert.Equal(t, v.field, field, "field for %q", v.term)
+		assert.Equal(t, v.rest, rest, "rest for %q", v.term)
+	}
+}
+
+func TestFieldSpan(t *testing.T) {
+	testValues := []struct {
+		input string
+		delim string
+		n     int
+		span  []int // nil if there is no such field
+	}{
+		{"foo,bar,baz", ",", 1, []int{0, 3}},
+		{"foo,bar,baz", ",", 2, []int{4, 7}},
+		{"foo,bar,baz", ",", -1, []int{8, 11}},
+		{"foo,bar,baz", ",", -3, []int{0, 3}},
+		{"foo,bar,baz", ",", 4, nil},
+		{"foo,bar,baz", ",", -4, nil},
+		{"foo,,baz", ",", 2, []int{4, 4}},
+		{"foo::bar", "::", 2, []int{5, 8}},
+		{"  foo   bar ", " ", 1, []int{2, 5}},
+		{"  foo   bar ", " ", 2, []int{8, 11}},
+		{"  foo   bar ", " ", 3, nil},
+		{"foo\tbar", "\t", -1, []int{4, 7}},
+	}
+
+	for _, v := range testValues {
+		start, end, ok := fieldSpan(v.input, v.delim, v.n)
+		if v.span == nil {
+			assert.False(t, ok, "field %d of %q should not exist", v.n, v.input)
+			continue
+		}
+		if !assert.True(t, ok, "field %d of %q should exist", v.n, v.input) {
+			continue
+		}
+		assert.Equal(t, v.span, []int{start, end}, "span of field %d of %q", v.n, v.input)
+	}
+}
+
+func TestFieldMatching(t *testing.T) {
+	testValues := []struct {
+		filter  Filter
+		input   string
+		query   string
+		indices [][]int // nil if the line should not be selected
+	}{
+		{NewIgnoreCase(), "foo bar", "2:bar", [][]int{{4, 7}}},
+		{NewIgnoreCase(), "bar foo", "2:bar", nil},
+		{NewIgnoreCase(), "foo bar baz", "-1:ba", [][]int{{8, 10}}},
+		{NewIgnoreCase(), "foo bar", "3:bar", nil},
+		{NewIgnoreCase(), "foo bar", "foo !2:bar", nil},
+		{NewIgnoreCase(), "foo baz", "foo !2:bar", [][]int{{0, 3}}},
+		{NewIgnoreCase(), "foo", "foo !2:bar", [][]int{{0, 3}}},
+		{NewIgnoreCase(), "at 12:30", "12:30", nil},
+		{NewRegexp(), "foo bar", "2:^b", [][]int{{4, 5}}},
+		{NewFuzzy(), "foo bar", "2:br", [][]int{{4, 5}, {6, 7}}},
+		{NewFuzzy(), "foo bar", "-1:fo", nil},
+		{NewFuzzyRanked(), "foo bar", "2:br", [][]int{{4, 5}, {6, 7}}},
+		{NewFuzzyRanked(), "foo", "2:br", nil},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s"`, v.filter, v.input, v.query), func(t *testing.T) {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			ctx = WithFieldDelimiter(ctx, " ")
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+
+	// Without a delimiter, the prefix is part of the query
+	filter := NewIgnoreCase()
+	ctx := filter.NewContext(context.Background(), "12:30")
+	ch := make(chan interface{}, 1)
+	l := line.NewRaw(0, "at 12:30", false)
+	if !assert.NoError(t, filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+		return
+	}
+	assert.Equal(t, 1, len(ch), "line should be selected")
+}
+
+func TestIsValidQuery(t *testing.T) {
+	rx := NewRegexp()
+	for query, expected := range map[string]bool{
+		"foo":       true,
+		"^foo bar$": true,
+		"!a(b":      false,
+		"2:[a-z]+":  true,
+		"a( b":      false,
+		"foo \\":    false,
+		"(?i)foo":   true,
+	} {
+		if rx.IsValidQuery(query) != expected {
+			t.Errorf("IsValidQuery(%q) should be %t", query, expected)
+		}
+	}
+
+	if !NewIgnoreCase().IsValidQuery("a(") {
+		t.Errorf("literal queries should always be valid")
+	}
+}
+
+func TestWholeWord(t *testing.T) {
+	testValues := []struct {
+		filter    Filter
+		input     string
+		query     string
+		wholeWord bool
+		indices   [][]int // nil if the line should not be selected
+	}{
+		{NewIgnoreCase(), "the cat", "cat", true, [][]int{{4, 7}}},
+		{NewIgnoreCase(), "category", "cat", true, nil},
+		{NewIgnoreCase(), "category", "cat", false, [][]int{{0, 3}}},
+		{NewIgnoreCase(), "category, cat.", "Cat", true, [][]int{{10, 13}}},
+		{NewCaseSensitive(), "the Cat", "cat", true, nil},
+		{NewIgnoreCase(), "the cat sat", "cat sat", true, [][]int{{4, 7}, {8, 11}}},
+		{NewIgnoreCase(), "the cat saturday", "cat sat", true, nil},
+		{NewIgnoreCase(), "the cat", "cat !the", true, nil},
+		{NewIgnoreCase(), "them cat", "cat !the", true, [][]int{{5, 8}}},
+		{NewIgnoreCase(), "café noir", "caf", true, nil},
+		{NewIgnoreCase(), "café noir", "café", true, [][]int{{0, 5}}},
+		{NewIgnoreCase(), "category, cat", `\bcat\b`, false, [][]int{{10, 13}}},
+		{NewIgnoreCase(), "category, cat", `\bcat\b cat`, false, [][]int{{0, 3}, {10, 13}}},
+		{NewRegexp(), "category", "cat", true, [][]int{{0, 3}}},
+		{NewRegexp(), "category", `\bcat\b`, false, nil},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s" (%t)`, v.filter, v.input, v.query, v.wholeWord), func(t *testing.T) {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			if v.wholeWord {
+				ctx = WithWholeWord(ctx)
+			}
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestFoldAccents(t *testing.T) {
+	testValues := []struct {
+		input   string
+		folded  string
+		offsets []int
+	}{
+		{"cafe", "cafe", nil},
+		{"café", "cafe", []int{0, 1, 2, 3, 5}},
+		{"cafe\u0301s", "cafes", []int{0, 1, 2, 3, 6, 7}},
+		{"Ça", "Ca", []int{0, 2, 3}},
+		{"\u0301a", "a", []int{2, 3}},
+		{"ø", "ø", nil},
+		{"北ñ", "北n", []int{0, 1, 2, 3, 5}},
+	}
+
+	for _, v := range testValues {
+		folded, offsets := foldAccents(v.input)
+		assert.Equal(t, v.folded, folded, "folded %q should match", v.input)
+		assert.Equal(t, v.offsets, offsets, "offsets of %q should match", v.input)
+	}
+}
+
+func TestIgnoreAccents(t *testing.T) {
+	testValues := []struct {
+		filter  Filter
+		input   string
+		query   string
+		ignore  bool
+		indices [][]int // nil if the line should not be selected
+	}{
+		{NewIgnoreCase(), "café noir", "cafe", true, [][]int{{0, 5}}},
+		{NewIgnoreCase(), "café noir", "cafe", false, nil},
+		{NewIgnoreCase(), "cafe noir", "café", true, [][]int{{0, 4}}},
+		{NewIgnoreCase(), "CAFÉ", "cafe", true, [][]int{{0, 5}}},
+		{NewIgnoreCase(), "cafe\u0301 noir", "cafe", true, [][]int{{0, 6}}},
+		{NewIgnoreCase(), "cafe\u0301 noir", "af", true, [][]int{{1, 3}}},
+		{NewIgnoreCase(), "crème brûlée", "creme brulee", true, [][]int{{0, 6}, {7, 15}}},
+		{NewIgnoreCase(), "crème brûlée", "creme !brulee", true, nil},
+		{NewSmartCase(), "École", "E", true, [][]int{{0, 2}}},
+		{NewSmartCase(), "école", "E", true, nil},
+		{NewCaseSensitive(), "résumé", "resume", true, [][]int{{0, 8}}},
+		{NewRegexp(), "café", "^cafe$", true, [][]int{{0, 5}}},
+		{NewFuzzy(), "Ça va", "cv", true, [][]int{{0, 2}, {4, 5}}},
+		{NewFuzzy(), "Ça va", "'ca", true, [][]int{{0, 3}}},
+		{NewFuzzyRanked(), "naïve", "nv", true, [][]int{{0, 1}, {4, 5}}},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s" (%t)`, v.filter, v.input, v.query, v.ignore), func(t *testing.T) {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			if v.ignore {
+				ctx = WithIgnoreAccents(ctx)
+			}
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestFuzzyAnchor(t *testing.T) {
+	testValues := []struct {
+		filter     Filter
+		input      string
+		query      string
+		separators string
+		indices    [][]int // nil if the line should not be selected
+	}{
+		{NewFuzzy(), "src/foo/bar.go", "sfb", "", [][]int{{0, 1}, {4, 5}, {8, 9}}},
+		{NewFuzzy(), "src/foo/bar.go", "fb", "", nil},
+		{NewFuzzy(), "src/foo/bar.go", "bg", "/", [][]int{{8, 9}, {12, 13}}},
+		{NewFuzzy(), "src/foo/bar.go", "fb", "/", nil},
+		{NewFuzzy(), "src/foo/bar.go", "ar", "/", nil},
+		{NewFuzzy(), "bar.go", "bg", "/", [][]int{{0, 1}, {4, 5}}},
+		{NewFuzzy(), "src/foo/bar.go", "bg src", "/", [][]int{{0, 3}, {8, 9}, {12, 13}}},
+		{NewFuzzy(), "src/foo/bar.go", "'bar", "/", [][]int{{8, 11}}},
+		{NewFuzzy(), "src/foo/ébar.go", "ég", "/", [][]int{{8, 10}, {14, 15}}},
+		{NewFuzzyRanked(), "src/foo/bar.go", "bar", "/", [][]int{{8, 9}, {9, 10}, {10, 11}}},
+		{NewFuzzyRanked(), "src/foo/bar.go", "ar", "/", nil},
+		{NewIgnoreCase(), "src/foo/bar.go", "foo", "/", [][]int{{4, 7}}},
+	}
+
+	for i, v := range testValues {
+		t.Run(fmt.Sprintf(`%s: "%s" against "%s" (%q)`, v.filter, v.input, v.query, v.separators), func(t *testing.T) {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			ctx = WithFuzzyAnchor(ctx, v.separators)
+			ch := make(chan interface{}, 1)
+			l := line.NewRaw(uint64(i), v.input, false)
+			if !assert.NoError(t, v.filter.Apply(ctx, []line.Line{l}, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+				return
+			}
+
+			if v.indices == nil {
+				assert.Equal(t, 0, len(ch), "line should not be selected")
+				return
+			}
+
+			if !assert.Equal(t, 1, len(ch), "line should be selected") {
+				return
+			}
+			assert.Equal(t, v.indices, receiveLine(ch).(indexer).Indices(), "indices should match")
+		})
+	}
+}
+
+func TestNumberIn(t *testing.T) {
+	tests := []struct {
+		input      string
+		n          float64
+		start, end int
+		ok         bool
+	}{
+		{"size 120 bytes", 120, 5, 8, true},
+		{"-3 degrees", -3, 0, 2, true},
+		{"took 1.5s", 1.5, 5, 8, true},
+		{"x-3", 3, 2, 3, true},
+		{"from -273.15", -273.15, 5, 12, true},
+		{"version 2.", 2, 8, 9, true},
+		{"no number", 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		n, start, end, ok := numberIn(test.input)
+		if !assert.Equal(t, test.ok, ok, "a number should be found in %q only if expected", test.input) || !ok {
+			continue
+		}
+		assert.Equal(t, test.n, n, "number in %q should match", test.input)
+		assert.Equal(t, []int{test.start, test.end}, []int{start, end}, "offsets of the number in %q should match", test.input)
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	lines := []line.Line{
+		line.NewRaw(0, "a 5", false),
+		line.NewRaw(1, "b 10", false),
+		line.NewRaw(2, "c 15.5", false),
+		line.NewRaw(3, "d 20", false),
+		line.NewRaw(4, "e -7", false),
+		line.NewRaw(5, "f", false),
+	}
+	tests := []struct {
+		query    string
+		expected []uint64
+	}{
+		{">10", []uint64{2, 3}},
+		{">=10", []uint64{1, 2, 3}},
+		{"<10", []uint64{0, 4}},
+		{"<=-7", []uint64{4}},
+		{"10", []uint64{1}},
+		{"=15.5", []uint64{2}},
+		{"!=10", []uint64{0, 2, 3, 4}},
+		{"10..20", []uint64{1, 2, 3}},
+		{"..5", []uint64{0, 4}},
+		{"15..", []uint64{2, 3}},
+		{">0 <20", []uint64{0, 1, 2}},
+	}
+
+	filter := NewNumeric(0)
+	for _, test := range tests {
+		ctx := filter.NewContext(context.Background(), test.query)
+		ch := make(chan interface{}, len(lines))
+		if !assert.NoError(t, filter.Apply(ctx, lines, pipeline.ChanOutput(ch)), "filter.Apply should succeed for %q", test.query) {
+			return
+		}
+		close(ch)
+
+		var matched []uint64
+		for v := range ch {
+			l, _ := RankedLine(v)
+			matched = append(matched, l.ID())
+		}
+		if !assert.Equal(t, test.expected, matched, "lines matched by %q should match", test.query) {
+			return
+		}
+	}
+
+	// The number is taken from the column, and highlighted
+	filter = NewNumeric(-1)
+	ctx := WithFieldDelimiter(filter.NewContext(context.Background(), ">100"), ",")
+	ch := make(chan interface{}, 2)
+	err := filter.Apply(ctx, []line.Line{
+		line.NewRaw(0, "500,ok,50", false),
+		line.NewRaw(1, "5,ok,500", false),
+	}, pipeline.ChanOutput(ch))
+	if !assert.NoError(t, err, "filter.Apply should succeed") {
+		return
+	}
+	if !assert.Equal(t, 1, len(ch), "only the last column should be compared") {
+		return
+	}
+	l := receiveLine(ch).(*line.Matched)
+	assert.Equal(t, uint64(1), l.ID(), "line with a large number in the last column should match")
+	assert.Equal(t, [][]int{{5, 8}}, l.Indices(), "number should be highlighted")
+
+	for _, query := range []string{">", "..", "abc", "=>5", "20..10", "1..2..3", "<x"} {
+		ctx := filter.NewContext(context.Background(), query)
+		assert.Error(t, filter.Apply(ctx, lines, pipeline.ChanOutput(make(chan interface{}, len(lines)))), "filter.Apply should fail for %q", query)
+		assert.False(t, filter.IsValidQuery(query), "%q should not be valid", query)
+	}
+	assert.True(t, filter.IsValidQuery("10..20 !=15"), "valid query should be accepted")
+}
+
+func TestUnion(t *testing.T) {
+	filter := NewUnion(NewIgnoreCase(), []string{"bar", "fo", "o"})
+	ctx := filter.NewContext(context.Background(), "")
+	ch := make(chan interface{}, 4)
+	lines := []line.Line{
+		line.NewRaw(0, "foo", false),
+		line.NewRaw(1, "bar", false),
+		line.NewRaw(2, "baz", false),
+		line.NewRaw(3, "qux", false),
+	}
+	if !assert.NoError(t, filter.Apply(ctx, lines, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+		return
+	}
+
+	// Each line is sent once, in the original order, with the matches
+	// of all queries merged
+	if !assert.Equal(t, 2, len(ch), "only matching lines should be selected") {
+		return
+	}
+
+	l := receiveLine(ch)
+	assert.Equal(t, uint64(0), l.ID(), "first line should be 'foo'")
+	assert.Equal(t, [][]int{{0, 3}}, l.(indexer).Indices(), "indices should be merged")
+
+	l = receiveLine(ch)
+	assert.Equal(t, uint64(1), l.ID(), "second line should be 'bar'")
+	assert.Equal(t, [][]int{{0, 3}}, l.(indexer).Indices(), "indices should match")
+}
+
+func TestChain(t *testing.T) {
+	filter := NewChain(NewIgnoreCase(), NewCaseSensitive())
+	if !assert.Equal(t, "IgnoreCase>CaseSensitive", filter.String(), "name should list the filters") {
+		return
+	}
+
+	lines := []line.Line{
+		line.NewRaw(0, "FOO foo", false),
+		line.NewRaw(1, "Foo bar", false),
+		line.NewRaw(2, "baz", false),
+	}
+	check := func(t *testing.T, ch chan interface{}) {
+		if !assert.Equal(t, 1, len(ch), "only lines matched by both filters should be selected") {
+			return
+		}
+		l := receiveLine(ch)
+		assert.Equal(t, uint64(0), l.ID(), "line should be 'FOO foo'")
+		assert.Equal(t, [][]int{{0, 3}, {4, 7}}, l.(indexer).Indices(), "indices of both filters should be merged")
+		assert.Equal(t, lines[0], l.(*line.Matched).Line, "line should not be wrapped more than once")
+	}
+
+	t.Run("Apply", func(t *testing.T) {
+		ctx := filter.NewContext(context.Background(), "foo")
+		ch := make(chan interface{}, len(lines))
+		if !assert.NoError(t, filter.Apply(ctx, lines, pipeline.ChanOutput(ch)), `filter.Apply should succeed`) {
+			return
+		}
+		check(t, ch)
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		// The output of each link is the input of the next
+		ctx := filter.NewContext(context.Background(), "foo")
+		in := lines
+		var ch chan interface{}
+		for _, f := range filter.Links() {
+			ch = make(chan interface{}, len(in))
+			if !assert.NoError(t, f.Apply(ctx, in, pipeline.ChanOutput(ch)), `f.Apply should succeed`) {
+				return
+			}
+			close(ch)
+			in = nil
+			for v := range ch {
+				l, _ := RankedLine(v)
+				in = append(in, l)
+			}
+		}
+		ch = make(chan interface{}, len(in))
+		for _, l := range in {
+			ch <- l
+		}
+		check(t, ch)
+	})
+
+	assert.False(t, NewChain(NewIgnoreCase(), NewRegexp()).IsValidQuery("("), "query should be valid for all filters")
+	assert.True(t, NewChain(NewIgnoreCase(), NewFuzzy()).IsSubsetQuery("fo", "foo"), "query should be a subset for all filters")
+}
+
+func TestSubmatch(t *testing.T) {
+	testValues := []struct {
+		input    string
+		query    string
+		delim    string
+		expected []string
+	}{
+		{"foo=123 bar=456", `bar=(\d+)`, "", []string{"bar=456", "456"}},
+		{"foo=123 bar=456", `qux (\w+)=(\d+)`, "", []string{"foo=123", "foo", "123"}},
+		{"foo=123 bar=456", `!foo b(a)r`, "", []string{"bar", "a"}},
+		{"foo=123 bar=456", `2:(\w+)=`, " ", []string{"bar=", "bar"}},
+		{"foo=123 bar=456", "qux", "", nil},
+	}
+
+	filter := NewRegexp()
+	for _, v := range testValues {
+		t.Run(fmt.Sprintf(`"%s" against "%s"`, v.input, v.query), func(t *testing.T) {
+			ctx := filter.NewContext(context.Background(), v.query)
+			if v.delim != "" {
+				ctx = WithFieldDelimiter(ctx, v.delim)
+			}
+			assert.Equal(t, v.expected, filter.Submatch(ctx, v.input), "submatches should match")
+		})
+	}
+}
+
+func TestParseHighlightedLine(t *testing.T) {
+	testValues := []struct {
+		input   string
+		line    string
+		indices [][]int
+	}{
+		{"4-7,0-3\tfoo bar baz", "foo bar baz", [][]int{{0, 3}, {4, 7}}},
+		{"\tfoo bar baz", "foo bar baz", nil},
+		{"0-3,8-20\tfoo bar baz", "foo bar baz", [][]int{{0, 3}}}, // out of range
+		{"1-3\t日本語", "日本語", nil},                                  // splits a character
+		{"0-3\t日本語", "日本語", [][]int{{0, 3}}},
+		{"foo bar baz", "foo bar baz", nil},
+		{"foo\tbar baz", "foo\tbar baz", nil}, // malformed prefix
+	}
+
+	for _, v := range testValues {
+		t.Run(fmt.Sprintf("%q", v.input), func(t *testing.T) {
+			s, indices := parseHighlightedLine(v.input)
+			if !assert.Equal(t, v.line, s, "line should match") {
+				return
+			}
+			assert.Equal(t, v.indices, indices, "indices should match")
+		})
+	}
+}
+
+func TestExternalCmdHighlight(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	idgen := &sequentialIDGen{}
+	script := `while read l; do case "$l" in *"$0"*) printf '0-3\t%s\n' "$l";; esac; done`
+	f := NewExternalCmd("test", "sh", []string{"-c", script, "$QUERY"}, 0, 0, idgen, false, HighlightPrefix, '\n')
+	ctx = f.NewContext(ctx, "bar")
+
+	ch := make(chan interface{}, 2)
+	lines := []line.Line{
+		line.NewRaw(0, "foo bar", false),
+		line.NewRaw(1, "baz qux", false),
+	}
+	if !assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "Apply should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, 1, len(ch), "one line should match") {
+		return
+	}
+	l := receiveLine(ch)
+	if !assert.Equal(t, "foo bar", l.DisplayString(), "line should match") {
+		return
+	}
+	assert.Equal(t, [][]int{{0, 3}}, l.(indexer).Indices(), "indices should match")
+}
+
+func TestExternalCmdHighlightFd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh and file descriptor inheritance")
+	}
+
+	lines := []line.Line{
+		line.NewRaw(0, "foo bar", false),
+		line.NewRaw(1, "baz qux", false),
+		line.NewRaw(2, "bar baz", false),
+	}
+	tests := []struct {
+		name    string
+		script  string
+		indices [][][]int
+	}{
+		{
+			name:    "records",
+			script:  `n=0; while read l; do case "$l" in *"$0"*) echo "$l"; printf '%d:0-3,4-7\n' $n >&3; n=$((n+1));; esac; done; echo 'bogus' >&3`,
+			indices: [][][]int{{{0, 3}, {4, 7}}, {{0, 3}, {4, 7}}},
+		},
+		{
+			name:    "out of range",
+			script:  `grep -e "$0"; printf '1:4-100\n0:5-6x\n' >&3`,
+			indices: [][][]int{nil, nil},
+		},
+		{
+			name:    "no records",
+			script:  `grep -e "$0"`,
+			indices: [][][]int{nil, nil},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			f := NewExternalCmd("test", "sh", []string{"-c", test.script, "$QUERY"}, 0, 0, &sequentialIDGen{}, false, HighlightFd, '\n')
+			ctx = f.NewContext(ctx, "bar")
+
+			ch := make(chan interface{}, len(lines))
+			if !assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "Apply should succeed") {
+				return
+			}
+			if !assert.Equal(t, 2, len(ch), "two lines should match") {
+				return
+			}
+			for i, expected := range []string{"foo bar", "bar baz"} {
+				l := receiveLine(ch)
+				if !assert.Equal(t, expected, l.DisplayString(), "line should match") {
+					return
+				}
+				assert.Equal(t, test.indices[i], l.(indexer).Indices(), "indices of line %d should match", i)
+			}
+		})
+	}
+}
+
+func TestExternalCmdDelimiter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The command prints its arguments, followed by the input
+	idgen := &sequentialIDGen{}
+	script := `printf '%s\0' "$0"; cat`
+	f := NewExternalCmd("test", "sh", []string{"-c", script, "query=$QUERY"}, 0, 0, idgen, false, HighlightNone, 0)
+	ctx = f.NewContext(ctx, "foo bar")
+
+	ch := make(chan interface{}, 3)
+	lines := []line.Line{
+		line.NewRaw(0, "foo\nbar", false),
+		line.NewRaw(1, "baz", false),
+	}
+	if !assert.NoError(t, f.Apply(ctx, lines, pipeline.ChanOutput(ch)), "Apply should succeed") {
+		return
+	}
+
+	if !assert.Equal(t, 3, len(ch), "all lines should be read") {
+		return
+	}
+	for _, expected := range []string{"query=foo bar", "foo\nbar", "baz"} {
+		if !assert.Equal(t, expected, receiveLine(ch).DisplayString(), "line should match") {
+			return
+		}
+	}
+}
+
+func TestExternalCmdRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	// failStart makes the first n attempts to start the command fail
+	// with err
+	failStart := func(f *ExternalCmd, n int, err error) *int {
+		var attempts int
+		f.retryDelay = time.Millisecond
+		f.startCmd = func(cmd *exec.Cmd) error {
+			attempts++
+			if attempts <= n {
+				return &os.PathError{Op: "fork/exec", Path: cmd.Path, Err: err}
+			}
+			return cmd.Start()
+		}
+		return &attempts
+	}
+
+	lines := []line.Line{line.NewRaw(0, "foo", false)}
+	apply := func(ctx context.Context, f *ExternalCmd) ([]string, error) {
+		ch := make(chan interface{}, 1)
+		err := f.Apply(f.NewContext(ctx, "foo"), lines, pipeline.ChanOutput(ch))
+		var out []string
+		for len(ch) > 0 {
+			out = append(out, receiveLine(ch).DisplayString())
+		}
+		return out, err
+	}
+
+	t.Run("transient", func(t *testing.T) {
+		f := NewExternalCmd("test", "cat", []string{"-"}, 0, 3, &sequentialIDGen{}, false, HighlightNone, '\n')
+		attempts := failStart(f, 2, syscall.EMFILE)
+		out, err := apply(context.Background(), f)
+		if !assert.NoError(t, err, "Apply should succeed after retrying") {
+			return
+		}
+		assert.Equal(t, 3, *attempts, "command should be started 3 times")
+		assert.Equal(t, []string{"foo"}, out, "output should be read")
+	})
+
+	t.Run("give up", func(t *testing.T) {
+		f := NewExternalCmd("test", "cat", []string{"-"}, 0, 1, &sequentialIDGen{}, false, HighlightNone, '\n')
+		attempts := failStart(f, 5, syscall.ENOMEM)
+		_, err := apply(context.Background(), f)
+		if !assert.Error(t, err, "Apply should fail") {
+			return
+		}
+		assert.Equal(t, 2, *attempts, "command should be started twice")
+		assert.Contains(t, err.Error(), syscall.ENOMEM.Error(), "error should include the cause")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		f := NewExternalCmd("test", "peco-no-such-command", nil, 0, 3, &sequentialIDGen{}, false, HighlightNone, '\n')
+		attempts := failStart(f, 0, nil)
+		_, err := apply(context.Background(), f)
+		if !assert.Error(t, err, "Apply should fail") {
+			return
+		}
+		assert.Equal(t, 1, *attempts, "missing commands should not be retried")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		f := NewExternalCmd("test", "cat", []string{"-"}, 0, 100, &sequentialIDGen{}, false, HighlightNone, '\n')
+		attempts := failStart(f, 100, syscall.EAGAIN)
+		f.retryDelay = 50 * time.Millisecond
+		time.AfterFunc(75*time.Millisecond, cancel)
+		_, err := apply(ctx, f)
+		if !assert.NoError(t, err, "canceled query should not fail") {
+			return
+		}
+		assert.Equal(t, 2, *attempts, "canceled query should not be retried")
+	})
+}
+
+type sequentialIDGen struct {
+	mutex sync.Mutex
+	next  uint64
+}
+
+func (g *sequentialIDGen) Next() uint64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.next++
+	return g.next
+}
+
+func TestExternalCmdTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	// The command prints its input, then hangs in a process of its own
+	// that keeps the output open
+	script := `cat; sleep 10`
+	lines := []line.Line{line.NewRaw(0, "foo", false)}
+	apply := func(ctx context.Context, f *ExternalCmd) ([]string, error) {
+		ch := make(chan interface{}, 1)
+		err := f.Apply(f.NewContext(ctx, "foo"), lines, pipeline.ChanOutput(ch))
+		var out []string
+		for len(ch) > 0 {
+			out = append(out, receiveLine(ch).DisplayString())
+		}
+		return out, err
+	}
+
+	for _, discard := range []bool{false, true} {
+		t.Run(fmt.Sprintf("discard=%t", discard), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			f := NewExternalCmd("test", "sh", []string{"-c", script}, 0, 0, &sequentialIDGen{}, false, HighlightNone, '\n')
+			f.SetTimeout(100*time.Millisecond, discard)
+			out, err := apply(ctx, f)
+			if !assert.IsType(t, &TimeoutError{}, err, "Apply should time out") {
+				return
+			}
+			if !assert.NoError(t, ctx.Err(), "command should be killed once the timeout elapses") {
+				return
+			}
+			if discard {
+				assert.Empty(t, out, "partial results should be discarded")
+			} else {
+				assert.Equal(t, []string{"foo"}, out, "partial results should be kept")
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		f := NewExternalCmd("test", "sh", []string{"-c", script}, 0, 0, &sequentialIDGen{}, false, HighlightNone, '\n')
+		f.SetTimeout(time.Minute, false)
+		_, err := apply(ctx, f)
+		assert.NoError(t, err, "canceled query should not time out")
+	})
+}
+
+func TestSizeSwitch(t *testing.T) {
+	external := NewExternalCmd("test", "false", nil, 0, 0, &sequentialIDGen{}, false, HighlightNone, '\n')
+	internal := NewIgnoreCase()
+	f := NewSizeSwitch(external, internal, 10)
+
+	assert.Equal(t, "test", f.String(), "name should be that of the external filter")
+	assert.Equal(t, internal, f.Select(0), "empty input should use the internal filter")
+	assert.Equal(t, internal, f.Select(9), "input below the threshold should use the internal filter")
+	assert.Equal(t, external, f.Select(10), "input at the threshold should use the external filter")
+	assert.Equal(t, external, f.Select(100), "input above the threshold should use the external filter")
+}
+
+func TestContents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires grep and /bin/sh")
+	}
+
+	dir, err := ioutil.TempDir("", "peco-contents")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.txt": "first line\n  Hello World  \nhello again\n",
+		"b.txt": "nothing to see\n",
+		"c.bin": "hello\x00world\n",
+	}
+	var lines []line.Line
+	for _, name := range []string{"a.txt", "b.txt", "c.bin", "missing"} {
+		filename := filepath.Join(dir, name)
+		if content, ok := files[name]; ok {
+			if !assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644), "WriteFile should succeed") {
+				return
+			}
+		}
+		lines = append(lines, line.NewRaw(uint64(len(lines)), filename, false))
+	}
+
+	apply := func(f *Contents, query string) ([]line.Line, error) {
+		ch := make(chan interface{}, len(lines))
+		err := f.Apply(f.NewContext(context.Background(), query), lines, pipeline.ChanOutput(ch))
+		var out []line.Line
+		for len(ch) > 0 {
+			out = append(out, receiveLine(ch))
+		}
+		return out, err
+	}
+
+	out, err := apply(NewContents("test", "", nil, 2, 0), "hello")
+	if !assert.NoError(t, err, "Apply should succeed") {
+		return
+	}
+	if assert.Len(t, out, 1, "only the text file containing the query should match") {
+		a := filepath.Join(dir, "a.txt")
+		assert.Equal(t, a+": Hello World", out[0].DisplayString(), "first matching line should be displayed")
+		assert.Equal(t, a, out[0].Output(), "name of the file should be output")
+		assert.Equal(t, uint64(0), out[0].ID(), "line should keep its ID")
+	}
+
+	f := NewContents("test", "sh", []string{"-c", `sleep 10; echo "$0"`}, 0, 100*time.Millisecond)
+	start := time.Now()
+	out, err = apply(f, "hello")
+	assert.IsType(t, &TimeoutError{}, err, "Apply should time out")
+	assert.Empty(t, out, "files that time out should not match")
+	assert.True(t, time.Since(start) < 5*time.Second, "commands should be killed once the timeout elapses")
+
+	_, err = apply(NewContents("test", "peco-no-such-command", nil, 0, 0), "hello")
+	assert.Error(t, err, "command that can't be started should be reported")
+}
+
+func TestIndexAllASCII(t *testing.T) {
+	testValues := []struct {
+		s        string
+		sub      string
+		fold     bool
+		expected [][]int
+	}{
+		{"foo bar foo", "foo", false, [][]int{{0, 3}, {8, 11}}},
+		{"Foo bar FOO", "foo", false, nil},
+		{"Foo bar FOO", "foo", true, [][]int{{0, 3}, {8, 11}}},
+		{"aaaa", "aa", true, [][]int{{0, 2}, {2, 4}}},
+		{"fOfOo", "foo", true, [][]int{{2, 5}}},
+		{"[a-z] 1+1", "1+1", true, [][]int{{6, 9}}},
+		{"fo", "foo", true, nil},
+		{"", "foo", false, nil},
+	}
+	for _, v := range testValues {
+		assert.Equal(t, v.expected, indexAllASCII(v.s, v.sub, v.fold), "occurrences of %q in %q (fold: %t)", v.sub, v.s, v.fold)
+	}
+}
+
+// applyAll runs the filter over lines, and returns the matched lines
+// as their text followed by their indices
+func applyAll(ctx context.Context, f Filter, lines []line.Line) ([]string, error) {
+	ch := make(chan interface{}, len(lines))
+	if err := f.Apply(ctx, lines, pipeline.ChanOutput(ch)); err != nil {
+		return nil, err
+	}
+	close(ch)
+
+	var got []string
+	for v := range ch {
+		l := v.(line.Line)
+		got = append(got, fmt.Sprintf("%s %v", l.DisplayString(), l.(indexer).Indices()))
+	}
+	return got, nil
+}
+
+func TestASCII(t *testing.T) {
+	var lines []line.Line
+	for i, s := range []string{"Foo bar", "foo.bar baz", "FOOFOO", "the cat sat", "category: 1+1", "a,b,Foo"} {
+		lines = append(lines, line.NewRaw(uint64(i), s, false))
+	}
+
+	testValues := []struct {
+		filter Filter
+		query  string
+	}{
+		{NewIgnoreCase(), "foo"},
+		{NewIgnoreCase(), "FOO bar"},
+		{NewIgnoreCase(), "o.b"},
+		{NewIgnoreCase(), "foo !baz"},
+		{NewIgnoreCase(), "3:foo"},
+		{NewIgnoreCase(), `\bcat\b`},
+		{NewIgnoreCase(), "1+1"},
+		{NewIgnoreCase(), "café"},
+		{NewCaseSensitive(), "Foo"},
+		{NewCaseSensitive(), "FOO"},
+		{NewSmartCase(), "foo"},
+		{NewSmartCase(), "Foo"},
+	}
+
+	for _, v := range testValues {
+		for _, word := range []bool{false, true} {
+			ctx := v.filter.NewContext(context.Background(), v.query)
+			ctx = WithFieldDelimiter(ctx, ",")
+			if word {
+				ctx = WithWholeWord(ctx)
+			}
+			expected, err := applyAll(ctx, v.filter, lines)
+			if !assert.NoError(t, err, "filter.Apply should succeed") {
+				return
+			}
+			got, err := applyAll(WithASCII(ctx), v.filter, lines)
+			if !assert.NoError(t, err, "filter.Apply should succeed") {
+				return
+			}
+			assert.Equal(t, expected, got, "%s should match %q the same way with ASCII input (whole word: %t)", v.filter, v.query, word)
+		}
+	}
+}
+
+func BenchmarkIgnoreCase(b *testing.B) {
+	words := []string{"Alpha", "bravo", "CHARLIE", "delta", "Echo", "foxtrot", "golf", "Hotel"}
+	lines := make([]line.Line, 10000)
+	for i := range lines {
+		s := fmt.Sprintf("/src/%s/%s/%s_%d.go", words[i%len(words)], words[(i/3)%len(words)], words[(i/7)%len(words)], i)
+		lines[i] = line.NewRaw(uint64(i), s, false)
+	}
+
+	for _, ascii := range []bool{false, true} {
+		b.Run(fmt.Sprintf("ascii=%t", ascii), func(b *testing.B) {
+			f := NewIgnoreCase()
+			ctx := f.NewContext(context.Background(), "hotel echo")
+			if ascii {
+				ctx = WithASCII(ctx)
+			}
+			ch := make(chan interface{}, len(lines))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := f.Apply(ctx, lines, pipeline.ChanOutput(ch)); err != nil {
+					b.Fatal(err)
+				}
+				for len(ch) > 0 {
+					<-ch
+				}
+			}
+		})
+	}
+}
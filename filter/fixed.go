@@ -0,0 +1,50 @@
+package filter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// NewFixedString builds a filter that matches the lines that contain
+// the entire query as is. Unlike the other filters, the query is not
+// split into terms, and nothing in it has a special meaning: spaces,
+// "!", "\" and the characters of regular expressions all stand for
+// themselves. The first occurrence of the query is highlighted
+func NewFixedString() *FixedString {
+	return &FixedString{}
+}
+
+func (ff FixedString) BufSize() int {
+	return 0
+}
+
+func (ff *FixedString) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (ff FixedString) String() string {
+	return "FixedString"
+}
+
+// IsSubsetQuery returns true if query contains prev, as the lines that
+// contain query then contain prev too
+func (ff *FixedString) IsSubsetQuery(prev, query string) bool {
+	return strings.Contains(query, prev)
+}
+
+func (ff *FixedString) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := ctx.Value(queryKey).(string)
+	for _, l := range lines {
+		i := strings.Index(line.MatchString(l), query)
+		if i < 0 {
+			continue
+		}
+		if err := out.SendCtx(ctx, line.NewMatched(l, [][]int{{i, i + len(query)}})); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package filter
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// foldEquivalents lists the letters that the case-insensitive
+// filters treat as the same letter, even though they are not case
+// variants of each other according to the simple case folding rules
+// used by the (?i) flag. The dotted and dotless i are folded together
+// so that queries match regardless of the Turkish casing rules
+var foldEquivalents = map[rune]string{
+	'i': `[iIİı]`,
+	'I': `[iIİı]`,
+	'İ': `[iIİı]`,
+	'ı': `[iIİı]`,
+	'ß': sharpS,
+	'ẞ': sharpS,
+}
+
+// sharpS matches the sharp s and its full case folding "ss". The (?i)
+// flag takes care of "SS", "ẞ" and everything in between
+const sharpS = `(?:ss|ß)`
+
+func isLetterS(r rune) bool {
+	return r == 's' || r == 'S'
+}
+
+// quoteFold is like regexp.QuoteMeta, but the returned expression also
+// matches the case variants of q that the (?i) flag alone does not. It
+// must be compiled with the (?i) flag. Since the expression is matched
+// against the line as is, the matched regions always refer to the
+// original bytes of the line, even where folding changes the length
+// of the text, as in "ß" and "ss"
+func quoteFold(q string) string {
+	var buf bytes.Buffer
+	runes := []rune(q)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if isLetterS(r) && i+1 < len(runes) && isLetterS(runes[i+1]) {
+			buf.WriteString(sharpS)
+			i++
+			continue
+		}
+
+		if v, ok := foldEquivalents[r]; ok {
+			buf.WriteString(v)
+			continue
+		}
+		buf.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	return buf.String()
+}
+
+// extendsSharpS returns true if query, which starts with prev, folds
+// a trailing "s" of prev into "ss", which also matches "ß"
+func extendsSharpS(prev, query string) bool {
+	return strings.HasSuffix(strings.ToLower(prev), "s") &&
+		strings.HasPrefix(strings.ToLower(query[len(prev):]), "s")
+}
+
+func hasIgnoreCaseFlag(flags []string) bool {
+	for _, f := range flags {
+		if strings.Contains(f, "i") {
+			return true
+		}
+	}
+	return false
+}
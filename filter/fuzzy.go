@@ -1,10 +1,13 @@
 package filter
 
 import (
+	"bytes"
 	"context"
+	"regexp"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/peco/peco/fuzzy"
 	"github.com/peco/peco/internal/util"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
@@ -12,11 +15,23 @@ import (
 
 // NewFuzzy builds a fuzzy-finder type of filter.
 // In effect, this uses a smart case filter, and for q query
-// like "ABC" it matches the equivalent of "A(.*)B(.*)C(.*)"
+// like "ABC" it matches the equivalent of "A(.*)B(.*)C(.*)".
+//
+// The query is split into terms separated by spaces, and lines must
+// match all of them. Each term is matched fuzzily, unless it asks for
+// another kind of match with a sigil. See parseFuzzyTerm
 func NewFuzzy() *Fuzzy {
 	return &Fuzzy{}
 }
 
+// NewFuzzyContiguous builds a Fuzzy filter named name, which rejects the
+// lines where the characters of a term are too scattered: at least n of
+// them must be matched one after the other. A term shorter than n must
+// be contained in the line as is
+func NewFuzzyContiguous(name string, n int) *Fuzzy {
+	return &Fuzzy{name: name, minContiguous: n}
+}
+
 func (ff Fuzzy) BufSize() int {
 	return 0
 }
@@ -25,45 +40,479 @@ func (ff *Fuzzy) NewContext(ctx context.Context, query string) context.Context {
 	return newContext(ctx, query)
 }
 
+// IsSubsetQuery returns true if query only extends the terms of prev,
+// or adds more terms to them. See isFuzzySubsetQuery
+func (ff *Fuzzy) IsSubsetQuery(prev, query string) bool {
+	return ff.minContiguous <= 1 && isFuzzySubsetQuery(prev, query)
+}
+
 func (ff Fuzzy) String() string {
+	if ff.name != "" {
+		return ff.name
+	}
 	return "Fuzzy"
 }
 
 func (ff *Fuzzy) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
-	originalQuery := ctx.Value(queryKey).(string)
-	hasUpper := util.ContainsUpper(originalQuery)
+	query := ctx.Value(queryKey).(string)
+	delim, fields := fieldDelimiter(ctx)
+	accents := ignoreAccents(ctx)
+	if accents {
+		query, _ = foldAccents(query)
+	}
+	terms := parseFuzzyQuery(query, fields)
+	for i := range terms {
+		terms[i].minContiguous = ff.minContiguous
+	}
+	anchorFuzzyQuery(ctx, terms)
 
 OUTER:
 	for _, l := range lines {
-		base := 0
+		v := line.MatchString(l)
+		var offsets []int
+		if accents {
+			v, offsets = foldAccents(v)
+		}
 		matches := [][]int{}
-		txt := l.DisplayString()
-		query := originalQuery
-		for len(query) > 0 {
-			r, n := utf8.DecodeRuneInString(query)
-			query = query[n:]
-			if r == utf8.RuneError {
-				// "Silently" ignore
+		for _, t := range terms {
+			m := t.match(v, delim)
+			if m == nil {
 				continue OUTER
 			}
+			matches = append(matches, m...)
+		}
+		if len(terms) > 1 {
+			matches = dedupMatches(matches)
+		}
+		if err := out.SendCtx(ctx, line.NewMatched(l, unfoldMatches(matches, offsets))); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseFuzzyTerm tells how a term of the query of the Fuzzy filters is
+// matched, from the sigils around it:
+//
+//	'foo   contains "foo" as is
+//	^foo   starts with "foo"
+//	foo$   ends with "foo"
+//	^foo$  is "foo" and nothing else
+//	foo    contains the characters of "foo", in order
+//
+// The rest of a term that starts with "'" is used as is. Otherwise, a
+// leading "\'" or "\^", or a trailing "\$", is unescaped into the literal
+// character. A sigil on its own is a literal character too. The returned
+// term has no field, and still needs to be compiled
+func parseFuzzyTerm(term string) fuzzyTerm {
+	if len(term) > 1 && term[0] == '\'' {
+		return fuzzyTerm{kind: fuzzyTermExact, text: term[1:]}
+	}
+
+	var prefix, suffix bool
+	switch {
+	case strings.HasPrefix(term, `\'`), strings.HasPrefix(term, `\^`):
+		term = term[1:]
+	case len(term) > 1 && term[0] == '^':
+		prefix = true
+		term = term[1:]
+	}
+
+	switch {
+	case strings.HasSuffix(term, `\$`):
+		term = term[:len(term)-2] + "$"
+	case len(term) > 1 && term[len(term)-1] == '$':
+		suffix = true
+		term = term[:len(term)-1]
+	}
+
+	kind := fuzzyTermFuzzy
+	switch {
+	case prefix && suffix:
+		kind = fuzzyTermWhole
+	case prefix:
+		kind = fuzzyTermPrefix
+	case suffix:
+		kind = fuzzyTermSuffix
+	}
+	return fuzzyTerm{kind: kind, text: term}
+}
+
+// parseFuzzyQuery splits the query into terms, and compiles them. If
+// fields is true, terms may be prefixed with "N:" to match against a
+// single field. The prefix comes before the sigils, as in "2:^foo"
+func parseFuzzyQuery(query string, fields bool) []fuzzyTerm {
+	var terms []fuzzyTerm
+	for _, q := range splitQuery(query) {
+		var field int
+		if fields {
+			field, q = parseFieldTerm(q)
+		}
+		t := parseFuzzyTerm(q)
+		t.field = field
+		t.compile()
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// anchorFuzzyQuery anchors the first term of the query, if it is fuzzy
+// and the Fuzzy filters are anchored. See WithFuzzyAnchor
+func anchorFuzzyQuery(ctx context.Context, terms []fuzzyTerm) {
+	seps, ok := fuzzyAnchor(ctx)
+	if !ok || len(terms) == 0 || terms[0].kind != fuzzyTermFuzzy {
+		return
+	}
+	terms[0].anchored = true
+	terms[0].separators = seps
+}
+
+// anchorOf returns the position in txt that an anchored term starts
+// at: right after the last of the separators, or 0 if there is none
+func anchorOf(txt, separators string) int {
+	i := strings.LastIndexAny(txt, separators)
+	if i < 0 {
+		return 0
+	}
+	_, n := utf8.DecodeRuneInString(txt[i:])
+	return i + n
+}
+
+// startsAt returns matches if the first of them starts at pos, and nil
+// otherwise
+func startsAt(matches [][]int, pos int) [][]int {
+	if len(matches) == 0 || matches[0][0] != pos {
+		return nil
+	}
+	return matches
+}
+
+// compile prepares the regular expression that the terms that are not
+// fuzzy are matched with. Like fuzzy terms, they are case sensitive
+// only if they contain an upper case character
+func (t *fuzzyTerm) compile() {
+	if t.kind == fuzzyTermFuzzy {
+		return
+	}
+
+	var buf bytes.Buffer
+	quoted := regexp.QuoteMeta(t.text)
+	if !util.ContainsUpper(t.text) {
+		buf.WriteString("(?i)")
+		quoted = quoteFold(t.text)
+	}
+	if t.kind == fuzzyTermPrefix || t.kind == fuzzyTermWhole {
+		buf.WriteByte('^')
+	}
+	buf.WriteString(quoted)
+	if t.kind == fuzzyTermSuffix || t.kind == fuzzyTermWhole {
+		buf.WriteByte('$')
+	}
+
+	// A term that fails to compile matches nothing
+	rx, _ := regexp.Compile(buf.String())
+	t.rx = regexpTerm{rx: rx, field: t.field}
+}
+
+// match matches the term against the line. The returned indices are
+// relative to the entire line. Returns nil if the term does not match
+func (t fuzzyTerm) match(v, delim string) [][]int {
+	if t.kind != fuzzyTermFuzzy {
+		return t.rx.match(v, delim, false, false)
+	}
+
+	txt, base, ok := fieldOf(v, delim, t.field)
+	if !ok {
+		return nil
+	}
+	if t.anchored {
+		// The characters are matched as early as possible, so the
+		// first one is at the anchor if it can be
+		a := anchorOf(txt, t.separators)
+		txt, base = txt[a:], base+a
+		if t.minContiguous > 1 {
+			return startsAt(contiguousIndices(t.text, txt, base, t.minContiguous), base)
+		}
+		return startsAt(fuzzyIndices(t.text, txt, base), base)
+	}
+	if t.minContiguous > 1 {
+		return contiguousIndices(t.text, txt, base, t.minContiguous)
+	}
+	return fuzzyIndices(t.text, txt, base)
+}
+
+// score is like match, but it also returns the score of the match, as
+// computed by fuzzy.Score. Only fuzzy terms are scored: the score of
+// the other terms is 0
+func (t fuzzyTerm) score(v, delim string) (int, [][]int) {
+	if t.kind != fuzzyTermFuzzy {
+		return 0, t.match(v, delim)
+	}
+
+	txt, base, ok := fieldOf(v, delim, t.field)
+	if !ok {
+		return 0, nil
+	}
+	scoreFunc := fuzzy.Score
+	if t.anchored {
+		a := anchorOf(txt, t.separators)
+		txt, base = txt[a:], base+a
+		scoreFunc = fuzzy.ScorePrefix
+	}
+	score, offsets := scoreFunc(t.text, txt)
+	if offsets == nil {
+		return 0, nil
+	}
+	if t.minContiguous > 1 {
+		// The score still tells how good the match is, but the line is
+		// only accepted, and highlighted, if it has the run of
+		// characters that is required
+		matches := contiguousIndices(t.text, txt, base, t.minContiguous)
+		if t.anchored {
+			matches = startsAt(matches, base)
+		}
+		if matches == nil {
+			return 0, nil
+		}
+		return score, matches
+	}
+
+	matches := make([][]int, len(offsets))
+	for i, offset := range offsets {
+		_, n := utf8.DecodeRuneInString(txt[offset:])
+		matches[i] = []int{base + offset, base + offset + n}
+	}
+	return score, matches
+}
+
+// fuzzyIndices matches the characters of query against txt, in order.
+// The first occurrence of each character after the previous one is
+// matched. The returned indices are offset by base. Returns nil if the
+// characters do not match
+func fuzzyIndices(query, txt string, base int) [][]int {
+	hasUpper := util.ContainsUpper(query)
+	matches := [][]int{}
+	for len(query) > 0 {
+		r, n := utf8.DecodeRuneInString(query)
+		query = query[n:]
+		if r == utf8.RuneError {
+			// "Silently" ignore
+			return nil
+		}
+
+		var i int
+		if hasUpper { // explicit match
+			i = strings.IndexRune(txt, r)
+		} else {
+			i = strings.IndexFunc(txt, util.CaseInsensitiveIndexFunc(r))
+		}
+		if i == -1 {
+			return nil
+		}
+
+		// otherwise we have a match, but the next match must match against
+		// something AFTER the current match
+		txt = txt[i+n:]
+		matches = append(matches, []int{base + i, base + i + n})
+		base = base + i + n
+	}
+	return matches
+}
+
+// contiguousIndices is like fuzzyIndices, but the characters of query
+// only match if n of them, or all of them if there are fewer, are found
+// one after the other in txt. This run is returned as a single match.
+// Other characters are matched as early as possible, and so is the earliest
+// run that leaves room for the rest of the characters
+func contiguousIndices(query, txt string, base, n int) [][]int {
+	runes := []rune(query)
+	if n > len(runes) {
+		n = len(runes)
+	}
+	hasUpper := util.ContainsUpper(query)
+
+	// Try each run of n characters of the query, from the first one
+	for i := 0; i+n <= len(runes); i++ {
+		matches := [][]int{}
+		pos, ok := matchRunes(runes[:i], txt, 0, hasUpper, &matches)
+		if !ok {
+			// The characters before the run don't fit, and there are
+			// more of them with the next run
+			return nil
+		}
+
+		start, end := -1, -1
+		for p := pos; p < len(txt); {
+			if e, ok := runAt(runes[i:i+n], txt, p, hasUpper); ok {
+				start, end = p, e
+				break
+			}
+			_, size := utf8.DecodeRuneInString(txt[p:])
+			p += size
+		}
+		if start == -1 {
+			continue
+		}
+		matches = append(matches, []int{start, end})
+
+		if _, ok := matchRunes(runes[i+n:], txt, end, hasUpper, &matches); !ok {
+			continue
+		}
+		for _, m := range matches {
+			m[0] += base
+			m[1] += base
+		}
+		return matches
+	}
+	return nil
+}
+
+// matchRunes matches runes against txt from pos on, in order, appending
+// the indices of each of them to matches. Returns the position after the
+// last one, and false if they do not all match
+func matchRunes(runes []rune, txt string, pos int, hasUpper bool, matches *[][]int) (int, bool) {
+	for _, r := range runes {
+		var i int
+		if hasUpper {
+			i = strings.IndexRune(txt[pos:], r)
+		} else {
+			i = strings.IndexFunc(txt[pos:], util.CaseInsensitiveIndexFunc(r))
+		}
+		if i == -1 {
+			return 0, false
+		}
+		_, size := utf8.DecodeRuneInString(txt[pos+i:])
+		*matches = append(*matches, []int{pos + i, pos + i + size})
+		pos += i + size
+	}
+	return pos, true
+}
+
+// runAt returns the position after runes if they are found one after
+// the other in txt, starting at pos
+func runAt(runes []rune, txt string, pos int, hasUpper bool) (int, bool) {
+	for _, r := range runes {
+		if pos >= len(txt) {
+			return 0, false
+		}
+		c, size := utf8.DecodeRuneInString(txt[pos:])
+		if c != r && (hasUpper || !util.CaseInsensitiveIndexFunc(r)(c)) {
+			return 0, false
+		}
+		pos += size
+	}
+	return pos, true
+}
+
+// isFuzzySubsetQuery returns true if every line that matches query
+// also matches prev, where query only appends to prev. This is the
+// case if the terms of prev are the same in query, except for the last
+// one, which can be extended as long as it is matched the same way.
+// Anchoring a term at the end is not considered an extension, nor is
+// typing after a trailing "$". Queries that may contain a field prefix
+// are excluded, as appending to the prefix changes the field being
+// matched
+func isFuzzySubsetQuery(prev, query string) bool {
+	if !strings.HasPrefix(query, prev) || strings.ContainsRune(query, ':') {
+		return false
+	}
 
-			var i int
-			if hasUpper { // explicit match
-				i = strings.IndexRune(txt, r)
-			} else {
-				i = strings.IndexFunc(txt, util.CaseInsensitiveIndexFunc(r))
+	prevTerms := splitQuery(prev)
+	terms := splitQuery(query)
+	if len(terms) < len(prevTerms) {
+		return false
+	}
+	for i, pt := range prevTerms {
+		a := parseFuzzyTerm(pt)
+		b := parseFuzzyTerm(terms[i])
+		if a.kind != b.kind {
+			return false
+		}
+
+		switch a.kind {
+		case fuzzyTermSuffix, fuzzyTermWhole:
+			if a.text != b.text {
+				return false
 			}
-			if i == -1 {
-				continue OUTER
+		default:
+			if !strings.HasPrefix(b.text, a.text) {
+				return false
 			}
+		}
+	}
+	return true
+}
+
+// NewFuzzyRanked builds a fuzzy-finder type of filter that ranks the
+// matched lines by their score as computed by fuzzy.Score, so that the
+// best matches are displayed first
+func NewFuzzyRanked() *FuzzyRanked {
+	return &FuzzyRanked{}
+}
+
+// NewFuzzyRankedContiguous is like NewFuzzyContiguous, but builds a
+// FuzzyRanked filter
+func NewFuzzyRankedContiguous(name string, n int) *FuzzyRanked {
+	return &FuzzyRanked{name: name, minContiguous: n}
+}
+
+func (ff FuzzyRanked) BufSize() int {
+	return 0
+}
+
+func (ff *FuzzyRanked) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
 
-			// otherwise we have a match, but the next match must match against
-			// something AFTER the current match
-			txt = txt[i+n:]
-			matches = append(matches, []int{base + i, base + i + n})
-			base = base + i + n
+// IsSubsetQuery returns true if query only extends the terms of prev,
+// or adds more terms to them. See isFuzzySubsetQuery
+func (ff *FuzzyRanked) IsSubsetQuery(prev, query string) bool {
+	return ff.minContiguous <= 1 && isFuzzySubsetQuery(prev, query)
+}
+
+func (ff FuzzyRanked) String() string {
+	if ff.name != "" {
+		return ff.name
+	}
+	return "FuzzyRanked"
+}
+
+func (ff *FuzzyRanked) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := ctx.Value(queryKey).(string)
+	delim, fields := fieldDelimiter(ctx)
+	accents := ignoreAccents(ctx)
+	if accents {
+		query, _ = foldAccents(query)
+	}
+	terms := parseFuzzyQuery(query, fields)
+	for i := range terms {
+		terms[i].minContiguous = ff.minContiguous
+	}
+	anchorFuzzyQuery(ctx, terms)
+
+OUTER:
+	for _, l := range lines {
+		v := line.MatchString(l)
+		var offsets []int
+		if accents {
+			v, offsets = foldAccents(v)
+		}
+		var total int
+		matches := [][]int{}
+		for _, t := range terms {
+			score, m := t.score(v, delim)
+			if m == nil {
+				continue OUTER
+			}
+			total += score
+			matches = append(matches, m...)
+		}
+		if len(terms) > 1 {
+			matches = dedupMatches(matches)
+		}
+		if err := out.SendCtx(ctx, NewRanked(l, unfoldMatches(matches, offsets), total)); err != nil {
+			return nil
 		}
-		out.Send(line.NewMatched(l, matches))
 	}
 	return nil
 }
@@ -3,6 +3,7 @@ package filter
 import (
 	"context"
 	"errors"
+	"os/exec"
 	"regexp"
 	"sync"
 	"time"
@@ -18,10 +19,49 @@ var defaultFlags = regexpFlagList{}
 var queryKey = &struct{}{}
 var incomingBufferKey = &struct{}{}
 
+// Unlike pointers to zero-size values, values of distinct named types
+// never compare equal to other context keys
+type fieldDelimiterKeyType struct{}
+
+var fieldDelimiterKey = fieldDelimiterKeyType{}
+
+type wholeWordKeyType struct{}
+
+var wholeWordKey = wholeWordKeyType{}
+
+type ignoreAccentsKeyType struct{}
+
+var ignoreAccentsKey = ignoreAccentsKeyType{}
+
+type asciiKeyType struct{}
+
+var asciiKey = asciiKeyType{}
+
+type fuzzyAnchorKeyType struct{}
+
+var fuzzyAnchorKey = fuzzyAnchorKeyType{}
+
 // DefaultCustomFilterBufferThreshold is the default value
 // for BufferThreshold setting on CustomFilters.
 const DefaultCustomFilterBufferThreshold = 100
 
+// DefaultContentsConcurrency is the default number of files that a
+// Contents filter searches at the same time
+const DefaultContentsConcurrency = 8
+
+// defaultContentsArgs are the arguments given to grep by a Contents
+// filter that does not name a command: the first line that contains
+// the query, ignoring case, is printed, and binary files do not match
+var defaultContentsArgs = []string{"-m", "1", "-I", "-F", "-i", "-e", "$QUERY", "--", "$FILE"}
+
+// customFilterRetryDelay is the time to wait before trying to start a
+// custom filter again. The delay doubles with each attempt, up to
+// maxCustomFilterRetryDelay
+const (
+	customFilterRetryDelay    = 10 * time.Millisecond
+	maxCustomFilterRetryDelay = time.Second
+)
+
 type Set struct {
 	current int
 	filters []Filter
@@ -43,11 +83,112 @@ type regexpQueryFactory struct {
 }
 
 type regexpQuery struct {
-	rx       []*regexp.Regexp
+	rx       []regexpTerm
+	negated  []regexpTerm
 	lastUsed time.Time
 }
 
+// regexpTerm is a compiled query term. field is the field of the line
+// that the term is matched against, or 0 for the entire line
+type regexpTerm struct {
+	rx        *regexp.Regexp
+	field     int
+	wholeWord bool // only match entire words, see WithWholeWord
+
+	// literal is the term as is, if it is only made of ASCII
+	// characters and is not a regular expression. fold is true if
+	// case is ignored. See WithASCII
+	literal string
+	fold    bool
+}
+
+// fuzzyTermKind tells how a term of the query of the Fuzzy filters is
+// matched. See parseFuzzyTerm
+type fuzzyTermKind int
+
+const (
+	fuzzyTermFuzzy  fuzzyTermKind = iota // the characters, in order
+	fuzzyTermExact                       // 'term: the term as is
+	fuzzyTermPrefix                      // ^term: the term at the start
+	fuzzyTermSuffix                      // term$: the term at the end
+	fuzzyTermWhole                       // ^term$: the term and nothing else
+)
+
+// fuzzyTerm is a term of the query of the Fuzzy filters. field is the
+// field of the line that the term is matched against, or 0 for the
+// entire line. The terms that are not fuzzy are matched using rx. If
+// minContiguous is more than 1, fuzzy terms only match if that many of
+// their characters are matched one after the other
+type fuzzyTerm struct {
+	kind          fuzzyTermKind
+	text          string
+	field         int
+	rx            regexpTerm
+	minContiguous int
+	anchored      bool   // the first character must be at the anchor, see WithFuzzyAnchor
+	separators    string // the anchor is after the last of these, if any
+}
+
+// Fuzzy matches the characters of the query in order. See NewFuzzy and
+// NewFuzzyContiguous
 type Fuzzy struct {
+	name          string
+	minContiguous int
+}
+
+// FuzzyRanked is like Fuzzy, but ranks the lines by their score. See
+// NewFuzzyRanked and NewFuzzyRankedContiguous
+type FuzzyRanked struct {
+	name          string
+	minContiguous int
+}
+
+// Numeric matches lines by comparing the number in a column of the
+// line with the query. See NewNumeric
+type Numeric struct {
+	column int
+}
+
+// numericTerm is a comparison of the query of the Numeric filter. A
+// number matches if it lies within the bounds that are set, or outside
+// of them if not is true
+type numericTerm struct {
+	lo, hi         float64
+	hasLo, hasHi   bool
+	exclLo, exclHi bool // the bound itself does not match
+	not            bool
+}
+
+// FixedString matches the lines that contain the entire query, without
+// interpreting it. See NewFixedString
+type FixedString struct{}
+
+// Chain matches lines against several filters in sequence, so that
+// each filter only sees the lines matched by the previous one. See
+// NewChain
+type Chain struct {
+	filters []Filter
+}
+
+// chainLink is a filter after the first one in a Chain. It merges the
+// regions that it matches with those matched by the previous filters
+type chainLink struct {
+	Filter
+}
+
+// Union matches lines that match any of its queries, using another
+// Filter to match each query
+type Union struct {
+	filter  Filter
+	queries []string
+}
+
+// SizeSwitch matches lines with one of two filters, depending on the
+// size of the input. See NewSizeSwitch
+type SizeSwitch struct {
+	external  Filter
+	internal  Filter
+	threshold int
 }
 
 type Regexp struct {
@@ -60,14 +201,51 @@ type Regexp struct {
 	outCh     pipeline.ChanOutput
 }
 
+// HighlightMode tells how an ExternalCmd tells peco where the lines
+// that it prints matched the query
+type HighlightMode int
+
+const (
+	HighlightNone   HighlightMode = iota // nothing is highlighted
+	HighlightPrefix                      // each line is prefixed with the regions, see parseHighlightedLine
+	HighlightFd                          // the regions are written on fd 3, see parseHighlightRecord
+)
+
 type ExternalCmd struct {
 	args            []string
 	cmd             string
+	delim           byte
 	enableSep       bool
+	highlight       HighlightMode
 	idgen           line.IDGenerator
 	outCh           pipeline.ChanOutput
 	name            string
+	retries         int           // number of times to retry starting the command
+	retryDelay      time.Duration // delay before the first retry
+	startCmd        func(*exec.Cmd) error
 	thresholdBufsiz int
+	timeout         time.Duration // how long each invocation may run, 0 for no limit
+	discardPartial  bool          // drop the lines printed by invocations that time out
+}
+
+// Contents matches the files named by the lines against the query,
+// using an external command such as grep. See NewContents
+type Contents struct {
+	args        []string
+	cmd         string
+	concurrency int // number of files searched at the same time
+	name        string
+	timeout     time.Duration // how long each file may take, 0 for no limit
+}
+
+// TimeoutError is returned by ExternalCmd.Apply when the command took
+// longer than the timeout given to SetTimeout, and had to be killed. It
+// is also returned by Contents.Apply when some of the files could not
+// be searched in time
+type TimeoutError struct {
+	Name      string        // name of the filter
+	Timeout   time.Duration // the timeout that elapsed
+	Discarded bool          // true if the lines printed so far were dropped
 }
 
 type Filter interface {
@@ -76,3 +254,18 @@ type Filter interface {
 	NewContext(context.Context, string) context.Context
 	String() string
 }
+
+// Incremental is implemented by filters that can tell if the lines
+// matched by a query are always a subset of the lines matched by a
+// previous query. When this is the case, the previous results can be
+// filtered instead of the entire input
+type Incremental interface {
+	IsSubsetQuery(prev, query string) bool
+}
+
+// QueryValidator is implemented by filters that do not accept every
+// query, such as the Regexp filter, whose queries must be valid regular
+// expressions. Filters that do not implement it accept any query
+type QueryValidator interface {
+	IsValidQuery(query string) bool
+}
@@ -0,0 +1,195 @@
+package filter
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+)
+
+// NewNumeric builds a filter that matches lines whose number satisfies
+// every term of the query. The number is the first one found in the
+// column-th field of the line, or in the entire line if column is 0.
+// Fields are split by the delimiter set by WithFieldDelimiter, or on
+// white spaces otherwise, and negative columns count from the last
+// field. Lines that do not have a number there never match. See
+// parseNumericTerm for the terms that the query may contain
+func NewNumeric(column int) *Numeric {
+	return &Numeric{column: column}
+}
+
+func (nf Numeric) BufSize() int {
+	return 0
+}
+
+func (nf *Numeric) NewContext(ctx context.Context, query string) context.Context {
+	return newContext(ctx, query)
+}
+
+func (nf Numeric) String() string {
+	return "Numeric"
+}
+
+// IsValidQuery returns true if every term of the query is a valid
+// comparison. Apply fails on the queries that are not
+func (nf *Numeric) IsValidQuery(query string) bool {
+	_, err := parseNumericQuery(query)
+	return err == nil
+}
+
+func (nf *Numeric) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	query := ctx.Value(queryKey).(string)
+	terms, err := parseNumericQuery(query)
+	if err != nil {
+		return err
+	}
+	delim, ok := fieldDelimiter(ctx)
+	if !ok {
+		delim = " "
+	}
+
+OUTER:
+	for _, l := range lines {
+		txt, base, ok := fieldOf(line.MatchString(l), delim, nf.column)
+		if !ok {
+			continue
+		}
+		n, start, end, ok := numberIn(txt)
+		if !ok {
+			continue
+		}
+		for _, t := range terms {
+			if !t.match(n) {
+				continue OUTER
+			}
+		}
+		if err := out.SendCtx(ctx, line.NewMatched(l, [][]int{{base + start, base + end}})); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseNumericQuery splits the query into terms, all of which a number
+// must satisfy
+func parseNumericQuery(query string) ([]numericTerm, error) {
+	var terms []numericTerm
+	for _, q := range splitQuery(query) {
+		t, err := parseNumericTerm(q)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+// parseNumericTerm parses a term of the query of the Numeric filter:
+//
+//	>10 >=10 <10 <=10   compares the number with 10
+//	10 =10              is 10
+//	!=10                is not 10
+//	10..20              is between 10 and 20, both included
+//	10.. ..20           is at least 10, or at most 20
+func parseNumericTerm(term string) (numericTerm, error) {
+	var t numericTerm
+	var err error
+
+	if i := strings.Index(term, ".."); i >= 0 {
+		lo, hi := term[:i], term[i+2:]
+		if lo == "" && hi == "" {
+			return t, errors.Errorf("invalid numeric range '%s'", term)
+		}
+		if lo != "" {
+			if t.lo, err = parseNumber(lo); err != nil {
+				return t, errors.Wrapf(err, "invalid numeric range '%s'", term)
+			}
+			t.hasLo = true
+		}
+		if hi != "" {
+			if t.hi, err = parseNumber(hi); err != nil {
+				return t, errors.Wrapf(err, "invalid numeric range '%s'", term)
+			}
+			t.hasHi = true
+		}
+		if t.hasLo && t.hasHi && t.lo > t.hi {
+			return t, errors.Errorf("invalid numeric range '%s': %s is greater than %s", term, lo, hi)
+		}
+		return t, nil
+	}
+
+	op := term[:len(term)-len(strings.TrimLeft(term, "<>=!"))]
+	n, err := parseNumber(term[len(op):])
+	if err != nil {
+		return t, errors.Wrapf(err, "invalid numeric comparison '%s'", term)
+	}
+	switch op {
+	case "", "=":
+		t.lo, t.hi, t.hasLo, t.hasHi = n, n, true, true
+	case "!=":
+		t.lo, t.hi, t.hasLo, t.hasHi, t.not = n, n, true, true, true
+	case ">", ">=":
+		t.lo, t.hasLo, t.exclLo = n, true, op == ">"
+	case "<", "<=":
+		t.hi, t.hasHi, t.exclHi = n, true, op == "<"
+	default:
+		return t, errors.Errorf("invalid numeric comparison '%s': unknown operator '%s'", term, op)
+	}
+	return t, nil
+}
+
+func parseNumber(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) {
+		return 0, errors.Errorf("'%s' is not a number", s)
+	}
+	return n, nil
+}
+
+func (t numericTerm) match(n float64) bool {
+	in := true
+	switch {
+	case t.hasLo && (n < t.lo || t.exclLo && n == t.lo):
+		in = false
+	case t.hasHi && (n > t.hi || t.exclHi && n == t.hi):
+		in = false
+	}
+	return in != t.not
+}
+
+// numberIn finds the first number in s, and returns its value along
+// with its byte offsets. Numbers may have a fractional part, and are
+// negative if they are preceded by a "-" that does not follow a word,
+// as in "-3", but not in "x-3". The last return value is false if s
+// does not contain any number
+func numberIn(s string) (float64, int, int, bool) {
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return 0, 0, 0, false
+	}
+	end := start + len(s[start:]) - len(strings.TrimLeft(s[start:], "0123456789"))
+	if end+1 < len(s) && s[end] == '.' && isDigit(s[end+1]) {
+		end++
+		end += len(s[end:]) - len(strings.TrimLeft(s[end:], "0123456789"))
+	}
+	if start > 0 && s[start-1] == '-' {
+		if r, _ := utf8.DecodeLastRuneInString(s[:start-1]); start == 1 || !isWordRune(r) {
+			start--
+		}
+	}
+
+	n, err := strconv.ParseFloat(s[start:end], 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return n, start, end, true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
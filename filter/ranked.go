@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// NewRanked wraps l, as matched by a filter that ranks the lines, into
+// the value that the filter sends. matches are the regions of l that
+// were matched
+func NewRanked(l line.Line, matches [][]int, score int) pipeline.Ranked {
+	spans := make([][2]int, 0, len(matches))
+	for _, m := range matches {
+		if len(m) == 2 {
+			spans = append(spans, [2]int{m[0], m[1]})
+		}
+	}
+	return pipeline.Ranked{Value: l, Score: score, Spans: spans}
+}
+
+// RankedLine returns the line carried by a value that a filter sent.
+// Lines sent as a pipeline.Ranked are returned as a line.Scored, with
+// the regions that were matched, and lines sent as they are are
+// returned as they are. It returns false if v does not carry a line
+func RankedLine(v interface{}) (line.Line, bool) {
+	switch v := v.(type) {
+	case pipeline.Ranked:
+		l, ok := v.Value.(line.Line)
+		if !ok {
+			return nil, false
+		}
+		matches := make([][]int, len(v.Spans))
+		for i, s := range v.Spans {
+			matches[i] = []int{s[0], s[1]}
+		}
+		return line.NewScored(l, matches, v.Score), true
+	case line.Line:
+		return v, true
+	}
+	return nil, false
+}
+
+// rankLine returns the value to send for a line that Union or Chain
+// combined out of the lines sent by the filters they wrap. Scored lines
+// are sent as a pipeline.Ranked, like the filters that rank the lines
+// send them
+func rankLine(l line.Line) interface{} {
+	if s, ok := l.(*line.Scored); ok {
+		return NewRanked(s.Line, s.Indices(), s.Score())
+	}
+	return l
+}
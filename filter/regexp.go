@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"regexp"
-	"sort"
 	"strings"
 	"time"
 
+	pdebug "github.com/lestrrat/go-pdebug"
 	"github.com/peco/peco/internal/util"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
@@ -25,7 +25,11 @@ func (r regexpFlagFunc) flags(s string) []string {
 func regexpFor(q string, flags []string, quotemeta bool) (*regexp.Regexp, error) {
 	reTxt := q
 	if quotemeta {
-		reTxt = regexp.QuoteMeta(q)
+		if hasIgnoreCaseFlag(flags) {
+			reTxt = quoteFold(q)
+		} else {
+			reTxt = regexp.QuoteMeta(q)
+		}
 	}
 
 	if flags != nil && len(flags) > 0 {
@@ -39,19 +43,102 @@ func regexpFor(q string, flags []string, quotemeta bool) (*regexp.Regexp, error)
 	return re, nil
 }
 
-func queryToRegexps(query string, flags regexpFlags, quotemeta bool) ([]*regexp.Regexp, error) {
-	queries := strings.Split(strings.TrimSpace(query), " ")
-	regexps := make([]*regexp.Regexp, 0)
+// queryToRegexps compiles each term in the query into a regular
+// expression. Terms prefixed with "!" are compiled into the list of
+// negated expressions. If fields is true, terms may be prefixed with
+// "N:" to match against a single field. Terms that fail to compile are
+// represented by a nil expression, which matches nothing
+func queryToRegexps(query string, flags regexpFlags, quotemeta, fields bool) (regexpQuery, error) {
+	var rq regexpQuery
+	for _, q := range splitQuery(query) {
+		q, negated := negatedTerm(q)
+
+		var field int
+		if fields {
+			field, q = parseFieldTerm(q)
+		}
+
+		// Regular expressions can use \b as they please
+		var word bool
+		if quotemeta {
+			q, word = wholeWordTerm(q)
+		}
 
-	for _, q := range queries {
 		re, err := regexpFor(q, flags.flags(query), quotemeta)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to compile regular expression '%s'", q)
+			if pdebug.Enabled {
+				pdebug.Printf("%s", errors.Wrapf(err, "failed to compile regular expression '%s'", q))
+			}
+			re = nil
+		}
+
+		t := regexpTerm{rx: re, field: field, wholeWord: word}
+		if quotemeta && q != "" && util.IsASCII(q) {
+			t.fold = hasIgnoreCaseFlag(flags.flags(query))
+			t.literal = q
+			if t.fold {
+				t.literal = strings.ToLower(q)
+			}
+		}
+		if negated {
+			rq.negated = append(rq.negated, t)
+		} else {
+			rq.rx = append(rq.rx, t)
 		}
-		regexps = append(regexps, re)
 	}
 
-	return regexps, nil
+	return rq, nil
+}
+
+// match matches the term against the line. The returned indices are
+// relative to the entire line. If word is true, or the term itself
+// asks for it, only the matches that are entire words are returned.
+// If ascii is true, v only consists of ASCII characters, and literal
+// terms are searched for without the regular expression
+func (t regexpTerm) match(v, delim string, word, ascii bool) [][]int {
+	if t.rx == nil {
+		return nil
+	}
+
+	start, end := 0, len(v)
+	if t.field != 0 {
+		var ok bool
+		start, end, ok = fieldSpan(v, delim, t.field)
+		if !ok {
+			return nil
+		}
+	}
+
+	s := v[start:end]
+	var matches [][]int
+	if ascii && t.literal != "" {
+		matches = indexAllASCII(s, t.literal, t.fold)
+	} else {
+		matches = t.rx.FindAllStringSubmatchIndex(s, -1)
+	}
+	if word || t.wholeWord {
+		words := matches[:0]
+		for _, m := range matches {
+			if isWordMatch(s, m[0], m[1]) {
+				words = append(words, m)
+			}
+		}
+		if len(words) == 0 {
+			return nil
+		}
+		matches = words
+	}
+
+	if start > 0 {
+		for _, m := range matches {
+			for i := range m {
+				if m[i] >= 0 {
+					m[i] += start
+				}
+			}
+		}
+	}
+	return matches
 }
 
 func (rf *Regexp) NewContext(ctx context.Context, query string) context.Context {
@@ -72,7 +159,7 @@ func NewRegexp() *Regexp {
 	}
 }
 
-func (rf Regexp) BufSize() int {
+func (rf *Regexp) BufSize() int {
 	return 0
 }
 
@@ -82,43 +169,60 @@ func (rf *Regexp) OutCh() <-chan interface{} {
 	return rf.outCh
 }
 
-func (f *regexpQueryFactory) Compile(s string, flags regexpFlags, quotemeta bool) ([]*regexp.Regexp, error) {
+func (f *regexpQueryFactory) Compile(s string, flags regexpFlags, quotemeta, fields bool) (regexpQuery, error) {
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
-	rq, ok := f.compiled[s]
+	// The same query compiles differently depending on whether
+	// the field prefixes are enabled
+	key := s
+	if fields {
+		key = "fields:" + s
+	}
+
+	rq, ok := f.compiled[key]
 	if ok {
 		if time.Since(rq.lastUsed) < f.threshold {
-			return rq.rx, nil
+			return rq, nil
 		}
-		delete(f.compiled, s)
+		delete(f.compiled, key)
 	}
 
-	rxs, err := queryToRegexps(s, flags, quotemeta)
+	rq, err := queryToRegexps(s, flags, quotemeta, fields)
 	if err != nil {
-		return nil, errors.Wrap(err, `failed to compile regular expression`)
+		return regexpQuery{}, errors.Wrap(err, `failed to compile regular expression`)
 	}
 
 	rq.lastUsed = time.Now()
-	rq.rx = rxs
-	f.compiled[s] = rq
-	return rxs, nil
+	f.compiled[key] = rq
+	return rq, nil
 }
 
 func (rf *Regexp) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
 	query := ctx.Value(queryKey).(string)
-	regexps, err := rf.factory.Compile(query, rf.flags, rf.quotemeta)
+	delim, fields := fieldDelimiter(ctx)
+	accents := ignoreAccents(ctx)
+	if accents {
+		query, _ = foldAccents(query)
+	}
+	rq, err := rf.factory.Compile(query, rf.flags, rf.quotemeta, fields)
 	if err != nil {
 		return errors.Wrap(err, "failed to compile queries as regular expression")
 	}
+	word := rf.quotemeta && wholeWord(ctx)
+	ascii := asciiInput(ctx)
 
 	for _, l := range lines {
-		v := l.DisplayString()
+		v := line.MatchString(l)
+		var offsets []int
+		if accents {
+			v, offsets = foldAccents(v)
+		}
 		allMatched := true
 		matches := [][]int{}
 	TryRegexps:
-		for _, rx := range regexps {
-			match := rx.FindAllStringSubmatchIndex(v, -1)
+		for _, t := range rq.rx {
+			match := t.match(v, delim, word, ascii)
 			if match == nil {
 				allMatched = false
 				break TryRegexps
@@ -130,40 +234,86 @@ func (rf *Regexp) Apply(ctx context.Context, lines []line.Line, out pipeline.Cha
 			continue
 		}
 
-		sort.Sort(byMatchStart(matches))
+		// Negated terms only exclude lines, they never contribute
+		// to the highlighted regions
+		for _, t := range rq.negated {
+			if t.match(v, delim, word, ascii) != nil {
+				allMatched = false
+				break
+			}
+		}
 
-		// We need to "dedupe" the results. For example, if we matched the
-		// same region twice, we don't want that to be drawn
+		if !allMatched {
+			continue
+		}
 
-		deduped := make([][]int, 0, len(matches))
+		if err := out.SendCtx(ctx, line.NewMatched(l, unfoldMatches(dedupMatches(matches), offsets))); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
 
-		for i, m := range matches {
-			// Always push the first one
-			if i == 0 {
-				deduped = append(deduped, m)
-				continue
-			}
+// Submatch returns the text matched by the first term of the query in
+// the context (see NewContext) that matches s, followed by the text
+// matched by its capture groups. Returns nil if no term matches
+func (rf *Regexp) Submatch(ctx context.Context, s string) []string {
+	query, _ := ctx.Value(queryKey).(string)
+	delim, fields := fieldDelimiter(ctx)
+	rq, err := rf.factory.Compile(query, rf.flags, rf.quotemeta, fields)
+	if err != nil {
+		return nil
+	}
 
-			prev := deduped[len(deduped)-1]
-			switch {
-			case matchContains(prev, m):
-				// If the previous match contains this one, then
-				// don't do anything
-				continue
-			case matchOverlaps(prev, m):
-				// If the previous match overlaps with this one,
-				// merge the results and make it a bigger one
-				deduped[len(deduped)-1] = mergeMatches(prev, m)
-			default:
-				deduped = append(deduped, m)
-			}
+	for _, t := range rq.rx {
+		if t.rx == nil {
+			continue
+		}
+		v, _, ok := fieldOf(s, delim, t.field)
+		if !ok {
+			continue
+		}
+		if m := t.rx.FindStringSubmatch(v); m != nil {
+			return m
 		}
-		out.Send(line.NewMatched(l, deduped))
 	}
 	return nil
 }
 
-func (rf Regexp) String() string {
+// IsSubsetQuery returns true if query only appends to prev. This is
+// only the case for filters that do not interpret the query as a
+// regular expression, and only if neither query contains a negated,
+// an escaped, or a field term, as extending those could match more
+// lines. The same goes for appending "s" to a trailing "s", which
+// also matches "ß" when case is ignored
+func (rf *Regexp) IsSubsetQuery(prev, query string) bool {
+	if !rf.quotemeta || !strings.HasPrefix(query, prev) {
+		return false
+	}
+	if strings.ContainsAny(query, `!\:`) {
+		return false
+	}
+	return !hasIgnoreCaseFlag(rf.flags.flags(query)) || !extendsSharpS(prev, query)
+}
+
+// IsValidQuery returns true if every term of the query compiles. Terms
+// that do not are silently ignored by Apply, so the query would not
+// match anything. Literal queries are always valid
+func (rf *Regexp) IsValidQuery(query string) bool {
+	if rf.quotemeta {
+		return true
+	}
+	for _, q := range splitQuery(query) {
+		q, _ = negatedTerm(q)
+		_, q = parseFieldTerm(q)
+		if _, err := regexpFor(q, rf.flags.flags(query), false); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (rf *Regexp) String() string {
 	return rf.name
 }
 
@@ -182,6 +332,15 @@ func NewCaseSensitive() *Regexp {
 	return rf
 }
 
+// NewIgnoreCaseRegexp creates a filter that matches regular expressions
+// like Regexp, but ignores case
+func NewIgnoreCaseRegexp() *Regexp {
+	rf := NewRegexp()
+	rf.flags = ignoreCaseFlags
+	rf.name = "IgnoreCaseRegexp"
+	return rf
+}
+
 // SmartCase turns ON the ignore-case flag in the regexp
 // if the query contains a upper-case character
 func NewSmartCase() *Regexp {
@@ -35,6 +35,20 @@ func (fs *Set) Rotate() {
 	}
 }
 
+// RotateReverse is the same as Rotate, but goes through the
+// filters in the opposite direction
+func (fs *Set) RotateReverse() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.current--
+	if fs.current < 0 {
+		fs.current = len(fs.filters) - 1
+	}
+	if pdebug.Enabled {
+		pdebug.Printf("Set.RotateReverse: now filter in effect is %s", fs.filters[fs.current])
+	}
+}
+
 func (fs *Set) SetCurrentByName(name string) error {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
@@ -47,6 +61,19 @@ func (fs *Set) SetCurrentByName(name string) error {
 	return ErrFilterNotFound
 }
 
+// Lookup returns the filter called name, or ErrFilterNotFound if there
+// is no such filter
+func (fs *Set) Lookup(name string) (Filter, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	for _, f := range fs.filters {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return nil, ErrFilterNotFound
+}
+
 func (fs *Set) Index() int {
 	fs.mutex.Lock()
 	defer fs.mutex.Unlock()
@@ -58,3 +85,11 @@ func (fs *Set) Current() Filter {
 	defer fs.mutex.Unlock()
 	return fs.filters[fs.current]
 }
+
+// CurrentName returns the name of the current filter, which is read
+// while the Set is locked
+func (fs *Set) CurrentName() string {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.filters[fs.current].String()
+}
@@ -0,0 +1,48 @@
+package filter
+
+import (
+	"context"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// NewSizeSwitch creates a filter that matches the lines with internal
+// while the input has fewer than threshold lines, and with external
+// once it has that many. It goes by the name of external, so that it
+// can take its place
+func NewSizeSwitch(external, internal Filter, threshold int) *SizeSwitch {
+	return &SizeSwitch{
+		external:  external,
+		internal:  internal,
+		threshold: threshold,
+	}
+}
+
+// Select returns the filter that is used to match an input of size
+// lines
+func (s *SizeSwitch) Select(size int) Filter {
+	if size < s.threshold {
+		return s.internal
+	}
+	return s.external
+}
+
+// The methods of Filter use the external filter, as that is what is
+// used when the size of the input is not known
+
+func (s *SizeSwitch) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	return s.external.Apply(ctx, lines, out)
+}
+
+func (s *SizeSwitch) BufSize() int {
+	return s.external.BufSize()
+}
+
+func (s *SizeSwitch) NewContext(ctx context.Context, query string) context.Context {
+	return s.external.NewContext(ctx, query)
+}
+
+func (s *SizeSwitch) String() string {
+	return s.external.String()
+}
@@ -0,0 +1,122 @@
+package filter
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// NewUnion creates a filter that matches the lines that f matches
+// against any of the queries. The query given to NewContext is ignored
+func NewUnion(f Filter, queries []string) *Union {
+	return &Union{
+		filter:  f,
+		queries: queries,
+	}
+}
+
+func (u *Union) BufSize() int {
+	return u.filter.BufSize()
+}
+
+func (u *Union) NewContext(ctx context.Context, _ string) context.Context {
+	return newContext(ctx, strings.Join(u.queries, " "))
+}
+
+func (u *Union) String() string {
+	return u.filter.String()
+}
+
+// Apply runs the wrapped filter against the lines once for each query.
+// Lines that match more than one query are only sent once, with the
+// matched regions of all queries, and the lines are sent in the order
+// that they were given
+func (u *Union) Apply(ctx context.Context, lines []line.Line, out pipeline.ChanOutput) error {
+	matched := make(map[uint64]line.Line)
+	for _, q := range u.queries {
+		// The wrapped filter sends at most one value per line, so
+		// it never blocks on this channel
+		ch := make(chan interface{}, len(lines))
+		if err := u.filter.Apply(u.filter.NewContext(ctx, q), lines, pipeline.ChanOutput(ch)); err != nil {
+			return err
+		}
+		close(ch)
+
+		for v := range ch {
+			l, ok := RankedLine(v)
+			if !ok {
+				continue
+			}
+
+			prev, ok := matched[l.ID()]
+			if !ok {
+				matched[l.ID()] = l
+				continue
+			}
+			matched[l.ID()] = mergeMatchedLines(prev, l)
+		}
+	}
+
+	for _, l := range lines {
+		m, ok := matched[l.ID()]
+		if !ok {
+			continue
+		}
+		if err := out.SendCtx(ctx, rankLine(m)); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// mergeMatchedLines combines the results of two queries that matched
+// the same line. Scored lines keep the better of the two scores
+func mergeMatchedLines(a, b line.Line) line.Line {
+	type indexer interface {
+		Indices() [][]int
+	}
+
+	am, ok := a.(indexer)
+	if !ok {
+		return a
+	}
+	bm, ok := b.(indexer)
+	if !ok {
+		return a
+	}
+
+	merged := mergeIndices(am.Indices(), bm.Indices())
+	switch a := a.(type) {
+	case *line.Scored:
+		score := a.Score()
+		if bs, ok := b.(*line.Scored); ok && bs.Score() > score {
+			score = bs.Score()
+		}
+		return line.NewScored(a.Line, merged, score)
+	case *line.Matched:
+		return line.NewMatched(a.Line, merged)
+	}
+	return a
+}
+
+// mergeIndices combines two lists of matched regions into one sorted
+// list, in which overlapping regions are merged
+func mergeIndices(a, b [][]int) [][]int {
+	indices := make([][]int, 0, len(a)+len(b))
+	indices = append(indices, a...)
+	indices = append(indices, b...)
+	sort.Sort(byMatchStart(indices))
+
+	merged := make([][]int, 0, len(indices))
+	for _, m := range indices {
+		if n := len(merged); n > 0 && (matchContains(merged[n-1], m) || matchOverlaps(merged[n-1], m)) {
+			merged[n-1] = mergeMatches(merged[n-1], m)
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
@@ -0,0 +1,375 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func runFilter(ctx context.Context, src pipeline.Source, f filter.Filter, query string) (*MemoryBuffer, error) {
+	p := pipeline.New()
+	p.SetSource(src)
+	p.Add(newFilterProcessor(f, query))
+	buf := NewMemoryBuffer()
+	p.SetDestination(buf)
+	return buf, p.Run(f.NewContext(ctx, query))
+}
+
+func TestFilterIncremental(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	state := New()
+	state.hub = nullHub{}
+	src := NewSource("-", strings.NewReader("foo\nfoobar\nfoobaz\nbar"), ig, 0, false)
+	go src.Setup(ctx, state)
+
+	select {
+	case <-src.SetupDone():
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timed out waiting for source")
+		return
+	}
+
+	ignoreCase := filter.NewIgnoreCase()
+	f := NewFilter(state)
+	buf, err := runFilter(ctx, src, ignoreCase, "foo")
+	if !assert.NoError(t, err, "running the query should succeed") {
+		return
+	}
+	f.updateCache(src, ignoreCase, "foo", SortNone, buf)
+
+	if !assert.Equal(t, pipeline.Source(f.cache), f.sourceFor(src, ignoreCase, "foob", SortNone), "extended query should use the cache") {
+		return
+	}
+	assert.Equal(t, pipeline.Source(src), f.sourceFor(src, ignoreCase, "fo", SortNone), "shorter query should use the source")
+	assert.Equal(t, pipeline.Source(src), f.sourceFor(src, ignoreCase, "foo !bar", SortNone), "negated query should use the source")
+	assert.Equal(t, pipeline.Source(src), f.sourceFor(src, filter.NewCaseSensitive(), "foob", SortNone), "different filter should use the source")
+	assert.Equal(t, pipeline.Source(src), f.sourceFor(src, ignoreCase, "foob", SortAlpha), "different sort mode should use the source")
+	other := NewSource("-", strings.NewReader(""), ig, 0, false)
+	assert.Equal(t, pipeline.Source(other), f.sourceFor(other, ignoreCase, "foob", SortNone), "different source should not use the cache")
+
+	buf, err = runFilter(ctx, f.sourceFor(src, ignoreCase, "fooba", SortNone), ignoreCase, "fooba")
+	if !assert.NoError(t, err, "running the query should succeed") {
+		return
+	}
+	if !assert.Equal(t, 2, buf.Size(), "lines matched by the cached query should be filtered") {
+		return
+	}
+	for i, expected := range []string{"foobar", "foobaz"} {
+		l, err := buf.LineAt(i)
+		if !assert.NoError(t, err, "buf.LineAt(%d) should succeed", i) {
+			return
+		}
+		assert.Equal(t, expected, l.DisplayString(), "matched line should be the same")
+	}
+}
+
+func TestFilterFuzzyRanked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	state := New()
+	state.hub = nullHub{}
+	src := NewSource("-", strings.NewReader("f_o_o\nfoo\nbar\nf_oo\nfoo"), ig, 0, false)
+	go src.Setup(ctx, state)
+
+	select {
+	case <-src.SetupDone():
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "timed out waiting for source")
+		return
+	}
+
+	buf, err := runFilter(ctx, src, filter.NewFuzzyRanked(), "foo")
+	if !assert.NoError(t, err, "running the query should succeed") {
+		return
+	}
+
+	// Best matches first, ties in input order
+	expected := []uint64{1, 4, 3, 0}
+	if !assert.Equal(t, len(expected), buf.Size(), "matching lines should be selected") {
+		return
+	}
+	for i, id := range expected {
+		l, err := buf.LineAt(i)
+		if !assert.NoError(t, err, "buf.LineAt(%d) should succeed", i) {
+			return
+		}
+		assert.Equal(t, id, l.ID(), "line %d should be ranked at %d", id, i)
+	}
+}
+
+func TestFilterStableOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = strings.NewReader("f_o_o\nfoo\nbar\nf_oo\nfoo\n")
+	p.config.InitialFilter = "FuzzyRanked"
+	p.config.StableOrder = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	p.Query().Set("foo")
+	p.ExecQuery()
+
+	// The ranked lines are displayed in input order instead of the
+	// order of their scores
+	expected := []string{"f_o_o", "foo", "f_oo", "foo"}
+	for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+		select {
+		case <-ctx.Done():
+			assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should be in the order they were read")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestFilterMaxResults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = strings.NewReader("foo1\nbar\nfoo2\nfoo3\nfoo4\nfoo5\n")
+	p.config.MaxResults = 3
+	p.config.IncrementalFilter = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				assert.Fail(t, "timed out waiting for the query", "expected %v, got %v", expected, bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	// The empty query is not limited
+	if !waitLines([]string{"foo1", "bar", "foo2", "foo3", "foo4", "foo5"}) {
+		return
+	}
+
+	p.Query().Set("foo")
+	p.ExecQuery()
+	if !waitLines([]string{"foo1", "foo2", "foo3"}) {
+		return
+	}
+
+	// The truncated results are not reused by the incremental filter
+	p.Query().Set("foo5")
+	p.ExecQuery()
+	if !waitLines([]string{"foo5"}) {
+		return
+	}
+
+	p.Query().Set("foo")
+	p.ExecQuery()
+	if !waitLines([]string{"foo1", "foo2", "foo3"}) {
+		return
+	}
+	p.acceptAll = true
+	assert.Equal(t, []string{"foo1", "foo2", "foo3"}, p.selectedStrings(), "all the truncated results should be accepted")
+}
+
+// waitDrawn waits for the view to draw the screen, so that what it
+// updates as it draws can be read without racing with it
+func waitDrawn(p *Peco) {
+	p.Hub().Batch(func() { p.Hub().SendDraw(nil) }, false)
+}
+
+func TestFilterLazyFilter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var input bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "foo%d\nbar%d\n", i, i)
+	}
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = &input
+	p.config.LazyFilter = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	p.Query().Set("foo")
+	p.ExecQuery()
+	for !p.ResultsApproximate() {
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "timed out waiting for the query")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	waitDrawn(p)
+	size := p.CurrentLineBuffer().Size()
+	if !assert.True(t, size > 0 && size < 1000, "only the lines around the scroll position should be matched, got %d", size) {
+		return
+	}
+	assert.Contains(t, promptCountMessage(p), "+", "the number of matches should be shown as approximate")
+
+	// Scrolling to the last line matched so far matches more of them
+	for p.ResultsApproximate() {
+		p.Hub().SendPaging(ToLastLine)
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "timed out waiting for the rest of the input")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	waitDrawn(p)
+	lines := bufferLines(p.CurrentLineBuffer())
+	if !assert.Len(t, lines, 1000, "all the lines should be matched once scrolled to the end") {
+		return
+	}
+	for i, l := range lines {
+		if !assert.Equal(t, fmt.Sprintf("foo%d", i), l, "lines should be matched in the input order") {
+			return
+		}
+	}
+	assert.NotContains(t, promptCountMessage(p), "+", "the number of matches should be exact")
+}
+
+func TestFilterChain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = strings.NewReader("FOO foo\nFoo bar\nfoo\nbaz\n")
+	p.config.FilterChain = []string{"IgnoreCase", "CaseSensitive"}
+	p.config.InitialFilter = "IgnoreCase>CaseSensitive"
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	p.Query().Set("foo")
+	p.ExecQuery()
+	expected := []string{"FOO foo", "foo"}
+	for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+		select {
+		case <-ctx.Done():
+			assert.Fail(t, "timed out waiting for the query", "expected %v, got %v", expected, bufferLines(p.CurrentLineBuffer()))
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	l, err := p.CurrentLineBuffer().LineAt(0)
+	if !assert.NoError(t, err, "LineAt should succeed") {
+		return
+	}
+	assert.Equal(t, [][]int{{0, 3}, {4, 7}}, l.(MatchIndexer).Indices(), "matches of both filters should be highlighted")
+
+	p = newPeco()
+	p.config.FilterChain = []string{"IgnoreCase", "NoSuchFilter"}
+	assert.Error(t, p.ApplyConfig(CLIOptions{}), "unknown filter should fail")
+}
+
+func TestFilterExecThreshold(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	run := func(threshold int, expected []string, msg string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.Stdin = strings.NewReader("foo\nbar\nfoobar\n")
+		p.config.CustomFilter = map[string]CustomFilterConfig{
+			"Ext": {
+				Cmd:            "sh",
+				Args:           []string{"-c", "echo external"},
+				InternalFilter: "IgnoreCase",
+				ExecThreshold:  threshold,
+			},
+		}
+		p.config.InitialFilter = "Ext"
+		go p.Run(ctx)
+
+		<-p.Ready()
+		<-p.source.SetupDone()
+
+		p.Query().Set("foo")
+		p.ExecQuery()
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				assert.Fail(t, "timed out waiting for the query", "%s: expected %v, got %v", msg, expected, bufferLines(p.CurrentLineBuffer()))
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	run(4, []string{"foo", "foobar"}, "small input should be matched by the internal filter")
+	run(3, []string{"external"}, "large input should be matched by the command")
+
+	p := newPeco()
+	p.config.CustomFilter = map[string]CustomFilterConfig{
+		"Ext": {Cmd: "sh", InternalFilter: "NoSuchFilter", ExecThreshold: 1},
+	}
+	assert.Error(t, p.ApplyConfig(CLIOptions{}), "unknown internal filter should fail")
+}
+
+func TestMemoryBufferChanged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	state := New()
+	state.hub = nullHub{}
+	src := NewSource("-", strings.NewReader("foo\nbar\n"), ig, 0, false)
+	go src.Setup(ctx, state)
+	<-src.SetupDone()
+
+	buf, err := runFilter(ctx, src, filter.NewIgnoreCase(), "foo")
+	if !assert.NoError(t, err, "running the query should succeed") {
+		return
+	}
+	assert.True(t, buf.takeChanged(), "matched lines should be a change")
+	assert.False(t, buf.takeChanged(), "changes should be forgotten once taken")
+
+	buf, err = runFilter(ctx, src, filter.NewIgnoreCase(), "baz")
+	if !assert.NoError(t, err, "running the query should succeed") {
+		return
+	}
+	assert.False(t, buf.takeChanged(), "no lines should be no change")
+}
@@ -0,0 +1,50 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// fixedStringFilter is the name of the filter that peco.ToggleFixedString
+// switches to
+const fixedStringFilter = "FixedString"
+
+// doToggleFixedString switches to the FixedString filter, which matches
+// the entire query as is, and back to the filter that was used before.
+// The current query is run again either way
+func doToggleFixedString(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleFixedString")
+		defer g.End()
+	}
+
+	filters := state.Filters()
+	if current := filters.CurrentName(); current != fixedStringFilter {
+		if err := filters.SetCurrentByName(fixedStringFilter); err != nil {
+			return
+		}
+		state.mutex.Lock()
+		state.fixedStringReturn = current
+		state.mutex.Unlock()
+		state.Hub().SendStatusMsgAndClear("Match the query as is", time.Second)
+	} else {
+		state.mutex.Lock()
+		prev := state.fixedStringReturn
+		state.mutex.Unlock()
+		// Without a filter to go back to, e.g. because FixedString was
+		// chosen with --initial-filter, the first filter is used
+		if prev == "" || filters.SetCurrentByName(prev) != nil {
+			filters.Reset()
+		}
+		state.Hub().SendStatusMsgAndClear("Match the query by terms", time.Second)
+	}
+	resetQueryOnFilterChange(state)
+
+	if state.ExecQuery() {
+		return
+	}
+	state.Hub().SendDrawPrompt()
+}
@@ -0,0 +1,54 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToggleFixedString(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--query", "a !b", "--initial-filter", "CaseSensitive"}
+	p.Stdin = bytes.NewBufferString("a !b\na c\nb a\n")
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached while waiting for %v, got %v", expected, bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	if !waitLines([]string{"a c"}) {
+		return
+	}
+
+	doToggleFixedString(ctx, p, termbox.Event{})
+	if !assert.Equal(t, "FixedString", p.Filters().Current().String(), "FixedString should be selected") {
+		return
+	}
+	if !waitLines([]string{"a !b"}) {
+		return
+	}
+
+	doToggleFixedString(ctx, p, termbox.Event{})
+	if !assert.Equal(t, "CaseSensitive", p.Filters().Current().String(), "previous filter should be selected") {
+		return
+	}
+	waitLines([]string{"a c"})
+}
@@ -0,0 +1,57 @@
+package peco
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/peco/peco/line"
+)
+
+// followMatch scrolls the list horizontally once the cursor moves to
+// another line, so that the matched regions of the line are visible.
+// Nothing is scrolled if a matched region shows at column 0, once the
+// line is truncated to the avail columns of the list. Otherwise the
+// list is scrolled to the earliest region, with a quarter of the list
+// before it for context. Returns true if the column changed
+func (l *ListArea) followMatch(state *Peco, linebuf Buffer, avail int) bool {
+	loc := state.Location()
+	target, err := linebuf.LineAt(loc.LineNumber())
+	if err != nil || target == l.followedLine {
+		return false
+	}
+	l.followedLine = target
+
+	if _, hidden := line.Unwrap(target).(line.Matcher); hidden {
+		return false
+	}
+
+	column := 0
+	if !matchShown(l.highlight(state, target, false, avail), avail) {
+		h := l.highlight(state, target, false, -1)
+		if len(h.matches) == 0 {
+			return false
+		}
+		earliest := h.matches[0]
+		for _, m := range h.matches[1:] {
+			if m[0] < earliest[0] {
+				earliest = m
+			}
+		}
+		column = maxOf(runewidth.StringWidth(h.text[:earliest[0]])-avail/4, 0)
+	}
+
+	if column == loc.Column() {
+		return false
+	}
+	loc.SetColumn(column)
+	return true
+}
+
+// matchShown returns true if any of the matched regions of h ends
+// within the first width columns of its text
+func matchShown(h *lineHighlight, width int) bool {
+	for _, m := range h.matches {
+		if m[1] > m[0] && runewidth.StringWidth(h.text[:m[1]]) <= width {
+			return true
+		}
+	}
+	return false
+}
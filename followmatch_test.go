@@ -0,0 +1,56 @@
+package peco
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFollowMatch(t *testing.T) {
+	long := strings.Repeat("x", 120) + "bar" + strings.Repeat("x", 27) + "foo" + strings.Repeat("x", 50)
+	testValues := []struct {
+		side     string
+		lineno   int
+		expected int
+	}{
+		{TruncateRight, 0, 0},
+		// The text starts at column 0, and a quarter of the screen is
+		// left before the earliest match
+		{TruncateRight, 1, 100},
+		{TruncateNone, 1, 100},
+		// The match is shown at column 0 once the line is truncated
+		{TruncateMiddle, 2, 0},
+		{TruncateLeft, 2, 0},
+		{TruncateRight, 2, 123},
+	}
+
+	for _, v := range testValues {
+		state := newPeco()
+		state.followMatch = true
+		state.truncateSide = v.side
+		state.ellipsis = DefaultEllipsis
+		buf := NewMemoryBuffer()
+		buf.lines = []line.Line{
+			line.NewMatched(line.NewRaw(0, "foo", false), [][]int{{0, 3}}),
+			line.NewMatched(line.NewRaw(1, long, false), [][]int{{150, 153}, {120, 123}}),
+			line.NewMatched(line.NewRaw(2, strings.Repeat("x", 200)+"foo", false), [][]int{{200, 203}}),
+		}
+		state.currentLineBuffer = buf
+
+		loc := state.Location()
+		loc.SetPerPage(3)
+		loc.SetPage(1)
+		loc.SetLineNumber(v.lineno)
+		list := NewListArea(NewDummyScreen(), AnchorTop, 0, true, NewStyleSet())
+		list.Draw(state, nil, 3, nil)
+		assert.Equal(t, v.expected, loc.Column(), "column with the cursor on line %d (%s)", v.lineno, v.side)
+
+		// Once scrolled by hand, the list stays where it is until the
+		// cursor moves to another line
+		loc.SetColumn(10)
+		list.Draw(state, nil, 3, nil)
+		assert.Equal(t, 10, loc.Column(), "column should be kept on the same line (%s)", v.side)
+	}
+}
@@ -0,0 +1,96 @@
+package peco
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// frecencyWeight is how much the frecency counts against the score given
+// by a ranked filter. FuzzyRanked gives at least 16 points per matched
+// character, so every doubling of the frecency is worth about as much
+// as one more matched character
+const frecencyWeight = 16
+
+// loadFrecencyTable reads the frecency of the lines from filename. Each
+// line of the file is a line of the input, followed by a tab and its
+// frecency, such as "/home/user/src\t42.5". Lines that are not in that
+// form are ignored, and so are the entries after the first one for the
+// same line. It is not an error for the file not to exist
+func loadFrecencyTable(filename string) (frecencyTable, error) {
+	t := frecencyTable{}
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, errors.Wrapf(err, "failed to open frecency file %s", filename)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		s := strings.TrimSuffix(scanner.Text(), "\r")
+		i := strings.LastIndexByte(s, '\t')
+		if i <= 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if _, ok := t[s[:i]]; !ok {
+			t[s[:i]] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read frecency file %s", filename)
+	}
+	return t, nil
+}
+
+// frecencyRank combines the score that a ranked filter gave to a line
+// with the frecency of the line, into the rank that SortFrecency orders
+// the lines by:
+//
+//	rank = score + frecencyWeight * log2(1 + frecency)
+//
+// The logarithm keeps a line that is used very often from beating a
+// much better match. Filters that do not rank the lines give them a
+// score of 0, so that they are ordered by frecency alone. A frecency
+// below 0 counts as 0, like that of a line that is not in the table
+func frecencyRank(score int, frecency float64) float64 {
+	if frecency < 0 {
+		frecency = 0
+	}
+	return float64(score) + frecencyWeight*math.Log2(1+frecency)
+}
+
+// sortByFrecency sorts lines in place from the highest to the lowest
+// rank, see frecencyRank. The lines are looked up in the table by their
+// output. Lines with the same rank are kept in the order they were read
+func sortByFrecency(lines []line.Line, t frecencyTable) {
+	ranks := make(map[uint64]float64, len(lines))
+	for _, l := range lines {
+		var score int
+		if sl, ok := l.(*line.Scored); ok {
+			score = sl.Score()
+		}
+		ranks[l.ID()] = frecencyRank(score, t[l.Output()])
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := ranks[lines[i].ID()], ranks[lines[j].ID()]
+		if a != b {
+			return a > b
+		}
+		return lines[i].ID() < lines[j].ID()
+	})
+}
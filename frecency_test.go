@@ -0,0 +1,124 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrecencyRank(t *testing.T) {
+	testValues := []struct {
+		score    int
+		frecency float64
+		expected float64
+	}{
+		{0, 0, 0},
+		{0, 1, frecencyWeight},
+		{0, 3, 2 * frecencyWeight},
+		{32, 0, 32},
+		{32, 7, 32 + 3*frecencyWeight},
+		{10, -5, 10},
+	}
+	for _, v := range testValues {
+		assert.Equal(t, v.expected, frecencyRank(v.score, v.frecency), "rank of score %d with frecency %f", v.score, v.frecency)
+	}
+
+	// A better match wins over a line used a little more often
+	assert.True(t, frecencyRank(64, 1) > frecencyRank(32, 2), "score should outweigh a small frecency")
+}
+
+func TestSortByFrecency(t *testing.T) {
+	table := frecencyTable{
+		"/src/peco": 40,
+		"/tmp":      1,
+		"/src/go":   40,
+	}
+
+	var lines []line.Line
+	for i, s := range []string{"/home", "/tmp", "/src/go", "/etc", "/src/peco"} {
+		lines = append(lines, line.NewRaw(uint64(i), s, false))
+	}
+	sortByFrecency(lines, table)
+
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.DisplayString())
+	}
+	assert.Equal(t, []string{"/src/go", "/src/peco", "/tmp", "/home", "/etc"}, got, "lines should be sorted by frecency, then in the order they were read")
+
+	// The score of ranked filters is combined with the frecency
+	lines = []line.Line{
+		line.NewScored(line.NewRaw(0, "/tmp", false), nil, 100),
+		line.NewScored(line.NewRaw(1, "/src/peco", false), nil, 16),
+	}
+	sortByFrecency(lines, table)
+	assert.Equal(t, "/tmp", lines[0].DisplayString(), "better match should come first")
+}
+
+func TestLoadFrecencyTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-frecency")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := loadFrecencyTable(filepath.Join(dir, "missing"))
+	if !assert.NoError(t, err, "missing file should not be an error") {
+		return
+	}
+	assert.Empty(t, table, "missing file should give an empty table")
+
+	filename := filepath.Join(dir, "frecency")
+	content := "/src/peco\t42.5\n/tmp\tfoo\nno tab\n\t3\n/a\tb\t2\r\n/src/peco\t1\n"
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644), "WriteFile should succeed") {
+		return
+	}
+	table, err = loadFrecencyTable(filename)
+	if !assert.NoError(t, err, "loadFrecencyTable should succeed") {
+		return
+	}
+	assert.Equal(t, frecencyTable{"/src/peco": 42.5, "/a\tb": 2}, table, "malformed lines should be ignored")
+}
+
+func TestSortFrecency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-frecency")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "frecency")
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte("bar\t5\nbaz\t10\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\nqux\n")
+	p.config.Sort = SortFrecency
+	p.config.FrecencyFile = filename
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	expected := []string{"baz", "bar", "foo", "qux"}
+	for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+		select {
+		case <-ctx.Done():
+			assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should be sorted by frecency")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package fuzzy implements the scoring used to rank the results of
+// fuzzy matching.
+package fuzzy
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/peco/peco/internal/util"
+)
+
+const (
+	// scoreMatch is given for each matched character
+	scoreMatch = 16
+	// scoreGapStart is given for the first unmatched character
+	// between two matched characters
+	scoreGapStart = -3
+	// scoreGapExtension is given for each following unmatched character
+	scoreGapExtension = -1
+
+	// bonusBoundary is given when a matched character starts a word
+	bonusBoundary = 8
+	// bonusCamel is given when a matched character starts a camelCase
+	// word, or a number following letters
+	bonusCamel = 7
+	// bonusConsecutive is the minimum bonus given to a matched character
+	// that immediately follows another matched character
+	bonusConsecutive = -(scoreGapStart + scoreGapExtension)
+	// bonusFirstCharMultiplier multiplies the bonus given to the first
+	// character of the query
+	bonusFirstCharMultiplier = 2
+)
+
+type charClass int
+
+const (
+	charNonWord charClass = iota
+	charLower
+	charUpper
+	charNumber
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case unicode.IsLower(r):
+		return charLower
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsNumber(r):
+		return charNumber
+	case unicode.IsLetter(r):
+		// Letters without case, such as CJK characters
+		return charLower
+	default:
+		return charNonWord
+	}
+}
+
+func bonusFor(prev, cur charClass) int {
+	switch {
+	case cur == charNonWord:
+		return 0
+	case prev == charNonWord:
+		return bonusBoundary
+	case prev == charLower && cur == charUpper,
+		prev != charNumber && cur == charNumber:
+		return bonusCamel
+	}
+	return 0
+}
+
+func runeEqual(a, b rune, caseSensitive bool) bool {
+	if caseSensitive {
+		return a == b
+	}
+	return a == b || unicode.ToLower(a) == unicode.ToLower(b)
+}
+
+// Score matches the characters in query against target, in order but
+// not necessarily consecutively. Like the Fuzzy filter, the match is
+// case sensitive only if query contains an upper case character.
+//
+// It returns the score of the match, which is higher for matches with
+// consecutive characters, characters at word boundaries, and shorter
+// gaps between the characters, and the byte offsets in target of each
+// matched character. If query does not match, the offsets are nil.
+func Score(query, target string) (int, []int) {
+	if query == "" {
+		return 0, []int{}
+	}
+
+	pattern := []rune(query)
+	for _, r := range pattern {
+		if r == utf8.RuneError {
+			return 0, nil
+		}
+	}
+	caseSensitive := util.ContainsUpper(query)
+
+	// Find the first position where the whole query matches, so we
+	// know where the match ends
+	pi := 0
+	end := -1
+	for i, r := range target {
+		if runeEqual(pattern[pi], r, caseSensitive) {
+			pi++
+			if pi == len(pattern) {
+				end = i + utf8.RuneLen(r)
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil
+	}
+
+	// Then walk back from the end to find the shortest match that
+	// ends there
+	pi = len(pattern) - 1
+	start := end
+	for start > 0 {
+		r, n := utf8.DecodeLastRuneInString(target[:start])
+		start -= n
+		if runeEqual(pattern[pi], r, caseSensitive) {
+			pi--
+			if pi < 0 {
+				break
+			}
+		}
+	}
+
+	return score(pattern, target, start, end, caseSensitive)
+}
+
+// ScorePrefix is like Score, but the first character of query must
+// match the first character of target, as it does when the match is
+// anchored at the start of target.
+func ScorePrefix(query, target string) (int, []int) {
+	if query == "" {
+		return 0, []int{}
+	}
+
+	pattern := []rune(query)
+	for _, r := range pattern {
+		if r == utf8.RuneError {
+			return 0, nil
+		}
+	}
+	caseSensitive := util.ContainsUpper(query)
+
+	first, _ := utf8.DecodeRuneInString(target)
+	if target == "" || !runeEqual(pattern[0], first, caseSensitive) {
+		return 0, nil
+	}
+
+	// The match starts at the beginning, so it only needs to end as
+	// early as possible
+	pi := 0
+	end := -1
+	for i, r := range target {
+		if runeEqual(pattern[pi], r, caseSensitive) {
+			pi++
+			if pi == len(pattern) {
+				end = i + utf8.RuneLen(r)
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil
+	}
+	return score(pattern, target, 0, end, caseSensitive)
+}
+
+// score computes the score of the match of pattern in target[start:end],
+// which must contain the characters of pattern in order, and returns it
+// along with the offsets of the matched characters
+func score(pattern []rune, target string, start, end int, caseSensitive bool) (int, []int) {
+	prevClass := charNonWord
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(target[:start])
+		prevClass = classOf(r)
+	}
+
+	var score, firstBonus int
+	var inGap, consecutive bool
+	offsets := make([]int, 0, len(pattern))
+	pi := 0
+	for i, r := range target[start:end] {
+		class := classOf(r)
+		if pi < len(pattern) && runeEqual(pattern[pi], r, caseSensitive) {
+			bonus := bonusFor(prevClass, class)
+			if consecutive {
+				// Consecutive chunks keep the bonus of their first
+				// character, so that matching a whole word is
+				// preferred over matching scattered characters
+				if firstBonus > bonus {
+					bonus = firstBonus
+				}
+				if bonusConsecutive > bonus {
+					bonus = bonusConsecutive
+				}
+			} else {
+				firstBonus = bonus
+			}
+
+			if pi == 0 {
+				bonus *= bonusFirstCharMultiplier
+			}
+			score += scoreMatch + bonus
+			offsets = append(offsets, start+i)
+			pi++
+			inGap = false
+			consecutive = true
+		} else {
+			if inGap {
+				score += scoreGapExtension
+			} else {
+				score += scoreGapStart
+			}
+			inGap = true
+			consecutive = false
+			firstBonus = 0
+		}
+		prevClass = class
+	}
+
+	return score, offsets
+}
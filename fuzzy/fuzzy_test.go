@@ -0,0 +1,72 @@
+package fuzzy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScore(t *testing.T) {
+	testValues := []struct {
+		query   string
+		target  string
+		offsets []int
+	}{
+		{"", "foo", []int{}},
+		{"abc", "aXbXc", []int{0, 2, 4}},
+		{"abc", "ab", nil},
+		{"ABC", "abc", nil},
+		{"abc", "ABC", []int{0, 1, 2}},
+		{"abc", "a_abc", []int{2, 3, 4}}, // shortest match wins
+		{"日本", "こんにちは日本語", []int{15, 18}},
+	}
+
+	for _, v := range testValues {
+		_, offsets := Score(v.query, v.target)
+		assert.Equal(t, v.offsets, offsets, "Score(%q, %q)", v.query, v.target)
+	}
+}
+
+func TestScorePrefix(t *testing.T) {
+	testValues := []struct {
+		query   string
+		target  string
+		offsets []int
+	}{
+		{"", "foo", []int{}},
+		{"abc", "a_abc", []int{0, 3, 4}}, // unlike Score, the first character is fixed
+		{"abc", "_abc", nil},
+		{"abc", "", nil},
+		{"Abc", "abc", nil},
+	}
+
+	for _, v := range testValues {
+		_, offsets := ScorePrefix(v.query, v.target)
+		assert.Equal(t, v.offsets, offsets, "ScorePrefix(%q, %q)", v.query, v.target)
+	}
+}
+
+func TestScoreRanking(t *testing.T) {
+	// Each target is expected to score higher than the next one
+	testValues := []struct {
+		query   string
+		targets []string
+	}{
+		// consecutive characters
+		{"foo", []string{"foo", "f_oo", "f_o_o"}},
+		// word boundaries
+		{"fb", []string{"foo_bar", "foobar"}},
+		// camel case
+		{"fb", []string{"fooBar", "foobar"}},
+		// shorter gaps
+		{"ab", []string{"axb", "axxxxb"}},
+	}
+
+	for _, v := range testValues {
+		for i := 1; i < len(v.targets); i++ {
+			prev, _ := Score(v.query, v.targets[i-1])
+			cur, _ := Score(v.query, v.targets[i])
+			assert.True(t, prev > cur, "%q should score higher than %q against %q (%d vs %d)", v.targets[i-1], v.targets[i], v.query, prev, cur)
+		}
+	}
+}
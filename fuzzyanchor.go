@@ -0,0 +1,52 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// IsValidFuzzyAnchor checks if a string is a supported FuzzyAnchor.
+// The empty string is the same as FuzzyAnchorNone
+func IsValidFuzzyAnchor(v string) bool {
+	switch v {
+	case "", FuzzyAnchorNone, FuzzyAnchorPrefix:
+		return true
+	}
+	return false
+}
+
+// FuzzyAnchor returns true if the first character of the query must
+// match at the anchor of the lines. See filter.WithFuzzyAnchor
+func (p *Peco) FuzzyAnchor() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.fuzzyAnchor
+}
+
+func (p *Peco) SetFuzzyAnchor(b bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.fuzzyAnchor = b
+}
+
+// doToggleFuzzyAnchor switches between anchoring the matches of the
+// fuzzy filters and matching anywhere in the lines, and runs the
+// current query again
+func doToggleFuzzyAnchor(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleFuzzyAnchor")
+		defer g.End()
+	}
+
+	b := !state.FuzzyAnchor()
+	state.SetFuzzyAnchor(b)
+	if b {
+		state.Hub().SendStatusMsgAndClear("Anchor fuzzy matches", time.Second)
+	} else {
+		state.Hub().SendStatusMsgAndClear("Match anywhere", time.Second)
+	}
+	state.ExecQuery()
+}
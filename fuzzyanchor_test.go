@@ -0,0 +1,59 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToggleFuzzyAnchor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--initial-filter", "Fuzzy", "--query", "bg"}
+	p.Stdin = bytes.NewBufferString("src/bar.go\nbin/big.go\nlib/go.mod\n")
+	p.config.IncrementalFilter = true
+	p.config.FuzzyAnchor = FuzzyAnchorPrefix
+	p.config.FuzzyAnchorSeparators = "/"
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached while waiting for %v, got %v", expected, bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	if !waitLines([]string{"src/bar.go", "bin/big.go"}) {
+		return
+	}
+
+	doToggleFuzzyAnchor(ctx, p, termbox.Event{})
+	if !assert.False(t, p.FuzzyAnchor(), "matches should not be anchored") {
+		return
+	}
+	if !waitLines([]string{"src/bar.go", "bin/big.go", "lib/go.mod"}) {
+		return
+	}
+
+	// The results of the query that matched anywhere must not be
+	// reused
+	doToggleFuzzyAnchor(ctx, p, termbox.Event{})
+	if !assert.True(t, p.FuzzyAnchor(), "matches should be anchored") {
+		return
+	}
+	waitLines([]string{"src/bar.go", "bin/big.go"})
+}
@@ -0,0 +1,272 @@
+package peco
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// NewGlobSource creates a new Source that walks the filesystem, and
+// reads the paths of the files that match pattern as its lines, as they
+// are found. The pattern is matched like filepath.Match, except that
+// "**" as an entire element of the pattern matches any number of
+// directories, including none. The walk starts at the directory given
+// by the elements of the pattern that come before the first one with
+// wildcards. If gitignore is true, the files ignored by the .gitignore
+// files found along the way are skipped, and so are .git directories.
+// Directories that cannot be read are available from Errors() once
+// Setup() is done
+func NewGlobSource(pattern string, gitignore bool, idgen line.IDGenerator, capacity int, enableSep bool) (*Source, error) {
+	w, err := newGlobWalker(pattern, gitignore)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewSource(pattern, nil, idgen, capacity, enableSep)
+	s.glob = w
+	return s, nil
+}
+
+func newGlobWalker(pattern string, gitignore bool) (*globWalker, error) {
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	for _, e := range elems {
+		if _, err := path.Match(e, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid glob pattern '%s'", pattern)
+		}
+	}
+
+	// The last element is matched even if it has no wildcards, so that
+	// the root is always a directory
+	i := 0
+	for i < len(elems)-1 && !hasGlobMeta(elems[i]) {
+		i++
+	}
+	root := strings.Join(elems[:i], "/")
+	switch {
+	case root == "" && strings.HasPrefix(pattern, "/"):
+		root = "/"
+	case root == "":
+		root = "."
+	}
+
+	var rest []string
+	for _, e := range elems[i:] {
+		if e != "" {
+			rest = append(rest, e)
+		}
+	}
+	if len(rest) == 0 {
+		return nil, errors.Errorf("invalid glob pattern '%s': nothing to match", pattern)
+	}
+
+	return &globWalker{
+		root:      filepath.FromSlash(root),
+		elems:     rest,
+		gitignore: gitignore,
+	}, nil
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// matchGlob returns true if the elements of a path match the elements
+// of a pattern, where "**" matches any number of elements
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchGlob(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchGlobDir returns true if the paths under the directory given by
+// its elements may match the pattern, so that it is worth walking
+func matchGlobDir(pattern, dir []string) bool {
+	for len(dir) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], dir[0]); !ok {
+			return false
+		}
+		pattern, dir = pattern[1:], dir[1:]
+	}
+	return len(pattern) > 0
+}
+
+// walk sends the paths of the files that match the pattern to lines,
+// until the walk is done or ctx is canceled. Directories that cannot be
+// read are reported to onError, and skipped
+func (w *globWalker) walk(ctx context.Context, lines chan sourceLine, scanned *int, onError func(error)) error {
+	if pdebug.Enabled {
+		g := pdebug.Marker("globWalker.walk %s", w.root)
+		defer g.End()
+	}
+
+	var ignores []gitignoreRule
+	err := filepath.Walk(w.root, func(p string, info os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			onError(errors.Wrapf(err, "failed to read %s", p))
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(w.root, p)
+		if err != nil || rel == "." {
+			if w.gitignore && info.IsDir() {
+				ignores = append(ignores, readGitignore(p, "")...)
+			}
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		elems := strings.Split(rel, "/")
+
+		if info.IsDir() {
+			if w.gitignore && (info.Name() == ".git" || isGitignored(ignores, rel, true)) {
+				return filepath.SkipDir
+			}
+			if !matchGlobDir(w.elems, elems) {
+				return filepath.SkipDir
+			}
+			if w.gitignore {
+				ignores = append(ignores, readGitignore(p, rel)...)
+			}
+			return nil
+		}
+
+		if !matchGlob(w.elems, elems) {
+			return nil
+		}
+		if w.gitignore && isGitignored(ignores, rel, false) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case lines <- sourceLine{text: p}:
+		}
+		*scanned++
+		return nil
+	})
+	if err == ctx.Err() {
+		return nil
+	}
+	return err
+}
+
+// readGitignore reads the rules of the .gitignore file in dir, if there
+// is one. base is the path of dir relative to the root of the walk
+func readGitignore(dir, base string) []gitignoreRule {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []gitignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, ok := parseGitignoreRule(scanner.Text(), base); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// parseGitignoreRule parses a line of a .gitignore file. The second
+// return value is false for blank lines and comments
+func parseGitignoreRule(s, base string) (gitignoreRule, bool) {
+	s = strings.TrimRight(strings.TrimSuffix(s, "\r"), " ")
+	if s == "" || strings.HasPrefix(s, "#") {
+		return gitignoreRule{}, false
+	}
+
+	r := gitignoreRule{base: base}
+	if strings.HasPrefix(s, "!") {
+		r.negated = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, `\!`) || strings.HasPrefix(s, `\#`) {
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		r.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+
+	// Patterns with a slash other than at the end are relative to the
+	// directory of the .gitignore file, the others match the name of a
+	// file in any directory below it
+	r.anchored = strings.Contains(s, "/")
+	s = strings.TrimPrefix(s, "/")
+	if s == "" {
+		return gitignoreRule{}, false
+	}
+	r.elems = strings.Split(s, "/")
+	return r, true
+}
+
+// isGitignored returns true if the path, relative to the root of the
+// walk, is ignored by the rules. The last rule that matches wins
+func isGitignored(rules []gitignoreRule, rel string, dir bool) bool {
+	var ignored bool
+	for _, r := range rules {
+		if r.matches(rel, dir) {
+			ignored = !r.negated
+		}
+	}
+	return ignored
+}
+
+func (r gitignoreRule) matches(rel string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+
+	elems := strings.Split(rel, "/")
+	if r.anchored {
+		return matchGlob(r.elems, elems)
+	}
+	ok, _ := path.Match(r.elems[0], elems[len(elems)-1])
+	return ok
+}
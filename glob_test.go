@@ -0,0 +1,161 @@
+package peco
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	testValues := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/peco/main.go", true},
+		{"**/*.go", "cmd/peco/main.c", false},
+		{"cmd/**", "cmd/peco/main.go", true},
+		{"cmd/**/main.go", "cmd/main.go", true},
+		{"cmd/**/main.go", "lib/main.go", false},
+		{"a/*/c", "a/b/c", true},
+		{"a/*/c", "a/b/b/c", false},
+	}
+	for _, v := range testValues {
+		assert.Equal(t, v.expected, matchGlob(strings.Split(v.pattern, "/"), strings.Split(v.name, "/")), "'%s' against '%s'", v.pattern, v.name)
+	}
+
+	// Directories are only walked if the paths below them may match
+	assert.True(t, matchGlobDir([]string{"**", "*.go"}, []string{"a", "b"}), "** should walk any directory")
+	assert.True(t, matchGlobDir([]string{"a", "*", "*.go"}, []string{"a", "b"}), "a/b may have matching files")
+	assert.False(t, matchGlobDir([]string{"a", "*.go"}, []string{"a", "b"}), "a/b is too deep")
+	assert.False(t, matchGlobDir([]string{"a", "*.go"}, []string{"b"}), "b does not match")
+}
+
+func TestNewGlobWalker(t *testing.T) {
+	testValues := []struct {
+		pattern string
+		root    string
+		elems   []string
+	}{
+		{"**/*.go", ".", []string{"**", "*.go"}},
+		{"cmd/peco/*.go", filepath.FromSlash("cmd/peco"), []string{"*.go"}},
+		{"cmd/peco", "cmd", []string{"peco"}},
+		{"/tmp/*.log", string(filepath.Separator) + "tmp", []string{"*.log"}},
+	}
+	for _, v := range testValues {
+		w, err := newGlobWalker(v.pattern, false)
+		if !assert.NoError(t, err, "newGlobWalker should succeed for '%s'", v.pattern) {
+			continue
+		}
+		assert.Equal(t, v.root, w.root, "root of '%s' should match", v.pattern)
+		assert.Equal(t, v.elems, w.elems, "elements of '%s' should match", v.pattern)
+	}
+
+	_, err := newGlobWalker("foo/[a", false)
+	assert.Error(t, err, "malformed pattern should fail")
+}
+
+func TestGitignoreRule(t *testing.T) {
+	rules := []gitignoreRule{}
+	for _, s := range []string{"# comment", "", "*.log", "!keep.log", "/build", "tmp/", "docs/*.html"} {
+		if r, ok := parseGitignoreRule(s, ""); ok {
+			rules = append(rules, r)
+		}
+	}
+	if r, ok := parseGitignoreRule("*.txt", "sub"); ok {
+		rules = append(rules, r)
+	}
+
+	testValues := []struct {
+		rel      string
+		dir      bool
+		expected bool
+	}{
+		{"a.log", false, true},
+		{"sub/a.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"sub/build", true, false},
+		{"tmp", true, true},
+		{"tmp", false, false},
+		{"docs/index.html", false, true},
+		{"sub/docs/index.html", false, false},
+		{"sub/a.txt", false, true},
+		{"a.txt", false, false},
+		{"main.go", false, false},
+	}
+	for _, v := range testValues {
+		assert.Equal(t, v.expected, isGitignored(rules, v.rel, v.dir), "'%s' should be ignored: %t", v.rel, v.expected)
+	}
+}
+
+func TestGlobSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-glob")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".gitignore":        "*.gen.go\nvendor/\n",
+		"main.go":           "",
+		"main.gen.go":       "",
+		"README.md":         "",
+		"cmd/peco/peco.go":  "",
+		"vendor/lib/lib.go": "",
+		"sub/.gitignore":    "!*.gen.go\n",
+		"sub/sub.gen.go":    "",
+		".git/hooks/x.go":   "",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755), "MkdirAll should succeed") {
+			return
+		}
+		if !assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	read := func(pattern string, gitignore bool) []string {
+		ig := newIDGen()
+		go ig.Run(ctx)
+
+		s, err := NewGlobSource(pattern, gitignore, ig, 0, false)
+		if !assert.NoError(t, err, "NewGlobSource should succeed") {
+			return nil
+		}
+		p := New()
+		p.hub = nullHub{}
+		go s.Setup(ctx, p)
+		<-s.SetupDone()
+		assert.Empty(t, s.Errors(), "there should be no errors")
+
+		var paths []string
+		for _, l := range bufferLines(s) {
+			rel, err := filepath.Rel(dir, l)
+			if !assert.NoError(t, err, "paths should be under the directory") {
+				return nil
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return paths
+	}
+
+	pattern := filepath.Join(dir, "**", "*.go")
+	assert.Equal(t, []string{".git/hooks/x.go", "cmd/peco/peco.go", "main.gen.go", "main.go", "sub/sub.gen.go", "vendor/lib/lib.go"}, read(pattern, false), "all files should match")
+	assert.Equal(t, []string{"cmd/peco/peco.go", "main.go", "sub/sub.gen.go"}, read(pattern, true), "ignored files should be skipped")
+	assert.Equal(t, []string{"main.gen.go", "main.go"}, read(filepath.Join(dir, "*.go"), false), "only the top directory should match")
+}
@@ -0,0 +1,95 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+)
+
+// isGroupHeader returns true if l matches the GroupPattern
+func (p *Peco) isGroupHeader(l line.Line) bool {
+	return p.groupPattern != nil && p.groupPattern.MatchString(l.DisplayString())
+}
+
+// skipsGroupHeaders returns true if the cursor does not stop on the
+// group headers
+func (p *Peco) skipsGroupHeaders() bool {
+	return p.groupPattern != nil && p.config.GroupHeadersSkippable
+}
+
+// skipGroupHeaders returns the first line of b, starting from n and
+// going in the direction of dir, that is not a group header. The lines
+// wrap around at either end. n is returned if all the lines are headers
+func skipGroupHeaders(state *Peco, b Buffer, n, dir int) int {
+	size := b.Size()
+	for i := 0; i < size; i++ {
+		m := ((n+dir*i)%size + size) % size
+		l, err := b.LineAt(m)
+		if err != nil {
+			break
+		}
+		if !state.isGroupHeader(l) {
+			return m
+		}
+	}
+	return n
+}
+
+func doNextGroup(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doNextGroup")
+		defer g.End()
+	}
+	jumpToGroup(state, 1)
+}
+
+func doPrevGroup(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doPrevGroup")
+		defer g.End()
+	}
+	jumpToGroup(state, -1)
+}
+
+// jumpToGroup moves the cursor to the closest group header in the
+// current line buffer, looking in the given direction from the current
+// line and wrapping around at either end. If the headers are skipped,
+// the cursor moves to the first line of the group instead. When going
+// backwards, the header of the group that the cursor is in is skipped
+// if the cursor is already on its first line
+func jumpToGroup(state *Peco, dir int) {
+	if state.groupPattern == nil {
+		state.Hub().SendStatusMsgAndClear("GroupPattern is not configured", time.Second)
+		return
+	}
+
+	b := state.CurrentLineBuffer()
+	size := b.Size()
+	loc := state.Location()
+	current := loc.LineNumber()
+	skip := state.skipsGroupHeaders()
+	for i := 1; i <= size; i++ {
+		n := ((current+dir*i)%size + size) % size
+		l, err := b.LineAt(n)
+		if err != nil || !state.isGroupHeader(l) {
+			continue
+		}
+		if skip {
+			n = skipGroupHeaders(state, b, n, 1)
+			if dir < 0 && n == current {
+				// Already at the top of this group
+				continue
+			}
+		}
+		if n == current {
+			return
+		}
+		loc.SetLineNumber(n)
+		state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+		return
+	}
+	state.Hub().SendStatusMsgAndClear("No groups in the current results", time.Second)
+}
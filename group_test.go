@@ -0,0 +1,96 @@
+package peco
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGroupState(skippable bool) *Peco {
+	state := newPeco()
+	state.hub = nullHub{}
+	state.groupPattern = regexp.MustCompile(`^#`)
+	state.config.GroupHeadersSkippable = skippable
+
+	buf := NewMemoryBuffer()
+	for i, s := range []string{"# a", "1", "2", "# b", "3", "# c", "# d", "4"} {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), s, false))
+	}
+	state.currentLineBuffer = buf
+	return state
+}
+
+func TestJumpToGroup(t *testing.T) {
+	t.Run("headers", func(t *testing.T) {
+		state := newGroupState(false)
+		for i, expected := range []int{3, 5, 6, 0} {
+			doNextGroup(context.Background(), state, termbox.Event{})
+			if !assert.Equal(t, expected, state.Location().LineNumber(), "NextGroup #%d", i) {
+				return
+			}
+		}
+		for i, expected := range []int{6, 5, 3} {
+			doPrevGroup(context.Background(), state, termbox.Event{})
+			if !assert.Equal(t, expected, state.Location().LineNumber(), "PrevGroup #%d", i) {
+				return
+			}
+		}
+	})
+
+	t.Run("skippable", func(t *testing.T) {
+		state := newGroupState(true)
+		state.Location().SetLineNumber(1)
+		for i, expected := range []int{4, 7, 1} {
+			doNextGroup(context.Background(), state, termbox.Event{})
+			if !assert.Equal(t, expected, state.Location().LineNumber(), "NextGroup #%d", i) {
+				return
+			}
+		}
+		state.Location().SetLineNumber(2)
+		for i, expected := range []int{1, 7, 4} {
+			doPrevGroup(context.Background(), state, termbox.Event{})
+			if !assert.Equal(t, expected, state.Location().LineNumber(), "PrevGroup #%d", i) {
+				return
+			}
+		}
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		state := newGroupState(false)
+		state.groupPattern = nil
+		doNextGroup(context.Background(), state, termbox.Event{})
+		assert.Equal(t, 0, state.Location().LineNumber(), "cursor should not move")
+	})
+}
+
+func TestSkipGroupHeaders(t *testing.T) {
+	state := newGroupState(true)
+	layout := NewDefaultLayout(state)
+	perPage := layout.linesPerPage()
+	layout.CalculatePage(state, perPage)
+
+	state.Location().SetLineNumber(2)
+	for i, expected := range []int{4, 7, 1} {
+		layout.MovePage(state, ToLineBelow)
+		layout.CalculatePage(state, perPage)
+		if !assert.Equal(t, expected, state.Location().LineNumber(), "ToLineBelow #%d", i) {
+			return
+		}
+	}
+	for i, expected := range []int{7, 4, 2} {
+		layout.MovePage(state, ToLineAbove)
+		layout.CalculatePage(state, perPage)
+		if !assert.Equal(t, expected, state.Location().LineNumber(), "ToLineAbove #%d", i) {
+			return
+		}
+	}
+
+	// Headers cannot be selected
+	state.Location().SetLineNumber(0)
+	doToggleSelection(context.Background(), state, termbox.Event{})
+	assert.Equal(t, 0, state.Selection().Len(), "header should not be selected")
+}
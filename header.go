@@ -0,0 +1,86 @@
+package peco
+
+import (
+	"github.com/peco/peco/line"
+)
+
+// Headers returns the lines kept out of the candidates with
+// HeaderLines, in the order they were read
+func (s *Source) Headers() []line.Line {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.headers
+}
+
+func (s *Source) addHeader(l line.Line) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.headers = append(s.headers, l)
+}
+
+// headerLines returns the lines displayed above the list with
+// HeaderLines, or nil if there are none
+func (p *Peco) headerLines() []line.Line {
+	src, ok := p.Source().(*Source)
+	if !ok || src == nil {
+		return nil
+	}
+	return src.Headers()
+}
+
+// headerRows returns the number of rows of the list area that the
+// header lines take. At least one row is left for the list
+func (l *BasicLayout) headerRows(state *Peco) int {
+	return maxOf(minOf(len(state.headerLines()), l.listRows()-1), 0)
+}
+
+// firstRow returns the row of the screen that the first line of the
+// list is displayed on, after the rows taken by the header lines
+func (l *ListArea) firstRow() int {
+	if l.sortTopDown {
+		return l.AnchorPosition() + l.headerRows
+	}
+	return l.AnchorPosition() - l.headerRows
+}
+
+// drawHeaders draws the header lines between the prompt and the list,
+// in the order they were read. They start at the same column as the
+// text of the lines, so that the columns of a table line up, and are
+// scrolled horizontally along with them
+func (l *ListArea) drawHeaders(state *Peco) {
+	if l.headerRows == 0 {
+		return
+	}
+
+	headers := state.headerLines()
+	top := l.AnchorPosition()
+	if !l.sortTopDown {
+		top -= l.headerRows - 1
+	}
+
+	fg, bg := l.styles.Basic.fg, l.styles.Basic.bg
+	if st := l.styles.Header; st != (Style{}) {
+		fg, bg = st.fg, st.bg
+	}
+
+	gutterWidth := lineNumberWidth(state)
+	column := state.Location().Column()
+	x := l.textColumn(state, gutterWidth, 0) - column
+	for i := 0; i < l.headerRows && i < len(headers); i++ {
+		l.screen.Print(PrintArgs{
+			Y:    top + i,
+			Fg:   fg,
+			Bg:   bg,
+			Fill: true,
+		})
+		l.screen.Print(PrintArgs{
+			X:       x,
+			Y:       top + i,
+			XOffset: column - gutterWidth,
+			Fg:      fg,
+			Bg:      bg,
+			Msg:     state.expandDisplayTabs(headers[i].DisplayString()),
+			Fill:    true,
+		})
+	}
+}
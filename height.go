@@ -0,0 +1,163 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+)
+
+// minRegionHeight is the smallest number of rows that a regionScreen
+// uses, which leaves room for the prompt, the status bar and a line
+const minRegionHeight = 3
+
+// UnmarshalJSON accepts a number of rows, or a string with either a
+// number of rows or a percentage
+func (h *Height) UnmarshalJSON(buf []byte) error {
+	var n int
+	if err := json.Unmarshal(buf, &n); err == nil {
+		*h = Height{value: n}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return errors.Wrap(err, "failed to unmarshal Height")
+	}
+	v, err := parseHeight(s)
+	if err != nil {
+		return err
+	}
+	*h = v
+	return nil
+}
+
+// MarshalJSON returns the height as UnmarshalJSON accepts it: a number
+// of rows, or a string with a percentage
+func (h Height) MarshalJSON() ([]byte, error) {
+	if h.percent {
+		return json.Marshal(h.String())
+	}
+	return json.Marshal(h.value)
+}
+
+// parseHeight parses a number of rows, such as "20", or a percentage,
+// such as "40%"
+func parseHeight(s string) (Height, error) {
+	var h Height
+	v := strings.TrimSpace(s)
+	if strings.HasSuffix(v, "%") {
+		h.percent = true
+		v = strings.TrimSpace(v[:len(v)-1])
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return Height{}, errors.Errorf("invalid height: %s", s)
+	}
+	h.value = n
+	return h, nil
+}
+
+// IsZero returns true if the height is not set, in which case the
+// entire terminal is used
+func (h Height) IsZero() bool {
+	return h.value == 0
+}
+
+// String returns the height as it would be written in the config file
+func (h Height) String() string {
+	if h.percent {
+		return strconv.Itoa(h.value) + "%"
+	}
+	return strconv.Itoa(h.value)
+}
+
+// validate returns an error if the height can't be used
+func (h Height) validate() error {
+	if h.value < 0 || (h.percent && h.value > 100) {
+		return errors.Errorf("invalid height: %s", h)
+	}
+	return nil
+}
+
+// rows returns the number of rows out of total that the height stands
+// for. The result is clamped to the size of the terminal
+func (h Height) rows(total int) int {
+	if h.IsZero() {
+		return total
+	}
+
+	n := h.value
+	if h.percent {
+		n = total * h.value / 100
+	}
+	if n < minRegionHeight {
+		n = minRegionHeight
+	}
+	if n > total {
+		n = total
+	}
+	return n
+}
+
+// newRegionScreen creates a Screen that draws in the rows at the bottom
+// of s, as many as height allows
+func newRegionScreen(s Screen, height Height) *regionScreen {
+	return &regionScreen{Screen: s, height: height}
+}
+
+// region returns the first row of s.Screen that is used, and the number
+// of rows. These change as the terminal is resized
+func (s *regionScreen) region() (int, int) {
+	_, h := s.Screen.Size()
+	rows := s.height.rows(h)
+	return h - rows, rows
+}
+
+// Size returns the width of the terminal, and the number of rows that
+// are used
+func (s *regionScreen) Size() (int, int) {
+	w, _ := s.Screen.Size()
+	_, rows := s.region()
+	return w, rows
+}
+
+// SetCell writes to the terminal, relative to the first row that is
+// used. Cells outside of the rows that are used are not drawn
+func (s *regionScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	top, rows := s.region()
+	if y < 0 || y >= rows {
+		return
+	}
+	s.Screen.SetCell(x, top+y, ch, fg, bg)
+}
+
+// Print draws args.Msg relative to the first row that is used
+func (s *regionScreen) Print(args PrintArgs) int {
+	return screenPrint(s, args)
+}
+
+// PollEvent returns the events of s.Screen. The positions of the mouse
+// events are made relative to the first row that is used
+func (s *regionScreen) PollEvent(ctx context.Context) chan termbox.Event {
+	in := s.Screen.PollEvent(ctx)
+	out := make(chan termbox.Event)
+	go func() {
+		defer close(out)
+		for ev := range in {
+			if ev.Type == termbox.EventMouse {
+				top, _ := s.region()
+				ev.MouseY -= top
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- ev:
+			}
+		}
+	}()
+	return out
+}
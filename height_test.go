@@ -0,0 +1,90 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeight(t *testing.T) {
+	tests := []struct {
+		json  string
+		valid bool
+		rows  int // out of 20
+	}{
+		{`8`, true, 8},
+		{`"8"`, true, 8},
+		{`"40%"`, true, 8},
+		{`" 50 % "`, true, 10},
+		{`1`, true, minRegionHeight},
+		{`"1%"`, true, minRegionHeight},
+		{`100`, true, 20},
+		{`"100%"`, true, 20},
+		{`0`, true, 20},
+		{`-1`, false, 0},
+		{`"150%"`, false, 0},
+	}
+
+	for _, test := range tests {
+		var h Height
+		if !assert.NoError(t, json.Unmarshal([]byte(test.json), &h), "%s should unmarshal", test.json) {
+			return
+		}
+		if !test.valid {
+			assert.Error(t, h.validate(), "%s should be invalid", test.json)
+			continue
+		}
+		if !assert.NoError(t, h.validate(), "%s should be valid", test.json) {
+			return
+		}
+		assert.Equal(t, test.rows, h.rows(20), "rows for %s should match", test.json)
+	}
+
+	for _, v := range []string{`"foo"`, `"%"`, `"40%%"`, `true`} {
+		var h Height
+		assert.Error(t, json.Unmarshal([]byte(v), &h), "%s should not unmarshal", v)
+	}
+}
+
+func TestRegionScreen(t *testing.T) {
+	screen := NewDummyScreen()
+	s := newRegionScreen(screen, Height{value: 4})
+
+	w, h := s.Size()
+	if !assert.Equal(t, []int{80, 4}, []int{w, h}, "size should be limited to the region") {
+		return
+	}
+
+	s.SetCell(0, 0, 'a', termbox.ColorDefault, termbox.ColorDefault)
+	s.SetCell(1, 3, 'b', termbox.ColorDefault, termbox.ColorDefault)
+	s.SetCell(2, 4, 'c', termbox.ColorDefault, termbox.ColorDefault)
+	s.SetCell(3, -1, 'd', termbox.ColorDefault, termbox.ColorDefault)
+	events := screen.interceptor.events["SetCell"]
+	if !assert.Len(t, events, 2, "cells outside of the region should not be drawn") {
+		return
+	}
+	assert.Equal(t, 6, events[0][1], "first row should be drawn at the top of the region")
+	assert.Equal(t, 9, events[1][1], "last row should be drawn at the bottom of the terminal")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evCh := s.PollEvent(ctx)
+	go screen.SendEvent(termbox.Event{Type: termbox.EventMouse, Key: termbox.MouseLeft, MouseY: 7})
+	ev := <-evCh
+	assert.Equal(t, 1, ev.MouseY, "mouse events should be relative to the region")
+}
+
+func TestMaxHeightLayout(t *testing.T) {
+	state := newPeco()
+	state.config.MaxHeight = Height{value: 50, percent: true}
+	if !assert.NoError(t, state.ApplyConfig(CLIOptions{}), "ApplyConfig should succeed") {
+		return
+	}
+	state.hub = nullHub{}
+
+	layout := NewDefaultLayout(state)
+	assert.Equal(t, 3, layout.linesPerPage(), "list should only use the rows of the region")
+}
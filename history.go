@@ -0,0 +1,226 @@
+package peco
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHistorySize is the number of queries kept in the history
+// when HistorySize is not specified in the config
+const DefaultHistorySize = 1000
+
+// LocateHistoryFile returns the path to the history file, which is
+// $XDG_STATE_HOME/peco/history if XDG_STATE_HOME is set, and
+// ~/.peco/history otherwise
+func LocateHistoryFile() (string, error) {
+	const basename = "history"
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "peco", basename), nil
+	}
+
+	home, err := homedirFunc()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get home directory")
+	}
+	return filepath.Join(home, ".peco", basename), nil
+}
+
+// NewHistory creates a new History that is stored in filename, and
+// remembers up to limit queries
+func NewHistory(filename string, limit int) *History {
+	return &History{
+		filename: filename,
+		limit:    limit,
+	}
+}
+
+// Load reads the queries stored in the history file. It is not an
+// error for the file not to exist
+func (h *History) Load() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entries, err := readHistoryFile(h.filename)
+	if err != nil {
+		return err
+	}
+
+	h.stored = len(entries)
+	if len(entries) > h.limit {
+		entries = entries[len(entries)-h.limit:]
+	}
+	h.entries = entries
+	h.current = len(entries)
+	return nil
+}
+
+// readHistoryFile reads the queries stored in filename, skipping the
+// empty ones and those that repeat the previous one. A file that does
+// not exist holds no queries
+func readHistoryFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to open history file %s", filename)
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		q := scanner.Text()
+		if q == "" || len(entries) > 0 && entries[len(entries)-1] == q {
+			continue
+		}
+		entries = append(entries, q)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read history file %s", filename)
+	}
+	return entries, nil
+}
+
+// Append adds the query to the history, and writes it to the history
+// file. Empty queries and queries that are the same as the last entry
+// are ignored.
+//
+// The query is written using a single write to a file opened with
+// O_APPEND, so that concurrently running instances of peco do not
+// clobber each other's entries. The history is locked while the file
+// is written, see lockHistory
+func (h *History) Append(q string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if q == "" || strings.ContainsAny(q, "\r\n") {
+		return nil
+	}
+
+	if n := len(h.entries); n > 0 && h.entries[n-1] == q {
+		h.current = n
+		return nil
+	}
+
+	h.entries = append(h.entries, q)
+	if len(h.entries) > h.limit {
+		h.entries = h.entries[len(h.entries)-h.limit:]
+	}
+	h.current = len(h.entries)
+
+	if err := os.MkdirAll(filepath.Dir(h.filename), 0700); err != nil {
+		return errors.Wrap(err, "failed to create history directory")
+	}
+
+	unlock, err := lockHistory(h.filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Once the file grows well over the limit, rewrite it with
+	// only the last entries
+	if h.stored+1 > 2*h.limit {
+		return h.rewrite(q)
+	}
+
+	f, err := os.OpenFile(h.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open history file %s", h.filename)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte(q + "\n")); err != nil {
+		return errors.Wrapf(err, "failed to write to history file %s", h.filename)
+	}
+	h.stored++
+	return nil
+}
+
+// rewrite replaces the history file with the last queries it holds,
+// followed by q. The file is read again rather than written out of the
+// entries that we remember, as other instances of peco may have added
+// theirs since it was loaded. It must be called with the history
+// locked, so that none are added while it is being rewritten.
+//
+// The entries are written to a temporary file first, which is then
+// renamed, so readers never see a partially written file
+func (h *History) rewrite(q string) error {
+	entries, err := readHistoryFile(h.filename)
+	if err != nil {
+		return err
+	}
+	if n := len(entries); n == 0 || entries[n-1] != q {
+		entries = append(entries, q)
+	}
+	if len(entries) > h.limit {
+		entries = entries[len(entries)-h.limit:]
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(h.filename), ".history")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary history file")
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, q := range entries {
+		w.WriteString(q)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write temporary history file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temporary history file")
+	}
+
+	if err := os.Rename(f.Name(), h.filename); err != nil {
+		return errors.Wrapf(err, "failed to replace history file %s", h.filename)
+	}
+	h.stored = len(entries)
+	return nil
+}
+
+// Previous returns the entry before the one currently being browsed.
+// When browsing starts, q is remembered so that Next can go back to it.
+// The second return value is false if there are no more entries
+func (h *History) Previous(q string) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.current <= 0 {
+		return "", false
+	}
+
+	if h.current == len(h.entries) {
+		h.pending = q
+	}
+	h.current--
+	return h.entries[h.current], true
+}
+
+// Next returns the entry after the one currently being browsed. After
+// the last entry, the query given to Previous when browsing started is
+// returned. The second return value is false if we are not browsing
+func (h *History) Next() (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.current >= len(h.entries) {
+		return "", false
+	}
+
+	h.current++
+	if h.current == len(h.entries) {
+		return h.pending, true
+	}
+	return h.entries[h.current], true
+}
@@ -0,0 +1,28 @@
+// +build !windows
+
+package peco
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockHistory takes an exclusive lock on the history stored in
+// filename, and returns the function that releases it. The lock is
+// taken on a separate file, as rewrite replaces the history file
+func lockHistory(filename string) (func(), error) {
+	f, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open history lock file %s.lock", filename)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock history file %s", filename)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,127 @@
+package peco
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-history")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "peco", "history")
+	h := NewHistory(filename, 3)
+	if !assert.NoError(t, h.Load(), "loading a missing history file should succeed") {
+		return
+	}
+
+	for _, q := range []string{"foo", "bar", "bar", "", "baz", "qux"} {
+		if !assert.NoError(t, h.Append(q), "h.Append(%q) should succeed", q) {
+			return
+		}
+	}
+
+	// Another instance appending to the same file
+	other := NewHistory(filename, 3)
+	if !assert.NoError(t, other.Load(), "loading the history file should succeed") {
+		return
+	}
+	if !assert.NoError(t, other.Append("quux"), "other.Append should succeed") {
+		return
+	}
+
+	h = NewHistory(filename, 3)
+	if !assert.NoError(t, h.Load(), "loading the history file should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"baz", "qux", "quux"}, h.entries, "history should be capped and deduped") {
+		return
+	}
+
+	// Browse back all the way, and then forward to the pending query
+	for _, expected := range []string{"quux", "qux", "baz"} {
+		q, ok := h.Previous("typing")
+		if !assert.True(t, ok, "h.Previous should return an entry") {
+			return
+		}
+		if !assert.Equal(t, expected, q, "h.Previous should return the previous entry") {
+			return
+		}
+	}
+	if _, ok := h.Previous("typing"); !assert.False(t, ok, "h.Previous should stop at the oldest entry") {
+		return
+	}
+
+	for _, expected := range []string{"qux", "quux", "typing"} {
+		q, ok := h.Next()
+		if !assert.True(t, ok, "h.Next should return an entry") {
+			return
+		}
+		if !assert.Equal(t, expected, q, "h.Next should return the next entry") {
+			return
+		}
+	}
+	if _, ok := h.Next(); !assert.False(t, ok, "h.Next should stop after the pending query") {
+		return
+	}
+}
+
+func TestHistoryRewrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-history")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "history")
+	h := NewHistory(filename, 2)
+	for _, q := range []string{"a", "b", "c", "d", "e"} {
+		if !assert.NoError(t, h.Append(q), "h.Append(%q) should succeed", q) {
+			return
+		}
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if !assert.NoError(t, err, "reading the history file should succeed") {
+		return
+	}
+	assert.Equal(t, "d\ne\n", string(buf), "history file should be rewritten once it grows over the limit")
+}
+
+func TestHistoryRewriteConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-history")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// The queries that another instance added after h was loaded are
+	// kept when h rewrites the file
+	filename := filepath.Join(dir, "history")
+	h := NewHistory(filename, 2)
+	other := NewHistory(filename, 2)
+	for _, q := range []string{"a", "b", "c", "d"} {
+		if !assert.NoError(t, h.Append(q), "h.Append(%q) should succeed", q) {
+			return
+		}
+	}
+	if !assert.NoError(t, other.Append("x"), "other.Append should succeed") {
+		return
+	}
+	if !assert.NoError(t, h.Append("e"), "h.Append should succeed") {
+		return
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if !assert.NoError(t, err, "reading the history file should succeed") {
+		return
+	}
+	assert.Equal(t, "x\ne\n", string(buf), "history file should be rewritten with the last queries of all instances")
+}
@@ -0,0 +1,63 @@
+package peco
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/internal/util"
+)
+
+// commandEnv returns the environment of the commands that peco executes:
+// a copy of the current environment, with some PECO specific variables.
+//
+// PECO_QUERY: current query value
+// PECO_FILENAME: input file name, if any. "-" for stdin
+// PECO_LINE_COUNT: number of lines in the original input
+func (p *Peco) commandEnv() []string {
+	env := os.Environ()
+	if s, ok := p.Source().(*Source); ok {
+		env = append(env,
+			`PECO_FILENAME=`+s.Name(),
+			`PECO_LINE_COUNT=`+strconv.Itoa(s.Size()),
+		)
+	}
+	return append(env, `PECO_QUERY=`+p.Query().String())
+}
+
+// setExitHook sets the command that is executed once peco exits. It is
+// only executed if peco is exiting by the time Run returns
+func (p *Peco) setExitHook(cmd string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.exitHook = cmd
+}
+
+// runExitHook executes the command set by setExitHook, if any. This is
+// called after the screen has been closed, so that the command can
+// write to the terminal. Its output goes to Stderr, as Stdout is
+// reserved for the selected lines. Failures to execute the command are
+// reported, but do not change the exit status of peco
+func (p *Peco) runExitHook() {
+	p.mutex.Lock()
+	hook := p.exitHook
+	p.mutex.Unlock()
+
+	if hook == "" {
+		return
+	}
+
+	if pdebug.Enabled {
+		g := pdebug.Marker("runExitHook %s", hook)
+		defer g.End()
+	}
+
+	cmd := util.Shell(hook)
+	cmd.Stdout = p.Stderr
+	cmd.Stderr = p.Stderr
+	cmd.Env = p.commandEnv()
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(p.Stderr, "failed to execute %s: %s\n", hook, err)
+	}
+}
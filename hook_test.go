@@ -0,0 +1,97 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-hook")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	run := func(t *testing.T, cfg Config, action func(context.Context, *Peco, termbox.Event)) (*Peco, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.Stdin = bytes.NewBufferString("foo\nbar\n")
+		p.Stderr = &bytes.Buffer{}
+		p.config = cfg
+		go func() {
+			<-p.Ready()
+			<-p.source.SetupDone()
+			p.Query().Set("foo")
+			action(ctx, p, termbox.Event{})
+		}()
+
+		err := p.Run(ctx)
+		assert.NoError(t, ctx.Err(), "peco should exit before the timeout")
+		return p, err
+	}
+
+	readHook := func(t *testing.T, out string) string {
+		buf, err := ioutil.ReadFile(out)
+		if !assert.NoError(t, err, "hook should have been executed") {
+			return ""
+		}
+		return string(buf)
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		out := filepath.Join(dir, "cancel")
+		_, err := run(t, Config{
+			OnCancel:        errorKey,
+			OnCancelCommand: `echo "$PECO_QUERY" > ` + out,
+			OnFinishCommand: `echo finish > ` + out,
+		}, doCancel)
+		if !assert.True(t, util.IsIgnorableError(err), "error should be ignorable") {
+			return
+		}
+		st, _ := util.GetExitStatus(err)
+		if !assert.Equal(t, ExitStatusNoSelection, st, "exit status should match") {
+			return
+		}
+		assert.Equal(t, "foo\n", readHook(t, out), "cancel hook should receive the query")
+	})
+
+	t.Run("finish", func(t *testing.T) {
+		out := filepath.Join(dir, "finish")
+		_, err := run(t, Config{
+			OnCancelCommand: `echo cancel > ` + out,
+			OnFinishCommand: `echo "$PECO_QUERY" > ` + out,
+		}, doFinish)
+		if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+			return
+		}
+		assert.Equal(t, "foo\n", readHook(t, out), "finish hook should receive the query")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		p, err := run(t, Config{
+			OnCancel:        errorKey,
+			OnCancelCommand: `echo oops >&2; exit 3`,
+		}, doCancel)
+		st, _ := util.GetExitStatus(err)
+		if !assert.Equal(t, ExitStatusNoSelection, st, "exit status should not be changed by the hook") {
+			return
+		}
+		stderr := p.Stderr.(*bytes.Buffer).String()
+		if !assert.Contains(t, stderr, "oops", "output of the hook should go to stderr") {
+			return
+		}
+		assert.Contains(t, stderr, "failed to execute", "failure should be reported")
+	})
+}
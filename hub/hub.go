@@ -1,6 +1,7 @@
 package hub
 
 import (
+	"sync/atomic"
 	"time"
 
 	pdebug "github.com/lestrrat/go-pdebug"
@@ -29,7 +30,6 @@ func (p payload) Done() {
 // NewHub creates a new Hub struct
 func New(bufsiz int) *Hub {
 	return &Hub{
-		isSync:      false,
 		queryCh:     make(chan Payload, bufsiz),
 		drawCh:      make(chan Payload, bufsiz),
 		statusMsgCh: make(chan Payload, bufsiz),
@@ -50,10 +50,10 @@ func (h *Hub) Batch(f func(), shouldLock bool) {
 		defer h.mutex.Unlock()
 	}
 
-	// temporarily set isSync = true
-	o := h.isSync
-	h.isSync = true
-	defer func() { h.isSync = o }()
+	// the messages are sent synchronously until f returns. Batch may
+	// be called from several goroutines at once, so they are counted
+	atomic.AddInt32(&h.batches, 1)
+	defer atomic.AddInt32(&h.batches, -1)
 
 	// ignore panics
 	defer func() { recover() }()
@@ -61,6 +61,12 @@ func (h *Hub) Batch(f func(), shouldLock bool) {
 	f()
 }
 
+// isSync returns true while the messages are sent synchronously, as
+// Batch is in progress
+func (h *Hub) isSync() bool {
+	return atomic.LoadInt32(&h.batches) > 0
+}
+
 // low-level utility
 func send(ch chan Payload, r *payload, needReply bool) {
 	if needReply {
@@ -78,7 +84,7 @@ func (h *Hub) QueryCh() chan Payload {
 
 // SendQuery sends the query string to be processed by the Filter
 func (h *Hub) SendQuery(q string) {
-	send(h.QueryCh(), NewPayload(q), h.isSync)
+	send(h.QueryCh(), NewPayload(q), h.isSync())
 }
 
 // DrawCh returns the channel to redraw the terminal display
@@ -88,14 +94,14 @@ func (h *Hub) DrawCh() chan Payload {
 
 // SendDrawPrompt sends a request to redraw the prompt only
 func (h *Hub) SendDrawPrompt() {
-	send(h.DrawCh(), NewPayload("prompt"), h.isSync)
+	send(h.DrawCh(), NewPayload("prompt"), h.isSync())
 }
 
 // SendDraw sends a request to redraw the terminal display
 func (h *Hub) SendDraw(options interface{}) {
 	pdebug.Printf("START Hub.SendDraw %v", options)
 	defer pdebug.Printf("END Hub.SendDraw %v", options)
-	send(h.DrawCh(), NewPayload(options), h.isSync)
+	send(h.DrawCh(), NewPayload(options), h.isSync())
 }
 
 // StatusMsgCh returns the channel to update the status message
@@ -111,6 +117,7 @@ func (h *Hub) SendStatusMsg(q string) {
 type statusMsgReq struct {
 	msg   string
 	delay time.Duration
+	level int
 }
 
 func (r statusMsgReq) Message() string {
@@ -121,22 +128,33 @@ func (r statusMsgReq) Delay() time.Duration {
 	return r.delay
 }
 
-func newStatusMsgReq(s string, d time.Duration) *statusMsgReq {
+func (r statusMsgReq) Level() int {
+	return r.level
+}
+
+func newStatusMsgReq(s string, d time.Duration, level int) *statusMsgReq {
 	return &statusMsgReq{
 		msg:   s,
 		delay: d,
+		level: level,
 	}
 }
 
 // SendStatusMsgAndClear sends a string to be displayed in the status message,
 // as well as a delay until the message should be cleared
 func (h *Hub) SendStatusMsgAndClear(q string, clearDelay time.Duration) {
-	msg := newStatusMsgReq(q, clearDelay)
-	send(h.StatusMsgCh(), NewPayload(msg), h.isSync)
+	h.SendStatusMsgLevel(q, clearDelay, 0)
+}
+
+// SendStatusMsgLevel is like SendStatusMsgAndClear, but also tells how
+// important the message is. The higher the level, the more important
+func (h *Hub) SendStatusMsgLevel(q string, clearDelay time.Duration, level int) {
+	msg := newStatusMsgReq(q, clearDelay, level)
+	send(h.StatusMsgCh(), NewPayload(msg), h.isSync())
 }
 
 func (h *Hub) SendPurgeDisplayCache() {
-	send(h.DrawCh(), NewPayload("purgeCache"), h.isSync)
+	send(h.DrawCh(), NewPayload("purgeCache"), h.isSync())
 }
 
 // PagingCh returns the channel to page through the results
@@ -146,5 +164,5 @@ func (h *Hub) PagingCh() chan Payload {
 
 // SendPaging sends a request to move the cursor around
 func (h *Hub) SendPaging(x interface{}) {
-	send(h.PagingCh(), NewPayload(x), h.isSync)
+	send(h.PagingCh(), NewPayload(x), h.isSync())
 }
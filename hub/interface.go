@@ -6,7 +6,7 @@ import "sync"
 // it controls how the communication that goes through channels
 // are handled.
 type Hub struct {
-	isSync      bool
+	batches     int32 // Batch calls in progress, accessed atomically
 	mutex       sync.Mutex
 	queryCh     chan Payload
 	drawCh      chan Payload
@@ -0,0 +1,89 @@
+package peco
+
+import (
+	"context"
+
+	"github.com/peco/peco/pipeline"
+)
+
+// IsValidIngestOverflow checks if a string is a supported
+// IngestOverflow. The empty string selects the default
+func IsValidIngestOverflow(v string) bool {
+	switch v {
+	case "", IngestOverflowBuffer, IngestOverflowDrop:
+		return true
+	}
+	return false
+}
+
+// ingestLimiter returns the node that limits the rate at which the
+// lines read by the source are added, see MaxIngestRate. Returns nil
+// if the rate is not limited
+func (p *Peco) ingestLimiter() *pipeline.RateLimitNode {
+	n := p.config.MaxIngestRate
+	switch {
+	case n <= 0:
+		return nil
+	case p.config.IngestOverflow == IngestOverflowDrop:
+		return pipeline.RateLimitDrop(n)
+	default:
+		return pipeline.RateLimit(n)
+	}
+}
+
+// ingestSplitter returns the node that splits each line read by the
+// source into the lines separated by SplitPattern. Returns nil if
+// SplitPattern is not configured
+func (p *Peco) ingestSplitter() pipeline.Acceptor {
+	re := p.splitPattern
+	if re == nil {
+		return nil
+	}
+	return pipeline.Split(func(v interface{}) []interface{} {
+		l := v.(sourceLine)
+		var items []interface{}
+		for _, s := range re.Split(l.text, -1) {
+			if s != "" {
+				items = append(items, sourceLine{text: s, file: l.file})
+			}
+		}
+		return items
+	})
+}
+
+// ingestLines runs the lines through node, and returns the channel
+// that receives those that it forwards. The channel is closed once
+// lines is closed and the lines that node buffered are forwarded
+func ingestLines(ctx context.Context, node pipeline.Acceptor, lines <-chan sourceLine) <-chan sourceLine {
+	in := make(chan interface{})
+	out := make(chan interface{})
+	limited := make(chan sourceLine)
+
+	go func() {
+		defer close(in)
+		for l := range lines {
+			select {
+			case <-ctx.Done():
+				return
+			case in <- l:
+			}
+		}
+	}()
+	go node.Accept(ctx, in, pipeline.ChanOutput(out))
+	go func() {
+		defer close(limited)
+		for v := range out {
+			l, ok := v.(sourceLine)
+			if !ok {
+				// The EndMark
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case limited <- l:
+			}
+		}
+	}()
+	return limited
+}
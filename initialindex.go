@@ -0,0 +1,88 @@
+package peco
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// initialIndexLast is the value of InitialIndex that stands for the
+// last line of the results
+const initialIndexLast = "last"
+
+// UnmarshalJSON accepts a 0 based index, or a string with either an
+// index or "last"
+func (i *InitialIndex) UnmarshalJSON(buf []byte) error {
+	var n int
+	if err := json.Unmarshal(buf, &n); err == nil {
+		*i = InitialIndex{value: n}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return errors.Wrap(err, "failed to unmarshal InitialIndex")
+	}
+	v, err := parseInitialIndex(s)
+	if err != nil {
+		return err
+	}
+	*i = v
+	return nil
+}
+
+// MarshalJSON returns the index as UnmarshalJSON accepts it: a number,
+// or "last"
+func (i InitialIndex) MarshalJSON() ([]byte, error) {
+	if i.last {
+		return json.Marshal(initialIndexLast)
+	}
+	return json.Marshal(i.value)
+}
+
+// parseInitialIndex parses a 0 based index, such as "2", or "last"
+func parseInitialIndex(s string) (InitialIndex, error) {
+	v := strings.TrimSpace(s)
+	if v == initialIndexLast {
+		return InitialIndex{last: true}, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return InitialIndex{}, errors.Errorf("invalid initial index: %s", s)
+	}
+	return InitialIndex{value: n}, nil
+}
+
+// IsZero returns true if the cursor starts on the first line, which is
+// where it is placed anyway
+func (i InitialIndex) IsZero() bool {
+	return !i.last && i.value == 0
+}
+
+// String returns the index as it would be given to --initial-index
+func (i InitialIndex) String() string {
+	if i.last {
+		return initialIndexLast
+	}
+	return strconv.Itoa(i.value)
+}
+
+// validate returns an error if the index can't be used
+func (i InitialIndex) validate() error {
+	if i.value < 0 {
+		return errors.Errorf("invalid initial index: %s", i)
+	}
+	return nil
+}
+
+// lineNumber returns the line that the cursor is placed on when there
+// are n lines in the results. Indices past the end stand for the last
+// line
+func (i InitialIndex) lineNumber(n int) int {
+	if i.last || i.value >= n {
+		return n - 1
+	}
+	return i.value
+}
@@ -0,0 +1,82 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitialIndex(t *testing.T) {
+	tests := []struct {
+		json       string
+		valid      bool
+		lineNumber int // out of 5 lines
+	}{
+		{`0`, true, 0},
+		{`3`, true, 3},
+		{`"3"`, true, 3},
+		{`10`, true, 4},
+		{`"last"`, true, 4},
+		{`-1`, false, 0},
+	}
+
+	for _, test := range tests {
+		var i InitialIndex
+		if !assert.NoError(t, json.Unmarshal([]byte(test.json), &i), "%s should unmarshal", test.json) {
+			return
+		}
+		if !test.valid {
+			assert.Error(t, i.validate(), "%s should be invalid", test.json)
+			continue
+		}
+		if !assert.NoError(t, i.validate(), "%s should be valid", test.json) {
+			return
+		}
+		assert.Equal(t, test.lineNumber, i.lineNumber(5), "line number for %s should match", test.json)
+	}
+
+	for _, v := range []string{`"foo"`, `"-1"`, `true`} {
+		var i InitialIndex
+		assert.Error(t, json.Unmarshal([]byte(v), &i), "%s should not unmarshal", v)
+	}
+}
+
+func TestInitialIndexAfterQuery(t *testing.T) {
+	tests := []struct {
+		index    string
+		expected int
+	}{
+		{"1", 1},
+		{"5", 2},
+		{"last", 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.index, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = []string{"peco", "--query", "ba", "--initial-index", test.index}
+			p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\nqux\nbam\n")
+			go p.Run(ctx)
+
+			<-p.Ready()
+			<-p.source.SetupDone()
+
+			// The index refers to the lines that matched the query
+			for p.CurrentLineBuffer().Size() != 3 || p.Location().LineNumber() != test.expected {
+				select {
+				case <-ctx.Done():
+					t.Errorf("cursor should be on line %d, got %d", test.expected, p.Location().LineNumber())
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		})
+	}
+}
@@ -3,10 +3,20 @@ package peco
 import (
 	"time"
 
+	"github.com/lestrrat/go-pdebug"
 	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
 	"context"
 )
 
+// doubleClickInterval is the maximum amount of time between two clicks
+// on the same line for them to be treated as a double click
+const doubleClickInterval = 500 * time.Millisecond
+
+// idleRecheckInterval is how long to wait before checking again if
+// the idle timeout is reached while a query is running
+const idleRecheckInterval = 100 * time.Millisecond
+
 func NewInput(state *Peco, am ActionMap, src chan termbox.Event) *Input {
 	return &Input{
 		actions: am,
@@ -18,14 +28,77 @@ func NewInput(state *Peco, am ActionMap, src chan termbox.Event) *Input {
 func (i *Input) Loop(ctx context.Context, cancel func()) error {
 	defer cancel()
 
+	// The idle timer is only created if IdleTimeout is set. Otherwise
+	// receiving from the nil channel blocks forever
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if d := i.state.idleTimeout; d > 0 {
+		idleTimer = time.NewTimer(d)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	// The key sequence timer is running only while we are in the
+	// middle of a key sequence
+	var seqExpired <-chan time.Time
+	var seqTimer *time.Timer
+	var seqLastKey termbox.Event
+	if d := i.state.keySequenceTimeout; d > 0 {
+		seqTimer = time.NewTimer(d)
+		defer seqTimer.Stop()
+		stopTimer(seqTimer)
+		seqExpired = seqTimer.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-seqExpired:
+			if pdebug.Enabled {
+				pdebug.Printf("Input: key sequence timeout reached")
+			}
+			i.state.dispatchAction(ctx, seqLastKey, true)
+		case <-idle:
+			// Results that are still coming in may be what the user
+			// is waiting for, so check again later
+			if i.state.FilterRunning() {
+				idleTimer.Reset(idleRecheckInterval)
+				continue
+			}
+			if pdebug.Enabled {
+				pdebug.Printf("Input: idle timeout reached")
+			}
+			i.state.Exit(setExitStatus(makeIgnorable(errors.New("idle timeout")), ExitStatusNoSelection))
+			return nil
+		case sig := <-i.state.signals:
+			i.state.handleSignal(ctx, sig)
 		case ev := <-i.evsrc:
+			if idleTimer != nil && (ev.Type == termbox.EventKey || ev.Type == termbox.EventMouse) {
+				stopTimer(idleTimer)
+				idleTimer.Reset(i.state.idleTimeout)
+			}
 			if err := i.handleInputEvent(ctx, ev); err != nil {
 				return nil
 			}
+			if seqTimer != nil && ev.Type == termbox.EventKey {
+				stopTimer(seqTimer)
+				if i.state.Keymap().Sequence().InMiddleOfChain() {
+					seqLastKey = ev
+					seqTimer.Reset(i.state.keySequenceTimeout)
+				}
+			}
+		}
+	}
+}
+
+// stopTimer stops t, and drains its channel so that it can be safely
+// reset
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
 		}
 	}
 }
@@ -35,7 +108,7 @@ func (i *Input) handleInputEvent(ctx context.Context, ev termbox.Event) error {
 	case termbox.EventError:
 		return nil
 	case termbox.EventResize:
-		i.state.Hub().SendDraw(nil)
+		i.state.resize()
 		return nil
 	case termbox.EventKey:
 		// ModAlt is a sequence of letters with a leading \x1b (=Esc).
@@ -54,7 +127,7 @@ func (i *Input) handleInputEvent(ctx context.Context, ev termbox.Event) error {
 				m.Lock()
 				i.mod = nil
 				m.Unlock()
-				i.state.Keymap().ExecuteAction(ctx, i.state, tmp)
+				i.state.dispatchAction(ctx, tmp, false)
 			})
 			m.Unlock()
 			return nil
@@ -71,10 +144,48 @@ func (i *Input) handleInputEvent(ctx context.Context, ev termbox.Event) error {
 		}
 		m.Unlock()
 
-		i.state.Keymap().ExecuteAction(ctx, i.state, ev)
+		i.state.dispatchAction(ctx, ev, false)
 
+		return nil
+	case termbox.EventMouse:
+		if !i.state.MouseEnabled() {
+			return nil
+		}
+		i.handleMouseEvent(ctx, ev)
 		return nil
 	}
 
 	return nil
 }
+
+// handleMouseEvent moves the selection to the clicked line, accepts the
+// line if it was double clicked, and pages through the list with the
+// mouse wheel
+func (i *Input) handleMouseEvent(ctx context.Context, ev termbox.Event) {
+	h := i.state.Hub()
+	switch ev.Key {
+	case termbox.MouseLeft:
+		i.mutex.Lock()
+		now := time.Now()
+		double := ev.MouseY == i.lastClickY && now.Sub(i.lastClick) < doubleClickInterval
+		if double {
+			// Don't let a third click count as another double click
+			i.lastClick = time.Time{}
+		} else {
+			i.lastClick = now
+			i.lastClickY = ev.MouseY
+		}
+		i.mutex.Unlock()
+
+		if double {
+			// The first click already moved the selection here
+			doFinish(ctx, i.state, ev)
+			return
+		}
+		h.SendPaging(JumpToScreenLineRequest(ev.MouseY))
+	case termbox.MouseWheelUp:
+		h.SendPaging(ToScrollPageUp)
+	case termbox.MouseWheelDown:
+		h.SendPaging(ToScrollPageDown)
+	}
+}
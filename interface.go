@@ -1,8 +1,14 @@
 package peco
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
+	"net"
+	"os"
+	"regexp"
 	"sync"
+	"text/template"
 	"time"
 
 	"context"
@@ -21,14 +27,32 @@ const (
 	errorKey   = "error"
 )
 
+// These are the exit statuses of the peco command
 const (
-	ToLineAbove      PagingRequestType = iota // ToLineAbove moves the selection to the line above
-	ToScrollPageDown                          // ToScrollPageDown moves the selection to the next page
-	ToLineBelow                               // ToLineBelow moves the selection to the line below
-	ToScrollPageUp                            // ToScrollPageUp moves the selection to the previous page
-	ToScrollLeft                              // ToScrollLeft scrolls screen to the left
-	ToScrollRight                             // ToScrollRight scrolls screen to the right
-	ToLineInPage                              // ToLineInPage jumps to a particular line on the page
+	ExitStatusSuccess     = 0 // ExitStatusSuccess is used when the selected lines were printed
+	ExitStatusNoSelection = 1 // ExitStatusNoSelection is used when peco exits without printing anything
+	ExitStatusError       = 2 // ExitStatusError is used when peco failed, for example because of an invalid config
+
+	// ExitStatusQueryAccepted is used when the query was printed
+	// instead of a line, as nothing matched it. See NoMatchAcceptQuery
+	ExitStatusQueryAccepted = 3
+)
+
+const (
+	ToLineAbove         PagingRequestType = iota // ToLineAbove moves the selection to the line above
+	ToScrollPageDown                             // ToScrollPageDown moves the selection to the next page
+	ToLineBelow                                  // ToLineBelow moves the selection to the line below
+	ToScrollPageUp                               // ToScrollPageUp moves the selection to the previous page
+	ToScrollLeft                                 // ToScrollLeft scrolls screen to the left
+	ToScrollRight                                // ToScrollRight scrolls screen to the right
+	ToLineInPage                                 // ToLineInPage jumps to a particular line on the page
+	ToScreenLine                                 // ToScreenLine jumps to the line displayed on a particular row of the screen
+	ToScrollFirstColumn                          // ToScrollFirstColumn scrolls screen back to the first column
+	ToScrollViewUp                               // ToScrollViewUp scrolls the list up by a line, without moving the selection
+	ToScrollViewDown                             // ToScrollViewDown scrolls the list down by a line, without moving the selection
+	ToFirstLine                                  // ToFirstLine moves the selection to the first line
+	ToLastLine                                   // ToLastLine moves the selection to the last line
+	ToMiddleLine                                 // ToMiddleLine moves the selection to the line in the middle of the screen
 )
 
 const (
@@ -37,6 +61,88 @@ const (
 	LayoutTypeBottomUp = "bottom-up"
 )
 
+const (
+	PositionTop    = "top"    // PositionTop puts the prompt or the status bar at the top of the screen
+	PositionBottom = "bottom" // PositionBottom puts the prompt or the status bar at the bottom of the screen
+)
+
+const (
+	ScrollModePage       = "page"       // ScrollModePage jumps a full page when the cursor moves past the edge of the screen
+	ScrollModeContinuous = "continuous" // ScrollModeContinuous scrolls just enough to keep the cursor on the screen
+)
+
+const (
+	SortNone     = "none"     // SortNone keeps the lines in the order they were read from the input
+	SortLength   = "length"   // SortLength sorts the lines from the shortest to the longest
+	SortAlpha    = "alpha"    // SortAlpha sorts the lines alphabetically
+	SortWeighted = "weighted" // SortWeighted sorts the lines from the highest to the lowest weight
+	SortFrecency = "frecency" // SortFrecency sorts the lines from the most to the least used, see FrecencyFile
+)
+
+const (
+	ResetQueryNever        = "never"        // ResetQueryNever keeps the query when the filter changes
+	ResetQueryAlways       = "always"       // ResetQueryAlways clears the query whenever the filter changes
+	ResetQueryIncompatible = "incompatible" // ResetQueryIncompatible clears the query if the new filter cannot use it
+)
+
+const (
+	NoMatchNoop        = "noop"         // NoMatchNoop finishes as usual when nothing matches, which prints nothing
+	NoMatchAcceptQuery = "accept-query" // NoMatchAcceptQuery prints the query and exits with ExitStatusQueryAccepted
+	NoMatchCancel      = "cancel"       // NoMatchCancel exits as if peco.Cancel was executed
+)
+
+const (
+	EmptyInputExit    = "exit"    // EmptyInputExit exits with ExitStatusNoSelection without bringing up the screen
+	EmptyInputWait    = "wait"    // EmptyInputWait brings up the screen with an empty list
+	EmptyInputMessage = "message" // EmptyInputMessage brings up the screen with a placeholder line in the list
+)
+
+const (
+	IngestOverflowBuffer = "buffer" // IngestOverflowBuffer keeps the lines read faster than MaxIngestRate until they can be added
+	IngestOverflowDrop   = "drop"   // IngestOverflowDrop drops the lines read faster than MaxIngestRate
+)
+
+const (
+	TruncateRight  = "right"  // TruncateRight cuts the end of the lines that do not fit on the screen
+	TruncateLeft   = "left"   // TruncateLeft cuts the beginning of the lines, which keeps the file name of paths visible
+	TruncateMiddle = "middle" // TruncateMiddle cuts the middle of the lines, keeping both ends visible
+	TruncateNone   = "none"   // TruncateNone cuts the lines at the edge of the screen, without an ellipsis
+)
+
+const (
+	FuzzyAnchorNone   = "none"   // FuzzyAnchorNone lets the fuzzy filters match anywhere in the lines
+	FuzzyAnchorPrefix = "prefix" // FuzzyAnchorPrefix makes the first character of the query match at the start of the lines
+)
+
+const (
+	MatchColumnOutputDisplay = "display" // MatchColumnOutputDisplay outputs the displayed text of the selected lines
+	MatchColumnOutputMatch   = "match"   // MatchColumnOutputMatch outputs the text that the queries are matched against
+)
+
+const (
+	LineTransformOutputTransformed = "transformed" // LineTransformOutputTransformed outputs the rewritten text of the selected lines
+	LineTransformOutputOriginal    = "original"    // LineTransformOutputOriginal outputs the selected lines as they were read
+)
+
+// DefaultPromptCountFormat is the PromptCountFormat used if none is
+// configured
+const DefaultPromptCountFormat = "{{.Filter}} [{{.Matched}}{{if .Approximate}}+{{end}} ({{.Page}}/{{.MaxPage}})]{{if .Pinned}} {{.Pinned}} pinned{{end}}"
+
+// DefaultEllipsis is the Ellipsis used if none is configured
+const DefaultEllipsis = "…"
+
+// DefaultRedrawInterval is the RedrawInterval used if none is
+// configured, which is about once per frame of a 60Hz display
+const DefaultRedrawInterval = 16
+
+// DefaultQuickSelectChars are the characters that peco.QuickSelect
+// labels the lines with if none are configured
+const DefaultQuickSelectChars = "asdfghjkl;"
+
+// DefaultEditorLinePattern is the EditorLinePattern used if none is
+// configured. It matches grep style lines such as "file.go:42:text"
+const DefaultEditorLinePattern = `^(?P<path>[^:]+)(?::(?P<line>\d+))?`
+
 const (
 	AnchorTop    VerticalAnchor = iota + 1 // AnchorTop anchors elements towards the top of the screen
 	AnchorBottom                           // AnchorBottom anchors elements towards the bottom of the screen
@@ -54,6 +160,36 @@ type idgen struct {
 	ch chan uint64
 }
 
+// repeatableAction is the action that peco.RepeatLastAction executes
+// again, along with the event that it was executed with
+type repeatableAction struct {
+	action Action
+	event  termbox.Event
+}
+
+// refinement is the state saved by peco.RefineByLine, so that
+// peco.PopRefinement can go back to it without running the filter
+type refinement struct {
+	query      string
+	caretPos   int
+	lineBuffer Buffer
+	lineNumber int
+	multiQuery []string
+}
+
+// actionPalette is the state that peco.ShowActionPalette replaces with
+// the list of actions, which is restored once the palette is dismissed
+type actionPalette struct {
+	actions    map[uint64]string // names of the actions by the IDs of their lines
+	caretPos   int
+	lineBuffer Buffer
+	lineNumber int
+	multiQuery []string
+	query      string
+	selection  *Selection
+	source     *Source
+}
+
 // Peco is the global object containing everything required to run peco.
 // It also contains the global state of the program.
 type Peco struct {
@@ -63,47 +199,156 @@ type Peco struct {
 	Stderr io.Writer
 	hub    MessageHub
 
-	args       []string
-	bufferSize int
-	caret      Caret
+	acceptAll   bool // True if the results are all the current lines, see peco.AcceptAll
+	acceptQuery bool // True if the query is printed instead of the results, see NoMatchAccept
+	annotator   *Annotator
+	ansiColors  bool // True if the colors of the input are displayed
+	args        []string
+	bufferSize  int
+	caret       Caret
 	// Config contains the values read in from config file
 	config                  Config
+	confirm                 *confirmation // action waiting for confirmation, nil if there is none
+	confirmTimeout          time.Duration // see ConfirmTimeout
 	currentLineBuffer       Buffer
-	enableSep               bool // Enable parsing on separators
+	editorLinePattern       *regexp.Regexp
+	ellipsis                string    // replaces the part of the lines that do not fit on the screen
+	emitOut                 io.Writer // where peco.EmitAndContinue writes, nil without --emit-to
+	emitTo                  string    // the file given with --emit-to
+	emptyInputBehavior      string    // see EmptyInputBehavior
+	emptyInputPlaceholder   string    // drawn in the list once the input turned out to be empty
+	enableSep               bool      // Enable parsing on separators
+	fieldSelectMode         bool      // True while waiting for the key typed after peco.SelectField
+	exitZero                bool      // True if --exit-0 is enabled
 	execOnFinish            string
+	executing               bool   // true while peco.ExecuteWithSelection runs a command
+	exitHook                string // command executed once peco exits, see OnCancelCommand
 	filters                 filter.Set
+	ignoreCaseRegexp        *filter.Regexp // used for "--regex -i" with QueryFlags
+	filtersRunning          int            // number of queries being run by Filter
+	filterBudget            time.Duration  // see FilterBudgetMs
+	redrawInterval          time.Duration  // see RedrawInterval
+	followMode              bool
+	frecency                frecencyTable // read from FrecencyFile, nil if it is not configured
+	idleTimeout             time.Duration
+	keySequenceTimeout      time.Duration
+	maxResults              int              // see MaxResults
+	lazyFilter              bool             // see LazyFilter
+	lazyRun                 *lazyFilterRun   // nil unless LazyFilter has more of the input to match
+	lazyWindow              int              // matches needed for the scroll position, see setLazyWindow
+	initialIndex            InitialIndex     // see InitialIndex
+	groupPattern            *regexp.Regexp   // nil if GroupPattern is not configured
+	splitPattern            *regexp.Regexp   // nil if SplitPattern is not configured
+	lineTransformer         *lineTransformer // nil if LineTransform is not configured
+	pendingSession          *session         // session being restored, see SessionFile
+	firstFilterCh           chan Buffer      // receives the result of the first query
+	fixedStringReturn       string           // filter that peco.ToggleFixedString goes back to
+	firstFilterOnce         sync.Once
+	history                 *History // nil if history is disabled
 	idgen                   *idgen
 	initialFilter           string
 	initialQuery            string   // populated if --query is specified
 	inputseq                Inputseq // current key sequence (just the names)
 	keymap                  Keymap
+	lastAction              repeatableAction // see peco.RepeatLastAction
+	actionRepeated          bool             // true if the running action repeated lastAction
+	benchmarkFile           string           // see --benchmark
+	benchmarkIterations     int
+	countOnly               bool // see --count
 	layoutType              string
 	location                Location
+	mark                    line.Line // set by peco.SetMark
+	horizontalScrollStep    int       // columns to scroll with ScrollLeft/ScrollRight, 0 for half the screen
+	followMatch             bool      // see FollowMatch
 	maxScanBufferSize       int
+	mouseEnabled            bool
+	preview                 *Preview
+	multiQuery              []string // populated if --query is specified more than once
 	mutex                   sync.Mutex
 	onCancel                string
+	outputCaptureGroup      int // see OutputCaptureGroup
+	outputField             int // field output instead of the entire line, 0 for the entire line
+	outputFormat            string
+	outputTemplate          *template.Template // nil if OutputTemplate is not configured
+	palette                 *actionPalette     // nil unless peco.ShowActionPalette is shown
+	print0                  bool               // Terminate output lines with NUL
 	prompt                  string
+	promptPosition          string             // see PromptPosition
+	statusPosition          string             // see StatusPosition
+	promptCountTemplate     *template.Template // nil to use DefaultPromptCountFormat
 	query                   Query
+	quickSelect             *quickSelectState // nil unless peco.QuickSelect is active
+	quickSelectChars        []rune            // see QuickSelectConfig
+	quickSelectOffset       int               // first line on the screen, see setQuickSelectWindow
+	quickSelectPerPage      int               // lines per page, see setQuickSelectWindow
+	queryDebounce           time.Duration     // 0 to use queryExecDelay instead
+	queryElapsed            time.Duration     // time taken by the last query, 0 while running
+	queryStart              time.Time         // when the last query started, zero if there is none
 	queryExecDelay          time.Duration
 	queryExecMutex          sync.Mutex
 	queryExecTimer          *time.Timer
+	readNull                bool // Split input on NUL instead of newline
 	readyCh                 chan struct{}
+	recordSeparator         string             // see RecordSeparator, empty to read lines
+	recordSummary           *template.Template // nil to summarize records by their first line
+	refinements             []refinement       // pushed by peco.RefineByLine
+	reverse                 bool               // True if the lines are displayed in reverse order
 	resultCh                chan line.Line
+	resetQueryMode          string // see ResetQueryOnFilterChange
+	restorableQuery         string // query cleared by a filter change, see peco.RestoreQuery
 	screen                  Screen
 	selection               *Selection
+	scrollMode              string
 	selectionPrefix         string
+	sortMode                string
+	stableOrder             bool
+	spinner                 *Spinner // nil if the spinner is disabled
 	selectionRangeStart     RangeStart
 	selectOneAndExit        bool // True if --select-1 is enabled
 	singleKeyJumpMode       bool
+	showLineNumbers         bool // True if the positions of the lines in the input are displayed
+	lineWrap                bool // True if the long lines are wrapped, see LineWrap
 	singleKeyJumpPrefixes   []rune
 	singleKeyJumpPrefixMap  map[rune]uint
 	singleKeyJumpShowPrefix bool
 	skipReadConfig          bool
+	sourceAddr              string // socket given to --source, empty to read files or stdin
+	globPattern             string // pattern given to --glob, empty to read files or stdin
+	respectGitignore        bool   // see --respect-gitignore
 	styles                  StyleSet
+	tabWidth                int    // tabs are expanded to stops every tabWidth columns, unless this is 0
+	truncateSide            string // see TruncateSide
+	wholeWord               bool   // True if queries only match entire words
+	wordChars               string // see WordChars
+	ignoreAccents           bool   // True if the diacritics are ignored by the filters
+	fuzzyAnchor             bool   // True if the fuzzy filters are anchored, see FuzzyAnchor
+	fuzzyAnchorSeparators   string // see FuzzyAnchorSeparators
 
 	// Source is where we buffer input. It gets reused when a new query is
 	// executed.
 	source *Source
+	// cancelSource stops reading the input into source, once it is
+	// replaced by reloadSource
+	cancelSource func()
+	// sourceUsers counts the queries running against each source, and
+	// retiredSources holds the sources that were replaced. A retired
+	// source is released once nothing uses its lines anymore, see
+	// releaseRetiredSources
+	sourceUsers    map[*Source]int
+	retiredSources []*Source
+	// signals receives the signals that Input handles, see notifySignals
+	signals        chan os.Signal
+	reloadOnSIGHUP bool // see ReloadOnSIGHUP
+
+	// actionLog is where the actions are logged, nil unless ActionLog
+	// is configured. actionMutex is held while a logged or replayed
+	// action runs, and actionsStopped is set under it once peco exits
+	actionLog        io.Writer
+	actionLogFile    string // see ActionLog
+	actionMutex      sync.Mutex
+	actionsStopped   bool
+	replayFile       string // see --replay
+	replayMismatches int    // actions replayed that did not lead to the state logged
 
 	// cancelFunc is called for Exit()
 	cancelFunc func()
@@ -123,6 +368,7 @@ type Keyseq interface {
 	AcceptKey(keyseq.Key) (interface{}, error)
 	CancelChain()
 	Clear()
+	ExpireChain() (interface{}, error)
 	Compile() error
 	InMiddleOfChain() bool
 }
@@ -136,12 +382,37 @@ type PagingRequest interface {
 
 type JumpToLineRequest int
 
+// JumpToScreenLineRequest moves the selection to the line that is
+// displayed on the given row of the screen. It is used for mouse clicks
+type JumpToScreenLineRequest int
+
+// replaceSourceRequest replaces the input with src, and moves the
+// selection to the first line. It is sent by peco.ExecuteCommand, so
+// that the view applies it rather than the goroutine running the
+// command. applied is closed once it is done
+type replaceSourceRequest struct {
+	src     *Source
+	cancel  func() // stops reading the input into src, if not nil
+	applied chan struct{}
+}
+
+// initialIndexRequest moves the selection to the given line of the
+// results. It is sent by checkInitialResult for --initial-index, so
+// that the view moves the cursor rather than the filter goroutine
+type initialIndexRequest int
+
 // Selection stores the line ids that were selected by the user.
 // The contents of the Selection is always sorted from smallest to
 // largest line ID
 type Selection struct {
-	mutex sync.Mutex
-	tree  *btree.BTree
+	mutex       sync.Mutex
+	tree        *btree.BTree
+	added       map[uint64]uint64 // order in which the lines were added, by ID
+	seq         uint64
+	max         int // see SetLimit
+	evictOldest bool
+	disabled    bool                // see SetDisabled
+	pinned      map[uint64]struct{} // IDs of the lines kept when the query changes, see Pin
 }
 
 // Screen hides termbox from the consuming code so that
@@ -156,14 +427,82 @@ type Screen interface {
 	SetCell(int, int, rune, termbox.Attribute, termbox.Attribute)
 	Size() (int, int)
 	SendEvent(termbox.Event)
+	SetMouse(bool)
 	Suspend()
 }
 
 // Termbox just hands out the processing to the termbox library
 type Termbox struct {
-	mutex     sync.Mutex
-	resumeCh  chan chan struct{}
-	suspendCh chan struct{}
+	initialized bool
+	mouse       bool // true if mouse events should be reported
+	mutex       sync.Mutex
+	resumeCh    chan chan struct{}
+	suspendCh   chan struct{}
+}
+
+// headlessScreen is the Screen that --replay runs on. Nothing is
+// drawn, and no events are received from the terminal
+type headlessScreen struct {
+	mutex  sync.Mutex
+	width  int
+	height int
+}
+
+// actionLogEntry is a line of the ActionLog. Size is the size of the
+// screen when the action was executed, and State the state it led to
+type actionLogEntry struct {
+	Time   time.Time      `json:"time"`
+	Key    string         `json:"key"`
+	Action string         `json:"action,omitempty"` // empty if the key is not bound by itself
+	Expire bool           `json:"expire,omitempty"` // true if a key sequence was not completed in time
+	Event  actionLogEvent `json:"event"`
+	Size   [2]int         `json:"size"`
+	State  actionLogState `json:"state"`
+}
+
+// actionLogEvent is the key event that an action was executed with
+type actionLogEvent struct {
+	Key termbox.Key      `json:"key"`
+	Ch  rune             `json:"ch"`
+	Mod termbox.Modifier `json:"mod"`
+}
+
+// actionLogState summarizes the state of peco after an action
+type actionLogState struct {
+	Query    string `json:"query"`
+	Caret    int    `json:"caret"`
+	Cursor   int    `json:"cursor"`
+	Selected int    `json:"selected"`
+}
+
+// session is what SessionFile holds. Selected holds the indices of the
+// selected lines in the input
+type session struct {
+	Input    string `json:"input"` // see inputHash
+	Query    string `json:"query"`
+	Cursor   int    `json:"cursor"`
+	Selected []int  `json:"selected,omitempty"`
+}
+
+// regionScreen is a Screen that only uses the rows at the bottom of
+// another Screen, as configured by MaxHeight
+type regionScreen struct {
+	Screen
+	height Height
+}
+
+// Height is a number of rows, or a percentage of the height of the
+// terminal such as "40%"
+type Height struct {
+	value   int
+	percent bool
+}
+
+// InitialIndex is the line of the results that the cursor is placed
+// on when peco starts: a 0 based index, or the last line
+type InitialIndex struct {
+	value int
+	last  bool
 }
 
 // View handles the drawing/updating the screen
@@ -177,6 +516,7 @@ type View struct {
 type PageCrop struct {
 	perPage     int
 	currentPage int
+	offset      int // the first line in the range
 }
 
 // LayoutType describes the types of layout that peco can take
@@ -189,6 +529,9 @@ type VerticalAnchor int
 // Layout represents the component that controls where elements are placed on screen
 type Layout interface {
 	PrintStatus(string, time.Duration)
+	ShowStatus(string, time.Duration, MessageStyle)
+	StatusTimer() <-chan time.Time
+	ExpireStatus()
 	DrawPrompt(*Peco)
 	DrawScreen(*Peco, *DrawOptions)
 	MovePage(*Peco, PagingRequest) (moved bool)
@@ -211,10 +554,38 @@ type UserPrompt struct {
 	styles    *StyleSet
 }
 
+// MessageStyle tells how important a status message is, which selects
+// the style it is displayed with, and whether it can replace the
+// message that is displayed
+type MessageStyle int
+
+const (
+	MessageInfo    MessageStyle = iota // displayed with the Basic style
+	MessageWarning                     // displayed with the StatusWarning style
+	MessageError                       // displayed with the StatusError style
+)
+
+// statusMessageQueueSize is the number of status messages that can wait
+// for a more important message to be cleared. The oldest ones are
+// dropped first
+const statusMessageQueueSize = 8
+
+// statusMessage is a message displayed by the StatusBar
+type statusMessage struct {
+	text  string
+	ttl   time.Duration // 0 if the message stays until it is replaced
+	style MessageStyle
+}
+
 // StatusBar draws the status message bar
 type StatusBar struct {
 	*AnchorSettings
 	clearTimer *time.Timer
+	current    statusMessage // message being displayed
+	filterName string        // name of the filter shown on the left side
+	nameMutex  sync.Mutex
+	persistent statusMessage   // message without a ttl, displayed once the timed messages are cleared
+	queue      []statusMessage // timed messages waiting for current to be cleared
 	styles     *StyleSet
 	timerMutex sync.Mutex
 }
@@ -223,10 +594,45 @@ type StatusBar struct {
 // displayed in the screen
 type ListArea struct {
 	*AnchorSettings
-	sortTopDown  bool
-	displayCache []line.Line
-	dirty        bool
-	styles       *StyleSet
+	sortTopDown      bool
+	displayCache     []line.Line
+	dirty            bool
+	quickSelectShown bool  // true if the last Draw showed the labels of peco.QuickSelect
+	gutterWidth      int   // width of the line numbers in the last Draw, see ShowLineNumbers
+	rowsDrawn        []int // rows taken by each line in the last Draw with LineWrap, nil otherwise
+	headerRows       int   // rows taken by the HeaderLines between the prompt and the list
+	styles           *StyleSet
+	highlights       map[line.Line]*lineHighlight // see ListArea.highlight
+	highlightBuf     Buffer                       // buffer that highlights holds the lines of
+	followedLine     line.Line                    // line that the cursor was on when FollowMatch last scrolled
+}
+
+// lineHighlight is what ListArea.Draw prints for a line: its text, once
+// the tabs are expanded and it is truncated, and the matched regions
+// and ANSI colors at their position in that text
+type lineHighlight struct {
+	text    string
+	matches [][]int
+	spans   []ansiSpan
+	width   int // width the text was truncated to, -1 if it was not
+}
+
+// wrapScreen is the Screen that ListArea.Draw prints a line on with
+// LineWrap. The cells past the right edge continue on the next rows,
+// after indent columns. Without an underlying Screen, nothing is
+// printed, and only the rows that the line takes are counted
+type wrapScreen struct {
+	Screen
+	width  int // width of a row
+	indent int // columns left blank at the start of the continued rows
+	y      int // row of the screen the line starts on
+	rows   int // rows available to the line, 0 for no limit
+
+	// The cell that the last character was printed on, at column x
+	// of the line
+	x   int
+	row int
+	col int
 }
 
 // BasicLayout is... the basic layout :) At this point this is the
@@ -235,20 +641,200 @@ type ListArea struct {
 // that are used are set and static
 type BasicLayout struct {
 	*StatusBar
-	prompt *UserPrompt
-	list   *ListArea
+	prompt  *UserPrompt
+	list    *ListArea
+	preview *Preview
+	topRows int // rows above the list area, taken by the prompt and the status bar
+
+	// The buffer and its size when the screen was last drawn. Used
+	// to follow new lines in FollowMode
+	followBuf  Buffer
+	followSize int
+}
+
+// Preview runs a command for the line under the cursor, and keeps
+// the output of the command so it can be displayed next to the list
+type Preview struct {
+	command   string
+	position  string
+	size      int
+	requestCh chan line.Line
+
+	mutex     sync.Mutex
+	cache     map[uint64][]string
+	cached    []uint64  // IDs of the cached lines, oldest first
+	current   line.Line // line being displayed
+	requested line.Line // line last sent to Loop
+	offset    int       // first line of the output being displayed
+	height    int       // number of lines displayed in the last Draw
+	hidden    bool      // true while hidden by peco.TogglePreview
+}
+
+// Annotator runs a command over the input, and remembers the badges
+// that it gives to the lines. The badges are displayed next to the
+// lines
+type Annotator struct {
+	command string
+
+	mutex  sync.Mutex
+	badges map[uint64]string // keyed by line ID
+	width  int               // width of the widest badge
+}
+
+// Spinner keeps track of the query being run, and tells which frame
+// of the spinner to display while it is running
+type Spinner struct {
+	frames   []string
+	position string
+	interval time.Duration
+	delay    time.Duration
+
+	mutex sync.Mutex
+	done  func() <-chan struct{} // Done of the destination of the query, nil if none is running
+	start time.Time
+}
+
+// resultWriter writes the lines that were selected by the user
+type resultWriter interface {
+	// WriteLine writes a line. selected is false if the line was
+	// not selected, but is written because it is the current line
+	WriteLine(l line.Line, selected bool)
+	Flush() error
+}
+
+// textResultWriter writes each line followed by a delimiter. The lines
+// are written in chunks of whole lines, so that a line is never written
+// partially
+type textResultWriter struct {
+	buf          bytes.Buffer
+	delim        string
+	err          error                      // the first error writing to out
+	format       func(io.Writer, line.Line) // nil to write the line as is
+	omitTrailing bool                       // only write delim between the lines
+	out          io.Writer
+	written      bool // true once a line has been written
+}
+
+// jsonResultWriter writes the lines as a JSON array of jsonResults
+type jsonResultWriter struct {
+	format  func(io.Writer, line.Line) // nil to write the line as is
+	indices map[uint64]int
+	origin  func(int) string // nil unless the input is read from several files
+	out     io.Writer
+	results []jsonResult
+}
+
+// resultSource is the pipeline.Source of the lines written by
+// PrintResults
+type resultSource struct {
+	lines  *Selection
+	buffer Buffer // if not nil, the lines of the buffer are sent instead
+}
+
+// resultDestination is the pipeline.Destination that writes the lines
+// it receives with a resultWriter. Lines that are still buffered by the
+// resultWriter when the pipeline is canceled are not written
+type resultDestination struct {
+	done     chan struct{}
+	err      error
+	selected bool // false if the line is written because it is the current line
+	writer   resultWriter
+}
+
+type jsonResult struct {
+	Line     string `json:"line"`
+	Index    int    `json:"index"` // 0 based position of the line in the input
+	Filename string `json:"filename,omitempty"`
+	Selected bool   `json:"selected"`
+}
+
+// promptCount is passed to PromptCountFormat
+type promptCount struct {
+	Filter  string // name of the current filter
+	Matched int    // number of lines matched by the query
+	Total   int    // number of lines in the input
+	Page    int
+	MaxPage int
+	Elapsed string // time taken by the query, empty if there is no query
+	Pinned  int    // number of lines pinned with peco.PinCurrent
+
+	// Approximate is true while LazyFilter has yet to match the rest of
+	// the input, so that Matched is a lower bound
+	Approximate bool
+}
+
+// outputTemplateLine is passed to OutputTemplate for each line
+type outputTemplateLine struct {
+	Filename   string // file the line was read from, "-" for stdin
+	Line       string
+	LineNumber int     // 1 based position of the line in the input
+	Weight     float64 // weight read from the WeightColumn, 0 if there is none
+	Score      int     // score given by a filter that ranks the lines, 0 for the others
+	groups     []string
+}
+
+// confirmation is an action that waits for the user to confirm it. See
+// CustomActionConfig.Confirm
+type confirmation struct {
+	action Action
+	event  termbox.Event // key that the action was invoked with
+	timer  *time.Timer   // cancels the confirmation once ConfirmTimeout is reached
+}
+
+// recordSummary is passed to RecordSummary for each record
+type recordSummary struct {
+	Record string   // the whole record
+	Lines  []string // the lines of the record
+}
+
+// ActionInfo describes an action, as listed by Keymap.Actions
+type ActionInfo struct {
+	Name        string   // such as "peco.SelectUp"
+	Description string   // empty if there is none
+	Keys        []string // the keys bound to the action, such as "C-p"
 }
 
 // Keymap holds all the key sequence to action map
 type Keymap struct {
-	Config map[string]string
-	Action map[string][]string // custom actions
-	seq    Keyseq
+	Config       map[string]string
+	Action       map[string][]string           // custom actions
+	CustomAction map[string]CustomActionConfig // actions built with arguments
+	Conditional  []ConditionalKeyConfig        // bindings that depend on the state of peco
+	seq          Keyseq
+}
+
+// History holds the queries accepted in previous invocations of peco,
+// which are stored in a file
+type History struct {
+	current  int // index of the entry being browsed; len(entries) if not browsing
+	entries  []string
+	filename string
+	limit    int
+	mutex    sync.Mutex
+	pending  string // the query before we started browsing
+	stored   int    // number of entries in the file
 }
 
 // Filter is responsible for the actual "grep" part of peco
 type Filter struct {
+	cache *filterCache
+	mutex sync.Mutex
 	state *Peco
+
+	// The query and the sort mode of the last query that was run
+	lastQuery    string
+	lastSortMode string
+}
+
+// filterCache holds the lines matched by the last completed query, so
+// that they can be fed to the filter instead of the entire source
+// when the query is extended. It implements pipeline.Source
+type filterCache struct {
+	filter   filter.Filter
+	lines    []line.Line
+	query    string
+	sortMode string
+	source   pipeline.Source
 }
 
 // Action describes an action that can be executed upon receiving user
@@ -273,10 +859,22 @@ type FilteredBuffer struct {
 	selection []int // maps from our index to src's index
 }
 
+// reversedBuffer presents the lines of another buffer in reverse order,
+// so that the last line of the source buffer comes first
+type reversedBuffer struct {
+	src Buffer
+}
+
 // Config holds all the data that can be configured in the
 // external configuration file
 type Config struct {
 	Action map[string][]string `json:"Action"`
+
+	// Macros defines named sequences of actions, which are executed in
+	// order as a single action when the name is bound to a key. The
+	// sequences may include other macros, but not themselves
+	Macros map[string][]string `json:"Macros"`
+
 	// Keymap used to be directly responsible for dispatching
 	// events against user input, but since then this has changed
 	// into something that just records the user's config input
@@ -290,22 +888,625 @@ type Config struct {
 	OnCancel            string            `json:"OnCancel"`
 	CustomMatcher       map[string][]string
 	CustomFilter        map[string]CustomFilterConfig
+	ContentFilter       map[string]ContentFilterConfig
+	CustomAction        map[string]CustomActionConfig
 	QueryExecutionDelay int
 	StickySelection     bool
 	MaxScanBufferSize   int
 
+	// PromptPosition and StatusPosition put the prompt and the status
+	// bar at the top or the bottom of the screen, see PositionTop and
+	// PositionBottom, and the list fills the rows in between. By
+	// default, the prompt is where Layout puts it, and the status bar
+	// is at the bottom
+	PromptPosition string `json:"PromptPosition"`
+	StatusPosition string `json:"StatusPosition"`
+
+	// ConditionalKeymap binds keys to actions that are only executed
+	// under some condition, such as when nothing is selected. When none
+	// of the conditions for a key hold, the key does what the Keymap
+	// says
+	ConditionalKeymap []ConditionalKeyConfig `json:"ConditionalKeymap"`
+
+	// FilterChain lists the names of filters that are applied one after
+	// the other, each to the lines matched by the previous one. The
+	// chain is added to the filters that can be chosen, under the names
+	// of its filters separated by ">"
+	FilterChain []string `json:"FilterChain"`
+
+	// FuzzyFilter declares variants of the Fuzzy filters, under the
+	// given names, which can be chosen like any other filter
+	FuzzyFilter map[string]FuzzyFilterConfig `json:"FuzzyFilter"`
+
+	// If MmapFiles is true, the input is mapped into memory instead of
+	// being read, when it is a regular file, so that the text of the
+	// lines does not have to be copied. Other inputs are read as usual
+	MmapFiles bool `json:"MmapFiles"`
+
+	// MaxIngestRate is the maximum number of lines per second that are
+	// added as the input is read, so that a fast stream does not make
+	// peco unusable. 0 adds the lines as fast as they are read
+	MaxIngestRate int `json:"MaxIngestRate"`
+
+	// IngestOverflow selects what happens to the lines that are read
+	// faster than MaxIngestRate. See IngestOverflowBuffer and
+	// IngestOverflowDrop. Defaults to IngestOverflowBuffer
+	IngestOverflow string `json:"IngestOverflow"`
+
+	// SplitPattern is a regular expression that separates the lines of
+	// the input into several lines, each of which is matched, selected
+	// and output on its own. The empty lines it leaves are dropped
+	SplitPattern string `json:"SplitPattern"`
+
+	// RecordSeparator splits the input into records at every occurrence
+	// of this string instead of at newlines, such as "\n\n" for records
+	// separated by blank lines. Each record is displayed on a single row
+	// as its summary, see RecordSummary, while the whole record is
+	// matched against the queries, and output
+	RecordSeparator string `json:"RecordSeparator"`
+
+	// RecordSummary is a template for the text displayed for each record
+	// read with RecordSeparator, such as "{{index .Lines 0}}". Defaults
+	// to the first line of the record
+	RecordSummary string `json:"RecordSummary"`
+
+	// If this is true, queries that extend the previous query only
+	// filter the lines matched by the previous query
+	IncrementalFilter bool `json:"IncrementalFilter"`
+
+	// If this is true, the input is split into records on NUL
+	// characters instead of newlines. Same as --read-null
+	ReadNull bool `json:"ReadNull"`
+
+	// HistorySize is the maximum number of queries kept in the
+	// history file. Set to a negative value to disable the history
+	HistorySize int `json:"HistorySize"`
+
+	// If WholeWord is true, the filters that match the query as is,
+	// such as IgnoreCase and CaseSensitive, only match entire words.
+	// Can be toggled with peco.ToggleWholeWord
+	WholeWord bool `json:"WholeWord"`
+
+	// WordChars are counted as parts of words along with letters and
+	// numbers, when the actions that work on the words of the query
+	// such as peco.ForwardWord look for their boundaries. For example,
+	// "_-" keeps snake_case and kebab-case words whole
+	WordChars string `json:"WordChars"`
+
+	// If IgnoreAccents is true, the built-in filters ignore the
+	// diacritics of the queries and the lines, so that "cafe" matches
+	// "café". Can be toggled with peco.ToggleIgnoreAccents
+	IgnoreAccents bool `json:"IgnoreAccents"`
+
+	// FuzzyAnchor is FuzzyAnchorNone to let the Fuzzy and FuzzyRanked
+	// filters match anywhere in the lines, or FuzzyAnchorPrefix to
+	// make the first character of the query match where the lines
+	// start. Can be toggled with peco.ToggleFuzzyAnchor
+	FuzzyAnchor string `json:"FuzzyAnchor"`
+
+	// FuzzyAnchorSeparators moves the anchor of FuzzyAnchorPrefix right
+	// after the last of these characters in the line, such as "/" to
+	// anchor at the file name of paths
+	FuzzyAnchorSeparators string `json:"FuzzyAnchorSeparators"`
+
+	// FieldDelimiter splits each line into fields, so that query terms
+	// prefixed with "N:" only match against the N-th field
+	FieldDelimiter string `json:"FieldDelimiter"`
+
+	// NumericColumn is the field that the Numeric filter takes the
+	// number of each line from, split by FieldDelimiter or on white
+	// spaces. 0 takes the first number anywhere in the line
+	NumericColumn int `json:"NumericColumn"`
+
+	// If SelectOne is true, peco prints the only line matched by the
+	// initial query and exits. Same as --select-1
+	SelectOne bool `json:"SelectOne"`
+
+	// If ExitZero is true, peco exits with a non-zero status if the
+	// initial query matches nothing. Same as --exit-0
+	ExitZero bool `json:"ExitZero"`
+
+	// EmptyInputBehavior selects what happens when the input turns out
+	// to be empty once it has been read entirely. See EmptyInputExit,
+	// EmptyInputWait and EmptyInputMessage. Defaults to EmptyInputWait
+	EmptyInputBehavior string `json:"EmptyInputBehavior"`
+
+	// EmptyInputPlaceholder is the line drawn when EmptyInputBehavior
+	// is EmptyInputMessage. Defaults to DefaultEmptyInputPlaceholder
+	EmptyInputPlaceholder string `json:"EmptyInputPlaceholder"`
+
+	// NoMatchAccept selects what peco.Finish does when nothing matches
+	// the query, and nothing is selected. See NoMatchNoop,
+	// NoMatchAcceptQuery and NoMatchCancel. Defaults to NoMatchNoop
+	NoMatchAccept string `json:"NoMatchAccept"`
+
+	// HorizontalScrollStep is the number of columns that ScrollLeft
+	// and ScrollRight scroll by. If 0, half the width of the screen
+	// is used
+	HorizontalScrollStep int `json:"HorizontalScrollStep"`
+
+	// FollowMatch scrolls the list horizontally when the cursor moves
+	// to a line whose matched regions would be off the screen
+	FollowMatch bool `json:"FollowMatch"`
+
+	// MaxHeight is the number of rows that peco draws in, at the
+	// bottom of the terminal. Either a number of rows, or a percentage
+	// of the height of the terminal such as "40%". The entire terminal
+	// is used if not set
+	MaxHeight Height `json:"MaxHeight"`
+
+	// InitialIndex is the line of the results that the cursor is
+	// placed on once the initial query has been run, either a 0 based
+	// index or "last". Same as --initial-index
+	InitialIndex InitialIndex `json:"InitialIndex"`
+
+	// If MouseEnable is true, lines can be selected by clicking on
+	// them, and the list can be scrolled with the mouse wheel
+	MouseEnable bool `json:"MouseEnable"`
+
+	// If AnsiColors is true, the lines are displayed with the colors
+	// given by the ANSI escape sequences that they contain. Queries
+	// are still matched against the text without the sequences
+	AnsiColors bool `json:"AnsiColors"`
+
+	// TabWidth expands the tabs of the lines to spaces, up to the next
+	// tab stop, with stops every TabWidth columns from the start of the
+	// line, so that tab separated columns line up. Queries are still
+	// matched against the tabs. If 0, tabs are drawn as up to 4 spaces
+	TabWidth int `json:"TabWidth"`
+
+	// If ShowLineNumbers is true, the position of each line in the
+	// input (1 based) is displayed in a gutter on the left of the
+	// list, using the LineNumber style
+	ShowLineNumbers bool `json:"ShowLineNumbers"`
+
+	// If LineWrap is true, the lines that do not fit in the list area
+	// continue on the next rows, instead of being truncated
+	LineWrap bool `json:"LineWrap"`
+
+	// PromptCountFormat is a text/template that is used to display
+	// the number of matched lines on the right side of the prompt
+	PromptCountFormat string `json:"PromptCountFormat"`
+
+	// If QueryFlags is true, flags such as -i and --regex at the start
+	// of the query change how the rest of the query is matched
+	QueryFlags bool `json:"QueryFlags"`
+
+	// HeaderLines is the number of lines at the start of the input
+	// that are displayed above the list, instead of being matched and
+	// selected, such as the column headers of a table
+	HeaderLines int `json:"HeaderLines"`
+
+	// OutputTemplate is a text/template that is used to print each
+	// selected line, instead of the line itself
+	OutputTemplate string `json:"OutputTemplate"`
+
+	// OutputCaptureGroup is the capture group of the query whose text
+	// is printed instead of each selected line, when the query is
+	// matched as a regular expression. Lines where the group did not
+	// capture anything are printed as is. 0 to print the lines
+	OutputCaptureGroup int `json:"OutputCaptureGroup"`
+
+	// OutputDelimiter is written between the lines that are output,
+	// such as " " to build the arguments of a command. Defaults to a
+	// newline, or NUL with --print0
+	OutputDelimiter string `json:"OutputDelimiter"`
+
+	// If OmitTrailingDelimiter is true, the OutputDelimiter is not
+	// written after the last line that is output
+	OmitTrailingDelimiter bool `json:"OmitTrailingDelimiter"`
+
+	// UniqueOutput drops the results that are the same as a result
+	// output before them, so that each one is only output once
+	UniqueOutput bool `json:"UniqueOutput"`
+
+	// FilterBudgetMs is the time in milliseconds after which the
+	// results of a query that is still running are displayed, even when
+	// they are sorted. The status bar tells that the results are partial
+	// until the query is done. 0 to wait for sorted results to be
+	// complete
+	FilterBudgetMs int `json:"FilterBudgetMs"`
+
+	// RedrawInterval is the time in milliseconds between redraws while
+	// the results of a query come in. However fast the lines are
+	// matched, the screen is only redrawn once per interval, and once
+	// more when the query is done. Defaults to DefaultRedrawInterval
+	RedrawInterval int `json:"RedrawInterval"`
+
+	// MaxResults is the maximum number of lines that a query matches.
+	// Once that many lines are matched, the rest of the input is not
+	// read, and the status bar tells that the results are truncated.
+	// 0 for no limit
+	MaxResults int `json:"MaxResults"`
+
+	// LazyFilter makes queries stop once they have matched the lines
+	// around the scroll position. More of the input is matched as the
+	// list is scrolled, and the number of matches is shown as
+	// approximate until the entire input has been matched
+	LazyFilter bool `json:"LazyFilter"`
+
+	// MaxSelection is the maximum number of lines that can be selected.
+	// Selecting more lines shows a warning instead, unless
+	// SelectionEvictOldest is true, in which case the line that was
+	// selected first is deselected. 0 for no limit
+	MaxSelection         int  `json:"MaxSelection"`
+	SelectionEvictOldest bool `json:"SelectionEvictOldest"`
+
+	// If SingleSelection is true, peco starts in single selection
+	// mode, where lines cannot be selected and the line under the
+	// cursor is the result. Can be toggled with peco.ToggleSelectionMode
+	SingleSelection bool `json:"SingleSelection"`
+
+	// QueryDebounce is the time in milliseconds that the query must
+	// stay unchanged before it is executed. If 0, the query is
+	// executed shortly after the first change
+	QueryDebounce int `json:"QueryDebounce"`
+
+	// IdleTimeout is the number of seconds without any input after
+	// which peco exits as if it was canceled, with a non-zero exit
+	// status. Disabled if 0
+	IdleTimeout int `json:"IdleTimeout"`
+
+	// ReloadOnSIGHUP makes peco read the input files again when it
+	// receives SIGHUP, instead of exiting. The current query is run
+	// against the lines that are read
+	ReloadOnSIGHUP bool `json:"ReloadOnSIGHUP"`
+
+	// MaxBufferLines is the maximum number of lines read from the
+	// input that are kept in memory. Once the limit is reached, the
+	// oldest lines are discarded. Same as --buffer-size, which takes
+	// precedence. No limit if 0
+	MaxBufferLines int `json:"MaxBufferLines"`
+
+	// ScrollMode selects how the list scrolls when the cursor moves
+	// past the edge of the screen. See ScrollModePage and
+	// ScrollModeContinuous. Defaults to ScrollModePage
+	ScrollMode string `json:"ScrollMode"`
+
+	// Sort selects the order in which the matched lines are displayed.
+	// See SortNone, SortLength, SortAlpha, SortWeighted and SortFrecency.
+	// Defaults to SortNone
+	Sort string `json:"Sort"`
+
+	// StableOrder guarantees that the matched lines are displayed and
+	// output in the order they were read, even with filters that rank
+	// them. The lines are only displayed once all of them are matched
+	StableOrder bool `json:"StableOrder"`
+
+	// FrecencyFile is the name of a file that gives how often and how
+	// recently each line was used, which SortFrecency orders the lines
+	// by. Each line of the file is a line of the input, followed by a
+	// tab and its frecency. Lines that are not in the file have a
+	// frecency of 0
+	FrecencyFile string `json:"FrecencyFile"`
+
+	// ResetQueryOnFilterChange selects whether the query is cleared
+	// when the filter is rotated. See ResetQueryNever, ResetQueryAlways
+	// and ResetQueryIncompatible. Defaults to ResetQueryNever
+	ResetQueryOnFilterChange string `json:"ResetQueryOnFilterChange"`
+
+	// KeySequenceTimeout is the number of milliseconds to wait for the
+	// next key in the middle of a key sequence. Once it expires, the
+	// action bound to the keys typed so far is executed, or the
+	// sequence is aborted if there is none. Waits forever if 0
+	KeySequenceTimeout int `json:"KeySequenceTimeout"`
+
+	// ConfirmTimeout is the number of milliseconds to wait for the
+	// answer when a CustomAction asks for confirmation, after which the
+	// action is canceled. Defaults to DefaultConfirmTimeout
+	ConfirmTimeout int `json:"ConfirmTimeout"`
+
+	// If FollowMode is true, the cursor stays on the last line while
+	// new lines are read from the input, like "tail -f"
+	FollowMode bool `json:"FollowMode"`
+
+	// EditorLinePattern is a regular expression that extracts the file
+	// name and the line number to open with peco.OpenInEditor from the
+	// current line. The file name is captured by the group named "path",
+	// and the line number by the optional group named "line". Defaults
+	// to DefaultEditorLinePattern
+	EditorLinePattern string `json:"EditorLinePattern"`
+
+	// GroupPattern is a regular expression that matches the lines that
+	// are headers of groups of lines, which peco.NextGroup and
+	// peco.PrevGroup jump between. If GroupHeadersSkippable is true,
+	// the cursor steps over the headers when it moves, and they cannot
+	// be selected
+	GroupPattern          string `json:"GroupPattern"`
+	GroupHeadersSkippable bool   `json:"GroupHeadersSkippable"`
+
+	// Preview configures the pane that shows the output of a command
+	// for the line under the cursor
+	Preview PreviewConfig `json:"Preview"`
+
+	// Unique drops lines that are the same as a line that came before
+	// them, so that each line is only displayed once
+	Unique bool `json:"Unique"`
+
+	// UniqueAdjacent is like Unique, but only drops lines that are the
+	// same as the line right before them. Unlike Unique, it does not
+	// need to remember all of the lines that it has seen, which saves
+	// memory on large inputs that are already sorted
+	UniqueAdjacent bool `json:"UniqueAdjacent"`
+
+	// Spinner configures the indicator shown in the prompt while a
+	// query takes a while to run
+	Spinner SpinnerConfig `json:"Spinner"`
+
+	// OnCancelCommand is a shell command that is executed when the
+	// user cancels peco, right before it exits. The query is available
+	// in the PECO_QUERY environment variable
+	OnCancelCommand string `json:"OnCancelCommand"`
+
+	// OnFinishCommand is like OnCancelCommand, but is executed when the
+	// user accepts the selected lines with peco.Finish
+	OnFinishCommand string `json:"OnFinishCommand"`
+
+	// If Reverse is true, the matched lines are displayed in reverse
+	// order, so that the most recent lines of the input come first and
+	// the cursor starts on them. Same as --reverse
+	Reverse bool `json:"Reverse"`
+
+	// WriteQueryTo is the name of a file that the query is written to
+	// when the user accepts the selection, so that it can be given back
+	// to --query-from by the next invocation of peco
+	WriteQueryTo string `json:"WriteQueryTo"`
+
+	// SessionFile is the name of a file that the query, the position of
+	// the cursor and the selected lines are saved to as peco exits. They
+	// are restored the next time peco runs against the same input
+	SessionFile string `json:"SessionFile"`
+
+	// ActionLog is the name of a file that each action executed is
+	// logged to, along with the state that it led to, so that the
+	// session can be replayed with --replay
+	ActionLog string `json:"ActionLog"`
+
+	// MatchColumn configures lines that are displayed and matched
+	// using different parts of the line, such as "name\tpath"
+	MatchColumn MatchColumnConfig `json:"MatchColumn"`
+
+	// WeightColumn configures lines that start with a weight, such as
+	// "0.92\ttext", which SortWeighted orders the lines by
+	WeightColumn WeightColumnConfig `json:"WeightColumn"`
+
+	// LineTransform rewrites the input lines before they are matched
+	// and displayed
+	LineTransform LineTransformConfig `json:"LineTransform"`
+
+	// Annotator configures the command that gives the lines badges,
+	// which are displayed on their left
+	Annotator AnnotatorConfig `json:"Annotator"`
+
+	// AutoFilter chooses the initial filter by looking at the initial
+	// query. The first rule that matches wins. Filters given on the
+	// command line take precedence
+	AutoFilter []AutoFilterRule `json:"AutoFilter"`
+
 	// If this is true, then the prefix for single key jump mode
 	// is displayed by default.
 	SingleKeyJump SingleKeyJumpConfig `json:"SingleKeyJump"`
 
+	// QuickSelect configures the labels that peco.QuickSelect shows
+	// next to the visible lines
+	QuickSelect QuickSelectConfig `json:"QuickSelect"`
+
 	// Use this prefix to denote currently selected line
 	SelectionPrefix string `json:"SelectionPrefix"`
+
+	// Ellipsis is displayed in place of the part of the lines that do
+	// not fit on the screen. Defaults to DefaultEllipsis
+	Ellipsis string `json:"Ellipsis"`
+
+	// TruncateSide selects which part of the lines that do not fit on
+	// the screen is replaced by the Ellipsis. See TruncateRight,
+	// TruncateLeft, TruncateMiddle and TruncateNone. Defaults to
+	// TruncateRight
+	TruncateSide string `json:"TruncateSide"`
+
+	// If ProjectConfig is true, the .peco.json file in the current
+	// directory is read after this file, and its values take precedence.
+	// It is off by default because that file comes with the directory,
+	// and can run commands just like this one
+	ProjectConfig bool `json:"ProjectConfig"`
+
+	// warnings are collected by ReadFilename, see Warnings
+	warnings []string
+}
+
+// PreviewConfig is used to specify the command whose output is shown
+// in the preview pane
+type PreviewConfig struct {
+	// Command is executed via the shell for the line under the cursor.
+	// "{}" is replaced with the (quoted) line, which is also passed
+	// through the command's stdin. The preview is disabled if empty
+	Command string `json:"command"`
+
+	// Position is either "right" (default) or "bottom"
+	Position string `json:"position"`
+
+	// Size is the percentage of the screen used by the preview pane.
+	// Defaults to 50
+	Size int `json:"size"`
+}
+
+// AnnotatorConfig is used to specify the command that gives badges to
+// the lines
+type AnnotatorConfig struct {
+	// Command is executed via the shell once the input has been read,
+	// with the lines on its stdin. For each line that gets a badge, it
+	// prints the index of the line (0 based) and the badge, separated
+	// by a tab. The annotator is disabled if empty
+	Command string `json:"command"`
+}
+
+// ConditionalKeyConfig binds a key to an action that is executed when
+// the condition holds at the time the key is typed
+type ConditionalKeyConfig struct {
+	// Key is the key, or key sequence, as in the Keymap
+	Key string `json:"key"`
+
+	// When is the name of the condition: "selection_empty",
+	// "query_empty" or "results_empty"
+	When string `json:"when"`
+
+	// Action is the name of the action to execute
+	Action string `json:"action"`
+}
+
+// AutoFilterRule chooses a filter for the initial queries that it
+// matches. A rule with both When and Pattern matches the queries that
+// satisfy both
+type AutoFilterRule struct {
+	// When is the name of a built-in test for the query: "regexp" for
+	// queries that look like regular expressions, or "uppercase" for
+	// queries that contain upper case letters
+	When string `json:"when"`
+
+	// Pattern is a regular expression that the query must match
+	Pattern string `json:"pattern"`
+
+	// Filter is the name of the filter to use
+	Filter string `json:"filter"`
+}
+
+// MatchColumnConfig is used to specify how lines are split into the
+// text that is displayed, and the text that queries are matched against
+type MatchColumnConfig struct {
+	// Delimiter separates the displayed text from the matched text,
+	// which comes after it. Disabled if empty
+	Delimiter string `json:"delimiter"`
+
+	// Output is either "display" (default), which outputs the displayed
+	// text of the selected lines, or "match", which outputs the matched
+	// text
+	Output string `json:"output"`
+}
+
+// WeightColumnConfig is used to specify how the weight of each line is
+// read
+type WeightColumnConfig struct {
+	// Delimiter separates the weight from the rest of the line, which
+	// is displayed, matched and output without the weight. Disabled if
+	// empty
+	Delimiter string `json:"delimiter"`
+}
+
+// LineTransformConfig is used to specify how the input lines are
+// rewritten before they are matched and displayed
+type LineTransformConfig struct {
+	// Pattern is the regular expression that is replaced in each line.
+	// Disabled if empty
+	Pattern string `json:"pattern"`
+
+	// Replace is the text that the matches of Pattern are replaced
+	// with. $1 and ${name} expand to the submatches, as with
+	// regexp.Regexp.ReplaceAllString
+	Replace string `json:"replace"`
+
+	// Output is either "transformed" (default), which outputs the
+	// rewritten text of the selected lines, or "original", which
+	// outputs the lines as they were read
+	Output string `json:"output"`
+}
+
+// Values of queryFlags.mode and queryFlags.caseMode. The empty string
+// means that the flag was not given
+const (
+	queryFlagRegex         = "regex"
+	queryFlagFuzzy         = "fuzzy"
+	queryFlagIgnoreCase    = "ignorecase"
+	queryFlagCaseSensitive = "casesensitive"
+)
+
+// queryFlags are the flags given at the start of the query with
+// QueryFlags
+type queryFlags struct {
+	given    bool   // true if the query started with any flag
+	mode     string // how the query is matched, queryFlagRegex or queryFlagFuzzy
+	caseMode string // queryFlagIgnoreCase or queryFlagCaseSensitive
+	word     bool   // true if only entire words are matched, see WholeWord
+}
+
+// lineTransformer is the pipeline node that rewrites the lines of the
+// source as configured by LineTransform
+type lineTransformer struct {
+	pattern        *regexp.Regexp
+	replace        string
+	outputOriginal bool
+}
+
+// lazyFilterRun remembers how to match more of the input for the
+// results of a query run with LazyFilter
+type lazyFilterRun struct {
+	buf     *MemoryBuffer
+	ctx     context.Context // canceled once the query changes
+	from    int             // input index to resume matching from
+	nodes   func() []pipeline.Acceptor
+	running bool // true while more of the input is being matched
+	src     *Source
+}
+
+// lazySource is the pipeline.Source that sends the lines that LazyFilter
+// has yet to match
+type lazySource struct {
+	source *Source
+	from   int // input index of the first line to send
+	upto   int // input index after the last line sent
+}
+
+// lazyDestination is the pipeline.Destination that appends the lines
+// matched by LazyFilter to the results of the query
+type lazyDestination struct {
+	buf  *MemoryBuffer
+	done chan struct{}
+}
+
+// SpinnerConfig is used to specify how the spinner is displayed
+type SpinnerConfig struct {
+	// Frames are displayed one after another while the spinner is
+	// shown. Defaults to DefaultSpinnerFrames
+	Frames []string `json:"frames"`
+
+	// Position is either "right" (default), which displays the spinner
+	// next to the line counts, "left", which displays it in place of
+	// the prompt, or "none", which disables the spinner
+	Position string `json:"position"`
+
+	// Interval is the time in milliseconds that each frame is shown.
+	// Defaults to 100
+	Interval int `json:"interval"`
+
+	// Delay is the time in milliseconds that a query must run before
+	// the spinner is shown, so that it does not flicker for queries
+	// that finish quickly. Defaults to 200
+	Delay int `json:"delay"`
 }
 
 type SingleKeyJumpConfig struct {
 	ShowPrefix bool `json:"ShowPrefix"`
 }
 
+// QuickSelectConfig is used to configure peco.QuickSelect
+type QuickSelectConfig struct {
+	// Chars are the characters that the labels are made of, the
+	// easiest to type first. When there are more visible lines than
+	// characters, the labels are two characters long. Defaults to
+	// DefaultQuickSelectChars
+	Chars string `json:"chars"`
+}
+
+// quickSelectState holds the labels shown by peco.QuickSelect. They are
+// only valid for the lines that were visible when it was executed, so
+// they are dropped once the list scrolls or the results change
+type quickSelectState struct {
+	labels  []string // indexed by the position of the line in the page
+	typed   string   // the first character typed of a two character label
+	offset  int
+	linebuf Buffer
+	query   string
+}
+
 // CustomFilterConfig is used to specify configuration parameters
 // to CustomFilters
 type CustomFilterConfig struct {
@@ -323,6 +1524,125 @@ type CustomFilterConfig struct {
 	// more often, but you pay the penalty of invoking that command
 	// more times.
 	BufferThreshold int
+
+	// Retries is the number of times peco tries to start the command
+	// again, if it fails to start because the system is short on
+	// resources such as memory or file descriptors. The delay between
+	// attempts doubles each time. No retries if 0
+	Retries int
+
+	// If Highlight is true, the command prefixes each line with the
+	// regions of the line that matched the query, so that they can be
+	// highlighted. See the README for the format
+	Highlight bool
+
+	// If HighlightFd is true, the command writes the regions of the
+	// lines that matched the query on file descriptor 3 instead, so
+	// that its output can be left as is. See the README for the format.
+	// Not supported on Windows
+	HighlightFd bool
+
+	// Timeout is how long each invocation of the command may run, in
+	// milliseconds. Once it elapses, the command is killed, and the
+	// lines it printed are displayed, unless DiscardOnTimeout is true.
+	// No limit if 0
+	Timeout int
+
+	// If DiscardOnTimeout is true, the lines printed by an invocation
+	// that times out are not displayed
+	DiscardOnTimeout bool
+
+	// InternalFilter is the name of a built-in filter that is used
+	// instead of the command while the input has fewer than
+	// ExecThreshold lines, as starting the command costs more than
+	// matching a few lines in peco
+	InternalFilter string
+
+	// ExecThreshold is the number of lines that the input must have
+	// for the command to be used, when InternalFilter is set. The
+	// size is taken each time the query is run
+	ExecThreshold int
+}
+
+// ContentFilterConfig is used to declare a filter that matches the
+// contents of the files named by the lines, instead of the lines
+type ContentFilterConfig struct {
+	// Cmd is the command that searches a file for the query. "$QUERY"
+	// and "$FILE" in Args are replaced by the query and the name of the
+	// file. The file matches if the command exits successfully, and the
+	// first line that it prints is displayed. Defaults to grep, which
+	// prints the first line of the file that contains the query
+	Cmd string
+
+	// Args are the arguments given to the command. The name of the file
+	// is added at the end unless one of them contains "$FILE"
+	Args []string
+
+	// Concurrency is the number of files that are searched at the same
+	// time. Defaults to filter.DefaultContentsConcurrency
+	Concurrency int
+
+	// Timeout is how long the search of each file may take, in
+	// milliseconds. The files that take longer are not matched, and the
+	// status bar tells that the results are partial. No limit if 0
+	Timeout int
+}
+
+// FuzzyFilterConfig is used to declare a variant of the Fuzzy filter
+type FuzzyFilterConfig struct {
+	// MinContiguous is the number of characters of each term of the
+	// query that must be matched one after the other, so that lines
+	// where the characters are scattered are rejected. Terms shorter
+	// than that must be contained in the line as is. 0 or 1 accepts
+	// any match, like Fuzzy does
+	MinContiguous int
+
+	// If Ranked is true, the lines are ranked by their score, like
+	// FuzzyRanked does
+	Ranked bool
+}
+
+// CustomActionConfig is used to declare an action that is created
+// from one of the actions that take arguments, such as
+// peco.ExecuteCommand
+type CustomActionConfig struct {
+	// Action is the name of the action to create
+	Action string
+
+	// Args is decoded by the action itself
+	Args json.RawMessage
+
+	// If Confirm is true, peco asks for confirmation in the status bar
+	// before executing the action, which is only executed if "y" is
+	// typed. See ConfirmTimeout
+	Confirm bool
+}
+
+// ExecuteCommandArgs are the arguments for peco.ExecuteCommand
+type ExecuteCommandArgs struct {
+	// Cmd is the command line to execute via the shell. The selected
+	// lines are passed through its stdin
+	Cmd string
+
+	// If Replace is true, the output of the command replaces the
+	// input buffer. Otherwise the output is discarded
+	Replace bool
+}
+
+// ExecuteWithSelectionArgs are the arguments for peco.ExecuteWithSelection
+type ExecuteWithSelectionArgs struct {
+	// Cmd is the command line to execute via the shell
+	Cmd string
+
+	// If Args is true, the selected lines are appended to the command
+	// line as arguments. Otherwise they are passed through its stdin
+	Args bool
+}
+
+// SetQueryArgs are the arguments for peco.SetQuery
+type SetQueryArgs struct {
+	// Query replaces the current query
+	Query string `json:"query"`
 }
 
 // StyleSet holds styles for various sections
@@ -332,6 +1652,31 @@ type StyleSet struct {
 	Selected       Style `json:"Selected"`
 	Query          Style `json:"Query"`
 	Matched        Style `json:"Matched"`
+	FilterName     Style `json:"FilterName"`
+
+	// SelectedMatched is used for the matched portions of the line
+	// under the cursor. Matched is used if this is not set
+	SelectedMatched Style `json:"SelectedMatched"`
+
+	// PromptNoMatch is used for the prompt once the query has finished
+	// running without matching any lines. Basic is used if this is not
+	// set
+	PromptNoMatch Style `json:"PromptNoMatch"`
+
+	// LineNumber is used for the line numbers displayed with
+	// ShowLineNumbers. The style of each line is used if this is not
+	// set
+	LineNumber Style `json:"LineNumber"`
+
+	// Header is used for the lines displayed above the list with
+	// HeaderLines. Basic is used if this is not set
+	Header Style `json:"Header"`
+
+	// StatusWarning and StatusError are used for the status messages
+	// that are warnings and errors. The status message is displayed in
+	// the reverse of Basic if they are not set
+	StatusWarning Style `json:"StatusWarning"`
+	StatusError   Style `json:"StatusError"`
 }
 
 // Style describes termbox styles
@@ -340,12 +1685,40 @@ type Style struct {
 	bg termbox.Attribute
 }
 
+// ansiSpan is a range of the displayed text, with the style given to it
+// by SGR sequences. Colors that are not set are termbox.ColorDefault
+// truncation describes how a line that does not fit on the screen is
+// shortened: the bytes of the line from `from` to `to` are replaced by
+// the ellipsis
+type truncation struct {
+	from     int
+	to       int
+	ellipsis string
+}
+
+// tabExpansion records where the tabs of a line were expanded to
+// spaces for TabWidth. pos[i] is the position in the expanded line of
+// the byte at i in the line. The zero value leaves the line as is
+type tabExpansion struct {
+	pos []int
+}
+
+type ansiSpan struct {
+	start int
+	end   int
+	fg    termbox.Attribute
+	bg    termbox.Attribute
+}
+
 type Caret struct {
 	mutex sync.Mutex
 	pos   int
 }
 
+// Location is where the cursor and the list are. It is updated by the
+// view, but read from the other goroutines too, hence the mutex
 type Location struct {
+	mutex   sync.Mutex
 	col     int
 	lineno  int
 	maxPage int
@@ -353,6 +1726,11 @@ type Location struct {
 	perPage int
 	offset  int
 	total   int
+
+	// scrolled is true while the list stays where it was scrolled to
+	// by peco.ScrollViewUp and peco.ScrollViewDown, instead of being
+	// paged with the cursor
+	scrolled bool
 }
 
 type Query struct {
@@ -368,15 +1746,64 @@ type Source struct {
 	pipeline.ChanOutput
 
 	capacity  int
+	discarded int // number of lines discarded because of capacity
 	enableSep bool
+	errs      []error     // errors reading files, which do not stop reading the rest
+	files     []string    // read in order instead of in, if not empty
+	glob      *globWalker // walks the filesystem for the paths to read, if not nil
 	idgen     line.IDGenerator
 	in        io.Reader
+	headers   []line.Line // the first lines read, with HeaderLines
 	lines     []line.Line
+	listener  net.Listener // accepts the connection to read from, if not nil
+	mapped    [][]byte     // files mapped into memory by mapRecords, see release
 	name      string
 	mutex     sync.RWMutex
+	nonASCII  bool           // a line with non-ASCII characters was read, see ASCII
+	origins   []sourceOrigin // where the lines of each file start
+	progress  chan struct{}  // receives a value when lines are read, see Progress
+	readBytes int64          // bytes read by Setup, including the delimiters
+	readLines int            // lines read by Setup, including those discarded
 	ready     chan struct{}
 	setupDone chan struct{}
 	setupOnce sync.Once
+	updated   chan struct{} // closed when a line is appended, if not nil
+}
+
+// frecencyTable maps the output of the lines to their frecency, as
+// read from FrecencyFile
+type frecencyTable map[string]float64
+
+// globWalker walks the filesystem for the paths that match a pattern
+// of --glob. See NewGlobSource
+type globWalker struct {
+	root      string   // directory that the walk starts at
+	elems     []string // elements of the pattern below root
+	gitignore bool     // skip the files ignored by .gitignore
+}
+
+// gitignoreRule is a line of a .gitignore file. base is the directory
+// of the file, relative to the root of the walk
+type gitignoreRule struct {
+	base     string
+	elems    []string
+	anchored bool // matched against the path instead of the name
+	dirOnly  bool // only matches directories
+	negated  bool // includes the paths that a previous rule ignored
+}
+
+// sourceLine is a line read by Source, along with the index of the
+// file it was read from
+type sourceLine struct {
+	text string
+	file int
+}
+
+// sourceOrigin records the file that lines are read from, starting
+// from the line at start
+type sourceOrigin struct {
+	filename string
+	start    int
 }
 
 type State interface {
@@ -392,26 +1819,51 @@ type State interface {
 }
 
 type CLIOptions struct {
-	OptHelp            bool   `short:"h" long:"help" description:"show this help message and exit"`
-	OptQuery           string `long:"query" description:"initial value for query"`
-	OptRcfile          string `long:"rcfile" description:"path to the settings file"`
-	OptVersion         bool   `long:"version" description:"print the version and exit"`
-	OptBufferSize      int    `long:"buffer-size" short:"b" description:"number of lines to keep in search buffer"`
-	OptEnableNullSep   bool   `long:"null" description:"expect NUL (\\0) as separator for target/output"`
-	OptInitialIndex    int    `long:"initial-index" description:"position of the initial index of the selection (0 base)"`
-	OptInitialMatcher  string `long:"initial-matcher" description:"specify the default matcher (deprecated)"`
-	OptInitialFilter   string `long:"initial-filter" description:"specify the default filter"`
-	OptPrompt          string `long:"prompt" description:"specify the prompt string"`
-	OptLayout          string `long:"layout" description:"layout to be used. 'top-down' or 'bottom-up'. default is 'top-down'"`
-	OptSelect1         bool   `long:"select-1" description:"select first item and immediately exit if the input contains only 1 item"`
-	OptOnCancel        string `long:"on-cancel" description:"specify action on user cancel. 'success' or 'error'.\ndefault is 'success'. This may change in future versions"`
-	OptSelectionPrefix string `long:"selection-prefix" description:"use a prefix instead of changing line color to indicate currently selected lines.\ndefault is to use colors. This option is experimental"`
-	OptExec            string `long:"exec" description:"execute command instead of finishing/terminating peco.\nPlease note that this command will receive selected line(s) from stdin,\nand will be executed via '/bin/sh -c' or 'cmd /c'"`
+	OptHelp            bool     `short:"h" long:"help" description:"show this help message and exit"`
+	OptQuery           []string `long:"query" description:"initial value for query. If specified more than once,\nlines matching any of the queries are displayed"`
+	OptQueryFrom       string   `long:"query-from" description:"read the initial value for query from the first line of a file"`
+	OptRcfile          string   `long:"rcfile" description:"path to the settings file"`
+	OptVersion         bool     `long:"version" description:"print the version and exit"`
+	OptBufferSize      int      `long:"buffer-size" short:"b" description:"number of lines to keep in search buffer"`
+	OptEnableNullSep   bool     `long:"null" description:"expect NUL (\\0) as separator for target/output"`
+	OptReadNull        bool     `long:"read-null" description:"read NUL (\\0) terminated records instead of lines"`
+	OptPrint0          bool     `long:"print0" description:"terminate each line of the output with NUL (\\0) instead of newline"`
+	OptEmitTo          string   `long:"emit-to" description:"file or FIFO that peco.EmitAndContinue writes the selected lines to, such as /dev/fd/3"`
+	OptOutput          string   `long:"output" description:"format of the output. 'text' or 'json'. default is 'text'"`
+	OptCaptureGroup    int      `long:"output-capture-group" description:"print the text captured by this group of the regular expression query,\ninstead of the selected lines"`
+	OptInitialIndex    string   `long:"initial-index" description:"position of the initial index of the selection (0 base), or 'last'"`
+	OptInitialMatcher  string   `long:"initial-matcher" description:"specify the default matcher (deprecated)"`
+	OptInitialFilter   string   `long:"initial-filter" description:"specify the default filter"`
+	OptPrompt          string   `long:"prompt" description:"specify the prompt string"`
+	OptLayout          string   `long:"layout" description:"layout to be used. 'top-down' or 'bottom-up'. default is 'top-down'"`
+	OptSelect1         bool     `long:"select-1" description:"select first item and immediately exit if the input contains only 1 item"`
+	OptExit0           bool     `long:"exit-0" description:"exit with a non-zero status and no output if the input contains no items"`
+	OptReverse         bool     `long:"reverse" description:"display the lines in reverse order, so that the last line read comes first"`
+	OptOnCancel        string   `long:"on-cancel" description:"specify action on user cancel. 'success' or 'error'.\ndefault is 'error'"`
+	OptSelectionPrefix string   `long:"selection-prefix" description:"use a prefix instead of changing line color to indicate currently selected lines.\ndefault is to use colors. This option is experimental"`
+	OptGlob            string   `long:"glob" description:"read the paths of the files that match the pattern, such as '**/*.go',\ninstead of files or stdin"`
+	OptGitignore       bool     `long:"respect-gitignore" description:"skip the files ignored by .gitignore with --glob"`
+	OptSource          string   `long:"source" description:"read the input from the first connection to a socket, given as unix:///path/to/socket or tcp://host:port"`
+	OptExec            string   `long:"exec" description:"execute command instead of finishing/terminating peco.\nPlease note that this command will receive selected line(s) from stdin,\nand will be executed via '/bin/sh -c' or 'cmd /c'"`
+	OptValidateConfig  string   `long:"validate-config" description:"check the given config file, print the problems found and exit"`
+	OptBenchmark       string   `long:"benchmark" description:"run the query against the lines of the given file without the screen,\nprint the timings to stderr and exit"`
+	OptBenchmarkIter   int      `long:"benchmark-iterations" description:"number of times --benchmark runs the query. default is 5"`
+	OptCount           bool     `long:"count" description:"print the number of lines matching the query and exit without the screen.\nexits with a non-zero status if no lines matched"`
+	OptReplay          string   `long:"replay" description:"execute the actions logged by ActionLog in the given file without the screen,\nreport the actions that led to another state and print the results"`
+	OptPrintConfig     bool     `long:"print-effective-config" description:"print the config that results from the config files as JSON and exit"`
 }
 
 type CLI struct {
 }
 
+// ConfigReport lists the problems that ValidateConfigFile found in a
+// config file
+type ConfigReport struct {
+	Filename string
+	Errors   []string // values that prevent peco from starting up
+	Warnings []string // keys that peco does not know about
+}
+
 type RangeStart struct {
 	val   int
 	valid bool
@@ -427,10 +1879,32 @@ type Buffer interface {
 
 // MemoryBuffer is an implementation of Buffer
 type MemoryBuffer struct {
+	capacity     int  // maximum number of lines kept, 0 for no limit
+	changed      bool // true if the lines changed since takeChanged was called
 	done         chan struct{}
 	lines        []line.Line
 	mutex        sync.RWMutex
+	partial      bool // true while the lines are only those received before Yield was called
 	PeriodicFunc func()
+	ranked       rankedLines   // lines ranked by the filter, which are displayed once rankLines orders them
+	rankPending  bool          // true if ranked has lines that rankLines has not ordered yet
+	source       *Source       // source that the lines were matched from, if any
+	frecency     frecencyTable // frecency of the lines for SortFrecency
+	sortMode     string        // lines are sorted once all of them are received, unless this is SortNone
+	stableOrder  bool          // lines are ordered as they were read once all of them are received
+	yieldCh      chan struct{}
+}
+
+// rankedLines collects the lines received by a MemoryBuffer. The lines
+// are appended as they arrive, and the oldest lines are discarded past
+// the capacity. Once a line ranked by the filter is added, the lines
+// are only ordered by their scores when sorted is called, and past the
+// capacity they are kept in a heap, so that the lowest ranked line is
+// the one discarded
+type rankedLines struct {
+	capacity int
+	lines    []line.Line
+	ranked   bool // true once a line.Scored was added
 }
 
 type ActionMap interface {
@@ -438,11 +1912,13 @@ type ActionMap interface {
 }
 
 type Input struct {
-	actions ActionMap
-	evsrc   chan termbox.Event
-	mod     *time.Timer
-	mutex   sync.Mutex
-	state   *Peco
+	actions    ActionMap
+	evsrc      chan termbox.Event
+	lastClick  time.Time // when the left mouse button was last clicked
+	lastClickY int       // the screen row of the last click
+	mod        *time.Timer
+	mutex      sync.Mutex
+	state      *Peco
 }
 
 // MessageHub is the interface that must be satisfied by the
@@ -459,10 +1935,13 @@ type MessageHub interface {
 	SendQuery(string)
 	SendStatusMsg(string)
 	SendStatusMsgAndClear(string, time.Duration)
+	SendStatusMsgLevel(string, time.Duration, int)
 	StatusMsgCh() chan hub.Payload
 }
 
 type filterProcessor struct {
-	filter filter.Filter
-	query  string
+	filter   filter.Filter
+	query    string
+	mutex    sync.Mutex
+	timedOut error // the last *filter.TimeoutError returned by the filter
 }
@@ -0,0 +1,37 @@
+// Package clipboard writes text to the system clipboard, using whichever
+// clipboard command is available on the platform
+package clipboard
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotAvailable is returned when none of the supported clipboard
+// commands could be found
+var ErrNotAvailable = errors.New("no clipboard command found")
+
+type command struct {
+	name string
+	args []string
+}
+
+// Write copies s to the system clipboard
+func Write(s string) error {
+	for _, c := range commands() {
+		path, err := exec.LookPath(c.name)
+		if err != nil {
+			continue
+		}
+
+		cmd := exec.Command(path, c.args...)
+		cmd.Stdin = strings.NewReader(s)
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "failed to execute '%s'", c.name)
+		}
+		return nil
+	}
+	return ErrNotAvailable
+}
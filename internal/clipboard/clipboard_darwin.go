@@ -0,0 +1,7 @@
+package clipboard
+
+func commands() []command {
+	return []command{
+		{name: "pbcopy"},
+	}
+}
@@ -0,0 +1,18 @@
+// +build !darwin,!windows
+
+package clipboard
+
+import "os"
+
+func commands() []command {
+	var list []command
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		list = append(list, command{name: "wl-copy"})
+	}
+	return append(list,
+		command{name: "xclip", args: []string{"-selection", "clipboard"}},
+		command{name: "xsel", args: []string{"--clipboard", "--input"}},
+		// Windows' clipboard is reachable from WSL
+		command{name: "clip.exe"},
+	)
+}
@@ -168,10 +168,13 @@ func ToKey(key string) (k termbox.Key, modifier ModifierKey, ch rune, err error)
 	k, ok = stringToKey[key]
 	if !ok {
 		// If this is a single rune, just allow it
-		ch, _ = utf8.DecodeRuneInString(key)
-		if ch != utf8.RuneError {
-			return
+		if utf8.RuneCountInString(key) == 1 {
+			ch, _ = utf8.DecodeRuneInString(key)
+			if ch != utf8.RuneError {
+				return
+			}
 		}
+		ch = 0
 
 		err = errors.Errorf("no such key %s", key)
 	}
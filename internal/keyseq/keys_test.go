@@ -154,3 +154,11 @@ func TestKeymapStrToKeyValueCh(t *testing.T) {
 	}
 
 }
+
+func TestKeymapStrToKeyValueUnknown(t *testing.T) {
+	for _, n := range []string{"C-nosuchkey", "Foo", "M-Foo", ""} {
+		if _, _, _, err := ToKey(n); err == nil {
+			t.Errorf("Expected key name '%s' to be rejected", n)
+		}
+	}
+}
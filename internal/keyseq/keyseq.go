@@ -125,6 +125,8 @@ func New() *Keyseq {
 }
 
 func (k *Keyseq) InMiddleOfChain() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
 	return k.current != nil && k.current != k.Matcher
 }
 
@@ -135,6 +137,27 @@ func (k *Keyseq) CancelChain() {
 	k.setCurrent(k.Matcher)
 }
 
+// ExpireChain is called when no more keys arrived in the middle of a
+// key sequence. The chain is canceled, and the value associated with
+// the keys typed so far is returned. If those keys are only a prefix of
+// longer sequences, ErrNoMatch is returned
+func (k *Keyseq) ExpireChain() (interface{}, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	n, ok := k.Current().(Node)
+	k.setCurrent(k.Matcher)
+	if !ok {
+		return nil, ErrNoMatch
+	}
+
+	data, _ := n.Value().(*nodeData)
+	if data == nil || data.pattern == nil {
+		return nil, ErrNoMatch
+	}
+	return data.Value(), nil
+}
+
 func (k *Keyseq) setCurrent(m keyseqMatcher) {
 	k.current = m
 }
@@ -166,8 +189,8 @@ func (k *Keyseq) AcceptKey(key Key) (interface{}, error) {
 
 	// Matched node has children. It MAY BE a part of a key sequence,
 	// but the longest one ALWAYS wins. So for example, if you had
-	// "C-x,C-n" and "C-x" mapped to something, "C-x" alone will never
-	// fire any action
+	// "C-x,C-n" and "C-x" mapped to something, "C-x" alone will not
+	// fire any action until the chain is expired by ExpireChain
 	if n.HasChildren() {
 		// Set the current matcher to the matched node, so the next
 		// AcceptKey matches AFTER the current node
@@ -0,0 +1,51 @@
+package keyseq
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestExpireChain(t *testing.T) {
+	ctrlX := NewKeyFromKey(termbox.KeyCtrlX)
+	ctrlC := NewKeyFromKey(termbox.KeyCtrlC)
+	ctrlS := NewKeyFromKey(termbox.KeyCtrlS)
+
+	k := New()
+	k.Add(KeyList{ctrlX}, "prefix")
+	k.Add(KeyList{ctrlX, ctrlC}, "cancel")
+	k.Add(KeyList{ctrlS, ctrlC}, "save")
+	if err := k.Compile(); err != nil {
+		t.Fatalf("Compile failed: %s", err)
+	}
+
+	// The longest sequence wins while keys are being typed
+	if _, err := k.AcceptKey(ctrlX); err != ErrInSequence {
+		t.Fatalf("AcceptKey(C-x) expected ErrInSequence, got %v", err)
+	}
+	if v, err := k.AcceptKey(ctrlC); err != nil || v != "cancel" {
+		t.Errorf("AcceptKey(C-c) expected cancel, got %v (%v)", v, err)
+	}
+
+	// If no more keys arrive, the prefix fires its own action
+	k.AcceptKey(ctrlX)
+	if v, err := k.ExpireChain(); err != nil || v != "prefix" {
+		t.Errorf("ExpireChain expected prefix, got %v (%v)", v, err)
+	}
+	if k.InMiddleOfChain() {
+		t.Errorf("ExpireChain should cancel the chain")
+	}
+
+	// Prefixes that are not bound to anything simply abort
+	k.AcceptKey(ctrlS)
+	if _, err := k.ExpireChain(); err != ErrNoMatch {
+		t.Errorf("ExpireChain expected ErrNoMatch, got %v", err)
+	}
+	if v, err := k.AcceptKey(ctrlC); err != ErrNoMatch {
+		t.Errorf("AcceptKey(C-c) after expiry expected ErrNoMatch, got %v (%v)", v, err)
+	}
+
+	if _, err := k.ExpireChain(); err != ErrNoMatch {
+		t.Errorf("ExpireChain outside of a chain expected ErrNoMatch, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Mmap maps the first size bytes of f into memory for reading. The
+// mapping stays valid after f is closed
+func Mmap(f *os.File, size int) ([]byte, error) {
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map %s into memory", f.Name())
+	}
+	return data, nil
+}
+
+// Munmap releases a mapping created by Mmap. Nothing may refer to the
+// mapped memory anymore
+func Munmap(data []byte) error {
+	return errors.Wrap(syscall.Munmap(data), "failed to unmap memory")
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package util
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Mmap is not supported on Windows, so the files are read instead
+func Mmap(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+// Munmap does nothing, as Mmap never maps anything on Windows
+func Munmap(data []byte) error {
+	return nil
+}
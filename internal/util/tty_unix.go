@@ -0,0 +1,20 @@
+// +build !windows
+
+package util
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// OpenTty opens the terminal, so that commands that interact with the
+// user can be run even if the standard input and output of peco are
+// redirected. The caller must close the returned files
+func OpenTty() (*os.File, *os.File, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open /dev/tty")
+	}
+	return tty, tty, nil
+}
@@ -64,3 +64,19 @@ func TtyTerm() {
 	syscall.Stdin = syscall.Handle(os.Stdin.Fd())
 	setStdHandle(syscall.STD_INPUT_HANDLE, syscall.Stdin)
 }
+
+// OpenTty opens the console, so that commands that interact with the
+// user can be run even if the standard input and output of peco are
+// redirected. The caller must close the returned files
+func OpenTty() (*os.File, *os.File, error) {
+	in, err := os.Open("CONIN$")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open CONIN$")
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, errors.Wrap(err, "failed to open CONOUT$")
+	}
+	return in, out, nil
+}
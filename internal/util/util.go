@@ -2,7 +2,9 @@ package util
 
 import (
 	"regexp"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type fder interface {
@@ -25,14 +27,34 @@ func ContainsUpper(query string) bool {
 	return false
 }
 
+// IsASCII returns true if s only consists of ASCII characters
+func IsASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
 // Global var used to strips ansi sequences
-var reANSIEscapeChars = regexp.MustCompile("\x1B\\[(?:[0-9]{1,2}(?:;[0-9]{1,2})?)*[a-zA-Z]")
+var reANSIEscapeChars = regexp.MustCompile("\x1B\\[[0-9;]*[a-zA-Z]")
 
-// Function who strips ansi sequences
+// Function who strips ansi sequences. s is returned as is if it does
+// not contain any, without going through the regexp
 func StripANSISequence(s string) string {
+	if strings.IndexByte(s, '\x1B') == -1 {
+		return s
+	}
 	return reANSIEscapeChars.ReplaceAllString(s, "")
 }
 
+// ANSISequenceIndices returns the location of each of the ansi
+// sequences that StripANSISequence strips from s
+func ANSISequenceIndices(s string) [][]int {
+	return reANSIEscapeChars.FindAllStringIndex(s, -1)
+}
+
 type causer interface {
 	Cause() error
 }
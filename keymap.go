@@ -13,11 +13,12 @@ import (
 )
 
 // NewKeymap creates a new Keymap struct
-func NewKeymap(config map[string]string, actions map[string][]string) Keymap {
+func NewKeymap(config map[string]string, actions map[string][]string, customActions map[string]CustomActionConfig) Keymap {
 	return Keymap{
-		Config: config,
-		Action: actions,
-		seq:    keyseq.New(),
+		Config:       config,
+		Action:       actions,
+		CustomAction: customActions,
+		seq:          keyseq.New(),
 	}
 }
 
@@ -33,29 +34,52 @@ func (km Keymap) ExecuteAction(ctx context.Context, state *Peco, ev termbox.Even
 		defer g.End()
 	}
 
+	ctx = context.WithValue(ctx, isTopLevelActionCall, true)
+
+	// The key typed while an action waits for confirmation answers it,
+	// instead of doing what it is bound to
+	if c := state.pendingConfirmation(); c != nil {
+		answerConfirmation(ctx, state, c, ev)
+		return nil
+	}
+
 	a := km.LookupAction(ev)
 	if a == nil {
 		return errors.New("action not found")
 	}
 
-	ctx = context.WithValue(ctx, isTopLevelActionCall, true)
 	a.Execute(ctx, state, ev)
 	return nil
 }
 
-// LookupAction returns the appropriate action for the given termbox event
-func (km Keymap) LookupAction(ev termbox.Event) Action {
-	modifier := keyseq.ModNone
-	if (ev.Mod & termbox.ModAlt) != 0 {
-		modifier = keyseq.ModAlt
+// ExpireSequence is called when no more keys were typed in the middle
+// of a key sequence. The action bound to the keys typed so far is
+// executed with the last key event, if there is one. Otherwise the
+// sequence is aborted
+func (km Keymap) ExpireSequence(ctx context.Context, state *Peco, ev termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Keymap.ExpireSequence")
+		defer g.End()
 	}
 
-	key := keyseq.Key{
-		Modifier: modifier,
-		Key:      ev.Key,
-		Ch:       ev.Ch,
+	seq := state.Inputseq()
+	msg := strings.Join(seq.KeyNames(), " ")
+	seq.Reset()
+
+	action, err := km.seq.ExpireChain()
+	if err != nil {
+		state.Hub().SendStatusMsgAndClear(msg+" is undefined", 500*time.Millisecond)
+		return
 	}
-	action, err := km.seq.AcceptKey(key)
+
+	state.Hub().SendStatusMsgAndClear(msg, 500*time.Millisecond)
+	ctx = context.WithValue(ctx, isTopLevelActionCall, true)
+	wrapRecordAction(action.(Action)).Execute(ctx, state, ev)
+}
+
+// LookupAction returns the appropriate action for the given termbox event
+func (km Keymap) LookupAction(ev termbox.Event) Action {
+	action, err := km.seq.AcceptKey(eventKey(ev))
 
 	switch err {
 	case nil:
@@ -63,7 +87,7 @@ func (km Keymap) LookupAction(ev termbox.Event) Action {
 		if pdebug.Enabled {
 			pdebug.Printf("Keymap.Handler: Fetched action")
 		}
-		return wrapClearSequence(action.(Action))
+		return wrapClearSequence(wrapRecordAction(action.(Action)))
 	case keyseq.ErrInSequence:
 		if pdebug.Enabled {
 			pdebug.Printf("Keymap.Handler: Waiting for more commands...")
@@ -134,9 +158,38 @@ func (km Keymap) resolveActionName(name string, depth int) (Action, error) {
 		return v, nil
 	}
 
+	// Can it be resolved via actions that take arguments?
+	if c, ok := km.CustomAction[name]; ok {
+		factory, ok := actionFactories[c.Action]
+		if !ok {
+			return nil, errors.Errorf("could not resolve %s: %s does not take arguments", name, c.Action)
+		}
+
+		v, err := factory(c.Args)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not resolve %s: failed to create %s", name, c.Action)
+		}
+		if c.Confirm {
+			v = makeConfirmedAction(confirmPrompt(name, c), v)
+		}
+		nameToActions[name] = v
+		return v, nil
+	}
+
 	return nil, errors.Errorf("could not resolve %s: no such action", name)
 }
 
+// canonicalKeyName returns the name that keyseq gives to the keys
+// named s, as keys have several names, such as "Enter" and "C-m".
+// Names that cannot be parsed are returned as is
+func canonicalKeyName(s string) string {
+	list, err := keyseq.ToKeyList(s)
+	if err != nil {
+		return s
+	}
+	return list.String()
+}
+
 // ApplyKeybinding applies all of the custom key bindings on top of
 // the default key bindings
 func (km *Keymap) ApplyKeybinding() error {
@@ -149,8 +202,16 @@ func (km *Keymap) ApplyKeybinding() error {
 		kb[s] = a
 	}
 
-	// munge the map using config
+	// munge the map using config. A key that is bound in the config
+	// replaces the default binding under any of its names, so that
+	// "Enter" replaces "C-m". "-" removes the binding altogether, and
+	// the key types its character like any key that is not bound
 	for s, as := range km.Config {
+		for d := range defaultKeyBinding {
+			if canonicalKeyName(d) == canonicalKeyName(s) {
+				delete(kb, d)
+			}
+		}
 		if as == "-" {
 			delete(kb, s)
 			continue
@@ -163,6 +224,10 @@ func (km *Keymap) ApplyKeybinding() error {
 		kb[s] = v
 	}
 
+	if err := km.applyConditionalBindings(kb); err != nil {
+		return err
+	}
+
 	// now compile using kb
 	// there's no need to do this, but we sort keys here just to make
 	// debugging easier
@@ -1,9 +1,11 @@
 package peco
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 	"unicode/utf8"
 
@@ -16,11 +18,29 @@ import (
 
 var extraOffset int = 0
 
+// maxHighlights is the number of lines whose highlight ListArea keeps,
+// which is plenty for a few screens of lines to be scrolled through
+const maxHighlights = 4096
+
+var defaultPromptCountTemplate = template.Must(template.New("PromptCountFormat").Parse(DefaultPromptCountFormat))
+
 // IsValidLayoutType checks if a string is a supported layout type
 func IsValidLayoutType(v LayoutType) bool {
 	return v == LayoutTypeTopDown || v == LayoutTypeBottomUp
 }
 
+// IsValidPosition checks if a string is a supported PromptPosition or
+// StatusPosition. The empty string selects the default position
+func IsValidPosition(v string) bool {
+	return v == "" || v == PositionTop || v == PositionBottom
+}
+
+// IsValidScrollMode checks if a string is a supported scroll mode. The
+// empty string selects the default mode
+func IsValidScrollMode(v string) bool {
+	return v == "" || v == ScrollModePage || v == ScrollModeContinuous
+}
+
 // IsValidVerticalAnchor checks if the specified anchor is supported
 func IsValidVerticalAnchor(anchor VerticalAnchor) bool {
 	return anchor == AnchorTop || anchor == AnchorBottom
@@ -89,12 +109,29 @@ func (u UserPrompt) Draw(state *Peco) {
 
 	location := u.AnchorPosition()
 
-	// print "QUERY>"
+	var frame string
+	var spinning bool
+	if s := state.spinner; s != nil {
+		frame, spinning = s.Frame()
+	}
+
+	// print "QUERY>", unless the spinner is displayed in its place
+	prompt := u.prompt
+	if spinning && state.spinner.position == spinnerPositionLeft {
+		prompt = frame
+		if pad := u.promptLen - runewidth.StringWidth(frame); pad > 0 {
+			prompt += strings.Repeat(" ", pad)
+		}
+	}
+	style := u.styles.Basic
+	if noMatch := u.styles.PromptNoMatch; noMatch != (Style{}) && queryMatchedNothing(state) {
+		style = noMatch
+	}
 	u.screen.Print(PrintArgs{
 		Y:   location,
-		Fg:  u.styles.Basic.fg,
-		Bg:  u.styles.Basic.bg,
-		Msg: u.prompt,
+		Fg:  style.fg,
+		Bg:  style.bg,
+		Msg: prompt,
 	})
 
 	c := state.Caret()
@@ -181,8 +218,10 @@ func (u UserPrompt) Draw(state *Peco) {
 
 	width, _ := u.screen.Size()
 
-	loc := state.Location()
-	pmsg := fmt.Sprintf("%s [%d (%d/%d)]", state.Filters().Current().String(), loc.Total(), loc.Page(), loc.MaxPage())
+	pmsg := promptCountMessage(state)
+	if spinning && state.spinner.position == spinnerPositionRight {
+		pmsg = frame + " " + pmsg
+	}
 	u.screen.Print(PrintArgs{
 		X:   int(width - runewidth.StringWidth(pmsg)),
 		Y:   location,
@@ -194,6 +233,50 @@ func (u UserPrompt) Draw(state *Peco) {
 	u.screen.Flush()
 }
 
+// queryMatchedNothing returns true if the query has finished running
+// without matching any lines. While a query is running, its results
+// are still being collected, so it is not considered to match nothing
+// yet
+func queryMatchedNothing(state *Peco) bool {
+	return state.CurrentLineBuffer().Size() == 0 && !state.FilterRunning()
+}
+
+// promptCountMessage renders the PromptCountFormat that is displayed
+// on the right side of the prompt
+func promptCountMessage(state *Peco) string {
+	total, page, maxPage := state.Location().Pages()
+	data := promptCount{
+		Filter:  state.Filters().CurrentName(),
+		Matched: total,
+		Total:   total,
+		Page:    page,
+		MaxPage: maxPage,
+		Pinned:  state.Selection().PinnedLen(),
+
+		Approximate: state.ResultsApproximate(),
+	}
+	if src, ok := state.Source().(*Source); ok && src != nil {
+		data.Total = src.Size()
+	}
+	if d, ok := state.QueryElapsed(); ok {
+		// Duration.Round is not available in Go 1.8
+		data.Elapsed = (d - d%time.Millisecond).String()
+	}
+
+	t := state.promptCountTemplate
+	if t == nil {
+		t = defaultPromptCountTemplate
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to execute PromptCountFormat: %s", err)
+		}
+	}
+	return buf.String()
+}
+
 // NewStatusBar creates a new StatusBar struct
 func NewStatusBar(screen Screen, anchor VerticalAnchor, anchorOffset int, styles *StyleSet) *StatusBar {
 	return &StatusBar{
@@ -203,39 +286,112 @@ func NewStatusBar(screen Screen, anchor VerticalAnchor, anchorOffset int, styles
 	}
 }
 
-func (s *StatusBar) stopTimer() {
+// PrintStatus prints a new status message, which is cleared after
+// clearDelay unless it is 0. See ShowStatus
+func (s *StatusBar) PrintStatus(msg string, clearDelay time.Duration) {
+	s.ShowStatus(msg, clearDelay, MessageInfo)
+}
+
+// ShowStatus displays a status message, and clears it after ttl. A
+// message whose ttl is 0 stays until another message replaces it, and
+// is displayed again once the timed messages that replaced it are
+// cleared. A message does not replace a timed message of a more
+// important style while it is displayed: timed messages are queued
+// instead, and displayed one after another once it is cleared
+func (s *StatusBar) ShowStatus(msg string, ttl time.Duration, style MessageStyle) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("StatusBar.ShowStatus")
+		defer g.End()
+	}
+
+	m := statusMessage{text: msg, ttl: ttl, style: style}
+
+	s.timerMutex.Lock()
+	if cur := s.current; cur.ttl > 0 && cur.style > style {
+		if ttl > 0 {
+			s.queue = append(s.queue, m)
+			if len(s.queue) > statusMessageQueueSize {
+				s.queue = s.queue[1:]
+			}
+		} else {
+			s.persistent = m
+		}
+		s.timerMutex.Unlock()
+		return
+	}
+	if ttl == 0 {
+		// The queued messages are about what happened before the new
+		// state that this message tells about
+		s.persistent = m
+		s.queue = nil
+	}
+	s.setCurrent(m)
+	s.timerMutex.Unlock()
+
+	s.draw(m)
+}
+
+// StatusTimer returns the channel that receives once the timed status
+// message that is displayed is to be cleared, which is then done by
+// ExpireStatus. Returns nil if there is no such message
+func (s *StatusBar) StatusTimer() <-chan time.Time {
 	s.timerMutex.Lock()
 	defer s.timerMutex.Unlock()
 	if t := s.clearTimer; t != nil {
-		t.Stop()
-		s.clearTimer = nil
+		return t.C
 	}
+	return nil
 }
 
-func (s *StatusBar) setClearTimer(t *time.Timer) {
+// ExpireStatus clears the timed status message that is displayed, and
+// displays the next message in the queue instead. Once the queue is
+// empty, the last message without a ttl is displayed
+func (s *StatusBar) ExpireStatus() {
 	s.timerMutex.Lock()
-	defer s.timerMutex.Unlock()
-	s.clearTimer = t
+	next := s.persistent
+	if len(s.queue) > 0 {
+		next = s.queue[0]
+		s.queue = s.queue[1:]
+	}
+	s.setCurrent(next)
+	s.timerMutex.Unlock()
+
+	s.draw(next)
 }
 
-// PrintStatus prints a new status message. This also resets the
-// timer created by ClearStatus()
-func (s *StatusBar) PrintStatus(msg string, clearDelay time.Duration) {
-	if pdebug.Enabled {
-		g := pdebug.Marker("StatusBar.PrintStatus")
-		defer g.End()
+// setCurrent makes m the message being displayed, and starts the timer
+// to clear it. timerMutex must be held
+func (s *StatusBar) setCurrent(m statusMessage) {
+	if t := s.clearTimer; t != nil {
+		t.Stop()
+		s.clearTimer = nil
+	}
+	s.current = m
+	if m.ttl > 0 {
+		s.clearTimer = time.NewTimer(m.ttl)
 	}
+}
 
-	s.stopTimer()
+// currentStatus returns the status message being displayed
+func (s *StatusBar) currentStatus() statusMessage {
+	s.timerMutex.Lock()
+	defer s.timerMutex.Unlock()
+	return s.current
+}
 
+// draw prints m in the status bar
+func (s *StatusBar) draw(m statusMessage) {
+	msg := m.text
 	location := s.AnchorPosition()
 
 	w, _ := s.screen.Size()
 	width := runewidth.StringWidth(msg)
 	for width > w {
-		_, rw := utf8.DecodeRuneInString(msg)
-		width = width - rw
-		msg = msg[rw:]
+		// Drop characters from the left until the message fits,
+		// accounting for the width they take on the screen
+		r, n := utf8.DecodeRuneInString(msg)
+		width = width - runewidth.RuneWidth(r)
+		msg = msg[n:]
 	}
 
 	var pad []byte
@@ -258,24 +414,80 @@ func (s *StatusBar) PrintStatus(msg string, clearDelay time.Duration) {
 		})
 	}
 
+	// The filter name is only drawn if it does not overlap the message
+	if name := s.FilterName(); name != "" && runewidth.StringWidth(name) < w-width {
+		s.screen.Print(PrintArgs{
+			Y:   location,
+			Fg:  s.styles.FilterName.fg,
+			Bg:  s.styles.FilterName.bg,
+			Msg: name,
+		})
+	}
+
 	if width > 0 {
+		msgFg := fgAttr | termbox.AttrReverse | termbox.AttrBold | termbox.AttrReverse
+		msgBg := bgAttr | termbox.AttrReverse
+		if style := s.messageStyle(m.style); style != (Style{}) {
+			msgFg, msgBg = style.fg, style.bg
+		}
 		s.screen.Print(PrintArgs{
 			X:   int(w - width),
 			Y:   location,
-			Fg:  fgAttr | termbox.AttrReverse | termbox.AttrBold | termbox.AttrReverse,
-			Bg:  bgAttr | termbox.AttrReverse,
+			Fg:  msgFg,
+			Bg:  msgBg,
 			Msg: msg,
 		})
 	}
 	s.screen.Flush()
+}
+
+// messageStyle returns the style configured for the messages of the
+// given style, which is empty if they are displayed as usual
+func (s *StatusBar) messageStyle(style MessageStyle) Style {
+	switch style {
+	case MessageWarning:
+		return s.styles.StatusWarning
+	case MessageError:
+		return s.styles.StatusError
+	}
+	return Style{}
+}
+
+// FilterName returns the name of the filter displayed in the status bar
+func (s *StatusBar) FilterName() string {
+	s.nameMutex.Lock()
+	defer s.nameMutex.Unlock()
+	return s.filterName
+}
+
+// SetFilterName changes the name of the filter displayed on the left
+// side of the status bar, and redraws it if it has changed
+func (s *StatusBar) SetFilterName(name string) {
+	s.nameMutex.Lock()
+	prev := s.filterName
+	s.filterName = name
+	s.nameMutex.Unlock()
+
+	if prev == name {
+		return
+	}
 
-	// if everything is successful AND the clearDelay timer is specified,
-	// then set a timer to clear the status
-	if clearDelay != 0 {
-		s.setClearTimer(time.AfterFunc(clearDelay, func() {
-			s.PrintStatus("", 0)
-		}))
+	location := s.AnchorPosition()
+	if pw := runewidth.StringWidth(prev); pw > 0 {
+		s.screen.Print(PrintArgs{
+			Y:   location,
+			Fg:  s.styles.Basic.fg,
+			Bg:  s.styles.Basic.bg,
+			Msg: strings.Repeat(" ", pw),
+		})
 	}
+	s.screen.Print(PrintArgs{
+		Y:   location,
+		Fg:  s.styles.FilterName.fg,
+		Bg:  s.styles.FilterName.bg,
+		Msg: name,
+	})
+	s.screen.Flush()
 }
 
 // NewListArea creates a new ListArea struct
@@ -293,6 +505,49 @@ func (l *ListArea) purgeDisplayCache() {
 	l.displayCache = []line.Line{}
 }
 
+// highlight returns the text that Draw prints for target, with the
+// matched regions and the ANSI colors at their position in it. The
+// tabs are expanded, and the text is truncated to width unless width
+// is negative. Scrolling draws the same lines on other rows, so the
+// result is cached until the buffer changes, or the cache holds
+// maxHighlights lines
+func (l *ListArea) highlight(state *Peco, target line.Line, hidden bool, width int) *lineHighlight {
+	if h, ok := l.highlights[target]; ok && h.width == width {
+		return h
+	}
+
+	var spans []ansiSpan
+	if state.ansiColors {
+		_, spans = parseANSI(line.RawDisplayString(target))
+	}
+	text := target.DisplayString()
+
+	var matches [][]int
+	if ix, ok := target.(MatchIndexer); ok && !hidden {
+		matches = ix.Indices()
+	}
+	if state.tabWidth > 0 {
+		var e tabExpansion
+		text, e = expandTabs(text, state.tabWidth)
+		matches = e.indices(matches)
+		spans = e.ansiSpans(spans)
+	}
+	if width >= 0 {
+		if t, ok := truncateLine(text, width, state.ellipsis, state.truncateSide); ok {
+			text = t.apply(text)
+			matches = t.indices(matches)
+			spans = t.ansiSpans(spans)
+		}
+	}
+
+	h := &lineHighlight{text: text, matches: matches, spans: spans, width: width}
+	if l.highlights == nil || len(l.highlights) >= maxHighlights {
+		l.highlights = make(map[line.Line]*lineHighlight)
+	}
+	l.highlights[target] = h
+	return h
+}
+
 func (l *ListArea) IsDirty() bool {
 	return l.dirty
 }
@@ -308,6 +563,39 @@ func selectionContains(state *Peco, n int) bool {
 	return false
 }
 
+// lineIndexAt returns the index in the current line buffer of the
+// line displayed on the given row of the screen. The second return
+// value is false if there is no line on that row
+func (l *ListArea) lineIndexAt(state *Peco, perPage, y int) (int, bool) {
+	n := y - l.firstRow()
+	if !l.sortTopDown {
+		n = -n
+	}
+	if n < 0 || n >= perPage {
+		return 0, false
+	}
+
+	// Wrapped lines take as many rows as they were drawn on
+	if rows := l.rowsDrawn; rows != nil {
+		var i int
+		for ; i < len(rows) && n >= rows[i]; i++ {
+			n -= rows[i]
+		}
+		if i == len(rows) {
+			return 0, false
+		}
+		n = i
+	}
+
+	// The lines are displayed starting from the offset of the
+	// current page, as calculated by CalculatePage
+	i := state.Location().Offset() + n
+	if i >= state.CurrentLineBuffer().Size() {
+		return 0, false
+	}
+	return i, true
+}
+
 type DrawOptions struct {
 	RunningQuery bool
 	DisableCache bool
@@ -333,7 +621,8 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	// makes sure that we never have an empty screen when we are
 	// at a large enough page, but we don't have enough entries
 	// to fill that many pages in the buffer
-	if options != nil && options.RunningQuery {
+	wrap := state.LineWrap()
+	if options != nil && options.RunningQuery && state.scrollMode != ScrollModeContinuous && !wrap {
 		bufsiz := linebuf.Size()
 		page := loc.Page()
 
@@ -347,6 +636,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 		}
 		if loc.Page() != page {
 			loc.SetPage(page)
+			loc.SetOffset((page - 1) * loc.PerPage())
 			parent.DrawPrompt(state)
 		}
 	}
@@ -364,11 +654,50 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 		loc.SetLineNumber(lbufsiz - 1)
 	}
 
+	// The line numbers are drawn in a gutter of their own, see
+	// lineNumberWidth
+	gutterWidth := lineNumberWidth(state)
+	src, _ := state.Source().(*Source)
+
+	// The highlights only hold for the lines of the buffer they were
+	// computed for, which is replaced by each query
+	if linebuf != l.highlightBuf {
+		l.highlights = nil
+		l.highlightBuf = linebuf
+	}
+
+	// Lines that do not fit in the list area are truncated, unless
+	// they are scrolled horizontally. The preview pane on the right
+	// hides the end of the lines
+	listWidth, _ := l.screen.Size()
+	if bl, ok := parent.(*BasicLayout); ok {
+		listWidth -= bl.previewWidth(listWidth)
+	}
+
+	// The labels of peco.QuickSelect are drawn in a column of their
+	// own. Cached lines may still show labels that are gone, so the
+	// lines are all drawn again while the labels come and go
+	labels, typed := state.QuickSelectLabels()
+	var labelWidth int
+	if len(labels) > 0 {
+		labelWidth = len([]rune(labels[0]))
+	}
+
+	// With FollowMatch, the list scrolls to the matches of the line
+	// that the cursor moves to, which moves all the lines
+	var followed bool
+	if state.followMatch && !wrap {
+		followed = l.followMatch(state, linebuf, listWidth-l.textColumn(state, gutterWidth, labelWidth))
+	}
+
 	// The max column size is calculated by buf. we check against where the
 	// loc variable thinks we should be scrolling to, and make sure that this
-	// falls in range with what we got
+	// falls in range with what we got. Wrapped lines are not scrolled
 	width, _ := state.screen.Size()
-	if max := maxOf(buf.MaxColumn()-width, 0); loc.Column() > max {
+	if max := maxOf(buf.MaxColumn()-width+gutterWidth, 0); loc.Column() > max || wrap {
+		if wrap {
+			max = 0
+		}
 		loc.SetColumn(max)
 	}
 
@@ -383,7 +712,27 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	}
 
 	var y int
-	start := l.AnchorPosition()
+	start := l.firstRow()
+
+	// With LineWrap, each line takes as many rows as it needs, and
+	// those that do not fit in the list area are cut off
+	var rows []int
+	usedRows := bufsiz
+	if wrap {
+		x := l.textColumn(state, gutterWidth, labelWidth)
+		usedRows = 0
+		for n := 0; n < bufsiz && usedRows < perPage; n++ {
+			r := 1
+			if target, err := buf.LineAt(n); err == nil {
+				r = wrappedRows(state.expandDisplayTabs(target.DisplayString()), x, gutterWidth, listWidth)
+			}
+			r = minOf(r, perPage-usedRows)
+			rows = append(rows, r)
+			usedRows += r
+		}
+		bufsiz = len(rows)
+	}
+	l.rowsDrawn = rows
 
 	// If our buffer is smaller than perPage, we may need to
 	// clear some lines
@@ -391,17 +740,29 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 		pdebug.Printf("ListArea.Draw: buffer size is %d, our view area is %d", bufsiz, perPage)
 	}
 
-	for n := bufsiz; n < perPage; n++ {
+	// The placeholder for an empty input takes the place of the first
+	// line, see EmptyInputBehavior
+	var placeholder string
+	if bufsiz == 0 {
+		placeholder = state.EmptyInputPlaceholder()
+	}
+
+	for n := usedRows; n < perPage; n++ {
 		if l.sortTopDown {
 			y = n + start
 		} else {
 			y = start - n
 		}
 
+		var msg string
+		if n == 0 {
+			msg = placeholder
+		}
 		l.screen.Print(PrintArgs{
 			Y:    y,
 			Fg:   l.styles.Basic.fg,
 			Bg:   l.styles.Basic.bg,
+			Msg:  msg,
 			Fill: true,
 		})
 	}
@@ -420,7 +781,24 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 		prefixDefault = strings.Repeat(" ", len+1)
 	}
 
+	// The badges given by the annotator are displayed in a column of
+	// their own, once there are any
+	var badgeWidth int
+	if state.annotator != nil {
+		badgeWidth = state.annotator.Width()
+	}
+
+	// Wrapped lines move up and down as the lines before them change,
+	// so they are all drawn again
+	disableCache := (options != nil && options.DisableCache) || followed || labels != nil || l.quickSelectShown || gutterWidth != l.gutterWidth || wrap
+	l.quickSelectShown = labels != nil
+	l.gutterWidth = gutterWidth
+
+	var row int
 	for n := 0; n < perPage; n++ {
+		// The background colors of the input are only drawn on lines
+		// that are not highlighted, so that the highlight stays visible
+		inputBg := true
 		if len(selectionPrefix) > 0 {
 			switch {
 			case n+loc.Offset() == loc.LineNumber():
@@ -435,23 +813,54 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 			case n+loc.Offset() == loc.LineNumber():
 				fgAttr = l.styles.Selected.fg
 				bgAttr = l.styles.Selected.bg
+				inputBg = false
 			case selectionContains(state, n+loc.Offset()):
 				fgAttr = l.styles.SavedSelection.fg
 				bgAttr = l.styles.SavedSelection.bg
+				inputBg = false
 			default:
 				fgAttr = l.styles.Basic.fg
 				bgAttr = l.styles.Basic.bg
 			}
 		}
 
+		// Matched portions are drawn on the background of the line.
+		// SelectedMatched is meant to be drawn on top of the selection,
+		// so its background color, if any, is used as is
+		matchFg := l.styles.Matched.fg
+		matchBg := mergeAttribute(bgAttr, l.styles.Matched.bg)
+		if sm := l.styles.SelectedMatched; n+loc.Offset() == loc.LineNumber() && sm != (Style{}) {
+			matchFg = sm.fg
+			matchBg = sm.bg
+			if sm.bg&0x0F == 0 {
+				matchBg = mergeAttribute(bgAttr, sm.bg)
+			}
+		}
+
 		if n >= bufsiz {
 			break
 		}
 
+		// Wrapped lines are read from top to bottom, whichever way the
+		// list goes
+		lineRows := 1
+		if wrap {
+			lineRows = rows[n]
+		}
 		if l.sortTopDown {
-			y = n + start
+			y = row + start
 		} else {
-			y = start - n
+			y = start - row - lineRows + 1
+		}
+		row += lineRows
+
+		// Each line is printed on a screen of its own while wrapping,
+		// that continues the line on the next rows
+		var scr Screen = l.screen
+		if wrap {
+			ws := &wrapScreen{Screen: l.screen, width: listWidth, indent: gutterWidth, y: y, rows: lineRows}
+			ws.init()
+			scr = ws
 		}
 
 		target, err := buf.LineAt(n)
@@ -459,7 +868,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 			break
 		}
 
-		if (options != nil && options.DisableCache) || l.IsDirty() || target.IsDirty() {
+		if disableCache || l.IsDirty() || target.IsDirty() {
 			target.SetDirty(false)
 		} else if l.displayCache[n] == target {
 			cached++
@@ -469,12 +878,12 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 		written++
 		l.displayCache[n] = target
 
-		x := -1 * loc.Column()
-		xOffset := loc.Column()
-		line := target.DisplayString()
+		_, hidden := line.Unwrap(target).(line.Matcher)
+		x := gutterWidth - loc.Column()
+		xOffset := loc.Column() - gutterWidth
 
 		if len := len(prefix); len > 0 {
-			l.screen.Print(PrintArgs{
+			scr.Print(PrintArgs{
 				X:       x,
 				Y:       y,
 				XOffset: xOffset,
@@ -484,10 +893,22 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 			})
 			x += len
 		}
+		if badgeWidth > 0 {
+			badge := state.annotator.Badge(target)
+			scr.Print(PrintArgs{
+				X:       x,
+				Y:       y,
+				XOffset: xOffset,
+				Fg:      fgAttr,
+				Bg:      bgAttr,
+				Msg:     badge + strings.Repeat(" ", badgeWidth-runewidth.StringWidth(badge)+1),
+			})
+			x += badgeWidth + 1
+		}
 		if state.SingleKeyJumpMode() || state.SingleKeyJumpShowPrefix() {
 			prefixes := state.SingleKeyJumpPrefixes()
 			if n < int(len(prefixes)) {
-				l.screen.Print(PrintArgs{
+				scr.Print(PrintArgs{
 					X:       x,
 					Y:       y,
 					XOffset: xOffset,
@@ -495,7 +916,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 					Bg:      bgAttr,
 					Msg:     string(prefixes[n]),
 				})
-				l.screen.Print(PrintArgs{
+				scr.Print(PrintArgs{
 					X:       x + 1,
 					Y:       y,
 					XOffset: xOffset,
@@ -504,7 +925,7 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 					Msg:     " ",
 				})
 			} else {
-				l.screen.Print(PrintArgs{
+				scr.Print(PrintArgs{
 					X:       x,
 					Y:       y,
 					XOffset: xOffset,
@@ -516,76 +937,105 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 
 			x += 2
 		}
+		if labels != nil {
+			// Once the first character of a label is typed, only the
+			// labels that start with it are shown, without it
+			var label string
+			if n < len(labels) && strings.HasPrefix(labels[n], typed) {
+				label = labels[n][len(typed):]
+			}
+			scr.Print(PrintArgs{
+				X:       x,
+				Y:       y,
+				XOffset: xOffset,
+				Fg:      fgAttr | termbox.AttrBold | termbox.AttrReverse,
+				Bg:      bgAttr,
+				Msg:     label,
+			})
+			w := runewidth.StringWidth(label)
+			scr.Print(PrintArgs{
+				X:       x + w,
+				Y:       y,
+				XOffset: xOffset,
+				Fg:      fgAttr,
+				Bg:      bgAttr,
+				Msg:     strings.Repeat(" ", labelWidth-w+1),
+			})
+			x += labelWidth + 1
+		}
 
-		ix, ok := target.(MatchIndexer)
-		if !ok {
-			l.screen.Print(PrintArgs{
+		truncateWidth := -1
+		if loc.Column() == 0 && !wrap {
+			truncateWidth = listWidth - x
+		}
+		h := l.highlight(state, target, hidden, truncateWidth)
+		line, matches, spans := h.text, h.matches, h.spans
+
+		// Lines without any matched portions (e.g. when the query
+		// only consists of spaces) are drawn as is. So are lines that
+		// were matched against text that is not displayed
+		if len(matches) == 0 {
+			l.printColored(scr, PrintArgs{
 				X:       x,
 				Y:       y,
 				XOffset: xOffset,
 				Fg:      fgAttr,
 				Bg:      bgAttr,
-				Msg:     line,
 				Fill:    true,
-			})
+			}, line, 0, len(line), spans, inputBg)
+			l.drawLineNumber(src, target, y, gutterWidth, fgAttr, bgAttr)
+			l.clearGutter(y, lineRows, gutterWidth, fgAttr, bgAttr)
 			continue
 		}
 
-		matches := ix.Indices()
 		prev := x
 		index := 0
 
+		// Matched regions are drawn at their position in the line, so
+		// when the line is scrolled horizontally, screenPrint takes care
+		// of clipping the regions that are (partially) off screen. They
+		// are drawn with the Matched style rather than the input colors
 		for _, m := range matches {
+			if m[1] <= index {
+				// Already drawn as part of a previous region
+				continue
+			}
 			if m[0] > index {
-				c := line[index:m[0]]
-				n := l.screen.Print(PrintArgs{
+				n := l.printColored(scr, PrintArgs{
 					X:       prev,
 					Y:       y,
 					XOffset: xOffset,
 					Fg:      fgAttr,
 					Bg:      bgAttr,
-					Msg:     c,
-				})
+				}, line, index, m[0], spans, inputBg)
 				prev += n
-				index += len(c)
+				index = m[0]
 			}
-			c := line[m[0]:m[1]]
 
-			n := l.screen.Print(PrintArgs{
+			n := scr.Print(PrintArgs{
 				X:       prev,
 				Y:       y,
 				XOffset: xOffset,
-				Fg:      l.styles.Matched.fg,
-				Bg:      mergeAttribute(bgAttr, l.styles.Matched.bg),
-				Msg:     c,
-				Fill:    true,
+				Fg:      matchFg,
+				Bg:      matchBg,
+				Msg:     line[index:m[1]],
 			})
 			prev += n
-			index += len(c)
+			index = m[1]
 		}
 
-		m := matches[len(matches)-1]
-		if m[0] > index {
-			l.screen.Print(PrintArgs{
-				X:       prev,
-				Y:       y,
-				XOffset: xOffset,
-				Fg:      l.styles.Query.fg,
-				Bg:      mergeAttribute(bgAttr, l.styles.Query.bg),
-				Msg:     line[m[0]:m[1]],
-				Fill:    true,
-			})
-		} else if len(line) > m[1] {
-			l.screen.Print(PrintArgs{
-				X:       prev,
-				Y:       y,
-				XOffset: xOffset,
-				Fg:      fgAttr,
-				Bg:      bgAttr,
-				Msg:     line[m[1]:len(line)],
-				Fill:    true,
-			})
-		}
+		// Draw the rest of the line, and clear whatever was drawn
+		// after it previously
+		l.printColored(scr, PrintArgs{
+			X:       prev,
+			Y:       y,
+			XOffset: xOffset,
+			Fg:      fgAttr,
+			Bg:      bgAttr,
+			Fill:    true,
+		}, line, index, len(line), spans, inputBg)
+		l.drawLineNumber(src, target, y, gutterWidth, fgAttr, bgAttr)
+		l.clearGutter(y, lineRows, gutterWidth, fgAttr, bgAttr)
 	}
 	l.SetDirty(false)
 	if pdebug.Enabled {
@@ -593,6 +1043,76 @@ func (l *ListArea) Draw(state *Peco, parent Layout, perPage int, options *DrawOp
 	}
 }
 
+// drawLineNumber draws the position of target in the input, right
+// aligned in a gutter of the given width at the left edge of the
+// screen. It is drawn after the line, over the part of the line that
+// is scrolled under it
+func (l *ListArea) drawLineNumber(src *Source, target line.Line, y, width int, fg, bg termbox.Attribute) {
+	if width == 0 {
+		return
+	}
+
+	var msg string
+	if i, ok := src.inputIndex(target.ID()); ok {
+		msg = strconv.Itoa(i + 1)
+	}
+	if st := l.styles.LineNumber; st != (Style{}) {
+		fg, bg = st.fg, st.bg
+	}
+	l.screen.Print(PrintArgs{
+		Y:   y,
+		Fg:  fg,
+		Bg:  bg,
+		Msg: fmt.Sprintf("%*s ", width-1, msg),
+	})
+}
+
+// printColored prints line[from:to] with the style given by args, except
+// for the parts that have a style of their own in spans. The background
+// colors of spans are only used if inputBg is true. Returns the width of
+// what was printed, like Print
+func (l *ListArea) printColored(scr Screen, args PrintArgs, line string, from, to int, spans []ansiSpan, inputBg bool) int {
+	fill := args.Fill
+	args.Fill = false
+
+	var written int
+	draw := func(end int, fg, bg termbox.Attribute) {
+		pa := args
+		pa.X += written
+		pa.Fg = fg
+		pa.Bg = bg
+		pa.Msg = line[from:end]
+		written += scr.Print(pa)
+		from = end
+	}
+
+	for _, span := range spans {
+		if span.end <= from {
+			continue
+		}
+		if span.start >= to {
+			break
+		}
+		if span.start > from {
+			draw(span.start, args.Fg, args.Bg)
+		}
+
+		bg := args.Bg
+		if inputBg {
+			bg = applyANSI(bg, span.bg)
+		}
+		end := span.end
+		if end > to {
+			end = to
+		}
+		draw(end, applyANSI(args.Fg, span.fg), bg)
+	}
+
+	args.Fill = fill
+	draw(to, args.Fg, args.Bg)
+	return written
+}
+
 func maxOf(a, b int) int {
 	if a > b {
 		return a
@@ -600,28 +1120,67 @@ func maxOf(a, b int) int {
 	return b
 }
 
+func minOf(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // NewDefaultLayout creates a new Layout in the default format (top-down)
 func NewDefaultLayout(state *Peco) *BasicLayout {
-	return &BasicLayout{
-		StatusBar: NewStatusBar(state.Screen(), AnchorBottom, 0+extraOffset, state.Styles()),
-		// The prompt is at the top
-		prompt: NewUserPrompt(state.Screen(), AnchorTop, 0, state.Prompt(), state.Styles()),
-		// The list area is at the top, after the prompt
-		// It's also displayed top-to-bottom order
-		list: NewListArea(state.Screen(), AnchorTop, 1, true, state.Styles()),
-	}
+	return newBasicLayout(state, true)
 }
 
 // NewBottomUpLayout creates a new Layout in bottom-up format
 func NewBottomUpLayout(state *Peco) *BasicLayout {
-	return &BasicLayout{
-		StatusBar: NewStatusBar(state.Screen(), AnchorBottom, 0+extraOffset, state.Styles()),
-		// The prompt is at the bottom, above the status bar
-		prompt: NewUserPrompt(state.Screen(), AnchorBottom, 1+extraOffset, state.Prompt(), state.Styles()),
-		// The list area is at the bottom, above the prompt
-		// It's displayed in bottom-to-top order
-		list: NewListArea(state.Screen(), AnchorBottom, 2+extraOffset, false, state.Styles()),
+	return newBasicLayout(state, false)
+}
+
+// newBasicLayout creates a Layout whose list is displayed in top-to-bottom
+// order if topDown is true. The status bar and the prompt are placed at
+// the top or the bottom of the screen according to StatusPosition and
+// PromptPosition: the status bar at the edge of the screen, and the
+// prompt between it and the list. The list area takes the rows that
+// are left, and starts next to the prompt if they are on the same side
+func newBasicLayout(state *Peco, topDown bool) *BasicLayout {
+	promptPosition := state.promptPosition
+	if promptPosition == "" {
+		// The prompt is at the top of a top-down list, and below a
+		// bottom-up one
+		promptPosition = PositionTop
+		if !topDown {
+			promptPosition = PositionBottom
+		}
+	}
+	statusPosition := state.statusPosition
+	if statusPosition == "" {
+		statusPosition = PositionBottom
 	}
+
+	// The number of rows taken at the top and the bottom of the screen
+	top, bottom := 0, extraOffset
+	place := func(position string) (VerticalAnchor, int) {
+		if position == PositionTop {
+			top++
+			return AnchorTop, top - 1
+		}
+		bottom++
+		return AnchorBottom, bottom - 1
+	}
+
+	l := &BasicLayout{preview: state.preview}
+	anchor, offset := place(statusPosition)
+	l.StatusBar = NewStatusBar(state.Screen(), anchor, offset, state.Styles())
+	anchor, offset = place(promptPosition)
+	l.prompt = NewUserPrompt(state.Screen(), anchor, offset, state.Prompt(), state.Styles())
+	if topDown {
+		l.list = NewListArea(state.Screen(), AnchorTop, top, true, state.Styles())
+	} else {
+		l.list = NewListArea(state.Screen(), AnchorBottom, bottom, false, state.Styles())
+	}
+	l.topRows = top
+	return l
 }
 
 func (l *BasicLayout) PurgeDisplayCache() {
@@ -636,10 +1195,24 @@ func (l *BasicLayout) CalculatePage(state *Peco, perPage int) error {
 	}
 	buf := state.CurrentLineBuffer()
 	loc := state.Location()
-	loc.SetPage((loc.LineNumber() / perPage) + 1)
-	loc.SetOffset((loc.Page() - 1) * perPage)
+	if state.LineWrap() {
+		l.calculateWrappedPage(state, perPage)
+		perPage = loc.PerPage()
+	} else if state.scrollMode == ScrollModeContinuous {
+		calculateScrollOffset(loc, buf.Size(), perPage)
+	} else if n := loc.LineNumber(); loc.Scrolled() && n >= loc.Offset() && n < loc.Offset()+perPage {
+		// The list stays where it was scrolled to, until the cursor
+		// leaves the screen
+		loc.SetPage((n / perPage) + 1)
+	} else {
+		loc.SetScrolled(false)
+		loc.SetPage((loc.LineNumber() / perPage) + 1)
+		loc.SetOffset((loc.Page() - 1) * perPage)
+	}
 	loc.SetPerPage(perPage)
 	loc.SetTotal(buf.Size())
+	state.setLazyWindow(lazyFilterWindow(loc))
+	state.setQuickSelectWindow(loc.Offset(), perPage)
 
 	if loc.Total() == 0 {
 		loc.SetMaxPage(1)
@@ -658,9 +1231,51 @@ func (l *BasicLayout) CalculatePage(state *Peco, perPage int) error {
 	return nil
 }
 
+// calculateScrollOffset moves the first line on the screen only as far
+// as needed to keep the cursor on the screen. The screen is kept full
+// when the number of lines shrinks, as long as there are enough lines
+func calculateScrollOffset(loc *Location, total, perPage int) {
+	lineno := loc.LineNumber()
+	if total > 0 && lineno >= total {
+		lineno = total - 1
+	}
+
+	offset := loc.Offset()
+	if lineno < offset {
+		offset = lineno
+	} else if lineno >= offset+perPage {
+		offset = lineno - perPage + 1
+	}
+	if max := total - perPage; offset > max {
+		offset = max
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	loc.SetOffset(offset)
+	loc.SetPage((lineno / perPage) + 1)
+}
+
 // DrawPrompt draws the prompt to the terminal
 func (l *BasicLayout) DrawPrompt(state *Peco) {
 	l.prompt.Draw(state)
+	// The flags at the start of the query may select another filter
+	current, _, flags := state.queryFilter(state.Filters().Current(), state.Query().String())
+	name := current.String()
+	if flags.word {
+		name += " (word)"
+	}
+	if queries := state.MultiQuery(); len(queries) > 0 {
+		name = fmt.Sprintf("%s [%s]", name, strings.Join(queries, " OR "))
+	}
+	if depth := state.RefinementDepth(); depth > 0 {
+		name = fmt.Sprintf("%s (refined %d)", name, depth)
+	}
+	if n := state.OutputField(); n > 0 {
+		name = fmt.Sprintf("%s (field %d)", name, n)
+	}
+	l.StatusBar.SetFilterName(name)
 }
 
 // DrawScreen draws the entire screen
@@ -670,21 +1285,59 @@ func (l *BasicLayout) DrawScreen(state *Peco, options *DrawOptions) {
 		defer g.End()
 	}
 
+	// The list moves to make room for the header lines as they are read
+	if n := l.headerRows(state); n != l.list.headerRows {
+		l.list.headerRows = n
+		l.list.purgeDisplayCache()
+	}
 	perPage := l.linesPerPage()
 
+	if state.followMode {
+		l.followNewLines(state)
+	}
+
 	if err := l.CalculatePage(state, perPage); err != nil {
 		return
 	}
 
 	l.DrawPrompt(state)
 	l.list.Draw(state, l, perPage, options)
+	l.list.drawHeaders(state)
+	if l.preview != nil {
+		l.drawPreview(state)
+	}
 
 	if err := l.screen.Flush(); err != nil {
 		return
 	}
 }
 
+// followNewLines moves the cursor to the last line if lines have been
+// added to the buffer since the last time the screen was drawn, and
+// the cursor was on the last line
+func (l *BasicLayout) followNewLines(state *Peco) {
+	buf := state.CurrentLineBuffer()
+	size := buf.Size()
+	loc := state.Location()
+	if buf == l.followBuf && size > l.followSize && loc.LineNumber() >= l.followSize-1 {
+		if prev, err := buf.LineAt(loc.LineNumber()); err == nil {
+			prev.SetDirty(true)
+		}
+		loc.SetLineNumber(size - 1)
+	}
+	l.followBuf = buf
+	l.followSize = size
+}
+
+// linesPerPage returns the number of lines displayed in the list area,
+// below the header lines
 func (l *BasicLayout) linesPerPage() int {
+	return l.listRows() - l.list.headerRows
+}
+
+// listRows returns the number of rows of the list area, including those
+// taken by the header lines
+func (l *BasicLayout) listRows() int {
 	_, height := l.screen.Size()
 
 	// list area is always the display area - 2 lines for prompt and status
@@ -704,14 +1357,93 @@ func (l *BasicLayout) linesPerPage() int {
 		}
 		return 2
 	}
-	return pp
+	return pp - l.previewHeight(pp)
+}
+
+// previewHeight returns the number of rows taken from the list area
+// by the preview pane, including the separator
+func (l *BasicLayout) previewHeight(rows int) int {
+	if l.preview == nil || l.preview.position != previewPositionBottom || l.preview.Hidden() {
+		return 0
+	}
+
+	ph := rows * l.preview.size / 100
+	if ph < 2 || rows-ph < 1 {
+		// Not enough space to display both the list and the preview
+		return 0
+	}
+	return ph
+}
+
+// previewWidth returns the number of columns taken from the list area
+// by the preview pane, including the separator
+func (l *BasicLayout) previewWidth(width int) int {
+	if l.preview == nil || l.preview.position == previewPositionBottom || l.preview.Hidden() {
+		return 0
+	}
+
+	pw := width * l.preview.size / 100
+	if pw < 2 || width-pw < 1 {
+		// Not enough space to display both the list and the preview
+		return 0
+	}
+	return pw
+}
+
+// drawPreview draws the preview pane. When the pane is on the right,
+// it is drawn over the right side of the list area
+func (l *BasicLayout) drawPreview(state *Peco) {
+	width, height := l.screen.Size()
+	rows := height - (2 + extraOffset)
+	if rows < 1 {
+		return
+	}
+
+	// The first row of the list area
+	top := l.topRows
+
+	fg := l.styles.Basic.fg
+	bg := l.styles.Basic.bg
+	switch l.preview.position {
+	case previewPositionBottom:
+		ph := l.previewHeight(rows)
+		if ph == 0 {
+			return
+		}
+
+		// The preview is displayed on the opposite side of the prompt
+		sep, y := top+rows-ph, top+rows-ph+1
+		if !l.list.sortTopDown {
+			sep, y = top+ph-1, top
+		}
+		l.screen.Print(PrintArgs{
+			Y:   sep,
+			Fg:  fg,
+			Bg:  bg,
+			Msg: strings.Repeat("─", width),
+		})
+		l.preview.Draw(state, 0, y, ph-1)
+	default:
+		pw := l.previewWidth(width)
+		if pw == 0 {
+			return
+		}
+
+		x := width - pw
+		for i := 0; i < rows; i++ {
+			l.screen.SetCell(x, top+i, '│', fg, bg)
+		}
+		l.preview.Draw(state, x+1, top, rows)
+	}
 }
 
 // MovePage scrolls the screen
 func (l *BasicLayout) MovePage(state *Peco, p PagingRequest) (moved bool) {
 	switch p.Type() {
-	case ToScrollLeft, ToScrollRight:
+	case ToScrollLeft, ToScrollRight, ToScrollFirstColumn:
 		moved = horizontalScroll(state, l, p)
+	case ToScrollViewUp, ToScrollViewDown:
+		moved = scrollView(state, l, p)
 	default:
 		moved = verticalScroll(state, l, p)
 	}
@@ -742,8 +1474,30 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		}
 	}()
 
-	lpp := l.linesPerPage()
-	if l.list.sortTopDown {
+	// Wrapped lines take more than a row, so a page holds fewer lines
+	// than there are rows
+	rows := l.linesPerPage()
+	lpp := rows
+	if state.LineWrap() && loc.PerPage() > 0 {
+		lpp = loc.PerPage()
+	}
+
+	// The jumps to a line of the list go by the indices of the lines,
+	// so they are the same in either layout
+	target, jumpDir, jump := jumpTarget(loc, p.Type(), lcur, lpp)
+	if jump && lcur == 0 {
+		return false
+	}
+
+	if p.Type() == ToScreenLine {
+		n, ok := l.list.lineIndexAt(state, rows, p.(JumpToScreenLineRequest).Row())
+		if !ok {
+			return false
+		}
+		lineno = n
+	} else if jump {
+		lineno = target
+	} else if l.list.sortTopDown {
 		switch p.Type() {
 		case ToLineAbove:
 			lineno--
@@ -757,7 +1511,7 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		case ToScrollPageUp:
 			lineno -= lpp
 		case ToLineInPage:
-			lineno = loc.PerPage()*(loc.Page()-1) + p.(JumpToLineRequest).Line()
+			lineno = loc.Offset() + p.(JumpToLineRequest).Line()
 		}
 	} else {
 		switch p.Type() {
@@ -770,10 +1524,26 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		case ToScrollPageUp:
 			lineno += lpp
 		case ToLineInPage:
-			lineno = loc.PerPage()*(loc.Page()-1) - p.(JumpToLineRequest).Line()
+			lineno = loc.Offset() - p.(JumpToLineRequest).Line()
+		}
+	}
+
+	// Scrolling by a page in the continuous mode moves the lines on
+	// the screen along with the cursor, like less does
+	if state.scrollMode == ScrollModeContinuous && lineno >= 0 && lineno < lcur {
+		switch p.Type() {
+		case ToScrollPageDown, ToScrollPageUp:
+			loc.SetOffset(loc.Offset() + lineno - lineBefore)
 		}
 	}
 
+	dir := 1
+	if jump {
+		dir = jumpDir
+	} else if lineno < lineBefore {
+		dir = -1
+	}
+
 	if lineno < 0 {
 		if lcur > 0 {
 			// Go to last page, if possible
@@ -785,22 +1555,118 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 		lineno = 0
 	}
 
+	// The cursor steps over group headers in the direction it moves
+	if state.skipsGroupHeaders() && lcur > 0 {
+		lineno = skipGroupHeaders(state, buf, lineno, dir)
+	}
+
 	// XXX DO NOT RETURN UNTIL YOU SET THE LINE NUMBER HERE
 	loc.SetLineNumber(lineno)
 
+	updateSelectionRange(state, l, lineBefore)
+	return true
+}
+
+// jumpTarget returns the line that ToFirstLine, ToLastLine and
+// ToMiddleLine move the cursor to, among the total lines of the list,
+// and the direction in which the group headers are skipped from there.
+// The middle line is that of the lines on the screen, which are perPage
+// lines from the offset, unless the list ends before. The last return
+// value is false for the other types of requests
+func jumpTarget(loc *Location, t PagingRequestType, total, perPage int) (int, int, bool) {
+	switch t {
+	case ToFirstLine:
+		return 0, 1, true
+	case ToLastLine:
+		return total - 1, -1, true
+	case ToMiddleLine:
+		offset := minOf(loc.Offset(), maxOf(total-1, 0))
+		n := maxOf(minOf(perPage, total-offset), 1)
+		return offset + (n-1)/2, 1, true
+	}
+	return 0, 0, false
+}
+
+// scrollView scrolls the list by a line. The cursor stays on the same
+// line, unless that line would leave the screen, in which case the
+// cursor is pulled along to the edge of the screen
+func scrollView(state *Peco, l *BasicLayout, p PagingRequest) bool {
+	loc := state.Location()
+	buf := state.CurrentLineBuffer()
+	lcur := buf.Size()
+
+	perPage := loc.PerPage()
+	if perPage < 1 {
+		perPage = l.linesPerPage()
+	}
+
+	// The lines are indexed from the bottom in the bottom-up layout
+	step := 1
+	if p.Type() == ToScrollViewUp {
+		step = -1
+	}
+	if !l.list.sortTopDown {
+		step = -step
+	}
+
+	offset := loc.Offset() + step
+	if offset < 0 || (step > 0 && offset > lcur-perPage) {
+		return false
+	}
+	loc.SetOffset(offset)
+	loc.SetScrolled(true)
+
+	lineBefore := loc.LineNumber()
+	lineno := lineBefore
+	if lineno < offset {
+		lineno = offset
+	} else if lineno >= offset+perPage {
+		lineno = offset + perPage - 1
+	}
+	if lineno == lineBefore {
+		return true
+	}
+
+	if state.skipsGroupHeaders() {
+		dir := 1
+		if lineno < lineBefore {
+			dir = -1
+		}
+		lineno = skipGroupHeaders(state, buf, lineno, dir)
+	}
+	for _, lno := range []int{lineBefore, lineno} {
+		if target, err := buf.LineAt(lno); err == nil {
+			target.SetDirty(true)
+		}
+	}
+	loc.SetLineNumber(lineno)
+	updateSelectionRange(state, l, lineBefore)
+
+	return true
+}
+
+// updateSelectionRange selects the lines between the start of the
+// range and the cursor, which moved from lineBefore, when a range of
+// lines is being selected
+func updateSelectionRange(state *Peco, l *BasicLayout, lineBefore int) {
+	loc := state.Location()
+	buf := state.CurrentLineBuffer()
+	lcur := buf.Size()
+
 	// if we were in range mode, we need to do stuff. otherwise
 	// just bail out
 	r := state.SelectionRangeStart()
 	if !r.Valid() {
-		return true
+		return
 	}
 
 	sel := state.Selection()
+	full := false
 	if l.list.sortTopDown {
 		if loc.LineNumber() < r.Value() {
 			for lineno := loc.LineNumber(); lineno <= r.Value(); lineno++ {
-				if line, err := buf.LineAt(lineno); err == nil {
-					sel.Add(line)
+				if line, err := buf.LineAt(lineno); err == nil && !sel.Add(line) {
+					full = true
 				}
 			}
 			switch {
@@ -819,8 +1685,8 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 			}
 		} else {
 			for lineno := r.Value(); lineno <= lcur && lineno <= loc.LineNumber(); lineno++ {
-				if line, err := buf.LineAt(lineno); err == nil {
-					sel.Add(line)
+				if line, err := buf.LineAt(lineno); err == nil && !sel.Add(line) {
+					full = true
 				}
 			}
 
@@ -840,23 +1706,32 @@ func verticalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
 			}
 		}
 	}
+	if full {
+		notifySelectionFull(state)
+	}
 
-	return true
 }
 
 // horizontalScroll scrolls screen horizontal
 func horizontalScroll(state *Peco, l *BasicLayout, p PagingRequest) bool {
-	width, _ := state.screen.Size()
+	step := state.horizontalScrollStep
+	if step <= 0 {
+		width, _ := state.screen.Size()
+		step = maxOf(width/2, 1)
+	}
+
 	loc := state.Location()
-	if p.Type() == ToScrollRight {
-		loc.SetColumn(loc.Column() + width/2)
-	} else if loc.Column() > 0 {
-		loc.SetColumn(loc.Column() - width/2)
-		if loc.Column() < 0 {
-			loc.SetColumn(0)
-		}
-	} else {
+	switch {
+	case p.Type() == ToScrollRight:
+		// ListArea.Draw makes sure that we don't scroll past
+		// the longest line
+		loc.SetColumn(loc.Column() + step)
+	case loc.Column() <= 0:
 		return false
+	case p.Type() == ToScrollFirstColumn:
+		loc.SetColumn(0)
+	default:
+		loc.SetColumn(maxOf(loc.Column()-step, 0))
 	}
 
 	l.list.SetDirty(true)
@@ -1,11 +1,18 @@
 package peco
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
 )
 
 func TestLayoutType(t *testing.T) {
@@ -29,6 +36,72 @@ func TestLayoutType(t *testing.T) {
 	}
 }
 
+func TestLayoutPositions(t *testing.T) {
+	state := newPeco()
+	buf := NewMemoryBuffer()
+	for i := 0; i < 20; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), "foo", false))
+	}
+	state.currentLineBuffer = buf
+
+	// The last row that can be drawn on
+	_, height := state.Screen().Size()
+	last := height - 1 - extraOffset
+
+	tests := []struct {
+		topDown bool
+		prompt  string
+		status  string
+		// Rows of the prompt, the status bar, and the first line
+		promptRow, statusRow, firstRow int
+	}{
+		{true, "", "", 0, last, 1},
+		{false, "", "", last - 1, last, last - 2},
+		{true, PositionBottom, PositionBottom, last - 1, last, 0},
+		{true, PositionTop, PositionTop, 1, 0, 2},
+		{true, PositionBottom, PositionTop, last, 0, 1},
+		{false, PositionTop, PositionTop, 1, 0, last},
+		{false, PositionTop, PositionBottom, 0, last, last - 1},
+	}
+
+	for _, test := range tests {
+		state.promptPosition = test.prompt
+		state.statusPosition = test.status
+		l := newBasicLayout(state, test.topDown)
+		name := fmt.Sprintf("top-down = %t, prompt = %q, status = %q", test.topDown, test.prompt, test.status)
+
+		if y := l.prompt.AnchorPosition(); y != test.promptRow {
+			t.Errorf("%s: expected the prompt on row %d, got %d", name, test.promptRow, y)
+		}
+		if y := l.StatusBar.AnchorPosition(); y != test.statusRow {
+			t.Errorf("%s: expected the status bar on row %d, got %d", name, test.statusRow, y)
+		}
+		if y := l.list.firstRow(); y != test.firstRow {
+			t.Errorf("%s: expected the first line on row %d, got %d", name, test.firstRow, y)
+		}
+
+		// The list takes the rest of the screen, and clicks land on the
+		// line drawn on the row that was clicked
+		perPage := l.linesPerPage()
+		if perPage != height-2-extraOffset {
+			t.Errorf("%s: expected %d lines per page, got %d", name, height-2-extraOffset, perPage)
+		}
+		state.Location().SetOffset(0)
+		for _, y := range []int{test.promptRow, test.statusRow} {
+			if i, ok := l.list.lineIndexAt(state, perPage, y); ok {
+				t.Errorf("%s: expected no line at row %d, got %d", name, y, i)
+			}
+		}
+		lastLine := test.firstRow + perPage - 1
+		if !test.topDown {
+			lastLine = test.firstRow - perPage + 1
+		}
+		if i, ok := l.list.lineIndexAt(state, perPage, lastLine); !ok || i != perPage-1 {
+			t.Errorf("%s: expected line %d at row %d, got %d (%t)", name, perPage-1, lastLine, i, ok)
+		}
+	}
+}
+
 func TestPrintScreen(t *testing.T) {
 	screen := NewDummyScreen()
 
@@ -92,6 +165,107 @@ func TestStatusBar(t *testing.T) {
 	}
 }
 
+func TestStatusBarFilterName(t *testing.T) {
+	screen := NewDummyScreen()
+	st := NewStatusBar(screen, AnchorBottom, 0, NewStyleSet())
+
+	cellsAt := func(y int) string {
+		cells := make([]rune, screen.width)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[1].(int) == y {
+				cells[ev[0].(int)] = ev[2].(rune)
+			}
+		}
+		return string(cells)
+	}
+
+	st.SetFilterName("IgnoreCase")
+	if l := strings.TrimRight(cellsAt(screen.height-1), "\x00"); l != "IgnoreCase" {
+		t.Errorf("Expected filter name to be drawn, got '%s'", l)
+		return
+	}
+
+	screen.interceptor.reset()
+	st.SetFilterName("IgnoreCase")
+	if l := len(screen.interceptor.events["SetCell"]); l != 0 {
+		t.Errorf("Expected unchanged filter name not to be redrawn, got %d SetCell events", l)
+		return
+	}
+
+	screen.interceptor.reset()
+	st.PrintStatus("Hello", 0)
+	l := cellsAt(screen.height - 1)
+	if !strings.HasPrefix(l, "IgnoreCase ") {
+		t.Errorf("Expected filter name to be kept when printing a status message, got '%s'", l)
+		return
+	}
+	if !strings.HasSuffix(l, " Hello") {
+		t.Errorf("Expected status message to be printed, got '%s'", l)
+		return
+	}
+}
+
+func TestStatusBarQueue(t *testing.T) {
+	screen := NewDummyScreen()
+	st := NewStatusBar(screen, AnchorBottom, 0, NewStyleSet())
+
+	verify := func(expected string) bool {
+		if cur := st.currentStatus().text; cur != expected {
+			t.Errorf("Expected status message to be '%s', got '%s'", expected, cur)
+			return false
+		}
+		return true
+	}
+
+	st.ShowStatus("Loading", 0, MessageInfo)
+	st.ShowStatus("Failed", time.Hour, MessageError)
+	if !verify("Failed") {
+		return
+	}
+	if st.StatusTimer() == nil {
+		t.Errorf("Expected a timer for the timed message")
+		return
+	}
+
+	// Less important messages wait for the error to be cleared
+	st.ShowStatus("Copied", time.Hour, MessageInfo)
+	st.ShowStatus("Still loading", 0, MessageInfo)
+	if !verify("Failed") {
+		return
+	}
+
+	st.ExpireStatus()
+	if !verify("Copied") {
+		return
+	}
+
+	// Messages as important replace the message right away
+	st.ShowStatus("Copied again", time.Hour, MessageInfo)
+	if !verify("Copied again") {
+		return
+	}
+
+	st.ExpireStatus()
+	if !verify("Still loading") {
+		return
+	}
+	if st.StatusTimer() != nil {
+		t.Errorf("Expected no timer for the message without a ttl")
+		return
+	}
+
+	// The timer set for the message clears it
+	st.ShowStatus("Warning", 10*time.Millisecond, MessageWarning)
+	select {
+	case <-st.StatusTimer():
+		st.ExpireStatus()
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the timer to fire")
+		return
+	}
+	verify("Still loading")
+}
+
 func TestMergeAttribute(t *testing.T) {
 	colors := stringToFg
 
@@ -127,3 +301,946 @@ func TestMergeAttribute(t *testing.T) {
 	}
 
 }
+
+func TestListAreaLineIndexAt(t *testing.T) {
+	state := newPeco()
+	buf := NewMemoryBuffer()
+	for i := 0; i < 20; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), "foo", false))
+	}
+	state.currentLineBuffer = buf
+	state.Location().SetOffset(8)
+
+	screen := state.Screen()
+	perPage := 8
+	tests := []struct {
+		list     *ListArea
+		y        int
+		expected int // -1 if there is no line at y
+	}{
+		// top-down: the list starts right below the prompt
+		{NewListArea(screen, AnchorTop, 1, true, state.Styles()), 0, -1},
+		{NewListArea(screen, AnchorTop, 1, true, state.Styles()), 1, 8},
+		{NewListArea(screen, AnchorTop, 1, true, state.Styles()), 8, 15},
+		{NewListArea(screen, AnchorTop, 1, true, state.Styles()), 9, -1},
+		// bottom-up: the list starts right above the prompt, and
+		// goes up from there
+		{NewListArea(screen, AnchorBottom, 2, false, state.Styles()), 7, 8},
+		{NewListArea(screen, AnchorBottom, 2, false, state.Styles()), 0, 15},
+		{NewListArea(screen, AnchorBottom, 2, false, state.Styles()), 8, -1},
+	}
+
+	for _, test := range tests {
+		i, ok := test.list.lineIndexAt(state, perPage, test.y)
+		if test.expected < 0 {
+			if ok {
+				t.Errorf("Expected no line at row %d (top-down = %t), got %d", test.y, test.list.sortTopDown, i)
+			}
+			continue
+		}
+		if !ok || i != test.expected {
+			t.Errorf("Expected line %d at row %d (top-down = %t), got %d (%t)", test.expected, test.y, test.list.sortTopDown, i, ok)
+		}
+	}
+
+	// Rows past the end of the buffer have no lines
+	state.Location().SetOffset(16)
+	list := NewListArea(screen, AnchorTop, 1, true, state.Styles())
+	if i, ok := list.lineIndexAt(state, perPage, 5); ok {
+		t.Errorf("Expected no line after the end of the buffer, got %d", i)
+	}
+}
+
+func TestPrintScreenClipping(t *testing.T) {
+	screen := NewDummyScreen()
+
+	cells := func() map[int]rune {
+		ret := make(map[int]rune)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			x := ev[0].(int)
+			if x < 0 {
+				t.Errorf("Expected cells left of the screen to be clipped, got SetCell at %d", x)
+			}
+			ret[x] = ev[2].(rune)
+		}
+		return ret
+	}
+
+	// "日本" is 4 columns wide. Scrolled by 3 columns, only the right
+	// half of "本" is visible, which should be drawn as a space
+	screen.interceptor.reset()
+	n := screen.Print(PrintArgs{X: -3, Msg: "日本abc"})
+	if n != 7 {
+		t.Errorf("Expected 7 columns to be written, got %d", n)
+	}
+	c := cells()
+	if c[0] != ' ' || c[1] != 'a' || c[2] != 'b' || c[3] != 'c' {
+		t.Errorf("Expected ' abc' to be drawn, got %#v", c)
+	}
+
+	// Filling a line that starts off screen should only touch
+	// the visible cells
+	screen.interceptor.reset()
+	screen.Print(PrintArgs{X: -5, Msg: "foo", Fill: true})
+	if c := cells(); len(c) != screen.width {
+		t.Errorf("Expected %d cells to be filled, got %d", screen.width, len(c))
+	}
+}
+
+func TestPrintScreenWideRunes(t *testing.T) {
+	screen := NewDummyScreen()
+
+	cells := func() map[int]rune {
+		ret := make(map[int]rune)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			x := ev[0].(int)
+			if x >= screen.width {
+				t.Errorf("Expected cells right of the screen to be clipped, got SetCell at %d", x)
+			}
+			ret[x] = ev[2].(rune)
+		}
+		return ret
+	}
+
+	// Only one column is left for "日", which should be drawn as a
+	// space instead of half of it
+	screen.interceptor.reset()
+	screen.Print(PrintArgs{X: screen.width - 3, Msg: "ab日c"})
+	c := cells()
+	if x := screen.width - 3; c[x] != 'a' || c[x+1] != 'b' || c[x+2] != ' ' || len(c) != 3 {
+		t.Errorf("Expected 'ab ' to be drawn at the right edge, got %#v", c)
+	}
+
+	// Combining marks do not take a column of their own
+	screen.interceptor.reset()
+	n := screen.Print(PrintArgs{Msg: "e\u0301x"})
+	if n != 2 {
+		t.Errorf("Expected 2 columns to be written, got %d", n)
+	}
+	c = cells()
+	if c[0] != 'e' || c[1] != 'x' || len(c) != 2 {
+		t.Errorf("Expected 'ex' to be drawn, got %#v", c)
+	}
+}
+
+func TestStatusBarWideRunes(t *testing.T) {
+	screen := NewDummyScreen()
+	st := NewStatusBar(screen, AnchorBottom, 0, NewStyleSet())
+
+	// 60 characters that are 2 columns wide each don't fit in 80
+	// columns, so only the last 40 should be drawn
+	st.PrintStatus(strings.Repeat("日", 30)+strings.Repeat("本", 30), 0)
+
+	var drawn []rune
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		if r := ev[2].(rune); r != ' ' {
+			drawn = append(drawn, r)
+		}
+	}
+	expected := strings.Repeat("日", 10) + strings.Repeat("本", 30)
+	if string(drawn) != expected {
+		t.Errorf("Expected %q to be drawn, got %q", expected, string(drawn))
+	}
+}
+
+func TestMatchedWideRunes(t *testing.T) {
+	state := newPeco()
+	screen := NewDummyScreen()
+	styles := NewStyleSet()
+	styles.Selected = Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault}
+	styles.Matched = Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault}
+
+	// "a" comes after "日本", which take 6 bytes and 4 columns
+	buf := NewMemoryBuffer()
+	buf.lines = append(buf.lines, line.NewMatched(line.NewRaw(0, "日本abc", false), [][]int{{6, 7}}))
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(1)
+	loc.SetPage(1)
+	loc.SetLineNumber(0)
+
+	list := NewListArea(screen, AnchorTop, 0, true, styles)
+	list.Draw(state, nil, 1, &DrawOptions{DisableCache: true})
+
+	cells := make(map[int]interceptorArgs)
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		cells[ev[0].(int)] = ev
+	}
+	for x, expected := range map[int]struct {
+		ch rune
+		fg termbox.Attribute
+	}{
+		0: {'日', termbox.ColorDefault},
+		2: {'本', termbox.ColorDefault},
+		4: {'a', termbox.ColorCyan},
+		5: {'b', termbox.ColorDefault},
+	} {
+		ev, ok := cells[x]
+		if !ok {
+			t.Errorf("Expected a cell to be drawn at %d", x)
+			continue
+		}
+		if ev[2].(rune) != expected.ch || ev[3].(termbox.Attribute) != expected.fg {
+			t.Errorf("Expected %q with fg %d at %d, got %q with fg %d", expected.ch, expected.fg, x, ev[2].(rune), ev[3].(termbox.Attribute))
+		}
+	}
+}
+
+func TestHorizontalScroll(t *testing.T) {
+	state := newPeco()
+	layout := NewDefaultLayout(state)
+	loc := state.Location()
+
+	if horizontalScroll(state, layout, ToScrollLeft) {
+		t.Errorf("Expected scrolling left at the first column to be a no-op")
+	}
+
+	// By default, we scroll by half the width of the screen
+	horizontalScroll(state, layout, ToScrollRight)
+	if loc.Column() != 40 {
+		t.Errorf("Expected column to be 40, got %d", loc.Column())
+	}
+
+	state.horizontalScrollStep = 8
+	horizontalScroll(state, layout, ToScrollRight)
+	if loc.Column() != 48 {
+		t.Errorf("Expected column to be 48, got %d", loc.Column())
+	}
+	horizontalScroll(state, layout, ToScrollLeft)
+	if loc.Column() != 40 {
+		t.Errorf("Expected column to be 40, got %d", loc.Column())
+	}
+
+	if !horizontalScroll(state, layout, ToScrollFirstColumn) || loc.Column() != 0 {
+		t.Errorf("Expected to scroll back to the first column, got %d", loc.Column())
+	}
+	if horizontalScroll(state, layout, ToScrollFirstColumn) {
+		t.Errorf("Expected scrolling to the first column to be a no-op when already there")
+	}
+}
+
+func TestFollowNewLines(t *testing.T) {
+	state := newPeco()
+	buf := NewMemoryBuffer()
+	state.currentLineBuffer = buf
+	layout := NewDefaultLayout(state)
+
+	appendLines := func(n int) {
+		for i := 0; i < n; i++ {
+			buf.lines = append(buf.lines, line.NewRaw(uint64(len(buf.lines)), "foo", false))
+		}
+	}
+
+	loc := state.Location()
+	layout.followNewLines(state)
+	appendLines(3)
+	layout.followNewLines(state)
+	if loc.LineNumber() != 2 {
+		t.Errorf("Expected cursor to follow the last line, got %d", loc.LineNumber())
+	}
+
+	appendLines(2)
+	layout.followNewLines(state)
+	if loc.LineNumber() != 4 {
+		t.Errorf("Expected cursor to follow the last line, got %d", loc.LineNumber())
+	}
+
+	// Once the cursor moves away from the last line, it stays there
+	loc.SetLineNumber(1)
+	appendLines(2)
+	layout.followNewLines(state)
+	if loc.LineNumber() != 1 {
+		t.Errorf("Expected cursor to stay on line 1, got %d", loc.LineNumber())
+	}
+}
+
+func TestPromptCountMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	state := newPeco()
+	state.hub = nullHub{}
+	if err := state.populateFilters(); err != nil {
+		t.Errorf("failed to populate filters: %s", err)
+		return
+	}
+	src := NewSource("-", strings.NewReader("foo\nbar\nbaz\n"), ig, 0, false)
+	go src.Setup(ctx, state)
+	<-src.SetupDone()
+	state.source = src
+
+	loc := state.Location()
+	loc.SetTotal(2)
+	loc.SetPage(1)
+	loc.SetMaxPage(1)
+
+	if msg := promptCountMessage(state); msg != "IgnoreCase [2 (1/1)]" {
+		t.Errorf("default format should be used, got %q", msg)
+	}
+
+	tmpl, err := compileTemplate("PromptCountFormat", "[{{.Matched}}/{{.Total}}] ({{.Elapsed}})", promptCount{})
+	if err != nil {
+		t.Errorf("failed to compile template: %s", err)
+		return
+	}
+	state.promptCountTemplate = tmpl
+
+	if msg := promptCountMessage(state); msg != "[2/3] ()" {
+		t.Errorf("elapsed time should be empty without a query, got %q", msg)
+	}
+
+	start := time.Now().Add(-1500 * time.Millisecond)
+	state.startQueryTimer(start)
+	state.stopQueryTimer(start)
+	if msg := promptCountMessage(state); !strings.HasPrefix(msg, "[2/3] (1.5") {
+		t.Errorf("elapsed time should be displayed, got %q", msg)
+	}
+
+	// A query that finishes after another one has started should not
+	// overwrite the elapsed time
+	state.startQueryTimer(time.Now())
+	state.stopQueryTimer(start)
+	if d, ok := state.QueryElapsed(); !ok || d > time.Second {
+		t.Errorf("elapsed time of the running query should be reported, got %s", d)
+	}
+
+	if _, err := compileTemplate("PromptCountFormat", "{{.Unknown}}", promptCount{}); err == nil {
+		t.Errorf("unknown fields should be rejected")
+	}
+}
+
+func TestScrollMode(t *testing.T) {
+	for _, mode := range []string{ScrollModePage, ScrollModeContinuous, ""} {
+		if !IsValidScrollMode(mode) {
+			t.Errorf("Expected %q to be a valid scroll mode", mode)
+		}
+	}
+	if IsValidScrollMode("foobar") {
+		t.Errorf("Expected foobar to be an invalid scroll mode")
+	}
+
+	newState := func(mode string) (*Peco, *BasicLayout, int) {
+		state := newPeco()
+		state.scrollMode = mode
+		buf := NewMemoryBuffer()
+		for i := 0; i < 20; i++ {
+			buf.lines = append(buf.lines, line.NewRaw(uint64(i), "foo", false))
+		}
+		state.currentLineBuffer = buf
+		layout := NewDefaultLayout(state)
+		perPage := layout.linesPerPage()
+		layout.CalculatePage(state, perPage)
+		return state, layout, perPage
+	}
+
+	moveTo := func(state *Peco, layout *BasicLayout, perPage int, p PagingRequest, n int) {
+		for i := 0; i < n; i++ {
+			layout.MovePage(state, p)
+			layout.CalculatePage(state, perPage)
+		}
+	}
+
+	// The page mode jumps a full page once the cursor moves past the
+	// last line on the screen
+	state, layout, perPage := newState(ScrollModePage)
+	moveTo(state, layout, perPage, ToLineBelow, perPage)
+	if loc := state.Location(); loc.Offset() != perPage {
+		t.Errorf("Expected the page mode to scroll to %d, got %d", perPage, loc.Offset())
+	}
+
+	// The continuous mode scrolls one line at a time
+	state, layout, perPage = newState(ScrollModeContinuous)
+	moveTo(state, layout, perPage, ToLineBelow, perPage)
+	loc := state.Location()
+	if loc.Offset() != 1 {
+		t.Errorf("Expected the continuous mode to scroll by 1 line, got %d", loc.Offset())
+	}
+	moveTo(state, layout, perPage, ToLineAbove, 1)
+	if loc.Offset() != 1 {
+		t.Errorf("Expected the screen not to scroll while the cursor is visible, got %d", loc.Offset())
+	}
+
+	// Scrolling by a page keeps the cursor on the same row
+	moveTo(state, layout, perPage, ToScrollPageDown, 1)
+	if row := loc.LineNumber() - loc.Offset(); row != perPage-2 || loc.Offset() != 1+perPage {
+		t.Errorf("Expected the cursor to stay on row %d at offset %d, got row %d at offset %d", perPage-2, 1+perPage, row, loc.Offset())
+	}
+
+	// The screen is kept full when there are fewer lines
+	buf := state.CurrentLineBuffer().(*MemoryBuffer)
+	buf.lines = buf.lines[:perPage+2]
+	layout.CalculatePage(state, perPage)
+	if loc.Offset() != 2 {
+		t.Errorf("Expected the offset to be 2 after the lines shrink, got %d", loc.Offset())
+	}
+}
+
+func TestScrollView(t *testing.T) {
+	for _, mode := range []string{ScrollModePage, ScrollModeContinuous} {
+		state := newPeco()
+		state.scrollMode = mode
+		buf := NewMemoryBuffer()
+		for i := 0; i < 20; i++ {
+			buf.lines = append(buf.lines, line.NewRaw(uint64(i), "foo", false))
+		}
+		state.currentLineBuffer = buf
+		layout := NewDefaultLayout(state)
+		perPage := layout.linesPerPage()
+		layout.CalculatePage(state, perPage)
+		loc := state.Location()
+
+		scroll := func(p PagingRequest, n int) {
+			for i := 0; i < n; i++ {
+				layout.MovePage(state, p)
+				layout.CalculatePage(state, perPage)
+			}
+		}
+
+		// The cursor stays on its line while it is on the screen
+		loc.SetLineNumber(3)
+		scroll(ToScrollViewDown, 2)
+		if loc.Offset() != 2 || loc.LineNumber() != 3 {
+			t.Errorf("Expected offset 2 with the cursor on line 3 (mode = %s), got offset %d on line %d", mode, loc.Offset(), loc.LineNumber())
+		}
+
+		// Moving the cursor within the screen keeps the list where it was
+		scroll(ToLineBelow, 1)
+		if loc.Offset() != 2 || loc.LineNumber() != 4 {
+			t.Errorf("Expected offset 2 with the cursor on line 4 (mode = %s), got offset %d on line %d", mode, loc.Offset(), loc.LineNumber())
+		}
+
+		// The cursor is pulled along once its line leaves the screen
+		scroll(ToScrollViewDown, 4)
+		if loc.Offset() != 6 || loc.LineNumber() != 6 {
+			t.Errorf("Expected the cursor to be pulled to line 6 (mode = %s), got offset %d on line %d", mode, loc.Offset(), loc.LineNumber())
+		}
+
+		// The list does not scroll past the last line
+		scroll(ToScrollViewDown, 20)
+		if max := 20 - perPage; loc.Offset() != max || loc.LineNumber() != max {
+			t.Errorf("Expected the list to stop at offset %d (mode = %s), got offset %d on line %d", max, mode, loc.Offset(), loc.LineNumber())
+		}
+
+		scroll(ToScrollViewUp, 20)
+		if loc.Offset() != 0 || loc.LineNumber() != perPage-1 {
+			t.Errorf("Expected the cursor to be pulled to line %d (mode = %s), got offset %d on line %d", perPage-1, mode, loc.Offset(), loc.LineNumber())
+		}
+	}
+
+	// In the bottom-up layout the list scrolls toward the lines that
+	// come after, which are displayed above
+	state := newPeco()
+	state.layoutType = LayoutTypeBottomUp
+	buf := NewMemoryBuffer()
+	for i := 0; i < 20; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), "foo", false))
+	}
+	state.currentLineBuffer = buf
+	layout := NewBottomUpLayout(state)
+	perPage := layout.linesPerPage()
+	layout.CalculatePage(state, perPage)
+	layout.MovePage(state, ToScrollViewUp)
+	layout.CalculatePage(state, perPage)
+	if loc := state.Location(); loc.Offset() != 1 || loc.LineNumber() != 1 {
+		t.Errorf("Expected offset 1 with the cursor on line 1, got offset %d on line %d", loc.Offset(), loc.LineNumber())
+	}
+}
+
+func TestJumpToLine(t *testing.T) {
+	for _, mode := range []string{ScrollModePage, ScrollModeContinuous} {
+		state := newPeco()
+		state.scrollMode = mode
+		buf := NewMemoryBuffer()
+		for i := 0; i < 20; i++ {
+			buf.lines = append(buf.lines, line.NewRaw(uint64(i), "foo", false))
+		}
+		state.currentLineBuffer = buf
+		layout := NewDefaultLayout(state)
+		perPage := layout.linesPerPage()
+		layout.CalculatePage(state, perPage)
+		loc := state.Location()
+
+		jump := func(p PagingRequest) bool {
+			moved := layout.MovePage(state, p)
+			layout.CalculatePage(state, perPage)
+			return moved
+		}
+
+		// The view follows the cursor to the last line
+		jump(ToLastLine)
+		if loc.LineNumber() != 19 || loc.Offset() > 19 || loc.Offset()+perPage <= 19 {
+			t.Errorf("Expected the cursor on line 19 to be on the screen (mode = %s), got offset %d on line %d", mode, loc.Offset(), loc.LineNumber())
+		}
+
+		// The middle of the lines on the screen, which may not fill it
+		jump(ToMiddleLine)
+		n := minOf(perPage, 20-loc.Offset())
+		if expected := loc.Offset() + (n-1)/2; loc.LineNumber() != expected {
+			t.Errorf("Expected the cursor on line %d (mode = %s), got line %d", expected, mode, loc.LineNumber())
+		}
+
+		jump(ToFirstLine)
+		if loc.LineNumber() != 0 || loc.Offset() != 0 {
+			t.Errorf("Expected the cursor on line 0 at offset 0 (mode = %s), got offset %d on line %d", mode, loc.Offset(), loc.LineNumber())
+		}
+
+		jump(ToMiddleLine)
+		if expected := (perPage - 1) / 2; loc.LineNumber() != expected {
+			t.Errorf("Expected the cursor on line %d (mode = %s), got line %d", expected, mode, loc.LineNumber())
+		}
+	}
+
+	// Nothing happens without any lines
+	state := newPeco()
+	state.currentLineBuffer = NewMemoryBuffer()
+	layout := NewDefaultLayout(state)
+	for _, p := range []PagingRequestType{ToFirstLine, ToLastLine, ToMiddleLine} {
+		if layout.MovePage(state, p) {
+			t.Errorf("Expected %s not to move the cursor without any lines", p)
+		}
+	}
+}
+
+func TestHeaderLines(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	state := newPeco()
+	state.hub = nullHub{}
+	state.config.HeaderLines = 2
+	src := NewSource("-", strings.NewReader("NAME SIZE\n---- ----\nfoo  1\nbar  2\n"), ig, 0, false)
+	go src.Setup(ctx, state)
+	<-src.SetupDone()
+	state.source = src
+	state.currentLineBuffer = src
+	if err := state.populateFilters(); err != nil {
+		t.Errorf("populateFilters failed: %s", err)
+		return
+	}
+
+	screen := NewDummyScreen()
+	rowAt := func(y int) string {
+		cells := make([]rune, screen.width)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[1].(int) == y {
+				cells[ev[0].(int)] = ev[2].(rune)
+			}
+		}
+		return string(cells)
+	}
+
+	for _, layoutType := range []string{LayoutTypeTopDown, LayoutTypeBottomUp} {
+		screen.interceptor.reset()
+		state.screen = screen
+		state.Location().SetLineNumber(0)
+		var layout *BasicLayout
+		// The header lines are between the prompt and the list, and
+		// the list starts right after them
+		headerRow, listRow := 1, 3
+		if layoutType == LayoutTypeTopDown {
+			layout = NewDefaultLayout(state)
+		} else {
+			layout = NewBottomUpLayout(state)
+			headerRow, listRow = screen.height-4, screen.height-5
+		}
+		layout.DrawScreen(state, &DrawOptions{DisableCache: true})
+
+		if perPage := layout.linesPerPage(); perPage != screen.height-4 {
+			t.Errorf("Expected %d lines per page (layout = %s), got %d", screen.height-4, layoutType, perPage)
+		}
+		if row := rowAt(headerRow); !strings.HasPrefix(row, "NAME SIZE") {
+			t.Errorf("Expected the header on row %d (layout = %s), got %q", headerRow, layoutType, row)
+		}
+		if row := rowAt(headerRow + 1); !strings.HasPrefix(row, "---- ----") {
+			t.Errorf("Expected the second header on row %d (layout = %s), got %q", headerRow+1, layoutType, row)
+		}
+		if row := rowAt(listRow); !strings.HasPrefix(row, "foo  1") {
+			t.Errorf("Expected the first line on row %d (layout = %s), got %q", listRow, layoutType, row)
+		}
+
+		// The header lines can't be clicked on
+		if i, ok := layout.list.lineIndexAt(state, layout.linesPerPage(), headerRow); ok {
+			t.Errorf("Expected no line on the header row (layout = %s), got %d", layoutType, i)
+		}
+		if i, ok := layout.list.lineIndexAt(state, layout.linesPerPage(), listRow); !ok || i != 0 {
+			t.Errorf("Expected line 0 on row %d (layout = %s), got %d (%t)", listRow, layoutType, i, ok)
+		}
+	}
+}
+
+func TestMatchedStyle(t *testing.T) {
+	state := newPeco()
+	screen := NewDummyScreen()
+	styles := NewStyleSet()
+	styles.Selected = Style{fg: termbox.ColorDefault, bg: termbox.ColorMagenta}
+	styles.Matched = Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault}
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 2; i++ {
+		buf.lines = append(buf.lines, line.NewMatched(line.NewRaw(uint64(i), "foobar", false), [][]int{{0, 3}}))
+	}
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(2)
+	loc.SetPage(1)
+	loc.SetLineNumber(0)
+
+	// cellAt returns the attributes of the cell last drawn at x, y
+	cellAt := func(x, y int) (termbox.Attribute, termbox.Attribute) {
+		var fg, bg termbox.Attribute
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[0].(int) == x && ev[1].(int) == y {
+				fg = ev[3].(termbox.Attribute)
+				bg = ev[4].(termbox.Attribute)
+			}
+		}
+		return fg, bg
+	}
+
+	draw := func() {
+		screen.interceptor.reset()
+		list := NewListArea(screen, AnchorTop, 0, true, styles)
+		list.Draw(state, nil, 2, &DrawOptions{DisableCache: true})
+	}
+
+	// Without SelectedMatched, the matched characters on the cursor
+	// line use the Matched foreground on the selection background
+	draw()
+	if fg, bg := cellAt(0, 0); fg != termbox.ColorCyan || bg != termbox.ColorMagenta {
+		t.Errorf("Expected matched char on the cursor line to be cyan on magenta, got %d on %d", fg, bg)
+	}
+	if fg, bg := cellAt(0, 1); fg != termbox.ColorCyan || bg != termbox.ColorDefault {
+		t.Errorf("Expected matched char on other lines to be cyan on default, got %d on %d", fg, bg)
+	}
+	if fg, bg := cellAt(3, 0); fg != termbox.ColorDefault || bg != termbox.ColorMagenta {
+		t.Errorf("Expected unmatched char on the cursor line to use the selection style, got %d on %d", fg, bg)
+	}
+
+	styles.SelectedMatched = Style{fg: termbox.ColorYellow | termbox.AttrBold, bg: termbox.ColorDefault}
+	draw()
+	if fg, bg := cellAt(0, 0); fg != termbox.ColorYellow|termbox.AttrBold || bg != termbox.ColorMagenta {
+		t.Errorf("Expected matched char on the cursor line to be bold yellow on magenta, got %d on %d", fg, bg)
+	}
+	if fg, _ := cellAt(0, 1); fg != termbox.ColorCyan {
+		t.Errorf("Expected matched char on other lines to still use Matched, got %d", fg)
+	}
+
+	// A background given to SelectedMatched wins over the selection
+	styles.SelectedMatched.bg = termbox.ColorGreen
+	draw()
+	if _, bg := cellAt(0, 0); bg != termbox.ColorGreen {
+		t.Errorf("Expected matched char on the cursor line to be on green, got %d", bg)
+	}
+}
+
+func TestPromptNoMatchStyle(t *testing.T) {
+	state := newPeco()
+	if err := state.populateFilters(); err != nil {
+		t.Errorf("populateFilters failed: %s", err)
+		return
+	}
+	screen := NewDummyScreen()
+	styles := NewStyleSet()
+	styles.PromptNoMatch = Style{fg: termbox.ColorRed, bg: termbox.ColorDefault}
+
+	// promptFg returns the foreground of the first character of the
+	// prompt, as drawn with the lines in buf
+	promptFg := func(buf Buffer) termbox.Attribute {
+		state.currentLineBuffer = buf
+		screen.interceptor.reset()
+		NewUserPrompt(screen, AnchorTop, 0, "QUERY>", styles).Draw(state)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[0].(int) == 0 && ev[1].(int) == 0 {
+				return ev[3].(termbox.Attribute)
+			}
+		}
+		return 0
+	}
+
+	if fg := promptFg(NewMemoryBuffer()); fg != termbox.ColorRed {
+		t.Errorf("Expected prompt to be red without matches, got %d", fg)
+	}
+
+	state.addFiltersRunning(1)
+	if fg := promptFg(NewMemoryBuffer()); fg != termbox.ColorDefault {
+		t.Errorf("Expected prompt to keep its style while the query is running, got %d", fg)
+	}
+	state.addFiltersRunning(-1)
+
+	buf := NewMemoryBuffer()
+	buf.lines = append(buf.lines, line.NewRaw(0, "foo", false))
+	if fg := promptFg(buf); fg != termbox.ColorDefault {
+		t.Errorf("Expected prompt to revert once lines match, got %d", fg)
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	state := newPeco()
+	state.hub = nullHub{}
+	var input string
+	for i := 1; i <= 10; i++ {
+		input += fmt.Sprintf("line%d %s\n", i, strings.Repeat("x", 100))
+	}
+	src := NewSource("-", strings.NewReader(input), ig, 0, false)
+	go src.Setup(ctx, state)
+	<-src.SetupDone()
+	state.source = src
+	state.showLineNumbers = true
+
+	// The lines are displayed in another order than they were read,
+	// and the numbers stay those of the input
+	buf := NewMemoryBuffer()
+	for _, i := range []int{9, 1} {
+		l, err := src.LineAt(i)
+		if err != nil {
+			t.Errorf("Expected line %d to be read: %s", i, err)
+			return
+		}
+		buf.lines = append(buf.lines, line.NewMatched(l, [][]int{{0, 4}}))
+	}
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(2)
+	loc.SetPage(1)
+
+	screen := NewDummyScreen()
+	styles := NewStyleSet()
+	styles.LineNumber = Style{fg: termbox.ColorYellow, bg: termbox.ColorDefault}
+
+	// rowAt returns the characters and the foreground colors last drawn
+	// on row y
+	rowAt := func(y int) (string, []termbox.Attribute) {
+		cells := make([]rune, screen.width)
+		fgs := make([]termbox.Attribute, screen.width)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[1].(int) == y {
+				cells[ev[0].(int)] = ev[2].(rune)
+				fgs[ev[0].(int)] = ev[3].(termbox.Attribute)
+			}
+		}
+		return string(cells), fgs
+	}
+
+	draw := func() {
+		screen.interceptor.reset()
+		list := NewListArea(screen, AnchorTop, 0, true, styles)
+		list.Draw(state, nil, 2, &DrawOptions{DisableCache: true})
+	}
+
+	draw()
+	row, fgs := rowAt(0)
+	if !strings.HasPrefix(row, "10 line10 ") {
+		t.Errorf("Expected line number to be drawn in the gutter, got %q", row)
+		return
+	}
+	if fgs[0] != termbox.ColorYellow {
+		t.Errorf("Expected line number to use the LineNumber style, got %d", fgs[0])
+		return
+	}
+	if fgs[3] != styles.Matched.fg {
+		t.Errorf("Expected matches to be shifted by the gutter, got %d", fgs[3])
+		return
+	}
+	if row, _ = rowAt(1); !strings.HasPrefix(row, " 2 line2 ") {
+		t.Errorf("Expected line number to be right aligned, got %q", row)
+		return
+	}
+
+	// Scrolling moves the lines under the gutter, but not the numbers
+	loc.SetColumn(4)
+	draw()
+	if row, _ = rowAt(0); !strings.HasPrefix(row, "10 10 xx") {
+		t.Errorf("Expected gutter not to be scrolled, got %q", row)
+	}
+}
+
+func TestLineWrap(t *testing.T) {
+	state := newPeco()
+	state.hub = nullHub{}
+	state.lineWrap = true
+
+	buf := NewMemoryBuffer()
+	long := "foo" + strings.Repeat("x", 90) + "bar"
+	buf.lines = append(buf.lines,
+		line.NewMatched(line.NewRaw(0, long, false), [][]int{{75, 85}}),
+		line.NewMatched(line.NewRaw(1, "short", false), nil),
+	)
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(2)
+	loc.SetPage(1)
+
+	screen := NewDummyScreen()
+	styles := NewStyleSet()
+
+	rowAt := func(y int) (string, []termbox.Attribute) {
+		cells := make([]rune, screen.width)
+		fgs := make([]termbox.Attribute, screen.width)
+		for _, ev := range screen.interceptor.events["SetCell"] {
+			if ev[1].(int) == y {
+				cells[ev[0].(int)] = ev[2].(rune)
+				fgs[ev[0].(int)] = ev[3].(termbox.Attribute)
+			}
+		}
+		return string(cells), fgs
+	}
+
+	list := NewListArea(screen, AnchorTop, 0, true, styles)
+	list.Draw(state, nil, 4, &DrawOptions{DisableCache: true})
+
+	// The long line continues on the next row, along with its match
+	row, fgs := rowAt(0)
+	if !strings.HasPrefix(row, "foox") || strings.HasSuffix(row, "bar") {
+		t.Errorf("Expected the first row to hold the start of the line, got %q", row)
+		return
+	}
+	if fgs[79] != styles.Matched.fg {
+		t.Errorf("Expected the match to be highlighted at the end of the row, got %d", fgs[79])
+		return
+	}
+	row, fgs = rowAt(1)
+	if !strings.HasPrefix(row, "xxxxxxxxxxxxxbar") {
+		t.Errorf("Expected the second row to hold the rest of the line, got %q", row)
+		return
+	}
+	if fgs[0] != styles.Matched.fg || fgs[5] == styles.Matched.fg {
+		t.Errorf("Expected the match to continue on the second row, got %v", fgs[:6])
+		return
+	}
+	if row, _ = rowAt(2); !strings.HasPrefix(row, "short") {
+		t.Errorf("Expected the next line right after the wrapped rows, got %q", row)
+		return
+	}
+
+	// Rows map to the lines they were drawn for
+	for y, expected := range []int{0, 0, 1} {
+		if i, ok := list.lineIndexAt(state, 4, y); !ok || i != expected {
+			t.Errorf("Expected line %d at row %d, got %d (%t)", expected, y, i, ok)
+		}
+	}
+	if i, ok := list.lineIndexAt(state, 4, 3); ok {
+		t.Errorf("Expected no line at row 3, got %d", i)
+	}
+
+	// The first line moves only as far as needed to show the line under
+	// the cursor in full
+	rowsOf := func(i int) int { return []int{1, 3, 1, 2, 1}[i] }
+	loc = &Location{}
+	loc.SetLineNumber(3)
+	if n := calculateWrappedOffset(loc, 5, 4, rowsOf); loc.Offset() != 2 || n != 3 {
+		t.Errorf("Expected offset 2 with 3 lines, got %d with %d lines", loc.Offset(), n)
+	}
+	loc.SetLineNumber(1)
+	if n := calculateWrappedOffset(loc, 5, 4, rowsOf); loc.Offset() != 1 || n != 2 {
+		t.Errorf("Expected offset 1 with 2 lines, got %d with %d lines", loc.Offset(), n)
+	}
+}
+
+func TestListAreaHighlights(t *testing.T) {
+	state := newPeco()
+	state.tabWidth = 4
+	screen := NewDummyScreen()
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 4; i++ {
+		buf.lines = append(buf.lines, line.NewMatched(line.NewRaw(uint64(i), "\tfoobar", false), [][]int{{1, 4}}))
+	}
+	state.currentLineBuffer = buf
+	loc := state.Location()
+	loc.SetPerPage(4)
+	loc.SetPage(1)
+
+	list := NewListArea(screen, AnchorTop, 0, true, NewStyleSet())
+	list.Draw(state, nil, 4, &DrawOptions{DisableCache: true})
+	h, ok := list.highlights[buf.lines[0]]
+	if !ok {
+		t.Errorf("Expected the highlight of the first line to be cached")
+		return
+	}
+	if h.text != "    foobar" || fmt.Sprint(h.matches) != "[[4 7]]" {
+		t.Errorf("Expected the tabs to be expanded in the highlight, got %q with %v", h.text, h.matches)
+	}
+
+	// Drawing the lines again reuses what was computed
+	list.Draw(state, nil, 4, &DrawOptions{DisableCache: true})
+	if list.highlights[buf.lines[0]] != h {
+		t.Errorf("Expected the highlight to be reused")
+	}
+
+	// The results of another query do not
+	other := NewMemoryBuffer()
+	other.lines = append(other.lines, line.NewMatched(line.NewRaw(0, "foobar", false), [][]int{{3, 6}}))
+	state.currentLineBuffer = other
+	list.Draw(state, nil, 4, &DrawOptions{DisableCache: true})
+	if _, ok := list.highlights[buf.lines[0]]; ok || len(list.highlights) != 1 {
+		t.Errorf("Expected the highlights to be cleared for the new buffer, got %d", len(list.highlights))
+	}
+}
+
+// discardScreen is a dummyScreen that does not record the cells drawn,
+// so that benchmarks measure the drawing rather than the recording
+type discardScreen struct {
+	*dummyScreen
+}
+
+func (d discardScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {}
+
+func (d discardScreen) Print(args PrintArgs) int {
+	return screenPrint(d, args)
+}
+
+func BenchmarkListAreaScroll(b *testing.B) {
+	state := newPeco()
+	state.ansiColors = true
+	state.tabWidth = 4
+	screen := discardScreen{NewDummyScreen()}
+
+	var lines []line.Line
+	for i := 0; i < 400; i++ {
+		lines = append(lines, line.NewRaw(uint64(i), fmt.Sprintf("\x1b[32msrc/foo\x1b[0m\tbar_%d.go:\t\x1b[1mfunc\x1b[0m baz%d()", i, i), false))
+	}
+	f := filter.NewRegexp()
+	ctx := f.NewContext(context.Background(), `bar_\d+ baz\d`)
+	ch := make(chan interface{}, len(lines))
+	if err := f.Apply(ctx, lines, pipeline.ChanOutput(ch)); err != nil {
+		b.Fatal(err)
+	}
+	close(ch)
+	buf := NewMemoryBuffer()
+	for v := range ch {
+		buf.lines = append(buf.lines, v.(line.Line))
+	}
+	state.currentLineBuffer = buf
+
+	perPage := 8
+	loc := state.Location()
+	loc.SetPerPage(perPage)
+	list := NewListArea(screen, AnchorTop, 0, true, NewStyleSet())
+
+	// Every page draws other lines than the previous one, so the lines
+	// drawn are never those displayed at the same row
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page := i%(len(buf.lines)/perPage) + 1
+		loc.SetPage(page)
+		loc.SetOffset((page - 1) * perPage)
+		loc.SetLineNumber((page - 1) * perPage)
+		list.Draw(state, nil, perPage, nil)
+	}
+}
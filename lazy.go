@@ -0,0 +1,246 @@
+package peco
+
+import (
+	"context"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+const (
+	// lazyFilterMargin is the number of pages matched ahead of the
+	// scroll position with LazyFilter
+	lazyFilterMargin = 2
+	// lazyFilterMinLines is the least number of lines that a query
+	// matches at a time with LazyFilter
+	lazyFilterMinLines = 100
+)
+
+// lazyFilterApplies returns true if the query may stop matching once
+// enough lines around the scroll position are matched. This is not
+// possible if the results depend on the lines that come after, such as
+// when they are sorted, ranked or displayed in the reverse order
+func (p *Peco) lazyFilterApplies(f filter.Filter, sortMode string) bool {
+	if !p.lazyFilter || p.reverse || p.maxResults > 0 {
+		return false
+	}
+	if isSorted(sortMode) || p.uniqueLines() {
+		return false
+	}
+
+	filters := []filter.Filter{f}
+	if chain, ok := f.(*filter.Chain); ok {
+		filters = chain.Links()
+	}
+	for _, f := range filters {
+		if _, ok := f.(*filter.FuzzyRanked); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// lazyFilterWindow returns the number of matches needed to fill the
+// pages up to the scroll position, plus the margin
+func lazyFilterWindow(loc *Location) int {
+	return maxOf(loc.Offset()+loc.PerPage()*(1+lazyFilterMargin), lazyFilterMinLines)
+}
+
+// setLazyWindow records the number of matches that LazyFilter needs
+// for the scroll position. The location is only accessed by the view,
+// so it is computed there, as the page is calculated, for the filter
+// to read it from the goroutines it runs on
+func (p *Peco) setLazyWindow(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lazyWindow = n
+}
+
+// lazyFilterLimit returns the number of matches that LazyFilter needs,
+// as recorded by setLazyWindow
+func (p *Peco) lazyFilterLimit() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return maxOf(p.lazyWindow, lazyFilterMinLines)
+}
+
+func (p *Peco) setLazyRun(run *lazyFilterRun) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lazyRun = run
+}
+
+// lazyFilterRun returns the run of LazyFilter that has yet to
+// match the rest of the input for the current results, or nil
+func (p *Peco) lazyFilterRun() *lazyFilterRun {
+	buf := p.CurrentLineBuffer()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	run := p.lazyRun
+	if run == nil || run.ctx.Err() != nil {
+		return nil
+	}
+	if rb, ok := buf.(*reversedBuffer); ok {
+		buf = rb.src
+	}
+	if buf != Buffer(run.buf) {
+		return nil
+	}
+	return run
+}
+
+// ResultsApproximate returns true while LazyFilter has not matched the
+// entire input, so that more lines may match once the list is scrolled
+func (p *Peco) ResultsApproximate() bool {
+	return p.lazyFilterRun() != nil
+}
+
+// extendLazyFilter matches more of the input when the list is scrolled
+// close to the last line matched so far by LazyFilter
+func (p *Peco) extendLazyFilter() {
+	run := p.lazyFilterRun()
+	if run == nil {
+		return
+	}
+
+	window := p.lazyFilterLimit()
+	size := run.buf.Size()
+	if size >= window {
+		return
+	}
+
+	p.mutex.Lock()
+	if run.running {
+		p.mutex.Unlock()
+		return
+	}
+	run.running = true
+	p.addSourceUser(run.src, 1)
+	p.mutex.Unlock()
+
+	from := run.from
+	if size > 0 {
+		if l, err := run.buf.LineAt(size - 1); err == nil {
+			if i, ok := run.src.inputIndex(l.ID()); ok && i+1 > from {
+				from = i + 1
+			}
+		}
+	}
+
+	go p.runLazyFilter(run, from, window-size)
+}
+
+// runLazyFilter matches the input from the from-th line, until n more
+// lines are matched, and appends them to the results
+func (p *Peco) runLazyFilter(run *lazyFilterRun, from, n int) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.runLazyFilter (from = %d, n = %d)", from, n)
+		defer g.End()
+	}
+
+	// Once the input has been read completely and the rest of it has
+	// been matched, the results are no longer approximate
+	var setupDone bool
+	select {
+	case <-run.src.SetupDone():
+		setupDone = true
+	default:
+	}
+
+	src := &lazySource{source: run.src, from: from}
+	pl := pipeline.New()
+	pl.SetSource(src)
+	for _, n := range run.nodes() {
+		pl.Add(n)
+	}
+	limit := pipeline.Limit(n)
+	pl.Add(limit)
+	pl.SetDestination(&lazyDestination{buf: run.buf})
+
+	err := pl.RunWithTimeout(run.ctx, pipelineDrainTimeout)
+
+	p.mutex.Lock()
+	run.running = false
+	p.addSourceUser(run.src, -1)
+	if err == nil && run.ctx.Err() == nil {
+		if !limit.Limited() {
+			run.from = src.upto
+			if setupDone && p.lazyRun == run {
+				p.lazyRun = nil
+			}
+		}
+	}
+	p.mutex.Unlock()
+
+	if err != nil {
+		p.ShowMessage(err.Error(), 0, MessageError)
+		return
+	}
+	p.Hub().SendDraw(&DrawOptions{DisableCache: true})
+
+	// The list may have been scrolled further in the mean time
+	p.extendLazyFilter()
+}
+
+// Start sends the lines of the input from the from-th line that have
+// been read so far
+func (s *lazySource) Start(ctx context.Context, out pipeline.ChanOutput) {
+	defer out.SendEndMarkCtx(ctx, "end of input")
+
+	lines, discarded := s.source.retained()
+	s.upto = discarded + len(lines)
+	start := s.from - discarded
+	if start < 0 {
+		start = 0
+	}
+	for _, l := range lines[minOf(start, len(lines)):] {
+		if err := out.SendCtx(ctx, l); err != nil {
+			return
+		}
+	}
+}
+
+// Reset is a no-op, as the lines to send are fixed when Start is called
+func (s *lazySource) Reset() {}
+
+// Reset prepares the destination for a new run. The lines are appended
+// to the existing results, which are kept as they are
+func (d *lazyDestination) Reset() {
+	d.done = make(chan struct{})
+}
+
+// Done returns the channel that is closed once the lines are appended
+func (d *lazyDestination) Done() <-chan struct{} {
+	return d.done
+}
+
+// Accept appends the lines it receives to the results
+func (d *lazyDestination) Accept(ctx context.Context, in chan interface{}, _ pipeline.ChanOutput) {
+	defer close(d.done)
+	defer d.buf.rankLines()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				return
+			}
+			if l, ok := filter.RankedLine(v); ok {
+				v = l
+			}
+			switch v := v.(type) {
+			case error:
+				if pipeline.IsEndMark(v) {
+					return
+				}
+			case line.Line:
+				d.buf.appendLine(v)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package line
+
+import (
+	"strings"
+
+	"github.com/peco/peco/internal/util"
+)
+
+// NewColumn creates a new Column out of rl. The text displayed for rl
+// is split at the first occurrence of delim: the text before it is
+// displayed, and the text after it is matched against the queries.
+// Lines without delim are displayed and matched as a whole. If
+// outputMatch is true, the matched text is output instead of the
+// displayed text
+func NewColumn(rl *Raw, delim string, outputMatch bool) *Column {
+	v := rl.buf
+	if i := rl.sepLoc; i > -1 {
+		v = v[:i]
+	}
+
+	cl := &Column{
+		Raw:         rl,
+		display:     v,
+		match:       v,
+		outputMatch: outputMatch,
+	}
+	if i := strings.Index(v, delim); i != -1 {
+		cl.display = v[:i]
+		cl.match = v[i+len(delim):]
+	}
+	return cl
+}
+
+// DisplayString returns the text before the delimiter
+func (cl Column) DisplayString() string {
+	return util.StripANSISequence(cl.display)
+}
+
+// rawDisplayString returns the text before the delimiter, including
+// its ANSI escape sequences
+func (cl Column) rawDisplayString() string {
+	return cl.display
+}
+
+// MatchString returns the text after the delimiter
+func (cl Column) MatchString() string {
+	return util.StripANSISequence(cl.match)
+}
+
+// Output returns the matched text if the column was created to output
+// it. Otherwise the displayed text is output, unless a null separator
+// specifies what to output
+func (cl Column) Output() string {
+	if cl.outputMatch {
+		return cl.match
+	}
+	if cl.sepLoc > -1 {
+		return cl.Raw.Output()
+	}
+	return cl.display
+}
+
+// Unwrap returns the line that l was created from, if l only adds the
+// information about a match to it
+func Unwrap(l Line) Line {
+	for {
+		switch v := l.(type) {
+		case *Matched:
+			l = v.Line
+		case *Scored:
+			l = v.Line
+		default:
+			return l
+		}
+	}
+}
+
+// Weight returns the weight that l was read with, 0 if it has none
+func Weight(l Line) float64 {
+	for {
+		switch v := l.(type) {
+		case weighter:
+			return v.Weight()
+		case *Matched:
+			l = v.Line
+		case *Scored:
+			l = v.Line
+		case *Transformed:
+			l = v.Line
+		default:
+			return 0
+		}
+	}
+}
+
+// RawDisplayString returns the string that is displayed for l, before
+// the ANSI escape sequences are stripped from it
+func RawDisplayString(l Line) string {
+	if r, ok := Unwrap(l).(rawDisplayer); ok {
+		return r.rawDisplayString()
+	}
+	return l.DisplayString()
+}
+
+// MatchString returns the string that queries are matched against for
+// l. This is the string that is displayed, unless l is a Matcher
+func MatchString(l Line) string {
+	if m, ok := Unwrap(l).(Matcher); ok {
+		return m.MatchString()
+	}
+	return l.DisplayString()
+}
@@ -41,7 +41,52 @@ type Raw struct {
 	buf           string
 	sepLoc        int
 	displayString string
-	dirty         bool
+	dirty         uint32 // accessed atomically, see SetDirty
+	weight        float64
+}
+
+// Column is a Raw line that is split into the text that is displayed,
+// and the text that queries are matched against
+type Column struct {
+	*Raw
+	display     string
+	match       string
+	outputMatch bool
+}
+
+// Record is a Raw line that holds a record of several lines of the
+// input. A summary of the record is displayed, while the whole record
+// is matched against the queries and output
+type Record struct {
+	*Raw
+	summary string
+}
+
+// Transformed is a line whose text was rewritten before it is matched
+// and displayed
+type Transformed struct {
+	Line
+	transform      func(string) string
+	display        string
+	match          string
+	outputOriginal bool
+}
+
+// Matcher is implemented by lines that queries are matched against
+// using a string other than the one that is displayed
+type Matcher interface {
+	MatchString() string
+}
+
+// weighter is implemented by lines that were read with a weight
+type weighter interface {
+	Weight() float64
+}
+
+// rawDisplayer is implemented by lines that keep the ANSI escape
+// sequences of the text that they display
+type rawDisplayer interface {
+	rawDisplayString() string
 }
 
 // Matched contains the indices to the matches
@@ -50,4 +95,9 @@ type Matched struct {
 	indices [][]int
 }
 
-
+// Scored is a Matched line that also carries a score, which is used
+// to rank the lines from best to worst match
+type Scored struct {
+	*Matched
+	score int
+}
@@ -10,3 +10,12 @@ func (ml Matched) Indices() [][]int {
 	return ml.indices
 }
 
+// NewScored creates a new Scored
+func NewScored(rl Line, matches [][]int, score int) *Scored {
+	return &Scored{NewMatched(rl, matches), score}
+}
+
+// Score returns the score given to this line
+func (sl Scored) Score() int {
+	return sl.score
+}
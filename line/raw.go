@@ -1,7 +1,9 @@
 package line
 
 import (
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/google/btree"
 	"github.com/peco/peco/internal/util"
@@ -10,24 +12,41 @@ import (
 // NewRaw creates a new Raw. The `enableSep` flag tells
 // it if we should search for a null character to split the
 // string to display and the string to emit upon selection of
-// of said line
+// of said line. The string to display is computed right away, as
+// the line is read from several goroutines afterwards
 func NewRaw(id uint64, v string, enableSep bool) *Raw {
 	rl := &Raw{
-		id:            id,
-		buf:           v,
-		sepLoc:        -1,
-		displayString: "",
-		dirty:         false,
+		id:     id,
+		buf:    v,
+		sepLoc: -1,
 	}
 
-	if !enableSep {
-		return rl
+	if enableSep {
+		if i := strings.IndexByte(rl.buf, '\000'); i != -1 {
+			rl.sepLoc = i
+		}
 	}
+	rl.displayString = util.StripANSISequence(rl.rawDisplayString())
+	return rl
+}
 
-	if i := strings.IndexByte(rl.buf, '\000'); i != -1 {
-		rl.sepLoc = i
+// NewWeighted creates a new Raw out of v, which starts with a numeric
+// weight followed by delim, such as "0.92\ttext". The weight and the
+// delimiter are not part of the line. If the weight cannot be parsed,
+// it is 0, and false is returned
+func NewWeighted(id uint64, v string, enableSep bool, delim string) (*Raw, bool) {
+	i := strings.Index(v, delim)
+	if i == -1 {
+		return NewRaw(id, v, enableSep), false
 	}
-	return rl
+
+	rl := NewRaw(id, v[i+len(delim):], enableSep)
+	w, err := strconv.ParseFloat(strings.TrimSpace(v[:i]), 64)
+	if err != nil {
+		return rl, false
+	}
+	rl.weight = w
+	return rl, true
 }
 
 // Less implements the btree.Item interface
@@ -41,36 +60,48 @@ func (rl *Raw) ID() uint64 {
 }
 
 // IsDirty returns true if this line must be redrawn on the terminal
-func (rl Raw) IsDirty() bool {
-	return rl.dirty
+func (rl *Raw) IsDirty() bool {
+	return atomic.LoadUint32(&rl.dirty) != 0
 }
 
-// SetDirty sets the dirty flag
+// SetDirty sets the dirty flag. The view sets it as it draws the line,
+// while the filters may be reading the line, so it is set atomically
 func (rl *Raw) SetDirty(b bool) {
-	rl.dirty = b
+	var v uint32
+	if b {
+		v = 1
+	}
+	atomic.StoreUint32(&rl.dirty, v)
+}
+
+// Weight returns the weight that the line was read with, 0 if it was
+// not created by NewWeighted
+func (rl *Raw) Weight() float64 {
+	return rl.weight
 }
 
 // Buffer returns the raw buffer. May contain null
-func (rl Raw) Buffer() string {
+func (rl *Raw) Buffer() string {
 	return rl.buf
 }
 
-// DisplayString returns the string to be displayed
-func (rl Raw) DisplayString() string {
-	if rl.displayString != "" {
-		return rl.displayString
-	}
+// DisplayString returns the string to be displayed, without its ANSI
+// escape sequences
+func (rl *Raw) DisplayString() string {
+	return rl.displayString
+}
 
+// rawDisplayString returns the string to be displayed, including its
+// ANSI escape sequences
+func (rl *Raw) rawDisplayString() string {
 	if i := rl.sepLoc; i > -1 {
-		rl.displayString = util.StripANSISequence(rl.buf[:i])
-	} else {
-		rl.displayString = util.StripANSISequence(rl.buf)
+		return rl.buf[:i]
 	}
-	return rl.displayString
+	return rl.buf
 }
 
 // Output returns the string to be displayed *after peco is done
-func (rl Raw) Output() string {
+func (rl *Raw) Output() string {
 	if i := rl.sepLoc; i > -1 {
 		return rl.buf[i+1:]
 	}
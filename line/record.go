@@ -0,0 +1,34 @@
+package line
+
+import (
+	"strings"
+
+	"github.com/peco/peco/internal/util"
+)
+
+// NewRecord creates a new Record out of rl, which holds a record of
+// several lines, and displays summary instead of the record. Newlines
+// in summary are displayed as spaces, so that it takes a single row
+func NewRecord(rl *Raw, summary string) *Record {
+	return &Record{
+		Raw:     rl,
+		summary: strings.NewReplacer("\r\n", " ", "\n", " ").Replace(summary),
+	}
+}
+
+// DisplayString returns the summary of the record
+func (r Record) DisplayString() string {
+	return util.StripANSISequence(r.summary)
+}
+
+// rawDisplayString returns the summary of the record, including its
+// ANSI escape sequences
+func (r Record) rawDisplayString() string {
+	return r.summary
+}
+
+// MatchString returns the whole record, or the part of it before the
+// null separator if there is one
+func (r Record) MatchString() string {
+	return r.Raw.DisplayString()
+}
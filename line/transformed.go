@@ -0,0 +1,39 @@
+package line
+
+// NewTransformed creates a new Transformed out of l, which displays and
+// matches the text of l as rewritten by transform. If outputOriginal is
+// true, the output of l is not rewritten
+func NewTransformed(l Line, transform func(string) string, outputOriginal bool) *Transformed {
+	display := l.DisplayString()
+	tl := &Transformed{
+		Line:           l,
+		transform:      transform,
+		display:        transform(display),
+		outputOriginal: outputOriginal,
+	}
+	tl.match = tl.display
+	if match := MatchString(l); match != display {
+		tl.match = transform(match)
+	}
+	return tl
+}
+
+// DisplayString returns the rewritten text of the original line
+func (tl Transformed) DisplayString() string {
+	return tl.display
+}
+
+// MatchString returns the rewritten text that the original line is
+// matched against
+func (tl Transformed) MatchString() string {
+	return tl.match
+}
+
+// Output returns the rewritten output of the original line, unless the
+// line was created to output the original
+func (tl Transformed) Output() string {
+	if tl.outputOriginal {
+		return tl.Line.Output()
+	}
+	return tl.transform(tl.Line.Output())
+}
@@ -0,0 +1,236 @@
+package peco
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// LineWrap returns true if the lines that do not fit in the list area
+// are wrapped, instead of being truncated
+func (p *Peco) LineWrap() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lineWrap
+}
+
+// SetLineWrap changes whether the long lines are wrapped
+func (p *Peco) SetLineWrap(b bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lineWrap = b
+}
+
+// doToggleLineWrap switches between wrapping the lines that do not fit
+// in the list area, and truncating them
+func doToggleLineWrap(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleLineWrap")
+		defer g.End()
+	}
+
+	wrap := !state.LineWrap()
+	state.SetLineWrap(wrap)
+	if wrap {
+		state.Hub().SendStatusMsgAndClear("Wrapping long lines", time.Second)
+	} else {
+		state.Hub().SendStatusMsgAndClear("Truncating long lines", time.Second)
+	}
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
+// lineNumberWidth returns the width of the gutter that the line numbers
+// are displayed in, or 0 if they are not displayed. The gutter is as
+// wide as the largest number, so that it stays put when the lines are
+// scrolled horizontally
+func lineNumberWidth(state *Peco) int {
+	src, _ := state.Source().(*Source)
+	if !state.showLineNumbers || src == nil {
+		return 0
+	}
+	return len(strconv.Itoa(src.inputSize())) + 1
+}
+
+// textColumn returns the column at which the text of the lines starts,
+// after the columns that ListArea.Draw displays before it
+func (l *ListArea) textColumn(state *Peco, gutterWidth, labelWidth int) int {
+	x := gutterWidth
+	if n := len(state.selectionPrefix); n > 0 {
+		x += n + 1
+	}
+	if state.annotator != nil {
+		x += state.annotator.Width() + 1
+	}
+	if state.SingleKeyJumpMode() || state.SingleKeyJumpShowPrefix() {
+		x += 2
+	}
+	if labelWidth > 0 {
+		x += labelWidth + 1
+	}
+	return x
+}
+
+// wrappedRows returns the number of rows that the text of target takes
+// when it starts at column x, and the lines are wrapped at width
+func wrappedRows(target string, x, gutterWidth, width int) int {
+	s := &wrapScreen{width: width, indent: gutterWidth}
+	s.init()
+	screenPrint(s, PrintArgs{X: x, XOffset: -gutterWidth, Msg: target})
+	return s.row + 1
+}
+
+// calculateWrappedOffset is calculateScrollOffset for LineWrap, where
+// lines take as many rows as they need. The first line on the screen
+// moves only as far as needed to keep the line under the cursor on the
+// screen in full. rowsOf returns the number of rows that the i-th line
+// takes. Returns the number of lines that are on the screen, counting
+// the last one even if it is cut off
+func calculateWrappedOffset(loc *Location, total, rows int, rowsOf func(int) int) int {
+	if total == 0 {
+		loc.SetOffset(0)
+		return 1
+	}
+
+	lineno := loc.LineNumber()
+	if lineno >= total {
+		lineno = total - 1
+	}
+
+	// Lines take at least a row each, so there is no need to look at
+	// the lines further than a screen away from the cursor
+	offset := loc.Offset()
+	if offset > lineno {
+		offset = lineno
+	}
+	if offset < lineno-rows+1 {
+		offset = lineno - rows + 1
+	}
+
+	var used int
+	for i := offset; i <= lineno; i++ {
+		used += rowsOf(i)
+	}
+	for used > rows && offset < lineno {
+		used -= rowsOf(offset)
+		offset++
+	}
+
+	// The rest of the screen is filled with the lines after the cursor,
+	// or with those before the first line once there are no more
+	n := lineno - offset + 1
+	i := lineno + 1
+	for ; i < total && used < rows; i++ {
+		used += rowsOf(i)
+		n++
+	}
+	if i == total {
+		for offset > 0 && used+rowsOf(offset-1) <= rows {
+			offset--
+			used += rowsOf(offset)
+			n++
+		}
+	}
+
+	loc.SetOffset(offset)
+	return n
+}
+
+// calculateWrappedPage is CalculatePage for LineWrap. The lines are
+// always scrolled continuously, as pages do not hold a fixed number of
+// lines. The page numbers are those of pages as long as the screen
+func (l *BasicLayout) calculateWrappedPage(state *Peco, rows int) {
+	buf := state.CurrentLineBuffer()
+	loc := state.Location()
+
+	width, _ := l.screen.Size()
+	width -= l.previewWidth(width)
+	gutterWidth := lineNumberWidth(state)
+	x := l.list.textColumn(state, gutterWidth, 0)
+
+	n := calculateWrappedOffset(loc, buf.Size(), rows, func(i int) int {
+		target, err := buf.LineAt(i)
+		if err != nil {
+			return 1
+		}
+		return wrappedRows(state.expandDisplayTabs(target.DisplayString()), x, gutterWidth, width)
+	})
+
+	loc.SetPerPage(n)
+	loc.SetPage(loc.Offset()/n + 1)
+}
+
+// init makes sure that the rows have room for at least one cell after
+// the indent
+func (s *wrapScreen) init() {
+	if s.width < 1 {
+		s.width = 1
+	}
+	if s.indent >= s.width {
+		s.indent = 0
+	}
+}
+
+// Print prints the message onto as many rows as it needs
+func (s *wrapScreen) Print(args PrintArgs) int {
+	return screenPrint(s, args)
+}
+
+// Size returns the width of all the rows put together, so that nothing
+// is clipped at the right edge of the screen
+func (s *wrapScreen) Size() (int, int) {
+	if s.rows == 0 {
+		return math.MaxInt32, 1
+	}
+	return s.width * s.rows, s.rows
+}
+
+// SetCell sets the cell at column x of the line, which is on the row
+// that it wraps to
+func (s *wrapScreen) SetCell(x, _ int, c rune, fg, bg termbox.Attribute) {
+	for s.x < x {
+		s.x++
+		s.col++
+		if s.col >= s.width {
+			s.row++
+			s.col = s.indent
+		}
+	}
+
+	// Wide characters that do not fit at the end of a row go to the
+	// next one, rather than being cut in half
+	if runewidth.RuneWidth(c) == 2 && s.col == s.width-1 && s.width-s.indent > 1 {
+		s.setCell(' ', fg, bg)
+		s.row++
+		s.col = s.indent
+	}
+	s.setCell(c, fg, bg)
+}
+
+func (s *wrapScreen) setCell(c rune, fg, bg termbox.Attribute) {
+	if s.Screen == nil || s.row >= s.rows {
+		return
+	}
+	s.Screen.SetCell(s.col, s.y+s.row, c, fg, bg)
+}
+
+// clearGutter blanks the gutter of the line numbers on the rows that a
+// wrapped line continues on
+func (l *ListArea) clearGutter(y, rows, gutterWidth int, fg, bg termbox.Attribute) {
+	if gutterWidth == 0 {
+		return
+	}
+	for i := 1; i < rows; i++ {
+		l.screen.Print(PrintArgs{
+			Y:   y + i,
+			Fg:  fg,
+			Bg:  bg,
+			Msg: strings.Repeat(" ", gutterWidth),
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package peco
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// macros returns the actions defined as lists of actions, in Action and
+// in Macros. Both are executed like the combined actions of Action
+func (c *Config) macros() map[string][]string {
+	if len(c.Macros) == 0 {
+		return c.Action
+	}
+
+	m := make(map[string][]string, len(c.Action)+len(c.Macros))
+	for name, l := range c.Action {
+		m[name] = l
+	}
+	for name, l := range c.Macros {
+		m[name] = l
+	}
+	return m
+}
+
+// macroProblems checks the lists of actions in Action and Macros. It
+// reports the names that are defined in both, the names in the lists
+// that are not actions, and the lists that end up executing themselves
+func (c *Config) macroProblems() []error {
+	var errs []error
+	macros := c.macros()
+	names := make([]string, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := c.Action[name]; ok {
+			if _, ok := c.Macros[name]; ok {
+				errs = append(errs, errors.Errorf("macro %s is also defined in Action", name))
+			}
+		}
+	}
+
+	isAction := func(name string) bool {
+		if _, ok := nameToActions[name]; ok {
+			return true
+		}
+		if _, ok := macros[name]; ok {
+			return true
+		}
+		_, ok := c.CustomAction[name]
+		return ok
+	}
+	for _, name := range names {
+		for _, child := range macros[name] {
+			if !isAction(child) {
+				errs = append(errs, errors.Errorf("macro %s calls unknown action %s", name, child))
+			}
+		}
+	}
+
+	// Look for cycles by walking down the lists from each macro. A macro
+	// that is found while the macros it calls are being walked calls
+	// itself, and each cycle is reported once, by the first macro in it
+	const (
+		walking = 1
+		walked  = 2
+	)
+	state := map[string]int{}
+	reported := map[string]bool{}
+	var path []string
+	var walk func(string)
+	walk = func(name string) {
+		switch state[name] {
+		case walking:
+			start := 0
+			for i, v := range path {
+				if v == name {
+					start = i
+				}
+			}
+			cycle := strings.Join(append(append([]string{}, path[start:]...), name), " -> ")
+			if !reported[cycle] {
+				reported[cycle] = true
+				errs = append(errs, errors.Errorf("macro %s calls itself: %s", name, cycle))
+			}
+			return
+		case walked:
+			return
+		}
+
+		state[name] = walking
+		path = append(path, name)
+		for _, child := range macros[name] {
+			if _, ok := macros[child]; ok {
+				walk(child)
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = walked
+	}
+	for _, name := range names {
+		walk(name)
+	}
+
+	return errs
+}
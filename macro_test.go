@@ -0,0 +1,77 @@
+package peco
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMacroProblems(t *testing.T) {
+	var cfg Config
+	cfg.Init()
+	cfg.CustomAction = map[string]CustomActionConfig{
+		"macro.Query": {Action: "peco.SetQuery", Args: json.RawMessage(`{"query": "foo"}`)},
+	}
+	cfg.Action = map[string][]string{
+		"my.Combined": {"peco.SelectAll", "peco.Finish"},
+	}
+	cfg.Macros = map[string][]string{
+		"tagAndNext": {"peco.ToggleSelection", "peco.NextSelection"},
+		"queryAll":   {"macro.Query", "my.Combined", "tagAndNext"},
+	}
+	if !assert.Empty(t, cfg.problems(), "valid macros should pass") {
+		return
+	}
+
+	cfg.Macros["broken"] = []string{"peco.SelectUp", "peco.NoSuchAction"}
+	errs := cfg.macroProblems()
+	if assert.Len(t, errs, 1, "unknown action should be reported") {
+		assert.Contains(t, errs[0].Error(), "peco.NoSuchAction", "unknown action should be named")
+	}
+	delete(cfg.Macros, "broken")
+
+	cfg.Macros["loop"] = []string{"peco.SelectUp", "loop", "loop"}
+	cfg.Macros["ping"] = []string{"pong"}
+	cfg.Macros["pong"] = []string{"queryAll", "ping"}
+	errs = cfg.macroProblems()
+	if assert.Len(t, errs, 2, "each cycle should be reported once") {
+		assert.Equal(t, "macro loop calls itself: loop -> loop", errs[0].Error(), "direct recursion should be reported")
+		assert.Equal(t, "macro ping calls itself: ping -> pong -> ping", errs[1].Error(), "indirect recursion should be reported")
+	}
+	delete(cfg.Macros, "loop")
+	delete(cfg.Macros, "ping")
+	delete(cfg.Macros, "pong")
+
+	cfg.Macros["my.Combined"] = []string{"peco.SelectAll"}
+	assert.NotEmpty(t, cfg.problems(), "macro defined in Action should be reported")
+}
+
+func TestMacroExecute(t *testing.T) {
+	state := newPeco()
+	state.hub = batchHub{}
+	state.config.CustomAction = map[string]CustomActionConfig{
+		"macro.Query": {Action: "peco.SetQuery", Args: json.RawMessage(`{"query": "foo"}`)},
+	}
+	state.config.Macros = map[string][]string{
+		"macro.Back":     {"peco.BackwardChar", "peco.BackwardChar"},
+		"macro.QueryAll": {"macro.Query", "macro.Back", "peco.DeleteForwardChar"},
+	}
+	if !assert.Empty(t, state.config.macroProblems(), "macros should be valid") {
+		return
+	}
+
+	km := NewKeymap(map[string]string{"M-q": "macro.QueryAll"}, state.config.macros(), state.config.CustomAction)
+	if !assert.NoError(t, km.ApplyKeybinding(), "ApplyKeybinding should succeed") {
+		return
+	}
+	a, err := km.resolveActionName("macro.QueryAll", 0)
+	if !assert.NoError(t, err, "macro should be resolved") {
+		return
+	}
+	a.Execute(context.Background(), state, termbox.Event{})
+	assert.Equal(t, "fo", state.Query().String(), "actions should be executed in order")
+	assert.Equal(t, 1, state.Caret().Pos(), "caret should be moved by the macro")
+}
@@ -0,0 +1,49 @@
+package peco
+
+import (
+	"github.com/lestrrat/go-pdebug"
+)
+
+// IsValidNoMatchAccept checks if a string is a supported value for
+// NoMatchAccept. The empty string selects the default behavior
+func IsValidNoMatchAccept(v string) bool {
+	switch v {
+	case "", NoMatchNoop, NoMatchAcceptQuery, NoMatchCancel:
+		return true
+	}
+	return false
+}
+
+// noMatch returns true if there is nothing to accept: no line matches
+// the query, and, unless all the current lines are accepted, no line
+// is selected either
+func (p *Peco) noMatch(all bool) bool {
+	if p.CurrentLineBuffer().Size() > 0 {
+		return false
+	}
+	return all || p.Selection().Len() == 0
+}
+
+// handleNoMatch does what NoMatchAccept asks for when peco.Finish
+// finds nothing to accept. It returns false if peco should finish as
+// usual. The empty query is never accepted, as it was not typed
+func (p *Peco) handleNoMatch() bool {
+	if pdebug.Enabled {
+		pdebug.Printf("Nothing to accept (NoMatchAccept = %s)", p.config.NoMatchAccept)
+	}
+
+	switch p.config.NoMatchAccept {
+	case NoMatchAcceptQuery:
+		if p.Query().Len() == 0 {
+			return false
+		}
+		p.acceptQuery = true
+		p.setExitHook(p.config.OnFinishCommand)
+		p.Exit(setExitStatus(errCollectResults{}, ExitStatusQueryAccepted))
+		return true
+	case NoMatchCancel:
+		exitCanceled(p)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoMatchAccept(t *testing.T) {
+	testValues := []struct {
+		mode     string
+		query    string
+		collect  bool
+		status   int
+		hasCode  bool
+		expected string
+	}{
+		{"", "zzz", true, 0, false, ""},
+		{NoMatchNoop, "zzz", true, 0, false, ""},
+		{NoMatchAcceptQuery, "zzz", true, ExitStatusQueryAccepted, true, "zzz\n"},
+		{NoMatchAcceptQuery, "ba", true, 0, false, "bar\n"},
+		{NoMatchCancel, "zzz", false, 0, false, ""},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.mode+" "+v.query, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = nil
+			p.Stdin = strings.NewReader("foo\nbar\n")
+			out := &bytes.Buffer{}
+			p.Stdout = out
+			p.config.NoMatchAccept = v.mode
+			resultCh := make(chan error)
+			go func() { resultCh <- p.Run(ctx) }()
+
+			<-p.Ready()
+			<-p.source.SetupDone()
+			p.Query().Set(v.query)
+			p.ExecQuery()
+
+			expected := 0
+			if v.query == "ba" {
+				expected = 1
+			}
+			for p.CurrentLineBuffer().Size() != expected {
+				select {
+				case <-ctx.Done():
+					t.Errorf("query %q should match %d lines", v.query, expected)
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+			doFinish(ctx, p, termbox.Event{})
+			err := <-resultCh
+			if !assert.Equal(t, v.collect, util.IsCollectResultsError(err), "results should be collected unless canceled") {
+				return
+			}
+			if !v.collect {
+				assert.True(t, util.IsIgnorableError(err), "peco should exit as canceled")
+				return
+			}
+			status, ok := util.GetExitStatus(err)
+			assert.Equal(t, v.hasCode, ok, "exit status should only be set when the query is accepted")
+			if ok {
+				assert.Equal(t, v.status, status, "exit status should match")
+			}
+			p.PrintResults()
+			assert.Equal(t, v.expected, out.String(), "output should match")
+		})
+	}
+}
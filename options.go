@@ -32,6 +32,9 @@ func (options CLIOptions) Validate() error {
 			return errors.New("unknown layout: '" + options.OptLayout + "'")
 		}
 	}
+	if !IsValidOutputFormat(options.OptOutput) {
+		return errors.New("unknown output format: '" + options.OptOutput + "'")
+	}
 	return nil
 }
 
@@ -39,7 +42,7 @@ func (options CLIOptions) help() []byte {
 	buf := bytes.Buffer{}
 
 	fmt.Fprintf(&buf, `
-Usage: peco [options] [FILE]
+Usage: peco [options] [FILE...]
 
 Options:
 `)
@@ -0,0 +1,362 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/google/btree"
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
+)
+
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+)
+
+// resultChunkSize is the number of bytes of output that are buffered
+// before they are written, when writing text
+const resultChunkSize = 32 * 1024
+
+// IsValidOutputFormat checks if the format given to --output is
+// supported. The empty string selects the default format
+func IsValidOutputFormat(v string) bool {
+	return v == "" || v == outputFormatText || v == outputFormatJSON
+}
+
+// Group returns the text matched by the n-th capture group of the
+// query. 0 returns the text matched by the entire expression. Returns
+// an empty string if there is no such group
+func (o outputTemplateLine) Group(n int) string {
+	if n < 0 || n >= len(o.groups) {
+		return ""
+	}
+	return o.groups[n]
+}
+
+// compileTemplate parses a template given in the configuration. The
+// template is also executed once against data, so that references to
+// fields that do not exist are reported before peco starts
+func compileTemplate(name, s string, data interface{}) (*template.Template, error) {
+	t, err := template.New(name).Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse template")
+	}
+
+	if err := t.Execute(ioutil.Discard, data); err != nil {
+		return nil, errors.Wrap(err, "failed to execute template")
+	}
+	return t, nil
+}
+
+// compileOutputTemplate parses the OutputTemplate configuration
+func compileOutputTemplate(s string) (*template.Template, error) {
+	return compileTemplate("OutputTemplate", s, outputTemplateLine{})
+}
+
+// outputKey is the key used to find duplicate results with
+// UniqueOutput. Lines are compared as they are output, so lines that
+// only differ in the fields that are not output are duplicates
+func (p *Peco) outputKey(v interface{}) string {
+	if l, ok := v.(line.Line); ok {
+		return p.outputString(l)
+	}
+	return ""
+}
+
+// inputIndices returns the positions of the lines in the input,
+// keyed by their IDs. Lines discarded because of the buffer size are
+// still counted, so that the positions do not change as more lines
+// are read
+func (p *Peco) inputIndices() map[uint64]int {
+	indices := make(map[uint64]int)
+	if src, ok := p.Source().(*Source); ok && src != nil {
+		lines, discarded := src.retained()
+		for i, l := range lines {
+			indices[l.ID()] = discarded + i
+		}
+	}
+	return indices
+}
+
+// submatcher returns a function that returns the text matched by the
+// query in a line, followed by the text matched by its capture groups.
+// Capture groups are only available for filters that use regular
+// expressions, so nil is returned for the other filters, and when
+// there is no query
+func (p *Peco) submatcher() func(line.Line) []string {
+	current, q, _ := p.queryFilter(p.Filters().Current(), p.Query().String())
+	rf, ok := current.(*filter.Regexp)
+	if !ok || q == "" {
+		return nil
+	}
+
+	ctx := rf.NewContext(context.Background(), q)
+	if delim := p.config.FieldDelimiter; delim != "" {
+		ctx = filter.WithFieldDelimiter(ctx, delim)
+	}
+	return func(l line.Line) []string {
+		return rf.Submatch(ctx, line.MatchString(l))
+	}
+}
+
+// captureGroupWriter returns a function that writes the text captured
+// in a line by the group given by OutputCaptureGroup, or the line as
+// usual if the group did not capture anything in it
+func (p *Peco) captureGroupWriter() func(io.Writer, line.Line) {
+	n := p.outputCaptureGroup
+	submatch := p.submatcher()
+	return func(w io.Writer, l line.Line) {
+		if submatch != nil {
+			if groups := submatch(l); n < len(groups) && groups[n] != "" {
+				io.WriteString(w, groups[n])
+				return
+			}
+		}
+		io.WriteString(w, p.outputString(l))
+	}
+}
+
+// outputTemplateWriter returns a function that writes a line using
+// the OutputTemplate
+func (p *Peco) outputTemplateWriter(indices map[uint64]int) func(io.Writer, line.Line) {
+	submatch := p.submatcher()
+	src, _ := p.Source().(*Source)
+	return func(w io.Writer, l line.Line) {
+		// Line numbers are positions in the input, regardless of
+		// what is currently displayed
+		data := outputTemplateLine{
+			Line:   p.outputString(l),
+			Weight: line.Weight(l),
+		}
+		if sl, ok := l.(*line.Scored); ok {
+			data.Score = sl.Score()
+		}
+		if src != nil {
+			data.Filename = src.Name()
+		}
+		if i, ok := indices[l.ID()]; ok {
+			data.LineNumber = i + 1
+			if src != nil {
+				data.Filename = src.Origin(i)
+			}
+		}
+		if submatch != nil {
+			data.groups = submatch(l)
+		}
+
+		if err := p.outputTemplate.Execute(w, data); err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("failed to execute OutputTemplate: %s", err)
+			}
+		}
+	}
+}
+
+// newResultWriter creates the resultWriter for the configured
+// output format, which writes to out
+func (p *Peco) newResultWriter(out io.Writer) resultWriter {
+	var indices map[uint64]int
+	if p.outputTemplate != nil || p.outputFormat == outputFormatJSON {
+		indices = p.inputIndices()
+	}
+
+	var format func(io.Writer, line.Line)
+	switch {
+	case p.outputTemplate != nil:
+		format = p.outputTemplateWriter(indices)
+	case p.outputCaptureGroup > 0:
+		format = p.captureGroupWriter()
+	case p.OutputField() > 0:
+		format = func(w io.Writer, l line.Line) {
+			io.WriteString(w, p.outputString(l))
+		}
+	}
+
+	if p.outputFormat == outputFormatJSON {
+		w := &jsonResultWriter{
+			format:  format,
+			indices: indices,
+			out:     out,
+		}
+		// The file name is only worth printing if there is more than one
+		if src, ok := p.Source().(*Source); ok && src != nil && len(src.files) > 1 {
+			w.origin = src.Origin
+		}
+		return w
+	}
+	return &textResultWriter{
+		delim:        p.resultDelimiter(),
+		format:       format,
+		omitTrailing: p.config.OmitTrailingDelimiter,
+		out:          out,
+	}
+}
+
+func (w *textResultWriter) WriteLine(l line.Line, _ bool) {
+	// Without the trailing delimiter, the delimiter is written before
+	// each line but the first, as the last line is not known yet
+	if w.omitTrailing && w.written {
+		w.buf.WriteString(w.delim)
+	}
+	if w.format != nil {
+		w.format(&w.buf, l)
+	} else {
+		w.buf.WriteString(l.Output())
+	}
+	if !w.omitTrailing {
+		w.buf.WriteString(w.delim)
+	}
+	w.written = true
+
+	if w.buf.Len() >= resultChunkSize {
+		w.writeChunk()
+	}
+}
+
+// writeChunk writes the buffered lines. Once writing fails, nothing
+// else is written, as the lines that follow would not make sense
+// after a partially written chunk
+func (w *textResultWriter) writeChunk() {
+	if w.err == nil && w.buf.Len() > 0 {
+		_, w.err = w.out.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+}
+
+func (w *textResultWriter) Flush() error {
+	w.writeChunk()
+	if w.err != nil {
+		return errors.Wrap(w.err, "failed to write results")
+	}
+	return nil
+}
+
+func (w *jsonResultWriter) WriteLine(l line.Line, selected bool) {
+	r := jsonResult{
+		Line:     l.Output(),
+		Index:    -1,
+		Selected: selected,
+	}
+	if w.format != nil {
+		var buf bytes.Buffer
+		w.format(&buf, l)
+		r.Line = buf.String()
+	}
+	if i, ok := w.indices[l.ID()]; ok {
+		r.Index = i
+		if w.origin != nil {
+			r.Filename = w.origin(i)
+		}
+	}
+	w.results = append(w.results, r)
+}
+
+// Flush writes the JSON array. An empty array is written if no
+// lines were written
+func (w *jsonResultWriter) Flush() error {
+	results := w.results
+	if results == nil {
+		results = []jsonResult{}
+	}
+	if err := json.NewEncoder(w.out).Encode(results); err != nil {
+		return errors.Wrap(err, "failed to write results")
+	}
+	return nil
+}
+
+// results returns the lines that are output when peco exits. If all is
+// true, they are the lines currently displayed, in the order they are
+// displayed. Otherwise they are the selected lines, or the current line
+// if nothing is selected
+func (p *Peco) results(all bool) resultSource {
+	if all {
+		return resultSource{buffer: p.CurrentLineBuffer()}
+	}
+	return resultSource{lines: selectionOrCurrentLine(p)}
+}
+
+// Len returns the number of lines
+func (s resultSource) Len() int {
+	if s.buffer != nil {
+		return s.buffer.Size()
+	}
+	return s.lines.Len()
+}
+
+// each calls fn with each of the lines, until it returns false. The
+// lines of a buffer come in the order they are displayed, and those
+// of a selection in the order they were read in
+func (s resultSource) each(fn func(line.Line) bool) {
+	if s.buffer != nil {
+		for i := 0; i < s.buffer.Size(); i++ {
+			l, err := s.buffer.LineAt(i)
+			if err != nil {
+				continue
+			}
+			if !fn(l) {
+				return
+			}
+		}
+		return
+	}
+
+	s.lines.Ascend(func(it btree.Item) bool {
+		return fn(it.(line.Line))
+	})
+}
+
+func (s resultSource) Start(ctx context.Context, out pipeline.ChanOutput) {
+	defer out.SendEndMarkCtx(ctx, "end of results")
+	s.each(func(l line.Line) bool {
+		return out.SendCtx(ctx, l) == nil
+	})
+}
+
+func (s resultSource) Reset() {}
+
+func newResultDestination(w resultWriter, selected bool) *resultDestination {
+	return &resultDestination{
+		done:     make(chan struct{}),
+		selected: selected,
+		writer:   w,
+	}
+}
+
+func (d *resultDestination) Reset() {}
+
+func (d *resultDestination) Done() <-chan struct{} {
+	return d.done
+}
+
+// Accept writes the lines as they are received. The output is only
+// flushed once all of them have been received
+func (d *resultDestination) Accept(ctx context.Context, in chan interface{}, _ pipeline.ChanOutput) {
+	defer close(d.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = pipeline.EndMark{}
+			}
+			switch v := v.(type) {
+			case error:
+				if pipeline.IsEndMark(v) {
+					d.err = d.writer.Flush()
+					return
+				}
+			case line.Line:
+				d.writer.WriteLine(v, d.selected)
+			}
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileOutputTemplate(t *testing.T) {
+	if _, err := compileOutputTemplate("{{.LineNumber}}: {{.Group 1}}"); !assert.NoError(t, err, "valid template should compile") {
+		return
+	}
+	if _, err := compileOutputTemplate("{{.Line"); !assert.Error(t, err, "malformed template should fail") {
+		return
+	}
+	if _, err := compileOutputTemplate("{{.NoSuchField}}"); !assert.Error(t, err, "unknown field should fail") {
+		return
+	}
+}
+
+func TestOutputTemplate(t *testing.T) {
+	testValues := []struct {
+		filter   string
+		query    string
+		template string
+		output   string
+	}{
+		{"Regexp", `bar=(\d)`, "{{.LineNumber}}:{{.Line}}", "2:bar=2\n"},
+		{"Regexp", `bar=(\d)`, "{{.Group 1}}", "2\n"},
+		{"Regexp", `bar=(\d)`, "[{{.Group 2}}]", "[]\n"},
+		// Capture groups are only available for regular expressions
+		{"IgnoreCase", "bar=", "[{{.Group 1}}]", "[]\n"},
+		// Scores are only given by the filters that rank the lines
+		{"FuzzyRanked", "bar", "{{if gt .Score 0}}ranked{{end}}", "ranked\n"},
+		{"IgnoreCase", "bar=", "{{.Score}}", "0\n"},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.filter+" "+v.template, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = []string{"--select-1", "--initial-filter", v.filter, "--query", v.query}
+			p.config.OutputTemplate = v.template
+			p.Stdin = bytes.NewBufferString("foo=1\nbar=2\nbaz=3\n")
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			err := p.Run(ctx)
+			if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+				return
+			}
+			p.PrintResults()
+			assert.Equal(t, v.output, out.String(), "output should match")
+		})
+	}
+}
+
+func TestOutputCaptureGroup(t *testing.T) {
+	testValues := []struct {
+		name   string
+		args   []string
+		output string
+	}{
+		{"group", []string{"--initial-filter", "Regexp", "--query", `(\w+)=(\d)?`, "--output-capture-group", "1"}, "foo\nbar\nbaz\n"},
+		// Lines where the group did not capture anything are printed as is
+		{"fallback", []string{"--initial-filter", "Regexp", "--query", `(\w+)=(\d)?`, "--output-capture-group", "2"}, "1\nbar=\n3\n"},
+		{"no such group", []string{"--initial-filter", "Regexp", "--query", `(\w+)=`, "--output-capture-group", "3"}, "foo=1\nbar=\nbaz=3\n"},
+		// Capture groups are only available for regular expressions
+		{"literal", []string{"--initial-filter", "IgnoreCase", "--query", "=", "--output-capture-group", "1"}, "foo=1\nbar=\nbaz=3\n"},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = v.args
+			p.Stdin = bytes.NewBufferString("foo=1\nbar=\nbaz=3\n")
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			go p.Run(ctx)
+			<-p.Ready()
+			<-p.source.SetupDone()
+			for {
+				if _, ok := p.CurrentLineBuffer().(*MemoryBuffer); ok && !p.FilterRunning() {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					assert.Fail(t, "timed out waiting for the query")
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+			p.acceptAll = true
+			if !assert.NoError(t, p.printResults(ctx), "printResults should succeed") {
+				return
+			}
+			assert.Equal(t, v.output, out.String(), "output should match")
+		})
+	}
+
+	p := newPeco()
+	assert.Error(t, p.ApplyConfig(CLIOptions{OptCaptureGroup: -1}), "negative group should fail")
+}
+
+func TestJSONOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"--select-1", "--output", "json", "--query", "bar"}
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	var out bytes.Buffer
+	p.Stdout = &out
+
+	err := p.Run(ctx)
+	if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+		return
+	}
+	p.PrintResults()
+	if !assert.Equal(t, `[{"line":"bar","index":1,"selected":true}]`+"\n", out.String(), "output should match") {
+		return
+	}
+
+	// The stream is valid even if nothing is written
+	out.Reset()
+	w := &jsonResultWriter{out: &out}
+	if !assert.NoError(t, w.Flush(), "Flush should succeed") {
+		return
+	}
+	if !assert.Equal(t, "[]\n", out.String(), "empty array should be written") {
+		return
+	}
+
+	out.Reset()
+	w = &jsonResultWriter{out: &out, indices: map[uint64]int{3: 0}}
+	w.WriteLine(line.NewRaw(3, "foo", false), true)
+	w.WriteLine(line.NewRaw(5, "bar", false), true)
+	if !assert.NoError(t, w.Flush(), "Flush should succeed") {
+		return
+	}
+	if !assert.Equal(t, `[{"line":"foo","index":0,"selected":true},{"line":"bar","index":-1,"selected":true}]`+"\n", out.String(), "all lines should be written") {
+		return
+	}
+}
+
+func TestInputIndicesAfterEviction(t *testing.T) {
+	p := newPeco()
+	src := NewSource("-", bytes.NewBufferString(""), nil, 2, false)
+	for i := 0; i < 5; i++ {
+		src.Append(line.NewRaw(uint64(100+i), "foo", false))
+	}
+	p.source = src
+
+	// Discarded lines still count, so the positions don't change
+	indices := p.inputIndices()
+	if !assert.Len(t, indices, 2, "only the kept lines should be indexed") {
+		return
+	}
+	assert.Equal(t, 3, indices[103], "position should include the discarded lines")
+	assert.Equal(t, 4, indices[104], "position should include the discarded lines")
+}
+
+func TestMultipleFilesOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-output")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	missing := filepath.Join(dir, "missing.txt")
+	if !assert.NoError(t, ioutil.WriteFile(a, []byte("foo\nbar\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+	if !assert.NoError(t, ioutil.WriteFile(b, []byte("baz\nqux\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	testValues := []struct {
+		name     string
+		args     []string
+		template string
+		output   string
+	}{
+		{"template", nil, "{{.Filename}}:{{.LineNumber}}:{{.Line}}", b + ":4:qux\n"},
+		{"json", []string{"--output", "json"}, "", `[{"line":"qux","index":3,"filename":` + strconv.Quote(b) + `,"selected":true}]` + "\n"},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = append([]string{"peco", "--select-1", "--query", "qux"}, v.args...)
+			p.Argv = append(p.Argv, a, missing, b)
+			p.config.OutputTemplate = v.template
+			var out, errOut bytes.Buffer
+			p.Stdout = &out
+			p.Stderr = &errOut
+
+			err := p.Run(ctx)
+			if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+				return
+			}
+			p.PrintResults()
+			if !assert.Equal(t, v.output, out.String(), "output should match") {
+				return
+			}
+			assert.Contains(t, errOut.String(), missing, "missing file should be reported")
+		})
+	}
+}
+
+// chunkRecorder remembers each chunk written to it
+type chunkRecorder struct {
+	chunks [][]byte
+}
+
+func (r *chunkRecorder) Write(p []byte) (int, error) {
+	r.chunks = append(r.chunks, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestPrintResultsStreaming(t *testing.T) {
+	newState := func(out *chunkRecorder) (*Peco, []byte) {
+		state := newPeco()
+		state.Stdout = out
+
+		var expected bytes.Buffer
+		for i := 0; i < 10000; i++ {
+			l := line.NewRaw(uint64(i), "line "+strconv.Itoa(i), false)
+			state.Selection().Add(l)
+			expected.WriteString(l.Output() + "\n")
+		}
+		return state, expected.Bytes()
+	}
+
+	t.Run("streamed", func(t *testing.T) {
+		var out chunkRecorder
+		state, expected := newState(&out)
+		if !assert.NoError(t, state.printResults(context.Background()), "printResults should succeed") {
+			return
+		}
+		if !assert.True(t, len(out.chunks) > 1, "results should be written in several chunks") {
+			return
+		}
+		for i, chunk := range out.chunks {
+			if !assert.Equal(t, byte('\n'), chunk[len(chunk)-1], "chunk %d should end with a whole line", i) {
+				return
+			}
+		}
+		assert.Equal(t, string(expected), string(bytes.Join(out.chunks, nil)), "all the lines should be written in order")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		var out chunkRecorder
+		state, _ := newState(&out)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if !assert.Error(t, state.printResults(ctx), "printResults should fail") {
+			return
+		}
+		for i, chunk := range out.chunks {
+			if !assert.Equal(t, byte('\n'), chunk[len(chunk)-1], "chunk %d should end with a whole line", i) {
+				return
+			}
+		}
+	})
+}
+
+func TestPrintResultsAcceptAll(t *testing.T) {
+	var out bytes.Buffer
+	state := newPeco()
+	state.Stdout = &out
+
+	mb := NewMemoryBuffer()
+	for i := 0; i < 4; i++ {
+		mb.lines = append(mb.lines, line.NewRaw(uint64(i), "line "+strconv.Itoa(i), false))
+	}
+	state.currentLineBuffer = newReversedBuffer(mb)
+	state.Selection().Add(mb.lines[1])
+
+	doAcceptAll(context.Background(), state, termbox.Event{})
+	if !assert.True(t, util.IsCollectResultsError(state.Err()), "peco should exit with the results") {
+		return
+	}
+	if !assert.NoError(t, state.printResults(context.Background()), "printResults should succeed") {
+		return
+	}
+	assert.Equal(t, "line 3\nline 2\nline 1\nline 0\n", out.String(), "all the lines should be written in the order they are displayed")
+}
+
+func TestOutputDelimiter(t *testing.T) {
+	testValues := []struct {
+		delimiter    string
+		omitTrailing bool
+		print0       bool
+		output       string
+	}{
+		{"", false, false, "foo\nbar baz\nqux\n"},
+		{"", true, false, "foo\nbar baz\nqux"},
+		{" ", false, false, "foo bar baz qux "},
+		{", ", true, false, "foo, bar baz, qux"},
+		{"\x00", false, false, "foo\x00bar baz\x00qux\x00"},
+		{", ", true, true, "foo\x00bar baz\x00qux"},
+	}
+
+	for _, v := range testValues {
+		t.Run(fmt.Sprintf("%q %t %t", v.delimiter, v.omitTrailing, v.print0), func(t *testing.T) {
+			var out bytes.Buffer
+			state := newPeco()
+			state.Stdout = &out
+			state.config.OutputDelimiter = v.delimiter
+			state.config.OmitTrailingDelimiter = v.omitTrailing
+			state.print0 = v.print0
+			for i, s := range []string{"foo", "bar baz", "qux"} {
+				state.Selection().Add(line.NewRaw(uint64(i), s, false))
+			}
+
+			if !assert.NoError(t, state.printResults(context.Background()), "printResults should succeed") {
+				return
+			}
+			assert.Equal(t, v.output, out.String(), "lines should be joined with the delimiter")
+		})
+	}
+}
+
+func TestUniqueOutput(t *testing.T) {
+	texts := []string{"foo", "bar", "foo", "baz", "bar"}
+	testValues := []struct {
+		selected []int // in the order the lines are selected
+		output   string
+	}{
+		{[]int{0, 1, 2, 3, 4}, "foo\nbar\nbaz\n"},
+		{[]int{4, 3, 2, 1, 0}, "foo\nbar\nbaz\n"},
+		{[]int{2, 4, 0}, "foo\nbar\n"},
+		{[]int{4, 2, 3}, "foo\nbaz\nbar\n"},
+		{[]int{3}, "baz\n"},
+	}
+
+	for _, v := range testValues {
+		t.Run(fmt.Sprint(v.selected), func(t *testing.T) {
+			var out bytes.Buffer
+			state := newPeco()
+			state.Stdout = &out
+			state.config.UniqueOutput = true
+			for _, i := range v.selected {
+				state.Selection().Add(line.NewRaw(uint64(i), texts[i], false))
+			}
+
+			if !assert.NoError(t, state.printResults(context.Background()), "printResults should succeed") {
+				return
+			}
+			assert.Equal(t, v.output, out.String(), "duplicate lines should only be written once")
+		})
+	}
+}
@@ -1,70 +1,121 @@
 package peco
 
 func (l *Location) SetColumn(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.col = n
 }
 
-func (l Location) Column() int {
+func (l *Location) Column() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.col
 }
 
 func (l *Location) SetLineNumber(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.lineno = n
 }
 
-func (l Location) LineNumber() int {
+func (l *Location) LineNumber() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.lineno
 }
 
 func (l *Location) SetOffset(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.offset = n
 }
 
-func (l Location) Offset() int {
+func (l *Location) Offset() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.offset
 }
 
 func (l *Location) SetPerPage(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.perPage = n
 }
 
-func (l Location) PerPage() int {
+func (l *Location) PerPage() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.perPage
 }
 
 func (l *Location) SetPage(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.page = n
 }
 
-func (l Location) Page() int {
+func (l *Location) Page() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.page
 }
 
 func (l *Location) SetTotal(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.total = n
 }
 
-func (l Location) Total() int {
+func (l *Location) Total() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.total
 }
 
 func (l *Location) SetMaxPage(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.maxPage = n
 }
 
-func (l Location) MaxPage() int {
+func (l *Location) MaxPage() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.maxPage
 }
 
-func (l Location) PageCrop() PageCrop {
+func (l *Location) SetScrolled(b bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.scrolled = b
+}
+
+func (l *Location) Scrolled() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.scrolled
+}
+
+// Pages returns the number of lines, the current page and the number
+// of pages all at once, so that they are read consistently
+func (l *Location) Pages() (total, page, maxPage int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.total, l.page, l.maxPage
+}
+
+func (l *Location) PageCrop() PageCrop {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return PageCrop{
 		perPage:     l.perPage,
 		currentPage: l.page,
+		offset:      l.offset,
 	}
 }
 
 // Crop returns a new Buffer whose contents are
 // bound within the given range
 func (pf PageCrop) Crop(in Buffer) *FilteredBuffer {
-	return NewFilteredBuffer(in, pf.currentPage, pf.perPage)
+	return newFilteredBufferAt(in, pf.offset, pf.perPage)
 }
@@ -0,0 +1,298 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// actionDescriptions holds the one line descriptions of the built-in
+// actions, as listed by peco.ShowActionPalette
+var actionDescriptions = map[string]string{
+	"peco.AcceptAll":                    "Exit with all the lines that matched the query",
+	"peco.AddMatchesToSelection":        "Add the lines that matched the query to the selection",
+	"peco.BackToInitialFilter":          "Switch to the first filter in the list",
+	"peco.BackwardChar":                 "Move the caret backward 1 character",
+	"peco.BackwardWord":                 "Move the caret backward 1 word",
+	"peco.BeginningOfLine":              "Move the caret to the beginning of the query",
+	"peco.Cancel":                       "Exit with failure status, or cancel range mode",
+	"peco.CancelRangeMode":              "Cancel the range selection",
+	"peco.ClearQuery":                   "Delete the entire query, including the queries given with --query",
+	"peco.ClearSelection":               "Deselect all the lines, including the pinned lines, and tell how many were deselected",
+	"peco.CompleteCommonPrefix":         "Extend the query to the longest prefix that the matched lines share",
+	"peco.CopyQueryToClipboard":         "Copy the query to the clipboard",
+	"peco.CopyToClipboard":              "Copy the selected lines, or the current line, to the clipboard",
+	"peco.DeleteAll":                    "Delete all entered characters",
+	"peco.DeleteBackwardChar":           "Delete one character backward",
+	"peco.DeleteBackwardWord":           "Delete one word backward",
+	"peco.DeleteForwardChar":            "Delete one character forward",
+	"peco.DeleteForwardWord":            "Delete one word forward",
+	"peco.EmitAndContinue":              "Write the selected lines to --emit-to, and start over without exiting",
+	"peco.EndOfFile":                    "Delete one character forward, or exit with failure status",
+	"peco.EndOfLine":                    "Move the caret to the end of the query",
+	"peco.Finish":                       "Exit with the selected lines",
+	"peco.ForwardChar":                  "Move the caret forward 1 character",
+	"peco.ForwardWord":                  "Move the caret forward 1 word",
+	"peco.InvertSelection":              "Invert the selection of the lines matching the query",
+	"peco.KillBeginningOfLine":          "Delete the characters before the caret",
+	"peco.KillEndOfLine":                "Delete the characters after the caret",
+	"peco.KonamiCommand":                "Up, up, down, down, left, right, left, right, b, a",
+	"peco.NextGroup":                    "Move the cursor to the next group",
+	"peco.NextQueryFromHistory":         "Replace the query with the next query from the history",
+	"peco.NextSelection":                "Move the cursor to the next selected line",
+	"peco.Noop":                         "Do nothing, so that the key bound to it does not type anything",
+	"peco.OpenInEditor":                 "Open the file referred to by the current line in $EDITOR",
+	"peco.PinCurrent":                   "Select the current line, and keep it selected when the query changes",
+	"peco.PopRefinement":                "Undo the last peco.RefineByLine",
+	"peco.PrevGroup":                    "Move the cursor to the previous group",
+	"peco.PrevSelection":                "Move the cursor to the previous selected line",
+	"peco.PreviousQueryFromHistory":     "Replace the query with the previous query from the history",
+	"peco.QuickSelect":                  "Label the visible lines, and accept the line whose label is typed",
+	"peco.RefineByLine":                 "Narrow down the results to the lines containing the current line",
+	"peco.RefreshScreen":                "Redraw the screen",
+	"peco.RepeatLastAction":             "Execute the last action bound to a key again",
+	"peco.RestoreQuery":                 "Bring back the query that was cleared when the filter changed",
+	"peco.RotateFilter":                 "Rotate between filters",
+	"peco.RotateFilterReverse":          "Rotate between filters in the reverse order",
+	"peco.ScrollFirstColumn":            "Scroll back to the first column",
+	"peco.ScrollLeft":                   "Scroll to the left",
+	"peco.ScrollPageDown":               "Move the cursor an entire page down",
+	"peco.ScrollPageUp":                 "Move the cursor an entire page up",
+	"peco.ScrollPreviewDown":            "Scroll the preview pane down by half a page",
+	"peco.ScrollPreviewUp":              "Scroll the preview pane up by half a page",
+	"peco.ScrollRight":                  "Scroll to the right",
+	"peco.ScrollViewDown":               "Scroll the list down by a line, keeping the cursor on its line",
+	"peco.ScrollViewUp":                 "Scroll the list up by a line, keeping the cursor on its line",
+	"peco.SelectAll":                    "Select all the lines",
+	"peco.SelectDown":                   "Move the cursor one line down",
+	"peco.SelectField":                  "Choose the field of the selected lines to output",
+	"peco.SelectNone":                   "Remove all saved selections",
+	"peco.SelectToMark":                 "Select the lines between the mark and the current line",
+	"peco.SelectUp":                     "Move the cursor one line up",
+	"peco.SelectVisible":                "Select all the visible lines",
+	"peco.SetMark":                      "Mark the current line",
+	"peco.ShowActionPalette":            "List the actions and their keys, and execute the chosen one",
+	"peco.ToLineBottom":                 "Move the cursor to the last line",
+	"peco.ToLineTop":                    "Move the cursor to the first line",
+	"peco.ToMiddle":                     "Move the cursor to the line in the middle of the screen",
+	"peco.ToggleFixedString":            "Switch between matching the query as is and the current filter",
+	"peco.ToggleFuzzyAnchor":            "Switch between anchoring the fuzzy matches and matching anywhere",
+	"peco.ToggleIgnoreAccents":          "Switch between ignoring and matching diacritics",
+	"peco.ToggleLineWrap":               "Switch between wrapping and truncating the long lines",
+	"peco.ToggleMouse":                  "Enable or disable the mouse",
+	"peco.TogglePreview":                "Hide or show the preview pane",
+	"peco.ToggleQuery":                  "Toggle between the lines filtered by the query and all lines",
+	"peco.ToggleRangeMode":              "Start selecting by range, or add the range to the selection",
+	"peco.ToggleSelection":              "Select the current line",
+	"peco.ToggleSelectionAndDown":       "Select the current line, and move one line down unless it is the last",
+	"peco.ToggleSelectionAndSelectNext": "Select the current line, and move to the next line",
+	"peco.ToggleSelectionAndUp":         "Select the current line, and move one line up unless it is the first",
+	"peco.ToggleSelectionMode":          "Switch between single and multiple selection",
+	"peco.ToggleSingleKeyJump":          "Enable SingleKeyJump mode",
+	"peco.ToggleSort":                   "Cycle through the sort orders",
+	"peco.ToggleWholeWord":              "Switch between matching whole words and matching anywhere",
+	"peco.TransposeChars":               "Swap the characters before and under the caret",
+	"peco.UnpinCurrent":                 "Stop keeping the current line selected when the query changes",
+}
+
+// Actions returns the actions that can be executed, sorted by name,
+// along with the keys bound to them. This includes the combined actions
+// and the actions with arguments that are bound to keys, but not the
+// deprecated actions
+func (km Keymap) Actions() []ActionInfo {
+	// The keys configured by the user replace the default bindings
+	bound := make(map[string]string, len(defaultKeyNames))
+	for k, name := range defaultKeyNames {
+		bound[k] = name
+	}
+	for k, name := range km.Config {
+		for d := range defaultKeyNames {
+			if canonicalKeyName(d) == canonicalKeyName(k) {
+				delete(bound, d)
+			}
+		}
+		if name == "-" {
+			delete(bound, k)
+			continue
+		}
+		bound[k] = name
+	}
+	keys := make(map[string][]string)
+	for k, name := range bound {
+		keys[name] = append(keys[name], k)
+	}
+
+	list := make([]ActionInfo, 0, len(nameToActions))
+	for name := range nameToActions {
+		if deprecatedActions[name] {
+			continue
+		}
+		sort.Strings(keys[name])
+		list = append(list, ActionInfo{
+			Name:        name,
+			Description: km.describeAction(name),
+			Keys:        keys[name],
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// describeAction returns the description of the named action. Actions
+// defined in the config file are described by what they are made of
+func (km Keymap) describeAction(name string) string {
+	if v, ok := actionDescriptions[name]; ok {
+		return v
+	}
+	if l, ok := km.Action[name]; ok {
+		return "Execute " + strings.Join(l, ", ")
+	}
+	if c, ok := km.CustomAction[name]; ok {
+		return "Execute " + c.Action + " with " + string(c.Args)
+	}
+	return ""
+}
+
+// ActionPaletteShown returns true while the list of actions shown by
+// peco.ShowActionPalette replaces the input
+func (p *Peco) ActionPaletteShown() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.palette != nil
+}
+
+func (p *Peco) setActionPalette(pal *actionPalette) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.palette = pal
+}
+
+// takeActionPalette returns the state saved by peco.ShowActionPalette,
+// and forgets about it. Returns nil if the palette is not shown
+func (p *Peco) takeActionPalette() *actionPalette {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pal := p.palette
+	p.palette = nil
+	return pal
+}
+
+// paletteLines returns the lines that peco.ShowActionPalette displays
+// for the actions, with the names, keys and descriptions in columns
+func paletteLines(actions []ActionInfo) []string {
+	var nameWidth, keysWidth int
+	for _, a := range actions {
+		nameWidth = maxOf(nameWidth, len(a.Name))
+		keysWidth = maxOf(keysWidth, len(strings.Join(a.Keys, " ")))
+	}
+
+	lines := make([]string, len(actions))
+	for i, a := range actions {
+		v := fmt.Sprintf("%-*s  %-*s  %s", nameWidth, a.Name, keysWidth, strings.Join(a.Keys, " "), a.Description)
+		lines[i] = strings.TrimRight(v, " ")
+	}
+	return lines
+}
+
+// doShowActionPalette replaces the input with the list of actions and
+// the keys bound to them, which is filtered by the query like any
+// other input. peco.Finish executes the action on the current line,
+// and peco.Cancel dismisses the list. Either way, the query, the
+// results and the selection are restored as they were
+func doShowActionPalette(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doShowActionPalette")
+		defer g.End()
+	}
+
+	if state.ActionPaletteShown() {
+		return
+	}
+
+	actions := state.Keymap().Actions()
+	src := newStaticSource("actions", paletteLines(actions), state.idgen)
+
+	pal := &actionPalette{
+		actions:    make(map[uint64]string, len(actions)),
+		caretPos:   state.Caret().Pos(),
+		lineBuffer: state.CurrentLineBuffer(),
+		lineNumber: state.Location().LineNumber(),
+		multiQuery: state.MultiQuery(),
+		query:      state.Query().String(),
+		selection:  NewSelection(),
+		source:     state.Source().(*Source),
+	}
+	for i, a := range actions {
+		if l, err := src.LineAt(i); err == nil {
+			pal.actions[l.ID()] = a.Name
+		}
+	}
+	state.Selection().Copy(pal.selection)
+	state.setActionPalette(pal)
+
+	state.Selection().Reset()
+	state.SetSource(src)
+	state.Query().Reset()
+	state.Caret().SetPos(0)
+	state.SetMultiQuery(nil)
+	state.Location().SetLineNumber(0)
+	state.ResetCurrentLineBuffer()
+
+	state.Hub().SendStatusMsg("Enter to execute the action, Esc to go back")
+	state.Hub().SendDrawPrompt()
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
+// closeActionPalette dismisses the list of actions shown by
+// peco.ShowActionPalette, and brings back what it replaced
+func closeActionPalette(state *Peco) {
+	pal := state.takeActionPalette()
+	if pal == nil {
+		return
+	}
+
+	state.SetSource(pal.source)
+	state.Query().Set(pal.query)
+	state.Caret().SetPos(pal.caretPos)
+	state.SetMultiQuery(pal.multiQuery)
+	state.Selection().Reset()
+	pal.selection.Copy(state.Selection())
+	state.Location().SetLineNumber(pal.lineNumber)
+	state.SetCurrentLineBuffer(pal.lineBuffer)
+
+	state.Hub().SendStatusMsg("")
+	state.Hub().SendDrawPrompt()
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
+// executeActionPalette dismisses the list of actions shown by
+// peco.ShowActionPalette, and executes the action on the current line
+func executeActionPalette(ctx context.Context, state *Peco) {
+	state.mutex.Lock()
+	pal := state.palette
+	state.mutex.Unlock()
+	if pal == nil {
+		return
+	}
+
+	var name string
+	if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
+		name = pal.actions[l.ID()]
+	}
+	closeActionPalette(state)
+
+	a, ok := nameToActions[name]
+	if !ok {
+		return
+	}
+	if pdebug.Enabled {
+		pdebug.Printf("Executing %s from the action palette", name)
+	}
+	a.Execute(ctx, state, termbox.Event{})
+}
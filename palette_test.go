@@ -0,0 +1,119 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeymapActions(t *testing.T) {
+	km := NewKeymap(map[string]string{
+		"C-j": "peco.SelectDown",
+		"C-n": "-",
+	}, nil, nil)
+
+	actions := map[string]ActionInfo{}
+	for _, a := range km.Actions() {
+		actions[a.Name] = a
+	}
+
+	down, ok := actions["peco.SelectDown"]
+	if !assert.True(t, ok, "peco.SelectDown should be listed") {
+		return
+	}
+	assert.Equal(t, []string{"ArrowDown", "C-j"}, down.Keys, "keys should include the configured ones")
+	assert.NotEmpty(t, down.Description, "description should be set")
+
+	assert.Equal(t, []string{"ArrowUp", "C-p"}, actions["peco.SelectUp"].Keys, "default keys should be listed")
+	assert.Empty(t, actions["peco.RefineByLine"].Keys, "unbound action should have no keys")
+	_, ok = actions["peco.SelectNext"]
+	assert.False(t, ok, "deprecated action should not be listed")
+}
+
+func TestActionPalette(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo\nbar\n")
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitForLines := func(check func([]string) bool) bool {
+		for {
+			if check(bufferLines(p.CurrentLineBuffer())) {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				t.Errorf("unexpected lines %#v", bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	input := func(lines []string) bool {
+		return assert.ObjectsAreEqual([]string{"foo", "bar"}, lines)
+	}
+
+	if !waitForLines(input) {
+		return
+	}
+	source := p.CurrentLineBuffer()
+	l, _ := source.LineAt(1)
+	p.Selection().Add(l)
+
+	t.Run("dismiss", func(t *testing.T) {
+		doShowActionPalette(ctx, p, termbox.Event{})
+		if !assert.True(t, p.ActionPaletteShown(), "palette should be shown") {
+			return
+		}
+		if !assert.Equal(t, 0, p.Selection().Len(), "selection should be cleared") {
+			return
+		}
+
+		doCancel(ctx, p, termbox.Event{})
+		if !assert.False(t, p.ActionPaletteShown(), "palette should be dismissed") {
+			return
+		}
+		if !assert.NoError(t, ctx.Err(), "peco should not exit") {
+			return
+		}
+		assert.Equal(t, source, p.CurrentLineBuffer(), "lines should be restored")
+		assert.True(t, p.Selection().Has(l), "selection should be restored")
+	})
+
+	t.Run("execute", func(t *testing.T) {
+		sortMode := p.SortMode()
+		doShowActionPalette(ctx, p, termbox.Event{})
+		p.Query().Set("peco.ToggleSort")
+		p.ExecQuery()
+		if !waitForLines(func(lines []string) bool {
+			return len(lines) == 1 && strings.HasPrefix(lines[0], "peco.ToggleSort ")
+		}) {
+			return
+		}
+
+		doFinish(ctx, p, termbox.Event{})
+		if !assert.False(t, p.ActionPaletteShown(), "palette should be dismissed") {
+			return
+		}
+		if !assert.NoError(t, ctx.Err(), "peco should not exit") {
+			return
+		}
+		assert.NotEqual(t, sortMode, p.SortMode(), "action should be executed")
+		assert.Equal(t, "", p.Query().String(), "query should be restored")
+		assert.True(t, p.Selection().Has(l), "selection should be restored")
+		waitForLines(func(lines []string) bool {
+			return len(lines) == 2 && !strings.HasPrefix(lines[0], "peco.")
+		})
+	})
+}
@@ -2,17 +2,20 @@ package peco
 
 import (
 	"bufio"
-	"bytes"
-	"io"
+	"encoding/json"
+	"fmt"
 	"os"
+	"os/signal"
 	"reflect"
+	"regexp"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
 	"context"
 
-	"github.com/google/btree"
 	"github.com/lestrrat/go-pdebug"
 	"github.com/peco/peco/filter"
 	"github.com/peco/peco/hub"
@@ -111,9 +114,11 @@ func New() *Peco {
 		Stdin:             os.Stdin,
 		Stdout:            os.Stdout,
 		currentLineBuffer: NewMemoryBuffer(), // XXX revisit this
+		firstFilterCh:     make(chan Buffer, 1),
 		idgen:             newIDGen(),
 		queryExecDelay:    50 * time.Millisecond,
 		readyCh:           make(chan struct{}),
+		redrawInterval:    DefaultRedrawInterval * time.Millisecond,
 		screen:            NewTermbox(),
 		selection:         NewSelection(),
 		maxScanBufferSize: bufio.MaxScanTokenSize,
@@ -160,6 +165,21 @@ func (p *Peco) SetResultCh(ch chan line.Line) {
 	p.resultCh = ch
 }
 
+// MultiQuery returns the queries given by specifying --query more
+// than once. Lines that match any of them are displayed until the user
+// edits the query. Returns nil if we are not in multi-query mode
+func (p *Peco) MultiQuery() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.multiQuery
+}
+
+func (p *Peco) SetMultiQuery(queries []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.multiQuery = queries
+}
+
 func (p *Peco) Selection() *Selection {
 	return p.selection
 }
@@ -206,15 +226,123 @@ func (p *Peco) ToggleSingleKeyJumpMode() {
 	go p.Hub().SendDraw(&DrawOptions{DisableCache: true})
 }
 
+// MouseEnabled returns true if mouse events are being handled
+func (p *Peco) MouseEnabled() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.mouseEnabled
+}
+
+// SetMouseEnabled enables or disables handling of mouse events
+func (p *Peco) SetMouseEnabled(b bool) {
+	p.mutex.Lock()
+	p.mouseEnabled = b
+	p.mutex.Unlock()
+	p.screen.SetMouse(b)
+}
+
+// QueryElapsed returns how long the last query took to run, or how long
+// it has been running so far. The second return value is false if no
+// query has been run since the query was last emptied
+func (p *Peco) QueryElapsed() (time.Duration, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch {
+	case p.queryStart.IsZero():
+		return 0, false
+	case p.queryElapsed == 0:
+		return time.Since(p.queryStart), true
+	default:
+		return p.queryElapsed, true
+	}
+}
+
+// startQueryTimer records that a query started running at start. The
+// zero time clears the record
+func (p *Peco) startQueryTimer(start time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.queryStart = start
+	p.queryElapsed = 0
+}
+
+// stopQueryTimer records that the query that started at start has
+// finished, unless another query has been started since then
+func (p *Peco) stopQueryTimer(start time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.queryStart.Equal(start) {
+		return
+	}
+	// Zero means that the query is still running
+	if p.queryElapsed = time.Since(start); p.queryElapsed <= 0 {
+		p.queryElapsed = time.Nanosecond
+	}
+}
+
+// FilterRunning returns true if a query is being run
+func (p *Peco) FilterRunning() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.filtersRunning > 0
+}
+
+func (p *Peco) addFiltersRunning(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.filtersRunning += n
+}
+
+// startExecuting records that a command started by
+// peco.ExecuteWithSelection is running. Returns false if one already is
+func (p *Peco) startExecuting() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.executing {
+		return false
+	}
+	p.executing = true
+	return true
+}
+
+func (p *Peco) doneExecuting() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.executing = false
+}
+
+// Mark returns the line marked by peco.SetMark, or nil
+func (p *Peco) Mark() line.Line {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.mark
+}
+
+func (p *Peco) SetMark(l line.Line) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.mark = l
+}
+
 func (p *Peco) SingleKeyJumpIndex(ch rune) (uint, bool) {
 	n, ok := p.singleKeyJumpPrefixMap[ch]
 	return n, ok
 }
 
 func (p *Peco) Source() pipeline.Source {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.source
 }
 
+// SetSource replaces the input buffer
+func (p *Peco) SetSource(s *Source) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.source = s
+}
+
 func (p *Peco) Filters() *filter.Set {
 	return &p.filters
 }
@@ -235,6 +363,15 @@ func (p *Peco) Hub() MessageHub {
 	return p.hub
 }
 
+// ShowMessage displays text in the status bar, and clears it after ttl.
+// If ttl is 0, the message stays until it is replaced, which suits
+// messages about ongoing states. Messages that are less important than
+// a timed warning or error wait for it to be cleared, instead of
+// replacing it right away
+func (p *Peco) ShowMessage(text string, ttl time.Duration, style MessageStyle) {
+	p.Hub().SendStatusMsgLevel(text, ttl, int(style))
+}
+
 func (p *Peco) Err() error {
 	return p.err
 }
@@ -251,6 +388,8 @@ func (p *Peco) Exit(err error) {
 }
 
 func (p *Peco) Keymap() Keymap {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	return p.keymap
 }
 
@@ -274,6 +413,18 @@ func (p *Peco) Setup() (err error) {
 		if err := readConfig(&p.config, opts.OptRcfile); err != nil {
 			return errors.Wrap(err, "failed to setup configuration")
 		}
+		for _, w := range p.config.Warnings() {
+			fmt.Fprintf(p.Stderr, "%s\n", w)
+		}
+	}
+
+	if opts.OptPrintConfig {
+		buf, err := json.MarshalIndent(p.config, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed to encode config")
+		}
+		p.Stdout.Write(append(buf, '\n'))
+		return makeIgnorable(errors.New("user asked to print the effective config"))
 	}
 
 	// Take Args, Config, Options, and apply the configuration to
@@ -282,12 +433,46 @@ func (p *Peco) Setup() (err error) {
 		return errors.Wrap(err, "failed to apply configuration")
 	}
 
+	// Like the config file, the history is not loaded in tests
+	if !p.skipReadConfig {
+		p.setupHistory()
+	}
+
 	// XXX p.Keymap et al should be initialized around here
 	p.hub = hub.New(5)
 
 	return nil
 }
 
+// setupHistory loads the query history. Failing to do so is not fatal,
+// peco just runs without the history
+func (p *Peco) setupHistory() {
+	size := p.config.HistorySize
+	if size < 0 {
+		return
+	}
+	if size == 0 {
+		size = DefaultHistorySize
+	}
+
+	filename, err := LocateHistoryFile()
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to locate history file: %s", err)
+		}
+		return
+	}
+
+	h := NewHistory(filename, size)
+	if err := h.Load(); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to load history: %s", err)
+		}
+		return
+	}
+	p.history = h
+}
+
 func (p *Peco) Run(ctx context.Context) (err error) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Peco.Run").BindError(&err)
@@ -302,6 +487,30 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 		return errors.Wrap(err, "failed to setup peco")
 	}
 
+	if p.benchmarkFile != "" {
+		return p.runBenchmark(ctx)
+	}
+
+	if p.countOnly {
+		return p.runCount(ctx)
+	}
+
+	// --replay runs without the terminal, on a screen as large as the
+	// one the actions were logged on
+	var replay []actionLogEntry
+	if p.replayFile != "" {
+		entries, err := readActionLog(p.replayFile)
+		if err != nil {
+			return err
+		}
+		w, h := DefaultReplayWidth, DefaultReplayHeight
+		if len(entries) > 0 {
+			w, h = entries[0].Size[0], entries[0].Size[1]
+		}
+		p.screen = newHeadlessScreen(w, h)
+		replay = entries
+	}
+
 	var _cancelOnce sync.Once
 	var _cancel func()
 	ctx, _cancel = context.WithCancel(ctx)
@@ -320,33 +529,118 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 	// remember this cancel func so p.Exit works (XXX requires locking?)
 	p.cancelFunc = cancel
 
+	// With ReloadOnSIGHUP, SIGHUP is handled by Input along with
+	// SIGWINCH, instead of making us exit
+	exitSigs := []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	if !p.reloadOnSIGHUP {
+		exitSigs = append(exitSigs, syscall.SIGHUP)
+	}
 	sigH := sig.New(sig.SigReceivedHandlerFunc(func(sig os.Signal) {
 		p.Exit(errors.New("received signal: " + sig.String()))
-	}))
+	}), exitSigs...)
 
 	go sigH.Loop(ctx, cancel)
 
+	p.signals = make(chan os.Signal, 1)
+	p.notifySignals(p.signals)
+	defer signal.Stop(p.signals)
+
 	// SetupSource is done AFTER other components are ready, otherwise
 	// we can't draw onto the screen while we are reading a really big
 	// buffer.
 	// Setup source buffer
-	src, err := p.SetupSource(ctx)
+	srcCtx, cancelSource := context.WithCancel(ctx)
+	p.cancelSource = cancelSource
+	src, err := p.SetupSource(srcCtx)
 	if err != nil {
 		return errors.Wrap(err, "failed to setup input source")
 	}
-	p.source = src
+	p.SetSource(src)
+
+	// The file that peco.EmitAndContinue writes to stays open until peco
+	// exits. Opening a FIFO waits until something reads it
+	if p.emitTo != "" {
+		f, err := os.OpenFile(p.emitTo, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s", p.emitTo)
+		}
+		defer f.Close()
+		p.emitOut = f
+	}
+
+	// The log is written from scratch each time, so that it can be
+	// replayed. The queries are executed right away while the actions
+	// are logged or replayed, so that each action waits for its query
+	if filename := p.actionLogFile; filename != "" {
+		f, err := os.Create(filename)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s", filename)
+		}
+		defer f.Close()
+		p.actionLog = f
+	}
+	if p.syncActions() {
+		p.queryExecDelay = 0
+		p.queryDebounce = 0
+	}
+
+	// Errors reading some of the input files are not fatal, and are
+	// reported once the screen has been closed
+	defer p.printSourceErrors(src)
 
+	// The command configured by OnCancelCommand or OnFinishCommand is
+	// executed once the screen has been closed as well
+	defer p.runExitHook()
+
+	// The query is written to WriteQueryTo before that, so that the
+	// command can read it
+	defer p.writeAcceptedQuery()
+	defer p.saveSession()
+
+	screenCh := make(chan struct{})
 	go func() {
+		defer close(screenCh)
 		<-p.source.Ready()
+		if p.source.Size() == 0 {
+			// Ready is only notified without any lines when the input
+			// ended. The screen is brought up as usual, unless
+			// --exit-0 or EmptyInputBehavior says to exit right away
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.source.SetupDone():
+			}
+			if p.source.Size() == 0 && ctx.Err() == nil && !p.handleEmptyInput() {
+				return
+			}
+		}
 		// screen.Init must be called within Run() because we
 		// want to make sure to call screen.Close() after getting
 		// out of Run()
+		if ctx.Err() != nil {
+			return
+		}
 		p.screen.Init()
 		go NewInput(p, p.Keymap(), p.screen.PollEvent(ctx)).Loop(ctx, cancel)
 		go NewView(p).Loop(ctx, cancel)
+		if p.EmptyInputPlaceholder() != "" {
+			p.Hub().SendDraw(&DrawOptions{DisableCache: true})
+		}
 		go NewFilter(p).Loop(ctx, cancel)
+		go p.showProgress(ctx, src)
+		if p.preview != nil {
+			go p.preview.Loop(ctx, p)
+		}
+		if p.annotator != nil {
+			go p.annotator.Run(ctx, p)
+		}
+	}()
+	// If ctx is canceled while the screen is being initialized, wait
+	// for it so that the terminal is restored no matter what
+	defer func() {
+		<-screenCh
+		p.screen.Close()
 	}()
-	defer p.screen.Close()
 
 	if p.Query().Len() <= 0 {
 		// Re-set the source only if there are no queries
@@ -357,26 +651,11 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 		pdebug.Printf("peco is now ready, go go go!")
 	}
 
-	// If this is enabled, we need to check if we have 1 line only
-	// in the buffer. If we do, we select that line and bail out
-	if p.selectOneAndExit {
-		go func() {
-			// Wait till source has read all lines. We should not wait
-			// source.Ready(), because Ready returns as soon as we get
-			// a line, where as SetupDone waits until we're completely
-			// done reading the input
-			<-p.source.SetupDone()
-			// If we have only one line, we just want to bail out
-			// printing that one line as the result
-			if b := p.CurrentLineBuffer(); b.Size() == 1 {
-				if l, err := b.LineAt(0); err == nil {
-					p.resultCh = make(chan line.Line)
-					p.Exit(errCollectResults{})
-					p.resultCh <- l
-					close(p.resultCh)
-				}
-			}
-		}()
+	// If any of these are enabled, we need to check how many lines
+	// the initial query matched, once it has been run against the
+	// entire input
+	if p.selectOneAndExit || p.exitZero || !p.initialIndex.IsZero() {
+		go p.checkInitialResult(ctx, p.initialQuery != "" || len(p.MultiQuery()) > 0)
 	}
 
 	readyOnce.Do(func() { close(p.readyCh) })
@@ -388,13 +667,34 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 		p.Caret().SetPos(utf8.RuneCountInString(q))
 	}
 
-	if p.Query().Len() > 0 {
+	if p.Query().Len() > 0 || len(p.MultiQuery()) > 0 || p.processesSource() {
 		go func() {
 			<-p.source.Ready()
 			p.ExecQuery()
 		}()
 	}
 
+	if p.config.SessionFile != "" {
+		go p.restoreSession(ctx)
+	}
+
+	if p.syncActions() {
+		var replayDone chan struct{}
+		if p.replayFile != "" {
+			replayDone = make(chan struct{})
+			go func() {
+				defer close(replayDone)
+				p.replayActions(ctx, replay)
+			}()
+		}
+		defer func() {
+			p.stopActions(replayDone)
+			if n := p.replayMismatches; n > 0 {
+				err = errors.Errorf("%d of the actions replayed did not lead to the state logged", n)
+			}
+		}()
+	}
+
 	// Alright, done everything we need to do automatically. We'll let
 	// the user play with peco, and when we receive notification to
 	// bail out, the context should be canceled appropriately
@@ -404,6 +704,81 @@ func (p *Peco) Run(ctx context.Context) (err error) {
 	return p.Err()
 }
 
+// printSourceErrors writes the errors that occurred while reading the
+// input files to Stderr
+func (p *Peco) printSourceErrors(src *Source) {
+	for _, err := range src.Errors() {
+		fmt.Fprintf(p.Stderr, "%s\n", err)
+	}
+}
+
+// checkInitialResult waits until the initial query has been run
+// against the entire input. Then, the cursor is placed on the line
+// given by --initial-index. If --select-1 is enabled and exactly one
+// line matched, that line is selected and we bail out as if the user
+// had accepted it. If --exit-0 is enabled and nothing matched, we bail
+// out with a non-zero exit status.
+//
+// Nothing happens if the user changes the query before the initial
+// query completes, as the result no longer reflects the initial query
+func (p *Peco) checkInitialResult(ctx context.Context, hasQuery bool) {
+	var b Buffer
+	if hasQuery {
+		select {
+		case <-ctx.Done():
+			return
+		case b = <-p.firstFilterCh:
+		}
+		if b == nil {
+			return
+		}
+	} else {
+		// Wait till source has read all lines. We should not wait
+		// source.Ready(), because Ready returns as soon as we get
+		// a line, where as SetupDone waits until we're completely
+		// done reading the input
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.source.SetupDone():
+		}
+		if p.Query().Len() > 0 {
+			return
+		}
+		b = p.source
+	}
+
+	if n := b.Size(); n > 0 && !p.initialIndex.IsZero() {
+		p.Hub().SendPaging(initialIndexRequest(p.initialIndex.lineNumber(n)))
+	}
+
+	switch b.Size() {
+	case 0:
+		if p.exitZero {
+			p.Exit(setExitStatus(makeIgnorable(errors.New("no lines matched")), ExitStatusNoSelection))
+		}
+	case 1:
+		if !p.selectOneAndExit {
+			return
+		}
+		// If we have only one line, we just want to bail out
+		// printing that one line as the result
+		if l, err := b.LineAt(0); err == nil {
+			p.Selection().Add(l)
+			p.Exit(errCollectResults{})
+		}
+	}
+}
+
+// firstFilterDone is called by the filter each time a query has been
+// run. Only the first call is recorded: b is the buffer holding the
+// result if the query ran to completion, and nil otherwise
+func (p *Peco) firstFilterDone(b Buffer) {
+	p.firstFilterOnce.Do(func() {
+		p.firstFilterCh <- b
+	})
+}
+
 func (p *Peco) parseCommandLine(opts *CLIOptions, args *[]string, argv []string) error {
 	remaining, err := opts.parse(argv)
 	if err != nil {
@@ -420,6 +795,16 @@ func (p *Peco) parseCommandLine(opts *CLIOptions, args *[]string, argv []string)
 		return makeIgnorable(errors.New("user asked to show version"))
 	}
 
+	if v := opts.OptValidateConfig; v != "" {
+		r := ValidateConfigFile(v)
+		r.WriteTo(p.Stdout)
+		err := makeIgnorable(errors.New("user asked to validate config file"))
+		if len(r.Errors) > 0 {
+			err = setExitStatus(err, ExitStatusError)
+		}
+		return err
+	}
+
 	if opts.OptRcfile == "" {
 		if file, err := LocateRcfile(locateRcfileIn); err == nil {
 			opts.OptRcfile = file
@@ -437,9 +822,37 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 		defer g.End()
 	}
 
-	var in io.Reader
-	var filename string
+	var src *Source
 	switch {
+	case p.globPattern != "":
+		if len(p.args) > 1 || p.sourceAddr != "" {
+			return nil, errors.New("cannot read from both --glob and files or --source")
+		}
+		if pdebug.Enabled {
+			pdebug.Printf("Using the files matching %s as input", p.globPattern)
+		}
+		src, err = NewGlobSource(p.globPattern, p.respectGitignore, p.idgen, p.bufferSize, p.enableSep)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup --glob")
+		}
+	case p.sourceAddr != "":
+		if len(p.args) > 1 {
+			return nil, errors.New("cannot read from both files and --source")
+		}
+		if pdebug.Enabled {
+			pdebug.Printf("Using %s as input", p.sourceAddr)
+		}
+		src, err = NewSocketSource(p.sourceAddr, p.idgen, p.bufferSize, p.enableSep)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open socket for input")
+		}
+	case len(p.args) > 2:
+		// Errors opening each of the files are reported by the source,
+		// so that one missing file doesn't stop us from reading the rest
+		if pdebug.Enabled {
+			pdebug.Printf("Using %d files as input", len(p.args)-1)
+		}
+		src = NewFileSource(p.args[1:], p.idgen, p.bufferSize, p.enableSep)
 	case len(p.args) > 1:
 		f, err := os.Open(p.args[1])
 		if err != nil {
@@ -448,21 +861,17 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 		if pdebug.Enabled {
 			pdebug.Printf("Using %s as input", p.args[1])
 		}
-		in = f
-		filename = p.args[1]
+		src = NewSource(p.args[1], f, p.idgen, p.bufferSize, p.enableSep)
 	case !util.IsTty(p.Stdin):
 		if pdebug.Enabled {
 			pdebug.Printf("Using p.Stdin as input")
 		}
-		in = p.Stdin
-		filename = `-`
+		src = NewSource(`-`, p.Stdin, p.idgen, p.bufferSize, p.enableSep)
 	default:
 		return nil, errors.New("you must supply something to work with via filename or stdin")
 	}
 
-	src := NewSource(filename, in, p.idgen, p.bufferSize, p.enableSep)
-
-	// Block until we receive something from `in`
+	// Block until we receive something from the input
 	if pdebug.Enabled {
 		pdebug.Printf("Blocking until we read something in source...")
 	}
@@ -473,6 +882,8 @@ func (p *Peco) SetupSource(ctx context.Context) (s *Source, err error) {
 	return src, nil
 }
 
+// readConfig reads the config file, and then the project config file
+// if the former enables it. Either file is optional
 func readConfig(cfg *Config, filename string) error {
 	if filename != "" {
 		if err := cfg.ReadFilename(filename); err != nil {
@@ -480,6 +891,16 @@ func readConfig(cfg *Config, filename string) error {
 		}
 	}
 
+	if !cfg.ProjectConfig {
+		return nil
+	}
+	if _, err := os.Stat(projectConfigFilename); err != nil {
+		return nil
+	}
+	if err := cfg.ReadFilename(projectConfigFilename); err != nil {
+		return errors.Wrap(err, "failed to read project config file")
+	}
+
 	return nil
 }
 
@@ -493,6 +914,9 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 		}
 	}
 
+	p.promptPosition = p.config.PromptPosition
+	p.statusPosition = p.config.StatusPosition
+
 	p.maxScanBufferSize = 256
 	if v := p.config.MaxScanBufferSize; v > 0 {
 		p.maxScanBufferSize = v
@@ -501,11 +925,51 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 	if v := opts.OptExec; len(v) > 0 {
 		p.execOnFinish = v
 	}
+	p.sourceAddr = opts.OptSource
+	p.globPattern = opts.OptGlob
+	p.respectGitignore = opts.OptGitignore
+	if p.respectGitignore && p.globPattern == "" {
+		return errors.New("--respect-gitignore requires --glob")
+	}
+	p.resetQueryMode = p.config.ResetQueryOnFilterChange
 
 	p.enableSep = opts.OptEnableNullSep
+	p.readNull = opts.OptReadNull || p.config.ReadNull
+	p.recordSeparator = p.config.RecordSeparator
+	if p.readNull && p.recordSeparator != "" {
+		return errors.New("RecordSeparator cannot be used with --read-null")
+	}
+	if v := p.config.RecordSummary; v != "" {
+		t, err := compileRecordSummary(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid RecordSummary")
+		}
+		p.recordSummary = t
+	}
+	p.print0 = opts.OptPrint0
+	p.emitTo = opts.OptEmitTo
+	p.outputFormat = opts.OptOutput
+
+	p.outputCaptureGroup = p.config.OutputCaptureGroup
+	switch n := opts.OptCaptureGroup; {
+	case n < 0:
+		return errors.Errorf("invalid --output-capture-group: %d", n)
+	case n > 0:
+		p.outputCaptureGroup = n
+	}
 
-	if i := opts.OptInitialIndex; i >= 0 {
-		p.Location().SetLineNumber(i)
+	p.initialIndex = p.config.InitialIndex
+	if v := opts.OptInitialIndex; v != "" {
+		i, err := parseInitialIndex(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid --initial-index")
+		}
+		p.initialIndex = i
+	}
+	// The cursor is moved again once the initial query has been run,
+	// as the line may not have been read yet
+	if i := p.initialIndex; !i.last {
+		p.Location().SetLineNumber(i.value)
 	}
 
 	if v := opts.OptLayout; v != "" {
@@ -519,19 +983,159 @@ func (p *Peco) ApplyConfig(opts CLIOptions) error {
 		p.prompt = v
 	}
 
-	p.onCancel = successKey
-	if opts.OptOnCancel == errorKey || p.config.OnCancel == errorKey {
-		p.onCancel = errorKey
+	p.onCancel = errorKey
+	if v := opts.OptOnCancel; len(v) > 0 {
+		p.onCancel = v
+	} else if v := p.config.OnCancel; len(v) > 0 {
+		p.onCancel = v
 	}
 	p.bufferSize = opts.OptBufferSize
+	if p.bufferSize <= 0 {
+		p.bufferSize = p.config.MaxBufferLines
+	}
 	if v := opts.OptSelectionPrefix; len(v) > 0 {
 		p.selectionPrefix = v
 	} else {
 		p.selectionPrefix = p.config.SelectionPrefix
 	}
-	p.selectOneAndExit = opts.OptSelect1
-	p.initialQuery = opts.OptQuery
+	p.selectOneAndExit = opts.OptSelect1 || p.config.SelectOne
+	p.exitZero = opts.OptExit0 || p.config.ExitZero
+	p.emptyInputBehavior = p.config.EmptyInputBehavior
+	p.reverse = opts.OptReverse || p.config.Reverse
+	p.SetMouseEnabled(p.config.MouseEnable)
+	p.horizontalScrollStep = p.config.HorizontalScrollStep
+	p.followMatch = p.config.FollowMatch
+	p.followMode = p.config.FollowMode
+	p.idleTimeout = time.Duration(p.config.IdleTimeout) * time.Second
+	p.reloadOnSIGHUP = p.config.ReloadOnSIGHUP
+	p.scrollMode = p.config.ScrollMode
+	p.sortMode = SortNone
+	if v := p.config.Sort; v != "" {
+		p.sortMode = v
+	}
+	p.stableOrder = p.config.StableOrder
+	if v := p.config.FrecencyFile; v != "" {
+		t, err := loadFrecencyTable(v)
+		if err != nil {
+			return errors.Wrap(err, "failed to load FrecencyFile")
+		}
+		p.frecency = t
+	}
+	p.keySequenceTimeout = time.Duration(p.config.KeySequenceTimeout) * time.Millisecond
+	p.confirmTimeout = DefaultConfirmTimeout
+	if v := p.config.ConfirmTimeout; v > 0 {
+		p.confirmTimeout = time.Duration(v) * time.Millisecond
+	}
+	p.wholeWord = p.config.WholeWord
+	p.wordChars = p.config.WordChars
+	p.ignoreAccents = p.config.IgnoreAccents
+	p.fuzzyAnchor = p.config.FuzzyAnchor == FuzzyAnchorPrefix
+	p.fuzzyAnchorSeparators = p.config.FuzzyAnchorSeparators
+	p.ansiColors = p.config.AnsiColors
+	p.tabWidth = p.config.TabWidth
+	p.showLineNumbers = p.config.ShowLineNumbers
+	p.lineWrap = p.config.LineWrap
+	p.ellipsis = DefaultEllipsis
+	if v := p.config.Ellipsis; v != "" {
+		p.ellipsis = v
+	}
+	p.quickSelectChars = []rune(DefaultQuickSelectChars)
+	if v := p.config.QuickSelect.Chars; v != "" {
+		p.quickSelectChars = []rune(v)
+	}
+	p.truncateSide = TruncateRight
+	if v := p.config.TruncateSide; v != "" {
+		p.truncateSide = v
+	}
+	p.queryDebounce = time.Duration(p.config.QueryDebounce) * time.Millisecond
+	p.filterBudget = time.Duration(p.config.FilterBudgetMs) * time.Millisecond
+	if v := p.config.RedrawInterval; v > 0 {
+		p.redrawInterval = time.Duration(v) * time.Millisecond
+	}
+	p.maxResults = p.config.MaxResults
+	p.lazyFilter = p.config.LazyFilter
+	if h := p.config.MaxHeight; !h.IsZero() {
+		p.screen = newRegionScreen(p.screen, h)
+	}
+	p.selection.SetLimit(p.config.MaxSelection, p.config.SelectionEvictOldest)
+	p.SetSingleSelection(p.config.SingleSelection)
+	p.preview = NewPreview(p.config.Preview)
+	p.annotator = NewAnnotator(p.config.Annotator)
+	p.spinner = NewSpinner(p.config.Spinner)
+	if v := p.config.PromptCountFormat; v != "" {
+		t, err := compileTemplate("PromptCountFormat", v, promptCount{})
+		if err != nil {
+			return errors.Wrap(err, "invalid PromptCountFormat")
+		}
+		p.promptCountTemplate = t
+	}
+
+	re, err := compileEditorLinePattern(p.config.EditorLinePattern)
+	if err != nil {
+		return errors.Wrap(err, "invalid EditorLinePattern")
+	}
+	p.editorLinePattern = re
+
+	if v := p.config.GroupPattern; v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid GroupPattern")
+		}
+		p.groupPattern = re
+	}
+
+	if v := p.config.SplitPattern; v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid SplitPattern")
+		}
+		p.splitPattern = re
+	}
+
+	if lt := p.config.LineTransform; lt.Pattern != "" {
+		t, err := newLineTransformer(lt)
+		if err != nil {
+			return errors.Wrap(err, "invalid LineTransform pattern")
+		}
+		p.lineTransformer = t
+	}
+
+	if v := p.config.OutputTemplate; v != "" {
+		t, err := compileOutputTemplate(v)
+		if err != nil {
+			return errors.Wrap(err, "invalid OutputTemplate")
+		}
+		p.outputTemplate = t
+	}
+	switch len(opts.OptQuery) {
+	case 0:
+		// --query takes precedence over --query-from
+		if v := opts.OptQueryFrom; v != "" {
+			q, err := readQueryFile(v)
+			if err != nil {
+				return errors.Wrap(err, "invalid --query-from")
+			}
+			p.initialQuery = q
+		}
+	case 1:
+		p.initialQuery = opts.OptQuery[0]
+	default:
+		p.multiQuery = opts.OptQuery
+	}
+	p.benchmarkFile = opts.OptBenchmark
+	p.benchmarkIterations = DefaultBenchmarkIterations
+	if n := opts.OptBenchmarkIter; n > 0 {
+		p.benchmarkIterations = n
+	}
+	p.countOnly = opts.OptCount
+	p.replayFile = opts.OptReplay
+	p.actionLogFile = p.config.ActionLog
 	p.initialFilter = opts.OptInitialFilter
+	if len(p.initialFilter) <= 0 && len(opts.OptInitialMatcher) <= 0 {
+		// The initial query is matched against the AutoFilter rules
+		// unless a filter is given on the command line
+		p.initialFilter, _ = autoFilter(p.config.AutoFilter, p.initialQuery)
+	}
 	if len(p.initialFilter) <= 0 {
 		p.initialFilter = p.config.InitialFilter
 	}
@@ -594,10 +1198,68 @@ func (p *Peco) populateFilters() error {
 	p.filters.Add(filter.NewSmartCase())
 	p.filters.Add(filter.NewRegexp())
 	p.filters.Add(filter.NewFuzzy())
-
+	p.filters.Add(filter.NewFuzzyRanked())
+	p.filters.Add(filter.NewNumeric(p.config.NumericColumn))
+	p.filters.Add(filter.NewFixedString())
+	p.ignoreCaseRegexp = filter.NewIgnoreCaseRegexp()
+
+	// Custom filters read and write records in the same format as
+	// the input
+	delim := byte('\n')
+	if p.readNull {
+		delim = 0
+	}
 	for name, c := range p.config.CustomFilter {
-		f := filter.NewExternalCmd(name, c.Cmd, c.Args, c.BufferThreshold, p.idgen, p.enableSep)
-		p.filters.Add(f)
+		f := filter.NewExternalCmd(name, c.Cmd, c.Args, c.BufferThreshold, c.Retries, p.idgen, p.enableSep, c.highlightMode(), delim)
+		f.SetTimeout(time.Duration(c.Timeout)*time.Millisecond, c.DiscardOnTimeout)
+		if c.InternalFilter == "" {
+			p.filters.Add(f)
+			continue
+		}
+
+		// Only the built-in filters, which have been added above, can
+		// take over from the command
+		internal, err := p.filters.Lookup(c.InternalFilter)
+		if err != nil {
+			return errors.Wrapf(err, "invalid InternalFilter for CustomFilter %s: unknown filter '%s'", name, c.InternalFilter)
+		}
+		p.filters.Add(filter.NewSizeSwitch(f, internal, c.ExecThreshold))
+	}
+
+	names := make([]string, 0, len(p.config.ContentFilter))
+	for name := range p.config.ContentFilter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := p.config.ContentFilter[name]
+		p.filters.Add(filter.NewContents(name, c.Cmd, c.Args, c.Concurrency, time.Duration(c.Timeout)*time.Millisecond))
+	}
+
+	names = make([]string, 0, len(p.config.FuzzyFilter))
+	for name := range p.config.FuzzyFilter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := p.config.FuzzyFilter[name]
+		if c.Ranked {
+			p.filters.Add(filter.NewFuzzyRankedContiguous(name, c.MinContiguous))
+		} else {
+			p.filters.Add(filter.NewFuzzyContiguous(name, c.MinContiguous))
+		}
+	}
+
+	if names := p.config.FilterChain; len(names) > 0 {
+		chain := make([]filter.Filter, len(names))
+		for i, name := range names {
+			f, err := p.filters.Lookup(name)
+			if err != nil {
+				return errors.Wrapf(err, "invalid FilterChain: unknown filter '%s'", name)
+			}
+			chain[i] = f
+		}
+		p.filters.Add(filter.NewChain(chain...))
 	}
 
 	return nil
@@ -605,11 +1267,16 @@ func (p *Peco) populateFilters() error {
 
 func (p *Peco) populateKeymap() error {
 	// Create a new keymap object
-	k := NewKeymap(p.config.Keymap, p.config.Action)
+	k := NewKeymap(p.config.Keymap, p.config.macros(), p.config.CustomAction)
+	k.Conditional = p.config.ConditionalKeymap
 	if err := k.ApplyKeybinding(); err != nil {
 		return errors.Wrap(err, "failed to apply key bindings")
 	}
+
+	// The keymap may be replaced while the input is being read
+	p.mutex.Lock()
 	p.keymap = k
+	p.mutex.Unlock()
 	return nil
 }
 
@@ -631,12 +1298,15 @@ func (p *Peco) SetCurrentLineBuffer(b Buffer) {
 		g := pdebug.Marker("Peco.SetCurrentLineBuffer %s", reflect.TypeOf(b).String())
 		defer g.End()
 	}
+	if _, ok := b.(*reversedBuffer); p.reverse && !ok {
+		b = newReversedBuffer(b)
+	}
 	p.currentLineBuffer = b
 	go p.Hub().SendDraw(nil)
 }
 
 func (p *Peco) ResetCurrentLineBuffer() {
-	p.SetCurrentLineBuffer(p.source)
+	p.SetCurrentLineBuffer(p.Source().(*Source))
 }
 
 func (p *Peco) ExecQuery() bool {
@@ -654,10 +1324,23 @@ func (p *Peco) ExecQuery() bool {
 		return false
 	}
 
+	// Once the user edits the query, we are no longer matching
+	// against the queries given on the command line
+	q := p.Query()
+	if q.Len() > 0 && len(p.MultiQuery()) > 0 {
+		p.SetMultiQuery(nil)
+		p.Hub().SendDrawPrompt()
+	}
+
 	// If this is an empty query, reset the display to show
 	// the raw source buffer
-	q := p.Query()
-	if q.Len() <= 0 {
+	if q.Len() <= 0 && len(p.MultiQuery()) <= 0 {
+		if p.processesSource() {
+			// The raw source buffer still has to be sorted or
+			// deduplicated, which is done by the filter
+			p.Hub().SendQuery("")
+			return true
+		}
 		if pdebug.Enabled {
 			pdebug.Printf("empty query, reset buffer")
 		}
@@ -666,6 +1349,11 @@ func (p *Peco) ExecQuery() bool {
 		return true
 	}
 
+	if d := p.queryDebounce; d > 0 {
+		p.debounceQuery(d)
+		return true
+	}
+
 	delay := p.QueryExecDelay()
 	if delay <= 0 {
 		if pdebug.Enabled {
@@ -705,30 +1393,96 @@ func (p *Peco) ExecQuery() bool {
 	return true
 }
 
+// debounceQuery sends the query once it stops changing for d. Each call
+// restarts the timer, so that typing fast runs the query only once.
+// Sending the query cancels the query that is running, if any
+func (p *Peco) debounceQuery(d time.Duration) {
+	p.queryExecMutex.Lock()
+	defer p.queryExecMutex.Unlock()
+
+	if p.queryExecTimer != nil {
+		p.queryExecTimer.Stop()
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		p.queryExecMutex.Lock()
+		if p.queryExecTimer != t {
+			// The timer fired just as it was being replaced
+			p.queryExecMutex.Unlock()
+			return
+		}
+		p.queryExecTimer = nil
+		p.queryExecMutex.Unlock()
+
+		if pdebug.Enabled {
+			pdebug.Printf("debounced query sent")
+		}
+		p.Hub().SendQuery(p.Query().String())
+	})
+	p.queryExecTimer = t
+}
+
+// PrintResults writes the selected lines, or the current line if
+// nothing is selected, to Stdout. If peco was exited with peco.AcceptAll,
+// all the current lines are written instead. The lines are streamed as
+// they are formatted rather than written all at once at the end
 func (p *Peco) PrintResults() {
+	p.printResults(context.Background())
+}
+
+// printResults writes the results through a pipeline. If ctx is
+// canceled before all the lines are written, the lines that have not
+// been written yet are dropped, but no line is ever written partially
+func (p *Peco) printResults(ctx context.Context) error {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Peco.PrintResults")
 		defer g.End()
 	}
-	selection := p.Selection()
-	if selection.Len() == 0 {
-		if l, err := p.CurrentLineBuffer().LineAt(p.Location().LineNumber()); err == nil {
-			selection.Add(l)
+
+	if p.acceptQuery {
+		_, err := fmt.Fprintf(p.Stdout, "%s%c", p.Query().String(), p.outputDelimiter())
+		return errors.Wrap(err, "failed to print query")
+	}
+
+	selected := !p.acceptAll && !p.SingleSelection() && p.Selection().Len() > 0
+	dst := newResultDestination(p.newResultWriter(p.Stdout), selected)
+
+	pl := pipeline.New()
+	pl.SetSource(p.results(p.acceptAll))
+	if p.config.UniqueOutput {
+		pl.Add(pipeline.Dedup(p.outputKey))
+	}
+	pl.SetDestination(dst)
+	if err := pl.Run(ctx); err != nil {
+		return errors.Wrap(err, "failed to print results")
+	}
+	if dst.err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("%s", dst.err)
 		}
+		return dst.err
 	}
-	p.SetResultCh(make(chan line.Line))
-	go func() {
-		defer close(p.resultCh)
-		p.selection.Ascend(func(it btree.Item) bool {
-			p.ResultCh() <- it.(line.Line)
-			return true
-		})
-	}()
+	return ctx.Err()
+}
 
-	var buf bytes.Buffer
-	for line := range p.ResultCh() {
-		buf.WriteString(line.Output())
-		buf.WriteByte('\n')
+// outputDelimiter returns the byte used to terminate each line
+// of the output
+func (p *Peco) outputDelimiter() byte {
+	if p.print0 {
+		return 0
+	}
+	return '\n'
+}
+
+// resultDelimiter returns the string written between the lines of the
+// results, see OutputDelimiter. --print0 wins over the configuration
+func (p *Peco) resultDelimiter() string {
+	if p.print0 {
+		return "\x00"
+	}
+	if d := p.config.OutputDelimiter; d != "" {
+		return d
 	}
-	p.Stdout.Write(buf.Bytes())
+	return "\n"
 }
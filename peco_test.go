@@ -2,10 +2,12 @@ package peco
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -21,17 +23,18 @@ import (
 
 type nullHub struct{}
 
-func (h nullHub) Batch(_ func(), _ bool)                          {}
-func (h nullHub) DrawCh() chan hub.Payload                        { return nil }
-func (h nullHub) PagingCh() chan hub.Payload                      { return nil }
-func (h nullHub) QueryCh() chan hub.Payload                       { return nil }
-func (h nullHub) SendDraw(_ interface{})                          {}
-func (h nullHub) SendDrawPrompt()                                 {}
-func (h nullHub) SendPaging(_ interface{})                        {}
-func (h nullHub) SendQuery(_ string)                              {}
-func (h nullHub) SendStatusMsg(_ string)                          {}
-func (h nullHub) SendStatusMsgAndClear(_ string, _ time.Duration) {}
-func (h nullHub) StatusMsgCh() chan hub.Payload                   { return nil }
+func (h nullHub) Batch(_ func(), _ bool)                              {}
+func (h nullHub) DrawCh() chan hub.Payload                            { return nil }
+func (h nullHub) PagingCh() chan hub.Payload                          { return nil }
+func (h nullHub) QueryCh() chan hub.Payload                           { return nil }
+func (h nullHub) SendDraw(_ interface{})                              {}
+func (h nullHub) SendDrawPrompt()                                     {}
+func (h nullHub) SendPaging(_ interface{})                            {}
+func (h nullHub) SendQuery(_ string)                                  {}
+func (h nullHub) SendStatusMsg(_ string)                              {}
+func (h nullHub) SendStatusMsgAndClear(_ string, _ time.Duration)     {}
+func (h nullHub) SendStatusMsgLevel(_ string, _ time.Duration, _ int) {}
+func (h nullHub) StatusMsgCh() chan hub.Payload                       { return nil }
 
 type interceptorArgs []interface{}
 type interceptor struct {
@@ -131,6 +134,9 @@ func (d dummyScreen) Size() (int, int) {
 	return d.width, d.height
 }
 func (d dummyScreen) Resume() {}
+func (d dummyScreen) SetMouse(enabled bool) {
+	d.record("SetMouse", interceptorArgs{enabled})
+}
 func (d dummyScreen) Suspend() {}
 
 func TestIDGen(t *testing.T) {
@@ -182,6 +188,27 @@ func TestPecoHelp(t *testing.T) {
 	}
 }
 
+func TestPrintEffectiveConfig(t *testing.T) {
+	p := newPeco()
+	p.Argv = []string{"peco", "--print-effective-config"}
+	out := &bytes.Buffer{}
+	p.Stdout = out
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Second, cancel)
+
+	err := p.Run(ctx)
+	if !assert.True(t, util.IsIgnorableError(err), "p.Run() should return an ignorable error") {
+		return
+	}
+
+	var cfg Config
+	if !assert.NoError(t, json.Unmarshal(out.Bytes(), &cfg), "output should be a config") {
+		return
+	}
+	assert.Equal(t, "QUERY>", cfg.Prompt, "default values should be printed")
+	assert.Equal(t, *NewStyleSet(), cfg.Style, "default styles should be printed")
+}
+
 func TestGHIssue331(t *testing.T) {
 	// Note: we should check that the drawing process did not
 	// use cached display, but ATM this seemed hard to do,
@@ -221,9 +248,9 @@ func TestApplyConfig(t *testing.T) {
 	var opts CLIOptions
 
 	opts.OptPrompt = "tpmorp>"
-	opts.OptQuery = "Hello, World"
+	opts.OptQuery = []string{"Hello, World"}
 	opts.OptBufferSize = 256
-	opts.OptInitialIndex = 2
+	opts.OptInitialIndex = "2"
 	opts.OptInitialFilter = "Regexp"
 	opts.OptLayout = "bottom-up"
 	opts.OptSelect1 = true
@@ -235,7 +262,7 @@ func TestApplyConfig(t *testing.T) {
 		return
 	}
 
-	if !assert.Equal(t, opts.OptQuery, p.initialQuery, "p.initialQuery should be equal to opts.Query") {
+	if !assert.Equal(t, opts.OptQuery[0], p.initialQuery, "p.initialQuery should be equal to opts.Query") {
 		return
 	}
 
@@ -247,7 +274,7 @@ func TestApplyConfig(t *testing.T) {
 		return
 	}
 
-	if !assert.Equal(t, opts.OptInitialIndex, p.Location().LineNumber(), "p.Location().LineNumber() should be equal to opts.OptInitialIndex") {
+	if !assert.Equal(t, 2, p.Location().LineNumber(), "p.Location().LineNumber() should be equal to opts.OptInitialIndex") {
 		return
 	}
 
@@ -384,3 +411,281 @@ func TestGHIssue367(t *testing.T) {
 		return
 	}
 }
+
+func TestSelectOneAndExitZero(t *testing.T) {
+	testValues := []struct {
+		argv   []string
+		output string // empty if peco should not exit
+		status int
+	}{
+		{[]string{"--select-1", "--query", "foo"}, "foo\n", 0},
+		{[]string{"--select-1", "--query", "ba"}, "", 0},
+		{[]string{"--select-1", "--exit-0", "--query", "xyz"}, "", 1},
+		{[]string{"--exit-0", "--query", "foo"}, "", 0},
+		{[]string{"--select-1", "--query", "foo", "--query", "xyz"}, "foo\n", 0},
+		{[]string{"--select-1", "--query", "foo", "--query", "bar"}, "", 0},
+		{[]string{"--exit-0", "--query", "xyz", "--query", "abc"}, "", 1},
+	}
+
+	for _, v := range testValues {
+		t.Run(strings.Join(v.argv, " "), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			p := newPeco()
+			p.Argv = v.argv
+			p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+			var out bytes.Buffer
+			p.Stdout = &out
+
+			err := p.Run(ctx)
+			if v.output == "" && v.status == 0 {
+				// Neither option should have kicked in, so we should
+				// have run until the timeout
+				assert.Equal(t, context.DeadlineExceeded, ctx.Err(), "peco should not exit")
+				return
+			}
+
+			if ctx.Err() != nil {
+				t.Errorf("timeout reached")
+				return
+			}
+
+			if v.status != 0 {
+				if !assert.True(t, util.IsIgnorableError(err), "error should be ignorable") {
+					return
+				}
+				st, ok := util.GetExitStatus(err)
+				assert.True(t, ok, "error should have an exit status")
+				assert.Equal(t, v.status, st, "exit status should match")
+				return
+			}
+
+			if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+				return
+			}
+			p.PrintResults()
+			assert.Equal(t, v.output, out.String(), "output should match")
+		})
+	}
+}
+
+// queryHub records the queries that are sent
+type queryHub struct {
+	nullHub
+	mutex   sync.Mutex
+	queries []string
+}
+
+func (h *queryHub) SendQuery(q string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.queries = append(h.queries, q)
+}
+
+func (h *queryHub) Queries() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string(nil), h.queries...)
+}
+
+func TestQueryDebounce(t *testing.T) {
+	p := newPeco()
+	h := &queryHub{}
+	p.hub = h
+	p.queryDebounce = 100 * time.Millisecond
+	close(p.readyCh)
+
+	// Each change restarts the timer, so only the last query is sent
+	for _, c := range "foo" {
+		p.Query().Set(p.Query().String() + string(c))
+		p.ExecQuery()
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !assert.Empty(t, h.Queries(), "no query should be sent while typing") {
+		return
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if !assert.Equal(t, []string{"foo"}, h.Queries(), "only the last query should be sent") {
+		return
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.config.IdleTimeout = 1
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+
+	start := time.Now()
+	go func() {
+		<-p.Ready()
+		// Input resets the timer
+		time.Sleep(500 * time.Millisecond)
+		p.screen.SendEvent(termbox.Event{Type: termbox.EventKey, Ch: 'f'})
+	}()
+
+	err := p.Run(ctx)
+	if !assert.NoError(t, ctx.Err(), "peco should exit before the timeout") {
+		return
+	}
+	if !assert.True(t, time.Since(start) >= 1500*time.Millisecond, "input should reset the idle timer") {
+		return
+	}
+	if !assert.True(t, util.IsIgnorableError(err), "error should be ignorable") {
+		return
+	}
+	st, ok := util.GetExitStatus(err)
+	if !assert.True(t, ok, "error should have an exit status") {
+		return
+	}
+	assert.Equal(t, 1, st, "exit status should be 1")
+}
+
+func TestExitStatus(t *testing.T) {
+	run := func(t *testing.T, argv []string, input string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = argv
+		p.Stdin = bytes.NewBufferString(input)
+		go func() {
+			<-p.Ready()
+			p.screen.SendEvent(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc})
+		}()
+
+		err := p.Run(ctx)
+		if !assert.NoError(t, ctx.Err(), "peco should exit before the timeout") {
+			return nil
+		}
+		if !assert.True(t, util.IsIgnorableError(err), "error should be ignorable") {
+			return nil
+		}
+		return err
+	}
+
+	t.Run("empty input with --exit-0", func(t *testing.T) {
+		err := run(t, []string{"peco", "--exit-0"}, "")
+		st, ok := util.GetExitStatus(err)
+		if !assert.True(t, ok, "error should have an exit status") {
+			return
+		}
+		assert.Equal(t, ExitStatusNoSelection, st, "exit status should match")
+	})
+	t.Run("cancel", func(t *testing.T) {
+		err := run(t, nil, "foo\n")
+		st, ok := util.GetExitStatus(err)
+		if !assert.True(t, ok, "error should have an exit status") {
+			return
+		}
+		assert.Equal(t, ExitStatusNoSelection, st, "exit status should match")
+	})
+	t.Run("cancel with --on-cancel success", func(t *testing.T) {
+		err := run(t, []string{"--on-cancel", "success"}, "foo\n")
+		_, ok := util.GetExitStatus(err)
+		assert.False(t, ok, "canceling should succeed with --on-cancel success")
+	})
+}
+
+func TestKeySequenceTimeout(t *testing.T) {
+	run := func(t *testing.T, keys ...termbox.Key) (time.Duration, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.config.KeySequenceTimeout = 200
+		p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+
+		started := make(chan time.Time, 1)
+		go func() {
+			<-p.Ready()
+			p.config.Keymap["C-x"] = "peco.Finish"
+			p.config.Keymap["C-x,C-c"] = "peco.Cancel"
+			started <- time.Now()
+			if !assert.NoError(t, p.populateKeymap(), "populateKeymap expected to succeed") {
+				return
+			}
+			for _, k := range keys {
+				p.screen.SendEvent(termbox.Event{Type: termbox.EventKey, Key: k})
+			}
+		}()
+
+		err := p.Run(ctx)
+		if !assert.NoError(t, ctx.Err(), "peco should exit before the timeout") {
+			return 0, err
+		}
+		return time.Since(<-started), err
+	}
+
+	t.Run("complete sequence", func(t *testing.T) {
+		_, err := run(t, termbox.KeyCtrlX, termbox.KeyCtrlC)
+		assert.True(t, util.IsIgnorableError(err), "longer sequence should be executed")
+	})
+
+	t.Run("expired sequence", func(t *testing.T) {
+		elapsed, err := run(t, termbox.KeyCtrlX)
+		if !assert.True(t, util.IsCollectResultsError(err), "prefix should be executed once the sequence expires") {
+			return
+		}
+		assert.True(t, elapsed >= 200*time.Millisecond, "prefix should not be executed before the timeout")
+	})
+}
+
+func TestReverse(t *testing.T) {
+	run := func(t *testing.T, query string, expected []string, action func(context.Context, *Peco)) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = []string{"peco", "--reverse"}
+		p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+		out := bytes.Buffer{}
+		p.Stdout = &out
+		resultCh := make(chan error)
+		go func() { resultCh <- p.Run(ctx) }()
+
+		<-p.Ready()
+		<-p.source.SetupDone()
+		if query != "" {
+			p.Query().Set(query)
+			p.ExecQuery()
+		}
+
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should be reversed")
+				return ""
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		action(ctx, p)
+		doFinish(ctx, p, termbox.Event{})
+		if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+			return ""
+		}
+		p.PrintResults()
+		return out.String()
+	}
+
+	t.Run("selection", func(t *testing.T) {
+		out := run(t, "", []string{"baz", "bar", "foo"}, func(ctx context.Context, p *Peco) {
+			doToggleSelection(ctx, p, termbox.Event{})
+			p.Location().SetLineNumber(2)
+			doToggleSelection(ctx, p, termbox.Event{})
+		})
+		assert.Equal(t, "foo\nbaz\n", out, "selection should be output in the order of the input")
+	})
+
+	t.Run("query", func(t *testing.T) {
+		out := run(t, "ba", []string{"baz", "bar"}, func(ctx context.Context, p *Peco) {
+			p.Location().SetLineNumber(1)
+		})
+		assert.Equal(t, "bar\n", out, "current line should be output")
+	})
+}
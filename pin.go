@@ -0,0 +1,58 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// doPinCurrent selects the current line, and keeps it selected when the
+// query changes, even without StickySelection
+func doPinCurrent(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doPinCurrent")
+		defer g.End()
+	}
+
+	l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber())
+	if err != nil {
+		return
+	}
+	if state.skipsGroupHeaders() && state.isGroupHeader(l) {
+		return
+	}
+
+	selection := state.Selection()
+	if selection.Pinned(l) {
+		return
+	}
+	if !selection.Pin(l) {
+		notifySelectionFull(state)
+		return
+	}
+	state.Hub().SendDrawPrompt()
+}
+
+// doUnpinCurrent undoes peco.PinCurrent on the current line. The line
+// stays selected until the query changes, like any other selected line
+func doUnpinCurrent(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doUnpinCurrent")
+		defer g.End()
+	}
+
+	l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber())
+	if err != nil {
+		return
+	}
+
+	selection := state.Selection()
+	if !selection.Pinned(l) {
+		state.Hub().SendStatusMsgAndClear("Line is not pinned", time.Second)
+		return
+	}
+	selection.Unpin(l)
+	state.Hub().SendDrawPrompt()
+}
@@ -0,0 +1,65 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinCurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+	out := &bytes.Buffer{}
+	p.Stdout = out
+	resultCh := make(chan error)
+	go func() { resultCh <- p.Run(ctx) }()
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	for p.CurrentLineBuffer().Size() < 3 {
+		select {
+		case <-ctx.Done():
+			t.Errorf("lines were not displayed")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	doPinCurrent(ctx, p, termbox.Event{})
+	p.Location().SetLineNumber(1)
+	doToggleSelection(ctx, p, termbox.Event{})
+	if !assert.Equal(t, 2, p.Selection().Len(), "both lines should be selected") {
+		return
+	}
+	if !assert.True(t, strings.HasSuffix(promptCountMessage(p), " 1 pinned"), "pinned lines should be counted") {
+		return
+	}
+
+	p.Query().Set("ba")
+	p.ExecQuery()
+	for p.Selection().Len() != 1 {
+		select {
+		case <-ctx.Done():
+			t.Errorf("selection should only keep the pinned line, got %d lines", p.Selection().Len())
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	doFinish(ctx, p, termbox.Event{})
+	if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "peco should exit with the results") {
+		return
+	}
+	p.PrintResults()
+	assert.Equal(t, "foo\n", out.String(), "pinned line should be output")
+}
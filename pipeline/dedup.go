@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+)
+
+// defaultDedupKey is the key used by Dedup and DedupAdjacent when no
+// key function is given
+func defaultDedupKey(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Dedup creates an Acceptor that only sends the first of the values
+// that have the same key downstream. Keys are computed by calling key
+// on each value. If key is nil, strings are used as is, and other
+// values are formatted with fmt.Sprint. The EndMark is forwarded
+// unchanged.
+//
+// Every key that was seen is remembered until the EndMark is received,
+// so the memory used grows with the number of unique values. Use
+// DedupAdjacent instead if the duplicates are known to be next to
+// each other, such as in sorted input.
+func Dedup(key func(interface{}) string) Acceptor {
+	if key == nil {
+		key = defaultDedupKey
+	}
+	return &dedupNode{key: key}
+}
+
+// DedupAdjacent is like Dedup, but only drops values that have the
+// same key as the value right before them. Only the last key is
+// remembered, so it runs in constant memory.
+func DedupAdjacent(key func(interface{}) string) Acceptor {
+	if key == nil {
+		key = defaultDedupKey
+	}
+	return &dedupNode{key: key, adjacent: true}
+}
+
+// Accept drops the values whose keys have already been seen
+func (d *dedupNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("dedupNode.Accept (adjacent = %t)", d.adjacent)
+		defer g.End()
+	}
+	defer out.Close()
+
+	var seen map[string]struct{}
+	if !d.adjacent {
+		seen = make(map[string]struct{})
+	}
+
+	var prev string
+	var first = true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			if isEndMarkValue(v) {
+				out.SendCtx(ctx, v)
+				return
+			}
+
+			k := d.key(v)
+			if d.adjacent {
+				if !first && k == prev {
+					continue
+				}
+				prev = k
+				first = false
+			} else {
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				seen[k] = struct{}{}
+			}
+
+			if err := out.SendCtx(ctx, v); err != nil {
+				return
+			}
+		}
+	}
+}
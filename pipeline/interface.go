@@ -2,10 +2,21 @@ package pipeline
 
 import (
 	"sync"
+	"time"
 
 	"context"
 )
 
+// TraceFunc is called by the pipeline internals to report on the flow
+// of data. It is a no-op by default. Assign something like `log.Printf`
+// to it to debug the pipeline:
+//
+//	pipeline.TraceFunc = log.Printf
+//
+// Callers on the hot path only pass constant strings, so the default
+// no-op does not allocate.
+var TraceFunc = func(string, ...interface{}) {}
+
 // EndMarker is an interface for things that tell us the input
 // sequence has ended
 type EndMarker interface {
@@ -13,9 +24,34 @@ type EndMarker interface {
 	EndMark() bool
 }
 
+// Ranked is a value that was given a score by a node that ranks the
+// values it sends, such as a filter that ranks the lines by how well
+// they match. Spans are the regions of the value that were matched, as
+// start and end offsets. Nodes that do not rank the values send them as
+// they are, so the nodes that come after them must handle both. See
+// Unrank
+type Ranked struct {
+	Value interface{}
+	Score int
+	Spans [][2]int
+}
+
 // EndMark is a dummy struct that gets send as an EOL mark of sorts
 type EndMark struct{}
 
+// EndMarkData describes the input that ended, for destinations that
+// need to know more than the fact that it has ended, such as those
+// fed by multiple sources. See SendEndMarkData and EndMarkInfo
+type EndMarkData struct {
+	Source string // identifies the source that sent the end mark
+	Count  int    // number of values sent before the end mark
+}
+
+// endMarkWithData is an EndMark that carries EndMarkData
+type endMarkWithData struct {
+	data EndMarkData
+}
+
 type Source interface {
 	// Start should be able to be called repeatedly, producing the
 	// same data to be consumed by the chained Acceptors
@@ -25,7 +61,11 @@ type Source interface {
 }
 
 // Acceptor is an object that can accept input, and send to
-// an optional output
+// an optional output. The input ends with an EndMark, or when it is
+// closed. Nothing may be sent to the output after the EndMark, and
+// Acceptors may close it once they are done, as those in this package
+// do. Acceptors that receive from Acceptors which do not close their
+// output must stop at the EndMark rather than range over the input
 type Acceptor interface {
 	Accept(context.Context, chan interface{}, ChanOutput)
 }
@@ -38,13 +78,155 @@ type Destination interface {
 	Acceptor
 }
 
+// ErrDestination is implemented by destinations that can fail while
+// they take in the values, such as a destination that writes them to a
+// pipe. Once the destination is done, Run returns the error given by
+// Err, unless one of the nodes reported an error first. Destinations
+// that do not implement it are treated as never failing
+type ErrDestination interface {
+	Destination
+	Err() error
+}
+
 // Pipeline is encapsulates a chain of `Source`, `ProcNode`s, and `Destination`
 type Pipeline struct {
-	done  chan struct{}
-	mutex sync.Mutex
-	nodes []Acceptor
-	src   Source
-	dst   Destination
+	done           chan struct{}
+	mutex          sync.Mutex
+	nodes          []Acceptor
+	src            Source
+	dst            Destination
+	metricsEnabled bool
+	metrics        []NodeMetric
+	budget         time.Duration // how often the destination is asked to yield, 0 to never ask
+}
+
+// Yielder is implemented by Destinations that can make the values they
+// have received so far available before all of them have arrived. If
+// the Pipeline has a budget, Yield is called once the budget elapses,
+// and again each time it elapses while the Pipeline is still running
+type Yielder interface {
+	Yield()
+}
+
+// NodeMetric holds the numbers collected for a single node while
+// the Pipeline was running. EndMarks are not counted as items
+type NodeMetric struct {
+	ItemsIn  int
+	ItemsOut int
+	Elapsed  time.Duration
+}
+
+// metricsNode wraps an Acceptor, and records how much data went
+// through it
+type metricsNode struct {
+	Acceptor
+	metric *NodeMetric
+}
+
+// ErrorReporter is used by the nodes in a Pipeline to report a fatal
+// error. The first error reported is returned from Run, and the rest
+// of the pipeline is canceled via the context
+type ErrorReporter interface {
+	ReportError(error)
+}
+
+// NodePanicError is the error that Run returns when one of the nodes
+// panics. The panic is recovered, and the rest of the pipeline is
+// canceled as if the node had reported the error. The Source and the
+// Destination are recovered as well: the Index of the Source is -1,
+// and that of the Destination is the number of nodes
+type NodePanicError struct {
+	Index int         // position of the node, in the order it was added
+	Value interface{} // the value passed to panic
+	Stack []byte      // the stack of the goroutine that panicked
+}
+
+// errorReporter is the ErrorReporter that Run stores in the context
+// passed to the nodes
+type errorReporter struct {
+	cancel func()
+	errCh  chan error
+}
+
+// sourceStopper is the function that StopSource finds in the context
+// passed to the nodes. It cancels the context passed to the Source
+type sourceStopper func()
+
+// collector is a Destination that wraps another Destination, and
+// remembers every value that goes through it. index is the index that
+// the wrapped Destination is reported as if it panics
+type collector struct {
+	Destination
+	index  int
+	done   chan struct{}
+	mutex  sync.Mutex
+	values []interface{}
+}
+
+// SliceDestination is a Destination that keeps the values it receives
+// in a slice. See NewSliceDestination
+type SliceDestination struct {
+	done   chan struct{}
+	mutex  sync.RWMutex
+	values []interface{}
+}
+
+// TeeDestination is a Destination that forwards everything it receives
+// to multiple Destinations. Each Destination receives the values in
+// the same order, but the delivery across Destinations is not
+// synchronized: a fast Destination may be ahead of a slow one by at
+// most the size of the per-branch buffer.
+type TeeDestination struct {
+	bufsiz int
+	done   chan struct{}
+	dsts   []Destination
+	mutex  sync.RWMutex
+}
+
+// batchNode is an Acceptor that groups the values it receives into
+// slices. See Batch
+type batchNode struct {
+	size int
+}
+
+// unbatchNode is an Acceptor that splits the slices created by a
+// batchNode back into individual values. See Unbatch
+type unbatchNode struct{}
+
+// dedupNode is an Acceptor that drops values with duplicate keys. See
+// Dedup and DedupAdjacent
+type dedupNode struct {
+	adjacent bool // only compare against the previous value
+	key      func(interface{}) string
+}
+
+// splitNode is an Acceptor that expands each value into any number of
+// values. See Split
+type splitNode struct {
+	fn func(interface{}) []interface{}
+}
+
+// LimitNode is an Acceptor that only forwards a limited number of
+// values. See Limit
+type LimitNode struct {
+	max     int
+	limited int32 // 1 if values were dropped, accessed atomically
+}
+
+// RateLimitNode is an Acceptor that forwards values at a limited rate.
+// See RateLimit and RateLimitDrop
+type RateLimitNode struct {
+	perSecond int
+	drop      bool  // drop the excess values instead of buffering them
+	dropped   int64 // values dropped by the last run, accessed atomically
+}
+
+// tokenBucket allows up to rate values per second, with bursts of up
+// to rate values
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
 }
 
 type Output interface {
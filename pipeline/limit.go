@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+)
+
+// Limit creates an Acceptor that forwards up to n values. Once n values
+// have been forwarded, the EndMark is sent right away, and the Source
+// is told to stop via StopSource, as nothing that it sends afterwards
+// would make it downstream. If n is less than 1, values are forwarded
+// without a limit.
+func Limit(n int) *LimitNode {
+	return &LimitNode{max: n}
+}
+
+// Limited returns true if the last run of the node dropped values
+// because of the limit. A run that received exactly as many values as
+// the limit allows is not limited.
+func (l *LimitNode) Limited() bool {
+	return atomic.LoadInt32(&l.limited) == 1
+}
+
+// Accept forwards the values it receives until the limit is reached
+func (l *LimitNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("LimitNode.Accept (max = %d)", l.max)
+		defer g.End()
+	}
+	defer out.Close()
+
+	atomic.StoreInt32(&l.limited, 0)
+
+	var sent int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			if isEndMarkValue(v) {
+				out.SendCtx(ctx, v)
+				return
+			}
+
+			if l.max > 0 && sent >= l.max {
+				atomic.StoreInt32(&l.limited, 1)
+				TraceFunc("pipeline: limit reached")
+				out.SendCtx(ctx, EndMark{})
+				StopSource(ctx)
+				drain(ctx, in)
+				return
+			}
+
+			if err := out.SendCtx(ctx, v); err != nil {
+				return
+			}
+			sent++
+		}
+	}
+}
+
+// drain discards the values sent to in until the EndMark, so that the
+// nodes upstream do not block while they wind down
+func drain(ctx context.Context, in chan interface{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok || isEndMarkValue(v) {
+				return
+			}
+		}
+	}
+}
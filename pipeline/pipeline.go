@@ -2,6 +2,9 @@
 package pipeline
 
 import (
+	"fmt"
+	"runtime/debug"
+	"sync"
 	"time"
 
 	"context"
@@ -20,15 +23,127 @@ func (e EndMark) Error() string {
 	return "end of input"
 }
 
+// EndMark returns true
+func (e endMarkWithData) EndMark() bool {
+	return true
+}
+
+// Error returns the error string "end of input"
+func (e endMarkWithData) Error() string {
+	return "end of input"
+}
+
 // IsEndMark is an utility function that checks if the given error
 // object is an EndMark
 func IsEndMark(err error) bool {
-	if em, ok := errors.Cause(err).(EndMarker); ok {
-		return em.EndMark()
+	if em, ok := errors.Cause(err).(EndMarker); ok && em.EndMark() {
+		TraceFunc("pipeline: detected end mark")
+		return true
 	}
 	return false
 }
 
+// EndMarkInfo returns the data carried by the end mark, if it was sent
+// by SendEndMarkData. The second return value is false if err is not an
+// end mark, or if it does not carry any data
+func EndMarkInfo(err error) (EndMarkData, bool) {
+	if em, ok := errors.Cause(err).(endMarkWithData); ok {
+		return em.data, true
+	}
+	return EndMarkData{}, false
+}
+
+// Note: this must not be a pointer to a zero-sized value, as those
+// may compare equal to other such context keys (e.g. filter's queryKey)
+type errorReporterKeyType struct{}
+
+var errorReporterKey = errorReporterKeyType{}
+
+type sourceStopperKeyType struct{}
+
+var sourceStopperKey = sourceStopperKeyType{}
+
+func newErrorReporter(cancel func()) *errorReporter {
+	return &errorReporter{
+		cancel: cancel,
+		errCh:  make(chan error, 1),
+	}
+}
+
+// ReportError records the error, and cancels the pipeline. Only the
+// first error is kept, and the rest are silently discarded
+func (r *errorReporter) ReportError(err error) {
+	if err == nil {
+		return
+	}
+
+	select {
+	case r.errCh <- err:
+		TraceFunc("pipeline: error reported")
+	default:
+	}
+	r.cancel()
+}
+
+// Err returns the error that was reported, if any
+func (r *errorReporter) Err() error {
+	select {
+	case err := <-r.errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Error returns the index of the node, and the value it panicked with
+func (e *NodePanicError) Error() string {
+	return fmt.Sprintf("pipeline: node %d panicked: %v", e.Index, e.Value)
+}
+
+// recoverNode recovers from a panic in the i-th node, and reports it
+// as a NodePanicError. Must be deferred by the goroutine that runs the
+// node. If failed is not nil, it is closed once the panic is reported
+func recoverNode(ctx context.Context, i int, failed chan struct{}) {
+	if v := recover(); v != nil {
+		TraceFunc("pipeline: node panicked")
+		ReportError(ctx, &NodePanicError{Index: i, Value: v, Stack: debug.Stack()})
+		if failed != nil {
+			close(failed)
+		}
+	}
+}
+
+type nilErrorReporter struct{}
+
+func (nilErrorReporter) ReportError(error) {}
+
+// ErrorReporterFromContext returns the ErrorReporter associated with
+// the context that Run passes to the nodes. If there is none, an
+// ErrorReporter that discards all errors is returned
+func ErrorReporterFromContext(ctx context.Context) ErrorReporter {
+	if r, ok := ctx.Value(errorReporterKey).(ErrorReporter); ok {
+		return r
+	}
+	return nilErrorReporter{}
+}
+
+// ReportError is a shortcut for ErrorReporterFromContext(ctx).ReportError(err)
+func ReportError(ctx context.Context, err error) {
+	ErrorReporterFromContext(ctx).ReportError(err)
+}
+
+// StopSource tells the Source of the Pipeline that passed ctx to the
+// node to stop sending values, for nodes that do not need any more of
+// them. The rest of the Pipeline keeps running, so the node is still
+// responsible for sending the EndMark downstream. Does nothing if ctx
+// does not come from a Pipeline
+func StopSource(ctx context.Context) {
+	if stop, ok := ctx.Value(sourceStopperKey).(sourceStopper); ok {
+		TraceFunc("pipeline: stopping source")
+		stop()
+	}
+}
+
 func NilOutput(ctx context.Context) ChanOutput {
 	ch := make(chan interface{})
 	go func() {
@@ -44,16 +159,45 @@ func NilOutput(ctx context.Context) ChanOutput {
 	return ChanOutput(ch)
 }
 
-// OutCh returns the channel that acceptors can listen to
+// ErrOutputClosed is returned when sending to a ChanOutput that has
+// been closed
+var ErrOutputClosed = errors.New("send on closed output")
+
+// recoverClosed turns the panic caused by sending to a closed channel
+// into ErrOutputClosed. Must be deferred by the function that sends
+func recoverClosed(err *error) {
+	if v := recover(); v != nil {
+		TraceFunc("pipeline: send on closed output")
+		*err = ErrOutputClosed
+	}
+}
+
+// OutCh returns the channel that acceptors can listen to. Once the
+// sender is done, the channel is either closed, or it receives an
+// EndMark, so it can be ranged over as long as the EndMark is checked
+// for too
 func (oc ChanOutput) OutCh() <-chan interface{} {
 	return oc
 }
 
-// Send sends the data `v` through this channel
+// Close tells the receiving end that nothing more is going to be
+// sent. The receivers in this package treat it like an EndMark, so
+// senders may close the output instead of, or after, sending one.
+// Nothing may be sent once the output is closed, and like a channel,
+// it must be closed only once, by the sender
+func (oc ChanOutput) Close() {
+	if oc != nil {
+		close(oc)
+	}
+}
+
+// Send sends the data `v` through this channel. ErrOutputClosed is
+// returned if the output has been closed
 func (oc ChanOutput) Send(v interface{}) (err error) {
 	if oc == nil {
 		return errors.New("nil channel")
 	}
+	defer recoverClosed(&err)
 
 	// We allow ourselves a timeout of 1 second.
 	t := time.NewTimer(time.Second)
@@ -67,11 +211,64 @@ func (oc ChanOutput) Send(v interface{}) (err error) {
 	return nil
 }
 
-// SendEndMark sends an end mark
+// SendCtx sends the data `v` through this channel, giving up as soon
+// as the context is canceled. Unlike Send, there is no timeout, so this
+// should be used when the receiving end is known to be alive for as long
+// as the context is. ErrOutputClosed is returned if the output has been
+// closed
+func (oc ChanOutput) SendCtx(ctx context.Context, v interface{}) (err error) {
+	if oc == nil {
+		return errors.New("nil channel")
+	}
+	defer recoverClosed(&err)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case oc <- v:
+	}
+	return nil
+}
+
+// SendEndMark sends an end mark. Nothing may be sent afterwards, but
+// the output is left open for the senders that predate Close
 func (oc ChanOutput) SendEndMark(s string) error {
 	return errors.Wrap(oc.Send(errors.Wrap(EndMark{}, s)), "failed to send end mark")
 }
 
+// SendEndMarkCtx sends an end mark like SendEndMark, but gives up as
+// soon as the context is canceled instead of after a timeout, like
+// SendCtx. Sources and nodes should use it, so that they do not linger
+// once the receiving end is gone
+func (oc ChanOutput) SendEndMarkCtx(ctx context.Context, s string) error {
+	return errors.Wrap(oc.SendCtx(ctx, errors.Wrap(EndMark{}, s)), "failed to send end mark")
+}
+
+// SendEndMarkAndClose sends an end mark like SendEndMark, and closes
+// the output, so that receivers that range over OutCh are done too.
+// The output is closed even if the end mark could not be sent
+func (oc ChanOutput) SendEndMarkAndClose(s string) error {
+	defer oc.Close()
+	return oc.SendEndMark(s)
+}
+
+// SendEndMarkData sends an end mark that carries data, which the
+// receiving end can retrieve with EndMarkInfo. The end mark is detected
+// by IsEndMark, and its message is s, just like those sent by
+// SendEndMark
+func (oc ChanOutput) SendEndMarkData(s string, data EndMarkData) error {
+	em := endMarkWithData{data: data}
+	return errors.Wrap(oc.Send(errors.Wrap(em, s)), "failed to send end mark")
+}
+
+// SendEndMarkDataCtx sends an end mark that carries data like
+// SendEndMarkData, but gives up as soon as the context is canceled,
+// like SendEndMarkCtx
+func (oc ChanOutput) SendEndMarkDataCtx(ctx context.Context, s string, data EndMarkData) error {
+	em := endMarkWithData{data: data}
+	return errors.Wrap(oc.SendCtx(ctx, errors.Wrap(em, s)), "failed to send end mark")
+}
+
 // New creates a new Pipeline
 func New() *Pipeline {
 	return &Pipeline{
@@ -106,8 +303,29 @@ func (p *Pipeline) SetDestination(d Destination) {
 	p.dst = d
 }
 
+// Reset clears the source, the destination, the nodes and the rest of
+// the configuration, leaving the Pipeline as New created it, so that it
+// can be set up again for another Run. Reset must not be called while
+// `Run` is running: like the other mutators it blocks until `Run`
+// returns, but the goroutines left behind by RunWithTimeout may still
+// be using the nodes that were cleared.
+func (p *Pipeline) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.src = nil
+	p.dst = nil
+	p.nodes = nil
+	p.budget = 0
+	p.metricsEnabled = false
+	p.metrics = nil
+	p.done = make(chan struct{})
+}
+
 // Run starts the processing. Mutator methods for `Pipeline` cannot be
-// called while `Run` is running.
+// called while `Run` is running. The same Pipeline may be run again
+// once `Run` returns, with the same configuration or with another one
+// set up after Reset.
 func (p *Pipeline) Run(ctx context.Context) (err error) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Pipeline.Run (%s)", ctx.Value("query")).BindError(&err)
@@ -115,13 +333,69 @@ func (p *Pipeline) Run(ctx context.Context) (err error) {
 	}
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
+
+	return p.run(ctx, p.dst, 0)
+}
+
+// RunWithTimeout is like Run, but once the context is canceled, it only
+// waits for at most `drainTimeout` for the destination to be done. If
+// the destination is not done by then, an error wrapping
+// context.DeadlineExceeded is returned. The goroutines spawned by the
+// Pipeline are still told to stop via the context, but they may
+// outlive this call.
+func (p *Pipeline) RunWithTimeout(ctx context.Context, drainTimeout time.Duration) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Pipeline.RunWithTimeout (%s)", ctx.Value("query")).BindError(&err)
+		defer g.End()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.run(ctx, p.dst, drainTimeout)
+}
+
+// RunWithResult is like Run, but also returns the values that reached
+// the destination, in the order that they were received. EndMarks are
+// not included in the result.
+func (p *Pipeline) RunWithResult(ctx context.Context) (result []interface{}, err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Pipeline.RunWithResult (%s)", ctx.Value("query")).BindError(&err)
+		defer g.End()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Careful not to create a non-nil interface from a nil destination
+	var dst Destination
+	var c *collector
+	if p.dst != nil {
+		c = &collector{Destination: p.dst, index: len(p.nodes)}
+		dst = c
+	}
+
+	if err := p.run(ctx, dst, 0); err != nil {
+		return nil, err
+	}
+	return c.Values(), nil
+}
+
+// run does the actual work for Run and friends. p.mutex must
+// be held by the caller. If drainTimeout is <= 0, we wait for the
+// destination to be done no matter what
+func (p *Pipeline) run(ctx context.Context, dst Destination, drainTimeout time.Duration) error {
+	// The channel closed by the previous run can't be closed again
+	select {
+	case <-p.done:
+		p.done = make(chan struct{})
+	default:
+	}
 	defer close(p.done)
 
 	if p.src == nil {
 		return errors.New("source must be non-nil")
 	}
 
-	if p.dst == nil {
+	if dst == nil {
 		return errors.New("destination must be non-nil")
 	}
 
@@ -129,33 +403,331 @@ func (p *Pipeline) Run(ctx context.Context) (err error) {
 	// any state changes that may have happened in the end of
 	// the previous call to Run()
 	p.src.Reset()
-	p.dst.Reset()
+	dst.Reset()
+
+	// Nodes may report fatal errors via the context. When they do,
+	// everybody else is told to stop via the same context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reporter := newErrorReporter(cancel)
+	ctx = context.WithValue(ctx, errorReporterKey, reporter)
+
+	// The Source gets a context of its own, so that the nodes can tell
+	// it to stop without stopping each other
+	srcCtx, stopSource := context.WithCancel(ctx)
+	defer stopSource()
+	ctx = context.WithValue(ctx, sourceStopperKey, sourceStopper(stopSource))
 
 	// Setup the Acceptors, effectively chaining all nodes
 	// starting from the destination, working all the way
 	// up to the Source
 	var prevCh ChanOutput = ChanOutput(make(chan interface{}))
-	go p.dst.Accept(ctx, prevCh, nil)
 
+	// The Source and the Destination are recovered like the nodes. If
+	// the Destination panics, it is never done, so we stop waiting for
+	// it when it fails
+	dstFailed := make(chan struct{})
+	go func(in ChanOutput) {
+		defer recoverNode(ctx, len(p.nodes), dstFailed)
+		dst.Accept(ctx, in, nil)
+	}(prevCh)
+
+	// When metrics are enabled, we wrap each node so that we can
+	// count what goes in and out of it. Otherwise the nodes are used
+	// as is.
+	var wg sync.WaitGroup
+	if p.metricsEnabled {
+		p.metrics = make([]NodeMetric, len(p.nodes))
+	}
+	// A node that panics is stopped, and the panic is returned as
+	// an error, so that the caller gets a chance to clean up
 	for i := len(p.nodes) - 1; i >= 0; i-- {
 		cur := p.nodes[i]
 		ch := make(chan interface{}) //
-		go cur.Accept(ctx, ch, prevCh)
+		if p.metricsEnabled {
+			mn := &metricsNode{Acceptor: cur, metric: &p.metrics[i]}
+			wg.Add(1)
+			go func(i int, out ChanOutput) {
+				defer wg.Done()
+				defer recoverNode(ctx, i, nil)
+				mn.Accept(ctx, ch, out)
+			}(i, prevCh)
+		} else {
+			go func(i int, out ChanOutput) {
+				defer recoverNode(ctx, i, nil)
+				cur.Accept(ctx, ch, out)
+			}(i, prevCh)
+		}
 		prevCh = ChanOutput(ch)
 	}
 
 	// And now tell the Source to send the values so data chugs
 	// through the pipeline
-	go p.src.Start(ctx, prevCh)
+	go func(out ChanOutput) {
+		defer recoverNode(ctx, -1, nil)
+		p.src.Start(srcCtx, out)
+	}(prevCh)
+
+	if y, ok := dst.(Yielder); ok && p.budget > 0 {
+		go yieldEvery(ctx, p.budget, dst, y)
+	}
 
 	// Wait till we're done
-	<-p.dst.Done()
+	if drainTimeout <= 0 {
+		select {
+		case <-dst.Done():
+		case <-dstFailed:
+		}
+	} else {
+		select {
+		case <-dst.Done():
+		case <-dstFailed:
+		case <-ctx.Done():
+			// We have been canceled. Give the destination some time to
+			// finish up, but don't wait forever
+			t := time.NewTimer(drainTimeout)
+			defer t.Stop()
+			select {
+			case <-dst.Done():
+			case <-dstFailed:
+			case <-t.C:
+				TraceFunc("pipeline: timed out waiting for destination")
+				return errors.Wrap(context.DeadlineExceeded, "timed out waiting for pipeline to drain")
+			}
+		}
+	}
+	TraceFunc("pipeline: destination is done")
+
+	// Make sure that the numbers are final before anybody gets to
+	// call Metrics()
+	if p.metricsEnabled {
+		cancel()
+		wg.Wait()
+	}
+
+	if err := reporter.Err(); err != nil {
+		return err
+	}
+	return destinationErr(dst)
+}
 
+// destinationErr returns the error of the destination, if it is an
+// ErrDestination
+func destinationErr(d Destination) error {
+	if ed, ok := d.(ErrDestination); ok {
+		return ed.Err()
+	}
 	return nil
 }
 
+// SetBudget sets how long the Pipeline may run before the Destination
+// is asked to yield the values it has received so far, if it is a
+// Yielder. The Pipeline keeps running afterwards. 0 disables yielding.
+// If called during `Run`, this method will block.
+func (p *Pipeline) SetBudget(d time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.budget = d
+}
+
+// yieldEvery calls y.Yield each time d elapses, until dst is done or
+// ctx is canceled
+func yieldEvery(ctx context.Context, d time.Duration, dst Destination, y Yielder) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-dst.Done():
+			return
+		case <-t.C:
+			TraceFunc("pipeline: budget elapsed, yielding")
+			y.Yield()
+		}
+	}
+}
+
+// EnableMetrics tells the Pipeline to collect per node metrics during
+// the next call to Run. If this is never called, the nodes are run
+// without any additional overhead.
+// If called during `Run`, this method will block.
+func (p *Pipeline) EnableMetrics() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.metricsEnabled = true
+}
+
+// Metrics returns the metrics collected during the last call to Run,
+// one for each node, in the order that they were added to the
+// Pipeline. Returns nil if metrics are not enabled.
+// If called during `Run`, this method will block.
+func (p *Pipeline) Metrics() []NodeMetric {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metrics == nil {
+		return nil
+	}
+	return append([]NodeMetric(nil), p.metrics...)
+}
+
+// Done returns a channel that is closed when the current, or the last,
+// call to Run is done
 func (p *Pipeline) Done() <-chan struct{} {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	return p.done
 }
+
+// Reset resets the wrapped destination, and discards the values
+// collected so far
+func (c *collector) Reset() {
+	c.mutex.Lock()
+	c.values = nil
+	c.done = make(chan struct{})
+	c.mutex.Unlock()
+	c.Destination.Reset()
+}
+
+// Done returns a channel that is closed once the wrapped destination
+// is done, or has panicked, in which case it would never be done
+func (c *collector) Done() <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.done
+}
+
+// Accept forwards everything it receives to the wrapped destination,
+// remembering all values except EndMarks. It returns once the first
+// EndMark has been forwarded, like the other nodes
+func (c *collector) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	ch := make(chan interface{})
+	c.mutex.Lock()
+	done := c.done
+	c.mutex.Unlock()
+	go func() {
+		defer close(done)
+		defer recoverNode(ctx, c.index, nil)
+		c.Destination.Accept(ctx, ch, out)
+		select {
+		case <-ctx.Done():
+		case <-c.Destination.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			end := isEndMarkValue(v)
+			if !end {
+				c.mutex.Lock()
+				c.values = append(c.values, v)
+				c.mutex.Unlock()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case ch <- v:
+			}
+			if end {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error of the wrapped destination
+func (c *collector) Err() error {
+	return destinationErr(c.Destination)
+}
+
+// Values returns the values collected so far
+func (c *collector) Values() []interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values
+}
+
+func isEndMarkValue(v interface{}) bool {
+	err, ok := v.(error)
+	return ok && IsEndMark(err)
+}
+
+// Accept runs the wrapped Acceptor, counting the values that are sent
+// to and from it
+func (n *metricsNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	nodeIn := make(chan interface{})
+	nodeOut := make(chan interface{})
+	nodeDone := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-nodeDone:
+				return
+			case v, ok := <-in:
+				if !ok {
+					close(nodeIn)
+					return
+				}
+				if !isEndMarkValue(v) {
+					n.metric.ItemsIn++
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-nodeDone:
+					return
+				case nodeIn <- v:
+				}
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-nodeDone:
+				return
+			case v, ok := <-nodeOut:
+				if !ok {
+					// The node closed its output, which is ours to close
+					out.Close()
+					return
+				}
+				if !isEndMarkValue(v) {
+					n.metric.ItemsOut++
+				}
+				if err := out.SendCtx(ctx, v); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	n.Acceptor.Accept(ctx, nodeIn, ChanOutput(nodeOut))
+	n.metric.Elapsed = time.Since(start)
+	close(nodeDone)
+	wg.Wait()
+}
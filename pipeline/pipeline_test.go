@@ -4,12 +4,17 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"context"
+
+	"github.com/pkg/errors"
 )
 
 type RegexpFilter struct {
@@ -135,3 +140,1068 @@ barfoo
 	p.Run(ctx)
 	t.Logf("%#v", dst.lines)
 }
+
+func TestUnrank(t *testing.T) {
+	if v := Unrank(Ranked{Value: "foo", Score: 10}); v != "foo" {
+		t.Errorf("expected ranked value to be unwrapped, got %v", v)
+	}
+	if v := Unrank("foo"); v != "foo" {
+		t.Errorf("expected bare value to be returned as is, got %v", v)
+	}
+}
+
+func TestIsEndMarkNoAlloc(t *testing.T) {
+	var err error = EndMark{}
+	allocs := testing.AllocsPerRun(100, func() {
+		if !IsEndMark(err) {
+			t.Errorf("expected IsEndMark to return true")
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("expected IsEndMark to not allocate, got %f allocations", allocs)
+	}
+}
+
+func TestTraceFunc(t *testing.T) {
+	var traced []string
+	TraceFunc = func(f string, args ...interface{}) {
+		traced = append(traced, fmt.Sprintf(f, args...))
+	}
+	defer func() { TraceFunc = func(string, ...interface{}) {} }()
+
+	IsEndMark(EndMark{})
+	if len(traced) != 1 {
+		t.Errorf("expected 1 trace message, got %d", len(traced))
+	}
+}
+
+func TestEndMarkInfo(t *testing.T) {
+	ch := make(ChanOutput, 2)
+	ch.SendEndMark("plain")
+	ch.SendEndMarkData("with data", EndMarkData{Source: "foo", Count: 3})
+
+	err := (<-ch).(error)
+	if !IsEndMark(err) {
+		t.Errorf("expected an end mark")
+	}
+	if _, ok := EndMarkInfo(err); ok {
+		t.Errorf("expected no data from a plain end mark")
+	}
+
+	err = (<-ch).(error)
+	if !IsEndMark(err) {
+		t.Errorf("expected an end mark")
+	}
+	if !strings.HasPrefix(err.Error(), "with data") {
+		t.Errorf("expected the message to be kept, got %q", err.Error())
+	}
+	data, ok := EndMarkInfo(err)
+	if !ok {
+		t.Errorf("expected data from the end mark")
+		return
+	}
+	if expected := (EndMarkData{Source: "foo", Count: 3}); data != expected {
+		t.Errorf("expected %#v, got %#v", expected, data)
+	}
+
+	if _, ok := EndMarkInfo(errors.New("not an end mark")); ok {
+		t.Errorf("expected no data from an error that is not an end mark")
+	}
+}
+
+func TestPipelineRunWithResult(t *testing.T) {
+	src := NewLineFeeder(strings.NewReader(`foo
+bar
+foobar
+barfoo
+`))
+	dst := NewReceiver()
+
+	p := New()
+	p.SetSource(src)
+	p.Add(NewRegexpFilter(regexp.MustCompile(`^foo`)))
+	p.SetDestination(dst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := p.RunWithResult(ctx)
+	if err != nil {
+		t.Errorf("RunWithResult should succeed: %s", err)
+		return
+	}
+
+	expected := []interface{}{"foo", "foobar"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+
+	// The original destination should still have received everything
+	if !reflect.DeepEqual(dst.lines, []string{"foo", "foobar"}) {
+		t.Errorf("expected destination to receive all lines, got %#v", dst.lines)
+	}
+}
+
+type failingNode struct {
+	err error
+}
+
+func (n failingNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-in:
+			ReportError(ctx, n.err)
+		}
+	}
+}
+
+func TestPipelineReportError(t *testing.T) {
+	src := NewLineFeeder(strings.NewReader("foo\nbar\n"))
+	dst := NewReceiver()
+
+	expected := errors.New("node failed")
+	p := New()
+	p.SetSource(src)
+	p.Add(failingNode{err: expected})
+	p.Add(failingNode{err: errors.New("this error should be ignored")})
+	p.SetDestination(dst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := p.Run(ctx)
+	if err != expected {
+		t.Errorf("expected Run to return %v, got %v", expected, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		t.Errorf("Run should have returned before the timeout")
+	default:
+	}
+}
+
+func TestPipelineNoError(t *testing.T) {
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo\n")))
+	p.SetDestination(NewReceiver())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("expected Run to return nil, got %v", err)
+	}
+}
+
+// errReceiver is a Receiver that fails once it is done
+type errReceiver struct {
+	*Receiver
+	err error
+}
+
+func (r errReceiver) Err() error {
+	return r.err
+}
+
+func TestPipelineDestinationError(t *testing.T) {
+	expected := errors.New("destination failed")
+	run := func(dst Destination, nodes ...Acceptor) error {
+		p := New()
+		p.SetSource(NewLineFeeder(strings.NewReader("foo\n")))
+		for _, n := range nodes {
+			p.Add(n)
+		}
+		p.SetDestination(dst)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return p.Run(ctx)
+	}
+
+	if err := run(errReceiver{Receiver: NewReceiver(), err: expected}); err != expected {
+		t.Errorf("expected Run to return %v, got %v", expected, err)
+	}
+	if err := run(Tee(NewReceiver(), errReceiver{Receiver: NewReceiver(), err: expected})); err != expected {
+		t.Errorf("expected Run to return the error of the branch %v, got %v", expected, err)
+	}
+	if err := run(errReceiver{Receiver: NewReceiver()}); err != nil {
+		t.Errorf("expected Run to return nil, got %v", err)
+	}
+
+	// Errors reported by the nodes come first
+	nodeErr := errors.New("node failed")
+	if err := run(errReceiver{Receiver: NewReceiver(), err: expected}, failingNode{err: nodeErr}); err != nodeErr {
+		t.Errorf("expected Run to return %v, got %v", nodeErr, err)
+	}
+
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo\n")))
+	p.SetDestination(errReceiver{Receiver: NewReceiver(), err: expected})
+	if _, err := p.RunWithResult(context.Background()); err != expected {
+		t.Errorf("expected RunWithResult to return %v, got %v", expected, err)
+	}
+}
+
+// panicNode panics as soon as it receives a value
+type panicNode struct{}
+
+func (panicNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-in:
+			panic(fmt.Sprintf("cannot handle %v", v))
+		}
+	}
+}
+
+func TestPipelineNodePanic(t *testing.T) {
+	for _, metrics := range []bool{false, true} {
+		p := New()
+		p.SetSource(NewLineFeeder(strings.NewReader("foo\nbar\n")))
+		p.Add(forwardNode{})
+		p.Add(panicNode{})
+		p.SetDestination(NewReceiver())
+		if metrics {
+			p.EnableMetrics()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := p.Run(ctx)
+		if ctx.Err() != nil {
+			t.Errorf("Run should have returned before the timeout (metrics: %t)", metrics)
+		}
+		cancel()
+
+		pe, ok := err.(*NodePanicError)
+		if !ok {
+			t.Errorf("expected Run to return a NodePanicError (metrics: %t), got %v", metrics, err)
+			continue
+		}
+		if pe.Index != 1 {
+			t.Errorf("expected the panic of node 1, got node %d", pe.Index)
+		}
+		if pe.Value != "cannot handle foo" {
+			t.Errorf("expected the value passed to panic, got %v", pe.Value)
+		}
+		if !strings.Contains(string(pe.Stack), "panicNode") {
+			t.Errorf("expected the stack to contain the node, got %s", pe.Stack)
+		}
+	}
+}
+
+// panicSource panics as soon as it is started
+type panicSource struct{}
+
+func (panicSource) Reset() {}
+
+func (panicSource) Start(ctx context.Context, out ChanOutput) {
+	panic("cannot read")
+}
+
+// panicDestination panics as soon as it receives a value, without
+// ever being done
+type panicDestination struct {
+	done chan struct{}
+}
+
+func (d *panicDestination) Reset() {
+	d.done = make(chan struct{})
+}
+
+func (d *panicDestination) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *panicDestination) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	for {
+		select {
+		case <-ctx.Done():
+			close(d.done)
+			return
+		case v := <-in:
+			panic(fmt.Sprintf("cannot handle %v", v))
+		}
+	}
+}
+
+func TestPipelineSourceDestinationPanic(t *testing.T) {
+	testValues := []struct {
+		name    string
+		src     Source
+		dst     Destination
+		collect bool
+		index   int
+		value   interface{}
+	}{
+		{"source", panicSource{}, NewReceiver(), false, -1, "cannot read"},
+		{"destination", NewLineFeeder(strings.NewReader("foo\n")), &panicDestination{}, false, 1, "cannot handle foo"},
+		{"collected destination", NewLineFeeder(strings.NewReader("foo\n")), &panicDestination{}, true, 1, "cannot handle foo"},
+	}
+
+	for _, v := range testValues {
+		p := New()
+		p.SetSource(v.src)
+		p.Add(forwardNode{})
+		p.SetDestination(v.dst)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		var err error
+		if v.collect {
+			_, err = p.RunWithResult(ctx)
+		} else {
+			err = p.Run(ctx)
+		}
+		if ctx.Err() != nil {
+			t.Errorf("Run should have returned before the timeout (%s)", v.name)
+		}
+		cancel()
+
+		pe, ok := err.(*NodePanicError)
+		if !ok {
+			t.Errorf("expected Run to return a NodePanicError (%s), got %v", v.name, err)
+			continue
+		}
+		if pe.Index != v.index {
+			t.Errorf("expected the panic of node %d (%s), got node %d", v.index, v.name, pe.Index)
+		}
+		if pe.Value != v.value {
+			t.Errorf("expected the value passed to panic (%s), got %v", v.name, pe.Value)
+		}
+	}
+}
+
+func TestSendCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nobody is listening on this channel, so SendCtx should bail
+	// out because the context has been canceled
+	oc := ChanOutput(make(chan interface{}))
+	if err := oc.SendCtx(ctx, "foo"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	oc = ChanOutput(make(chan interface{}, 1))
+	if err := oc.SendCtx(context.Background(), "foo"); err != nil {
+		t.Errorf("expected SendCtx to succeed, got %v", err)
+	}
+	if v := <-oc; v != "foo" {
+		t.Errorf("expected 'foo', got %v", v)
+	}
+}
+
+func TestSendEndMarkCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nobody is listening on this channel, so SendEndMarkCtx should
+	// bail out right away instead of waiting for the timeout
+	oc := ChanOutput(make(chan interface{}))
+	if err := oc.SendEndMarkCtx(ctx, "foo"); errors.Cause(err) != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err := oc.SendEndMarkDataCtx(ctx, "foo", EndMarkData{Source: "foo"}); errors.Cause(err) != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	oc = ChanOutput(make(chan interface{}, 1))
+	if err := oc.SendEndMarkDataCtx(context.Background(), "foo", EndMarkData{Source: "foo", Count: 3}); err != nil {
+		t.Errorf("expected SendEndMarkDataCtx to succeed, got %v", err)
+	}
+	v := (<-oc).(error)
+	if !IsEndMark(v) {
+		t.Errorf("expected an end mark, got %v", v)
+	}
+	if data, ok := EndMarkInfo(v); !ok || data.Count != 3 {
+		t.Errorf("expected the end mark to carry its data, got %#v", data)
+	}
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader(`foo
+bar
+foobar
+barfoo
+`)))
+	p.Add(NewRegexpFilter(regexp.MustCompile(`^foo`)))
+	p.Add(NewRegexpFilter(regexp.MustCompile(`bar$`)))
+	p.SetDestination(NewReceiver())
+
+	if m := p.Metrics(); m != nil {
+		t.Errorf("expected no metrics before enabling them, got %#v", m)
+	}
+
+	p.EnableMetrics()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+
+	metrics := p.Metrics()
+	if len(metrics) != 2 {
+		t.Errorf("expected 2 metrics, got %d", len(metrics))
+		return
+	}
+
+	expected := [][2]int{{4, 2}, {2, 1}}
+	for i, m := range metrics {
+		if m.ItemsIn != expected[i][0] || m.ItemsOut != expected[i][1] {
+			t.Errorf("node %d: expected in/out = %v, got %d/%d", i, expected[i], m.ItemsIn, m.ItemsOut)
+		}
+		if m.Elapsed <= 0 {
+			t.Errorf("node %d: expected elapsed time to be recorded", i)
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	dst1 := NewReceiver()
+	dst2 := NewReceiver()
+	tee := Tee(dst1, dst2)
+	tee.SetBufferSize(1)
+
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo\nbar\nbaz\n")))
+	p.SetDestination(tee)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+
+	expected := []string{"foo", "bar", "baz"}
+	for i, dst := range []*Receiver{dst1, dst2} {
+		if !reflect.DeepEqual(dst.lines, expected) {
+			t.Errorf("destination %d: expected %#v, got %#v", i, expected, dst.lines)
+		}
+	}
+}
+
+func TestSliceDestination(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dst := NewSliceDestination()
+	for i := 0; i < 2; i++ {
+		p := New()
+		p.SetSource(NewLineFeeder(strings.NewReader("foo\nbar\nbaz\n")))
+		p.SetDestination(dst)
+		if err := p.Run(ctx); err != nil {
+			t.Errorf("Run should succeed: %s", err)
+			return
+		}
+		// Each run starts afresh
+		expected := []interface{}{"foo", "bar", "baz"}
+		if !reflect.DeepEqual(dst.Results(), expected) {
+			t.Errorf("run %d: expected %#v, got %#v", i, expected, dst.Results())
+			return
+		}
+	}
+
+	// The values received so far can be looked at while the pipeline
+	// is still running
+	release := make(chan struct{})
+	dst = NewSliceDestination()
+	p := New()
+	p.SetSource(heldSource{release: release})
+	p.SetDestination(dst)
+	errCh := make(chan error, 1)
+	go func() { errCh <- p.Run(ctx) }()
+
+	for len(dst.Snapshot()) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Errorf("value should be received")
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+	snapshot := dst.Snapshot()
+	select {
+	case <-dst.Done():
+		t.Errorf("destination should not be done before the end mark")
+	default:
+	}
+	close(release)
+	if err := <-errCh; err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(snapshot, []interface{}{"foo"}) {
+		t.Errorf("expected [foo], got %#v", snapshot)
+	}
+}
+
+// stuckReceiver never becomes done
+type stuckReceiver struct{}
+
+func (stuckReceiver) Reset()                                                     {}
+func (stuckReceiver) Done() <-chan struct{}                                      { return nil }
+func (stuckReceiver) Accept(_ context.Context, _ chan interface{}, _ ChanOutput) {}
+
+func TestPipelineRunWithTimeout(t *testing.T) {
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo\n")))
+	p.SetDestination(stuckReceiver{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := p.RunWithTimeout(ctx, 100*time.Millisecond)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunWithTimeout took too long (%s)", elapsed)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo\nbar\nbaz\nqux\nquux\n")))
+	p.Add(Batch(2))
+	p.SetDestination(NewReceiver())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := p.RunWithResult(ctx)
+	if err != nil {
+		t.Errorf("RunWithResult should succeed: %s", err)
+		return
+	}
+
+	// The last, partial batch should be flushed by the EndMark
+	expected := []interface{}{
+		[]interface{}{"foo", "bar"},
+		[]interface{}{"baz", "qux"},
+		[]interface{}{"quux"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestUnbatch(t *testing.T) {
+	dst := NewReceiver()
+
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo\nbar\nbaz\n")))
+	p.Add(Batch(2))
+	p.Add(Unbatch())
+	p.SetDestination(dst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+
+	expected := []string{"foo", "bar", "baz"}
+	if !reflect.DeepEqual(dst.lines, expected) {
+		t.Errorf("expected %#v, got %#v", expected, dst.lines)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	testValues := []struct {
+		name     string
+		node     Acceptor
+		expected []string
+	}{
+		{"Dedup", Dedup(nil), []string{"foo", "bar", "baz"}},
+		{"DedupAdjacent", DedupAdjacent(nil), []string{"foo", "bar", "foo", "baz"}},
+		{"Dedup with key", Dedup(func(v interface{}) string { return v.(string)[:1] }), []string{"foo", "bar"}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.name, func(t *testing.T) {
+			dst := NewReceiver()
+
+			p := New()
+			p.SetSource(NewLineFeeder(strings.NewReader("foo\nfoo\nbar\nfoo\nbaz\nbaz\n")))
+			p.Add(v.node)
+			p.SetDestination(dst)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := p.Run(ctx); err != nil {
+				t.Errorf("Run should succeed: %s", err)
+				return
+			}
+
+			if !reflect.DeepEqual(dst.lines, v.expected) {
+				t.Errorf("expected %#v, got %#v", v.expected, dst.lines)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dst := NewReceiver()
+
+	p := New()
+	p.SetSource(NewLineFeeder(strings.NewReader("foo,bar\n\nbaz\nqux,,quux\n")))
+	p.Add(Split(func(v interface{}) []interface{} {
+		var items []interface{}
+		for _, s := range strings.Split(v.(string), ",") {
+			if s != "" {
+				items = append(items, s)
+			}
+		}
+		return items
+	}))
+	p.SetDestination(dst)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+
+	expected := []string{"foo", "bar", "baz", "qux", "quux"}
+	if !reflect.DeepEqual(dst.lines, expected) {
+		t.Errorf("expected %#v, got %#v", expected, dst.lines)
+	}
+}
+
+// countSource sends the numbers from 0 to n - 1
+type countSource struct {
+	n int
+}
+
+func (s countSource) Reset() {}
+
+func (s countSource) Start(ctx context.Context, out ChanOutput) {
+	for i := 0; i < s.n; i++ {
+		if err := out.SendCtx(ctx, i); err != nil {
+			return
+		}
+	}
+	out.SendCtx(ctx, EndMark{})
+}
+
+// forwardNode sends everything it receives downstream
+type forwardNode struct{}
+
+func (forwardNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-in:
+			if err := out.SendCtx(ctx, v); err != nil {
+				return
+			}
+			if isEndMarkValue(v) {
+				return
+			}
+		}
+	}
+}
+
+// countReceiver counts the values it receives
+type countReceiver struct {
+	count int
+	done  chan struct{}
+}
+
+func (r *countReceiver) Reset() {
+	r.count = 0
+	r.done = make(chan struct{})
+}
+
+func (r *countReceiver) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *countReceiver) Accept(ctx context.Context, in chan interface{}, _ ChanOutput) {
+	defer close(r.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-in:
+			if isEndMarkValue(v) {
+				return
+			}
+			r.count++
+		}
+	}
+}
+
+func benchmarkBatch(b *testing.B, size int) {
+	const items = 1000000
+	for i := 0; i < b.N; i++ {
+		dst := &countReceiver{}
+		p := New()
+		p.SetSource(countSource{n: items})
+		if size > 0 {
+			p.Add(Batch(size))
+		}
+		for j := 0; j < 4; j++ {
+			p.Add(forwardNode{})
+		}
+		if size > 0 {
+			p.Add(Unbatch())
+		}
+		p.SetDestination(dst)
+
+		if err := p.Run(context.Background()); err != nil {
+			b.Fatalf("Run should succeed: %s", err)
+		}
+		if dst.count != items {
+			b.Fatalf("expected %d values, got %d", items, dst.count)
+		}
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	for _, size := range []int{0, 16, 256} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchmarkBatch(b, size)
+		})
+	}
+}
+
+// heldSource sends "foo", and waits for release to be closed before it
+// sends the end mark
+type heldSource struct {
+	release chan struct{}
+}
+
+func (s heldSource) Reset() {}
+
+func (s heldSource) Start(ctx context.Context, out ChanOutput) {
+	defer out.SendEndMark("end of heldSource")
+	out.SendCtx(ctx, "foo")
+	select {
+	case <-ctx.Done():
+	case <-s.release:
+	}
+}
+
+// yieldingReceiver releases the source the first time it is asked to
+// yield
+type yieldingReceiver struct {
+	*Receiver
+	once    sync.Once
+	release chan struct{}
+	yielded int32
+}
+
+func (r *yieldingReceiver) Yield() {
+	atomic.AddInt32(&r.yielded, 1)
+	r.once.Do(func() { close(r.release) })
+}
+
+func TestPipelineBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	dst := &yieldingReceiver{Receiver: NewReceiver(), release: release}
+
+	p := New()
+	p.SetSource(heldSource{release: release})
+	p.SetDestination(dst)
+	p.SetBudget(10 * time.Millisecond)
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+	if ctx.Err() != nil {
+		t.Errorf("destination should have been asked to yield")
+		return
+	}
+	if atomic.LoadInt32(&dst.yielded) == 0 {
+		t.Errorf("Yield should have been called")
+	}
+	if !reflect.DeepEqual(dst.lines, []string{"foo"}) {
+		t.Errorf("expected [foo], got %v", dst.lines)
+	}
+}
+
+// endlessSource sends values until it is told to stop
+type endlessSource struct {
+	stopped chan struct{}
+}
+
+func (s endlessSource) Reset() {}
+
+func (s endlessSource) Start(ctx context.Context, out ChanOutput) {
+	defer close(s.stopped)
+	for i := 0; ; i++ {
+		if err := out.SendCtx(ctx, i); err != nil {
+			return
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	src := endlessSource{stopped: make(chan struct{})}
+	limit := Limit(3)
+	dst := &countReceiver{}
+
+	p := New()
+	p.SetSource(src)
+	p.Add(forwardNode{})
+	p.Add(limit)
+	p.SetDestination(dst)
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+	if ctx.Err() != nil {
+		t.Errorf("limit should have ended the input")
+		return
+	}
+	if dst.count != 3 {
+		t.Errorf("expected 3 values, got %d", dst.count)
+	}
+	if !limit.Limited() {
+		t.Errorf("node should be limited")
+	}
+
+	select {
+	case <-src.stopped:
+	case <-ctx.Done():
+		t.Errorf("source should have been stopped")
+		return
+	}
+
+	// Receiving as many values as the limit allows is not limited
+	p = New()
+	p.SetSource(countSource{n: 3})
+	p.Add(limit)
+	p.SetDestination(dst)
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed: %s", err)
+		return
+	}
+	if dst.count != 3 {
+		t.Errorf("expected 3 values, got %d", dst.count)
+	}
+	if limit.Limited() {
+		t.Errorf("node should not be limited")
+	}
+}
+
+func TestChanOutputClose(t *testing.T) {
+	oc := ChanOutput(make(chan interface{}, 1))
+	if err := oc.SendEndMarkAndClose("done"); err != nil {
+		t.Fatalf("SendEndMarkAndClose should succeed: %s", err)
+	}
+
+	var values []interface{}
+	for v := range oc.OutCh() {
+		values = append(values, v)
+	}
+	if len(values) != 1 || !isEndMarkValue(values[0]) {
+		t.Errorf("expected only the end mark, got %v", values)
+	}
+
+	if err := oc.Send(1); err != ErrOutputClosed {
+		t.Errorf("Send after Close should fail with ErrOutputClosed, got %v", err)
+	}
+	if err := oc.SendCtx(context.Background(), 1); err != ErrOutputClosed {
+		t.Errorf("SendCtx after Close should fail with ErrOutputClosed, got %v", err)
+	}
+}
+
+// closeSource sends the numbers up to n, and closes the output instead
+// of sending an EndMark
+type closeSource struct {
+	n int
+}
+
+func (s closeSource) Reset() {}
+
+func (s closeSource) Start(ctx context.Context, out ChanOutput) {
+	defer out.Close()
+	for i := 0; i < s.n; i++ {
+		if err := out.SendCtx(ctx, i); err != nil {
+			return
+		}
+	}
+}
+
+// rangeNode ranges over the input, which only works if the node
+// upstream closes its output
+type rangeNode struct{}
+
+func (rangeNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	defer out.Close()
+	for v := range in {
+		if err := out.SendCtx(ctx, v); err != nil {
+			return
+		}
+	}
+}
+
+func TestClosedOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dst := NewSliceDestination()
+	p := New()
+	p.SetSource(closeSource{n: 5})
+	p.Add(Batch(2))
+	p.Add(rangeNode{})
+	p.Add(Unbatch())
+	p.SetDestination(dst)
+
+	if err := p.Run(ctx); err != nil {
+		t.Fatalf("Run should succeed: %s", err)
+	}
+	expected := []interface{}{0, 1, 2, 3, 4}
+	if !reflect.DeepEqual(expected, dst.Results()) {
+		t.Errorf("expected %v, got %v", expected, dst.Results())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// run sends n values to the node, then waits for the given time
+	// before closing the input. Returns the values received before the
+	// input was closed, and all of the values received
+	run := func(node *RateLimitNode, n int, wait time.Duration) ([]interface{}, []interface{}) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		in := make(chan interface{})
+		out := make(chan interface{})
+		go node.Accept(ctx, in, ChanOutput(out))
+
+		var mutex sync.Mutex
+		var values []interface{}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for v := range out {
+				if isEndMarkValue(v) {
+					continue
+				}
+				mutex.Lock()
+				values = append(values, v)
+				mutex.Unlock()
+			}
+		}()
+
+		for i := 0; i < n; i++ {
+			in <- i
+		}
+		time.Sleep(wait)
+		mutex.Lock()
+		before := append([]interface{}(nil), values...)
+		mutex.Unlock()
+		close(in)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("output should be closed promptly once the input is closed")
+		}
+		return before, values
+	}
+
+	before, values := run(RateLimit(10), 25, 300*time.Millisecond)
+	if len(before) < 10 || len(before) >= 25 {
+		t.Errorf("expected the values to be rate limited, got %d before the end", len(before))
+	}
+	if len(values) != 25 {
+		t.Errorf("expected the buffered values to be flushed, got %d", len(values))
+		return
+	}
+	for i, v := range values {
+		if v != i {
+			t.Errorf("expected the values to be in order, got %v at %d", v, i)
+			return
+		}
+	}
+
+	node := RateLimitDrop(10)
+	_, values = run(node, 25, 0)
+	if len(values) < 10 || len(values) >= 25 {
+		t.Errorf("expected the excess values to be dropped, got %d", len(values))
+	}
+	if node.Dropped() != 25-len(values) {
+		t.Errorf("expected %d values to be dropped, got %d", 25-len(values), node.Dropped())
+	}
+
+	if _, values = run(RateLimit(0), 25, 0); len(values) != 25 {
+		t.Errorf("expected the values to be forwarded without a limit, got %d", len(values))
+	}
+}
+
+func TestPipelineReset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upper := func(v interface{}) []interface{} {
+		return []interface{}{strings.ToUpper(v.(string))}
+	}
+
+	// The same pipeline can be run again with another source
+	dst := NewReceiver()
+	p := New()
+	p.SetDestination(dst)
+	for _, input := range []string{"foo\nbar\n", "baz\n"} {
+		p.SetSource(NewLineFeeder(strings.NewReader(input)))
+		if err := p.Run(ctx); err != nil {
+			t.Errorf("Run should succeed with %q: %s", input, err)
+			return
+		}
+		expected := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+		if !reflect.DeepEqual(dst.lines, expected) {
+			t.Errorf("expected %#v, got %#v", expected, dst.lines)
+		}
+	}
+	<-p.Done()
+
+	// After Reset, none of the previous configuration is left
+	p.Reset()
+	if err := p.Run(ctx); err == nil {
+		t.Errorf("Run should fail without a source")
+	}
+
+	p.Reset()
+	p.SetSource(NewLineFeeder(strings.NewReader("baz\nqux\n")))
+	p.Add(Split(upper))
+	p.SetDestination(dst)
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed after Reset: %s", err)
+		return
+	}
+	expected := []string{"BAZ", "QUX"}
+	if !reflect.DeepEqual(dst.lines, expected) {
+		t.Errorf("expected %#v, got %#v", expected, dst.lines)
+	}
+
+	p.Reset()
+	p.SetSource(NewLineFeeder(strings.NewReader("quux\n")))
+	p.SetDestination(dst)
+	if err := p.Run(ctx); err != nil {
+		t.Errorf("Run should succeed after another Reset: %s", err)
+		return
+	}
+	expected = []string{"quux"}
+	if !reflect.DeepEqual(dst.lines, expected) {
+		t.Errorf("expected the nodes to be cleared with %#v, got %#v", expected, dst.lines)
+	}
+}
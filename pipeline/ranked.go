@@ -0,0 +1,10 @@
+package pipeline
+
+// Unrank returns the value wrapped by a Ranked, or v itself if it was
+// not ranked
+func Unrank(v interface{}) interface{} {
+	if r, ok := v.(Ranked); ok {
+		return r.Value
+	}
+	return v
+}
@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+)
+
+// RateLimit creates an Acceptor that forwards up to perSecond values
+// per second, with bursts of up to perSecond values. The values that
+// come in faster are buffered, and forwarded as the rate allows. When
+// the EndMark is received, the buffered values are all forwarded right
+// away, followed by the EndMark. If perSecond is less than 1, values
+// are forwarded without a limit.
+//
+// The buffer is not bounded, so the memory used grows with the number
+// of values that are waiting. Use RateLimitDrop instead if the excess
+// values may be lost.
+func RateLimit(perSecond int) *RateLimitNode {
+	return &RateLimitNode{perSecond: perSecond}
+}
+
+// RateLimitDrop is like RateLimit, but drops the values that come in
+// faster than perSecond values per second instead of buffering them.
+// The EndMark is forwarded as soon as it is received.
+func RateLimitDrop(perSecond int) *RateLimitNode {
+	return &RateLimitNode{perSecond: perSecond, drop: true}
+}
+
+// Dropped returns the number of values that the last run of a node
+// created by RateLimitDrop dropped so far
+func (r *RateLimitNode) Dropped() int {
+	return int(atomic.LoadInt64(&r.dropped))
+}
+
+// Accept forwards the values it receives at the rate of the node
+func (r *RateLimitNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("RateLimitNode.Accept (perSecond = %d, drop = %t)", r.perSecond, r.drop)
+		defer g.End()
+	}
+	defer out.Close()
+
+	atomic.StoreInt64(&r.dropped, 0)
+
+	bucket := newTokenBucket(r.perSecond, time.Now())
+	var queue []interface{}
+	var timer <-chan time.Time
+	for {
+		// Forward the buffered values that the rate allows, and wake
+		// up once the next one is allowed
+		for len(queue) > 0 && bucket.take(time.Now()) {
+			if err := out.SendCtx(ctx, queue[0]); err != nil {
+				return
+			}
+			queue[0] = nil
+			queue = queue[1:]
+		}
+		if len(queue) > 0 && timer == nil {
+			timer = time.After(bucket.wait())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer:
+			timer = nil
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			if isEndMarkValue(v) {
+				for _, q := range queue {
+					if err := out.SendCtx(ctx, q); err != nil {
+						return
+					}
+				}
+				out.SendCtx(ctx, v)
+				return
+			}
+
+			switch {
+			case bucket == nil:
+				if err := out.SendCtx(ctx, v); err != nil {
+					return
+				}
+			case !r.drop:
+				queue = append(queue, v)
+			case bucket.take(time.Now()):
+				if err := out.SendCtx(ctx, v); err != nil {
+					return
+				}
+			default:
+				atomic.AddInt64(&r.dropped, 1)
+			}
+		}
+	}
+}
+
+// newTokenBucket creates a full bucket that allows perSecond values
+// per second. Returns nil if perSecond is less than 1
+func newTokenBucket(perSecond int, now time.Time) *tokenBucket {
+	if perSecond < 1 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		last:   now,
+	}
+}
+
+// take returns true if a value is allowed at the given time, and
+// counts it against the rate. A nil bucket allows everything
+func (b *tokenBucket) take(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait returns how long it takes until the next value is allowed, as
+// of the last call to take
+func (b *tokenBucket) wait() time.Duration {
+	if b == nil || b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
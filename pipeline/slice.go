@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+)
+
+// NewSliceDestination creates a Destination that keeps every value it
+// receives, except for the EndMark, in the order that they arrive. It
+// is meant for tests and tools that need to look at the output of a
+// Pipeline without writing a Destination of their own
+func NewSliceDestination() *SliceDestination {
+	d := &SliceDestination{}
+	d.Reset()
+	return d
+}
+
+// Reset forgets the values received so far, so that the destination
+// can be used for another run
+func (d *SliceDestination) Reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.done = make(chan struct{})
+	d.values = nil
+}
+
+// Done returns a channel that is closed once the EndMark has been
+// received, or the context passed to Accept has been canceled
+func (d *SliceDestination) Done() <-chan struct{} {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.done
+}
+
+// Accept stores the values it receives until the EndMark
+func (d *SliceDestination) Accept(ctx context.Context, in chan interface{}, _ ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("SliceDestination.Accept")
+		defer g.End()
+	}
+
+	d.mutex.RLock()
+	done := d.done
+	d.mutex.RUnlock()
+	defer close(done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			if isEndMarkValue(v) {
+				return
+			}
+			d.mutex.Lock()
+			d.values = append(d.values, v)
+			d.mutex.Unlock()
+		}
+	}
+}
+
+// Results returns the values received by the last run. The values
+// that arrive afterwards are not added to the returned slice, but it
+// is shared with the destination, so it must not be modified. Use
+// Snapshot to get a copy of its own
+func (d *SliceDestination) Results() []interface{} {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.values[:len(d.values):len(d.values)]
+}
+
+// Snapshot returns a copy of the values received so far. It may be
+// called at any time, including while the Pipeline is running
+func (d *SliceDestination) Snapshot() []interface{} {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if len(d.values) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(d.values))
+	copy(values, d.values)
+	return values
+}
@@ -0,0 +1,51 @@
+package pipeline
+
+import (
+	"context"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+)
+
+// Split creates an Acceptor that replaces each value with the values
+// that fn returns for it, in the order they are returned. A value for
+// which fn returns nothing is dropped. The EndMark is forwarded after
+// the values of the last value that came before it.
+//
+// The values returned for one value are all sent before the next one
+// is read, and are released as they are sent, so a value that expands
+// into many values only holds on to them until they are consumed.
+func Split(fn func(interface{}) []interface{}) Acceptor {
+	return &splitNode{fn: fn}
+}
+
+// Accept sends the values that each value it receives expands into
+func (s *splitNode) Accept(ctx context.Context, in chan interface{}, out ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("splitNode.Accept")
+		defer g.End()
+	}
+	defer out.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			if isEndMarkValue(v) {
+				out.SendCtx(ctx, v)
+				return
+			}
+
+			items := s.fn(v)
+			for i, item := range items {
+				if err := out.SendCtx(ctx, item); err != nil {
+					return
+				}
+				items[i] = nil
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"context"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+)
+
+// DefaultTeeBufferSize is the default number of values that each
+// branch of a TeeDestination can buffer
+const DefaultTeeBufferSize = 100
+
+// Tee creates a new TeeDestination that forwards values to all of
+// the given Destinations
+func Tee(dsts ...Destination) *TeeDestination {
+	t := &TeeDestination{
+		bufsiz: DefaultTeeBufferSize,
+		dsts:   dsts,
+	}
+	t.Reset()
+	return t
+}
+
+// SetBufferSize sets the number of values that each branch can buffer
+// before the TeeDestination stops accepting values from upstream.
+// This must be called before the Pipeline is run.
+func (t *TeeDestination) SetBufferSize(n int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	t.bufsiz = n
+}
+
+// Reset resets all of the Destinations
+func (t *TeeDestination) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.done = make(chan struct{})
+	for _, d := range t.dsts {
+		d.Reset()
+	}
+}
+
+// Done returns a channel that is closed when all of the Destinations
+// are done
+func (t *TeeDestination) Done() <-chan struct{} {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.done
+}
+
+// Err returns the first error of the Destinations that are
+// ErrDestinations, in the order they were given to Tee
+func (t *TeeDestination) Err() error {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, d := range t.dsts {
+		if err := destinationErr(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Accept forwards each value to all Destinations. The EndMark is also
+// forwarded, after which we wait for all Destinations to be done
+func (t *TeeDestination) Accept(ctx context.Context, in chan interface{}, _ ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("TeeDestination.Accept")
+		defer g.End()
+	}
+
+	t.mutex.RLock()
+	bufsiz := t.bufsiz
+	done := t.done
+	dsts := t.dsts
+	t.mutex.RUnlock()
+
+	branches := make([]chan interface{}, len(dsts))
+	for i, d := range dsts {
+		branches[i] = make(chan interface{}, bufsiz)
+		go d.Accept(ctx, branches[i], nil)
+	}
+
+	defer func() {
+		for _, d := range dsts {
+			select {
+			case <-ctx.Done():
+			case <-d.Done():
+			}
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = EndMark{}
+			}
+			for i, ch := range branches {
+				select {
+				case <-ctx.Done():
+					return
+				case <-dsts[i].Done():
+					// this branch is not listening anymore
+				case ch <- v:
+				}
+			}
+
+			if err, ok := v.(error); ok && IsEndMark(err) {
+				return
+			}
+		}
+	}
+}
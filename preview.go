@@ -0,0 +1,304 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+)
+
+const (
+	previewPositionRight  = "right"
+	previewPositionBottom = "bottom"
+
+	// Time to wait for the cursor to settle before running the command
+	previewDelay = 100 * time.Millisecond
+	// Number of lines of output kept per line
+	previewMaxLines = 1000
+	// Number of lines whose output is cached
+	previewCacheSize = 100
+)
+
+// IsValidPreviewPosition checks if the position of the preview pane
+// is supported. The empty string selects the default position
+func IsValidPreviewPosition(v string) bool {
+	return v == "" || v == previewPositionRight || v == previewPositionBottom
+}
+
+// NewPreview creates a new Preview from the configuration. Returns nil
+// if no command is configured
+func NewPreview(cfg PreviewConfig) *Preview {
+	if cfg.Command == "" {
+		return nil
+	}
+
+	position := cfg.Position
+	if position == "" {
+		position = previewPositionRight
+	}
+
+	size := cfg.Size
+	if size <= 0 || size >= 100 {
+		size = 50
+	}
+
+	return &Preview{
+		command:   cfg.Command,
+		position:  position,
+		size:      size,
+		requestCh: make(chan line.Line, 1),
+		cache:     make(map[uint64][]string),
+	}
+}
+
+// Command returns the command line to execute for the given line
+func (p *Preview) Command(l line.Line) string {
+	return strings.Replace(p.command, "{}", util.ShellQuote(l.Output()), -1)
+}
+
+// Output returns the lines of the output of the command for l, starting
+// from the current scroll position. If the output is not available yet,
+// the command is scheduled to be executed and the second return value
+// is false
+func (p *Preview) Output(l line.Line) ([]string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.current == nil || p.current.ID() != l.ID() {
+		p.current = l
+		p.offset = 0
+	}
+
+	if out, ok := p.cache[l.ID()]; ok {
+		if p.offset > len(out) {
+			p.offset = len(out)
+		}
+		return out[p.offset:], true
+	}
+
+	if p.requested == nil || p.requested.ID() != l.ID() {
+		p.requested = l
+		// Only the latest request matters, so replace the pending one
+		select {
+		case <-p.requestCh:
+		default:
+		}
+		p.requestCh <- l
+	}
+	return nil, false
+}
+
+// Scroll moves the displayed portion of the output by n lines
+func (p *Preview) Scroll(n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.current == nil {
+		return
+	}
+
+	out := p.cache[p.current.ID()]
+	offset := p.offset + n
+	if max := len(out) - p.height; offset > max {
+		offset = max
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	p.offset = offset
+}
+
+// PageSize returns the number of lines to scroll by with
+// ScrollPreviewUp and ScrollPreviewDown
+func (p *Preview) PageSize() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return maxOf(p.height/2, 1)
+}
+
+// Hidden returns true if the preview pane has been hidden with
+// peco.TogglePreview
+func (p *Preview) Hidden() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.hidden
+}
+
+// SetHidden hides or shows the preview pane. Hiding it kills the
+// command that is running for the current line, and the command is
+// executed again once the pane is shown, unless its output is cached
+func (p *Preview) SetHidden(b bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.hidden = b
+	if !b {
+		return
+	}
+
+	// A nil request tells Loop to give up the pending one, and the
+	// line has to be requested again when the pane is shown
+	p.requested = nil
+	select {
+	case <-p.requestCh:
+	default:
+	}
+	p.requestCh <- nil
+}
+
+func (p *Preview) setHeight(h int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.height = h
+}
+
+// Draw displays the output for the line under the cursor in the given
+// area of the screen. The area must extend to the right edge of the
+// screen
+func (p *Preview) Draw(state *Peco, x, y, height int) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Preview.Draw")
+		defer g.End()
+	}
+
+	p.setHeight(height)
+
+	var out []string
+	if l, err := state.CurrentLineBuffer().LineAt(state.Location().LineNumber()); err == nil {
+		out, _ = p.Output(l)
+	}
+
+	styles := state.Styles()
+	for i := 0; i < height; i++ {
+		var msg string
+		if i < len(out) {
+			msg = out[i]
+		}
+		state.Screen().Print(PrintArgs{
+			X:    x,
+			Y:    y + i,
+			Fg:   styles.Basic.fg,
+			Bg:   styles.Basic.bg,
+			Msg:  msg,
+			Fill: true,
+		})
+	}
+}
+
+func (p *Preview) store(l line.Line, out []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.cache[l.ID()]; !ok {
+		if len(p.cached) >= previewCacheSize {
+			delete(p.cache, p.cached[0])
+			p.cached = p.cached[1:]
+		}
+		p.cached = append(p.cached, l.ID())
+	}
+	p.cache[l.ID()] = out
+}
+
+func (p *Preview) isCurrent(l line.Line) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.current != nil && p.current.ID() == l.ID()
+}
+
+// Loop executes the command for the lines requested by Output. The
+// command is executed once the cursor stays on a line for a while, and
+// a command that is still running when the cursor moves on, or when
+// the pane is hidden, is killed
+func (p *Preview) Loop(ctx context.Context, state *Peco) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Preview.Loop")
+		defer g.End()
+	}
+
+	var pending line.Line
+	var timer <-chan time.Time
+	cancelRun := func() {}
+	for {
+		select {
+		case <-ctx.Done():
+			cancelRun()
+			return
+		case l := <-p.requestCh:
+			cancelRun()
+			pending = l
+			if l == nil {
+				timer = nil
+				continue
+			}
+			timer = time.After(previewDelay)
+		case <-timer:
+			timer = nil
+			var runCtx context.Context
+			runCtx, cancelRun = context.WithCancel(ctx)
+			go p.run(runCtx, state, pending)
+		}
+	}
+}
+
+func (p *Preview) run(ctx context.Context, state *Peco, l line.Line) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Preview.run %d", l.ID())
+		defer g.End()
+	}
+
+	var out bytes.Buffer
+	cmd := util.Shell(p.Command(l))
+	cmd.Stdin = strings.NewReader(l.Output() + "\n")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		p.store(l, []string{err.Error()})
+		state.Hub().SendDraw(nil)
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// The exit status is not interesting, whatever the command
+		// printed is displayed as is
+		cmd.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		if pdebug.Enabled {
+			pdebug.Printf("Killing preview command for line %d", l.ID())
+		}
+		cmd.Process.Kill()
+		return
+	case <-done:
+	}
+
+	p.store(l, previewLines(&out))
+	if p.isCurrent(l) {
+		state.Hub().SendDraw(nil)
+	}
+}
+
+// previewLines splits the output of the command into lines that can
+// be drawn on the screen
+func previewLines(out *bytes.Buffer) []string {
+	var lines []string
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for scanner.Scan() {
+		if len(lines) >= previewMaxLines {
+			lines = append(lines, "(truncated)")
+			break
+		}
+		s := util.StripANSISequence(scanner.Text())
+		lines = append(lines, strings.Replace(s, "\t", "    ", -1))
+	}
+	return lines
+}
@@ -0,0 +1,167 @@
+package peco
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPreview(t *testing.T) {
+	if !assert.Nil(t, NewPreview(PreviewConfig{}), "preview should be disabled without a command") {
+		return
+	}
+
+	p := NewPreview(PreviewConfig{Command: "cat {}", Size: 120})
+	if !assert.Equal(t, previewPositionRight, p.position, "position should default to right") {
+		return
+	}
+	if !assert.Equal(t, 50, p.size, "invalid size should be replaced by the default") {
+		return
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	l := line.NewRaw(0, "it's here", false)
+	if !assert.Equal(t, `cat 'it'\''s here'`, p.Command(l), "line should be quoted") {
+		return
+	}
+}
+
+func TestPreviewOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("preview commands are only tested on unix")
+	}
+
+	state := newPeco()
+	state.hub = nullHub{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := NewPreview(PreviewConfig{Command: "echo {}; seq 10"})
+	go p.Loop(ctx, state)
+
+	l := line.NewRaw(0, "foo bar", false)
+	var out []string
+	timeout := time.After(5 * time.Second)
+	for {
+		var ok bool
+		out, ok = p.Output(l)
+		if ok {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Errorf("timed out waiting for preview output")
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if !assert.Len(t, out, 11, "output should contain 11 lines") {
+		return
+	}
+	if !assert.Equal(t, "foo bar", out[0], "{} should be replaced by the line") {
+		return
+	}
+
+	p.setHeight(4)
+	p.Scroll(3)
+	out, _ = p.Output(l)
+	if !assert.Equal(t, "3", out[0], "output should be scrolled") {
+		return
+	}
+
+	// Can't scroll past the last page
+	p.Scroll(100)
+	out, _ = p.Output(l)
+	if !assert.Len(t, out, 4, "last page should be displayed") {
+		return
+	}
+
+	// Moving to another line resets the scroll position
+	other := line.NewRaw(1, "baz", false)
+	p.Output(other)
+	out, _ = p.Output(l)
+	if !assert.Len(t, out, 11, "scroll position should be reset") {
+		return
+	}
+}
+
+func TestLayoutPreviewHeight(t *testing.T) {
+	state := newPeco()
+	layout := NewDefaultLayout(state)
+
+	// dummyScreen is 10 rows high, 8 of which are used by the list
+	if !assert.Equal(t, 8-extraOffset, layout.linesPerPage(), "no rows should be used without a preview") {
+		return
+	}
+
+	layout.preview = NewPreview(PreviewConfig{Command: "cat", Position: previewPositionRight})
+	if !assert.Equal(t, 8-extraOffset, layout.linesPerPage(), "preview on the right should not use any rows") {
+		return
+	}
+
+	layout.preview = NewPreview(PreviewConfig{Command: "cat", Position: previewPositionBottom})
+	if !assert.Equal(t, 4, layout.linesPerPage(), "preview at the bottom should use half of the rows") {
+		return
+	}
+
+	layout.preview.SetHidden(true)
+	if !assert.Equal(t, 8-extraOffset, layout.linesPerPage(), "hidden preview should not use any rows") {
+		return
+	}
+	if !assert.Equal(t, 0, layout.previewWidth(80), "hidden preview should not use any columns") {
+		return
+	}
+}
+
+func TestPreviewHidden(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("preview commands are only tested on unix")
+	}
+
+	state := newPeco()
+	state.hub = nullHub{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := NewPreview(PreviewConfig{Command: "echo {}"})
+	go p.Loop(ctx, state)
+
+	// Hiding the pane before the cursor settles gives up the request
+	l := line.NewRaw(0, "foo", false)
+	if _, ok := p.Output(l); !assert.False(t, ok, "output should not be available yet") {
+		return
+	}
+	p.SetHidden(true)
+	time.Sleep(3 * previewDelay)
+	p.mutex.Lock()
+	_, cached := p.cache[l.ID()]
+	p.mutex.Unlock()
+	if !assert.False(t, cached, "command should not be executed while hidden") {
+		return
+	}
+
+	p.SetHidden(false)
+	timeout := time.After(5 * time.Second)
+	for {
+		if out, ok := p.Output(l); ok {
+			assert.Equal(t, []string{"foo"}, out, "command should be executed once shown")
+			return
+		}
+		select {
+		case <-timeout:
+			t.Errorf("timed out waiting for preview output")
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+)
+
+// progressInterval is how often the status bar is updated while the
+// input is being read. Reading that takes less time is not reported
+const progressInterval = 200 * time.Millisecond
+
+// addProgress records that a line of n bytes has been read, and lets
+// the receiver of Progress know. This never blocks: if the receiver is
+// busy, the notifications are coalesced into the one pending
+func (s *Source) addProgress(n int) {
+	s.mutex.Lock()
+	s.readLines++
+	s.readBytes += int64(n)
+	s.mutex.Unlock()
+
+	select {
+	case s.progress <- struct{}{}:
+	default:
+	}
+}
+
+// Progress returns a channel that receives a value when lines have
+// been read since the last value was received. Use ReadCount to find
+// out how many
+func (s *Source) Progress() <-chan struct{} {
+	return s.progress
+}
+
+// ReadCount returns the number of lines and bytes read so far. Unlike
+// Size, lines that were discarded because of the capacity are counted
+func (s *Source) ReadCount() (int, int64) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.readLines, s.readBytes
+}
+
+// showProgress displays how much of the input src has read in the
+// status bar, until it is done reading. Updates are displayed at most
+// once every progressInterval, and the message is cleared once src is
+// done, if it was displayed at all
+func (p *Peco) showProgress(ctx context.Context, src *Source) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.showProgress")
+		defer g.End()
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	var changed, shown bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-src.SetupDone():
+			if shown && p.Source() == src {
+				p.Hub().SendStatusMsg("")
+			}
+			return
+		case <-src.Progress():
+			changed = true
+		case <-ticker.C:
+			// The status bar belongs to whatever replaced the input,
+			// such as the action palette
+			if !changed || p.Source() != src {
+				continue
+			}
+			changed = false
+			shown = true
+			p.Hub().SendStatusMsg(progressMessage(src.ReadCount()))
+		}
+	}
+}
+
+// progressMessage returns the message that tells how much of the input
+// has been read, such as "Reading input... 1.2M lines, 85.3MB"
+func progressMessage(lines int, bytes int64) string {
+	return fmt.Sprintf("Reading input... %s lines, %s", humanCount(int64(lines), 1000, ""), humanCount(bytes, 1024, "B"))
+}
+
+// humanCount returns n with a K, M or G suffix for multiples of unit,
+// followed by suffix
+func humanCount(n, unit int64, suffix string) string {
+	if n < unit {
+		return fmt.Sprintf("%d%s", n, suffix)
+	}
+	v := float64(n)
+	for _, prefix := range []string{"K", "M", "G"} {
+		v /= float64(unit)
+		if v < float64(unit) || prefix == "G" {
+			return fmt.Sprintf("%.1f%s%s", v, prefix, suffix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package peco
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// progressHub records the status messages that are sent, which may be
+// from several goroutines
+type progressHub struct {
+	nullHub
+	mutex sync.Mutex
+	msgs  []string
+}
+
+func (h *progressHub) SendStatusMsg(msg string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.msgs = append(h.msgs, msg)
+}
+
+func (h *progressHub) last() (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.msgs) == 0 {
+		return "", false
+	}
+	return h.msgs[len(h.msgs)-1], true
+}
+
+func TestShowProgress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	r, w := io.Pipe()
+	s := NewSource("-", r, ig, 1, false)
+	h := &progressHub{}
+	p := New()
+	p.hub = h
+	p.source = s
+	go s.Setup(ctx, p)
+	go p.showProgress(ctx, s)
+
+	waitFor := func(expected string) bool {
+		for {
+			if msg, ok := h.last(); ok && msg == expected {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				msg, _ := h.last()
+				return assert.Equal(t, expected, msg, "status message should match")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	io.WriteString(w, "foo\nbarbaz\n")
+	if !waitFor("Reading input... 2 lines, 11B") {
+		return
+	}
+	lines, bytes := s.ReadCount()
+	assert.Equal(t, 2, lines, "discarded lines should be counted")
+	assert.Equal(t, int64(11), bytes, "bytes should be counted")
+
+	w.Close()
+	<-s.SetupDone()
+	waitFor("")
+}
+
+func TestProgressMessage(t *testing.T) {
+	tests := []struct {
+		lines    int
+		bytes    int64
+		expected string
+	}{
+		{0, 0, "Reading input... 0 lines, 0B"},
+		{999, 1023, "Reading input... 999 lines, 1023B"},
+		{1500, 2048, "Reading input... 1.5K lines, 2.0KB"},
+		{1200000, 89443532, "Reading input... 1.2M lines, 85.3MB"},
+		{30000000, 5 << 30, "Reading input... 30.0M lines, 5.0GB"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, progressMessage(test.lines, test.bytes), "message for %d lines and %d bytes", test.lines, test.bytes)
+	}
+}
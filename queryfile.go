@@ -0,0 +1,54 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/pkg/errors"
+)
+
+// readQueryFile reads the initial query given by --query-from, which is
+// the first line of the file. A file that does not exist gives an empty
+// query, so that scripts do not need to create the file beforehand
+func readQueryFile(filename string) (string, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "failed to read query from %s", filename)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	if !scanner.Scan() {
+		return "", nil
+	}
+	return scanner.Text(), nil
+}
+
+// writeQueryFile writes q to the file given by WriteQueryTo. The file
+// is created if it does not exist, and truncated otherwise
+func writeQueryFile(filename, q string) error {
+	if err := ioutil.WriteFile(filename, []byte(q+"\n"), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write query to %s", filename)
+	}
+	return nil
+}
+
+// writeAcceptedQuery writes the query to the file given by WriteQueryTo
+// if the user accepted the selection. Failures are reported, but do not
+// change the exit status of peco
+func (p *Peco) writeAcceptedQuery() {
+	filename := p.config.WriteQueryTo
+	if filename == "" || !util.IsCollectResultsError(p.Err()) {
+		return
+	}
+
+	if err := writeQueryFile(filename, p.Query().String()); err != nil {
+		fmt.Fprintf(p.Stderr, "%s\n", err)
+	}
+}
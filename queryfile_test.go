@@ -0,0 +1,91 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadQueryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-query")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := readQueryFile(filepath.Join(dir, "missing"))
+	if !assert.NoError(t, err, "missing file should not be an error") || !assert.Equal(t, "", q, "missing file should give an empty query") {
+		return
+	}
+
+	filename := filepath.Join(dir, "query")
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte("foo bar\nbaz\n"), 0600), "writing query should succeed") {
+		return
+	}
+	q, err = readQueryFile(filename)
+	if !assert.NoError(t, err, "readQueryFile should succeed") {
+		return
+	}
+	assert.Equal(t, "foo bar", q, "query should be the first line")
+}
+
+func TestWriteQueryTo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-query")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "query")
+	run := func(t *testing.T, action func(context.Context, *Peco, termbox.Event)) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = []string{"peco", "--query-from", filename}
+		p.Stdin = bytes.NewBufferString("foo\nbar\n")
+		p.Stdout = &bytes.Buffer{}
+		p.config.WriteQueryTo = filename
+		go func() {
+			<-p.Ready()
+			<-p.source.SetupDone()
+			p.Query().Set(p.Query().String() + "o")
+			action(ctx, p, termbox.Event{})
+		}()
+
+		err := p.Run(ctx)
+		assert.NoError(t, ctx.Err(), "peco should exit before the timeout")
+		buf, _ := ioutil.ReadFile(filename)
+		return string(buf), err
+	}
+
+	// The file does not exist yet, so the initial query is empty
+	out, err := run(t, doFinish)
+	if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+		return
+	}
+	if !assert.Equal(t, "o\n", out, "query should be written") {
+		return
+	}
+
+	// The query is read back, and the file is truncated
+	out, err = run(t, doFinish)
+	if !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+		return
+	}
+	if !assert.Equal(t, "oo\n", out, "query should be read back and written") {
+		return
+	}
+
+	// Nothing is written if the user cancels
+	out, _ = run(t, doCancel)
+	assert.Equal(t, "oo\n", out, "query should not be written on cancel")
+}
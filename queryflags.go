@@ -0,0 +1,114 @@
+package peco
+
+import (
+	"strings"
+
+	"github.com/peco/peco/filter"
+)
+
+// parseQueryFlags strips the flags that the query starts with, see
+// QueryFlags. The flags end at the first word that is not a flag,
+// which is kept in the query along with the rest of it, or after "--"
+func parseQueryFlags(query string) (queryFlags, string) {
+	var flags queryFlags
+	rest := query
+	for {
+		s := strings.TrimLeft(rest, " ")
+		word := s
+		if i := strings.IndexByte(s, ' '); i >= 0 {
+			word = s[:i]
+		}
+
+		switch word {
+		case "-i":
+			flags.caseMode = queryFlagIgnoreCase
+		case "-s":
+			flags.caseMode = queryFlagCaseSensitive
+		case "--regex":
+			flags.mode = queryFlagRegex
+		case "--fuzzy":
+			flags.mode = queryFlagFuzzy
+		case "--word":
+			flags.word = true
+		case "--":
+			flags.given = true
+			return flags, strings.TrimLeft(s[len(word):], " ")
+		default:
+			if !flags.given {
+				return flags, query
+			}
+			return flags, s
+		}
+		flags.given = true
+		rest = s[len(word):]
+	}
+}
+
+// queryFilter returns the filter that the query is matched with, and
+// the part of the query that is matched. With QueryFlags, the flags
+// at the start of the query override the selected filter for the
+// query only. Without them, the selected filter and the query are
+// returned as they are
+func (p *Peco) queryFilter(selected filter.Filter, query string) (filter.Filter, string, queryFlags) {
+	if !p.config.QueryFlags {
+		return selected, query, queryFlags{}
+	}
+
+	flags, query := parseQueryFlags(query)
+	if flags.mode == "" && flags.caseMode == "" {
+		return selected, query, flags
+	}
+
+	// The flags that are not given are those of the selected filter.
+	// Filters other than Regexp and the fuzzy ones match literally
+	mode := flags.mode
+	if mode == "" {
+		switch f := selected.(type) {
+		case *filter.Regexp:
+			if f.String() == "Regexp" {
+				mode = queryFlagRegex
+			}
+		default:
+			if isFuzzyFilter(selected) {
+				mode = queryFlagFuzzy
+			}
+		}
+	}
+
+	// The fuzzy filters are always smart case, so the case flags only
+	// select the filter that matches literally or by regular expression.
+	// A fuzzy filter that is selected is kept with --fuzzy, so that it
+	// still ranks the lines if it did
+	var name string
+	switch mode {
+	case queryFlagFuzzy:
+		if flags.mode == "" || isFuzzyFilter(selected) {
+			return selected, query, flags
+		}
+		name = "Fuzzy"
+	case queryFlagRegex:
+		if flags.caseMode == queryFlagIgnoreCase {
+			return p.ignoreCaseRegexp, query, flags
+		}
+		name = "Regexp"
+	default:
+		name = "IgnoreCase"
+		if flags.caseMode == queryFlagCaseSensitive {
+			name = "CaseSensitive"
+		}
+	}
+
+	f, err := p.filters.Lookup(name)
+	if err != nil {
+		return selected, query, flags
+	}
+	return f, query, flags
+}
+
+func isFuzzyFilter(f filter.Filter) bool {
+	switch f.(type) {
+	case *filter.Fuzzy, *filter.FuzzyRanked:
+		return true
+	}
+	return false
+}
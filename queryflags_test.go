@@ -0,0 +1,117 @@
+package peco
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peco/peco/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueryFlags(t *testing.T) {
+	testValues := []struct {
+		query    string
+		flags    queryFlags
+		expected string
+	}{
+		{"foo", queryFlags{}, "foo"},
+		{"-i foo", queryFlags{given: true, caseMode: queryFlagIgnoreCase}, "foo"},
+		{"  --regex -s  ^foo bar", queryFlags{given: true, mode: queryFlagRegex, caseMode: queryFlagCaseSensitive}, "^foo bar"},
+		{"--word --fuzzy fb", queryFlags{given: true, mode: queryFlagFuzzy, word: true}, "fb"},
+		{"-i", queryFlags{given: true, caseMode: queryFlagIgnoreCase}, ""},
+		// Unknown flags, and those after the query, are part of it
+		{"-x foo", queryFlags{}, "-x foo"},
+		{"-i -x -s", queryFlags{given: true, caseMode: queryFlagIgnoreCase}, "-x -s"},
+		{"-ifoo", queryFlags{}, "-ifoo"},
+		{"-- -i foo", queryFlags{given: true}, "-i foo"},
+	}
+
+	for _, v := range testValues {
+		flags, query := parseQueryFlags(v.query)
+		assert.Equal(t, v.flags, flags, "flags of '%s' should be parsed", v.query)
+		assert.Equal(t, v.expected, query, "flags should be stripped from '%s'", v.query)
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	p := newPeco()
+	if !assert.NoError(t, p.populateFilters(), "populateFilters should succeed") {
+		return
+	}
+	lookup := func(name string) filter.Filter {
+		f, err := p.filters.Lookup(name)
+		if err != nil {
+			t.Errorf("Expected filter %s to exist: %s", name, err)
+		}
+		return f
+	}
+
+	// Without QueryFlags, the query is matched as it is
+	f, query, _ := p.queryFilter(lookup("Fuzzy"), "-i foo")
+	assert.Equal(t, lookup("Fuzzy"), f, "filter should not change without QueryFlags")
+	assert.Equal(t, "-i foo", query, "query should not change without QueryFlags")
+
+	p.config.QueryFlags = true
+	testValues := []struct {
+		selected string
+		query    string
+		expected string
+	}{
+		{"SmartCase", "-i foo", "IgnoreCase"},
+		{"IgnoreCase", "-s foo", "CaseSensitive"},
+		{"SmartCase", "--word foo", "SmartCase"},
+		{"IgnoreCase", "--regex foo", "Regexp"},
+		{"Regexp", "-i foo", "IgnoreCaseRegexp"},
+		{"CaseSensitive", "--regex -i foo", "IgnoreCaseRegexp"},
+		{"Regexp", "--fuzzy foo", "Fuzzy"},
+		{"Fuzzy", "--regex foo", "Regexp"},
+		// The fuzzy filters are kept, as they have no case flags
+		{"FuzzyRanked", "-i foo", "FuzzyRanked"},
+		{"FuzzyRanked", "--fuzzy foo", "FuzzyRanked"},
+		{"Numeric", "-s foo", "CaseSensitive"},
+	}
+	for _, v := range testValues {
+		f, query, _ := p.queryFilter(lookup(v.selected), v.query)
+		assert.Equal(t, v.expected, f.String(), "'%s' with %s should select %s", v.query, v.selected, v.expected)
+		assert.Equal(t, "foo", query, "flags should be stripped from '%s'", v.query)
+	}
+}
+
+func TestQueryFlagsMatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = strings.NewReader("FOO bar\nfoo\nfood\nbaz\n")
+	p.config.QueryFlags = true
+	p.config.InitialFilter = "CaseSensitive"
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	testValues := []struct {
+		query    string
+		expected []string
+	}{
+		{"foo", []string{"foo", "food"}},
+		{"-i foo", []string{"FOO bar", "foo", "food"}},
+		{"-i --word foo", []string{"FOO bar", "foo"}},
+		{"--regex ^f.o$", []string{"foo"}},
+	}
+	for _, v := range testValues {
+		p.Query().Set(v.query)
+		p.ExecQuery()
+		for !assert.ObjectsAreEqual(v.expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				assert.Fail(t, "timed out waiting for the query", "expected %v for '%s', got %v", v.expected, v.query, bufferLines(p.CurrentLineBuffer()))
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package peco
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+)
+
+// validateQuickSelectChars makes sure that the labels made of the
+// characters in s can be typed, and told apart
+func validateQuickSelectChars(s string) error {
+	seen := make(map[rune]bool)
+	for _, r := range s {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) || runewidth.RuneWidth(r) != 1 {
+			return errors.Errorf("%q cannot be used in labels", r)
+		}
+		if seen[r] {
+			return errors.Errorf("%q is given more than once", r)
+		}
+		seen[r] = true
+	}
+	if len(seen) < 2 {
+		return errors.New("at least 2 characters are required")
+	}
+	return nil
+}
+
+// quickSelectLabels returns the labels for n lines, made of chars. The
+// labels are one character long if there are enough characters, and
+// two characters long otherwise, so that none of them is the start of
+// another. Lines that do not get a label are left out
+func quickSelectLabels(n int, chars []rune) []string {
+	k := len(chars)
+	if n <= k {
+		labels := make([]string, n)
+		for i := range labels {
+			labels[i] = string(chars[i])
+		}
+		return labels
+	}
+
+	labels := make([]string, minOf(n, k*k))
+	for i := range labels {
+		labels[i] = string([]rune{chars[i/k], chars[i%k]})
+	}
+	return labels
+}
+
+// QuickSelectLabels returns the labels shown by peco.QuickSelect next to
+// the visible lines, indexed by their position in the page, and the part
+// of a label that has been typed so far. Returns nil if peco.QuickSelect
+// is not active. Once the list has scrolled or the results have changed
+// since it was executed, it is no longer active
+func (p *Peco) QuickSelectLabels() ([]string, string) {
+	linebuf := p.CurrentLineBuffer()
+	query := p.Query().String()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	qs := p.quickSelect
+	if qs == nil {
+		return nil, ""
+	}
+	if qs.offset != p.quickSelectOffset || qs.linebuf != linebuf || qs.query != query {
+		p.quickSelect = nil
+		return nil, ""
+	}
+	return qs.labels, qs.typed
+}
+
+// setQuickSelectWindow records the first line on the screen and the
+// number of lines per page. The location is only accessed by the view,
+// so like setLazyWindow, it is recorded there as the page is
+// calculated, for the actions to place the labels
+func (p *Peco) setQuickSelectWindow(offset, perPage int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.quickSelectOffset = offset
+	p.quickSelectPerPage = perPage
+}
+
+// visibleLines returns the window recorded by setQuickSelectWindow
+func (p *Peco) visibleLines() (offset, perPage int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.quickSelectOffset, p.quickSelectPerPage
+}
+
+func (p *Peco) setQuickSelect(qs *quickSelectState) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.quickSelect = qs
+}
+
+func (p *Peco) setQuickSelectTyped(s string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.quickSelect != nil {
+		p.quickSelect.typed = s
+	}
+}
+
+// endQuickSelect removes the labels shown by peco.QuickSelect
+func endQuickSelect(state *Peco) {
+	state.setQuickSelect(nil)
+	state.Hub().SendDraw(nil)
+}
+
+// doQuickSelect labels the visible lines, so that typing the label of a
+// line accepts it. Executing it again removes the labels
+func doQuickSelect(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doQuickSelect")
+		defer g.End()
+	}
+
+	if labels, _ := state.QuickSelectLabels(); labels != nil {
+		endQuickSelect(state)
+		return
+	}
+
+	offset, perPage := state.visibleLines()
+	linebuf := state.CurrentLineBuffer()
+	n := minOf(perPage, linebuf.Size()-offset)
+	if n <= 0 {
+		return
+	}
+
+	state.setQuickSelect(&quickSelectState{
+		labels:  quickSelectLabels(n, state.quickSelectChars),
+		offset:  offset,
+		linebuf: linebuf,
+		query:   state.Query().String(),
+	})
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
+// doQuickSelectKey handles the keys typed while peco.QuickSelect is
+// active. Typing a key that no label starts with removes the labels
+func doQuickSelectKey(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doQuickSelectKey %c", e.Ch)
+		defer g.End()
+	}
+
+	labels, typed := state.QuickSelectLabels()
+	typed += string(e.Ch)
+
+	var partial bool
+	for i, label := range labels {
+		if label == typed {
+			state.setQuickSelect(nil)
+			toplevel, _ := ctx.Value(isTopLevelActionCall).(bool)
+			state.Hub().Batch(func() {
+				ctx = context.WithValue(ctx, isTopLevelActionCall, false)
+				state.Hub().SendPaging(JumpToLineRequest(i))
+				doFinish(ctx, state, e)
+			}, toplevel)
+			return
+		}
+		if strings.HasPrefix(label, typed) {
+			partial = true
+		}
+	}
+
+	if !partial {
+		endQuickSelect(state)
+		state.Hub().SendStatusMsgAndClear("Quick select canceled", time.Second)
+		return
+	}
+	state.setQuickSelectTyped(typed)
+	state.Hub().SendDraw(nil)
+}
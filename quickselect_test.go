@@ -0,0 +1,126 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuickSelectLabels(t *testing.T) {
+	tests := []struct {
+		n        int
+		chars    string
+		expected []string
+	}{
+		{0, "abc", []string{}},
+		{2, "abc", []string{"a", "b"}},
+		{3, "abc", []string{"a", "b", "c"}},
+		{4, "abc", []string{"aa", "ab", "ac", "ba"}},
+		{6, "ab", []string{"aa", "ab", "ba", "bb"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, quickSelectLabels(test.n, []rune(test.chars)), "labels for %d lines with %q", test.n, test.chars)
+	}
+}
+
+func TestValidateQuickSelectChars(t *testing.T) {
+	assert.NoError(t, validateQuickSelectChars(DefaultQuickSelectChars), "default chars should be valid")
+	for _, s := range []string{"a", "aba", "a b", "a日"} {
+		assert.Error(t, validateQuickSelectChars(s), "%q should be invalid", s)
+	}
+}
+
+func TestQuickSelect(t *testing.T) {
+	start := func(t *testing.T, ctx context.Context) (*Peco, *bytes.Buffer, chan error) {
+		p := newPeco()
+		p.Argv = nil
+		p.Stdin = bytes.NewBufferString("foo\nbar\nbaz\n")
+		p.config.QuickSelect.Chars = "ab"
+		out := &bytes.Buffer{}
+		p.Stdout = out
+		resultCh := make(chan error)
+		go func() { resultCh <- p.Run(ctx) }()
+
+		<-p.Ready()
+		<-p.source.SetupDone()
+		for p.Location().PerPage() == 0 || p.CurrentLineBuffer().Size() < 3 {
+			select {
+			case <-ctx.Done():
+				t.Errorf("lines were not displayed")
+				return nil, nil, nil
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return p, out, resultCh
+	}
+
+	t.Run("accept", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p, out, resultCh := start(t, ctx)
+		if p == nil {
+			return
+		}
+
+		doQuickSelect(ctx, p, termbox.Event{})
+		labels, _ := p.QuickSelectLabels()
+		if !assert.Equal(t, []string{"aa", "ab", "ba"}, labels, "labels should be two characters long") {
+			return
+		}
+
+		doAcceptChar(ctx, p, termbox.Event{Ch: 'b'})
+		labels, typed := p.QuickSelectLabels()
+		if !assert.NotNil(t, labels, "labels should be kept after the first character") {
+			return
+		}
+		if !assert.Equal(t, "b", typed, "typed character should be remembered") {
+			return
+		}
+
+		doAcceptChar(ctx, p, termbox.Event{Ch: 'a'})
+		if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "peco should exit with the results") {
+			return
+		}
+		p.PrintResults()
+		assert.Equal(t, "baz\n", out.String(), "labeled line should be accepted")
+		assert.Equal(t, "", p.Query().String(), "labels should not be added to the query")
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p, _, _ := start(t, ctx)
+		if p == nil {
+			return
+		}
+
+		doQuickSelect(ctx, p, termbox.Event{})
+		doAcceptChar(ctx, p, termbox.Event{Ch: 'x'})
+		labels, _ := p.QuickSelectLabels()
+		if !assert.Nil(t, labels, "unknown label should remove the labels") {
+			return
+		}
+
+		doQuickSelect(ctx, p, termbox.Event{})
+		doCancel(ctx, p, termbox.Event{})
+		labels, _ = p.QuickSelectLabels()
+		if !assert.Nil(t, labels, "peco.Cancel should remove the labels") {
+			return
+		}
+		if !assert.NoError(t, ctx.Err(), "peco should not exit") {
+			return
+		}
+
+		doQuickSelect(ctx, p, termbox.Event{})
+		p.Query().Set("ba")
+		labels, _ = p.QuickSelectLabels()
+		assert.Nil(t, labels, "query change should remove the labels")
+	})
+}
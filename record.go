@@ -0,0 +1,72 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/peco/peco/line"
+)
+
+// scanRecords returns a split function for bufio.Scanner that splits
+// the input into records at every occurrence of sep. The newlines at
+// the start and the end of each record are trimmed, and the records
+// that are left empty are skipped, so that "\n\n" separates records by
+// any number of blank lines
+func scanRecords(sep string) bufio.SplitFunc {
+	delim := []byte(sep)
+	trim := func(b []byte) []byte {
+		return bytes.Trim(b, "\r\n")
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// Empty records are skipped here, as the scanner stops at the
+		// end of the input if no token is returned
+		var skipped int
+		for {
+			if atEOF && len(data) == 0 {
+				return skipped, nil, nil
+			}
+
+			i := bytes.Index(data, delim)
+			if i < 0 {
+				if atEOF {
+					if rec := trim(data); len(rec) > 0 {
+						return skipped + len(data), rec, nil
+					}
+					return skipped + len(data), nil, nil
+				}
+				// Request more data
+				return skipped, nil, nil
+			}
+
+			if rec := trim(data[:i]); len(rec) > 0 {
+				return skipped + i + len(delim), rec, nil
+			}
+			skipped += i + len(delim)
+			data = data[i+len(delim):]
+		}
+	}
+}
+
+// compileRecordSummary parses the RecordSummary configuration
+func compileRecordSummary(s string) (*template.Template, error) {
+	return compileTemplate("RecordSummary", s, recordSummary{Lines: []string{""}})
+}
+
+// newRecord creates the line for a record read with RecordSeparator,
+// which is summarized by its first line unless RecordSummary says
+// otherwise. If the template fails on a record, for example because it
+// has fewer lines than the template expects, the first line is used
+func (p *Peco) newRecord(rl *line.Raw) *line.Record {
+	text := rl.Buffer()
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	summary := lines[0]
+	if t := p.recordSummary; t != nil {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, recordSummary{Record: text, Lines: lines}); err == nil {
+			summary = buf.String()
+		}
+	}
+	return line.NewRecord(rl, summary)
+}
@@ -0,0 +1,103 @@
+package peco
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanRecords(t *testing.T) {
+	testValues := []struct {
+		sep      string
+		input    string
+		expected []string
+	}{
+		{"\n\n", "foo\nbar\n\nbaz\n", []string{"foo\nbar", "baz"}},
+		{"\n\n", "\n\nfoo\n\n\n\nbar\n\n", []string{"foo", "bar"}},
+		{"\n\n", "foo\r\n\r\nbar", []string{"foo\r\n\r\nbar"}},
+		{"---", "foo\n---\nbar\n---\n", []string{"foo", "bar"}},
+		{"\n\n", "", nil},
+	}
+
+	for _, v := range testValues {
+		scanner := bufio.NewScanner(strings.NewReader(v.input))
+		scanner.Split(scanRecords(v.sep))
+
+		var records []string
+		for scanner.Scan() {
+			records = append(records, scanner.Text())
+		}
+		if !assert.NoError(t, scanner.Err(), "scanning %q should succeed", v.input) {
+			return
+		}
+		assert.Equal(t, v.expected, records, "records scanned from %q with %q", v.input, v.sep)
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	p := newPeco()
+	text := "commit abc\nAuthor: foo\n\n    Fix the bug"
+
+	r := p.newRecord(line.NewRaw(0, text, false))
+	assert.Equal(t, "commit abc", r.DisplayString(), "first line should be the summary")
+	assert.Equal(t, text, line.MatchString(r), "whole record should be matched")
+	assert.Equal(t, text, r.Output(), "whole record should be output")
+
+	tmpl, err := compileRecordSummary("{{if gt (len .Lines) 3}}{{index .Lines 3}}\n{{end}}({{index .Lines 0}})")
+	if !assert.NoError(t, err, "compileRecordSummary should succeed") {
+		return
+	}
+	p.recordSummary = tmpl
+	r = p.newRecord(line.NewRaw(1, text, false))
+	assert.Equal(t, "    Fix the bug (commit abc)", r.DisplayString(), "summary should be a single row")
+
+	r = p.newRecord(line.NewRaw(2, "short", false))
+	assert.Equal(t, "(short)", r.DisplayString(), "template should be executed for short records")
+
+	_, err = compileRecordSummary("{{.Missing}}")
+	assert.Error(t, err, "unknown fields should be reported")
+}
+
+func TestRecordSeparator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = strings.NewReader("commit 1\n    Add foo\n\ncommit 2\n    Fix bar\n\ncommit 3\n    Add bar\n")
+	out := &bytes.Buffer{}
+	p.Stdout = out
+	p.config.RecordSeparator = "\n\n"
+	resultCh := make(chan error)
+	go func() { resultCh <- p.Run(ctx) }()
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	p.Query().Set("bar")
+	p.ExecQuery()
+
+	expected := []string{"commit 2", "commit 3"}
+	for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+		select {
+		case <-ctx.Done():
+			assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "records should be matched as a whole")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	doFinish(ctx, p, termbox.Event{})
+	if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "peco should exit with the results") {
+		return
+	}
+	p.PrintResults()
+	assert.Equal(t, "commit 2\n    Fix bar\n", out.String(), "whole record should be output")
+}
@@ -0,0 +1,110 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/peco/peco/line"
+)
+
+// RefinementDepth returns the number of times peco.RefineByLine has
+// been applied without being undone by peco.PopRefinement
+func (p *Peco) RefinementDepth() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.refinements)
+}
+
+func (p *Peco) pushRefinement(r refinement) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.refinements = append(p.refinements, r)
+}
+
+// popRefinement removes the last refinement from the stack. The second
+// return value is false if the stack is empty
+func (p *Peco) popRefinement() (refinement, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	n := len(p.refinements)
+	if n == 0 {
+		return refinement{}, false
+	}
+	r := p.refinements[n-1]
+	p.refinements = p.refinements[:n-1]
+	return r, true
+}
+
+// refinedQuery returns the query that narrows down the lines matched
+// by q to those that contain s. Filters that do not combine query
+// terms can only match against s alone
+func refinedQuery(f filter.Filter, q, s string) string {
+	term, ok := filter.LiteralTerm(f, s)
+	if !ok || q == "" {
+		return term
+	}
+	return q + " " + term
+}
+
+// doRefineByLine adds the contents of the current line to the query,
+// so that only the lines containing it are displayed. The current
+// query and its results are saved, so that peco.PopRefinement can go
+// back to them
+func doRefineByLine(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doRefineByLine")
+		defer g.End()
+	}
+
+	loc := state.Location()
+	b := state.CurrentLineBuffer()
+	l, err := b.LineAt(loc.LineNumber())
+	if err != nil {
+		return
+	}
+
+	q := state.Query()
+	c := state.Caret()
+	state.pushRefinement(refinement{
+		query:      q.String(),
+		caretPos:   c.Pos(),
+		lineBuffer: b,
+		lineNumber: loc.LineNumber(),
+		multiQuery: state.MultiQuery(),
+	})
+
+	q.Set(refinedQuery(state.Filters().Current(), q.String(), line.MatchString(l)))
+	c.SetPos(q.Len())
+	loc.SetLineNumber(0)
+
+	state.Hub().SendDrawPrompt()
+	state.ExecQuery()
+}
+
+// doPopRefinement undoes the last peco.RefineByLine. The saved results
+// are displayed as they were, without running the filter again
+func doPopRefinement(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doPopRefinement")
+		defer g.End()
+	}
+
+	r, ok := state.popRefinement()
+	if !ok {
+		state.Hub().SendStatusMsgAndClear("Nothing to pop", time.Second)
+		return
+	}
+
+	state.Query().Set(r.query)
+	state.Caret().SetPos(r.caretPos)
+	state.SetMultiQuery(r.multiQuery)
+	state.Location().SetLineNumber(r.lineNumber)
+	state.SetCurrentLineBuffer(r.lineBuffer)
+
+	state.Hub().SendDrawPrompt()
+	state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
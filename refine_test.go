@@ -0,0 +1,105 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefinedQuery(t *testing.T) {
+	assert.Equal(t, `foo\ bar`, refinedQuery(filter.NewIgnoreCase(), "", "foo bar"), "empty query should be replaced")
+	assert.Equal(t, `baz foo\ bar`, refinedQuery(filter.NewIgnoreCase(), "baz", "foo bar"), "term should be appended")
+	assert.Equal(t, `a\.b`, refinedQuery(filter.NewRegexp(), "", "a.b"), "regular expressions should be quoted")
+	assert.Equal(t, `baz 'foo\ bar`, refinedQuery(filter.NewFuzzy(), "baz", "foo bar"), "fuzzy query should get an exact term")
+	assert.Equal(t, "foo bar", refinedQuery(filter.NewExternalCmd("cat", "cat", nil, 0, 0, nil, false, filter.HighlightNone, 0), "baz", "foo bar"), "custom filter query should be replaced")
+}
+
+func TestRefineByLine(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("foo bar\nfoo\nfoo bar baz\nqux\n")
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitForLines := func(expected []string) bool {
+		for {
+			if b := p.CurrentLineBuffer(); b.Size() == len(expected) {
+				if lines := bufferLines(b); assert.ObjectsAreEqual(expected, lines) {
+					return true
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should be refined")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	if !waitForLines([]string{"foo bar", "foo", "foo bar baz", "qux"}) {
+		return
+	}
+	source := p.CurrentLineBuffer()
+
+	doRefineByLine(ctx, p, termbox.Event{})
+	if !assert.Equal(t, `foo\ bar`, p.Query().String(), "query should be refined") {
+		return
+	}
+	if !assert.Equal(t, 1, p.RefinementDepth(), "depth should be 1") {
+		return
+	}
+	if !waitForLines([]string{"foo bar", "foo bar baz"}) {
+		return
+	}
+	refined := p.CurrentLineBuffer()
+
+	p.Location().SetLineNumber(1)
+	doRefineByLine(ctx, p, termbox.Event{})
+	if !assert.Equal(t, `foo\ bar foo\ bar\ baz`, p.Query().String(), "query should be refined") {
+		return
+	}
+	if !assert.Equal(t, 2, p.RefinementDepth(), "depth should be 2") {
+		return
+	}
+	if !waitForLines([]string{"foo bar baz"}) {
+		return
+	}
+
+	doPopRefinement(ctx, p, termbox.Event{})
+	if !assert.Equal(t, `foo\ bar`, p.Query().String(), "query should be restored") {
+		return
+	}
+	if !assert.Equal(t, p.Query().Len(), p.Caret().Pos(), "caret should be restored") {
+		return
+	}
+	if !assert.Equal(t, refined, p.CurrentLineBuffer(), "results should be restored without filtering") {
+		return
+	}
+	if !assert.Equal(t, 1, p.Location().LineNumber(), "line number should be restored") {
+		return
+	}
+
+	doPopRefinement(ctx, p, termbox.Event{})
+	if !assert.Equal(t, "", p.Query().String(), "query should be restored") {
+		return
+	}
+	if !assert.Equal(t, source, p.CurrentLineBuffer(), "results should be restored without filtering") {
+		return
+	}
+	if !assert.Equal(t, 0, p.RefinementDepth(), "depth should be 0") {
+		return
+	}
+
+	doPopRefinement(ctx, p, termbox.Event{})
+	assert.Equal(t, 0, p.RefinementDepth(), "popping an empty stack should do nothing")
+}
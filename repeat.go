@@ -0,0 +1,56 @@
+package peco
+
+import (
+	"context"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// recordAction remembers a as the action for peco.RepeatLastAction to
+// execute, unless it repeated the last action itself
+func (p *Peco) recordAction(a Action, ev termbox.Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.actionRepeated {
+		return
+	}
+	p.lastAction = repeatableAction{action: a, event: ev}
+}
+
+// wrapRecordAction records a once it has been executed, so that it can
+// be repeated. Only the actions bound to keys are recorded: characters
+// that are typed into the query are not
+func wrapRecordAction(a Action) Action {
+	return ActionFunc(func(ctx context.Context, state *Peco, ev termbox.Event) {
+		state.mutex.Lock()
+		state.actionRepeated = false
+		state.mutex.Unlock()
+
+		a.Execute(ctx, state, ev)
+		state.recordAction(a, ev)
+	})
+}
+
+// doRepeatLastAction executes the last action that was executed with a
+// key again, with the same key. Actions that contain
+// peco.RepeatLastAction, such as combined actions, are never repeated,
+// which also keeps it from repeating itself
+func doRepeatLastAction(ctx context.Context, state *Peco, e termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doRepeatLastAction")
+		defer g.End()
+	}
+
+	state.mutex.Lock()
+	last := state.lastAction
+	state.actionRepeated = true
+	state.mutex.Unlock()
+
+	if last.action == nil {
+		state.Hub().SendStatusMsg("No action to repeat")
+		return
+	}
+	last.action.Execute(ctx, state, last.event)
+}
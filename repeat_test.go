@@ -0,0 +1,63 @@
+package peco
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchHub is a nullHub that runs the functions given to Batch, so that
+// combined actions do something
+type batchHub struct {
+	nullHub
+}
+
+func (batchHub) Batch(f func(), _ bool) { f() }
+
+func TestRepeatLastAction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := newPeco()
+	state.hub = batchHub{}
+	state.Query().Set("abcdef")
+	state.Caret().SetPos(6)
+
+	km := NewKeymap(
+		map[string]string{"C-r": "peco.RepeatLastAction", "C-y": "my.BackThenRepeat"},
+		map[string][]string{"my.BackThenRepeat": {"peco.BackwardChar", "peco.RepeatLastAction"}},
+		nil,
+	)
+	if !assert.NoError(t, km.ApplyKeybinding(), "ApplyKeybinding should succeed") {
+		return
+	}
+
+	keys := []struct {
+		ev       termbox.Event
+		expected int
+	}{
+		// Nothing to repeat yet
+		{termbox.Event{Key: termbox.KeyCtrlR}, 6},
+		{termbox.Event{Key: termbox.KeyCtrlB}, 5},
+		{termbox.Event{Key: termbox.KeyCtrlR}, 4},
+		// Repeating again repeats the same action
+		{termbox.Event{Key: termbox.KeyCtrlR}, 3},
+		// Typed characters are not recorded
+		{termbox.Event{Ch: 'x'}, 4},
+		{termbox.Event{Key: termbox.KeyCtrlR}, 3},
+		// Nor are the actions that repeat the last action
+		{termbox.Event{Key: termbox.KeyCtrlY}, 1},
+		{termbox.Event{Key: termbox.KeyCtrlR}, 0},
+	}
+	for i, k := range keys {
+		if !assert.NoError(t, km.ExecuteAction(ctx, state, k.ev), "ExecuteAction should succeed") {
+			return
+		}
+		if !assert.Equal(t, k.expected, state.Caret().Pos(), "caret position after key %d should match", i) {
+			return
+		}
+	}
+	assert.Equal(t, "abcxdef", state.Query().String(), "query should match")
+}
@@ -0,0 +1,66 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/filter"
+)
+
+// IsValidResetQueryMode checks if a string is a supported value for
+// ResetQueryOnFilterChange. The empty string selects the default mode
+func IsValidResetQueryMode(v string) bool {
+	switch v {
+	case "", ResetQueryNever, ResetQueryAlways, ResetQueryIncompatible:
+		return true
+	}
+	return false
+}
+
+// resetQueryOnFilterChange clears the query once the filter has been
+// changed, if ResetQueryOnFilterChange asks for it. The query can be
+// brought back with peco.RestoreQuery
+func resetQueryOnFilterChange(state *Peco) {
+	q := state.Query().String()
+	if q == "" {
+		return
+	}
+
+	switch state.resetQueryMode {
+	case ResetQueryAlways:
+	case ResetQueryIncompatible:
+		v, ok := state.Filters().Current().(filter.QueryValidator)
+		if !ok || v.IsValidQuery(q) {
+			return
+		}
+	default:
+		return
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("Clearing query '%s' for filter %s", q, state.Filters().Current())
+	}
+	state.restorableQuery = q
+	state.Query().Reset()
+	state.Caret().SetPos(0)
+	state.Hub().SendStatusMsgAndClear("Query cleared", time.Second)
+}
+
+// doRestoreQuery brings back the query that was cleared the last time
+// the filter was changed
+func doRestoreQuery(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doRestoreQuery")
+		defer g.End()
+	}
+
+	q := state.restorableQuery
+	if q == "" {
+		state.Hub().SendStatusMsgAndClear("No query to restore", time.Second)
+		return
+	}
+	state.restorableQuery = ""
+	setQuery(state, q)
+}
@@ -0,0 +1,78 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResetQueryOnFilterChange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state := newPeco()
+	state.Argv = []string{"peco", "--initial-filter", "SmartCase", "--query", "ba("}
+	state.Stdin = bytes.NewBufferString("foo\nba(r\nbaz\n")
+	state.config.ResetQueryOnFilterChange = ResetQueryIncompatible
+	go state.Run(ctx)
+
+	<-state.Ready()
+	<-state.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(state.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached while waiting for %v, got %v", expected, bufferLines(state.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	if !waitLines([]string{"ba(r"}) {
+		return
+	}
+
+	// "ba(" is not a valid regular expression
+	doRotateFilter(ctx, state, termbox.Event{})
+	if !assert.Equal(t, "Regexp", state.Filters().Current().String(), "filter should be rotated") {
+		return
+	}
+	if !assert.Equal(t, "", state.Query().String(), "query should be cleared") {
+		return
+	}
+	if !waitLines([]string{"foo", "ba(r", "baz"}) {
+		return
+	}
+
+	doRestoreQuery(ctx, state, termbox.Event{})
+	if !assert.Equal(t, "ba(", state.Query().String(), "query should be restored") {
+		return
+	}
+	if !assert.Equal(t, "", state.restorableQuery, "query should only be restored once") {
+		return
+	}
+	if !waitLines(nil) {
+		return
+	}
+
+	// Filters that do not check the query keep it
+	doRotateFilter(ctx, state, termbox.Event{})
+	if !assert.Equal(t, "ba(", state.Query().String(), "query should be kept") {
+		return
+	}
+	waitLines([]string{"ba(r"})
+}
+
+func TestIsValidResetQueryMode(t *testing.T) {
+	for _, v := range []string{"", ResetQueryNever, ResetQueryAlways, ResetQueryIncompatible} {
+		assert.True(t, IsValidResetQueryMode(v), "%q should be valid", v)
+	}
+	assert.False(t, IsValidResetQueryMode("sometimes"), "unknown mode should be invalid")
+}
@@ -15,7 +15,20 @@ func (t *Termbox) Init() error {
 		return errors.Wrap(err, "failed to initialized termbox")
 	}
 
-	return t.PostInit()
+	if err := t.PostInit(); err != nil {
+		return err
+	}
+
+	// termbox forgets about the input mode when it's closed, so
+	// we need to set it up every time we're initialized (e.g. after
+	// being suspended)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.initialized = true
+	if t.mouse {
+		setMouseMode(true)
+	}
+	return nil
 }
 
 func NewTermbox() *Termbox {
@@ -29,11 +42,39 @@ func (t *Termbox) Close() error {
 	if pdebug.Enabled {
 		pdebug.Printf("Termbox: Close")
 	}
+	t.mutex.Lock()
+	t.initialized = false
+	t.mutex.Unlock()
+
 	termbox.Interrupt()
 	termbox.Close()
 	return nil
 }
 
+// SetMouse enables or disables reporting of mouse events. While it is
+// disabled, the terminal handles the mouse as usual, so the text on the
+// screen can be selected with it
+func (t *Termbox) SetMouse(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.mouse = enabled
+	if t.initialized {
+		setMouseMode(enabled)
+	}
+}
+
+// setMouseMode changes the input mode of termbox, keeping the
+// flags that are not related to the mouse
+func setMouseMode(enabled bool) {
+	mode := termbox.SetInputMode(termbox.InputCurrent)
+	if enabled {
+		mode |= termbox.InputMouse
+	} else {
+		mode &^= termbox.InputMouse
+	}
+	termbox.SetInputMode(mode)
+}
+
 // SendEvent is used to allow programmers generate random
 // events, but it's only useful for testing purposes.
 // When interactiving with termbox-go, this method is a noop
@@ -159,6 +200,7 @@ func screenPrint(t Screen, args PrintArgs) int {
 	x := args.X
 	y := args.Y
 	xOffset := args.XOffset
+	width, _ := t.Size()
 	for len(msg) > 0 {
 		c, w := utf8.DecodeRuneInString(msg)
 		if c == utf8.RuneError {
@@ -170,13 +212,37 @@ func screenPrint(t Screen, args PrintArgs) int {
 			// In case we found a tab, we draw it as 4 spaces
 			n := 4 - (x+xOffset)%4
 			for i := int(0); i <= n; i++ {
-				t.SetCell(int(x+i), int(y), ' ', fg, bg)
+				if x+i >= 0 {
+					t.SetCell(int(x+i), int(y), ' ', fg, bg)
+				}
 			}
 			written += n
 			x += n
 		} else {
-			t.SetCell(int(x), int(y), c, fg, bg)
 			n := int(runewidth.RuneWidth(c))
+			switch {
+			case n == 0:
+				// Zero width runes such as combining marks do not
+				// have a cell of their own, so they can't be drawn
+				continue
+			case x >= 0 && x+n > width:
+				// Only part of this wide character fits before the
+				// right edge of the screen, so pad it with spaces
+				// rather than drawing half of it
+				for i := x; i < width; i++ {
+					t.SetCell(i, int(y), ' ', fg, bg)
+				}
+			case x >= 0:
+				t.SetCell(int(x), int(y), c, fg, bg)
+			case x+n > 0:
+				// The line has been scrolled horizontally, and only
+				// part of this wide character is on screen. Cells to
+				// the left of the screen are clipped, so pad the part
+				// that is visible with spaces
+				for i := 0; i < x+n; i++ {
+					t.SetCell(i, int(y), ' ', fg, bg)
+				}
+			}
 			x += n
 			written += n
 		}
@@ -186,10 +252,62 @@ func screenPrint(t Screen, args PrintArgs) int {
 		return written
 	}
 
-	width, _ := t.Size()
 	for ; x < int(width); x++ {
-		t.SetCell(int(x), int(y), ' ', fg, bg)
+		if x >= 0 {
+			t.SetCell(int(x), int(y), ' ', fg, bg)
+		}
 	}
 	written += int(width) - x
 	return written
 }
+
+func newHeadlessScreen(width, height int) *headlessScreen {
+	return &headlessScreen{width: width, height: height}
+}
+
+func (s *headlessScreen) Init() error {
+	return nil
+}
+
+func (s *headlessScreen) Close() error {
+	return nil
+}
+
+func (s *headlessScreen) Flush() error {
+	return nil
+}
+
+func (s *headlessScreen) Resume() {}
+
+func (s *headlessScreen) Suspend() {}
+
+func (s *headlessScreen) SetMouse(_ bool) {}
+
+func (s *headlessScreen) SendEvent(_ termbox.Event) {}
+
+func (s *headlessScreen) SetCell(x, y int, ch rune, fg, bg termbox.Attribute) {}
+
+// PollEvent returns a channel that never receives anything, as the
+// events are dispatched by the replay instead
+func (s *headlessScreen) PollEvent(_ context.Context) chan termbox.Event {
+	return make(chan termbox.Event)
+}
+
+func (s *headlessScreen) Print(args PrintArgs) int {
+	return screenPrint(s, args)
+}
+
+func (s *headlessScreen) Size() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.width, s.height
+}
+
+// SetSize changes the size reported by Size, like the terminal being
+// resized
+func (s *headlessScreen) SetSize(width, height int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.width = width
+	s.height = height
+}
@@ -0,0 +1,108 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// ErrCanceled is returned by Select when the user exits peco without
+// choosing any lines, including when peco gives up because of
+// --exit-0 or IdleTimeout
+var ErrCanceled = errors.New("canceled")
+
+// Options configures Select
+type Options struct {
+	// Args are the command line options to run peco with, without the
+	// program name. For example []string{"--query", "foo"}. Arguments
+	// that are not options are not allowed, as the input comes from the
+	// lines given to Select
+	Args []string
+
+	// Screen is where peco draws its user interface, and reads the
+	// keys typed by the user from. Defaults to the terminal
+	Screen Screen
+
+	// Stderr receives the messages that peco prints once the screen
+	// has been closed. Defaults to os.Stderr
+	Stderr io.Writer
+}
+
+// Select runs peco against lines, and returns the lines chosen by the
+// user. If the user cancels peco, ErrCanceled is returned. If ctx is
+// canceled, peco exits and ctx.Err() is returned.
+//
+// The config file is read as if peco was run from the command line.
+// Lines may contain newlines, as they are given to peco as NUL
+// terminated records.
+//
+// Select blocks until peco exits. Unless Options.Screen is given, it
+// takes over the terminal for as long as it runs, so nothing else may
+// read from or write to the terminal in the meantime, and only one
+// call to Select may run at a time. Signals such as SIGINT make peco
+// exit as if it was canceled. The terminal is restored before Select
+// returns, including when ctx is canceled
+func Select(ctx context.Context, lines []string, opts Options) (selected []string, err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("peco.Select (%d lines)", len(lines)).BindError(&err)
+		defer g.End()
+	}
+
+	// The lines replace the input, so there must not be any files to
+	// read from
+	var cliopts CLIOptions
+	remaining, err := cliopts.parse(opts.Args)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse options")
+	}
+	if len(remaining) > 0 {
+		return nil, errors.Errorf("unexpected argument '%s'", remaining[0])
+	}
+
+	var in bytes.Buffer
+	for _, l := range lines {
+		in.WriteString(l)
+		in.WriteByte(0)
+	}
+
+	p := New()
+	p.Argv = append([]string{"peco", "--read-null"}, opts.Args...)
+	p.Stdin = &in
+	if opts.Screen != nil {
+		p.screen = opts.Screen
+	}
+	if opts.Stderr != nil {
+		p.Stderr = opts.Stderr
+	}
+
+	if err := p.Run(ctx); err != nil {
+		switch {
+		case util.IsCollectResultsError(err):
+			return p.selectedStrings(), nil
+		case util.IsIgnorableError(err):
+			return nil, ErrCanceled
+		default:
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrCanceled
+}
+
+// selectedStrings returns the lines that PrintResults would print, as
+// plain strings
+func (p *Peco) selectedStrings() []string {
+	var selected []string
+	p.results(p.acceptAll).each(func(l line.Line) bool {
+		selected = append(selected, p.outputString(l))
+		return true
+	})
+	return selected
+}
@@ -0,0 +1,98 @@
+package peco
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// closeRecorder is a Screen that remembers whether it was closed after
+// being initialized
+type closeRecorder struct {
+	*dummyScreen
+	mutex       sync.Mutex
+	initialized bool
+}
+
+func (s *closeRecorder) Init() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.initialized = true
+	return nil
+}
+
+func (s *closeRecorder) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.initialized = false
+	return nil
+}
+
+func TestSelect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-select")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Keep the user's config file and history out of the way
+	rcfile := filepath.Join(dir, "config.json")
+	if !assert.NoError(t, ioutil.WriteFile(rcfile, []byte("{}"), 0644), "writing config should succeed") {
+		return
+	}
+	defer os.Setenv("XDG_STATE_HOME", os.Getenv("XDG_STATE_HOME"))
+	os.Setenv("XDG_STATE_HOME", dir)
+
+	lines := []string{"foo", "bar", "multi\nline"}
+	run := func(ctx context.Context, args []string, screen Screen) ([]string, error) {
+		return Select(ctx, lines, Options{
+			Args:   append([]string{"--rcfile", rcfile}, args...),
+			Screen: screen,
+		})
+	}
+
+	t.Run("select", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		selected, err := run(ctx, []string{"--select-1", "--query", "line"}, NewDummyScreen())
+		if !assert.NoError(t, err, "Select should succeed") {
+			return
+		}
+		assert.Equal(t, []string{"multi\nline"}, selected, "selected lines should match")
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		screen := NewDummyScreen()
+		go screen.SendEvent(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc})
+		_, err := run(ctx, nil, screen)
+		assert.Equal(t, ErrCanceled, err, "Select should be canceled")
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		screen := &closeRecorder{dummyScreen: NewDummyScreen()}
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		_, err := run(ctx, nil, screen)
+		if !assert.Equal(t, context.Canceled, err, "Select should return the error of ctx") {
+			return
+		}
+		assert.False(t, screen.initialized, "screen should be closed")
+	})
+
+	t.Run("arguments", func(t *testing.T) {
+		_, err := run(context.Background(), []string{"file.txt"}, NewDummyScreen())
+		assert.Error(t, err, "files should not be allowed")
+	})
+}
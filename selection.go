@@ -12,32 +12,187 @@ func NewSelection() *Selection {
 	return s
 }
 
+// SetLimit sets the maximum number of lines that the selection holds.
+// Once the selection is full, Add does not add any more lines, unless
+// evictOldest is true, in which case the line that was added first is
+// removed to make room. 0 means no limit
+func (s *Selection) SetLimit(max int, evictOldest bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.max = max
+	s.evictOldest = evictOldest
+}
+
+// Limit returns the maximum number of lines set by SetLimit
+func (s *Selection) Limit() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.max
+}
+
+// SetDisabled stops the selection from holding any lines. Add refuses
+// new lines while it is disabled, but the lines that were already
+// selected are kept until they are removed
+func (s *Selection) SetDisabled(b bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.disabled = b
+}
+
+// Disabled returns true if lines cannot be added. See SetDisabled
+func (s *Selection) Disabled() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.disabled
+}
+
 // Add adds a new line to the selection. If the line already
-// exists in the selection, it is silently ignored
-func (s *Selection) Add(l line.Line) {
+// exists in the selection, it is silently ignored. Add returns false
+// if the line could not be added because the selection is full or
+// disabled
+func (s *Selection) Add(l line.Line) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+
+	if s.tree.Has(l) {
+		return true
+	}
+	if s.disabled {
+		return false
+	}
+	if s.max > 0 && s.tree.Len() >= s.max {
+		if !s.evictOldest {
+			return false
+		}
+		s.removeOldest()
+	}
+	s.seq++
+	s.added[l.ID()] = s.seq
 	s.tree.ReplaceOrInsert(l)
+	return true
 }
 
+// removeOldest removes the line that was added first. The caller must
+// hold the lock
+func (s *Selection) removeOldest() {
+	var oldest line.Line
+	var oldestSeq uint64
+	s.tree.Ascend(func(it btree.Item) bool {
+		l := it.(line.Line)
+		if seq := s.added[l.ID()]; oldest == nil || seq < oldestSeq {
+			oldest, oldestSeq = l, seq
+		}
+		return true
+	})
+	if oldest == nil {
+		return
+	}
+	s.tree.Delete(oldest)
+	delete(s.added, oldest.ID())
+	delete(s.pinned, oldest.ID())
+	// The line may be displayed as selected
+	oldest.SetDirty(true)
+}
+
+// Copy adds the lines of s to dst. Pinned lines are pinned in dst too
 func (s *Selection) Copy(dst *Selection) {
+	var lines, pinned []line.Line
 	s.Ascend(func(it btree.Item) bool {
-		dst.Add(it.(line.Line))
+		l := it.(line.Line)
+		// Ascend holds the lock already
+		if _, ok := s.pinned[l.ID()]; ok {
+			pinned = append(pinned, l)
+		} else {
+			lines = append(lines, l)
+		}
 		return true
 	})
+	for _, l := range lines {
+		dst.Add(l)
+	}
+	for _, l := range pinned {
+		dst.Pin(l)
+	}
 }
 
-// Remove removes the specified line from the selection
+// Remove removes the specified line from the selection, even if it is
+// pinned
 func (s *Selection) Remove(l line.Line) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.tree.Delete(l)
+	delete(s.added, l.ID())
+	delete(s.pinned, l.ID())
 }
 
+// Reset removes all the lines from the selection, including the pinned
+// lines
 func (s *Selection) Reset() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.tree = btree.New(32)
+	s.added = make(map[uint64]uint64)
+	s.pinned = make(map[uint64]struct{})
+}
+
+// Pin adds the line to the selection like Add, and marks it so that
+// ResetUnpinned keeps it. Pin returns false if the line could not be
+// added
+func (s *Selection) Pin(l line.Line) bool {
+	if !s.Add(l) {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// The line may have been evicted in the meantime
+	if s.tree.Has(l) {
+		s.pinned[l.ID()] = struct{}{}
+	}
+	return true
+}
+
+// Unpin removes the mark set by Pin. The line stays selected, until
+// the selection is reset
+func (s *Selection) Unpin(l line.Line) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.pinned, l.ID())
+}
+
+// Pinned returns true if the line was pinned with Pin
+func (s *Selection) Pinned(l line.Line) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.pinned[l.ID()]
+	return ok
+}
+
+// PinnedLen returns the number of pinned lines
+func (s *Selection) PinnedLen() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.pinned)
+}
+
+// ResetUnpinned removes all the lines from the selection, except for
+// the pinned lines. This is what happens to the selection when the
+// query changes
+func (s *Selection) ResetUnpinned() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var lines []line.Line
+	s.tree.Ascend(func(it btree.Item) bool {
+		l := it.(line.Line)
+		if _, ok := s.pinned[l.ID()]; !ok {
+			lines = append(lines, l)
+		}
+		return true
+	})
+	for _, l := range lines {
+		s.tree.Delete(l)
+		delete(s.added, l.ID())
+	}
 }
 
 func (s *Selection) Has(x line.Line) bool {
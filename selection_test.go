@@ -1,9 +1,12 @@
 package peco
 
 import (
+	"context"
 	"testing"
 
+	"github.com/nsf/termbox-go"
 	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSelection(t *testing.T) {
@@ -30,3 +33,154 @@ func TestSelection(t *testing.T) {
 		t.Errorf("expected Len = 1, got %d", s.Len())
 	}
 }
+
+func TestSelectionLimit(t *testing.T) {
+	lines := make([]line.Line, 4)
+	for i := range lines {
+		lines[i] = line.NewRaw(uint64(i), "line", false)
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		s := NewSelection()
+		s.SetLimit(2, false)
+		if !assert.True(t, s.Add(lines[1]), "first line should be added") {
+			return
+		}
+		if !assert.True(t, s.Add(lines[0]), "second line should be added") {
+			return
+		}
+		if !assert.True(t, s.Add(lines[0]), "line that is selected already should be accepted") {
+			return
+		}
+		if !assert.False(t, s.Add(lines[2]), "third line should be refused") {
+			return
+		}
+		assert.False(t, s.Has(lines[2]), "refused line should not be selected")
+
+		s.Remove(lines[0])
+		assert.True(t, s.Add(lines[2]), "line should be added once there is room")
+	})
+
+	t.Run("evict oldest", func(t *testing.T) {
+		s := NewSelection()
+		s.SetLimit(2, true)
+		// Lines are evicted in the order they were added, not by ID
+		for _, i := range []int{2, 0, 3, 1} {
+			if !assert.True(t, s.Add(lines[i]), "line %d should be added", i) {
+				return
+			}
+		}
+		if !assert.Equal(t, 2, s.Len(), "selection should be full") {
+			return
+		}
+		assert.True(t, s.Has(lines[3]) && s.Has(lines[1]), "last lines should stay selected")
+	})
+}
+
+func TestMaxSelection(t *testing.T) {
+	state := newPeco()
+	state.config.MaxSelection = 3
+	if !assert.NoError(t, state.ApplyConfig(CLIOptions{}), "ApplyConfig should succeed") {
+		return
+	}
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 5; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), "line", false))
+	}
+	state.currentLineBuffer = buf
+	state.hub = nullHub{}
+
+	doSelectAll(context.Background(), state, termbox.Event{})
+	if !assert.Equal(t, 3, state.Selection().Len(), "only MaxSelection lines should be selected") {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		assert.True(t, state.Selection().Has(buf.lines[i]), "line %d should be selected", i)
+	}
+
+	state.Location().SetLineNumber(4)
+	doToggleSelection(context.Background(), state, termbox.Event{})
+	assert.False(t, state.Selection().Has(buf.lines[4]), "line should not be selected once the selection is full")
+
+	state.Selection().SetLimit(3, true)
+	doToggleSelection(context.Background(), state, termbox.Event{})
+	assert.True(t, state.Selection().Has(buf.lines[4]), "line should be selected by evicting the oldest one")
+	assert.False(t, state.Selection().Has(buf.lines[0]), "oldest line should be evicted")
+	assert.Equal(t, 3, state.Selection().Len(), "selection should stay full")
+}
+
+func TestToggleSelectionMode(t *testing.T) {
+	state := newPeco()
+	state.config.SingleSelection = true
+	if !assert.NoError(t, state.ApplyConfig(CLIOptions{}), "ApplyConfig should succeed") {
+		return
+	}
+
+	buf := NewMemoryBuffer()
+	for i := 0; i < 3; i++ {
+		buf.lines = append(buf.lines, line.NewRaw(uint64(i), "line", false))
+	}
+	state.currentLineBuffer = buf
+	state.hub = nullHub{}
+	ctx := context.Background()
+
+	if !assert.True(t, state.SingleSelection(), "SingleSelection should start in single selection mode") {
+		return
+	}
+	doSelectAll(ctx, state, termbox.Event{})
+	if !assert.Equal(t, 0, state.Selection().Len(), "lines should not be selected in single selection mode") {
+		return
+	}
+
+	doToggleSelectionMode(ctx, state, termbox.Event{})
+	if !assert.False(t, state.SingleSelection(), "mode should be toggled") {
+		return
+	}
+	doSelectAll(ctx, state, termbox.Event{})
+	if !assert.Equal(t, 3, state.Selection().Len(), "lines should be selected in multiple selection mode") {
+		return
+	}
+
+	state.Location().SetLineNumber(1)
+	doToggleSelectionMode(ctx, state, termbox.Event{})
+	if !assert.True(t, state.SingleSelection(), "mode should be toggled") {
+		return
+	}
+	assert.Equal(t, 0, state.Selection().Len(), "selection should be cleared")
+	assert.Equal(t, 1, state.Location().LineNumber(), "cursor should not move")
+
+	sel := selectionOrCurrentLine(state)
+	if assert.Equal(t, 1, sel.Len(), "result should be the current line") {
+		assert.True(t, sel.Has(buf.lines[1]), "result should be the current line")
+	}
+}
+
+func TestSelectionPin(t *testing.T) {
+	lines := make([]line.Line, 3)
+	for i := range lines {
+		lines[i] = line.NewRaw(uint64(i), "line", false)
+	}
+
+	s := NewSelection()
+	s.Add(lines[0])
+	if !assert.True(t, s.Pin(lines[1]), "Pin should succeed") {
+		return
+	}
+	s.Pin(lines[2])
+	s.Unpin(lines[2])
+	if !assert.Equal(t, 1, s.PinnedLen(), "only one line should be pinned") {
+		return
+	}
+
+	dst := NewSelection()
+	s.Copy(dst)
+	assert.True(t, dst.Pinned(lines[1]), "pinned lines should be pinned in the copy")
+
+	s.ResetUnpinned()
+	assert.Equal(t, 1, s.Len(), "only the pinned line should be left")
+	assert.True(t, s.Has(lines[1]), "pinned line should be kept")
+
+	s.Remove(lines[1])
+	assert.Equal(t, 0, s.PinnedLen(), "removed line should be unpinned")
+}
@@ -0,0 +1,52 @@
+package peco
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// SingleSelection returns true if peco is in single selection mode,
+// where lines cannot be selected and the line under the cursor is
+// the result
+func (p *Peco) SingleSelection() bool {
+	return p.Selection().Disabled()
+}
+
+// SetSingleSelection switches between single and multiple selection
+// mode. Switching to single selection mode deselects all the lines
+func (p *Peco) SetSingleSelection(b bool) {
+	p.Selection().SetDisabled(b)
+	if b {
+		p.Selection().Reset()
+		p.SelectionRangeStart().Reset()
+	}
+}
+
+// doToggleSelectionMode switches between single and multiple selection
+// mode. The lines that are selected when switching to single selection
+// mode are deselected, leaving the cursor where it is
+func doToggleSelectionMode(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleSelectionMode")
+		defer g.End()
+	}
+
+	if state.SingleSelection() {
+		state.SetSingleSelection(false)
+		state.Hub().SendStatusMsgAndClear("Multiple selection", time.Second)
+		return
+	}
+
+	n := state.Selection().Len()
+	state.SetSingleSelection(true)
+	if n > 0 {
+		state.Hub().SendStatusMsgAndClear(fmt.Sprintf("Single selection, deselected %d lines", n), 2*time.Second)
+		state.Hub().SendDraw(&DrawOptions{DisableCache: true})
+		return
+	}
+	state.Hub().SendStatusMsgAndClear("Single selection", time.Second)
+}
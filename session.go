@@ -0,0 +1,211 @@
+package peco
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// inputHash returns a digest of the lines of b, which tells if a
+// session was saved for the same input
+func inputHash(b Buffer) string {
+	h := sha256.New()
+	for i := 0; i < b.Size(); i++ {
+		l, err := b.LineAt(i)
+		if err != nil {
+			continue
+		}
+		h.Write([]byte(l.Buffer()))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readSessionFile reads the session stored in filename. A file that
+// does not exist gives a nil session
+func readSessionFile(filename string) (*session, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read session from %s", filename)
+	}
+
+	var s session
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse session in %s", filename)
+	}
+	return &s, nil
+}
+
+// writeSessionFile writes s to filename, creating the directory it is
+// in if needed
+func writeSessionFile(filename string, s *session) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize session")
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory for %s", filename)
+	}
+	if err := ioutil.WriteFile(filename, buf, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write session to %s", filename)
+	}
+	return nil
+}
+
+// saveSession writes the query, the position of the cursor and the
+// selected lines to SessionFile as peco exits. Nothing is saved if the
+// input has not been read completely, as it could not be recognized
+// next time. Failures are reported, but do not change the exit status
+// of peco
+func (p *Peco) saveSession() {
+	filename := p.config.SessionFile
+	if filename == "" {
+		return
+	}
+
+	select {
+	case <-p.source.SetupDone():
+	default:
+		return
+	}
+
+	s := session{
+		Input:  inputHash(p.source),
+		Query:  p.Query().String(),
+		Cursor: p.Location().LineNumber(),
+	}
+	sel := p.Selection()
+	for i := 0; i < p.source.Size(); i++ {
+		if l, err := p.source.LineAt(i); err == nil && sel.Has(l) {
+			s.Selected = append(s.Selected, i)
+		}
+	}
+
+	if err := writeSessionFile(filename, &s); err != nil {
+		fmt.Fprintf(p.Stderr, "%s\n", err)
+	}
+}
+
+// restoreSession restores the session saved in SessionFile once the
+// input has been read completely, if it was saved for the same input.
+// The session is not restored if a query was given on the command
+// line, or if the user has typed a query in the meantime. If the
+// session has a query, it is run first, and the cursor and the
+// selection are restored once it completes. See sessionFor
+func (p *Peco) restoreSession(ctx context.Context) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.restoreSession")
+		defer g.End()
+	}
+
+	s, err := readSessionFile(p.config.SessionFile)
+	if err != nil {
+		p.ShowMessage(err.Error(), 0, MessageError)
+		return
+	}
+	if s == nil || p.initialQuery != "" || len(p.MultiQuery()) > 0 {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-p.source.SetupDone():
+	}
+
+	if s.Input != inputHash(p.source) {
+		if pdebug.Enabled {
+			pdebug.Printf("session was saved for another input, starting afresh")
+		}
+		return
+	}
+	if p.Query().Len() > 0 {
+		return
+	}
+
+	// Without a query, the lines of the source are displayed as they
+	// are, unless they are processed by the filter first
+	if s.Query == "" && !p.processesSource() {
+		p.applySession(s)
+		p.Hub().SendDraw(&DrawOptions{DisableCache: true})
+		return
+	}
+
+	p.mutex.Lock()
+	p.pendingSession = s
+	p.mutex.Unlock()
+
+	p.Query().Set(s.Query)
+	p.Caret().SetPos(utf8.RuneCountInString(s.Query))
+	p.Hub().SendDrawPrompt()
+	p.ExecQuery()
+}
+
+// sessionFor returns the session that is being restored, if query is
+// the query that it saved. The session is forgotten once another query
+// is run
+func (p *Peco) sessionFor(query string) *session {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s := p.pendingSession
+	if s != nil && s.Query != query {
+		p.pendingSession = nil
+		return nil
+	}
+	return s
+}
+
+// applySession selects the lines of s, and moves the cursor to where it
+// was. The lines are taken from the current results when they are
+// there, so that they are output the same way
+func (p *Peco) applySession(s *session) {
+	p.mutex.Lock()
+	p.pendingSession = nil
+	p.mutex.Unlock()
+
+	selected := make(map[uint64]line.Line)
+	for _, i := range s.Selected {
+		if l, err := p.source.LineAt(i); err == nil {
+			selected[l.ID()] = l
+		}
+	}
+
+	sel := p.Selection()
+	b := p.CurrentLineBuffer()
+	for i := 0; i < b.Size() && len(selected) > 0; i++ {
+		if l, err := b.LineAt(i); err == nil && selected[l.ID()] != nil {
+			sel.Add(l)
+			delete(selected, l.ID())
+		}
+	}
+	// Lines that are not in the results are still selected with
+	// StickySelection
+	for _, l := range selected {
+		sel.Add(l)
+	}
+
+	if n := b.Size(); n > 0 {
+		cursor := s.Cursor
+		if cursor >= n {
+			cursor = n - 1
+		}
+		if cursor < 0 {
+			cursor = 0
+		}
+		p.Location().SetLineNumber(cursor)
+	}
+}
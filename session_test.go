@@ -0,0 +1,106 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-session")
+	if !assert.NoError(t, err, "ioutil.TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "state", "session.json")
+	run := func(t *testing.T, input string, interact func(context.Context, *Peco) bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.Stdin = bytes.NewBufferString(input)
+		p.Stdout = &bytes.Buffer{}
+		p.config.SessionFile = filename
+		go func() {
+			<-p.Ready()
+			<-p.source.SetupDone()
+			if interact(ctx, p) {
+				doCancel(ctx, p, termbox.Event{})
+			}
+		}()
+
+		p.Run(ctx)
+		assert.NoError(t, ctx.Err(), "peco should exit before the timeout")
+	}
+
+	waitFor := func(ctx context.Context, cond func() bool) bool {
+		for !cond() {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	// Nothing to restore yet
+	run(t, "foo\nbar\nfoobar\n", func(ctx context.Context, p *Peco) bool {
+		p.Query().Set("foo")
+		p.ExecQuery()
+		if !assert.True(t, waitFor(ctx, func() bool {
+			return assert.ObjectsAreEqual([]string{"foo", "foobar"}, bufferLines(p.CurrentLineBuffer()))
+		}), "query should be run") {
+			return true
+		}
+		// Let the query reset the selection before selecting
+		time.Sleep(100 * time.Millisecond)
+		p.Location().SetLineNumber(1)
+		doToggleSelection(ctx, p, termbox.Event{})
+		return true
+	})
+	if _, err := os.Stat(filename); !assert.NoError(t, err, "session should be saved") {
+		return
+	}
+
+	// The same input restores the session
+	run(t, "foo\nbar\nfoobar\n", func(ctx context.Context, p *Peco) bool {
+		restored := waitFor(ctx, func() bool {
+			return p.Selection().Len() == 1 && p.Location().LineNumber() == 1
+		})
+		if !assert.True(t, restored, "cursor and selection should be restored") {
+			return true
+		}
+		assert.Equal(t, "foo", p.Query().String(), "query should be restored")
+		assert.Equal(t, []string{"foo", "foobar"}, bufferLines(p.CurrentLineBuffer()), "query should be run")
+		l, err := p.CurrentLineBuffer().LineAt(1)
+		if assert.NoError(t, err, "LineAt should succeed") {
+			assert.True(t, p.Selection().Has(l), "selected line should be restored")
+		}
+		return true
+	})
+
+	// Another input starts afresh
+	run(t, "foo\nbaz\n", func(ctx context.Context, p *Peco) bool {
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, "", p.Query().String(), "query should not be restored")
+		assert.Equal(t, 0, p.Selection().Len(), "selection should not be restored")
+		return true
+	})
+
+	// The session of the last run was saved, so the first input no
+	// longer matches
+	s, err := readSessionFile(filename)
+	if assert.NoError(t, err, "readSessionFile should succeed") && assert.NotNil(t, s, "session should be saved") {
+		assert.Equal(t, "", s.Query, "last query should be saved")
+	}
+}
@@ -0,0 +1,82 @@
+package peco
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+)
+
+// handleSignal handles the signals received by Input: the terminal is
+// redrawn once it is resized, and the input is read again on SIGHUP
+func (p *Peco) handleSignal(ctx context.Context, sig os.Signal) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.handleSignal %s", sig)
+		defer g.End()
+	}
+
+	switch {
+	case isResizeSignal(sig):
+		p.resize()
+	case sig == syscall.SIGHUP:
+		p.reloadSource(ctx)
+	}
+}
+
+// resize redraws the screen for the new size of the terminal. The
+// cursor stays on its line if it can, but the list is no longer
+// scrolled, so that the page is worked out again from the cursor
+func (p *Peco) resize() {
+	// termbox only picks up the new size once the screen is flushed,
+	// and the layout is drawn for whatever size it knows about
+	p.screen.Flush()
+
+	loc := p.Location()
+	loc.SetScrolled(false)
+	if n := p.CurrentLineBuffer().Size(); n > 0 && loc.LineNumber() >= n {
+		loc.SetLineNumber(n - 1)
+	}
+	p.Hub().SendDraw(&DrawOptions{DisableCache: true})
+}
+
+// canReload returns true if the input can be read again, which is the
+// case for the files given on the command line or matched by --glob.
+// What was read from stdin or --source is gone
+func (p *Peco) canReload() bool {
+	return p.sourceAddr == "" && (p.globPattern != "" || len(p.args) > 1)
+}
+
+// reloadSource replaces the input with the lines read again from the
+// same files, and runs the current query against them. The old input
+// is no longer read, and the selection is dropped, as it refers to the
+// old lines. The action palette is closed if it is shown
+func (p *Peco) reloadSource(ctx context.Context) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Peco.reloadSource")
+		defer g.End()
+	}
+
+	if !p.canReload() {
+		p.ShowMessage("Cannot reload the input: it is not read from files", 5*time.Second, MessageError)
+		return
+	}
+
+	srcCtx, cancel := context.WithCancel(ctx)
+	src, err := p.SetupSource(srcCtx)
+	if err != nil {
+		cancel()
+		p.ShowMessage("Failed to reload the input: "+err.Error(), 5*time.Second, MessageError)
+		return
+	}
+
+	if !p.swapSource(ctx, src, cancel) {
+		cancel()
+		return
+	}
+	go p.showProgress(ctx, src)
+	if p.queriesSource() {
+		p.ExecQuery()
+	}
+}
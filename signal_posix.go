@@ -0,0 +1,23 @@
+// +build !windows
+
+package peco
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals relays the signals that Input handles to ch: SIGWINCH,
+// and SIGHUP if ReloadOnSIGHUP is enabled
+func (p *Peco) notifySignals(ch chan os.Signal) {
+	sigs := []os.Signal{syscall.SIGWINCH}
+	if p.reloadOnSIGHUP {
+		sigs = append(sigs, syscall.SIGHUP)
+	}
+	signal.Notify(ch, sigs...)
+}
+
+func isResizeSignal(sig os.Signal) bool {
+	return sig == syscall.SIGWINCH
+}
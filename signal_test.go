@@ -0,0 +1,133 @@
+package peco
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-reload")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "input")
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte("foo\nbar\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	p := newPeco()
+	p.Argv = []string{"peco", filename}
+	p.config.ReloadOnSIGHUP = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	p.Query().Set("ba")
+	p.ExecQuery()
+
+	waitLines := func(expected []string, msg string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				return assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), msg)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+	if !waitLines([]string{"bar"}, "query should be run against the input") {
+		return
+	}
+
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte("bar\nbaz\nqux\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+	p.handleSignal(ctx, syscall.SIGHUP)
+	waitLines([]string{"bar", "baz"}, "query should be run against the reloaded input")
+}
+
+func TestReloadSourceMmap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files are not mapped into memory on Windows")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-reload")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "input")
+	if !assert.NoError(t, ioutil.WriteFile(filename, []byte("foo\nbar\n"), 0644), "WriteFile should succeed") {
+		return
+	}
+
+	p := newPeco()
+	p.Argv = []string{"peco", filename}
+	p.config.ReloadOnSIGHUP = true
+	p.config.MmapFiles = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	old := p.Source().(*Source)
+	mapped := func(s *Source) int {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		return len(s.mapped)
+	}
+	if !assert.Equal(t, 1, mapped(old), "input should be mapped") {
+		return
+	}
+
+	// The mapping of the old input is released once the view no
+	// longer displays its lines
+	p.handleSignal(ctx, syscall.SIGHUP)
+	if !assert.True(t, p.Source() != old, "input should be replaced") {
+		return
+	}
+	for mapped(old) > 0 {
+		p.Hub().SendDraw(nil)
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached while waiting for the old input to be released")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	<-p.Source().(*Source).SetupDone()
+	assert.Equal(t, []string{"foo", "bar"}, bufferLines(p.CurrentLineBuffer()), "reloaded input should be displayed")
+}
+
+func TestCanReload(t *testing.T) {
+	p := newPeco()
+	p.args = []string{"peco"}
+	assert.False(t, p.canReload(), "stdin should not be reloadable")
+
+	p.args = []string{"peco", "foo", "bar"}
+	assert.True(t, p.canReload(), "files should be reloadable")
+
+	p.sourceAddr = "localhost:0"
+	assert.False(t, p.canReload(), "socket should not be reloadable")
+
+	p.args = []string{"peco"}
+	p.sourceAddr = ""
+	p.globPattern = "**/*.go"
+	assert.True(t, p.canReload(), "glob should be reloadable")
+}
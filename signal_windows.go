@@ -0,0 +1,19 @@
+package peco
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals relays the signals that Input handles to ch. There is
+// no SIGWINCH on Windows, where termbox reports the resize by itself
+func (p *Peco) notifySignals(ch chan os.Signal) {
+	if p.reloadOnSIGHUP {
+		signal.Notify(ch, syscall.SIGHUP)
+	}
+}
+
+func isResizeSignal(_ os.Signal) bool {
+	return false
+}
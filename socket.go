@@ -0,0 +1,95 @@
+package peco
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/line"
+	"github.com/pkg/errors"
+)
+
+// listenSource creates the listener for the address given to --source,
+// which is either unix:///path/to/socket or tcp://host:port
+func listenSource(addr string) (net.Listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid source %s", addr)
+	}
+
+	var network, address string
+	switch u.Scheme {
+	case "unix":
+		// unix://foo.sock is a relative path
+		network, address = "unix", u.Host+u.Path
+	case "tcp":
+		network, address = "tcp", u.Host
+	default:
+		return nil, errors.Errorf("invalid source %s: expected unix:// or tcp://", addr)
+	}
+	if address == "" {
+		return nil, errors.Errorf("invalid source %s: missing address", addr)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", addr)
+	}
+	return l, nil
+}
+
+// NewSocketSource creates a new Source that listens on addr, and reads
+// the lines sent through the first connection made to it, until the
+// connection is closed. Errors accepting or reading the connection are
+// available from Errors() once Setup() is done, and are reported to the
+// pipeline that is streaming the input at the time
+func NewSocketSource(addr string, idgen line.IDGenerator, capacity int, enableSep bool) (*Source, error) {
+	l, err := listenSource(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewSource(addr, nil, idgen, capacity, enableSep)
+	s.listener = l
+	return s, nil
+}
+
+// acceptOne waits for a single connection to l, and closes l once it is
+// made. The connection is closed when ctx is canceled, so that reading
+// from it does not block forever
+func acceptOne(ctx context.Context, l net.Listener) (net.Conn, error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("acceptOne %s", l.Addr())
+		defer g.End()
+	}
+
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan accepted, 1)
+	go func() {
+		conn, err := l.Accept()
+		ch <- accepted{conn: conn, err: err}
+	}()
+
+	var a accepted
+	select {
+	case <-ctx.Done():
+		l.Close()
+		return nil, ctx.Err()
+	case a = <-ch:
+	}
+	l.Close()
+
+	if a.err != nil {
+		return nil, errors.Wrap(a.err, "failed to accept connection")
+	}
+
+	go func() {
+		<-ctx.Done()
+		a.conn.Close()
+	}()
+	return a.conn, nil
+}
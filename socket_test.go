@@ -0,0 +1,91 @@
+package peco
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenSource(t *testing.T) {
+	for _, addr := range []string{"foo", "udp://localhost:0", "unix://", "tcp://"} {
+		_, err := listenSource(addr)
+		assert.Error(t, err, "%s should not be accepted", addr)
+	}
+
+	l, err := listenSource("tcp://127.0.0.1:0")
+	if !assert.NoError(t, err, "listenSource should succeed") {
+		return
+	}
+	defer l.Close()
+	assert.Equal(t, "tcp", l.Addr().Network(), "network should match")
+}
+
+func TestSocketSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-socket")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "peco.sock")
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--source", "unix://" + sock}
+	go p.Run(ctx)
+
+	var conn net.Conn
+	for {
+		if conn, err = net.Dial("unix", sock); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached while waiting for the socket")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	conn.Write([]byte("foo\nbar\n"))
+	conn.Close()
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+	if !assert.Equal(t, []string{"foo", "bar"}, bufferLines(p.source), "lines should be read from the connection") {
+		return
+	}
+	assert.Empty(t, p.source.Errors(), "there should be no errors")
+	assert.Equal(t, "unix://"+sock, p.source.Name(), "name should be the address")
+
+	// Only one connection is accepted
+	_, err = net.Dial("unix", sock)
+	assert.Error(t, err, "socket should be closed")
+}
+
+func TestSocketSourceCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := NewSocketSource("tcp://127.0.0.1:0", newIDGen(), 0, false)
+	if !assert.NoError(t, err, "NewSocketSource should succeed") {
+		return
+	}
+	var lines int
+	done := make(chan error)
+	go func() { done <- s.scanConn(ctx, nil, nil, &lines) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err, "waiting for the connection should be canceled")
+	case <-time.After(5 * time.Second):
+		t.Errorf("timeout reached while waiting for scanConn")
+	}
+}
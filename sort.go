@@ -0,0 +1,148 @@
+package peco
+
+import (
+	"context"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+)
+
+// sortModes lists the sort modes in the order that peco.ToggleSort
+// cycles through them. Those that need more configuration are skipped
+// unless it is given, see sortModeAvailable
+var sortModes = []string{SortNone, SortLength, SortAlpha, SortWeighted, SortFrecency}
+
+// IsValidSortMode checks if a string is a supported sort mode. The
+// empty string selects the default mode
+func IsValidSortMode(v string) bool {
+	if v == "" {
+		return true
+	}
+	for _, mode := range sortModes {
+		if v == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// isSorted returns true if mode requires the lines to be sorted
+func isSorted(mode string) bool {
+	return mode != "" && mode != SortNone
+}
+
+// sortLines sorts lines in place according to mode. The sort is
+// stable, so lines that compare equal stay in the order they were
+// matched, which for ranked filters is the order of their scores.
+// Lines with the same weight are kept in the order they were read
+func sortLines(lines []line.Line, mode string) {
+	if mode == SortWeighted {
+		sort.SliceStable(lines, func(i, j int) bool {
+			a, b := line.Weight(lines[i]), line.Weight(lines[j])
+			if a != b {
+				return a > b
+			}
+			return lines[i].ID() < lines[j].ID()
+		})
+		return
+	}
+
+	var less func(a, b string) bool
+	switch mode {
+	case SortLength:
+		less = func(a, b string) bool {
+			return utf8.RuneCountInString(a) < utf8.RuneCountInString(b)
+		}
+	case SortAlpha:
+		less = func(a, b string) bool {
+			return a < b
+		}
+	default:
+		return
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return less(lines[i].DisplayString(), lines[j].DisplayString())
+	})
+}
+
+// sortByID sorts lines in place in the order they were read
+func sortByID(lines []line.Line) {
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].ID() < lines[j].ID()
+	})
+}
+
+// sortLines sorts lines in place according to the sort mode of the
+// buffer. With StableOrder, the lines are first put back in the order
+// they were read, so that the sort mode only breaks the ties in that
+// order
+func (mb *MemoryBuffer) sortLines(lines []line.Line) {
+	if mb.stableOrder {
+		sortByID(lines)
+	}
+	if mb.sortMode == SortFrecency {
+		sortByFrecency(lines, mb.frecency)
+		return
+	}
+	sortLines(lines, mb.sortMode)
+}
+
+// SortMode returns the order in which the matched lines are displayed
+func (p *Peco) SortMode() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sortMode
+}
+
+// SetSortMode changes the order in which the matched lines are
+// displayed. The current query must be executed again for the change
+// to take effect
+func (p *Peco) SetSortMode(mode string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sortMode = mode
+}
+
+// sortModeAvailable returns false for the sort modes that have nothing
+// to sort the lines by: SortWeighted without WeightColumn, and
+// SortFrecency without FrecencyFile
+func (p *Peco) sortModeAvailable(mode string) bool {
+	switch mode {
+	case SortWeighted:
+		return p.config.WeightColumn.Delimiter != ""
+	case SortFrecency:
+		return p.frecency != nil
+	}
+	return true
+}
+
+// doToggleSort cycles through the sort modes, and runs the current
+// query again so that the lines are displayed in the new order
+func doToggleSort(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleSort")
+		defer g.End()
+	}
+
+	var modes []string
+	for _, mode := range sortModes {
+		if state.sortModeAvailable(mode) {
+			modes = append(modes, mode)
+		}
+	}
+	mode := modes[0]
+	for i, v := range modes {
+		if v == state.SortMode() {
+			mode = modes[(i+1)%len(modes)]
+			break
+		}
+	}
+	state.SetSortMode(mode)
+	state.Hub().SendStatusMsgAndClear("Sort: "+mode, time.Second)
+	state.ExecQuery()
+}
@@ -0,0 +1,248 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func bufferLines(b Buffer) []string {
+	var lines []string
+	for i := 0; i < b.Size(); i++ {
+		l, err := b.LineAt(i)
+		if err != nil {
+			break
+		}
+		lines = append(lines, l.DisplayString())
+	}
+	return lines
+}
+
+func TestMemoryBufferSort(t *testing.T) {
+	testValues := []struct {
+		mode     string
+		expected []string
+	}{
+		{SortNone, []string{"ccc", "b", "aa", "d"}},
+		{SortLength, []string{"b", "d", "aa", "ccc"}},
+		{SortAlpha, []string{"aa", "b", "ccc", "d"}},
+	}
+
+	for _, v := range testValues {
+		t.Run(v.mode, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mb := NewMemoryBuffer()
+			mb.sortMode = v.mode
+			in := make(chan interface{})
+			go mb.Accept(ctx, in, nil)
+			for i, s := range []string{"ccc", "b", "aa", "d"} {
+				in <- line.NewRaw(uint64(i), s, false)
+				if isSorted(v.mode) {
+					if !assert.Equal(t, 0, mb.Size(), "sorted lines should not be available before the end mark") {
+						return
+					}
+				}
+			}
+			in <- pipeline.EndMark{}
+			<-mb.Done()
+
+			assert.Equal(t, v.expected, bufferLines(mb), "lines should be sorted")
+		})
+	}
+}
+
+func TestMemoryBufferRanked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+	in <- pipeline.Ranked{Value: line.NewRaw(0, "foo", false), Score: 10, Spans: [][2]int{{0, 1}}}
+	in <- line.NewRaw(1, "bar", false)
+	in <- pipeline.EndMark{}
+	<-mb.Done()
+
+	if !assert.Equal(t, []string{"foo", "bar"}, bufferLines(mb), "ranked and bare lines should be kept") {
+		return
+	}
+	l, err := mb.LineAt(0)
+	if !assert.NoError(t, err, "LineAt(0) should succeed") {
+		return
+	}
+	if sl, ok := l.(*line.Scored); assert.True(t, ok, "ranked line should keep its score") {
+		assert.Equal(t, 10, sl.Score(), "score should match")
+		assert.Equal(t, [][]int{{0, 1}}, sl.Indices(), "matches should match")
+	}
+}
+
+func TestMemoryBufferRankedOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	mb.capacity = 3
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+	for i, score := range []int{10, 30, 20, 30, 5, 40} {
+		in <- pipeline.Ranked{Value: line.NewRaw(uint64(i), strconv.Itoa(i), false), Score: score}
+	}
+	in <- pipeline.EndMark{}
+	<-mb.Done()
+
+	assert.Equal(t, []string{"5", "1", "3"}, bufferLines(mb), "best lines should be kept, by descending score then in the order they were read")
+}
+
+func TestMemoryBufferYield(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	mb.sortMode = SortAlpha
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+	in <- line.NewRaw(0, "ccc", false)
+	in <- line.NewRaw(1, "b", false)
+
+	// The lines received so far are sorted and made available
+	mb.Yield()
+	for !assert.ObjectsAreEqual([]string{"b", "ccc"}, bufferLines(mb)) {
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached while waiting for the lines to be yielded")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !assert.True(t, mb.Partial(), "lines should be partial") {
+		return
+	}
+
+	in <- line.NewRaw(2, "aa", false)
+	in <- pipeline.EndMark{}
+	<-mb.Done()
+
+	if !assert.Equal(t, []string{"aa", "b", "ccc"}, bufferLines(mb), "all the lines should be sorted") {
+		return
+	}
+	assert.False(t, mb.Partial(), "lines should be complete")
+}
+
+func TestMemoryBufferStableOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	mb.stableOrder = true
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+	in <- pipeline.Ranked{Value: line.NewRaw(3, "foo", false), Score: 30}
+	in <- pipeline.Ranked{Value: line.NewRaw(1, "f_oo", false), Score: 20}
+	in <- pipeline.Ranked{Value: line.NewRaw(2, "f_o_o", false), Score: 10}
+
+	// Nothing is displayed until the lines are yielded or complete
+	if !assert.Equal(t, 0, mb.Size(), "lines should be kept aside") {
+		return
+	}
+	mb.Yield()
+	for !assert.ObjectsAreEqual([]string{"f_oo", "f_o_o", "foo"}, bufferLines(mb)) {
+		select {
+		case <-ctx.Done():
+			t.Errorf("timeout reached while waiting for the lines to be yielded")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	in <- pipeline.Ranked{Value: line.NewRaw(0, "fooo", false), Score: 30}
+	in <- pipeline.EndMark{}
+	<-mb.Done()
+
+	if !assert.Equal(t, []string{"fooo", "f_oo", "f_o_o", "foo"}, bufferLines(mb), "lines should be in the order they were read") {
+		return
+	}
+	assert.False(t, mb.Partial(), "lines should be complete")
+
+	// Ties of the sort mode are broken in the order the lines were read
+	mb = NewMemoryBuffer()
+	mb.stableOrder = true
+	mb.sortMode = SortLength
+	in = make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+	in <- pipeline.Ranked{Value: line.NewRaw(2, "baz", false), Score: 30}
+	in <- pipeline.Ranked{Value: line.NewRaw(0, "quux", false), Score: 20}
+	in <- pipeline.Ranked{Value: line.NewRaw(1, "bar", false), Score: 10}
+	in <- pipeline.EndMark{}
+	<-mb.Done()
+
+	assert.Equal(t, []string{"bar", "baz", "quux"}, bufferLines(mb), "lines should be sorted, then in the order they were read")
+}
+
+func TestToggleSort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = bytes.NewBufferString("ccc\nb\naa\n")
+	p.config.Sort = SortLength
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitForLines := func(expected []string) bool {
+		for {
+			if b := p.CurrentLineBuffer(); b.Size() == len(expected) {
+				if lines := bufferLines(b); assert.ObjectsAreEqual(expected, lines) {
+					return true
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should be sorted")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	if !waitForLines([]string{"b", "aa", "ccc"}) {
+		return
+	}
+
+	l, err := p.CurrentLineBuffer().LineAt(0)
+	if !assert.NoError(t, err, "LineAt(0) should succeed") {
+		return
+	}
+	p.Selection().Add(l)
+
+	doToggleSort(ctx, p, termbox.Event{})
+	if !assert.Equal(t, SortAlpha, p.SortMode(), "sort mode should be rotated") {
+		return
+	}
+	if !waitForLines([]string{"aa", "b", "ccc"}) {
+		return
+	}
+	sl, err := p.CurrentLineBuffer().LineAt(1)
+	if !assert.NoError(t, err, "LineAt(1) should succeed") {
+		return
+	}
+	if !assert.True(t, p.Selection().Has(sl), "selection should follow the line") {
+		return
+	}
+
+	doToggleSort(ctx, p, termbox.Event{})
+	if !assert.Equal(t, SortNone, p.SortMode(), "sort mode should be rotated") {
+		return
+	}
+	waitForLines([]string{"ccc", "b", "aa"})
+}
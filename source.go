@@ -2,7 +2,11 @@ package peco
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"io"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,17 +16,45 @@ import (
 	"github.com/peco/peco/internal/util"
 	"github.com/peco/peco/line"
 	"github.com/peco/peco/pipeline"
+	"github.com/pkg/errors"
 )
 
+// scanNullTerminated is a bufio.SplitFunc that splits the input into
+// NUL terminated records. The last record is returned even if it is
+// not terminated
+func scanNullTerminated(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	// Request more data
+	return 0, nil, nil
+}
+
+// IsValidMatchColumnOutput checks if the column output for MatchColumn
+// is supported. The empty string selects the default column
+func IsValidMatchColumnOutput(v string) bool {
+	return v == "" || v == MatchColumnOutputDisplay || v == MatchColumnOutputMatch
+}
+
 // Creates a new Source. Does not start processing the input until you
 // call Setup()
 func NewSource(name string, in io.Reader, idgen line.IDGenerator, capacity int, enableSep bool) *Source {
 	s := &Source{
-		name: name,
+		name:       name,
 		in:         in, // Note that this may be closed, so do not rely on it
 		capacity:   capacity,
 		enableSep:  enableSep,
 		idgen:      idgen,
+		progress:   make(chan struct{}, 1),
 		ready:      make(chan struct{}),
 		setupDone:  make(chan struct{}),
 		ChanOutput: pipeline.ChanOutput(make(chan interface{})),
@@ -31,6 +63,30 @@ func NewSource(name string, in io.Reader, idgen line.IDGenerator, capacity int,
 	return s
 }
 
+// NewFileSource creates a new Source that reads the given files in
+// order, as if they were concatenated. Files that cannot be read are
+// skipped, and the errors are available from Errors() once Setup()
+// has read all of the files
+func NewFileSource(filenames []string, idgen line.IDGenerator, capacity int, enableSep bool) *Source {
+	s := NewSource(filenames[0], nil, idgen, capacity, enableSep)
+	s.files = filenames
+	return s
+}
+
+// newStaticSource creates a Source that holds the given lines from the
+// start, without anything to read
+func newStaticSource(name string, lines []string, idgen line.IDGenerator) *Source {
+	s := NewSource(name, nil, idgen, 0, false)
+	for _, v := range lines {
+		s.Append(line.NewRaw(idgen.Next(), v, false))
+	}
+	s.setupOnce.Do(func() {
+		close(s.ready)
+		close(s.setupDone)
+	})
+	return s
+}
+
 func (s *Source) Name() string {
 	return s.name
 }
@@ -86,18 +142,86 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 			pdebug.Printf("Source: using buffer size of %dkb", state.maxScanBufferSize)
 		}
 		scanbuf := make([]byte, state.maxScanBufferSize*1024)
-		scanner := bufio.NewScanner(s.in)
-		scanner.Buffer(scanbuf, state.maxScanBufferSize*1024)
-		defer func() {
-			if util.IsTty(s.in) {
-				return
+		newScanner := func(in io.Reader) *bufio.Scanner {
+			scanner := bufio.NewScanner(in)
+			scanner.Buffer(scanbuf, state.maxScanBufferSize*1024)
+			switch {
+			case state.readNull:
+				scanner.Split(scanNullTerminated)
+			case state.recordSeparator != "":
+				scanner.Split(scanRecords(state.recordSeparator))
 			}
-			if closer, ok := s.in.(io.Closer); ok {
-				closer.Close()
+			return scanner
+		}
+		// With MmapFiles, regular files are mapped into memory instead
+		// of being read through a scanner, unless they are split into
+		// records, which only the scanner does
+		var mapFile func(f *os.File, file int, lines chan sourceLine, scanned *int) bool
+		if state.config.MmapFiles && state.recordSeparator == "" {
+			delim := byte('\n')
+			if state.readNull {
+				delim = 0
 			}
-		}()
+			mapFile = func(f *os.File, file int, lines chan sourceLine, scanned *int) bool {
+				return s.mapRecords(ctx, f, file, delim, lines, scanned)
+			}
+		}
+		if s.in != nil {
+			defer func() {
+				if util.IsTty(s.in) {
+					return
+				}
+				if closer, ok := s.in.(io.Closer); ok {
+					closer.Close()
+				}
+			}()
+		}
+
+		// With a WeightColumn, the weight is removed from each line
+		// before anything else. Malformed weights are 0, and are only
+		// reported once
+		var badWeight string
+		var weightWarning sync.Once
+		newRaw := func(text string) *line.Raw {
+			return line.NewRaw(s.idgen.Next(), text, s.enableSep)
+		}
+		if delim := state.config.WeightColumn.Delimiter; delim != "" {
+			newRaw = func(text string) *line.Raw {
+				rl, ok := line.NewWeighted(s.idgen.Next(), text, s.enableSep, delim)
+				if !ok && badWeight == "" {
+					badWeight = text
+				}
+				return rl
+			}
+		}
+
+		// With a MatchColumn, queries are matched against a part of
+		// each line that is not displayed
+		newLine := func(text string) line.Line {
+			return newRaw(text)
+		}
+		if mc := state.config.MatchColumn; mc.Delimiter != "" {
+			outputMatch := mc.Output == MatchColumnOutputMatch
+			newLine = func(text string) line.Line {
+				return line.NewColumn(newRaw(text), mc.Delimiter, outputMatch)
+			}
+		}
 
-		lines := make(chan string)
+		// With a RecordSeparator, each record is displayed as a summary
+		if state.recordSeparator != "" {
+			newLine = func(text string) line.Line {
+				return state.newRecord(newRaw(text))
+			}
+		}
+
+		lines := make(chan sourceLine)
+		var ingest <-chan sourceLine = lines
+		if node := state.ingestSplitter(); node != nil {
+			ingest = ingestLines(ctx, node, ingest)
+		}
+		if node := state.ingestLimiter(); node != nil {
+			ingest = ingestLines(ctx, node, ingest)
+		}
 		go func() {
 			var scanned int
 			if pdebug.Enabled {
@@ -105,15 +229,58 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 			}
 
 			defer close(lines)
-			for scanner.Scan() {
-				lines <- scanner.Text()
-				scanned++
+			if s.listener != nil {
+				if err := s.scanConn(ctx, newScanner, lines, &scanned); err != nil && ctx.Err() == nil {
+					if pdebug.Enabled {
+						pdebug.Printf("%s", err)
+					}
+					s.addError(err)
+					state.ShowMessage(err.Error(), 0, MessageError)
+				}
+				return
+			}
+
+			if s.glob != nil {
+				onError := func(err error) {
+					if pdebug.Enabled {
+						pdebug.Printf("%s", err)
+					}
+					s.addError(err)
+				}
+				if err := s.glob.walk(ctx, lines, &scanned, onError); err != nil {
+					onError(err)
+					state.ShowMessage(err.Error(), 0, MessageError)
+				}
+				return
+			}
+
+			if len(s.files) == 0 {
+				if f, ok := s.in.(*os.File); ok && mapFile != nil && mapFile(f, 0, lines, &scanned) {
+					return
+				}
+				scanner := newScanner(s.in)
+				for scanner.Scan() {
+					lines <- sourceLine{text: scanner.Text()}
+					scanned++
+				}
+				return
+			}
+
+			for i, filename := range s.files {
+				if err := s.scanFile(ctx, newScanner, mapFile, i, filename, lines, &scanned); err != nil {
+					if pdebug.Enabled {
+						pdebug.Printf("%s", err)
+					}
+					s.addError(err)
+					state.ShowMessage(err.Error(), 0, MessageError)
+				}
 			}
 		}()
 
 		state.Hub().SendStatusMsg("Waiting for input...")
 
 		readCount := 0
+		prevFile := 0
 		for loop := true; loop; {
 			select {
 			case <-ctx.Done():
@@ -121,7 +288,7 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 					pdebug.Printf("Bailing out of source setup, because ctx was canceled")
 				}
 				return
-			case l, ok := <-lines:
+			case l, ok := <-ingest:
 				if !ok {
 					if pdebug.Enabled {
 						pdebug.Printf("No more lines to read...")
@@ -130,9 +297,33 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 					break
 				}
 
+				// The header lines are displayed as they are, and are
+				// not part of the lines that are matched
+				if len(s.Headers()) < state.config.HeaderLines {
+					s.addHeader(newLine(l.text))
+					s.addProgress(len(l.text) + 1)
+					continue
+				}
+
 				readCount++
-				s.Append(line.NewRaw(s.idgen.Next(), l, s.enableSep))
+				if s.files != nil && (readCount == 1 || l.file != prevFile) {
+					s.addOrigin(l.file)
+					prevFile = l.file
+				}
+				s.Append(newLine(l.text))
+				s.addProgress(len(l.text) + 1)
 				notify.Do(notifycb)
+
+				// The warning comes after the first line clears the
+				// status message
+				if badWeight != "" {
+					weightWarning.Do(func() {
+						if pdebug.Enabled {
+							pdebug.Printf("Source: malformed weight in %q", badWeight)
+						}
+						state.ShowMessage(fmt.Sprintf("Malformed weights are treated as 0: %q", badWeight), 2*time.Second, MessageWarning)
+					})
+				}
 			}
 		}
 
@@ -142,6 +333,113 @@ func (s *Source) Setup(ctx context.Context, state *Peco) {
 	})
 }
 
+// scanFile sends the lines of the i-th file to lines. The number of
+// lines sent is added to scanned. If mapFile is not nil, the file
+// is mapped into memory with it if possible
+func (s *Source) scanFile(ctx context.Context, newScanner func(io.Reader) *bufio.Scanner, mapFile func(*os.File, int, chan sourceLine, *int) bool, i int, filename string, lines chan sourceLine, scanned *int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open file %s", filename)
+	}
+	defer f.Close()
+
+	if pdebug.Enabled {
+		pdebug.Printf("Source: reading %s", filename)
+	}
+
+	if mapFile != nil && mapFile(f, i, lines, scanned) {
+		return nil
+	}
+
+	scanner := newScanner(f)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case lines <- sourceLine{text: scanner.Text(), file: i}:
+		}
+		*scanned++
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read file %s", filename)
+	}
+	return nil
+}
+
+// scanConn sends the lines read from the first connection to the
+// socket of the source to lines, until the connection is closed. The
+// number of lines sent is added to scanned
+func (s *Source) scanConn(ctx context.Context, newScanner func(io.Reader) *bufio.Scanner, lines chan sourceLine, scanned *int) error {
+	conn, err := acceptOne(ctx, s.listener)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if pdebug.Enabled {
+		pdebug.Printf("Source: reading from %s", conn.RemoteAddr())
+	}
+
+	scanner := newScanner(conn)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case lines <- sourceLine{text: scanner.Text()}:
+		}
+		*scanned++
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read from socket")
+	}
+	return nil
+}
+
+func (s *Source) addError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+// Errors returns the errors that occurred while reading the files of
+// a Source created by NewFileSource, the connection of a Source
+// created by NewSocketSource, or the directories walked by a Source
+// created by NewGlobSource
+func (s *Source) Errors() []error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.errs
+}
+
+// addOrigin records that the lines appended from now on are read from
+// the i-th file
+func (s *Source) addOrigin(i int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.origins = append(s.origins, sourceOrigin{
+		filename: s.files[i],
+		start:    s.discarded + len(s.lines),
+	})
+}
+
+// Origin returns the name of the file that the n-th line of the input
+// (0 based, including the lines discarded because of the capacity of
+// the source) was read from. For sources that read a single input,
+// this is the same as Name()
+func (s *Source) Origin(n int) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Find the last file that starts at or before the line
+	i := sort.Search(len(s.origins), func(i int) bool {
+		return s.origins[i].start > n
+	})
+	if i == 0 {
+		return s.name
+	}
+	return s.origins[i-1].filename
+}
+
 // Start starts
 func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 	var sent int
@@ -151,7 +449,9 @@ func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 		defer g.End()
 		defer func() { pdebug.Printf("Source sent %d lines", sent) }()
 	}
-	defer out.SendEndMark("end of input")
+	defer func() {
+		out.SendEndMarkDataCtx(ctx, "end of input", pipeline.EndMarkData{Source: s.Name(), Count: sent})
+	}()
 
 	var resume bool
 	select {
@@ -163,61 +463,85 @@ func (s *Source) Start(ctx context.Context, out pipeline.ChanOutput) {
 	if !resume {
 		// no fancy resume handling needed. just go
 		for _, l := range s.lines {
-			select {
-			case <-ctx.Done():
+			if err := out.SendCtx(ctx, l); err != nil {
 				if pdebug.Enabled {
 					pdebug.Printf("Source: context.Done detected")
 				}
 				return
-			default:
-				out.Send(l)
-				sent++
 			}
+			sent++
 		}
 		return
 	}
 
 	// For the first time we get called, we may possibly be in the
-	// middle of reading a really long input stream. In this case,
-	// we should resume where we left off.
+	// middle of reading a really long input stream, or a stream that
+	// never ends. In this case, we keep sending the lines as they
+	// are read, until the input is closed.
 
 	var prev = 0
-	var setupDone bool
 	for {
-		// This is where we are ready up to
-		upto := s.Size()
-
-		// We bail out if we are done with the setup, and our
-		// buffer has not grown
-		if setupDone && upto == prev {
-			return
+		// Check this before looking at the lines, so that we don't
+		// miss lines that are appended in the mean time
+		var setupDone bool
+		select {
+		case <-s.setupDone:
+			setupDone = true
+		default:
 		}
 
-		for i := prev; i < upto; i++ {
-			select {
-			case <-ctx.Done():
+		lines, upto, updated := s.linesFrom(prev)
+		for _, l := range lines {
+			if err := out.SendCtx(ctx, l); err != nil {
 				if pdebug.Enabled {
 					pdebug.Printf("Source: context.Done detected")
 				}
 				return
-			default:
-				l, _ := s.LineAt(i)
-				out.Send(l)
-				sent++
 			}
+			sent++
 		}
 		// Remember how far we have processed
 		prev = upto
 
-		// Check if we're done with setup
+		if setupDone {
+			// The connection failing is fatal for the pipeline that
+			// was waiting for the rest of the input
+			if s.listener != nil {
+				if errs := s.Errors(); len(errs) > 0 {
+					pipeline.ReportError(ctx, errs[0])
+				}
+			}
+			return
+		}
+
+		// Wait for more lines to come in
 		select {
+		case <-ctx.Done():
+			return
+		case <-updated:
 		case <-s.setupDone:
-			setupDone = true
-		default:
 		}
 	}
 }
 
+// linesFrom returns the lines that were appended after the first n
+// lines, the number of lines that were appended in total, and a channel
+// that is closed when the next line is appended. Lines that have been
+// discarded because of the capacity of the source are skipped
+func (s *Source) linesFrom(n int) ([]line.Line, int, <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	start := n - s.discarded
+	if start < 0 {
+		start = 0
+	}
+	if s.updated == nil {
+		s.updated = make(chan struct{})
+	}
+	return s.lines[start:], s.discarded + len(s.lines), s.updated
+}
+
 // Reset resets the state of the source object so that it
 // is ready to feed the filters
 func (s *Source) Reset() {
@@ -240,6 +564,21 @@ func (s *Source) SetupDone() <-chan struct{} {
 	return s.setupDone
 }
 
+// ASCII returns true once all of the input is read, if none of the
+// lines contains anything other than ASCII characters. The filters can
+// then be told to take the faster path, see filter.WithASCII
+func (s *Source) ASCII() bool {
+	select {
+	case <-s.setupDone:
+	default:
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return !s.nonASCII
+}
+
 func (s *Source) linesInRange(start, end int) []line.Line {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -258,15 +597,58 @@ func (s *Source) Size() int {
 	return bufferSize(s.lines)
 }
 
+// retained returns the lines currently kept in the source, along with
+// the number of lines that were discarded before them because of the
+// capacity of the source. The position of a line in the input is its
+// position in the returned slice plus that number
+func (s *Source) retained() ([]line.Line, int) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lines, s.discarded
+}
+
+// inputIndex returns the position in the input of the line with the
+// given ID, counting the lines discarded because of the capacity of the
+// source. The lines are appended in the order they are read, so their
+// IDs are increasing
+func (s *Source) inputIndex(id uint64) (int, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	i := sort.Search(len(s.lines), func(i int) bool {
+		return s.lines[i].ID() >= id
+	})
+	if i == len(s.lines) || s.lines[i].ID() != id {
+		return 0, false
+	}
+	return s.discarded + i, true
+}
+
+// inputSize returns the number of lines read so far, including those
+// discarded because of the capacity of the source
+func (s *Source) inputSize() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.discarded + len(s.lines)
+}
+
 func (s *Source) Append(l line.Line) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	s.lines = append(s.lines, l)
+	if !s.nonASCII && !util.IsASCII(line.MatchString(l)) {
+		s.nonASCII = true
+	}
 	if s.capacity > 0 && len(s.lines) > s.capacity {
 		diff := len(s.lines) - s.capacity
+		s.lines = s.lines[diff:]
+		s.discarded += diff
+	}
 
-		// Golang's version of array realloc
-		s.lines = s.lines[diff:s.capacity:s.capacity]
+	// Wake up the consumers waiting for more lines in Start
+	if s.updated != nil {
+		close(s.updated)
+		s.updated = nil
 	}
 }
@@ -0,0 +1,203 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"unsafe"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/internal/util"
+	"github.com/pkg/errors"
+)
+
+// mapRecords sends the records of f, from its current offset, to
+// lines, like a scanner that splits the input on delim would. Instead
+// of reading f, it is mapped into memory, and the text of each line
+// points into the mapping rather than being copied, so that large
+// files do not have to fit in the heap. The mapping belongs to s, and
+// is only released once s is replaced, see Peco.retireSource. The
+// number of lines sent is added to scanned.
+//
+// f must not be truncated while peco runs, as the lines past its new
+// end could then no longer be read. If that happens while the records
+// are being sent, the rest of f is skipped and the error is reported
+// like those reading the other files. Afterwards, it crashes peco.
+//
+// Returns false without sending anything if f cannot be mapped, such
+// as when it is not a regular file, in which case it should be read
+// normally
+func (s *Source) mapRecords(ctx context.Context, f *os.File, file int, delim byte, lines chan sourceLine, scanned *int) (mapped bool) {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 || int64(int(fi.Size())) != fi.Size() {
+		return false
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || offset >= fi.Size() {
+		return false
+	}
+	data, err := util.Mmap(f, int(fi.Size()))
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("Source: %s, reading the file instead", err)
+		}
+		return false
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("Source: mapped %s (%d bytes)", f.Name(), fi.Size())
+	}
+	s.mutex.Lock()
+	s.mapped = append(s.mapped, data)
+	s.mutex.Unlock()
+
+	// Reading the pages past the end of a truncated file faults, which
+	// panics instead of crashing while the records are being sent
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(runtime.Error)
+			if !ok {
+				panic(r)
+			}
+			s.addError(errors.Wrapf(err, "failed to read %s, which was truncated", f.Name()))
+			mapped = true
+		}
+	}()
+
+	data = data[offset:]
+	for len(data) > 0 {
+		record := data
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			record, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		// Like bufio.ScanLines, drop the carriage return of "\r\n"
+		if n := len(record); delim == '\n' && n > 0 && record[n-1] == '\r' {
+			record = record[:n-1]
+		}
+
+		select {
+		case <-ctx.Done():
+			return true
+		case lines <- sourceLine{text: mappedString(record), file: file}:
+		}
+		*scanned++
+	}
+	return true
+}
+
+// release unmaps the files that mapRecords mapped into memory. The
+// lines of s must no longer be used, as their text points into the
+// mappings
+func (s *Source) release() {
+	s.mutex.Lock()
+	mapped := s.mapped
+	s.mapped = nil
+	s.mutex.Unlock()
+
+	for _, data := range mapped {
+		if err := util.Munmap(data); err != nil && pdebug.Enabled {
+			pdebug.Printf("Source: %s", err)
+		}
+	}
+}
+
+// useSource returns the current source. It is not released until done
+// is called, even if it is replaced in the mean time
+func (p *Peco) useSource() (*Source, func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s := p.source
+	p.addSourceUser(s, 1)
+	return s, func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.addSourceUser(s, -1)
+	}
+}
+
+// addSourceUser adds n to the number of queries running against s. The
+// mutex must be held
+func (p *Peco) addSourceUser(s *Source, n int) {
+	if p.sourceUsers == nil {
+		p.sourceUsers = make(map[*Source]int)
+	}
+	p.sourceUsers[s] += n
+	if p.sourceUsers[s] <= 0 {
+		delete(p.sourceUsers, s)
+	}
+}
+
+// retireSource marks s, which was replaced, to be released once its
+// lines are no longer used
+func (p *Peco) retireSource(s *Source) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.retiredSources = append(p.retiredSources, s)
+}
+
+// releaseRetiredSources releases the sources passed to retireSource
+// that nothing uses anymore. It is called by the view once it has
+// drawn the screen, so that it is not drawing the lines of a source as
+// it is released
+func (p *Peco) releaseRetiredSources() {
+	p.mutex.Lock()
+	var released []*Source
+	retired := p.retiredSources[:0]
+	for _, s := range p.retiredSources {
+		if p.sourceInUse(s) {
+			retired = append(retired, s)
+			continue
+		}
+		released = append(released, s)
+	}
+	p.retiredSources = retired
+	p.mutex.Unlock()
+
+	for _, s := range released {
+		s.release()
+	}
+}
+
+// sourceInUse returns true if the lines of s may still be used: while
+// the input is being read into s, while queries run against it, or if
+// the lines displayed or those kept by the action palette come from
+// it. The mutex must be held
+func (p *Peco) sourceInUse(s *Source) bool {
+	select {
+	case <-s.SetupDone():
+	default:
+		return true
+	}
+	if s == p.source || p.sourceUsers[s] > 0 || bufferUses(p.currentLineBuffer, s) {
+		return true
+	}
+	return p.palette != nil && (p.palette.source == s || bufferUses(p.palette.lineBuffer, s))
+}
+
+// bufferUses returns true if the lines of b may come from s
+func bufferUses(b Buffer, s *Source) bool {
+	switch b := b.(type) {
+	case nil:
+		return false
+	case *Source:
+		return b == s
+	case *MemoryBuffer:
+		return b.source == s
+	}
+	return true
+}
+
+// mappedString returns the contents of b as a string without copying
+// them. b must never be modified, which holds for read-only mappings
+func mappedString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -1,13 +1,24 @@
 package peco
 
 import (
+	"bufio"
+	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"context"
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -86,3 +97,482 @@ func TestSource(t *testing.T) {
 		}
 	}
 }
+
+func TestScanNullTerminated(t *testing.T) {
+	testValues := []struct {
+		input    string
+		expected []string
+	}{
+		{"foo\x00bar\x00", []string{"foo", "bar"}},
+		{"foo\x00bar", []string{"foo", "bar"}},
+		{"foo\nbar\x00baz\x00", []string{"foo\nbar", "baz"}},
+		{"foo\x00\x00bar", []string{"foo", "", "bar"}},
+		{"", nil},
+	}
+
+	for _, v := range testValues {
+		scanner := bufio.NewScanner(strings.NewReader(v.input))
+		scanner.Split(scanNullTerminated)
+
+		var records []string
+		for scanner.Scan() {
+			records = append(records, scanner.Text())
+		}
+		if !assert.NoError(t, scanner.Err(), "scanning %q should succeed", v.input) {
+			return
+		}
+		assert.Equal(t, v.expected, records, "records scanned from %q", v.input)
+	}
+}
+
+func TestSourceStreaming(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	r, w := io.Pipe()
+	s := NewSource("-", r, ig, 0, false)
+	p := New()
+	p.hub = nullHub{}
+	go s.Setup(ctx, p)
+
+	ch := make(chan interface{})
+	go s.Start(ctx, pipeline.ChanOutput(ch))
+
+	receive := func() interface{} {
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	}
+
+	for _, l := range []string{"foo", "bar"} {
+		io.WriteString(w, l+"\n")
+		v := receive()
+		if !assert.Implements(t, (*line.Line)(nil), v, "should receive a line") {
+			return
+		}
+		if !assert.Equal(t, l, v.(line.Line).DisplayString(), "should receive the line that was written") {
+			return
+		}
+	}
+
+	select {
+	case v := <-ch:
+		t.Errorf("nothing should be sent while the input is open, got %#v", v)
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	w.Close()
+	v := receive()
+	if !assert.Implements(t, (*error)(nil), v, "should receive the end mark") {
+		return
+	}
+	if !assert.True(t, pipeline.IsEndMark(v.(error)), "should receive the end mark") {
+		return
+	}
+	data, ok := pipeline.EndMarkInfo(v.(error))
+	if !assert.True(t, ok, "end mark should carry data") {
+		return
+	}
+	assert.Equal(t, pipeline.EndMarkData{Source: "-", Count: 2}, data, "end mark data should match")
+}
+
+func TestFileSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-source")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	empty := filepath.Join(dir, "empty.txt")
+	missing := filepath.Join(dir, "missing.txt")
+	for filename, content := range map[string]string{a: "foo\nbar\n", b: "baz\n", empty: ""} {
+		if !assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	s := NewFileSource([]string{a, missing, empty, b}, ig, 0, false)
+	p := New()
+	p.hub = nullHub{}
+	go s.Setup(ctx, p)
+	<-s.SetupDone()
+
+	if !assert.Equal(t, []string{"foo", "bar", "baz"}, bufferLines(s), "files should be concatenated") {
+		return
+	}
+	for i, expected := range []string{a, a, b} {
+		if !assert.Equal(t, expected, s.Origin(i), "origin of line %d should match", i) {
+			return
+		}
+	}
+
+	errs := s.Errors()
+	if !assert.Len(t, errs, 1, "missing file should be reported") {
+		return
+	}
+	assert.Contains(t, errs[0].Error(), missing, "error should mention the file")
+}
+
+func TestMmapFiles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "peco-source")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	empty := filepath.Join(dir, "empty.txt")
+	null := filepath.Join(dir, "null.txt")
+	for filename, content := range map[string]string{a: "foo\r\n\nbar\n", b: "baz", empty: "", null: "foo\nbar\x00baz\x00"} {
+		if !assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644), "WriteFile should succeed") {
+			return
+		}
+	}
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	read := func(s *Source, readNull bool) []string {
+		p := New()
+		p.hub = nullHub{}
+		p.config.MmapFiles = true
+		p.readNull = readNull
+		go s.Setup(ctx, p)
+		<-s.SetupDone()
+		return bufferLines(s)
+	}
+
+	s := NewFileSource([]string{a, empty, b}, ig, 0, false)
+	if !assert.Equal(t, []string{"foo", "", "bar", "baz"}, read(s, false), "files should be read as with a scanner") {
+		return
+	}
+	assert.Equal(t, b, s.Origin(3), "origin should match")
+
+	f, err := os.Open(null)
+	if !assert.NoError(t, err, "Open should succeed") {
+		return
+	}
+	assert.Equal(t, []string{"foo\nbar", "baz"}, read(NewSource(null, f, ig, 0, false), true), "NUL terminated records should be read")
+
+	// Inputs that cannot be mapped are read as usual
+	assert.Equal(t, []string{"foo", "bar"}, read(NewSource("-", bytes.NewBufferString("foo\nbar\n"), ig, 0, false), false), "other inputs should be read")
+}
+
+func TestSourceCapacity(t *testing.T) {
+	s := NewSource("-", strings.NewReader(""), nil, 2, false)
+	for i := 0; i < 5; i++ {
+		s.Append(line.NewRaw(uint64(i), strconv.Itoa(i), false))
+	}
+
+	if !assert.Equal(t, 2, s.Size(), "only the last lines should be kept") {
+		return
+	}
+	for i, expected := range []string{"3", "4"} {
+		l, err := s.LineAt(i)
+		if !assert.NoError(t, err, "LineAt(%d) should succeed", i) {
+			return
+		}
+		if !assert.Equal(t, expected, l.DisplayString(), "line %d should match", i) {
+			return
+		}
+	}
+
+	// Lines that have been discarded are skipped
+	lines, upto, _ := s.linesFrom(1)
+	if !assert.Len(t, lines, 2, "remaining lines should be returned") {
+		return
+	}
+	if !assert.Equal(t, 5, upto, "total number of lines should be returned") {
+		return
+	}
+	lines, _, _ = s.linesFrom(4)
+	if !assert.Len(t, lines, 1, "lines after the 4th line should be returned") {
+		return
+	}
+}
+
+func TestSourceASCII(t *testing.T) {
+	s := NewSource("-", strings.NewReader(""), nil, 0, false)
+	s.Append(line.NewRaw(0, "foo bar", false))
+	assert.False(t, s.ASCII(), "input should not be ASCII until all of it is read")
+
+	close(s.setupDone)
+	assert.True(t, s.ASCII(), "input should be ASCII")
+	s.Append(line.NewRaw(1, "café", false))
+	assert.False(t, s.ASCII(), "input should not be ASCII once a line is not")
+}
+
+func TestMemoryBufferCapacity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mb := NewMemoryBuffer()
+	mb.capacity = 2
+	in := make(chan interface{})
+	go mb.Accept(ctx, in, nil)
+	for i := 0; i < 5; i++ {
+		in <- line.NewRaw(uint64(i), strconv.Itoa(i), false)
+	}
+	in <- pipeline.EndMark{}
+	<-mb.Done()
+
+	if !assert.Equal(t, 2, mb.Size(), "only the last lines should be kept") {
+		return
+	}
+	for i, expected := range []string{"3", "4"} {
+		l, err := mb.LineAt(i)
+		if !assert.NoError(t, err, "LineAt(%d) should succeed", i) {
+			return
+		}
+		if !assert.Equal(t, expected, l.DisplayString(), "line %d should match", i) {
+			return
+		}
+	}
+
+	// Ranked lines keep the best lines instead
+	ranked := rankedLines{capacity: 2}
+	for i, score := range []int{1, 3, 4, 2} {
+		ranked.add(line.NewScored(line.NewRaw(uint64(i), strconv.Itoa(score), false), nil, score))
+	}
+	lines := ranked.sorted()
+	if !assert.Len(t, lines, 2, "only the best lines should be kept") {
+		return
+	}
+	assert.Equal(t, "4", lines[0].DisplayString(), "best line should be first")
+	assert.Equal(t, "3", lines[1].DisplayString(), "second best line should be kept")
+}
+
+func TestReversedBuffer(t *testing.T) {
+	mb := NewMemoryBuffer()
+	for i := 0; i < 4; i++ {
+		mb.lines = append(mb.lines, line.NewRaw(uint64(i), strconv.Itoa(i), false))
+	}
+
+	rb := newReversedBuffer(mb)
+	if !assert.Equal(t, []string{"3", "2", "1", "0"}, bufferLines(rb), "lines should be reversed") {
+		return
+	}
+	if _, err := rb.LineAt(4); !assert.Error(t, err, "LineAt should fail past the end") {
+		return
+	}
+
+	var lines []string
+	for _, l := range rb.linesInRange(1, 3) {
+		lines = append(lines, l.DisplayString())
+	}
+	assert.Equal(t, []string{"2", "1"}, lines, "range should be reversed")
+}
+
+func TestMatchColumn(t *testing.T) {
+	run := func(t *testing.T, cfg MatchColumnConfig, query string, expected []string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.Stdin = strings.NewReader("apple\t/fruits/red\nbanana\t/fruits/yellow\nplain\n")
+		out := bytes.Buffer{}
+		p.Stdout = &out
+		p.config.MatchColumn = cfg
+		resultCh := make(chan error)
+		go func() { resultCh <- p.Run(ctx) }()
+
+		<-p.Ready()
+		<-p.source.SetupDone()
+		p.Query().Set(query)
+		p.ExecQuery()
+
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should match")
+				return ""
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		doFinish(ctx, p, termbox.Event{})
+		if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+			return ""
+		}
+		p.PrintResults()
+		return out.String()
+	}
+
+	t.Run("display", func(t *testing.T) {
+		out := run(t, MatchColumnConfig{Delimiter: "\t"}, "yellow", []string{"banana"})
+		assert.Equal(t, "banana\n", out, "displayed text should be output")
+	})
+
+	t.Run("match", func(t *testing.T) {
+		out := run(t, MatchColumnConfig{Delimiter: "\t", Output: MatchColumnOutputMatch}, "red", []string{"apple"})
+		assert.Equal(t, "/fruits/red\n", out, "matched text should be output")
+	})
+
+	t.Run("no delimiter", func(t *testing.T) {
+		out := run(t, MatchColumnConfig{Delimiter: "\t", Output: MatchColumnOutputMatch}, "lain", []string{"plain"})
+		assert.Equal(t, "plain\n", out, "lines without a delimiter should be matched as a whole")
+	})
+
+	t.Run("display is not matched", func(t *testing.T) {
+		out := run(t, MatchColumnConfig{Delimiter: "\t"}, "apple", nil)
+		assert.Equal(t, "", out, "nothing should be output")
+	})
+}
+
+func TestWeightColumn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = nil
+	p.Stdin = strings.NewReader("0.5\tbanana\nbad\tplain\n0.9\tapple\n0.5\tcherry\n")
+	out := bytes.Buffer{}
+	p.Stdout = &out
+	p.config.Sort = SortWeighted
+	p.config.WeightColumn = WeightColumnConfig{Delimiter: "\t"}
+	p.config.OutputTemplate = "{{.Weight}} {{.Line}}"
+	resultCh := make(chan error)
+	go func() { resultCh <- p.Run(ctx) }()
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	// Malformed weights are 0, and lines with the same weight are kept
+	// in the order they were read
+	expected := []string{"apple", "banana", "cherry", "plain"}
+	for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+		select {
+		case <-ctx.Done():
+			assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should be sorted by weight")
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	doAcceptAll(ctx, p, termbox.Event{})
+	if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+		return
+	}
+	p.PrintResults()
+	assert.Equal(t, "0.9 apple\n0.5 banana\n0.5 cherry\n0 plain\n", out.String(), "weights should be available to the template, and not output")
+}
+
+func TestSourceMaxIngestRate(t *testing.T) {
+	// read returns the lines of a source limited to 10 lines per
+	// second, that is given 30 lines, then the end of the input after
+	// half a second
+	read := func(overflow string) ([]string, []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		ig := newIDGen()
+		go ig.Run(ctx)
+
+		r, w := io.Pipe()
+		s := NewSource("-", r, ig, 0, false)
+		p := New()
+		p.hub = nullHub{}
+		p.config.MaxIngestRate = 10
+		p.config.IngestOverflow = overflow
+		go s.Setup(ctx, p)
+
+		var input []string
+		for i := 0; i < 30; i++ {
+			input = append(input, strconv.Itoa(i))
+		}
+		io.WriteString(w, strings.Join(input, "\n")+"\n")
+		time.Sleep(500 * time.Millisecond)
+		before := bufferLines(s)
+		w.Close()
+
+		select {
+		case <-s.SetupDone():
+		case <-ctx.Done():
+			t.Errorf("source should be done once the input ends")
+		}
+		return before, bufferLines(s)
+	}
+
+	before, lines := read("")
+	if !assert.True(t, len(before) >= 10 && len(before) < 30, "lines should be added at the limited rate, got %d", len(before)) {
+		return
+	}
+	if !assert.Len(t, lines, 30, "buffered lines should be added once the input ends") {
+		return
+	}
+	if !assert.Equal(t, "29", lines[29], "lines should be added in order") {
+		return
+	}
+
+	_, lines = read(IngestOverflowDrop)
+	assert.True(t, len(lines) >= 10 && len(lines) < 30, "excess lines should be dropped, got %d", len(lines))
+}
+
+func TestSourceSplitPattern(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	s := NewSource("-", strings.NewReader("foo, bar\nbaz\n,qux,\n"), ig, 0, false)
+	p := New()
+	p.hub = nullHub{}
+	p.splitPattern = regexp.MustCompile(`\s*,\s*`)
+	go s.Setup(ctx, p)
+
+	select {
+	case <-s.SetupDone():
+	case <-ctx.Done():
+		t.Errorf("source should be done once the input ends")
+		return
+	}
+	assert.Equal(t, []string{"foo", "bar", "baz", "qux"}, bufferLines(s), "lines should be split, and the empty ones dropped")
+}
+
+func TestSourceHeaderLines(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ig := newIDGen()
+	go ig.Run(ctx)
+
+	s := NewSource("-", strings.NewReader("NAME SIZE\nfoo 1\nbar 2\n"), ig, 0, false)
+	p := New()
+	p.hub = nullHub{}
+	p.config.HeaderLines = 1
+	go s.Setup(ctx, p)
+
+	select {
+	case <-s.SetupDone():
+	case <-ctx.Done():
+		t.Errorf("source should be done once the input ends")
+		return
+	}
+	assert.Equal(t, []string{"foo 1", "bar 2"}, bufferLines(s), "header lines should not be candidates")
+	headers := s.Headers()
+	if !assert.Len(t, headers, 1, "the first line should be a header") {
+		return
+	}
+	assert.Equal(t, "NAME SIZE", headers[0].DisplayString(), "header line should be kept as it was read")
+}
@@ -0,0 +1,99 @@
+package peco
+
+import (
+	"time"
+)
+
+const (
+	spinnerPositionRight = "right"
+	spinnerPositionLeft  = "left"
+	spinnerPositionNone  = "none"
+
+	defaultSpinnerInterval = 100 * time.Millisecond
+	defaultSpinnerDelay    = 200 * time.Millisecond
+)
+
+// DefaultSpinnerFrames are the frames of the spinner, unless others
+// are configured
+var DefaultSpinnerFrames = []string{"|", "/", "-", `\`}
+
+// IsValidSpinnerPosition checks if the position of the spinner is
+// supported. The empty string selects the default position
+func IsValidSpinnerPosition(v string) bool {
+	switch v {
+	case "", spinnerPositionRight, spinnerPositionLeft, spinnerPositionNone:
+		return true
+	}
+	return false
+}
+
+// NewSpinner creates a new Spinner from the configuration. Returns nil
+// if the spinner is disabled
+func NewSpinner(cfg SpinnerConfig) *Spinner {
+	if cfg.Position == spinnerPositionNone {
+		return nil
+	}
+
+	position := cfg.Position
+	if position == "" {
+		position = spinnerPositionRight
+	}
+
+	frames := cfg.Frames
+	if len(frames) == 0 {
+		frames = DefaultSpinnerFrames
+	}
+
+	interval := time.Duration(cfg.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultSpinnerInterval
+	}
+
+	delay := time.Duration(cfg.Delay) * time.Millisecond
+	if delay <= 0 {
+		delay = defaultSpinnerDelay
+	}
+
+	return &Spinner{
+		frames:   frames,
+		position: position,
+		interval: interval,
+		delay:    delay,
+	}
+}
+
+// Start records that a query started running. The spinner is shown
+// until the channel returned by done is closed. The function is called
+// every time the spinner is drawn, as destinations replace the channel
+// each time they are reset by the pipeline
+func (s *Spinner) Start(done func() <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.done = done
+	s.start = time.Now()
+}
+
+// Frame returns the frame of the spinner to display. The second return
+// value is false if the spinner should not be displayed, because no
+// query is running, or the query has not been running for long enough
+func (s *Spinner) Frame() (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.done == nil {
+		return "", false
+	}
+
+	select {
+	case <-s.done():
+		s.done = nil
+		return "", false
+	default:
+	}
+
+	elapsed := time.Since(s.start) - s.delay
+	if elapsed < 0 {
+		return "", false
+	}
+	return s.frames[int(elapsed/s.interval)%len(s.frames)], true
+}
@@ -0,0 +1,90 @@
+package peco
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSpinner(t *testing.T) {
+	if !assert.Nil(t, NewSpinner(SpinnerConfig{Position: "none"}), "spinner should be disabled") {
+		return
+	}
+
+	s := NewSpinner(SpinnerConfig{})
+	if !assert.NotNil(t, s, "spinner should be enabled by default") {
+		return
+	}
+	assert.Equal(t, DefaultSpinnerFrames, s.frames, "default frames should be used")
+	assert.Equal(t, spinnerPositionRight, s.position, "default position should be used")
+	assert.Equal(t, defaultSpinnerInterval, s.interval, "default interval should be used")
+	assert.Equal(t, defaultSpinnerDelay, s.delay, "default delay should be used")
+
+	assert.True(t, IsValidSpinnerPosition("left"), "left should be valid")
+	assert.False(t, IsValidSpinnerPosition("top"), "top should be invalid")
+}
+
+func TestSpinnerFrame(t *testing.T) {
+	s := NewSpinner(SpinnerConfig{Frames: []string{"a", "b"}, Interval: 10, Delay: 50})
+	if _, ok := s.Frame(); !assert.False(t, ok, "spinner should not be shown before a query starts") {
+		return
+	}
+
+	done := make(chan struct{})
+	s.Start(func() <-chan struct{} { return done })
+	if _, ok := s.Frame(); !assert.False(t, ok, "spinner should not be shown before the delay") {
+		return
+	}
+
+	s.start = time.Now().Add(-75 * time.Millisecond)
+	if frame, ok := s.Frame(); !assert.True(t, ok, "spinner should be shown after the delay") || !assert.Equal(t, "a", frame, "frame should match") {
+		return
+	}
+	s.start = time.Now().Add(-85 * time.Millisecond)
+	if frame, _ := s.Frame(); !assert.Equal(t, "b", frame, "frame should advance") {
+		return
+	}
+
+	close(done)
+	_, ok := s.Frame()
+	assert.False(t, ok, "spinner should be cleared once the query is done")
+}
+
+func TestSpinnerPrompt(t *testing.T) {
+	for _, position := range []string{spinnerPositionRight, spinnerPositionLeft} {
+		t.Run(position, func(t *testing.T) {
+			state := newPeco()
+			if !assert.NoError(t, state.populateFilters(), "populateFilters should succeed") {
+				return
+			}
+			state.spinner = NewSpinner(SpinnerConfig{Frames: []string{"@"}, Position: position})
+			state.spinner.Start(func() <-chan struct{} { return nil })
+			state.spinner.start = time.Now().Add(-time.Second)
+
+			screen := NewDummyScreen()
+			prompt := NewUserPrompt(screen, AnchorTop, 0, "", NewStyleSet())
+			prompt.Draw(state)
+
+			// Find the cell last drawn at each column of the prompt
+			width, _ := screen.Size()
+			cells := make([]rune, width)
+			for _, ev := range screen.interceptor.events["SetCell"] {
+				if ev[1].(int) == 0 {
+					cells[ev[0].(int)] = ev[2].(rune)
+				}
+			}
+
+			x := 0
+			if position == spinnerPositionRight {
+				x = width - len(promptCountMessage(state)) - 2
+			}
+			if !assert.Equal(t, '@', cells[x], "spinner should be drawn at column %d", x) {
+				return
+			}
+			if position == spinnerPositionLeft {
+				assert.Equal(t, ' ', cells[1], "prompt should be hidden by the spinner")
+			}
+		})
+	}
+}
@@ -2,9 +2,9 @@ package peco
 
 import "fmt"
 
-const _PagingRequestType_name = "ToLineAboveToScrollPageDownToLineBelowToScrollPageUpToScrollLeftToScrollRightToLineInPage"
+const _PagingRequestType_name = "ToLineAboveToScrollPageDownToLineBelowToScrollPageUpToScrollLeftToScrollRightToLineInPageToScreenLineToScrollFirstColumnToScrollViewUpToScrollViewDownToFirstLineToLastLineToMiddleLine"
 
-var _PagingRequestType_index = [...]uint8{0, 11, 27, 38, 52, 64, 77, 89}
+var _PagingRequestType_index = [...]uint8{0, 11, 27, 38, 52, 64, 77, 89, 101, 120, 134, 150, 161, 171, 183}
 
 func (i PagingRequestType) String() string {
 	if i < 0 || i >= PagingRequestType(len(_PagingRequestType_index)-1) {
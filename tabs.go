@@ -0,0 +1,82 @@
+package peco
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// expandTabs replaces the tabs in s with spaces, up to the next tab
+// stop, with stops every width columns from the start of s. The
+// returned tabExpansion maps the positions in s to those in the
+// expanded string. s is returned as is if width is 0 or less, or if s
+// does not contain any tabs
+func expandTabs(s string, width int) (string, tabExpansion) {
+	if width <= 0 || strings.IndexByte(s, '\t') < 0 {
+		return s, tabExpansion{}
+	}
+
+	var buf bytes.Buffer
+	pos := make([]int, len(s)+1)
+	var col int
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		for j := 0; j < n; j++ {
+			pos[i+j] = buf.Len() + j
+		}
+		if r == '\t' {
+			spaces := width - col%width
+			buf.WriteString(strings.Repeat(" ", spaces))
+			col += spaces
+		} else {
+			buf.WriteString(s[i : i+n])
+			col += runewidth.RuneWidth(r)
+		}
+		i += n
+	}
+	pos[len(s)] = buf.Len()
+	return buf.String(), tabExpansion{pos: pos}
+}
+
+// expandDisplayTabs expands the tabs of s at the stops given by
+// TabWidth, for the text of the lines to be measured as it is drawn
+func (p *Peco) expandDisplayTabs(s string) string {
+	s, _ = expandTabs(s, p.tabWidth)
+	return s
+}
+
+// offset returns the position in the expanded string of the byte at i
+// in the original string
+func (e tabExpansion) offset(i int) int {
+	if e.pos == nil {
+		return i
+	}
+	return e.pos[i]
+}
+
+// indices returns the matched regions of the expanded line. A region
+// that contains a tab covers all the spaces that it was expanded to
+func (e tabExpansion) indices(matches [][]int) [][]int {
+	if e.pos == nil {
+		return matches
+	}
+	result := make([][]int, len(matches))
+	for i, m := range matches {
+		result[i] = []int{e.offset(m[0]), e.offset(m[1])}
+	}
+	return result
+}
+
+// ansiSpans returns the styled regions of the expanded line
+func (e tabExpansion) ansiSpans(spans []ansiSpan) []ansiSpan {
+	if e.pos == nil {
+		return spans
+	}
+	result := make([]ansiSpan, len(spans))
+	for i, s := range spans {
+		result[i] = ansiSpan{e.offset(s.start), e.offset(s.end), s.fg, s.bg}
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTabs(t *testing.T) {
+	tests := []struct {
+		input    string
+		width    int
+		matches  [][]int
+		expected string
+		indices  [][]int
+	}{
+		{"a\tbb\tc", 4, [][]int{{2, 4}, {5, 6}}, "a   bb  c", [][]int{{4, 6}, {8, 9}}},
+		{"abcd\te", 4, [][]int{{4, 6}}, "abcd    e", [][]int{{4, 9}}},
+		{"\t\tx", 2, [][]int{{2, 3}}, "    x", [][]int{{4, 5}}},
+		// Wide characters take two columns before the tab stop
+		{"あ\tい\tx", 4, [][]int{{4, 7}, {8, 9}}, "あ  い  x", [][]int{{5, 8}, {10, 11}}},
+		// Lines without tabs, and a width of 0, leave the line as is
+		{"abc", 4, [][]int{{0, 1}}, "abc", [][]int{{0, 1}}},
+		{"a\tb", 0, [][]int{{2, 3}}, "a\tb", [][]int{{2, 3}}},
+	}
+
+	for _, test := range tests {
+		s, e := expandTabs(test.input, test.width)
+		if !assert.Equal(t, test.expected, s, "tabs of %q should be expanded every %d columns", test.input, test.width) {
+			return
+		}
+		if !assert.Equal(t, test.indices, e.indices(test.matches), "matches of %q should follow the expansion", test.input) {
+			return
+		}
+	}
+
+	_, e := expandTabs("a\tb", 8)
+	spans := e.ansiSpans([]ansiSpan{{start: 0, end: 3, fg: termbox.ColorRed}})
+	assert.Equal(t, []ansiSpan{{start: 0, end: 9, fg: termbox.ColorRed}}, spans, "colors should follow the expansion")
+}
+
+func TestListAreaTabWidth(t *testing.T) {
+	state := newPeco()
+	state.tabWidth = 8
+	screen := NewDummyScreen()
+	screen.width = 30
+	styles := NewStyleSet()
+	styles.Matched = Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault}
+
+	// "ab" and "2" are matched in the second and the third columns
+	buf := NewMemoryBuffer()
+	buf.lines = append(buf.lines, line.NewMatched(line.NewRaw(0, "x\tab\t12", false), [][]int{{2, 4}, {6, 7}}))
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(1)
+	loc.SetPage(1)
+	loc.SetLineNumber(0)
+
+	screen.interceptor.reset()
+	list := NewListArea(screen, AnchorTop, 0, true, styles)
+	list.Draw(state, nil, 1, &DrawOptions{DisableCache: true})
+
+	drawn := make([]rune, 20)
+	var matched []int
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		x := ev[0].(int)
+		if x < len(drawn) {
+			drawn[x] = ev[2].(rune)
+			if ev[3].(termbox.Attribute) == termbox.ColorCyan {
+				matched = append(matched, x)
+			}
+		}
+	}
+	assert.Equal(t, "x       ab      12  ", string(drawn), "tabs should be expanded to the tab stops")
+	assert.Equal(t, []int{8, 9, 17}, matched, "matches should be highlighted at their expanded columns")
+}
@@ -0,0 +1,67 @@
+package peco
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/peco/peco/line"
+	"github.com/peco/peco/pipeline"
+)
+
+// IsValidLineTransformOutput checks if the output for LineTransform
+// is valid
+func IsValidLineTransformOutput(v string) bool {
+	return v == "" || v == LineTransformOutputTransformed || v == LineTransformOutputOriginal
+}
+
+// newLineTransformer creates the pipeline node that rewrites the lines
+// as configured by cfg
+func newLineTransformer(cfg LineTransformConfig) (*lineTransformer, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &lineTransformer{
+		pattern:        re,
+		replace:        cfg.Replace,
+		outputOriginal: cfg.Output == LineTransformOutputOriginal,
+	}, nil
+}
+
+// transform returns s with the matches of the pattern replaced
+func (t *lineTransformer) transform(s string) string {
+	return t.pattern.ReplaceAllString(s, t.replace)
+}
+
+// Accept rewrites the lines it receives. Lines that have been
+// rewritten already, such as those reused by the incremental filter,
+// are sent as they are
+func (t *lineTransformer) Accept(ctx context.Context, in chan interface{}, out pipeline.ChanOutput) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("lineTransformer.Accept")
+		defer g.End()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-in:
+			if !ok {
+				v = pipeline.EndMark{}
+			}
+			if l, ok := v.(line.Line); ok {
+				if _, ok := l.(*line.Transformed); !ok {
+					v = line.NewTransformed(l, t.transform, t.outputOriginal)
+				}
+			}
+			if err := out.SendCtx(ctx, v); err != nil {
+				return
+			}
+			if err, ok := v.(error); ok && pipeline.IsEndMark(err) {
+				return
+			}
+		}
+	}
+}
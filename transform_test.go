@@ -0,0 +1,89 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineTransform(t *testing.T) {
+	run := func(t *testing.T, cfg LineTransformConfig, query string, expected []string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		p := newPeco()
+		p.Argv = nil
+		p.Stdin = strings.NewReader("/home/alice/src\n/home/bob/doc\n/tmp/alice\n")
+		out := bytes.Buffer{}
+		p.Stdout = &out
+		p.config.LineTransform = cfg
+		resultCh := make(chan error)
+		go func() { resultCh <- p.Run(ctx) }()
+
+		<-p.Ready()
+		<-p.source.SetupDone()
+		if query != "" {
+			p.Query().Set(query)
+			p.ExecQuery()
+		}
+
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				assert.Equal(t, expected, bufferLines(p.CurrentLineBuffer()), "lines should match")
+				return ""
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		doFinish(ctx, p, termbox.Event{})
+		if err := <-resultCh; !assert.True(t, util.IsCollectResultsError(err), "isCollectResultsError") {
+			return ""
+		}
+		p.PrintResults()
+		return out.String()
+	}
+
+	home := LineTransformConfig{Pattern: `^/home/[^/]+/`, Replace: "~/"}
+
+	t.Run("no query", func(t *testing.T) {
+		out := run(t, home, "", []string{"~/src", "~/doc", "/tmp/alice"})
+		assert.Equal(t, "~/src\n", out, "rewritten text should be output")
+	})
+
+	t.Run("transformed", func(t *testing.T) {
+		out := run(t, home, "alice", []string{"/tmp/alice"})
+		assert.Equal(t, "/tmp/alice\n", out, "rewritten text should be matched")
+	})
+
+	t.Run("original", func(t *testing.T) {
+		cfg := home
+		cfg.Output = LineTransformOutputOriginal
+		out := run(t, cfg, "doc", []string{"~/doc"})
+		assert.Equal(t, "/home/bob/doc\n", out, "original line should be output")
+	})
+
+	t.Run("submatches", func(t *testing.T) {
+		out := run(t, LineTransformConfig{Pattern: `^/(\w+)/(\w+)`, Replace: "$2@$1"}, "bob", []string{"bob@home/doc"})
+		assert.Equal(t, "bob@home/doc\n", out, "submatches should be expanded")
+	})
+}
+
+func TestLineTransformConfig(t *testing.T) {
+	var cfg Config
+	cfg.Init()
+	cfg.LineTransform = LineTransformConfig{Pattern: "("}
+	assert.NotEmpty(t, cfg.problems(), "invalid pattern should be reported")
+
+	cfg.LineTransform = LineTransformConfig{Pattern: "foo", Output: "both"}
+	assert.NotEmpty(t, cfg.problems(), "invalid output should be reported")
+
+	cfg.LineTransform = LineTransformConfig{Pattern: "foo", Output: LineTransformOutputOriginal}
+	assert.Empty(t, cfg.problems(), "valid config should pass")
+}
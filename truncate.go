@@ -0,0 +1,122 @@
+package peco
+
+import (
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// IsValidTruncateSide checks if a string is a supported TruncateSide.
+// The empty string selects the default side
+func IsValidTruncateSide(v string) bool {
+	switch v {
+	case "", TruncateRight, TruncateLeft, TruncateMiddle, TruncateNone:
+		return true
+	}
+	return false
+}
+
+// cellWidth returns the number of columns that r takes on the screen.
+// Tabs are counted as the widest they can be drawn
+func cellWidth(r rune) int {
+	if r == '\t' {
+		return 4
+	}
+	return runewidth.RuneWidth(r)
+}
+
+// headLen returns the number of bytes at the beginning of s that fit
+// in width columns
+func headLen(s string, width int) int {
+	var i int
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if width -= cellWidth(r); width < 0 {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// tailLen returns the number of bytes at the end of s that fit in
+// width columns
+func tailLen(s string, width int) int {
+	i := len(s)
+	for i > 0 {
+		r, n := utf8.DecodeLastRuneInString(s[:i])
+		if width -= cellWidth(r); width < 0 {
+			break
+		}
+		i -= n
+	}
+	return len(s) - i
+}
+
+// truncateLine returns how s is shortened to fit in width columns,
+// with the ellipsis on the given side. The second return value is
+// false if s fits, or if it cannot be truncated
+func truncateLine(s string, width int, ellipsis, side string) (truncation, bool) {
+	if side == TruncateNone || width <= 0 || headLen(s, width) == len(s) {
+		return truncation{}, false
+	}
+
+	avail := width - runewidth.StringWidth(ellipsis)
+	if avail <= 0 {
+		return truncation{}, false
+	}
+
+	t := truncation{ellipsis: ellipsis}
+	switch side {
+	case TruncateLeft:
+		t.to = len(s) - tailLen(s, avail)
+	case TruncateMiddle:
+		t.from = headLen(s, avail-avail/2)
+		t.to = len(s) - tailLen(s[t.from:], avail/2)
+	default:
+		t.from = headLen(s, avail)
+		t.to = len(s)
+	}
+	return t, true
+}
+
+// apply returns the truncated s
+func (t truncation) apply(s string) string {
+	return s[:t.from] + t.ellipsis + s[t.to:]
+}
+
+// remap calls fn with the parts of the region of the line from start
+// to end that are left after truncation, at their positions in the
+// truncated line. A region that contains the ellipsis is split in two
+func (t truncation) remap(start, end int, fn func(start, end int)) {
+	shift := len(t.ellipsis) - (t.to - t.from)
+	if start < t.from {
+		fn(start, minOf(end, t.from))
+	}
+	if end > t.to {
+		fn(maxOf(start, t.to)+shift, end+shift)
+	}
+}
+
+// indices returns the matched regions of the truncated line
+func (t truncation) indices(matches [][]int) [][]int {
+	var result [][]int
+	for _, m := range matches {
+		t.remap(m[0], m[1], func(start, end int) {
+			result = append(result, []int{start, end})
+		})
+	}
+	return result
+}
+
+// ansiSpans returns the styled regions of the truncated line. The
+// ellipsis is never styled
+func (t truncation) ansiSpans(spans []ansiSpan) []ansiSpan {
+	var result []ansiSpan
+	for _, s := range spans {
+		t.remap(s.start, s.end, func(start, end int) {
+			result = append(result, ansiSpan{start, end, s.fg, s.bg})
+		})
+	}
+	return result
+}
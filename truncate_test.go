@@ -0,0 +1,85 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/line"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateLine(t *testing.T) {
+	tests := []struct {
+		input     string
+		width     int
+		side      string
+		matches   [][]int
+		truncated bool
+		expected  string
+		indices   [][]int
+	}{
+		{"abc", 5, TruncateRight, nil, false, "abc", nil},
+		{"abcdefghij", 5, TruncateNone, nil, false, "abcdefghij", nil},
+		{"abcdefghij", 5, TruncateRight, [][]int{{3, 6}}, true, "abcd…", [][]int{{3, 4}}},
+		{"abcdefghij", 5, TruncateLeft, [][]int{{0, 2}, {7, 10}}, true, "…ghij", [][]int{{4, 7}}},
+		// Matches that contain the ellipsis are split in two
+		{"abcdefghij", 5, TruncateMiddle, [][]int{{1, 9}}, true, "ab…ij", [][]int{{1, 2}, {5, 6}}},
+		// Wide characters are not cut in half
+		{"あいうえお", 6, TruncateRight, nil, true, "あい…", nil},
+		{"あいうえお", 6, TruncateLeft, nil, true, "…えお", nil},
+		// There is no room for anything but the ellipsis
+		{"abcdefghij", 1, TruncateRight, nil, false, "abcdefghij", nil},
+	}
+
+	for _, test := range tests {
+		tr, ok := truncateLine(test.input, test.width, "…", test.side)
+		if !assert.Equal(t, test.truncated, ok, "%q should be truncated to %d on the %s: %t", test.input, test.width, test.side, test.truncated) {
+			return
+		}
+		if !ok {
+			continue
+		}
+		if !assert.Equal(t, test.expected, tr.apply(test.input), "truncated %q should match", test.input) {
+			return
+		}
+		if !assert.Equal(t, test.indices, tr.indices(test.matches), "matches of %q should follow the truncation", test.input) {
+			return
+		}
+	}
+}
+
+func TestListAreaTruncate(t *testing.T) {
+	state := newPeco()
+	state.ellipsis = "…"
+	state.truncateSide = TruncateLeft
+	screen := NewDummyScreen()
+	screen.width = 10
+	styles := NewStyleSet()
+	styles.Matched = Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault}
+
+	buf := NewMemoryBuffer()
+	buf.lines = append(buf.lines, line.NewMatched(line.NewRaw(0, "/usr/local/bin/peco", false), [][]int{{15, 19}}))
+	state.currentLineBuffer = buf
+
+	loc := state.Location()
+	loc.SetPerPage(1)
+	loc.SetPage(1)
+	loc.SetLineNumber(0)
+
+	screen.interceptor.reset()
+	list := NewListArea(screen, AnchorTop, 0, true, styles)
+	list.Draw(state, nil, 1, &DrawOptions{DisableCache: true})
+
+	var drawn []rune
+	var matched []rune
+	for _, ev := range screen.interceptor.events["SetCell"] {
+		if x := ev[0].(int); x < 10 {
+			drawn = append(drawn, ev[2].(rune))
+			if ev[3].(termbox.Attribute) == termbox.ColorCyan {
+				matched = append(matched, ev[2].(rune))
+			}
+		}
+	}
+	assert.Equal(t, "…/bin/peco", string(drawn), "the beginning of the line should be truncated")
+	assert.Equal(t, "peco", string(matched), "the match should be highlighted in the truncated line")
+}
@@ -0,0 +1,306 @@
+package peco
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/internal/keyseq"
+	"github.com/pkg/errors"
+)
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// ValidateConfigFile checks everything in the config file that peco
+// would otherwise only check, or silently ignore, while starting up
+func ValidateConfigFile(filename string) *ConfigReport {
+	r := &ConfigReport{Filename: filename}
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		r.addError(errors.Wrapf(err, "failed to read file %s", filename))
+		return r
+	}
+
+	var cfg Config
+	if err := cfg.Init(); err != nil {
+		r.addError(errors.Wrap(err, "failed to initialize config"))
+		return r
+	}
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		r.addError(errors.Wrap(err, "failed to decode JSON"))
+		return r
+	}
+
+	for _, key := range unknownConfigKeys("", buf, reflect.TypeOf(cfg)) {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("unknown key %s is ignored", key))
+	}
+
+	for _, err := range cfg.problems() {
+		r.addError(err)
+	}
+	for _, err := range styleProblems(buf) {
+		r.addError(err)
+	}
+	for _, err := range keymapProblems(&cfg) {
+		r.addError(err)
+	}
+	for _, err := range filterProblems(&cfg) {
+		r.addError(err)
+	}
+
+	if v := cfg.PromptCountFormat; v != "" {
+		if _, err := compileTemplate("PromptCountFormat", v, promptCount{}); err != nil {
+			r.addError(errors.Wrap(err, "invalid PromptCountFormat"))
+		}
+	}
+	if v := cfg.OutputTemplate; v != "" {
+		if _, err := compileOutputTemplate(v); err != nil {
+			r.addError(errors.Wrap(err, "invalid OutputTemplate"))
+		}
+	}
+	if _, err := compileEditorLinePattern(cfg.EditorLinePattern); err != nil {
+		r.addError(errors.Wrap(err, "invalid EditorLinePattern"))
+	}
+
+	return r
+}
+
+func (r *ConfigReport) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// WriteTo writes the report in a human readable form
+func (r *ConfigReport) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	write := func(format string, args ...interface{}) error {
+		written, err := fmt.Fprintf(w, format, args...)
+		n += int64(written)
+		return err
+	}
+
+	for _, msg := range r.Errors {
+		if err := write("%s: error: %s\n", r.Filename, msg); err != nil {
+			return n, err
+		}
+	}
+	for _, msg := range r.Warnings {
+		if err := write("%s: warning: %s\n", r.Filename, msg); err != nil {
+			return n, err
+		}
+	}
+
+	if len(r.Errors) == 0 && len(r.Warnings) == 0 {
+		return n, write("%s: OK\n", r.Filename)
+	}
+	return n, write("%s: %d error(s), %d warning(s)\n", r.Filename, len(r.Errors), len(r.Warnings))
+}
+
+// jsonFieldByName finds the field that encoding/json would decode
+// the given key into. Like encoding/json, the match is case insensitive
+func jsonFieldByName(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if v := strings.Split(tag, ",")[0]; v != "" {
+				name = v
+			}
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// sortedKeys returns the keys of m in order, so that the problems are
+// always reported in the same order
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unknownConfigKeys returns the keys in buf that are not decoded into
+// a value of type t, because t has no such field. Nested objects are
+// checked as well, and the keys are returned as paths such as
+// "Style.Matched"
+func unknownConfigKeys(prefix string, buf []byte, t reflect.Type) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types that decode themselves don't have keys to check
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	var m map[string]json.RawMessage
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if err := json.Unmarshal(buf, &m); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	var unknown []string
+	for _, k := range sortedKeys(m) {
+		// The keys of a map can be anything, but its values may
+		// still be objects with known keys
+		elem := t
+		if t.Kind() == reflect.Map {
+			elem = t.Elem()
+		} else if f, ok := jsonFieldByName(t, k); ok {
+			elem = f.Type
+		} else {
+			unknown = append(unknown, prefix+k)
+			continue
+		}
+		unknown = append(unknown, unknownConfigKeys(prefix+k+".", m[k], elem)...)
+	}
+	return unknown
+}
+
+// isStyleName returns true if s is one of the names that can be used
+// in a Style
+func isStyleName(s string) bool {
+	for _, m := range []map[string]termbox.Attribute{stringToFg, stringToBg, stringToFgAttr, stringToBgAttr} {
+		if _, ok := m[s]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// styleProblems reports the names in the Style section of buf that
+// are not colors or attributes. Unknown names are otherwise ignored
+func styleProblems(buf []byte) []error {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &top); err != nil {
+		return nil
+	}
+
+	var errs []error
+	for _, k := range sortedKeys(top) {
+		if !strings.EqualFold(k, "Style") {
+			continue
+		}
+
+		var styles map[string][]string
+		if err := json.Unmarshal(top[k], &styles); err != nil {
+			return append(errs, errors.Wrap(err, "invalid Style"))
+		}
+
+		names := make([]string, 0, len(styles))
+		for name := range styles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, s := range styles[name] {
+				if !isStyleName(s) {
+					errs = append(errs, errors.Errorf("unknown color or attribute %s in Style.%s", s, name))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// keymapProblems reports the key bindings that refer to keys or
+// actions that do not exist. Unlike when peco starts up, all bindings
+// are checked, instead of stopping at the first invalid one
+func keymapProblems(cfg *Config) []error {
+	km := NewKeymap(cfg.Keymap, cfg.Action, cfg.CustomAction)
+
+	keys := make([]string, 0, len(cfg.Keymap))
+	for k := range cfg.Keymap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		if _, err := keyseq.ToKeyList(k); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid key %s in Keymap", k))
+		}
+
+		name := cfg.Keymap[k]
+		if name == "-" {
+			continue
+		}
+		if _, err := km.resolveActionName(name, 0); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid action for %s in Keymap", k))
+		}
+	}
+
+	for _, c := range cfg.ConditionalKeymap {
+		if _, err := keyseq.ToKeyList(c.Key); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid key %s in ConditionalKeymap", c.Key))
+		}
+		if _, err := km.resolveActionName(c.Action, 0); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid action for %s in ConditionalKeymap", c.Key))
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Action))
+	for name := range cfg.Action {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := km.resolveActionName(name, 0); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid combined action %s in Action", name))
+		}
+	}
+	return errs
+}
+
+// filterProblems reports the initial filters that do not exist
+func filterProblems(cfg *Config) []error {
+	p := &Peco{config: *cfg}
+	if err := p.populateFilters(); err != nil {
+		return []error{errors.Wrap(err, "failed to populate filters")}
+	}
+
+	type filterRef struct {
+		key  string
+		name string
+	}
+	refs := []filterRef{
+		{"InitialFilter", cfg.InitialFilter},
+		{"InitialMatcher", cfg.InitialMatcher},
+	}
+	for i, r := range cfg.AutoFilter {
+		refs = append(refs, filterRef{fmt.Sprintf("AutoFilter rule %d", i), r.Filter})
+	}
+
+	var errs []error
+	for _, v := range refs {
+		if v.name == "" {
+			continue
+		}
+		if err := p.filters.SetCurrentByName(v.name); err != nil {
+			errs = append(errs, errors.Errorf("unknown filter %s in %s", v.name, v.key))
+		}
+	}
+	return errs
+}
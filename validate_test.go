@@ -0,0 +1,121 @@
+package peco
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/peco/peco/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTempConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "peco-validate-config-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("failed to write temporary file: %s", err)
+	}
+	return f.Name()
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	valid := writeTempConfig(t, `{
+	"Keymap": {
+		"C-j": "peco.Finish",
+		"C-x,C-c": "my.Action",
+		"C-d": "-"
+	},
+	"Action": {
+		"my.Action": ["peco.SelectAll", "peco.Finish"]
+	},
+	"CustomFilter": {
+		"MyGrep": {"Cmd": "grep", "Args": ["$QUERY"]}
+	},
+	"InitialFilter": "MyGrep",
+	"Style": {
+		"Matched": ["cyan", "bold", "on_red"]
+	}
+}`)
+	defer os.Remove(valid)
+
+	r := ValidateConfigFile(valid)
+	if !assert.Empty(t, r.Errors, "valid config should have no errors") {
+		return
+	}
+	if !assert.Empty(t, r.Warnings, "valid config should have no warnings") {
+		return
+	}
+
+	invalid := writeTempConfig(t, `{
+	"Keymap": {
+		"C-j": "peco.NoSuchAction",
+		"C-nosuchkey": "peco.Finish"
+	},
+	"Layout": "sideways",
+	"InitialFilter": "NoSuchFilter",
+	"Style": {
+		"Matched": ["purple"],
+		"NoSuchStyle": ["red"]
+	},
+	"Preview": {"command": "cat", "colour": "red"},
+	"NoSuchKey": true
+}`)
+	defer os.Remove(invalid)
+
+	r = ValidateConfigFile(invalid)
+	expected := []string{
+		"invalid layout type: sideways",
+		"unknown color or attribute purple in Style.Matched",
+		"invalid action for C-j in Keymap: could not resolve peco.NoSuchAction: no such action",
+		"unknown filter NoSuchFilter in InitialFilter",
+	}
+	if !assert.Len(t, r.Errors, len(expected)+1, "all problems should be reported: %v", r.Errors) {
+		return
+	}
+	for _, msg := range expected {
+		if !assert.Contains(t, r.Errors, msg, "errors should contain %q", msg) {
+			return
+		}
+	}
+	if !assert.Equal(t, []string{
+		"unknown key NoSuchKey is ignored",
+		"unknown key Preview.colour is ignored",
+		"unknown key Style.NoSuchStyle is ignored",
+	}, r.Warnings, "unknown keys should be reported") {
+		return
+	}
+
+	broken := writeTempConfig(t, `{"Keymap": `)
+	defer os.Remove(broken)
+	r = ValidateConfigFile(broken)
+	if !assert.Len(t, r.Errors, 1, "broken JSON should be reported") {
+		return
+	}
+}
+
+func TestValidateConfigOption(t *testing.T) {
+	filename := writeTempConfig(t, `{"Layout": "sideways"}`)
+	defer os.Remove(filename)
+
+	var out bytes.Buffer
+	p := newPeco()
+	p.Argv = []string{"--validate-config", filename}
+	p.Stdout = &out
+
+	err := p.Setup()
+	if !assert.True(t, util.IsIgnorableError(err), "error should be ignorable") {
+		return
+	}
+	st, ok := util.GetExitStatus(err)
+	if !assert.True(t, ok, "error should have an exit status") {
+		return
+	}
+	if !assert.Equal(t, ExitStatusError, st, "exit status should match") {
+		return
+	}
+	assert.Equal(t, filename+": error: invalid layout type: sideways\n"+filename+": 1 error(s), 0 warning(s)\n", out.String(), "report should be printed")
+}
@@ -10,6 +10,7 @@ import (
 type statusMsgReq interface {
 	Message() string
 	Delay() time.Duration
+	Level() int
 }
 
 func (prt PagingRequestType) Type() PagingRequestType {
@@ -24,6 +25,23 @@ func (jlr JumpToLineRequest) Line() int {
 	return int(jlr)
 }
 
+func (jslr JumpToScreenLineRequest) Type() PagingRequestType {
+	return ToScreenLine
+}
+
+// Row returns the row of the screen that was requested
+func (jslr JumpToScreenLineRequest) Row() int {
+	return int(jslr)
+}
+
+func (rsr replaceSourceRequest) Type() PagingRequestType {
+	return ToFirstLine
+}
+
+func (iir initialIndexRequest) Type() PagingRequestType {
+	return ToLineInPage
+}
+
 func NewView(state *Peco) *View {
 	var layout Layout
 	switch state.LayoutType() {
@@ -41,13 +59,32 @@ func NewView(state *Peco) *View {
 func (v *View) Loop(ctx context.Context, cancel func()) error {
 	defer cancel()
 
+	// The spinner is animated by redrawing the prompt on every frame.
+	// The prompt is drawn once more after the spinner disappears, so
+	// that it is cleared
+	var spinnerCh <-chan time.Time
+	var spinnerShown bool
+	if s := v.state.spinner; s != nil {
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+		spinnerCh = t.C
+	}
+
 	h := v.state.Hub()
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-spinnerCh:
+			_, shown := v.state.spinner.Frame()
+			if shown || spinnerShown {
+				v.layout.DrawPrompt(v.state)
+			}
+			spinnerShown = shown
 		case r := <-h.StatusMsgCh():
 			v.printStatus(r, r.Data().(statusMsgReq))
+		case <-v.layout.StatusTimer():
+			v.layout.ExpireStatus()
 		case r := <-h.PagingCh():
 			v.movePage(r, r.Data().(PagingRequest))
 		case r := <-h.DrawCh():
@@ -71,7 +108,7 @@ func (v *View) Loop(ctx context.Context, cancel func()) error {
 
 func (v *View) printStatus(p hub.Payload, r statusMsgReq) {
 	defer p.Done()
-	v.layout.PrintStatus(r.Message(), r.Delay())
+	v.layout.ShowStatus(r.Message(), r.Delay(), MessageStyle(r.Level()))
 }
 
 func (v *View) purgeDisplayCache(p hub.Payload) {
@@ -84,6 +121,7 @@ func (v *View) drawScreen(p hub.Payload, options *DrawOptions) {
 	defer p.Done()
 
 	v.layout.DrawScreen(v.state, options)
+	v.state.releaseRetiredSources()
 }
 
 func (v *View) drawPrompt(p hub.Payload) {
@@ -95,7 +133,22 @@ func (v *View) drawPrompt(p hub.Payload) {
 func (v *View) movePage(p hub.Payload, r PagingRequest) {
 	defer p.Done()
 
+	if rsr, ok := r.(replaceSourceRequest); ok {
+		v.state.replaceSource(rsr)
+		v.layout.MovePage(v.state, r)
+		v.layout.DrawScreen(v.state, &DrawOptions{DisableCache: true})
+		v.state.releaseRetiredSources()
+		return
+	}
+
+	if iir, ok := r.(initialIndexRequest); ok {
+		v.state.Location().SetLineNumber(int(iir))
+		v.layout.DrawScreen(v.state, nil)
+		return
+	}
+
 	if v.layout.MovePage(v.state, r) {
 		v.layout.DrawScreen(v.state, nil)
 	}
+	v.state.extendLazyFilter()
 }
@@ -0,0 +1,41 @@
+package peco
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nsf/termbox-go"
+)
+
+// WholeWord returns true if the queries only match entire words. See
+// filter.WithWholeWord
+func (p *Peco) WholeWord() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.wholeWord
+}
+
+func (p *Peco) SetWholeWord(b bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.wholeWord = b
+}
+
+// doToggleWholeWord switches between matching entire words only and
+// matching anywhere in the lines, and runs the current query again
+func doToggleWholeWord(ctx context.Context, state *Peco, _ termbox.Event) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("doToggleWholeWord")
+		defer g.End()
+	}
+
+	b := !state.WholeWord()
+	state.SetWholeWord(b)
+	if b {
+		state.Hub().SendStatusMsgAndClear("Match whole words", time.Second)
+	} else {
+		state.Hub().SendStatusMsgAndClear("Match anywhere", time.Second)
+	}
+	state.ExecQuery()
+}
@@ -0,0 +1,55 @@
+package peco
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToggleWholeWord(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPeco()
+	p.Argv = []string{"peco", "--query", "cat"}
+	p.Stdin = bytes.NewBufferString("the cat\ncategory\n")
+	p.config.IncrementalFilter = true
+	go p.Run(ctx)
+
+	<-p.Ready()
+	<-p.source.SetupDone()
+
+	waitLines := func(expected []string) bool {
+		for !assert.ObjectsAreEqual(expected, bufferLines(p.CurrentLineBuffer())) {
+			select {
+			case <-ctx.Done():
+				t.Errorf("timeout reached while waiting for %v, got %v", expected, bufferLines(p.CurrentLineBuffer()))
+				return false
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return true
+	}
+
+	if !waitLines([]string{"the cat", "category"}) {
+		return
+	}
+
+	doToggleWholeWord(ctx, p, termbox.Event{})
+	if !assert.True(t, p.WholeWord(), "whole word matching should be enabled") {
+		return
+	}
+	if !waitLines([]string{"the cat"}) {
+		return
+	}
+
+	doToggleWholeWord(ctx, p, termbox.Event{})
+	if !assert.False(t, p.WholeWord(), "whole word matching should be disabled") {
+		return
+	}
+	waitLines([]string{"the cat", "category"})
+}